@@ -0,0 +1,85 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/plansummary"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// apply tags - --tag-on-apply leaves an annotated deploy/<env>/<time> tag at HEAD after every successful apply, so what was live when can be read back from git
+
+// tagRemote is where --push-tags pushes the tag
+
+const tagRemote = "origin"
+
+// tagSteps is the tag made after the apply, push sends it to tagRemote
+
+type tagSteps struct {
+	enabled bool
+	push    bool
+}
+
+func applyTagName(environment string, at time.Time) string {
+	return "deploy/" + environment + "/" + at.UTC().Format("2006-01-02T15-04")
+}
+
+// tagApply tags HEAD with what the applied plan did and the tfvars it was applied with. The apply has already happened, so nothing here fails it, and a working tree with uncommitted changes isn't tagged since HEAD isn't what was applied
+
+func tagApply(ctx context.Context, a *app, steps applySteps, opts tfexec.ApplyOptions) {
+	dir := cmp.Or(opts.Chdir, ".")
+	inRepo, dirty := gitinfo.WorkTree(ctx, dir)
+	switch {
+	case !inRepo:
+		a.out.Verbosef("%s is not in a git repository, the apply isn't tagged\n", dir)
+		return
+	case dirty:
+		a.out.Warnf("The working tree has uncommitted changes, so HEAD isn't what was applied to %s - not tagging it", steps.env)
+		return
+	}
+
+	now := time.Now().UTC()
+	name := applyTagName(steps.env, now)
+	message := []string{fmt.Sprintf("Applied to %s at %s", steps.env, now.Format(time.RFC3339))}
+	if summary, err := appliedSummary(ctx, a, opts); err != nil {
+		a.out.Warnf("Could not read the plan's changes for the tag: %v", err)
+	} else {
+		message = append(message, summary.String())
+	}
+	if opts.VarFile != "" {
+		if sum, err := storage.FileChecksum(opts.VarFile); err == nil {
+			message = append(message, "tfvars sha256: "+sum)
+		}
+	}
+	if steps.planKey != "" {
+		message = append(message, "Stored plan: "+steps.planKey)
+	}
+	if err := gitinfo.Tag(ctx, dir, name, strings.Join(message, "\n")); err != nil {
+		a.out.Warnf("Could not tag the apply: %v", err)
+		return
+	}
+	a.out.Event("apply-tag", map[string]any{"environment": steps.env, "tag": name, "pushed": steps.tag.push})
+	a.out.Successf("Tagged HEAD as %s", name)
+	if !steps.tag.push {
+		return
+	}
+	if err := gitinfo.PushTag(ctx, dir, tagRemote, name); err != nil {
+		a.out.Warnf("Could not push %s to %s: %v", name, tagRemote, err)
+		return
+	}
+	a.out.Successf("Pushed %s to %s", name, tagRemote)
+}
+
+func appliedSummary(ctx context.Context, a *app, opts tfexec.ApplyOptions) (plansummary.Summary, error) {
+	data, err := tfexec.Show(ctx, runner, tfexec.ShowOptions{Chdir: opts.Chdir, PlanFile: opts.PlanFile, JSON: true}, a.terraformOutput())
+	if err != nil {
+		return plansummary.Summary{}, err
+	}
+	return plansummary.Parse(data)
+}
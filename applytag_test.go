@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestApplyTagName(t *testing.T) {
+	if got := applyTagName("prod", time.Date(2024, 5, 30, 14, 22, 59, 0, time.UTC)); got != "deploy/prod/2024-05-30T14-22" {
+		t.Errorf("applyTagName() = %q", got)
+	}
+}
+
+func TestTagOnApply(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	rec := &tfexec.RecordingRunner{OutputFor: func(args []string) string {
+		if slices.Contains(args, "show") {
+			return planB
+		}
+		return ""
+	}}
+	useRunner(t, rec)
+	inTempDir(t)
+	dir, _ := os.Getwd()
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+	os.WriteFile("main.tf", nil, 0o644)
+	os.WriteFile("dev.tfvars", []byte("a = 1\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return string(out)
+	}
+	git("init", "-q", "-b", "main")
+	git("config", "user.email", "test@example.com")
+	git("config", "user.name", "test")
+	git("add", "main.tf")
+	git("commit", "-q", "-m", "main")
+
+	var out bytes.Buffer
+	if err := runWithUI([]string{"apply", "dev", "--tag-on-apply"}, &ui{stdout: &out, stderr: io.Discard}); err != nil {
+		t.Fatalf("apply --tag-on-apply: %v", err)
+	}
+	tags := git("tag", "-l", "deploy/dev/*")
+	if !strings.HasPrefix(tags, "deploy/dev/") {
+		t.Fatalf("tags = %q\n%s", tags, out.String())
+	}
+	message := git("tag", "-l", "--format=%(contents)", strings.TrimSpace(tags))
+	for _, want := range []string{"Applied to dev at", "Plan: 2 to add, 0 to change, 1 to destroy.", "tfvars sha256: "} {
+		if !strings.Contains(message, want) {
+			t.Errorf("tag message %q is missing %q", message, want)
+		}
+	}
+	if args := rec.Args(); args[0][0] != "plan" {
+		t.Errorf("terraform ran %q, want a plan for the tag's counts first", args)
+	}
+
+	// a dirty tree and a failed push only warn, the apply itself succeeded
+	git("tag", "-d", strings.TrimSpace(tags))
+	os.WriteFile("main.tf", []byte("# changed\n"), 0o644)
+	out.Reset()
+	if err := runWithUI([]string{"apply", "dev", "--tag-on-apply"}, &ui{stdout: &out, stderr: io.Discard}); err != nil || !strings.Contains(out.String(), "uncommitted changes") {
+		t.Errorf("apply --tag-on-apply with a dirty tree: %v\n%s", err, out.String())
+	}
+	if tags := git("tag", "-l"); tags != "" {
+		t.Errorf("a dirty tree was tagged: %q", tags)
+	}
+	git("checkout", "--", "main.tf")
+	out.Reset()
+	if err := runWithUI([]string{"apply", "dev", "--tag-on-apply", "--push-tags"}, &ui{stdout: &out, stderr: io.Discard}); err != nil || !strings.Contains(out.String(), "Could not push") {
+		t.Errorf("apply --push-tags without a remote: %v\n%s", err, out.String())
+	}
+
+	if err := run([]string{"apply", "dev", "--push-tags"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("--push-tags without tagging: %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// Plan approvals - approve writes a marker per approver next to a stored plan with its hash, apply --require-approval only applies a plan with enough approvals whose hash still matches
+
+const (
+	statusValid = "VALID"
+	statusStale = "STALE"
+)
+
+// planApproval is one approver's marker, stored under <plan key>.approvals/
+
+type planApproval struct {
+	SHA256     string    `json:"sha256"`
+	Approver   string    `json:"approver"`
+	ApprovedAt time.Time `json:"approved_at"`
+}
+
+func approvalsPrefix(planKey string) string {
+	return planKey + ".approvals/"
+}
+
+// approvalKey is keyed by the approver so approving twice replaces the first marker
+
+func approvalKey(planKey, approver string) string {
+	return approvalsPrefix(planKey) + url.PathEscape(approver) + ".json"
+}
+
+// callerIdentity gives back the ARN of whoever the AWS credentials belong to - it is a variable so the tests don't need STS
+
+var callerIdentity = stsCallerARN
+
+func stsCallerARN(ctx context.Context, s settings) (string, error) {
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return "", err
+	}
+	return awsconfig.CallerARN(ctx, cfg)
+}
+
+// approvalsRequired is how many approvals apply needs for the environment, 0 means none. --require-approval needs at least one and --no-approval turns it all off
+
+func approvalsRequired(s settings, environment string, requireFlag, noApproval bool) int {
+	if noApproval {
+		return 0
+	}
+	env := s.Terraform[environment]
+	if requireFlag || env.RequireApproval {
+		return max(env.RequiredApprovals, 1)
+	}
+	return env.RequiredApprovals
+}
+
+func approveCommand() *command {
+	return &command{
+		name:    "approve",
+		args:    "<env> <plan-key|latest>",
+		summary: "Approve a plan stored with plan --store-plan so apply --require-approval will apply it.",
+		examples: []string{
+			"tfmanage approve prod latest",
+			"tfmanage approve prod 20240501T130405Z-0123abc.tfplan",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(ctx context.Context, a *app, args []string) error {
+				if err := a.checkEnvironment(args[0]); err != nil {
+					return err
+				}
+				s, store, err := a.planStore(ctx)
+				if err != nil {
+					return err
+				}
+				key, err := environmentPlanKey(ctx, s, store, args[0], args[1])
+				if err != nil {
+					return err
+				}
+				return approvePlan(ctx, a, s, store, key)
+			}
+		},
+	}
+}
+
+func approvalsCommand() *command {
+	return &command{
+		name:    "approvals",
+		args:    "<env> <plan-key|latest>",
+		summary: "List who has approved a stored plan and whether their approval still matches it.",
+		examples: []string{
+			"tfmanage approvals prod latest",
+			"tfmanage approvals prod 20240501T130405Z-0123abc.tfplan --output json",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(ctx context.Context, a *app, args []string) error {
+				if err := a.checkEnvironment(args[0]); err != nil {
+					return err
+				}
+				s, store, err := a.planStore(ctx)
+				if err != nil {
+					return err
+				}
+				key, err := environmentPlanKey(ctx, s, store, args[0], args[1])
+				if err != nil {
+					return err
+				}
+				return listApprovals(ctx, a, s, store, args[0], key)
+			}
+		},
+	}
+}
+
+// environmentPlanKey resolves the plan argument and makes sure the plan was made for the environment, an approved dev plan must never end up applied to prod
+
+func environmentPlanKey(ctx context.Context, s settings, store storage.Backend, environment, arg string) (string, error) {
+	key, err := resolvePlanKey(ctx, s, store, environment, arg)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(key, storage.Key(s.S3Path, planStorePrefix+"/"+environment+"/")) {
+		return "", usageError("%s is not a stored plan for %s", key, environment)
+	}
+	return key, nil
+}
+
+// verifiedPlan is a stored plan downloaded to file, with its hash and sidecar
+
+type verifiedPlan struct {
+	file     string
+	sha256   string
+	artifact planArtifact
+}
+
+// verifyStoredPlan downloads the plan and hashes it, a plan that no longer matches the hash its sidecar recorded is refused. cleanup removes the download
+
+func verifyStoredPlan(ctx context.Context, a *app, s settings, store storage.Backend, key string) (verifiedPlan, func(), error) {
+	planFile, cleanup, err := downloadPlan(ctx, a, s, store, key)
+	if err != nil {
+		return verifiedPlan{}, nil, err
+	}
+	plan := verifiedPlan{file: planFile, artifact: readArtifact(ctx, store, key)}
+	if err := checkPlanEnvironment(s, key, plan.artifact); err != nil {
+		cleanup()
+		return verifiedPlan{}, nil, err
+	}
+	if plan.sha256, err = storage.FileChecksum(planFile); err != nil {
+		cleanup()
+		return verifiedPlan{}, nil, err
+	}
+	if recorded := plan.artifact.SHA256; recorded != "" && recorded != plan.sha256 {
+		cleanup()
+		return verifiedPlan{}, nil, withCode(exitCheck, fmt.Errorf("the plan's sha256 is %s but %s was recorded when it was stored, it has been changed since", plan.sha256, recorded))
+	}
+	return plan, cleanup, nil
+}
+
+// readApprovals gives back every approval of the plan, oldest first
+
+func readApprovals(ctx context.Context, store storage.Backend, key string) ([]planApproval, error) {
+	objects, err := store.List(ctx, approvalsPrefix(key))
+	if err != nil {
+		return nil, err
+	}
+	var approvals []planApproval
+	for _, o := range objects {
+		data, err := storage.GetBytes(ctx, store, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		var approval planApproval
+		if err := json.Unmarshal(data, &approval); err != nil {
+			return nil, configError("failed to read the approval %s: %w", o.Key, err)
+		}
+		approvals = append(approvals, approval)
+	}
+	slices.SortFunc(approvals, func(x, y planApproval) int { return x.ApprovedAt.Compare(y.ApprovedAt) })
+	return approvals, nil
+}
+
+func approvePlan(ctx context.Context, a *app, s settings, store storage.Backend, key string) error {
+	plan, cleanup, err := verifyStoredPlan(ctx, a, s, store, key)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	approver, err := callerIdentity(ctx, s)
+	if err != nil {
+		return err
+	}
+	approval := planApproval{SHA256: plan.sha256, Approver: approver, ApprovedAt: time.Now().UTC()}
+	data, err := json.MarshalIndent(approval, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the approval: %w", err)
+	}
+	if _, err := storage.PutBytes(ctx, store, approvalKey(key, approver), data); err != nil {
+		return err
+	}
+	a.out.Event("plan-approve", map[string]any{"bucket": s.S3Bucket, "key": key, "sha256": plan.sha256, "approver": approver})
+	a.out.Successf("Approved s3://%s/%s as %s", s.S3Bucket, key, approver)
+	return nil
+}
+
+func listApprovals(ctx context.Context, a *app, s settings, store storage.Backend, environment, key string) error {
+	plan, cleanup, err := verifyStoredPlan(ctx, a, s, store, key)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	approvals, err := readApprovals(ctx, store, key)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	valid := 0
+	for _, approval := range approvals {
+		status := statusStale
+		if approval.SHA256 == plan.sha256 {
+			status = statusValid
+			valid++
+		}
+		a.out.Event("approval", map[string]any{"key": key, "approver": approval.Approver, "approved_at": approval.ApprovedAt, "status": status})
+		rows = append(rows, []string{approval.Approver, approval.ApprovedAt.Format(time.RFC3339), status})
+	}
+	if a.out.json {
+		return nil
+	}
+	if len(rows) == 0 {
+		a.out.Printf("%s has no approvals\n", key)
+		return nil
+	}
+	a.out.Table(a.out.humanOut(), []string{"APPROVER", "APPROVED AT", "STATUS"}, rows, func(col int, cell string) string {
+		if col == 2 {
+			return a.out.statusColor(cell)
+		}
+		return cell
+	})
+	a.out.Printf("\n%d valid approval(s), %s needs %d\n", valid, environment, approvalsRequired(s, environment, true, false))
+	return nil
+}
+
+// isStoredPlan tells apply --plan arguments that name a stored plan from local plan files. A local file always wins, and a bare name has to look like the name of a stored plan so a mistyped local file isn't looked for in the bucket
+
+func isStoredPlan(s settings, arg string) bool {
+	if arg == "" {
+		return false
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return false
+	}
+	_, stored := planKey(s, arg)
+	_, named := planNameTime(arg)
+	return stored || arg == "latest" || (named && !strings.ContainsAny(arg, `/\`))
+}
+
+// planCreatedAt is when a stored plan was made, from its sidecar, the time in its name or when it was stored, in that order
+
+func planCreatedAt(ctx context.Context, store storage.Backend, key string, artifact planArtifact) (time.Time, error) {
+	if !artifact.CreatedAt.IsZero() {
+		return artifact.CreatedAt, nil
+	}
+	if t, ok := planNameTime(key); ok {
+		return t, nil
+	}
+	info, err := store.Head(ctx, key)
+	return info.LastModified, err
+}
+
+// storedApplyPlan resolves the stored plan apply --plan names and downloads it. The plan has to be made for the environment, unchanged since, made with the tfvars apply is about to use and no older than maxAge, 0 turns the age check off. cleanup removes the download
+
+func storedApplyPlan(ctx context.Context, a *app, s settings, store storage.Backend, environment, arg, varFile string, maxAge time.Duration) (verifiedPlan, string, func(), error) {
+	key, err := environmentPlanKey(ctx, s, store, environment, arg)
+	if err != nil {
+		return verifiedPlan{}, "", nil, err
+	}
+	a.out.Event("plan-resolve", map[string]any{"environment": environment, "plan": arg, "bucket": s.S3Bucket, "key": key})
+	a.out.Printf("Using the stored plan s3://%s/%s\n", s.S3Bucket, key)
+	plan, cleanup, err := verifyStoredPlan(ctx, a, s, store, key)
+	if err != nil {
+		return verifiedPlan{}, "", nil, err
+	}
+	fail := func(err error) (verifiedPlan, string, func(), error) {
+		cleanup()
+		return verifiedPlan{}, "", nil, err
+	}
+
+	if maxAge > 0 {
+		createdAt, err := planCreatedAt(ctx, store, key, plan.artifact)
+		if err != nil {
+			return fail(err)
+		}
+		if age := time.Since(createdAt); age > maxAge {
+			return fail(withCode(exitCheck, fmt.Errorf("%s was made %s ago, longer than --max-plan-age %s - run 'tfmanage plan %s <plan-file> --store-plan' again, or pass a longer --max-plan-age", key, age.Round(time.Minute), maxAge, environment)))
+		}
+	}
+	if recorded := plan.artifact.TFVarsSHA256; recorded != "" {
+		current, err := storage.FileChecksum(varFile)
+		if err != nil {
+			return fail(err)
+		}
+		if current != recorded {
+			return fail(withCode(exitCheck, fmt.Errorf("%s has changed since the plan was taken, which invalidates the plan and its approvals - plan again and apply the new plan", varFile)))
+		}
+	}
+	return plan, key, cleanup, nil
+}
+
+// approvedPlan downloads a stored plan for apply like storedApplyPlan and counts the approvals that match its hash. Approvals by whoever runs the apply don't count, and all of them go stale when the tfvars changed after the plan was taken. It gives back the download and the plan's key, cleanup removes the download
+
+func approvedPlan(ctx context.Context, a *app, environment, arg, varFile string, required int, maxAge time.Duration) (string, string, func(), error) {
+	if arg == "" {
+		return "", "", nil, usageError("applying %s needs an approved stored plan, pass it with --plan <plan-key|latest>", environment)
+	}
+	s, store, err := a.planStore(ctx)
+	if err != nil {
+		return "", "", nil, err
+	}
+	plan, key, cleanup, err := storedApplyPlan(ctx, a, s, store, environment, arg, varFile, maxAge)
+	if err != nil {
+		return "", "", nil, err
+	}
+	fail := func(err error) (string, string, func(), error) {
+		cleanup()
+		return "", "", nil, err
+	}
+
+	approvals, err := readApprovals(ctx, store, key)
+	if err != nil {
+		return fail(err)
+	}
+	if len(approvals) == 0 {
+		return fail(withCode(exitCheck, fmt.Errorf("%s has not been approved, run 'tfmanage approve %s %s' first", key, environment, arg)))
+	}
+	applier, err := callerIdentity(ctx, s)
+	if err != nil {
+		return fail(err)
+	}
+	var approvers []string
+	stale, own := 0, false
+	for _, approval := range approvals {
+		switch {
+		case approval.SHA256 != plan.sha256:
+			stale++
+		case approval.Approver == applier:
+			own = true
+		case !slices.Contains(approvers, approval.Approver):
+			approvers = append(approvers, approval.Approver)
+		}
+	}
+	if len(approvers) < required {
+		msg := fmt.Sprintf("%s has %d of the %d approval(s) it needs", key, len(approvers), required)
+		if own {
+			msg += ", your own approval doesn't count"
+		}
+		if stale > 0 {
+			msg += fmt.Sprintf(", %d approval(s) are for a different plan hash so the plan has to be approved again", stale)
+		}
+		return fail(withCode(exitCheck, errors.New(msg)))
+	}
+	a.out.Event("plan-approval", map[string]any{"environment": environment, "key": key, "sha256": plan.sha256, "approvers": approvers})
+	a.out.Successf("%s was approved by %s", key, strings.Join(approvers, ", "))
+	return plan.file, key, cleanup, nil
+}
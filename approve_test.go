@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// planBytesSHA is the sha256 of "plan bytes"
+
+const planBytesSHA = "38d0287182a74a6879e277b5907da1f0807d24ed2cb56911517de9f9863d5a6a"
+
+const (
+	reviewerARN = "arn:aws:iam::123456789012:user/reviewer"
+	secondARN   = "arn:aws:iam::123456789012:user/second"
+	deployerARN = "arn:aws:sts::123456789012:assumed-role/deploy/session"
+)
+
+// withCaller makes callerIdentity give back whatever the returned string is set to
+
+func withCaller(t *testing.T, arn string) *string {
+	t.Helper()
+	caller := arn
+	swap(t, &callerIdentity, func(context.Context, settings) (string, error) { return caller, nil })
+	return &caller
+}
+
+func storeTestPlan(t *testing.T, store *storage.MemoryStore, key, content string) {
+	t.Helper()
+	ctx := context.Background()
+	store.Put(ctx, storage.PutInput{Key: key, Body: strings.NewReader(content)})
+	store.Put(ctx, storage.PutInput{Key: key + ".json", Body: strings.NewReader(`{"environment":"prod","sha256":"` + planBytesSHA + `"}`)})
+}
+
+func TestApprovePlan(t *testing.T) {
+	_, store := withPlanStore(t)
+	withCaller(t, reviewerARN)
+	storeTestPlan(t, store, "team/plans/prod/p.tfplan", "plan bytes")
+
+	if err := run([]string{"approve", "prod", "p.tfplan"}); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if err := run([]string{"approve", "prod", "p.tfplan"}); err != nil {
+		t.Fatalf("approving twice: %v", err)
+	}
+	objects, _ := store.List(context.Background(), "team/plans/prod/p.tfplan.approvals/")
+	if len(objects) != 1 {
+		t.Fatalf("approval markers = %+v, want one for the approver", objects)
+	}
+	var approval planApproval
+	data, _ := store.Bytes(approvalKey("team/plans/prod/p.tfplan", reviewerARN))
+	if err := json.Unmarshal(data, &approval); err != nil || approval.SHA256 != planBytesSHA || approval.Approver != reviewerARN || approval.ApprovedAt.IsZero() {
+		t.Errorf("approval = %s (%v)", data, err)
+	}
+
+	if err := run([]string{"approve", "prod", "plans/dev/p.tfplan"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("approving a dev plan for prod: %v, want a usage error", err)
+	}
+
+	storeTestPlan(t, store, "team/plans/prod/changed.tfplan", "other bytes")
+	if err := run([]string{"approve", "prod", "changed.tfplan"}); exitCodeFor(err) != exitCheck {
+		t.Errorf("approving a plan that doesn't match its sidecar: %v, want exit %d", err, exitCheck)
+	}
+
+	// a dev plan copied into prod's folder keeps its sidecar, which gives it away
+	storeTestPlan(t, store, "team/plans/prod/copied.tfplan", "plan bytes")
+	store.Put(context.Background(), storage.PutInput{Key: "team/plans/prod/copied.tfplan.json", Body: strings.NewReader(`{"environment":"dev","sha256":"` + planBytesSHA + `"}`)})
+	err := run([]string{"approve", "prod", "copied.tfplan"})
+	if exitCodeFor(err) != exitCheck || !strings.Contains(fmt.Sprint(err), "made for dev") {
+		t.Errorf("approving a dev plan stored under prod: %v, want exit %d", err, exitCheck)
+	}
+}
+
+func TestApplyRequireApproval(t *testing.T) {
+	rec, store := withPlanStore(t)
+	caller := withCaller(t, reviewerARN)
+	storeTestPlan(t, store, "team/plans/prod/p.tfplan", "plan bytes")
+	apply := []string{"apply", "prod", "--plan", "latest", "--require-approval"}
+
+	if err := run(apply); exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "has not been approved") {
+		t.Fatalf("apply before approving: %v, want exit %d", err, exitCheck)
+	}
+	if len(rec.Calls) != 0 {
+		t.Fatalf("terraform ran without an approval: %q", rec.Args())
+	}
+
+	if err := run([]string{"approve", "prod", "latest"}); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if err := run(apply); exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "your own approval doesn't count") {
+		t.Fatalf("apply by the approver: %v, want exit %d", err, exitCheck)
+	}
+
+	*caller = deployerARN
+	if err := run(apply); err != nil {
+		t.Fatalf("apply after approving: %v", err)
+	}
+	// the apply is followed by terraform version for the apply record
+	args := rec.Calls[len(rec.Calls)-2].Args
+	planFile := args[len(args)-1]
+	if args[0] != "apply" || !strings.HasSuffix(planFile, "p.tfplan") {
+		t.Errorf("apply ran %q, want the downloaded plan", args)
+	}
+	if _, err := os.Stat(planFile); !os.IsNotExist(err) {
+		t.Errorf("the downloaded plan %s was not cleaned up", planFile)
+	}
+
+	// the plan is swapped after it was approved
+	store.Put(context.Background(), storage.PutInput{Key: "team/plans/prod/p.tfplan", Body: strings.NewReader("plan bytes, but not those")})
+	store.Put(context.Background(), storage.PutInput{Key: "team/plans/prod/p.tfplan.json", Body: strings.NewReader(`{"environment":"prod"}`)})
+	calls := len(rec.Calls)
+	if err := run(apply); exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "approved again") {
+		t.Errorf("apply of a changed plan: %v, want exit %d", err, exitCheck)
+	}
+	if len(rec.Calls) != calls {
+		t.Errorf("terraform ran for a changed plan: %q", rec.Args())
+	}
+
+	if err := run([]string{"apply", "prod", "--require-approval"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("--require-approval without --plan: %v, want a usage error", err)
+	}
+}
+
+func TestApplyTwoApprovals(t *testing.T) {
+	rec, _ := withPlanStore(t)
+	caller := withCaller(t, reviewerARN)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    required_approvals: 2\n"), 0o644)
+	os.WriteFile("prod.tfvars", []byte("instance_type = \"t3.large\"\n"), 0o644)
+	os.WriteFile("prod.tfplan", []byte("plan bytes"), 0o644)
+
+	if err := run([]string{"plan", "prod", "prod.tfplan", "--store-plan"}); err != nil {
+		t.Fatalf("plan --store-plan: %v", err)
+	}
+	apply := []string{"apply", "prod", "--plan", "latest"}
+	if err := run([]string{"approve", "prod", "latest"}); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	*caller = deployerARN
+	if err := run(apply); exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "1 of the 2") {
+		t.Fatalf("apply with one approval: %v, want exit %d", err, exitCheck)
+	}
+
+	*caller = secondARN
+	if err := run([]string{"approve", "prod", "latest"}); err != nil {
+		t.Fatalf("second approve: %v", err)
+	}
+	*caller = deployerARN
+	calls := len(rec.Calls)
+	if err := run(apply); err != nil {
+		t.Fatalf("apply with two approvals: %v", err)
+	}
+	// prod snapshots its state first
+	if len(rec.Calls) != calls+3 || rec.Calls[calls].Args[0] != "state" || rec.Calls[calls+2].Args[0] != "version" {
+		t.Fatalf("apply ran %q, want the state pull, the apply and the version", rec.Args()[calls:])
+	}
+
+	// changing the tfvars after the plan was taken throws the approvals away
+	os.WriteFile("prod.tfvars", []byte("instance_type = \"t3.xlarge\"\n"), 0o644)
+	if err := run(apply); exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "prod.tfvars has changed") {
+		t.Errorf("apply after the tfvars changed: %v, want exit %d", err, exitCheck)
+	}
+
+	if err := run([]string{"apply", "prod", "--plan", "prod.tfplan", "--no-approval"}); err != nil {
+		t.Fatalf("apply --no-approval: %v", err)
+	}
+	if args := rec.Calls[len(rec.Calls)-2].Args; !strings.HasSuffix(args[len(args)-1], "prod.tfplan") {
+		t.Errorf("apply ran %q, want the local plan", args)
+	}
+}
+
+func TestApprovalsList(t *testing.T) {
+	_, store := withPlanStore(t)
+	caller := withCaller(t, reviewerARN)
+	storeTestPlan(t, store, "team/plans/prod/p.tfplan", "plan bytes")
+	run([]string{"approve", "prod", "p.tfplan"})
+	*caller = secondARN
+	run([]string{"approve", "prod", "p.tfplan"})
+	stale, _ := json.Marshal(planApproval{SHA256: "0000", Approver: deployerARN})
+	storage.PutBytes(context.Background(), store, approvalKey("team/plans/prod/p.tfplan", deployerARN), stale)
+
+	var stdout strings.Builder
+	if err := runWithUI([]string{"approvals", "prod", "p.tfplan"}, &ui{stdout: &stdout, stderr: &stdout}); err != nil {
+		t.Fatalf("approvals: %v", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{reviewerARN, secondARN, "STALE", "2 valid approval(s), prod needs 1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("approvals printed:\n%s\nwant %q in it", out, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+)
+
+// The auto environment - passing auto where a command takes <env> picks the environment from the git branch with the branches mapping in the config
+
+const autoEnvironment = "auto"
+
+// currentBranch is a variable so the tests don't depend on the checkout they run in
+
+var currentBranch = gitinfo.Branch
+
+func (a *app) autoEnvironment(ctx context.Context) (string, error) {
+	s, err := a.loadSettings()
+	if err != nil {
+		return "", err
+	}
+	if len(s.Branches) == 0 {
+		return "", configError("the %s environment needs a branches mapping in the config file", autoEnvironment)
+	}
+	branch := currentBranch(ctx)
+	if branch == "" {
+		return "", usageError("the %s environment needs the git branch, but there is no branch checked out and no CI variable names one (mappings: %s)", autoEnvironment, describeBranches(s.Branches))
+	}
+	env, err := matchBranch(s.Branches, branch)
+	if err != nil {
+		return "", err
+	}
+	a.out.Printf("Using the %s environment for branch %s\n", env, branch)
+	a.out.Event("environment", map[string]any{"branch": branch, "environment": env})
+	return env, nil
+}
+
+// matchBranch maps a branch to its environment. An exact name wins over the globs, and globs that match with different environments are an error rather than a guess
+
+func matchBranch(branches map[string]string, branch string) (string, error) {
+	if env, ok := branches[branch]; ok {
+		return env, nil
+	}
+	var patterns, envs []string
+	for pattern, env := range branches {
+		if ok, err := path.Match(pattern, branch); err != nil {
+			return "", configError("branches has an invalid pattern %q: %v", pattern, err)
+		} else if ok {
+			patterns = append(patterns, pattern)
+			if !slices.Contains(envs, env) {
+				envs = append(envs, env)
+			}
+		}
+	}
+	sort.Strings(patterns)
+	switch {
+	case len(envs) == 0:
+		return "", usageError("branch %s is not mapped to an environment (mappings: %s)", branch, describeBranches(branches))
+	case len(envs) > 1:
+		return "", usageError("branch %s matches %s, which map to different environments (mappings: %s)", branch, strings.Join(patterns, ", "), describeBranches(branches))
+	}
+	return envs[0], nil
+}
+
+func describeBranches(branches map[string]string) string {
+	var mappings []string
+	for pattern, env := range branches {
+		mappings = append(mappings, fmt.Sprintf("%s -> %s", pattern, env))
+	}
+	sort.Strings(mappings)
+	return strings.Join(mappings, ", ")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestMatchBranch(t *testing.T) {
+	branches := map[string]string{"develop": "dev", "release/*": "staging", "main": "prod", "release/hotfix-*": "prod", "release/1.*": "staging"}
+	for branch, want := range map[string]string{
+		"develop":       "dev",
+		"main":          "prod",
+		"release/2.0":   "staging",
+		"release/1.4":   "staging",
+		"release/1.x/y": "",
+		"feature/login": "",
+		// release/* and release/hotfix-* disagree
+		"release/hotfix-1": "",
+	} {
+		got, err := matchBranch(branches, branch)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("matchBranch(%q) = %q, %v, want %q", branch, got, err, want)
+		}
+	}
+
+	_, err := matchBranch(branches, "release/hotfix-1")
+	if exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "release/*, release/hotfix-*") {
+		t.Errorf("ambiguous branch: %v", err)
+	}
+	_, err = matchBranch(branches, "feature/login")
+	if !strings.Contains(err.Error(), "feature/login") || !strings.Contains(err.Error(), "develop -> dev") {
+		t.Errorf("unmapped branch: %v, want the branch and the mappings", err)
+	}
+}
+
+func TestAutoEnvironment(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	branch := "release/1.2"
+	swap(t, &currentBranch, func(context.Context) string { return branch })
+	os.WriteFile("staging.tfvars", nil, 0o644)
+	t.Setenv("STAGING_TFVARS", "staging.tfvars")
+
+	if err := run([]string{"plan", "auto", "out.tfplan"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("auto without a mapping: %v, want a config error", err)
+	}
+
+	os.WriteFile("tfmanage.yaml", []byte("branches:\n  develop: dev\n  release/*: staging\n  main: prod\n"), 0o644)
+	if err := run([]string{"plan", "auto", "out.tfplan"}); err != nil {
+		t.Fatalf("plan auto: %v", err)
+	}
+	if args := rec.Calls[0].Args; !strings.HasSuffix(args[2], "staging.tfvars") {
+		t.Errorf("plan ran %q, want the staging tfvars", args)
+	}
+
+	branch = "feature/x"
+	if err := run([]string{"plan", "auto", "out.tfplan"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("plan auto on an unmapped branch: %v, want a usage error", err)
+	}
+	branch = ""
+	if err := run([]string{"state", "list", "auto"}); exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "no branch") {
+		t.Errorf("state list auto without a branch: %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// The backend check - plan and apply make sure the directory's backend keeps the state of the environment they were asked for, plan staging in a directory initialized for the prod state is an easy mistake to make
+
+var errBackendMismatch = errors.New("the terraform backend doesn't match the environment")
+
+// stateOwner is the environment a state path names when it isn't the one expected - a path naming the environment itself, or none at all, can't be told apart from a good one
+
+func stateOwner(s settings, environment, statePath string) string {
+	segments := strings.Split(strings.TrimSuffix(statePath, ".tfstate"), "/")
+	if slices.Contains(segments, environment) {
+		return ""
+	}
+	for _, other := range environmentNames(s) {
+		if slices.Contains(segments, other) {
+			return other
+		}
+	}
+	return ""
+}
+
+// checkBackend compares where the backend keeps the state with the environment's expected_state_key or managed state key, or without either with the environment names in the path
+
+func (a *app) checkBackend(environment, chdir string, skip bool) error {
+	if skip {
+		a.out.Warnf("Not checking that the terraform backend keeps the state of %s (--skip-backend-check)", environment)
+		return nil
+	}
+	if a.global.runner == "ssm" {
+		a.out.Warnf("Not checking that the terraform backend keeps the state of %s, it is initialized on %s", environment, a.global.instanceID)
+		return nil
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	expected := s.Terraform[environment].ExpectedStateKey
+	hint := "Run 'terraform init -reconfigure' with the backend config of " + environment + ", or pass --skip-backend-check if this is on purpose"
+	if expected == "" {
+		key, managed, err := stateKey(s, environment)
+		if err != nil {
+			return err
+		}
+		if managed {
+			expected = key
+			hint = "Run 'tfmanage init " + environment + "' to set the key from the state_key_template, with --migrate-state when the template changed"
+			a.out.Printf("State key for %s: %s\n", environment, a.out.green(key))
+		}
+	}
+	backend, err := tfexec.ReadBackend(chdir)
+	if errors.Is(err, tfexec.ErrNoBackend) {
+		if expected == "" {
+			return nil
+		}
+		return configError("%w: %s expects its state at %s, but %v. %s", errBackendMismatch, environment, expected, err, hint)
+	}
+	if err != nil {
+		return err
+	}
+
+	statePath := backend.StatePath(a.workspace)
+	a.out.Verbosef("The %s backend from %s keeps the state at %s\n", backend.Type, backend.Source, statePath)
+	if expected != "" {
+		if statePath != expected && backend.Key != expected {
+			return configError("%w: %s expects its state at %s, but the %s backend from %s keeps it at %s. %s", errBackendMismatch, environment, expected, backend.Type, backend.Source, statePath, hint)
+		}
+		return nil
+	}
+	if owner := stateOwner(s, environment, statePath); owner != "" {
+		return configError("%w: the %s backend from %s keeps the state at %s, which looks like %s's state rather than %s's. %s", errBackendMismatch, backend.Type, backend.Source, statePath, owner, environment, hint)
+	}
+	return nil
+}
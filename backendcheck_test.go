@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestBackendMismatch(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("staging.tfvars", nil, 0o644)
+	t.Setenv("STAGING_TFVARS", "staging.tfvars")
+	os.WriteFile("backend.tf", []byte("terraform {\n  backend \"s3\" {\n    bucket = \"states\"\n    key    = \"prod/app/terraform.tfstate\"\n  }\n}\n"), 0o644)
+
+	err := run([]string{"plan", "staging", "plan.out"})
+	if !errors.Is(err, errBackendMismatch) || exitCodeFor(err) != exitConfig || !strings.Contains(err.Error(), "prod/app/terraform.tfstate") || !strings.Contains(err.Error(), "init -reconfigure") {
+		t.Fatalf("plan staging against the prod state: %v, want a mismatch naming the key", err)
+	}
+	if err := run([]string{"apply", "staging"}); !errors.Is(err, errBackendMismatch) {
+		t.Errorf("apply staging against the prod state: %v, want a mismatch", err)
+	}
+	if len(rec.Calls) != 0 {
+		t.Fatalf("terraform ran %q despite the mismatch", rec.Args())
+	}
+	if err := run([]string{"plan", "staging", "plan.out", "--skip-backend-check"}); err != nil || len(rec.Calls) != 1 {
+		t.Errorf("plan --skip-backend-check: %v", err)
+	}
+
+	// the config can say exactly where the state is, in its workspace
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  staging:\n    workspace: blue\n    expected_state_key: env:/blue/prod/app/terraform.tfstate\n"), 0o644)
+	if err := run([]string{"plan", "staging", "plan.out"}); err != nil {
+		t.Errorf("plan with the expected_state_key: %v", err)
+	}
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  staging:\n    expected_state_key: staging/app/terraform.tfstate\n"), 0o644)
+	if err := run([]string{"plan", "staging", "plan.out"}); !errors.Is(err, errBackendMismatch) || !strings.Contains(err.Error(), "expects its state at staging/app/terraform.tfstate") {
+		t.Errorf("plan against another expected_state_key: %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfvars"
+)
+
+// blame - which upload gave a variable its current value. The versions of the environment's tfvars are read newest first, one at a time, until one has another value, so a long history isn't downloaded in full
+
+const defaultBlameVersions = 50
+
+// what a version of the tfvars says about the variable
+
+const (
+	blameSame       = "same"
+	blameChanged    = "changed"
+	blameUnset      = "not set"
+	blameUnreadable = "unreadable"
+)
+
+// blameVersion is one version blame read, the uploader and message come from the change journal and the version's metadata
+
+type blameVersion struct {
+	VersionID string    `json:"version_id"`
+	At        time.Time `json:"at"`
+	Uploader  string    `json:"uploader"`
+	Message   string    `json:"message,omitempty"`
+	State     string    `json:"state"`
+}
+
+func blameCommand() *command {
+	return &command{
+		name:    "blame",
+		args:    "<env> <variable>",
+		summary: "Find the upload that gave a variable in the environment's tfvars its current value, with who uploaded it, when and why.",
+		examples: []string{
+			"tfmanage blame prod instance_type",
+			"tfmanage blame prod replicas --max-versions 200",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			maxVersions := fs.Int("max-versions", defaultBlameVersions, "read at most this many versions, newest first")
+			return func(ctx context.Context, a *app, args []string) error {
+				if *maxVersions < 1 {
+					return usageError("--max-versions has to be at least 1")
+				}
+				fileName, err := a.tfvarsFor(args[0])
+				if err != nil {
+					return err
+				}
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				if err := requirementsError("blame", checkStoreRequirements("download", args[0], s)); err != nil {
+					return err
+				}
+				loc, err := a.locate(ctx, s, args[0], fileName)
+				if err != nil {
+					return err
+				}
+				versions, err := loc.store.Versions(ctx, loc.key)
+				if err != nil {
+					return err
+				}
+				journal := &changeIndex{}
+				journal.store, journal.prefix, journal.ok = changeJournal(loc, args[0], fileName)
+				return a.blame(ctx, loc, journal, args[0], fileName, args[1], versions, *maxVersions)
+			}
+		},
+	}
+}
+
+func (a *app) blame(ctx context.Context, loc tfvarsLocation, journal *changeIndex, environment, fileName, name string, versions []storage.ObjectInfo, maxVersions int) error {
+	if len(versions) == 0 {
+		return usageError("%s has no stored versions, upload it first", loc.url(loc.key))
+	}
+	read := versions[:min(len(versions), maxVersions)]
+	var current tfvars.Assignment
+	var since *blameVersion
+	for i, v := range read {
+		data, err := storage.GetVersionBytes(ctx, loc.store, loc.key, v.VersionID)
+		if err != nil {
+			return err
+		}
+		state := blameUnset
+		assignments, err := tfvars.ParseFile(fileName, data)
+		value, ok := tfvars.Lookup(assignments, name)
+		switch {
+		case err != nil && i == 0:
+			return configError("the current version of %s can't be read: %v", loc.url(loc.key), err)
+		case err != nil:
+			a.out.Warnf("Version %s of %s can't be read, it is left out: %v", v.VersionID, loc.url(loc.key), err)
+			state = blameUnreadable
+		case !ok && i == 0:
+			return usageError("%s isn't set in the current version of %s", name, loc.url(loc.key))
+		case i == 0:
+			current, state = value, blameSame
+			a.out.Printf("%s\n", blameValue(name, current.Value))
+		case ok && tfvars.Equal(value.Value, current.Value):
+			state = blameSame
+		case ok:
+			state = blameChanged
+		}
+
+		record := a.blameRecord(ctx, journal, v, state)
+		a.out.Event("blame-version", map[string]any{"environment": environment, "variable": name, "version_id": record.VersionID, "at": record.At, "uploader": record.Uploader, "message": record.Message, "state": record.State})
+		a.out.Printf("  %s  %s  %-9s %s%s\n", record.VersionID, record.At.Format(time.RFC3339), record.State, record.Uploader, quotedMessage(record.Message))
+		switch state {
+		case blameSame:
+			since = &record
+			continue
+		case blameUnreadable:
+			continue
+		}
+		// the version after this one is where the value came in
+		kind := "changed to its current value"
+		if state == blameUnset {
+			kind = "introduced"
+		}
+		return a.blameResult(environment, name, kind, *since, i+1)
+	}
+	if len(read) < len(versions) {
+		a.out.Event("blame", map[string]any{"environment": environment, "variable": name, "result": "older", "version_id": since.VersionID, "versions_read": len(read)})
+		a.out.Printf("%s has had its current value since at least version %s (%s), the oldest of the %d versions read - pass a higher --max-versions to look further back\n", name, since.VersionID, since.At.Format(time.RFC3339), len(read))
+		return nil
+	}
+	return a.blameResult(environment, name, "introduced", *since, len(read))
+}
+
+func (a *app) blameResult(environment, name, kind string, v blameVersion, read int) error {
+	a.out.Event("blame", map[string]any{"environment": environment, "variable": name, "result": strings.Fields(kind)[0], "version_id": v.VersionID, "at": v.At, "uploader": v.Uploader, "message": v.Message, "versions_read": read})
+	a.out.Successf("%s was %s in version %s at %s by %s%s", name, kind, v.VersionID, v.At.Format(time.RFC3339), v.Uploader, quotedMessage(v.Message))
+	return nil
+}
+
+// blameRecord describes a version, the uploader is only known from the change journal
+
+func (a *app) blameRecord(ctx context.Context, journal *changeIndex, v storage.ObjectInfo, state string) blameVersion {
+	record := blameVersion{VersionID: v.VersionID, At: v.LastModified, Uploader: unknownField, Message: decodeMetadataValue(v.Metadata[changeMessageMetadataKey]), State: state}
+	if entry, ok := journal.find(ctx, a, v.VersionID); ok {
+		record.Uploader = entry.Uploader
+		record.Message = cmp.Or(record.Message, entry.Message)
+	}
+	return record
+}
+
+// blameValue shows the current value, masked when the name says it is a secret or the working directory declares it sensitive, and only its first line when it has several
+
+func blameValue(name, value string) string {
+	if names, err := redact.SensitiveVariables("."); err == nil && slices.Contains(names, name) {
+		value = redact.Mask
+	}
+	first, rest, multiline := strings.Cut(value, "\n")
+	line := redact.Line(name + " = " + first)
+	if multiline {
+		line += fmt.Sprintf(" ... (%d more lines)", strings.Count(rest, "\n")+1)
+	}
+	return line
+}
+
+func quotedMessage(message string) string {
+	if message == "" {
+		return ""
+	}
+	return ": " + message
+}
+
+// changeIndex finds the journal entry that made a version. It reads the journal newest first and only as far as it has to, blame walks the versions in the same order
+
+type changeIndex struct {
+	store  storage.Backend
+	prefix string
+	ok     bool
+
+	listed  bool
+	entries []changeEntry
+	next    int
+}
+
+func (x *changeIndex) find(ctx context.Context, a *app, versionID string) (changeEntry, bool) {
+	if !x.ok || versionID == "" {
+		return changeEntry{}, false
+	}
+	if !x.listed {
+		x.listed = true
+		entries, err := listChanges(ctx, x.store, x.prefix)
+		if err != nil {
+			a.out.Verbosef("Could not read the change journal, uploaders are unknown: %v\n", err)
+			return changeEntry{}, false
+		}
+		x.entries = entries
+	}
+	for i := range x.next {
+		if x.entries[i].AfterVersion == versionID {
+			return x.entries[i], true
+		}
+	}
+	for ; x.next < len(x.entries); x.next++ {
+		e := &x.entries[x.next]
+		if err := readChange(ctx, x.store, e); err != nil {
+			a.out.Verbosef("Could not read %s from the change journal: %v\n", e.Key, err)
+			continue
+		}
+		if e.AfterVersion == versionID {
+			x.next++
+			return *e, true
+		}
+	}
+	return changeEntry{}, false
+}
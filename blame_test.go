@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBlame(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	caller := withCaller(t, deployerARN)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	for _, upload := range []struct{ caller, content, message string }{
+		{deployerARN, "instance_type = \"t3.small\"\n", "first"},
+		{reviewerARN, "instance_type = \"t3.small\"\nreplicas = 2\nzone = \"a\"\n", "two replicas"},
+		{deployerARN, "instance_type = \"t3.small\"\nreplicas = 3\nzone = \"a\"\n", "scale to 3"},
+		{reviewerARN, "instance_type = \"t3.large\"\nreplicas   =   3 # still 3\nzone = \"a\"\n", "bigger instances"},
+	} {
+		*caller = upload.caller
+		os.WriteFile("dev.tfvars", []byte(upload.content), 0o644)
+		if err := run([]string{"upload", "dev", "-m", upload.message}); err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+	}
+
+	blame := func(args ...string) map[string]any {
+		t.Helper()
+		var stdout bytes.Buffer
+		if err := runWithUI(append([]string{"--output", "json", "blame", "dev"}, args...), &ui{json: true, stdout: &stdout, stderr: io.Discard}); err != nil {
+			t.Fatalf("blame %v: %v", args, err)
+		}
+		for line := range strings.Lines(stdout.String()) {
+			var event map[string]any
+			if json.Unmarshal([]byte(line), &event); event["event"] == "blame" {
+				return event
+			}
+		}
+		t.Fatalf("blame %v printed no result:\n%s", args, stdout.String())
+		return nil
+	}
+
+	// the journal entries take every other version
+	if got := blame("replicas"); got["result"] != "changed" || got["version_id"] != "v5" || got["uploader"] != deployerARN || got["message"] != "scale to 3" {
+		t.Errorf("blame replicas = %v, want changed in v5 by %s", got, deployerARN)
+	}
+	if got := blame("zone"); got["result"] != "introduced" || got["version_id"] != "v3" || got["uploader"] != reviewerARN {
+		t.Errorf("blame zone = %v, want introduced in v3 by %s", got, reviewerARN)
+	}
+	if got := blame("instance_type"); got["result"] != "changed" || got["version_id"] != "v7" || got["versions_read"] != 2.0 {
+		t.Errorf("blame instance_type = %v, want changed in v7 after reading 2 versions", got)
+	}
+	if got := blame("zone", "--max-versions", "2"); got["result"] != "older" || got["version_id"] != "v5" {
+		t.Errorf("blame zone --max-versions 2 = %v, want unchanged since at least v5", got)
+	}
+
+	if err := run([]string{"blame", "dev", "nope"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("blame of a variable that isn't set: %v, want a usage error", err)
+	}
+	if err := run([]string{"blame", "dev", "zone", "--max-versions", "0"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("blame --max-versions 0: %v, want a usage error", err)
+	}
+}
+
+func TestBlameValue(t *testing.T) {
+	inTempDir(t)
+	os.WriteFile("variables.tf", []byte("variable \"db_pass\" {\n  sensitive = true\n}\n"), 0o644)
+	if got := blameValue("db_pass", `"hunter2"`); strings.Contains(got, "hunter2") {
+		t.Errorf("blameValue() of a sensitive variable = %q", got)
+	}
+	if got := blameValue("tags", "{\nowner = \"x\"\n}"); got != "tags = { ... (2 more lines)" {
+		t.Errorf("blameValue() of a map = %q", got)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/iampolicy"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// Uploads make sure the bucket belongs to the account they expect first - a stale S3_BUCKET can point at a bucket of the same name in somebody else's account, and the tfvars would end up there
+
+var errCrossAccountBucket = errors.New("the bucket belongs to another account")
+
+var accountID = regexp.MustCompile(`^\d{12}$`)
+
+func (a *app) bucketOwnerVerdict(ctx context.Context, checker storage.OwnershipChecker, bucket string, check bucketCheck) error {
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	if s.ExpectedBucketAccount != "" && !accountID.MatchString(s.ExpectedBucketAccount) {
+		return configError("expected_bucket_account %q isn't a 12 digit account ID", s.ExpectedBucketAccount)
+	}
+	var caller string
+	if arn, err := callerIdentity(ctx, s); err == nil {
+		caller = iampolicy.AccountID(arn)
+	}
+	expected, source := s.ExpectedBucketAccount, "expected_bucket_account"
+	if expected == "" {
+		expected, source = caller, "the account of the AWS credentials in use"
+	}
+	if expected == "" {
+		return a.ownerUnchecked(bucket, errors.New("the account of the AWS credentials in use is unknown"), check)
+	}
+
+	owned, err := checker.OwnedBy(ctx, expected)
+	if err != nil {
+		return a.ownerUnchecked(bucket, err, check)
+	}
+	acl, aclErr := checker.ACL(ctx)
+	a.out.Event("bucket-owner", map[string]any{"bucket": bucket, "expected_account": expected, "owned": owned, "grants": acl.Grants, "allowed": check.allowCrossAccount})
+	switch {
+	case aclErr != nil:
+		a.out.Warnf("Couldn't read the ACL of s3://%s, uploading anyway: %v", bucket, aclErr)
+	case len(acl.Grants) > 0:
+		a.out.Warnf("The ACL of s3://%s lets in more than its owner:\n  - %s", bucket, strings.Join(acl.Grants, "\n  - "))
+	}
+	if owned {
+		return nil
+	}
+
+	details := fmt.Sprintf("it isn't owned by account %s, %s", expected, source)
+	if caller != "" && caller != expected {
+		details += fmt.Sprintf(", and the AWS credentials in use are of account %s", caller)
+	}
+	if acl.OwnerID != "" {
+		details += fmt.Sprintf(". Its owner's canonical ID is %s", acl.OwnerID)
+	}
+	if check.allowCrossAccount {
+		a.out.Warnf("Uploading to s3://%s anyway because of --allow-cross-account-bucket, %s", bucket, details)
+		return nil
+	}
+	return withCode(exitCheck, fmt.Errorf("%w, refusing to upload to s3://%s: %s\ncheck S3_BUCKET, set expected_bucket_account if the bucket is meant to be in another account, or pass --allow-cross-account-bucket",
+		errCrossAccountBucket, bucket, details))
+}
+
+// ownerUnchecked is the verdict when the owner can't be checked, least privilege credentials often aren't allowed to
+
+func (a *app) ownerUnchecked(bucket string, err error, check bucketCheck) error {
+	if check.strict {
+		return withCode(exitCheck, fmt.Errorf("couldn't check who owns s3://%s, and --strict needs it checked: %w", bucket, err))
+	}
+	a.out.Warnf("Couldn't check who owns s3://%s, uploading anyway: %v", bucket, err)
+	return nil
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// bundles - a frozen tar.gz of exactly what an environment was run with, the .tf files, the tfvars and the lock file with a manifest of their checksums, for audits. bundle verify checks one still matches its manifest
+
+// bundleStorePrefix is where bundles go under S3_PATH, one folder per environment
+
+const bundleStorePrefix = "bundles"
+
+// the names in a bundle that aren't module files
+
+const (
+	bundleManifestName = "manifest.json"
+	bundleTFVarsDir    = "tfvars"
+)
+
+// bundleManifestMetadataKey is the object metadata with the checksum of the manifest, so a bundle whose manifest was rewritten to match edited files is caught too
+
+const bundleManifestMetadataKey = "manifest-sha256"
+
+// bundleManifest is manifest.json, the first entry of every bundle. It has no timestamp so the same files give the same bundle
+
+type bundleManifest struct {
+	Environment      string       `json:"environment"`
+	Commit           string       `json:"commit,omitempty"`
+	TerraformVersion string       `json:"terraform_version,omitempty"`
+	TFVars           string       `json:"tfvars"`
+	Files            []bundleFile `json:"files"`
+}
+
+type bundleFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// bundleKey gives back the object key for a bundle argument - bundles/<env>/<file>, with or without S3_PATH in front
+
+func bundleKey(s settings, arg string) (string, bool) {
+	if s.S3Path != "" && strings.HasPrefix(arg, s.S3Path+bundleStorePrefix+"/") {
+		return arg, true
+	}
+	if strings.HasPrefix(arg, bundleStorePrefix+"/") {
+		return storage.Key(s.S3Path, arg), true
+	}
+	return "", false
+}
+
+// bundleFiles finds the .tf files under dir and the lock file, leaving out .terraform, .git and whatever skip says. The paths are slash separated and sorted
+
+func bundleFiles(dir string, skip skipFunc) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			// terraform's own copies of the modules are never part of the module, even with --no-ignore
+			if d.Name() == ".terraform" || d.Name() == ".git" || skip(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || skip(rel, false) {
+			return nil
+		}
+		if strings.HasSuffix(rel, ".tf") || rel == tfexec.LockFileName {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the module in %s: %w", dir, err)
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// bundleEntry is one file going into a bundle, under its name in the bundle
+
+type bundleEntry struct {
+	name string
+	data []byte
+}
+
+// writeBundle writes a tar.gz with the manifest first and the entries after it in order. Every header has the same time, owner and mode so the bytes only depend on the contents
+
+func writeBundle(manifest bundleManifest, entries []bundleEntry) ([]byte, error) {
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the bundle manifest: %w", err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, e := range append([]bundleEntry{{bundleManifestName, manifestData}}, entries...) {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: e.name, Size: int64(len(e.data)), Mode: 0o644, ModTime: time.Unix(0, 0), Format: tar.FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// buildBundle reads the module in dir and the tfvars into a bundle. It gives back the archive and its manifest
+
+func buildBundle(dir, varFile string, manifest bundleManifest, skip skipFunc) ([]byte, bundleManifest, error) {
+	names, err := bundleFiles(dir, skip)
+	if err != nil {
+		return nil, bundleManifest{}, err
+	}
+	if !slices.ContainsFunc(names, func(n string) bool { return strings.HasSuffix(n, ".tf") }) {
+		return nil, bundleManifest{}, usageError("there are no .tf files in %s to bundle", dir)
+	}
+	var entries []bundleEntry
+	manifest.Files = nil
+	add := func(name, fileName string) error {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		entries = append(entries, bundleEntry{name, data})
+		manifest.Files = append(manifest.Files, bundleFile{Path: name, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data))})
+		return nil
+	}
+	for _, name := range names {
+		if err := add(name, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return nil, bundleManifest{}, err
+		}
+	}
+	manifest.TFVars = path.Join(bundleTFVarsDir, filepath.Base(varFile))
+	if err := add(manifest.TFVars, varFile); err != nil {
+		return nil, bundleManifest{}, err
+	}
+	data, err := writeBundle(manifest, entries)
+	return data, manifest, err
+}
+
+// bundleProblem is one way a bundle doesn't match its manifest
+
+type bundleProblem struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// verifyBundle reads a bundle back and checks every file against the manifest - changed, missing and extra files are all problems. An archive that can't be read or has no manifest is an error
+
+func verifyBundle(data []byte) (bundleManifest, []byte, []bundleProblem, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return bundleManifest{}, nil, nil, fmt.Errorf("not a bundle: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	sums := map[string]string{}
+	var manifestData []byte
+	var problems []bundleProblem
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return bundleManifest{}, nil, nil, fmt.Errorf("not a bundle: %w", err)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			return bundleManifest{}, nil, nil, fmt.Errorf("not a bundle: %w", err)
+		}
+		if hdr.Name == bundleManifestName {
+			manifestData = contents
+			continue
+		}
+		if _, dup := sums[hdr.Name]; dup {
+			problems = append(problems, bundleProblem{hdr.Name, "is in the bundle more than once"})
+		}
+		sum := sha256.Sum256(contents)
+		sums[hdr.Name] = hex.EncodeToString(sum[:])
+	}
+	if manifestData == nil {
+		return bundleManifest{}, nil, nil, errors.New("not a bundle: it has no " + bundleManifestName)
+	}
+	var manifest bundleManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return bundleManifest{}, nil, nil, fmt.Errorf("the bundle's %s is broken: %w", bundleManifestName, err)
+	}
+
+	listed := map[string]bool{}
+	for _, f := range manifest.Files {
+		listed[f.Path] = true
+		switch sum, ok := sums[f.Path]; {
+		case !ok:
+			problems = append(problems, bundleProblem{f.Path, "is missing"})
+		case sum != f.SHA256:
+			problems = append(problems, bundleProblem{f.Path, "has changed"})
+		}
+	}
+	var extra []string
+	for name := range sums {
+		if !listed[name] {
+			extra = append(extra, name)
+		}
+	}
+	slices.Sort(extra)
+	for _, name := range extra {
+		problems = append(problems, bundleProblem{name, "is not in the manifest"})
+	}
+	return manifest, manifestData, problems, nil
+}
+
+func bundleCommand() *command {
+	return &command{
+		name:    "bundle",
+		args:    "<env> | verify <bundle>",
+		summary: "Store the .tf files, tfvars and lock file of an environment as one tar.gz for audits, or check a stored bundle wasn't changed.",
+		examples: []string{
+			"tfmanage bundle prod",
+			"tfmanage bundle prod --chdir infra --out-file prod-bundle.tar.gz",
+			"tfmanage bundle verify bundles/prod/20240501T120000Z-1a2b3c4.tar.gz",
+			"tfmanage bundle verify prod-bundle.tar.gz",
+		},
+		minArgs: 1,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "bundle the module in this directory")
+			useCache := fs.Bool("use-cache", false, "bundle the tfvars cached with download --cache instead of the tfvars path")
+			outFile := fs.String("out-file", "", "also write the bundle to this file")
+			noIgnore := fs.Bool("no-ignore", false, "bundle the files .tfmanageignore and the built-in ignore rules leave out")
+			return func(ctx context.Context, a *app, args []string) error {
+				if args[0] == "verify" {
+					if len(args) != 2 {
+						return usageError("bundle verify needs a bundle key or file")
+					}
+					// verify isn't an environment, keep it out of the metrics
+					a.environment = ""
+					return verifyBundleCommand(ctx, a, args[1])
+				}
+				if len(args) != 1 {
+					return usageError("bundle takes just the environment, or verify and a bundle")
+				}
+				return createBundle(ctx, a, args[0], *chdir, *useCache, *outFile, *noIgnore)
+			}
+		},
+	}
+}
+
+func createBundle(ctx context.Context, a *app, environment, chdir string, useCache bool, outFile string, noIgnore bool) error {
+	varFile, err := a.varFile(ctx, "plan", environment, useCache)
+	if err != nil {
+		return err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	if err := requirementsError("bundle", checkRequirements("bundle", "", s)); err != nil {
+		return err
+	}
+	dir := cmp.Or(a.useEnvironment(environment, chdir), ".")
+	skip, err := a.ignoreRules(dir, noIgnore)
+	if err != nil {
+		return err
+	}
+
+	manifest := bundleManifest{Environment: environment, Commit: gitinfo.Commit(ctx)}
+	if version, err := tfexec.TerraformVersion(ctx, runner, a.terraformOutput()); err != nil {
+		a.out.Warnf("Could not get the terraform version for the bundle's manifest: %v", err)
+	} else {
+		manifest.TerraformVersion = version.String()
+	}
+	data, manifest, err := buildBundle(dir, varFile, manifest, skip)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(manifest.Files, func(f bundleFile) bool { return f.Path == tfexec.LockFileName }) {
+		a.out.Warnf("There is no %s in %s, the bundle doesn't pin the provider versions", tfexec.LockFileName, dir)
+	}
+	if outFile != "" {
+		if err := os.WriteFile(outFile, data, 0o600); err != nil {
+			return err
+		}
+	}
+
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return err
+	}
+	short := cmp.Or(gitinfo.Short(manifest.Commit), "nogit")
+	key := storage.Key(s.S3Path, path.Join(bundleStorePrefix, environment, time.Now().UTC().Format("20060102T150405Z")+"-"+short+".tar.gz"))
+	manifestData, _ := json.MarshalIndent(manifest, "", "  ")
+	manifestSum := sha256.Sum256(manifestData)
+	kmsKey, _ := kmsKeyFor(s, environment)
+	metadata := map[string]string{gitSHAMetadataKey: cmp.Or(manifest.Commit, "none"), bundleManifestMetadataKey: hex.EncodeToString(manifestSum[:])}
+	res, err := storage.PutBytesWith(ctx, store, key, data, storage.UploadOptions{Metadata: metadata, KMSKeyID: kmsKey})
+	if err != nil {
+		return err
+	}
+	a.out.Event("bundle", map[string]any{"environment": environment, "bucket": s.S3Bucket, "key": key, "sha256": res.Checksum, "files": len(manifest.Files), "commit": manifest.Commit, "terraform_version": manifest.TerraformVersion, "kms_key_arn": kmsKey})
+	a.out.Successf("Stored the bundle of %s (%d files) as s3://%s/%s", environment, len(manifest.Files), s.S3Bucket, key)
+	return nil
+}
+
+func verifyBundleCommand(ctx context.Context, a *app, arg string) error {
+	data, wantManifest, where, err := readBundle(ctx, a, arg)
+	if err != nil {
+		return err
+	}
+	manifest, problems, err := checkBundle(data, wantManifest)
+	if err != nil {
+		return withCode(exitCheck, fmt.Errorf("%s: %w", where, err))
+	}
+	a.out.Event("bundle-verify", map[string]any{"bundle": where, "environment": manifest.Environment, "commit": manifest.Commit, "files": len(manifest.Files), "problems": problems})
+	if len(problems) > 0 {
+		for _, p := range problems {
+			a.out.Warnf("%s %s", p.Path, p.Reason)
+		}
+		return withCode(exitCheck, fmt.Errorf("%s has been tampered with, %d file(s) don't match its manifest", where, len(problems)))
+	}
+	a.out.Successf("%s matches its manifest, %d file(s) of %s at commit %s", where, len(manifest.Files), manifest.Environment, cmp.Or(gitinfo.Short(manifest.Commit), "none"))
+	return nil
+}
+
+// checkBundle is verifyBundle plus the manifest checksum a stored bundle has in its metadata, empty for a local file
+
+func checkBundle(data []byte, wantManifest string) (bundleManifest, []bundleProblem, error) {
+	manifest, manifestData, problems, err := verifyBundle(data)
+	if err != nil {
+		return bundleManifest{}, nil, err
+	}
+	if wantManifest != "" {
+		sum := sha256.Sum256(manifestData)
+		if hex.EncodeToString(sum[:]) != wantManifest {
+			problems = append(problems, bundleProblem{bundleManifestName, "doesn't match the checksum it was stored with"})
+		}
+	}
+	return manifest, problems, nil
+}
+
+// readBundle reads a bundle from a local file, or from the bucket when the argument is a key. For a stored bundle it also gives back the manifest checksum from its metadata
+
+func readBundle(ctx context.Context, a *app, arg string) ([]byte, string, string, error) {
+	if data, err := os.ReadFile(arg); err == nil {
+		return data, "", arg, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, "", "", err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return nil, "", "", err
+	}
+	key, ok := bundleKey(s, arg)
+	if !ok {
+		return nil, "", "", usageError("%s is neither a file nor a bundle key like %s/<env>/<bundle>.tar.gz", arg, bundleStorePrefix)
+	}
+	s, store, err := a.bundleStore(ctx)
+	if err != nil {
+		return nil, "", "", err
+	}
+	data, wantManifest, err := fetchBundle(ctx, s, store, key)
+	return data, wantManifest, "s3://" + s.S3Bucket + "/" + key, err
+}
+
+// bundleStore checks the bucket settings and gives back the store the bundles are kept in
+
+func (a *app) bundleStore(ctx context.Context) (settings, storage.Backend, error) {
+	s, err := a.loadSettings()
+	if err != nil {
+		return settings{}, nil, err
+	}
+	if err := requirementsError("bundle", checkRequirements("bundle", "", s)); err != nil {
+		return settings{}, nil, err
+	}
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return settings{}, nil, err
+	}
+	return s, store, nil
+}
+
+// fetchBundle downloads a stored bundle, checking it against the checksum it was uploaded with, and gives back the manifest checksum from its metadata
+
+func fetchBundle(ctx context.Context, s settings, store storage.Backend, key string) ([]byte, string, error) {
+	remote, err := store.Head(ctx, key)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, "", configError("there is no bundle at s3://%s/%s", s.S3Bucket, key)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := storage.GetBytes(ctx, store, key)
+	if err != nil {
+		return nil, "", kmsDecryptError(err, remote.KMSKeyID)
+	}
+	if sum := remote.Metadata[storage.ChecksumMetadataKey]; sum != "" {
+		if got := sha256.Sum256(data); hex.EncodeToString(got[:]) != sum {
+			return nil, "", withCode(exitCheck, fmt.Errorf("s3://%s/%s has been tampered with, it doesn't match the checksum it was uploaded with", s.S3Bucket, key))
+		}
+	}
+	return data, remote.Metadata[bundleManifestMetadataKey], nil
+}
+
+// latestBundle is the newest bundle stored for the environment, the keys start with the time so the last one is the newest
+
+func latestBundle(ctx context.Context, s settings, store storage.Backend, environment string) (string, error) {
+	prefix := storage.Key(s.S3Path, bundleStorePrefix+"/"+environment+"/")
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	for _, o := range objects {
+		if strings.HasSuffix(o.Key, ".tar.gz") && o.Key > latest {
+			latest = o.Key
+		}
+	}
+	if latest == "" {
+		return "", configError("no bundles are stored for %s under s3://%s/%s, run 'tfmanage bundle %s' first", environment, s.S3Bucket, prefix, environment)
+	}
+	return latest, nil
+}
+
+// resolveBundleKey turns latest, a bundle key or a file name under the environment's bundles into a key
+
+func resolveBundleKey(ctx context.Context, s settings, store storage.Backend, environment, arg string) (string, error) {
+	if arg == "latest" {
+		return latestBundle(ctx, s, store, environment)
+	}
+	if key, ok := bundleKey(s, arg); ok {
+		return key, nil
+	}
+	return storage.Key(s.S3Path, path.Join(bundleStorePrefix, environment, arg)), nil
+}
+
+// extractBundle writes the files of a checked bundle under dir. Names that would land outside dir are refused, whatever the manifest says
+
+func extractBundle(data []byte, dir string) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("not a bundle: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("not a bundle: %w", err)
+		}
+		if hdr.Name == bundleManifestName {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(filepath.FromSlash(hdr.Name)) {
+			return withCode(exitCheck, fmt.Errorf("the bundle has %q in it, which isn't a file inside the module", hdr.Name))
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+// checkBundleTerraform refuses a local terraform that can't run what the bundle was made with - it has to be the same minor version, a newer minor can upgrade the state so the recorded version can't read it anymore
+
+func checkBundleTerraform(ctx context.Context, a *app, recorded string) error {
+	if recorded == "" {
+		a.out.Warnf("The bundle doesn't record a terraform version, using the installed one")
+		return nil
+	}
+	want, err := tfexec.ParseVersion(recorded)
+	if err != nil {
+		return err
+	}
+	have, err := tfexec.TerraformVersion(ctx, runner, a.terraformOutput())
+	if err != nil {
+		return err
+	}
+	if have.Major != want.Major || have.Minor != want.Minor || !have.AtLeast(want) {
+		return configError("the bundle was made with terraform %s but this is terraform %s, use %d.%d.%d or a newer %d.%d release", want, have, want.Major, want.Minor, want.Patch, want.Major, want.Minor)
+	}
+	return nil
+}
+
+// bundleWorkdir gets a stored bundle of the environment ready to apply: it downloads and checks it, unpacks it into a temp dir and runs terraform init there with the environment's backend settings. It gives back the dir and the bundled tfvars, cleanup removes the dir unless keep is set
+
+func bundleWorkdir(ctx context.Context, a *app, environment, arg string, keep bool) (string, string, func(), error) {
+	s, store, err := a.bundleStore(ctx)
+	if err != nil {
+		return "", "", nil, err
+	}
+	key, err := resolveBundleKey(ctx, s, store, environment, arg)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if !strings.HasPrefix(key, storage.Key(s.S3Path, bundleStorePrefix+"/"+environment+"/")) {
+		return "", "", nil, usageError("%s is not a stored bundle for %s", key, environment)
+	}
+	where := "s3://" + s.S3Bucket + "/" + key
+	a.out.Printf("Downloading %s...\n", where)
+	data, wantManifest, err := fetchBundle(ctx, s, store, key)
+	if err != nil {
+		return "", "", nil, err
+	}
+	manifest, problems, err := checkBundle(data, wantManifest)
+	if err != nil {
+		return "", "", nil, withCode(exitCheck, fmt.Errorf("%s: %w", where, err))
+	}
+	if len(problems) > 0 {
+		return "", "", nil, withCode(exitCheck, fmt.Errorf("%s has been tampered with, %d file(s) don't match its manifest - run 'tfmanage bundle verify %s' for the list", where, len(problems), key))
+	}
+	if manifest.Environment != environment {
+		return "", "", nil, usageError("%s is a bundle of %s, it can't be applied to %s", where, manifest.Environment, environment)
+	}
+	if manifest.TFVars == "" {
+		return "", "", nil, configError("%s doesn't say which of its files are the tfvars", where)
+	}
+	if err := checkBundleTerraform(ctx, a, manifest.TerraformVersion); err != nil {
+		return "", "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "tfmanage-bundle-*")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to create a temp dir for the bundle: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if keep {
+		cleanup = func() { a.out.Printf("Kept the bundle's working directory %s\n", dir) }
+	}
+	fail := func(err error) (string, string, func(), error) {
+		cleanup()
+		return "", "", nil, err
+	}
+	if err := extractBundle(data, dir); err != nil {
+		return fail(err)
+	}
+	a.out.Event("bundle-workdir", map[string]any{"environment": environment, "bucket": s.S3Bucket, "key": key, "commit": manifest.Commit, "terraform_version": manifest.TerraformVersion, "dir": dir})
+	a.out.Printf("Applying the bundle of %s at commit %s from %s\n", environment, cmp.Or(gitinfo.Short(manifest.Commit), "none"), dir)
+
+	opts := tfexec.InitOptions{Chdir: dir, NoColor: !a.out.color}
+	backendKey, managed, err := stateKey(s, environment)
+	if err != nil {
+		return fail(err)
+	}
+	if managed {
+		opts.BackendConfig = []string{"key=" + backendKey}
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.InitArgs(opts))
+	if err := tfexec.Init(ctx, runner, opts, a.streamOutput()); err != nil {
+		return fail(err)
+	}
+	return dir, filepath.Join(dir, filepath.FromSlash(manifest.TFVars)), cleanup, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+func TestBundleFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"main.tf":                          "",
+		"variables.tf":                     "",
+		".terraform.lock.hcl":              "",
+		"README.md":                        "",
+		"modules/net/main.tf":              "",
+		"modules/net/scratch.tf":           "",
+		".terraform/modules/x/main.tf":     "",
+		"examples/demo/main.tf":            "",
+		"override.tf":                      "",
+		".tfmanageignore":                  "# local only\n\n*_override.tf\noverride.tf\nexamples/\nmodules/*/scratch.tf\n",
+		"terraform.tfstate.d/prod/main.tf": "",
+	} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644)
+	}
+	got, err := bundleFiles(dir, ignoreRulesFor(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".terraform.lock.hcl", "main.tf", "modules/net/main.tf", "terraform.tfstate.d/prod/main.tf", "variables.tf"}
+	if !slices.Equal(got, want) {
+		t.Errorf("bundleFiles() = %v, want %v", got, want)
+	}
+
+	// --no-ignore still leaves out terraform's own directory
+	got, _ = bundleFiles(dir, func(string, bool) bool { return false })
+	want = []string{".terraform.lock.hcl", "examples/demo/main.tf", "main.tf", "modules/net/main.tf", "modules/net/scratch.tf", "override.tf", "terraform.tfstate.d/prod/main.tf", "variables.tf"}
+	if !slices.Equal(got, want) {
+		t.Errorf("bundleFiles() without ignore rules = %v, want %v", got, want)
+	}
+}
+
+func TestIgnoreRulesLogSkippedFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".tfmanageignore"), []byte("*.tf\n!main.tf\n"), 0o644)
+	var stderr strings.Builder
+	a := &app{out: &ui{verbose: true, stdout: io.Discard, stderr: &stderr}}
+	skip, err := a.ignoreRules(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skip("main.tf", false) || !skip("extra.tf", false) || !skip("main.tf.backup", false) {
+		t.Error("the ignore rules didn't follow .tfmanageignore and the defaults")
+	}
+	for _, want := range []string{"extra.tf, ignored by " + filepath.Join(dir, ".tfmanageignore") + ":1: *.tf", "main.tf.backup, ignored by default: *.backup"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("verbose output %q doesn't mention %q", stderr.String(), want)
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, ".tfmanageignore"), []byte("[z-a\n"), 0o644)
+	if _, err := a.ignoreRules(dir, false); exitCodeFor(err) != exitConfig {
+		t.Errorf("ignoreRules() with a bad pattern: %v, want a config error", err)
+	}
+	if _, err := a.ignoreRules(dir, true); err != nil {
+		t.Errorf("ignoreRules() with --no-ignore read the file: %v", err)
+	}
+}
+
+// ignoreRulesFor is the rules bundle uses for dir, without the logging
+
+func ignoreRulesFor(t *testing.T, dir string) skipFunc {
+	t.Helper()
+	skip, err := (&app{out: &ui{stdout: io.Discard, stderr: io.Discard}}).ignoreRules(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return skip
+}
+
+func TestBundleReproducibleAndVerified(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/main.tf", []byte("resource \"null_resource\" \"a\" {}\n"), 0o644)
+	os.WriteFile(dir+"/prod.tfvars", []byte("a = 1\n"), 0o644)
+
+	manifest := bundleManifest{Environment: "prod", Commit: "0123456789abcdef"}
+	first, m, err := buildBundle(dir, dir+"/prod.tfvars", manifest, ignoreRulesFor(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, _ := buildBundle(dir, dir+"/prod.tfvars", manifest, ignoreRulesFor(t, dir))
+	if !bytes.Equal(first, second) {
+		t.Error("bundling the same files twice gave different bytes")
+	}
+	if m.TFVars != "tfvars/prod.tfvars" || len(m.Files) != 2 {
+		t.Errorf("manifest = %+v", m)
+	}
+	if _, _, problems, err := verifyBundle(first); err != nil || len(problems) != 0 {
+		t.Errorf("verifyBundle() = %v, %v, want no problems", problems, err)
+	}
+
+	tampered, _ := writeBundle(m, []bundleEntry{{"main.tf", []byte("resource \"null_resource\" \"b\" {}\n")}, {"evil.tf", nil}})
+	_, _, problems, err := verifyBundle(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bundleProblem{{"main.tf", "has changed"}, {"tfvars/prod.tfvars", "is missing"}, {"evil.tf", "is not in the manifest"}}
+	if !slices.Equal(problems, want) {
+		t.Errorf("verifyBundle() = %v, want %v", problems, want)
+	}
+	if _, _, _, err := verifyBundle([]byte("not gzip")); err == nil {
+		t.Error("verifyBundle() of garbage gave no error")
+	}
+}
+
+func TestBundleCommand(t *testing.T) {
+	_, store := withPlanStore(t)
+	os.WriteFile("main.tf", []byte("terraform {}\n"), 0o644)
+	os.WriteFile(".terraform.lock.hcl", []byte("# lock\n"), 0o644)
+
+	if err := run([]string{"bundle", "prod", "--out-file", "prod.tar.gz"}); err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	objects, _ := store.List(context.Background(), "team/bundles/prod/")
+	if len(objects) != 1 || !strings.HasSuffix(objects[0].Key, "-0123456.tar.gz") {
+		t.Fatalf("stored %v, want one bundle named after the commit", objects)
+	}
+	key := objects[0].Key
+	info, _ := store.Head(context.Background(), key)
+	if info.KMSKeyID != planKMSKey || info.Metadata[bundleManifestMetadataKey] == "" || info.ContentType != "application/gzip" {
+		t.Errorf("stored %+v, want it encrypted with the manifest checksum", info)
+	}
+	data, _ := storage.GetBytes(context.Background(), store, key)
+	manifest, _, _, _ := verifyBundle(data)
+	if manifest.TerraformVersion != "1.6.2" || manifest.Commit != "0123456789abcdef" {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	for _, arg := range []string{key, strings.TrimPrefix(key, "team/"), "prod.tar.gz"} {
+		if err := run([]string{"bundle", "verify", arg}); err != nil {
+			t.Errorf("bundle verify %s: %v", arg, err)
+		}
+	}
+
+	// a bundle rebuilt with a changed file and a manifest to match, without the object checksum, still differs from the manifest checksum in the metadata
+	os.WriteFile("main.tf", []byte("terraform { required_version = \">= 0\" }\n"), 0o644)
+	rebuilt, _, _ := buildBundle(".", "prod.tfvars", manifest, ignoreRulesFor(t, "."))
+	delete(info.Metadata, storage.ChecksumMetadataKey)
+	store.Put(context.Background(), storage.PutInput{Key: key, Body: bytes.NewReader(rebuilt), Metadata: info.Metadata})
+	if err := run([]string{"bundle", "verify", key}); exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "tampered") {
+		t.Errorf("bundle verify of a rewritten bundle: %v, want a check failure", err)
+	}
+	if err := run([]string{"bundle", "verify", "nope.tar.gz"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("bundle verify of a missing file: %v, want a usage error", err)
+	}
+}
+
+func TestApplyFromBundle(t *testing.T) {
+	rec, store := withPlanStore(t)
+	os.WriteFile("main.tf", []byte("terraform {}\n"), 0o644)
+	if err := run([]string{"bundle", "prod"}); err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	// the bundle has its own tfvars, the local ones don't matter anymore
+	os.Remove("prod.tfvars")
+	rec.Calls = nil
+
+	if err := run([]string{"apply", "prod", "--from-bundle", "latest", "--snapshot-state=false"}); err != nil {
+		t.Fatalf("apply --from-bundle: %v", err)
+	}
+	var dir string
+	var applied []string
+	for _, c := range rec.Calls {
+		switch {
+		case slices.Contains(c.Args, "init"):
+			dir = strings.TrimPrefix(c.Args[0], "-chdir=")
+		case slices.Contains(c.Args, "apply"):
+			applied = c.Args
+		}
+	}
+	if dir == "" || applied == nil || applied[0] != "-chdir="+dir || applied[len(applied)-1] != filepath.Join(dir, "tfvars", "prod.tfvars") {
+		t.Fatalf("ran %v, want init and an apply in the bundle's directory with its tfvars", rec.Calls)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the bundle's directory %s is still there: %v", dir, err)
+	}
+
+	rec.Calls = nil
+	if err := run([]string{"apply", "prod", "--from-bundle", "latest", "--snapshot-state=false", "--keep-workdir"}); err != nil {
+		t.Fatalf("apply --keep-workdir: %v", err)
+	}
+	dir = strings.TrimPrefix(rec.Calls[len(rec.Calls)-2].Args[0], "-chdir=")
+	if data, err := os.ReadFile(filepath.Join(dir, "main.tf")); err != nil || string(data) != "terraform {}\n" {
+		t.Errorf("kept directory has main.tf = %q, %v", data, err)
+	}
+	os.RemoveAll(dir)
+
+	// a bundle of another environment is refused, even under this one's prefix
+	objects, _ := store.List(context.Background(), "team/bundles/prod/")
+	data, _ := storage.GetBytes(context.Background(), store, objects[0].Key)
+	manifest, _, _, _ := verifyBundle(data)
+	manifest.Environment = "dev"
+	os.WriteFile("prod.tfvars", []byte("a = 1\n"), 0o644)
+	other, _, _ := buildBundle(".", "prod.tfvars", manifest, ignoreRulesFor(t, "."))
+	storage.PutBytes(context.Background(), store, "team/bundles/prod/99999999T000000Z-dev.tar.gz", other)
+	if err := run([]string{"apply", "prod", "--from-bundle", "latest"}); exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "bundle of dev") {
+		t.Errorf("apply of another environment's bundle: %v, want a usage error", err)
+	}
+
+	manifest.Environment, manifest.TerraformVersion = "prod", "1.7.0"
+	newer, _, _ := buildBundle(".", "prod.tfvars", manifest, ignoreRulesFor(t, "."))
+	storage.PutBytes(context.Background(), store, "team/bundles/prod/99999999T000000Z-dev.tar.gz", newer)
+	if err := run([]string{"apply", "prod", "--from-bundle", "latest"}); exitCodeFor(err) != exitConfig || !strings.Contains(err.Error(), "terraform 1.7.0") {
+		t.Errorf("apply of a bundle made with another terraform: %v, want a config error", err)
+	}
+
+	if err := run([]string{"apply", "prod", "--from-bundle", "latest", "--plan", "p.tfplan"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("--from-bundle with --plan: %v, want a usage error", err)
+	}
+}
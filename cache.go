@@ -0,0 +1,151 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/cache"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/dirs"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// The managed cache - download --cache keeps the tfvars under the user cache dir with an index of the remote revision, plan and apply --use-cache read it back and status compares it with the bucket
+
+const (
+	statusCurrent = "CURRENT"
+	statusBehind  = "BEHIND"
+	statusAhead   = "AHEAD"
+	statusUnknown = "UNKNOWN"
+)
+
+// defaultCacheMaxAge is how old a cached file gets before --use-cache warns, cache.max_age in the config changes it
+
+const defaultCacheMaxAge = 24 * time.Hour
+
+func tfvarsCache() (cache.Cache, error) {
+	root, err := dirs.CacheDir()
+	if err != nil {
+		return cache.Cache{}, configError("failed to find the cache directory: %v", err)
+	}
+	return cache.Cache{Root: root}, nil
+}
+
+// downloadToCache downloads the environment's tfvars into the cache and records the revision it got
+
+func downloadToCache(ctx context.Context, a *app, environment, fileName string) error {
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	c, err := tfvarsCache()
+	if err != nil {
+		return err
+	}
+	loc, err := a.locate(ctx, s, environment, fileName)
+	if err != nil {
+		return err
+	}
+	key := loc.key
+	remote, err := loc.store.Head(ctx, key)
+	if err != nil {
+		return err
+	}
+	a.logKMSKey(s, environment)
+	a.checkObjectKey(loc, remote)
+
+	local := c.Path(tfvarsCacheName(s, environment), environment, fileName)
+	if err := os.MkdirAll(filepath.Dir(local), 0o700); err != nil {
+		return fmt.Errorf("failed to create the cache directory: %w", err)
+	}
+	a.out.Printf("Downloading %s from %s to the cache...\n", fileName, loc.service)
+	numBytes, err := storage.DownloadKey(ctx, loc.store, key, local)
+	if err != nil {
+		return kmsDecryptError(err, cmp.Or(remote.KMSKeyID, loc.kmsKey))
+	}
+	sum, err := storage.FileChecksum(local)
+	if err != nil {
+		return err
+	}
+	entry := cache.Entry{Key: key, File: local, ETag: remote.ETag, VersionID: remote.VersionID, SHA256: sum, DownloadedAt: time.Now().UTC()}
+	if err := c.Write(tfvarsCacheName(s, environment), environment, entry); err != nil {
+		return err
+	}
+	a.out.Event("download", map[string]any{"file": local, "bucket": loc.bucket, "key": key, "bytes": numBytes, "cache": true, "etag": remote.ETag, "version_id": remote.VersionID})
+	a.out.Successf("Cached %s as %s (%d bytes)", fileName, local, numBytes)
+	return nil
+}
+
+// varFile is prepare for plan and apply - with useCache it gives back the cached copy instead of the tfvars path
+
+func (a *app) varFile(ctx context.Context, operation, environment string, useCache bool) (string, error) {
+	if !useCache {
+		return a.prepare(operation, environment)
+	}
+	fileName, err := a.tfvarsFor(environment)
+	if err != nil {
+		return "", err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return "", err
+	}
+	c, err := tfvarsCache()
+	if err != nil {
+		return "", err
+	}
+	entry, err := c.Read(tfvarsCacheName(s, environment), environment)
+	if errors.Is(err, cache.ErrNotCached) {
+		return "", configError("%v, run 'tfmanage download %s --cache' first", err, environment)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	maxAge := s.CacheMaxAge
+	if maxAge == 0 {
+		maxAge = defaultCacheMaxAge
+	}
+	if age := time.Since(entry.DownloadedAt); age > maxAge {
+		a.out.Warnf("The cached %s was downloaded %s ago, run 'tfmanage download %s --cache' to refresh it", fileName, age.Round(time.Minute), environment)
+	}
+	switch status, detail := cacheStatus(ctx, s, environment, entry); status {
+	case statusBehind, statusAhead:
+		a.out.Warnf("The cached %s is %s: %s", fileName, status, detail)
+	case statusUnknown:
+		a.out.Verbosef("Could not compare the cached %s with the bucket: %s\n", fileName, detail)
+	}
+	a.out.Printf("Using the cached %s downloaded at %s\n", fileName, entry.DownloadedAt.Format(time.RFC3339))
+	return entry.File, nil
+}
+
+// cacheStatus compares a cached file with its index and the bucket. AHEAD means the cached copy was edited, BEHIND that the bucket has a newer revision
+
+func cacheStatus(ctx context.Context, s settings, environment string, entry cache.Entry) (string, string) {
+	sum, err := storage.FileChecksum(entry.File)
+	if err != nil {
+		return statusUnknown, err.Error()
+	}
+	if sum != entry.SHA256 {
+		return statusAhead, "the cached file was changed after it was downloaded"
+	}
+	if requirementsError("download", checkStoreRequirements("download", environment, s)) != nil {
+		return statusUnknown, "the bucket settings are not set"
+	}
+	loc, err := tfvarsStore(ctx, s, environment, entry.File)
+	if err != nil {
+		return statusUnknown, err.Error()
+	}
+	remote, err := loc.store.Head(ctx, entry.Key)
+	if err != nil {
+		return statusUnknown, err.Error()
+	}
+	if remote.ETag != entry.ETag {
+		return statusBehind, fmt.Sprintf("%s was changed at %s", loc.url(entry.Key), remote.LastModified.Format(time.RFC3339))
+	}
+	return statusCurrent, ""
+}
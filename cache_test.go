@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/cache"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestDownloadToCache(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	store := withMemoryStore(t)
+	cacheHome := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheHome)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	ctx := context.Background()
+	store.Put(ctx, storage.PutInput{Key: "team/prod.tfvars", Body: strings.NewReader("a = 1\n")})
+
+	if err := run([]string{"plan", "prod", "out.tfplan", "--use-cache"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("--use-cache before downloading: %v, want a config error", err)
+	}
+	if err := run([]string{"download", "prod", "--cache"}); err != nil {
+		t.Fatalf("download --cache: %v", err)
+	}
+	if _, err := os.Stat("prod.tfvars"); !os.IsNotExist(err) {
+		t.Errorf("download --cache wrote the tfvars path too")
+	}
+	c := cache.Cache{Root: filepath.Join(cacheHome, "tfmanage")}
+	entry, err := c.Read("tfvars-bucket", "prod")
+	if err != nil || entry.Key != "team/prod.tfvars" || entry.ETag == "" || entry.VersionID != "v1" {
+		t.Fatalf("cache entry = %+v, %v", entry, err)
+	}
+
+	if err := run([]string{"plan", "prod", "out.tfplan", "--use-cache"}); err != nil {
+		t.Fatalf("plan --use-cache: %v", err)
+	}
+	if args := rec.Calls[0].Args; args[2] != entry.File {
+		t.Errorf("plan ran %q, want the cached %s", args, entry.File)
+	}
+
+	statuses := func() map[string]string {
+		t.Helper()
+		var stdout bytes.Buffer
+		if err := runWithUI([]string{"--output", "json", "status", "--exit-zero"}, &ui{json: true, stdout: &stdout, stderr: io.Discard}); err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		got := map[string]string{}
+		for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+			var ev map[string]any
+			json.Unmarshal([]byte(line), &ev)
+			if ev["event"] == "cache-status" {
+				got[ev["environment"].(string)] = ev["status"].(string)
+			}
+		}
+		return got
+	}
+	if got := statuses(); got["prod"] != statusCurrent || got["dev"] != statusUnknown {
+		t.Errorf("statuses = %v", got)
+	}
+
+	store.Put(ctx, storage.PutInput{Key: "team/prod.tfvars", Body: strings.NewReader("a = 2\n")})
+	if got := statuses(); got["prod"] != statusBehind {
+		t.Errorf("status after the remote changed = %v", got)
+	}
+	os.WriteFile(entry.File, []byte("a = 3\n"), 0o644)
+	if got := statuses(); got["prod"] != statusAhead {
+		t.Errorf("status after the cached copy changed = %v", got)
+	}
+}
+
+func TestUseCacheWarnsWhenOld(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	withMemoryStore(t)
+	cacheHome := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheHome)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	os.WriteFile("tfmanage.yaml", []byte("cache:\n  max_age: 1h\n"), 0o644)
+
+	c := cache.Cache{Root: filepath.Join(cacheHome, "tfmanage")}
+	file := c.Path("tfvars-bucket", "dev", "dev.tfvars")
+	os.MkdirAll(filepath.Dir(file), 0o700)
+	os.WriteFile(file, []byte("a = 1\n"), 0o644)
+	sum, _ := storage.FileChecksum(file)
+	c.Write("tfvars-bucket", "dev", cache.Entry{Key: "team/dev.tfvars", File: file, SHA256: sum, DownloadedAt: time.Now().Add(-2 * time.Hour)})
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"plan", "dev", "out.tfplan", "--use-cache"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("plan --use-cache: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "was downloaded 2h0m0s ago") {
+		t.Errorf("plan printed %q, want the staleness warning", stdout.String())
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+)
+
+// change messages - upload -m says why the tfvars changed the way a commit message does, it goes in the object metadata and the change journal. require_change_message refuses uploads without one
+
+const (
+	changeMessageEnv         = "TFM_CHANGE_MESSAGE"
+	changeMessageMetadataKey = "change-message"
+	// maxChangeMessage is the most a message takes in the metadata, S3 allows 2KB for all of it and SSM 1KB for the description it goes in
+	maxChangeMessage = 256
+)
+
+// changeMessage is the message of an upload. With --ci it can come from TFM_CHANGE_MESSAGE or the subject of the commit the tfvars are in, which is cut down to fit
+
+func changeMessage(ctx context.Context, s settings, environment, fileName, message string, ci bool) (string, error) {
+	message = strings.TrimSpace(message)
+	if message == "" && ci {
+		message = strings.TrimSpace(os.Getenv(changeMessageEnv))
+		if message == "" {
+			subject := gitinfo.Subject(ctx, filepath.Dir(fileName))
+			for len(encodeMetadataValue(subject)) > maxChangeMessage {
+				runes := []rune(subject)
+				subject = strings.TrimSpace(string(runes[:len(runes)-1]))
+			}
+			message = subject
+		}
+	}
+	if message == "" {
+		if s.Terraform[environment].RequireChangeMessage {
+			hint := "pass -m \"what changed and why\""
+			if ci {
+				hint = "set " + changeMessageEnv + " or run from a git checkout so the commit subject can be used"
+			}
+			return "", usageError("uploads of %s need a change message, environments.%s.require_change_message is set - %s", environment, environment, hint)
+		}
+		return "", nil
+	}
+	if strings.ContainsFunc(message, unicode.IsControl) {
+		return "", usageError("the change message has to be a single line without control characters")
+	}
+	if n := len(encodeMetadataValue(message)); n > maxChangeMessage {
+		return "", usageError("the change message takes %d bytes in the object metadata, at most %d fit - shorten it", n, maxChangeMessage)
+	}
+	return message, nil
+}
+
+// encodeMetadataValue keeps a value S3 can store as a header, anything that isn't ASCII is encoded the way RFC 2047 says, which is what S3 asks for
+
+func encodeMetadataValue(value string) string {
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+// decodeMetadataValue undoes encodeMetadataValue, a value that doesn't decode is given back as it is
+
+func decodeMetadataValue(value string) string {
+	var dec mime.WordDecoder
+	decoded, err := dec.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadChangeMessage(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	withCaller(t, deployerARN)
+	t.Setenv(changeMessageEnv, "")
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    tfvars: prod.tfvars\n    require_change_message: true\n    require_clean_git: false\n"), 0o644)
+	os.WriteFile("prod.tfvars", []byte("replicas = 2\n"), 0o644)
+
+	err := run([]string{"upload", "prod"})
+	if exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), `-m "what changed and why"`) {
+		t.Fatalf("upload without -m: %v, want a usage error with a hint", err)
+	}
+	if store.Puts() != 0 {
+		t.Fatal("the tfvars were uploaded without a change message")
+	}
+	if err := run([]string{"upload", "prod", "-m", strings.Repeat("x", maxChangeMessage+1)}); exitCodeFor(err) != exitUsage {
+		t.Errorf("upload with a message too long for the metadata: %v, want a usage error", err)
+	}
+
+	if err := run([]string{"upload", "prod", "-m", "Größere Instanzen für den Black Friday"}); err != nil {
+		t.Fatalf("upload -m: %v", err)
+	}
+	info, err := store.Head(context.Background(), "team/prod.tfvars")
+	if err != nil || info.Metadata[changeMessageMetadataKey] == "" || strings.ContainsFunc(info.Metadata[changeMessageMetadataKey], func(r rune) bool { return r > 127 }) {
+		t.Fatalf("metadata = %v, %v, want the message encoded as ASCII", info.Metadata, err)
+	}
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"versions", "prod"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("versions: %v", err)
+	}
+	if !strings.Contains(stdout.String(), `change-message="Größere Instanzen für den Black Friday"`) {
+		t.Errorf("versions printed:\n%s", stdout.String())
+	}
+	stdout.Reset()
+	if err := runWithUI([]string{"changes", "prod"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("changes: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "    Größere Instanzen für den Black Friday\n") {
+		t.Errorf("changes printed:\n%s", stdout.String())
+	}
+
+	// in CI the message can come from the pipeline
+	os.WriteFile("prod.tfvars", []byte("replicas = 3\n"), 0o644)
+	t.Setenv(changeMessageEnv, "Deploy #42")
+	if err := run([]string{"upload", "prod", "--ci"}); err != nil {
+		t.Fatalf("upload --ci: %v", err)
+	}
+	if info, _ := store.Head(context.Background(), "team/prod.tfvars"); info.Metadata[changeMessageMetadataKey] != "Deploy #42" {
+		t.Errorf("metadata = %v, want the message from %s", info.Metadata, changeMessageEnv)
+	}
+}
+
+func TestChangeMessageFromCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	inTempDir(t)
+	dir, _ := os.Getwd()
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+	t.Setenv(changeMessageEnv, "")
+	os.WriteFile("prod.tfvars", []byte("replicas = 2\n"), 0o644)
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "test"},
+		{"add", "prod.tfvars"},
+		{"commit", "-q", "-m", "Scale prod to 2 replicas\n\nThe body isn't used."},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	s := settings{}
+	if got, err := changeMessage(context.Background(), s, "prod", "prod.tfvars", "", true); err != nil || got != "Scale prod to 2 replicas" {
+		t.Errorf("changeMessage() in CI = %q, %v, want the commit subject", got, err)
+	}
+	if got, err := changeMessage(context.Background(), s, "prod", "prod.tfvars", "", false); err != nil || got != "" {
+		t.Errorf("changeMessage() outside CI = %q, %v, want none", got, err)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/linediff"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// change journal - every upload of an environment's tfvars leaves a redacted unified diff next to them under changes/<env>/, so what changed and who changed it can be read without downloading two versions. changes prints the newest ones
+
+const (
+	changesPrefix       = "changes"
+	defaultChangesLimit = 10
+	// maxChangeDiff is how much of a diff the journal keeps, a bigger one is cut at a line with a note saying how much is missing
+	maxChangeDiff = 64 << 10
+	changeContext = 3
+	// changeTimeFormat names the entries, down to the nanosecond so uploads in the same second don't replace each other's
+	changeTimeFormat = "20060102T150405.000000000Z"
+)
+
+// the metadata of a journal entry, it links the versions before and after the upload
+
+const (
+	changeKindMetadataKey    = "change"
+	beforeVersionMetadataKey = "before-version"
+	afterVersionMetadataKey  = "after-version"
+	uploaderMetadataKey      = "uploader-arn"
+)
+
+const (
+	changeCreated = "created"
+	changeChanged = "changed"
+)
+
+// changeJournal is the store and prefix of the environment's journal, next to the tfvars in a bucket or a local directory. SSM and Secrets Manager have no room for one, ok is false for them
+
+func changeJournal(loc tfvarsLocation, environment, fileName string) (store storage.Backend, prefix string, ok bool) {
+	switch {
+	case loc.bucket != "":
+		return loc.store, strings.TrimSuffix(loc.key, fileName) + changesPrefix + "/" + environment + "/", true
+	case loc.scheme == fileScheme:
+		return loc.store, changesPrefix + "/" + environment + "/", true
+	}
+	return nil, "", false
+}
+
+// previousUpload is what an upload replaces, exists is false for the first upload of the file
+
+type previousUpload struct {
+	exists    bool
+	versionID string
+	data      []byte
+}
+
+// readPrevious reads the tfvars an upload is about to replace. ok is false when they can't be read, the upload then goes ahead without a journal entry
+
+func (a *app) readPrevious(ctx context.Context, loc tfvarsLocation) (previousUpload, bool) {
+	info, err := loc.store.Head(ctx, loc.key)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return previousUpload{}, true
+	}
+	if err == nil {
+		var data []byte
+		if data, err = storage.GetBytes(ctx, loc.store, loc.key); err == nil {
+			return previousUpload{exists: true, versionID: info.VersionID, data: data}, true
+		}
+	}
+	a.out.Warnf("Could not read the current %s, the upload won't be in the change journal: %v", loc.url(loc.key), err)
+	return previousUpload{}, false
+}
+
+// recordChange writes the journal entry of an upload. The upload is done by now, so a journal that can't be written only warns
+
+func (a *app) recordChange(ctx context.Context, s settings, environment, fileName, message string, loc tfvarsLocation, prev previousUpload, res storage.UploadResult) {
+	store, prefix, ok := changeJournal(loc, environment, fileName)
+	if !ok {
+		return
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		a.out.Warnf("Could not record the upload in the change journal: %v", err)
+		return
+	}
+	kind, body := changeCreated, fmt.Sprintf("%s was created, there is no earlier version to compare it with\n", fileName)
+	if prev.exists {
+		diff := linediff.Unified("a/"+fileName, "b/"+fileName, prev.data, data, changeContext)
+		if diff == "" {
+			a.out.Verbosef("%s has the same lines as before, nothing to record in the change journal\n", fileName)
+			return
+		}
+		kind, body = changeChanged, truncateDiff(redactDiff(diff, prev.data, data), maxChangeDiff)
+	}
+	uploader, err := callerIdentity(ctx, s)
+	if err != nil {
+		a.out.Verbosef("Could not tell who is uploading, the change journal says %s: %v\n", unknownField, err)
+		uploader = unknownField
+	}
+	metadata := map[string]string{changeKindMetadataKey: kind, uploaderMetadataKey: uploader}
+	if prev.versionID != "" {
+		metadata[beforeVersionMetadataKey] = prev.versionID
+	}
+	if res.VersionID != "" {
+		metadata[afterVersionMetadataKey] = res.VersionID
+	}
+	if message != "" {
+		metadata[changeMessageMetadataKey] = encodeMetadataValue(message)
+	}
+	key := prefix + time.Now().UTC().Format(changeTimeFormat) + ".diff"
+	opts := storage.UploadOptions{Metadata: metadata}
+	if loc.bucket != "" {
+		opts.KMSKeyID = loc.kmsKey
+	}
+	if _, err := storage.PutBytesWith(ctx, store, key, []byte(body), opts); err != nil {
+		a.out.Warnf("Could not record the upload in the change journal: %v", err)
+		return
+	}
+	a.out.Verbosef("Recorded the change in %s\n", loc.url(key))
+}
+
+// redactDiff masks the lines of a diff the way terraform's output is masked, and the values either version gives the sensitive variables declared in the working directory
+
+func redactDiff(diff string, versions ...[]byte) string {
+	r := redact.NewRedactor()
+	if names, err := redact.SensitiveVariables("."); err == nil && len(names) > 0 {
+		for _, data := range versions {
+			r.Add(redact.TFVarsValues(data, names)...)
+		}
+	}
+	var out strings.Builder
+	for line := range strings.Lines(diff) {
+		out.WriteString(r.Mask(redact.Line(strings.TrimSuffix(line, "\n"))))
+		out.WriteByte('\n')
+	}
+	return out.String()
+}
+
+// truncateDiff cuts a diff over limit bytes at the last whole line that fits
+
+func truncateDiff(diff string, limit int) string {
+	if len(diff) <= limit {
+		return diff
+	}
+	cut := strings.LastIndexByte(diff[:limit], '\n') + 1
+	missing := strings.Count(diff[cut:], "\n")
+	return diff[:cut] + fmt.Sprintf("... the diff is over %d KiB, the other %d lines of it are left out\n", limit>>10, missing)
+}
+
+// changeEntry is one upload in the journal
+
+type changeEntry struct {
+	Key           string    `json:"key"`
+	At            time.Time `json:"at"`
+	Change        string    `json:"change"`
+	BeforeVersion string    `json:"before_version,omitempty"`
+	AfterVersion  string    `json:"after_version,omitempty"`
+	Uploader      string    `json:"uploader"`
+	Message       string    `json:"message,omitempty"`
+	Diff          string    `json:"diff"`
+}
+
+func changesCommand() *command {
+	return &command{
+		name:    "changes",
+		args:    "<env>",
+		summary: "Print the newest changes to the environment's tfvars from the change journal every upload writes, as redacted unified diffs.",
+		examples: []string{
+			"tfmanage changes prod",
+			"tfmanage changes staging --limit 3 --output json",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			limit := fs.Int("limit", defaultChangesLimit, "print at most this many changes")
+			return func(ctx context.Context, a *app, args []string) error {
+				if *limit < 1 {
+					return usageError("--limit has to be at least 1")
+				}
+				fileName, err := a.tfvarsFor(args[0])
+				if err != nil {
+					return err
+				}
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				if err := requirementsError("changes", checkStoreRequirements("download", args[0], s)); err != nil {
+					return err
+				}
+				loc, err := a.locate(ctx, s, args[0], fileName)
+				if err != nil {
+					return err
+				}
+				store, prefix, ok := changeJournal(loc, args[0], fileName)
+				if !ok {
+					return usageError("%s keeps its tfvars in %s, which has no change journal - only a bucket or a local directory has one", args[0], loc.service)
+				}
+				entries, err := changeEntries(ctx, store, prefix, *limit)
+				if err != nil {
+					return err
+				}
+				return a.printChanges(args[0], entries)
+			}
+		},
+	}
+}
+
+// changeEntries reads the newest limit entries of a journal, newest first
+
+func changeEntries(ctx context.Context, store storage.Backend, prefix string, limit int) ([]changeEntry, error) {
+	entries, err := listChanges(ctx, store, prefix)
+	if err != nil {
+		return nil, err
+	}
+	entries = entries[:min(len(entries), limit)]
+	for i := range entries {
+		if err := readChange(ctx, store, &entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// listChanges gives the entries of a journal newest first, with only their key and time
+
+func listChanges(ctx context.Context, store storage.Backend, prefix string) ([]changeEntry, error) {
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var entries []changeEntry
+	for _, o := range objects {
+		if !strings.HasSuffix(o.Key, ".diff") {
+			continue
+		}
+		entry := changeEntry{Key: o.Key, At: o.LastModified}
+		if t, err := time.Parse(changeTimeFormat, strings.TrimSuffix(path.Base(o.Key), ".diff")); err == nil {
+			entry.At = t
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(x, y changeEntry) int {
+		if c := y.At.Compare(x.At); c != 0 {
+			return c
+		}
+		return strings.Compare(y.Key, x.Key)
+	})
+	return entries, nil
+}
+
+// readChange fills in an entry from its metadata and diff, listings don't carry the metadata in every backend
+
+func readChange(ctx context.Context, store storage.Backend, e *changeEntry) error {
+	info, err := store.Head(ctx, e.Key)
+	if err != nil {
+		return err
+	}
+	data, err := storage.GetBytes(ctx, store, e.Key)
+	if err != nil {
+		return err
+	}
+	e.Change = cmp.Or(info.Metadata[changeKindMetadataKey], unknownField)
+	e.BeforeVersion, e.AfterVersion = info.Metadata[beforeVersionMetadataKey], info.Metadata[afterVersionMetadataKey]
+	e.Uploader = cmp.Or(info.Metadata[uploaderMetadataKey], unknownField)
+	e.Message = decodeMetadataValue(info.Metadata[changeMessageMetadataKey])
+	e.Diff = string(data)
+	return nil
+}
+
+func (a *app) printChanges(environment string, entries []changeEntry) error {
+	for _, e := range entries {
+		a.out.Event("change", map[string]any{"environment": environment, "key": e.Key, "at": e.At, "change": e.Change, "before_version": e.BeforeVersion, "after_version": e.AfterVersion, "uploader": e.Uploader, "message": e.Message, "diff": e.Diff})
+	}
+	if a.out.json {
+		return nil
+	}
+	if len(entries) == 0 {
+		a.out.Printf("No changes to %s are in the change journal yet, it is written by upload\n", environment)
+		return nil
+	}
+	for i, e := range entries {
+		if i > 0 {
+			a.out.Printf("\n")
+		}
+		versions := ""
+		if e.BeforeVersion != "" || e.AfterVersion != "" {
+			versions = fmt.Sprintf(" (version %s -> %s)", cmp.Or(e.BeforeVersion, "none"), cmp.Or(e.AfterVersion, unknownField))
+		}
+		a.out.Printf("%s %s by %s%s\n", e.At.Format(time.RFC3339), e.Change, e.Uploader, versions)
+		if e.Message != "" {
+			a.out.Printf("    %s\n", e.Message)
+		}
+		for line := range strings.Lines(e.Diff) {
+			a.out.DiffLine(strings.TrimSuffix(line, "\n"))
+		}
+	}
+	return nil
+}
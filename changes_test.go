@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestChangeJournal(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	withCaller(t, deployerARN)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	os.WriteFile("variables.tf", []byte("variable \"db_pass\" {\n  sensitive = true\n}\n"), 0o644)
+
+	for _, content := range []string{
+		"instance_type = \"t3.small\"\ndb_pass = \"hunter2-old\"\n",
+		"instance_type = \"t3.large\"\ndb_pass = \"hunter2-new\"\n",
+	} {
+		os.WriteFile("dev.tfvars", []byte(content), 0o644)
+		if err := run([]string{"upload", "dev"}); err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+	}
+	// unchanged, so the upload is skipped and so is the journal
+	if err := run([]string{"upload", "dev", "--skip-unchanged"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	entries, err := store.List(context.Background(), "team/changes/dev/")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("journal = %+v, %v, want an entry for each upload", entries, err)
+	}
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"--output", "json", "changes", "dev"}, &ui{json: true, stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("changes: %v", err)
+	}
+	var changes []changeEntry
+	for line := range strings.Lines(stdout.String()) {
+		var event struct {
+			Event string `json:"event"`
+			changeEntry
+		}
+		if json.Unmarshal([]byte(line), &event); event.Event == "change" {
+			changes = append(changes, event.changeEntry)
+		}
+	}
+	if len(changes) != 2 {
+		t.Fatalf("changes printed %d entries, want 2:\n%s", len(changes), stdout.String())
+	}
+	latest, first := changes[0], changes[1]
+	if first.Change != changeCreated || first.BeforeVersion != "" || first.Uploader != deployerARN {
+		t.Errorf("first entry = %+v, want created by %s", first, deployerARN)
+	}
+	if latest.Change != changeChanged || latest.BeforeVersion != "v1" || latest.AfterVersion != "v3" || latest.Uploader != deployerARN {
+		t.Errorf("latest entry = %+v, want changed from v1 to v3 by %s", latest, deployerARN)
+	}
+	if !strings.Contains(latest.Diff, "\n-instance_type = \"t3.small\"\n") || !strings.Contains(latest.Diff, "\n+instance_type = \"t3.large\"\n") {
+		t.Errorf("diff doesn't show the change:\n%s", latest.Diff)
+	}
+	if strings.Contains(latest.Diff, "hunter2") {
+		t.Errorf("diff shows the sensitive value:\n%s", latest.Diff)
+	}
+
+	stdout.Reset()
+	if err := runWithUI([]string{"changes", "dev", "--limit", "1"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("changes --limit 1: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "changed by "+deployerARN+" (version v1 -> v3)") || strings.Contains(out, "created") {
+		t.Errorf("changes --limit 1 printed:\n%s", out)
+	}
+	if err := run([]string{"changes", "dev", "--limit", "0"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("changes --limit 0: %v, want a usage error", err)
+	}
+}
+
+func TestTruncateDiff(t *testing.T) {
+	diff := "--- a\n+++ b\n@@ -1 +1 @@\n-x = 1\n+x = 2\n"
+	if got := truncateDiff(diff, 1<<10); got != diff {
+		t.Errorf("truncateDiff() of a short diff = %q", got)
+	}
+	want := "--- a\n+++ b\n... the diff is over 0 KiB, the other 3 lines of it are left out\n"
+	if got := truncateDiff(diff, 16); got != want {
+		t.Errorf("truncateDiff() = %q, want %q", got, want)
+	}
+}
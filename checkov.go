@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+// errScanFailed is returned when checkov failed checks at or above the threshold, they have been printed already
+
+var errScanFailed = withCode(exitCheck, errors.New("checkov found failed checks at or above the fail-on severity"))
+
+// defaultCheckovFailOn is used when neither --checkov-fail-on nor hooks.checkov_fail_on is set
+
+const defaultCheckovFailOn = "HIGH"
+
+// scanSteps is how the checkov scan before an apply is set up
+
+type scanSteps struct {
+	enabled bool
+	failOn  string
+	binary  string
+}
+
+// checkovConfigFile finds the .checkov.yaml next to the terraform code so skips in it count
+
+func checkovConfigFile(dir string) string {
+	for _, name := range []string{".checkov.yaml", ".checkov.yml"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// runCheckov scans the exported plan and prints the failed checks by severity
+
+func runCheckov(ctx context.Context, a *app, env string, scan scanSteps, dir, planJSON string) error {
+	configFile := checkovConfigFile(dir)
+	a.out.Printf("Scanning the plan with checkov...\n")
+	a.out.Verbosef("Running checkov %v\n", tools.CheckovArgs(planJSON, configFile))
+	res, err := tools.Checkov(ctx, toolRunner, scan.binary, dir, planJSON, configFile)
+	if err != nil {
+		return err
+	}
+	failing := res.FailsAt(scan.failOn)
+	a.out.Event("checkov", map[string]any{"environment": env, "failed": res.Failed, "passed": res.Passed, "skipped": res.Skipped, "fail_on": scan.failOn})
+
+	if len(res.Failed) == 0 {
+		a.out.Successf("checkov: %d passed, %d skipped, none failed", res.Passed, res.Skipped)
+		return nil
+	}
+	a.out.Printf("checkov: %d passed, %d skipped, %d failed:\n", res.Passed, res.Skipped, len(res.Failed))
+	// most severe first, the ones without a severity count as failing so they lead
+	order := slices.Clone(tools.CheckovSeverities)
+	slices.Reverse(order)
+	for _, severity := range append([]string{""}, order...) {
+		for _, f := range res.Failed {
+			if f.Severity != severity {
+				continue
+			}
+			label := severity
+			if label == "" {
+				label = "NO SEVERITY"
+			}
+			level := "warning"
+			if slices.Contains(failing, f) {
+				level = "error"
+			}
+			a.out.Finding(level, "", 0, fmt.Sprintf("[%s] %s %s: %s", label, f.ID, f.Resource, f.Name))
+		}
+	}
+	if len(failing) > 0 {
+		return errScanFailed
+	}
+	return nil
+}
+
+// checkovFailOn picks the threshold and checks it is one checkov knows
+
+func checkovFailOn(flagValue, configValue string) (string, error) {
+	failOn := flagValue
+	if failOn == "" {
+		failOn = configValue
+	}
+	if failOn == "" {
+		failOn = defaultCheckovFailOn
+	}
+	if !tools.ValidCheckovSeverity(failOn) {
+		return "", usageError("unknown checkov severity %q, use one of %s", failOn, strings.Join(tools.CheckovSeverities, ", "))
+	}
+	return strings.ToUpper(failOn), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+const checkovFindings = `{"results": {"failed_checks": [
+  {"check_id": "CKV_AWS_20", "check_name": "S3 bucket allows public read", "severity": "HIGH", "resource": "aws_s3_bucket.logs"},
+  {"check_id": "CKV_AWS_18", "check_name": "S3 bucket has no access logging", "severity": "LOW", "resource": "aws_s3_bucket.logs"}
+]}, "summary": {"passed": 4, "failed": 2, "skipped": 1}}`
+
+func withCheckovRunners(t *testing.T) (*tfexec.RecordingRunner, *tools.RecordingRunner) {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{Output: `{"format_version":"1.2"}`}
+	checkov := &tools.RecordingRunner{Result: func(tools.Command) ([]byte, error) {
+		return []byte(checkovFindings), &tools.Error{Tool: "checkov", ExitCode: 1}
+	}}
+	useRunner(t, rec)
+	useTools(t, checkov)
+	withTFVars(t, "dev")
+	return rec, checkov
+}
+
+func TestCheckovFailsApplyAtThreshold(t *testing.T) {
+	rec, checkov := withCheckovRunners(t)
+	os.WriteFile(".checkov.yaml", []byte("skip-check:\n  - CKV_AWS_144\n"), 0o644)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"apply", "dev", "--checkov"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if !errors.Is(err, errScanFailed) || exitCodeFor(err) != exitCheck {
+		t.Fatalf("apply: %v, want the scan failure", err)
+	}
+	if calls := rec.Args(); len(calls) != 2 {
+		t.Errorf("terraform calls = %q, want plan and show only", calls)
+	}
+	args := checkov.Calls[0].Args
+	if args[len(args)-1] != ".checkov.yaml" {
+		t.Errorf("checkov args = %q, want the config file passed on", args)
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "checkov: 4 passed, 1 skipped, 2 failed:") || strings.Index(out, "CKV_AWS_20") > strings.Index(out, "CKV_AWS_18") {
+		t.Errorf("output = %q, want the summary with HIGH before LOW", out)
+	}
+}
+
+func TestCheckovBelowThresholdApplies(t *testing.T) {
+	rec, _ := withCheckovRunners(t)
+
+	if err := run([]string{"apply", "dev", "--checkov-fail-on", "critical"}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if calls := rec.Args(); len(calls) != 4 || calls[2][0] != "apply" {
+		t.Errorf("terraform calls = %q", calls)
+	}
+	if err := run([]string{"apply", "dev", "--checkov-fail-on", "severe"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("unknown severity gave %v, want a usage error", err)
+	}
+}
+
+func TestCheckovOffByDefault(t *testing.T) {
+	rec, checkov := withCheckovRunners(t)
+
+	if err := run([]string{"apply", "dev"}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if len(checkov.Calls) != 0 || len(rec.Calls) != 2 {
+		t.Errorf("checkov calls = %d, terraform calls = %q", len(checkov.Calls), rec.Args())
+	}
+}
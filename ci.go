@@ -0,0 +1,129 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// require_ci - applies to an environment with require_ci only run from a CI system. --break-glass gets around it in an emergency, after the environment name is typed, and leaves a record with the reason in the bucket's audit trail
+
+// ciSystemVariables are set by the CI systems require_ci knows, ci_variable adds one more
+
+var ciSystemVariables = []string{"GITHUB_ACTIONS", "GITLAB_CI", "BUILDKITE"}
+
+// auditPrefix is where the audit trail is kept under S3_PATH, one folder per environment
+
+const auditPrefix = "audit"
+
+func ciVariables(s settings) []string {
+	if s.CIVariable == "" {
+		return ciSystemVariables
+	}
+	return append(ciSystemVariables[:len(ciSystemVariables):len(ciSystemVariables)], s.CIVariable)
+}
+
+// ciSystem is the variable that says which CI system this is, CI=true has to be set as well
+
+func ciSystem(s settings) (string, bool) {
+	if os.Getenv("CI") != "true" {
+		return "", false
+	}
+	for _, name := range ciVariables(s) {
+		if os.Getenv(name) != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// breakGlassRecord is one use of --break-glass in the audit trail
+
+type breakGlassRecord struct {
+	Environment string    `json:"environment"`
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason"`
+	Caller      string    `json:"caller"`
+	User        string    `json:"user,omitempty"`
+	Host        string    `json:"host,omitempty"`
+	Commit      string    `json:"commit,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// checkCI refuses action on an environment with require_ci outside CI. With breakGlass it asks for the environment name instead, and only goes ahead once the record of it is in the audit trail
+
+func (a *app) checkCI(ctx context.Context, environment, action string, breakGlass bool, reason string) error {
+	if reason != "" && !breakGlass {
+		return usageError("--reason goes with --break-glass")
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	if !s.Terraform[environment].RequireCI {
+		if breakGlass {
+			return usageError("--break-glass is only for environments with require_ci, %s doesn't have it", environment)
+		}
+		return nil
+	}
+	if name, ok := ciSystem(s); ok {
+		a.out.Verbosef("Running in CI (CI=true and %s is set), %s of %s is allowed\n", name, action, environment)
+		return nil
+	}
+	if !breakGlass {
+		return withCode(exitCheck, fmt.Errorf("%s of %s only runs from CI, environments.%s.require_ci is set - CI=true and one of %s have to be set. Run it from the pipeline, or in an emergency pass --break-glass --reason \"...\", which is recorded in the audit trail", action, environment, environment, strings.Join(ciVariables(s), ", ")))
+	}
+	if strings.TrimSpace(reason) == "" {
+		return usageError("--break-glass needs --reason saying why %s of %s can't wait for CI", action, environment)
+	}
+	if a.terminalInput() == nil {
+		return usageError("--break-glass needs %s typed at a terminal, it can't be used without one", environment)
+	}
+	if err := a.askToConfirm(environment, action+" with --break-glass", fmt.Sprintf("%s of %s is only allowed from CI. Breaking glass records your identity and reason in the audit trail.", action, environment)); err != nil {
+		return err
+	}
+	return recordBreakGlass(ctx, a, s, environment, action, reason)
+}
+
+func recordBreakGlass(ctx context.Context, a *app, s settings, environment, action, reason string) error {
+	if err := requirementsError("audit trail", checkRequirements("audit trail", "", s)); err != nil {
+		return err
+	}
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return err
+	}
+	caller, err := callerIdentity(ctx, s)
+	if err != nil {
+		return err
+	}
+	record := breakGlassRecord{
+		Environment: environment,
+		Action:      action,
+		Reason:      reason,
+		Caller:      caller,
+		User:        cmp.Or(os.Getenv("USER"), os.Getenv("USERNAME")),
+		Commit:      gitinfo.Commit(ctx),
+		At:          time.Now().UTC(),
+	}
+	record.Host, _ = os.Hostname()
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the audit record: %w", err)
+	}
+	key := storage.Key(s.S3Path, path.Join(auditPrefix, environment, record.At.Format(planTimeFormat)+"-break-glass.json"))
+	if _, err := storage.PutBytes(ctx, store, key, data); err != nil {
+		return fmt.Errorf("failed to write the audit record, not breaking glass: %w", err)
+	}
+	a.out.Event("break-glass", map[string]any{"environment": environment, "action": action, "reason": reason, "caller": caller, "bucket": s.S3Bucket, "key": key})
+	a.out.Warnf("Breaking glass: %s of %s outside CI as %s, recorded in s3://%s/%s", action, environment, caller, s.S3Bucket, key)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// withoutCI clears whatever CI the tests themselves run in
+
+func withoutCI(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"CI", "GITHUB_ACTIONS", "GITLAB_CI", "BUILDKITE", "JENKINS_URL"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestRequireCI(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	store := withMemoryStore(t)
+	withCaller(t, deployerARN)
+	withoutCI(t)
+	os.WriteFile("tfmanage.yaml", []byte("ci_variable: JENKINS_URL\nenvironments:\n  prod:\n    require_ci: true\n"), 0o644)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	// the apply is followed by terraform version for the apply record
+	applied := func() bool {
+		return len(rec.Calls) > 1 && rec.Calls[len(rec.Calls)-2].Args[0] == "apply"
+	}
+
+	err := run([]string{"apply", "prod"})
+	if exitCodeFor(err) != exitCheck || !strings.Contains(fmt.Sprint(err), "--break-glass") {
+		t.Fatalf("apply outside CI: %v, want exit %d naming --break-glass", err, exitCheck)
+	}
+	if err := run([]string{"apply", "prod", "--destroy"}); exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "destroy of prod only runs from CI") {
+		t.Errorf("destroy outside CI: %v", err)
+	}
+	t.Setenv("CI", "true")
+	if err := run([]string{"apply", "prod"}); exitCodeFor(err) != exitCheck {
+		t.Errorf("apply with CI=true alone: %v, want exit %d", err, exitCheck)
+	}
+	if len(rec.Calls) != 0 {
+		t.Fatalf("terraform ran outside CI: %q", rec.Args())
+	}
+	if err := run([]string{"plan", "prod", "plan.out"}); err != nil {
+		t.Errorf("plan outside CI: %v", err)
+	}
+
+	for _, name := range []string{"GITLAB_CI", "JENKINS_URL"} {
+		t.Setenv(name, "true")
+		if err := run([]string{"apply", "prod"}); err != nil || !applied() {
+			t.Errorf("apply in CI with %s: %v", name, err)
+		}
+		t.Setenv(name, "")
+	}
+	t.Setenv("CI", "")
+
+	// breaking glass
+	if err := run([]string{"apply", "prod", "--break-glass"}); exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "--reason") {
+		t.Errorf("--break-glass without a reason: %v, want a usage error", err)
+	}
+	if err := runWithUI([]string{"apply", "prod", "--break-glass", "--reason", "pipeline down"}, &ui{stdout: io.Discard, stderr: io.Discard}); exitCodeFor(err) != exitUsage {
+		t.Errorf("--break-glass without a terminal: %v, want a usage error", err)
+	}
+	if err := run([]string{"apply", "dev", "--break-glass", "--reason", "pipeline down"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("--break-glass for an environment without require_ci: %v, want a usage error", err)
+	}
+	calls, puts := len(rec.Calls), store.Puts()
+	breakGlass := func(typed string) (string, error) {
+		var out bytes.Buffer
+		err := runWithUI([]string{"apply", "prod", "--break-glass", "--reason", "INC-1234 pipeline down"}, &ui{stdout: &out, stderr: &out, stdin: strings.NewReader(typed)})
+		return out.String(), err
+	}
+	if _, err := breakGlass("dev\n"); !errors.Is(err, errNotConfirmed) || len(rec.Calls) != calls || store.Puts() != puts {
+		t.Errorf("--break-glass with the wrong name typed: %v, %d terraform runs, %d objects", err, len(rec.Calls)-calls, store.Puts()-puts)
+	}
+	out, err := breakGlass("prod\n")
+	if err != nil || !applied() {
+		t.Fatalf("--break-glass: %v\n%s", err, out)
+	}
+	objects, _ := store.List(context.Background(), "team/audit/prod/")
+	if len(objects) != 1 || !strings.HasSuffix(objects[0].Key, "-break-glass.json") || !strings.Contains(out, objects[0].Key) {
+		t.Fatalf("audit trail = %+v\n%s", objects, out)
+	}
+	var record breakGlassRecord
+	data, _ := store.Bytes(objects[0].Key)
+	if err := json.Unmarshal(data, &record); err != nil || record.Reason != "INC-1234 pipeline down" || record.Caller != deployerARN || record.Action != "apply" || record.Environment != "prod" {
+		t.Errorf("audit record = %s (%v)", data, err)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfvars"
+)
+
+// clone-env - a new environment from an existing one. The tfvars are copied with every value naming the source environment rewritten to name the new one, plus the --set overrides, and the environment is added to the config file. Every value it changed is listed so nothing slips into the new environment unreviewed
+
+// what clone-env did to a variable
+
+const (
+	cloneSet       = "set"
+	cloneAdded     = "added"
+	cloneRewritten = "rewritten"
+)
+
+// environmentNamePattern is what a new environment can be called, it has to make a <NAME>_TFVARS variable
+
+var environmentNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+type cloneChange struct {
+	Variable string `json:"variable"`
+	Change   string `json:"change"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to"`
+}
+
+type cloneRequest struct {
+	sets          []string
+	local, upload bool
+	file, message string
+}
+
+func cloneEnvCommand() *command {
+	return &command{
+		name:    "clone-env",
+		args:    "<env> <new-env>",
+		summary: "Create a new environment from an existing one: copy its tfvars with the environment's name rewritten and the --set values, and add it to the config file.",
+		examples: []string{
+			"tfmanage clone-env staging qa",
+			"tfmanage clone-env staging qa --set instance_type=t3.small --set replicas=1",
+			"tfmanage clone-env prod dr --local --file envs/dr.tfvars --upload",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			var sets stringList
+			fs.Var(&sets, "set", "set a variable of the new tfvars, as name=value, a value that isn't a number, bool, list, map or quoted string is taken as a string (repeatable)")
+			local := fs.Bool("local", false, "copy the source's local tfvars instead of the remote ones")
+			file := fs.String("file", "", "write the new tfvars here (default the source's path with the environment's name replaced)")
+			upload := fs.Bool("upload", false, "upload the new tfvars without asking")
+			message := fs.String("m", "", "the change message of the upload")
+			return func(ctx context.Context, a *app, args []string) error {
+				return a.cloneEnvironment(ctx, args[0], args[1], cloneRequest{sets: sets, local: *local, upload: *upload, file: *file, message: *message})
+			}
+		},
+	}
+}
+
+func (a *app) cloneEnvironment(ctx context.Context, source, name string, req cloneRequest) error {
+	fileName, err := a.tfvarsFor(source)
+	if err != nil {
+		return err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	if _, exists := s.TFVars[name]; exists {
+		return usageError("the %s environment already exists, clone-env only creates new ones", name)
+	}
+	if !environmentNamePattern.MatchString(name) {
+		return usageError("%q can't be an environment name, use letters, digits, - and _ and start with a letter", name)
+	}
+	isJSON := strings.HasSuffix(fileName, ".json")
+	sets, err := parseCloneSets(req.sets, isJSON)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	from := fileName
+	if req.local {
+		if data, err = os.ReadFile(fileName); err != nil {
+			return err
+		}
+	} else {
+		if err := requirementsError("clone-env", checkStoreRequirements("download", source, s)); err != nil {
+			return err
+		}
+		loc, err := a.locate(ctx, s, source, fileName)
+		if err != nil {
+			return err
+		}
+		from = loc.url(loc.key)
+		if data, err = storage.GetBytes(ctx, loc.store, loc.key); err != nil {
+			return err
+		}
+	}
+	assignments, err := tfvars.ParseFile(fileName, data)
+	if err != nil {
+		return configError("%s can't be read: %v", from, err)
+	}
+
+	var values []tfvars.Assignment
+	var changes []cloneChange
+	for _, as := range assignments {
+		if value, ok := sets[as.Name]; ok {
+			values = append(values, tfvars.Assignment{Name: as.Name, Value: value})
+			changes = append(changes, cloneChange{Variable: as.Name, Change: cloneSet, From: as.Value, To: value})
+			continue
+		}
+		if value := rewriteEnvironmentName(as.Value, source, name); value != as.Value {
+			values = append(values, tfvars.Assignment{Name: as.Name, Value: value})
+			changes = append(changes, cloneChange{Variable: as.Name, Change: cloneRewritten, From: as.Value, To: value})
+		}
+	}
+	for _, set := range req.sets {
+		variable, _, _ := strings.Cut(set, "=")
+		variable = strings.TrimSpace(variable)
+		if _, ok := tfvars.Lookup(assignments, variable); !ok && !slices.ContainsFunc(values, func(v tfvars.Assignment) bool { return v.Name == variable }) {
+			values = append(values, tfvars.Assignment{Name: variable, Value: sets[variable]})
+			changes = append(changes, cloneChange{Variable: variable, Change: cloneAdded, To: sets[variable]})
+		}
+	}
+	newData := data
+	if len(values) > 0 {
+		if newData, err = tfvars.SetFile(fileName, data, values); err != nil {
+			return configError("can't change %s: %v", from, err)
+		}
+	}
+
+	target := cmp.Or(req.file, cloneFileName(fileName, source, name))
+	if _, err := os.Stat(target); err == nil {
+		return usageError("%s already exists, pass --file to write the new tfvars somewhere else", target)
+	}
+	if dir := filepath.Dir(target); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(target, newData, 0o644); err != nil {
+		return err
+	}
+	configFile := cmp.Or(s.ConfigFile, config.DefaultFile)
+	if err := config.AddEnvironment(configFile, name, config.Environment{TFVars: target, Chdir: s.Terraform[source].Chdir}); err != nil {
+		os.Remove(target)
+		return configError("%v", err)
+	}
+
+	a.reportCloneChanges(changes)
+	a.out.Event("clone-env", map[string]any{"source": source, "environment": name, "from": from, "file": target, "config_file": configFile, "changes": len(changes)})
+	a.out.Successf("Created the %s environment from %s: %s, added to %s", name, source, target, configFile)
+	if len(changes) == 0 {
+		a.out.Warnf("No value named %s and nothing was set, the tfvars of %s are an exact copy", source, name)
+	}
+
+	// the settings were read before the environment existed
+	a.settings = nil
+	upload := req.upload
+	if !upload {
+		if upload, err = a.askYesNo(fmt.Sprintf("Upload %s as the tfvars of %s now?", target, name)); err != nil {
+			return err
+		}
+	}
+	if !upload {
+		a.out.Printf("Review %s, then upload it with: tfmanage upload %s\n", target, name)
+		return nil
+	}
+	return a.upload(ctx, name, uploadRequest{message: req.message})
+}
+
+// reportCloneChanges lists every value clone-env changed, sensitive ones masked
+
+func (a *app) reportCloneChanges(changes []cloneChange) {
+	sensitive, _ := redact.SensitiveVariables(".")
+	var rows [][]string
+	for _, c := range changes {
+		if redact.SensitiveName(c.Variable) || slices.Contains(sensitive, c.Variable) || redact.SecretValue(c.From) || redact.SecretValue(c.To) {
+			if c.From != "" {
+				c.From = redact.Mask
+			}
+			c.To = redact.Mask
+		}
+		a.out.Event("clone-env-change", map[string]any{"variable": c.Variable, "change": c.Change, "from": c.From, "to": c.To})
+		rows = append(rows, []string{c.Variable, c.Change, cmp.Or(shortValue(c.From), "-"), shortValue(c.To)})
+	}
+	if !a.out.json && len(rows) > 0 {
+		a.out.Table(a.out.humanOut(), []string{"VARIABLE", "CHANGE", "FROM", "TO"}, rows, nil)
+	}
+}
+
+// parseCloneSets reads the name=value of each --set. A value that isn't already an expression is a string, quoted for HCL or JSON
+
+func parseCloneSets(sets []string, isJSON bool) (map[string]string, error) {
+	values := map[string]string{}
+	for _, set := range sets {
+		name, value, ok := strings.Cut(set, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !tfvarsNamePattern.MatchString(name) {
+			return nil, usageError("--set %q isn't name=value", set)
+		}
+		if _, dup := values[name]; dup {
+			return nil, usageError("--set gives %s twice", name)
+		}
+		values[name] = setValue(value, isJSON)
+	}
+	return values, nil
+}
+
+var tfvarsNamePattern = regexp.MustCompile(`^[A-Za-z_][\w-]*$`)
+
+func setValue(value string, isJSON bool) string {
+	value = strings.TrimSpace(value)
+	if _, err := strconv.ParseFloat(value, 64); err == nil || value == "true" || value == "false" || value == "null" {
+		return value
+	}
+	if isJSON {
+		if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
+			if json.Valid([]byte(value)) {
+				return value
+			}
+		}
+		quoted, _ := json.Marshal(value)
+		return string(quoted)
+	}
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") || strings.HasPrefix(value, "<<") {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// rewriteEnvironmentName replaces the environment's name in a value where it stands as a word of its own, in lower, upper or title case - staging-vpc becomes qa-vpc but stagingarea stays
+
+func rewriteEnvironmentName(value, from, to string) string {
+	for _, pair := range [][2]string{
+		{from, to},
+		{strings.ToUpper(from), strings.ToUpper(to)},
+		{strings.ToUpper(from[:1]) + from[1:], strings.ToUpper(to[:1]) + to[1:]},
+	} {
+		value = replaceWord(value, pair[0], pair[1])
+	}
+	return value
+}
+
+func replaceWord(s, old, with string) string {
+	isWordByte := func(i int) bool {
+		if i < 0 || i >= len(s) {
+			return false
+		}
+		c := s[i]
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], old) && !isWordByte(i-1) && !isWordByte(i+len(old)) {
+			b.WriteString(with)
+			i += len(old)
+			continue
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// cloneFileName is the source's path with the environment's name replaced, or <new-env>.tfvars next to it when the name isn't in it
+
+func cloneFileName(fileName, from, to string) string {
+	if renamed := rewriteEnvironmentName(fileName, from, to); renamed != fileName {
+		return renamed
+	}
+	ext := ".tfvars"
+	if strings.HasSuffix(fileName, ".tfvars.json") {
+		ext = ".tfvars.json"
+	}
+	return filepath.Join(filepath.Dir(fileName), to+ext)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCloneEnv(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	os.WriteFile("tfmanage.yaml", []byte("# our environments\nenvironments:\n  staging:\n    tfvars: envs/staging.tfvars\n    chdir: infra\n"), 0o644)
+	os.MkdirAll("envs", 0o755)
+	os.WriteFile("envs/staging.tfvars", []byte(`name = "staging-api" # the service
+bucket = "STAGING_LOGS"
+area = "stagingarea"
+instance_type = "m5.large"
+db_password = "staging-hunter2"
+`), 0o644)
+	if err := run([]string{"upload", "staging"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"clone-env", "staging", "qa", "--set", "instance_type=t3.small", "--set", "replicas=1"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if err != nil {
+		t.Fatalf("clone-env: %v", err)
+	}
+	want := `name = "qa-api" # the service
+bucket = "QA_LOGS"
+area = "stagingarea"
+instance_type = "t3.small"
+db_password = "qa-hunter2"
+replicas = 1
+`
+	if data, _ := os.ReadFile("envs/qa.tfvars"); string(data) != want {
+		t.Errorf("envs/qa.tfvars =\n%s\nwant\n%s", data, want)
+	}
+	for _, line := range []string{"name           rewritten", "instance_type  set", "replicas       added", "db_password    rewritten  (redacted)"} {
+		if !strings.Contains(stdout.String(), line) {
+			t.Errorf("the summary doesn't have %q:\n%s", line, stdout.String())
+		}
+	}
+	if strings.Contains(stdout.String(), "hunter2") {
+		t.Errorf("the summary shows a secret:\n%s", stdout.String())
+	}
+	if data, _ := os.ReadFile("tfmanage.yaml"); !strings.Contains(string(data), "# our environments") || !strings.Contains(string(data), "qa:\n    tfvars: envs/qa.tfvars\n    chdir: infra") {
+		t.Errorf("tfmanage.yaml =\n%s", data)
+	}
+	if _, ok := store.Bytes("team/envs/qa.tfvars"); ok {
+		t.Error("clone-env uploaded without --upload or a yes")
+	}
+
+	if err := run([]string{"upload", "qa"}); err != nil {
+		t.Errorf("upload of the new environment: %v", err)
+	}
+	if err := run([]string{"clone-env", "staging", "qa"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("clone-env onto an existing environment: %v, want a usage error", err)
+	}
+	if err := run([]string{"clone-env", "staging", "perf", "--set", "nope"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("clone-env with a bad --set: %v, want a usage error", err)
+	}
+}
+
+func TestCloneEnvUpload(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	os.WriteFile("dev.tfvars", []byte("env = \"dev\"\n"), 0o644)
+
+	if err := run([]string{"clone-env", "dev", "sandbox", "--local", "--upload"}); err != nil {
+		t.Fatalf("clone-env --upload: %v", err)
+	}
+	if data, ok := store.Bytes("team/sandbox.tfvars"); !ok || string(data) != "env = \"sandbox\"\n" {
+		t.Errorf("uploaded %q, %v", data, ok)
+	}
+}
+
+func TestRewriteEnvironmentName(t *testing.T) {
+	for value, want := range map[string]string{
+		`"prod-db"`:             `"qa-db"`,
+		`"prod_db"`:             `"qa_db"`,
+		`"production"`:          `"production"`,
+		`"PROD"`:                `"QA"`,
+		`"Prod Team"`:           `"Qa Team"`,
+		`["prod", "prod/logs"]`: `["qa", "qa/logs"]`,
+		`"preprod"`:             `"preprod"`,
+	} {
+		if got := rewriteEnvironmentName(value, "prod", "qa"); got != want {
+			t.Errorf("rewriteEnvironmentName(%s) = %s, want %s", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/codebuild"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+)
+
+// --runner codebuild - plan and apply run as a build of the --project CodeBuild project with the project's role, tfmanage starts it, follows its log and exits with how it went. What the build runs is up to the project's buildspec, it gets the environment and the operation in these variables
+
+const (
+	codeBuildEnvironmentVar = "TFMANAGE_ENVIRONMENT"
+	codeBuildOperationVar   = "TFMANAGE_OPERATION"
+	codeBuildPlanKeyVar     = "TFMANAGE_PLAN_KEY"
+	codeBuildArgsVar        = "TFMANAGE_ARGS"
+	codeBuildGitSHAVar      = "TFMANAGE_GIT_SHA"
+)
+
+// codeBuildFlags are the flags of each command a build can be asked for, every other flag of the command does something on this machine. Apply's --plan goes in TFMANAGE_PLAN_KEY, the others in TFMANAGE_ARGS
+
+var codeBuildFlags = map[string][]string{
+	"plan":  {"store-plan"},
+	"apply": {"plan"},
+}
+
+// codeBuildPollInterval is how often the build and its log are looked at
+
+var codeBuildPollInterval = 5 * time.Second
+
+// codeBuildAPI is what the runner needs of CodeBuild, *codebuild.Client in the real thing
+
+type codeBuildAPI interface {
+	StartBuild(ctx context.Context, project string, env map[string]string) (codebuild.Build, error)
+	StopBuild(ctx context.Context, id string) (codebuild.Build, error)
+	Follow(ctx context.Context, id string, w io.Writer, interval time.Duration) (codebuild.Build, error)
+}
+
+// newCodeBuildClient gives back the client and the region it calls - a variable like newStore so the tests can fake the project
+
+var newCodeBuildClient = func(ctx context.Context, s settings) (codeBuildAPI, string, error) {
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return nil, "", err
+	}
+	return codebuild.NewClient(cfg), cfg.Region, nil
+}
+
+// runInCodeBuild starts the build for the command instead of running it, and follows it unless --no-wait
+
+func (a *app) runInCodeBuild(ctx context.Context, c *command, fs *flag.FlagSet, args []string) error {
+	allowed, ok := codeBuildFlags[c.name]
+	if !ok {
+		return usageError("%s can't run in CodeBuild, --runner codebuild is for plan and apply", c.name)
+	}
+	if err := checkCodeBuildFlags(c.name, fs, allowed); err != nil {
+		return err
+	}
+	if len(a.global.tfEnvVars) > 0 {
+		return usageError("--tf-env is terraform's environment on this machine, the project sets the build's")
+	}
+	if len(args) > 1 {
+		return usageError("the build keeps its plan, %s in CodeBuild doesn't take a plan file, use --store-plan to keep it in the bucket", c.name)
+	}
+	environment := args[0]
+	if err := a.checkEnvironment(environment); err != nil {
+		return err
+	}
+	env := map[string]string{
+		codeBuildEnvironmentVar: environment,
+		codeBuildOperationVar:   c.name,
+	}
+	if sha := gitinfo.Commit(ctx); sha != "" {
+		env[codeBuildGitSHAVar] = sha
+	}
+	if a.failoverRequested() {
+		env[failoverEnv] = "1"
+	}
+	var extra []string
+	var planKeyErr error
+	fs.Visit(func(f *flag.Flag) {
+		switch {
+		case f.Name == "plan":
+			env[codeBuildPlanKeyVar], planKeyErr = a.codeBuildPlanKey(ctx, environment, f.Value.String())
+		case slices.Contains(allowed, f.Name):
+			extra = append(extra, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	if planKeyErr != nil {
+		return planKeyErr
+	}
+	if len(extra) > 0 {
+		env[codeBuildArgsVar] = strings.Join(extra, " ")
+	}
+
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	client, region, err := newCodeBuildClient(ctx, s)
+	if err != nil {
+		return err
+	}
+	build, err := client.StartBuild(ctx, a.global.project, env)
+	if err != nil {
+		return err
+	}
+	link := codebuild.ConsoleURL(region, a.global.project, build.ID)
+	a.out.Event("codebuild", map[string]any{"environment": environment, "operation": c.name, "project": a.global.project, "build_id": build.ID, "url": link})
+	if a.global.noWait {
+		// only the ID, for scripts that follow the build themselves
+		if !a.out.machineReadable() {
+			fmt.Fprintln(a.out.stdout, build.ID)
+		}
+		a.out.Verbosef("%s\n", link)
+		return nil
+	}
+	a.out.Printf("Started %s of %s in CodeBuild as %s\n  %s\n\n", c.name, environment, build.ID, link)
+	return a.followBuild(ctx, client, build.ID)
+}
+
+// followBuild streams the build's log until it is over. A Ctrl-C asks whether to stop the build, since leaving an apply running can be what was wanted, and TFM_TIMEOUT stops it without asking
+
+func (a *app) followBuild(ctx context.Context, client codeBuildAPI, id string) error {
+	logOut := a.out.stdout
+	if a.out.machineReadable() {
+		logOut = a.out.stderr
+	}
+	build, err := client.Follow(ctx, id, logOut, codeBuildPollInterval)
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil && errors.Is(err, ctxErr) {
+		stop := errors.Is(ctxErr, context.DeadlineExceeded)
+		if !stop {
+			if stop, err = a.askYesNo(fmt.Sprintf("\nStop build %s?", id)); err != nil {
+				return err
+			}
+		}
+		if !stop {
+			a.out.Warnf("Build %s is still running, follow it in the CodeBuild console", id)
+			return ctxErr
+		}
+		a.out.Printf("Stopping build %s\n", id)
+		// the build takes a moment to stop, its last lines are still worth showing
+		ctx = context.WithoutCancel(ctx)
+		if _, err := client.StopBuild(ctx, id); err != nil {
+			return err
+		}
+		if build, err = client.Follow(ctx, id, logOut, codeBuildPollInterval); err != nil {
+			return err
+		}
+		if err := build.Err(); err != nil {
+			return err
+		}
+		return ctxErr
+	}
+	if err != nil {
+		return err
+	}
+	a.out.Event("codebuild-result", map[string]any{"build_id": build.ID, "status": build.Status})
+	if err := build.Err(); err != nil {
+		return err
+	}
+	a.out.Successf("Build %s succeeded", build.ID)
+	return nil
+}
+
+// checkCodeBuildFlags refuses the command's flags the build wouldn't get, rather than dropping them without a word
+
+func checkCodeBuildFlags(command string, fs *flag.FlagSet, allowed []string) error {
+	global := newFlagSet("global")
+	var g globalFlags
+	g.register(global)
+	var refused []string
+	fs.Visit(func(f *flag.Flag) {
+		if global.Lookup(f.Name) == nil && !slices.Contains(allowed, f.Name) {
+			refused = append(refused, "--"+f.Name)
+		}
+	})
+	if len(refused) > 0 {
+		return usageError("%s only works on this machine, with --runner codebuild the project's buildspec decides how %s runs", strings.Join(refused, ", "), command)
+	}
+	return nil
+}
+
+// codeBuildPlanKey turns apply's --plan into the key of a stored plan, so the build applies the plan that was the latest when it was asked for
+
+func (a *app) codeBuildPlanKey(ctx context.Context, environment, arg string) (string, error) {
+	s, err := a.loadSettings()
+	if err != nil {
+		return "", err
+	}
+	if arg == lastPlanArg || !isStoredPlan(s, arg) {
+		return "", usageError("--plan %s is a plan on this machine, a build can only apply a stored plan, latest or its key", arg)
+	}
+	s, store, err := a.planStore(ctx)
+	if err != nil {
+		return "", err
+	}
+	return resolvePlanKey(ctx, s, store, environment, arg)
+}
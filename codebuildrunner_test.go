@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/codebuild"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// fakeProject starts builds that end in status, or never end when it is in progress
+type fakeProject struct {
+	project string
+	env     map[string]string
+	status  string
+	stopped int
+}
+
+func (f *fakeProject) StartBuild(ctx context.Context, project string, env map[string]string) (codebuild.Build, error) {
+	f.project, f.env = project, env
+	return codebuild.Build{ID: project + ":1", Status: codebuild.StatusInProgress}, nil
+}
+
+func (f *fakeProject) StopBuild(ctx context.Context, id string) (codebuild.Build, error) {
+	f.stopped++
+	f.status = codebuild.StatusStopped
+	return codebuild.Build{ID: id, Status: codebuild.StatusInProgress}, nil
+}
+
+func (f *fakeProject) Follow(ctx context.Context, id string, w io.Writer, interval time.Duration) (codebuild.Build, error) {
+	if f.status == codebuild.StatusInProgress {
+		<-ctx.Done()
+		return codebuild.Build{ID: id, Status: f.status}, ctx.Err()
+	}
+	io.WriteString(w, "[Container] Running command tfmanage "+f.env["TFMANAGE_OPERATION"]+"\n")
+	return codebuild.Build{ID: id, Status: f.status, Complete: true}, nil
+}
+
+func TestCodeBuildRunner(t *testing.T) {
+	local := &tfexec.RecordingRunner{}
+	project := &fakeProject{status: codebuild.StatusSucceeded}
+	useRunner(t, local)
+	swap(t, &newCodeBuildClient, func(context.Context, settings) (codeBuildAPI, string, error) { return project, "eu-west-1", nil })
+	inTempDir(t)
+	store := withMemoryStore(t)
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	var out bytes.Buffer
+	if err := runWithUI([]string{"--runner", "codebuild", "--project", "deploy", "plan", "dev", "--store-plan"}, &ui{stdout: &out, stderr: io.Discard}); err != nil {
+		t.Fatalf("plan in CodeBuild: %v", err)
+	}
+	if len(local.Calls) != 0 || project.project != "deploy" {
+		t.Fatalf("local terraform ran %q, project %q", local.Args(), project.project)
+	}
+	if project.env["TFMANAGE_ENVIRONMENT"] != "dev" || project.env["TFMANAGE_OPERATION"] != "plan" || project.env["TFMANAGE_ARGS"] != "--store-plan=true" {
+		t.Errorf("build environment %v", project.env)
+	}
+	if !strings.Contains(out.String(), "Running command tfmanage plan") || !strings.Contains(out.String(), "codebuild/projects/deploy/build/deploy:1") {
+		t.Errorf("output %q, want the build's log and its link", out.String())
+	}
+
+	key := "team/plans/dev/dev-20261001T120000Z.tfplan"
+	storage.PutBytesEncrypted(context.Background(), store, key, []byte("plan"), "")
+	quiet := &ui{stdout: io.Discard, stderr: io.Discard}
+	if err := runWithUI([]string{"--runner", "codebuild", "--project", "deploy", "apply", "dev", "--plan", "latest"}, quiet); err != nil {
+		t.Fatalf("apply in CodeBuild: %v", err)
+	}
+	if project.env["TFMANAGE_PLAN_KEY"] != key {
+		t.Errorf("TFMANAGE_PLAN_KEY = %q, want the latest plan's key", project.env["TFMANAGE_PLAN_KEY"])
+	}
+
+	out.Reset()
+	if err := runWithUI([]string{"--runner", "codebuild", "--project", "deploy", "--no-wait", "apply", "dev"}, &ui{stdout: &out, stderr: io.Discard}); err != nil || out.String() != "deploy:1\n" {
+		t.Errorf("--no-wait printed %q, %v, want only the build ID", out.String(), err)
+	}
+
+	project.status = codebuild.StatusFailed
+	if err := runWithUI([]string{"--runner", "codebuild", "--project", "deploy", "apply", "dev"}, quiet); exitCodeFor(err) != exitTerraform {
+		t.Errorf("failed build: %v (exit code %d)", err, exitCodeFor(err))
+	}
+
+	for _, args := range [][]string{
+		{"--runner", "codebuild", "plan", "dev"},
+		{"--project", "deploy", "plan", "dev"},
+		{"--no-wait", "plan", "dev"},
+		{"--runner", "codebuild", "--project", "deploy", "init", "dev"},
+		{"--runner", "codebuild", "--project", "deploy", "plan", "dev", "--target", "module.network"},
+		{"--runner", "codebuild", "--project", "deploy", "plan", "dev", "dev.tfplan"},
+		{"--runner", "codebuild", "--project", "deploy", "apply", "dev", "--plan", "last"},
+		{"--runner", "codebuild", "--project", "deploy", "--tf-env", "TF_LOG=debug", "apply", "dev"},
+	} {
+		if err := runWithUI(args, quiet); exitCodeFor(err) != exitUsage {
+			t.Errorf("%q: %v, want a usage error", args, err)
+		}
+	}
+}
+
+func TestFollowBuildInterrupted(t *testing.T) {
+	for _, tc := range []struct {
+		answer  string
+		stopped int
+		want    error
+	}{
+		{"y\n", 1, codebuild.ErrBuildStopped},
+		{"n\n", 0, context.Canceled},
+	} {
+		project := &fakeProject{status: codebuild.StatusInProgress}
+		a := &app{out: &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader(tc.answer)}}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := a.followBuild(ctx, project, "deploy:1")
+		if !errors.Is(err, tc.want) || project.stopped != tc.stopped {
+			t.Errorf("answering %q: %v, stopped %d times", tc.answer, err, project.stopped)
+		}
+	}
+
+	// TFM_TIMEOUT running out stops the build without asking
+	project := &fakeProject{status: codebuild.StatusInProgress}
+	a := &app{out: &ui{stdout: io.Discard, stderr: io.Discard}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := a.followBuild(ctx, project, "deploy:1"); !errors.Is(err, codebuild.ErrBuildStopped) || project.stopped != 1 {
+		t.Errorf("timed out: %v, stopped %d times", err, project.stopped)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestParseArgsInterspersed(t *testing.T) {
+	fs := newFlagSet("test")
+	destroy := fs.Bool("destroy", false, "")
+	var targets stringList
+	fs.Var(&targets, "target", "")
+
+	got, err := parseArgs(fs, []string{"dev", "--target", "a.b", "plan.out", "--destroy", "--target=c.d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"dev", "plan.out"}) {
+		t.Errorf("positional = %q", got)
+	}
+	if !*destroy || !reflect.DeepEqual([]string(targets), []string{"a.b", "c.d"}) {
+		t.Errorf("flags not parsed: destroy=%v targets=%q", *destroy, targets)
+	}
+
+	fs = newFlagSet("test")
+	fs.Bool("x", false, "")
+	got, err = parseArgs(fs, []string{"a", "--", "-x"})
+	if err != nil || !reflect.DeepEqual(got, []string{"a", "-x"}) {
+		t.Errorf("after -- got %q, %v", got, err)
+	}
+}
+
+func TestSuggestCommand(t *testing.T) {
+	tests := map[string]string{
+		"uplaod":  "upload",
+		"aply":    "apply",
+		"pla":     "plan",
+		"downlod": "download",
+		"xyzzy":   "",
+	}
+	for typed, want := range tests {
+		if got := suggestCommand(typed); got != want {
+			t.Errorf("suggestCommand(%q) = %q, want %q", typed, got, want)
+		}
+	}
+}
+
+func TestUnknownCommandSuggests(t *testing.T) {
+	err := run([]string{"uplaod", "dev"})
+	if exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), `did you mean "upload"`) {
+		t.Errorf("run() = %v", err)
+	}
+}
+
+func TestCommandHelpShowsFlags(t *testing.T) {
+	c, err := lookupCommand("plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	printCommandHelp(&buf, c)
+	for _, want := range []string{"Usage: tfmanage plan <env> [plan-file]", "-target", "-destroy", "-refresh-only", "Examples:"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("help output is missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestBadFlagIsUsageError(t *testing.T) {
+	if got := exitCodeFor(run([]string{"apply", "dev", "--no-such-flag"})); got != exitUsage {
+		t.Errorf("exit code = %d, want %d", got, exitUsage)
+	}
+	if got := exitCodeFor(run([]string{"--output", "yaml", "version"})); got != exitUsage {
+		t.Errorf("bad --output exit code = %d, want %d", got, exitUsage)
+	}
+}
+
+func TestFlagsReachTerraform(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("staging.tfvars", nil, 0o644)
+	t.Setenv("STAGING_TFVARS", "staging.tfvars")
+
+	err := run([]string{"--verbose", "plan", "staging", "out.tfplan", "--target", "module.a", "--destroy", "--chdir", "infra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	varFile, _ := filepath.Abs("staging.tfvars")
+	out, _ := filepath.Abs("out.tfplan")
+	want := []string{"-chdir=infra", "plan", "-var-file", varFile, "-target=module.a", "-destroy", "-out", out, "-detailed-exitcode", "-no-color"}
+	if got := rec.Args(); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("terraform calls = %q, want %q", got, want)
+	}
+}
+
+func TestConfigFileEnvironments(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tfmanage.yaml")
+	os.WriteFile(path, []byte("bucket: from-file\nenvironments:\n  qa:\n    tfvars: qa.tfvars\n  dev:\n    tfvars: file-dev.tfvars\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "env-dev.tfvars")
+	t.Setenv("QA_TFVARS", "")
+	t.Setenv("S3_BUCKET", "")
+
+	s, err := loadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.S3Bucket != "from-file" {
+		t.Errorf("bucket = %q", s.S3Bucket)
+	}
+	if s.TFVars["qa"] != "qa.tfvars" {
+		t.Errorf("qa tfvars = %q", s.TFVars["qa"])
+	}
+	if s.TFVars["dev"] != "env-dev.tfvars" {
+		t.Errorf("dev tfvars = %q, the env should win over the file", s.TFVars["dev"])
+	}
+	if _, ok := s.TFVars["prod"]; !ok {
+		t.Error("built in environments are missing")
+	}
+
+	if got := exitCodeFor(run([]string{"--config", filepath.Join(dir, "nope.yaml"), "upload", "dev"})); got != exitConfig {
+		t.Errorf("missing --config exit code = %d, want %d", got, exitConfig)
+	}
+}
+
+func TestJSONOutputEvents(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	u := &ui{json: true, stdout: &stdout, stderr: &stderr}
+	u.Event("startup", map[string]any{"command": "upload"})
+	u.Printf("Uploading\n")
+	u.Result("upload", usageError("bad"))
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("stdout = %q", stdout.String())
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["event"] != "result" || result["status"] != "error" || result["exit_code"] != float64(exitUsage) {
+		t.Errorf("result event = %v", result)
+	}
+	if stderr.String() != "Uploading\n" {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+}
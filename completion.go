@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Shell completion - the scripts call back into the tool with the hidden __complete command so the environment names always come from the current configuration
+
+// fileCompletion tells the completion scripts to fall back to completing file names
+
+const fileCompletion = ":files"
+
+// environmentNames gives back the environments that can be used, sorted
+
+func environmentNames(s settings) []string {
+	names := make([]string, 0, len(s.TFVars))
+	for name := range s.TFVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeArgs gives the candidates for the next word given the words already typed after the program name
+
+func completeArgs(words []string, s settings) []string {
+	if len(words) == 0 {
+		var names []string
+		for _, c := range commands {
+			if !c.hidden {
+				names = append(names, c.name)
+			}
+		}
+		return names
+	}
+
+	// flags are not completed, only the positional arguments are counted
+	var positional []string
+	for _, w := range words[1:] {
+		if !strings.HasPrefix(w, "-") {
+			positional = append(positional, w)
+		}
+	}
+
+	switch words[0] {
+	case "upload", "download", "merge-remote", "clone-env", "retire", "versions", "versions-used", "changes", "blame", "upload-lockfile", "download-lockfile", "init", "apply", "import", "taint", "untaint", "graph", "console", "test", "status", "generate-iam-policy", "plans":
+		if len(positional) == 0 {
+			return environmentNames(s)
+		}
+	case "plan", "policy-check":
+		switch len(positional) {
+		case 0:
+			return environmentNames(s)
+		case 1:
+			return []string{fileCompletion}
+		}
+	case "state":
+		switch len(positional) {
+		case 0:
+			return []string{"backup", "list", "show", "restore", "diff"}
+		case 1:
+			return environmentNames(s)
+		}
+	case "modules":
+		switch len(positional) {
+		case 0:
+			return []string{"update"}
+		case 1:
+			return environmentNames(s)
+		}
+	case "workspace":
+		switch len(positional) {
+		case 0:
+			return []string{"list", "new"}
+		case 1:
+			return environmentNames(s)
+		}
+	case "show", "approve", "approvals":
+		switch len(positional) {
+		case 0:
+			return environmentNames(s)
+		case 1:
+			return []string{"latest"}
+		}
+	case "preflight":
+		switch len(positional) {
+		case 0:
+			return environmentNames(s)
+		case 1:
+			return preflightOperations
+		}
+	case "plan-diff":
+		if len(positional) < 2 {
+			return []string{fileCompletion}
+		}
+	case "matrix":
+		return environmentNames(s)
+	case "drift-detect":
+		if len(positional) == 0 {
+			return append([]string{"all"}, environmentNames(s)...)
+		}
+	case "providers":
+		switch len(positional) {
+		case 0:
+			return []string{"mirror", "lock"}
+		case 1:
+			if positional[0] == "mirror" {
+				return []string{fileCompletion}
+			}
+			return environmentNames(s)
+		}
+	case "bundle":
+		switch len(positional) {
+		case 0:
+			return append([]string{"verify"}, environmentNames(s)...)
+		case 1:
+			if positional[0] == "verify" {
+				return []string{fileCompletion}
+			}
+		}
+	case "config":
+		switch {
+		case len(positional) == 0:
+			return []string{"path", "init", "show", "validate"}
+		case len(positional) == 1 && positional[0] == "show":
+			return environmentNames(s)
+		}
+	case "env":
+		switch len(positional) {
+		case 0:
+			return []string{"check"}
+		case 1:
+			return []string{"upload", "download", "plan", "apply"}
+		case 2:
+			return environmentNames(s)
+		}
+	case "help":
+		if len(positional) == 0 {
+			names := []string{"exit-codes"}
+			for _, c := range commands {
+				if !c.hidden {
+					names = append(names, c.name)
+				}
+			}
+			return names
+		}
+	case "completion":
+		if len(positional) == 0 {
+			return []string{"bash", "zsh", "fish"}
+		}
+	}
+	return nil
+}
+
+func completionCommand() *command {
+	return &command{
+		name:     "completion",
+		args:     "<bash|zsh|fish>",
+		summary:  "Print the shell completion script for bash, zsh or fish.",
+		examples: []string{"source <(tfmanage completion bash)", "tfmanage completion fish > ~/.config/fish/completions/tfmanage.fish"},
+		minArgs:  1,
+		maxArgs:  1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(ctx context.Context, a *app, args []string) error {
+				name := filepath.Base(os.Args[0])
+				script, ok := completionScripts[args[0]]
+				if !ok {
+					return usageError("unsupported shell %q, use bash, zsh or fish", args[0])
+				}
+				fmt.Print(strings.ReplaceAll(script, "PROG", name))
+				return nil
+			}
+		},
+	}
+}
+
+// completeCommand is the hidden hook the completion scripts call, it never fails so a broken config does not break the shell
+
+func completeCommand() *command {
+	return &command{
+		name:    "__complete",
+		hidden:  true,
+		maxArgs: -1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(ctx context.Context, a *app, args []string) error {
+				s, err := a.loadSettings()
+				if err != nil {
+					return nil
+				}
+				for _, c := range completeArgs(args, s) {
+					fmt.Println(c)
+				}
+				return nil
+			}
+		},
+	}
+}
+
+var completionScripts = map[string]string{
+	"bash": `# bash completion for PROG
+# add to ~/.bashrc: source <(PROG completion bash)
+_PROG_complete() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local out
+    out=$("${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:COMP_CWORD-1}" 2>/dev/null)
+    if [[ "$out" == ":files" ]]; then
+        COMPREPLY=($(compgen -f -- "$cur"))
+    else
+        COMPREPLY=($(compgen -W "$out" -- "$cur"))
+    fi
+}
+complete -F _PROG_complete PROG
+`,
+	"zsh": `#compdef PROG
+# add to ~/.zshrc: source <(PROG completion zsh)
+_PROG_complete() {
+    local out
+    local -a candidates
+    out=$(${words[1]} __complete ${words[2,CURRENT-1]} 2>/dev/null)
+    if [[ "$out" == ":files" ]]; then
+        _files
+    else
+        candidates=(${(f)out})
+        compadd -a candidates
+    fi
+}
+compdef _PROG_complete PROG
+`,
+	"fish": `# fish completion for PROG
+# save to ~/.config/fish/completions/PROG.fish: PROG completion fish > ~/.config/fish/completions/PROG.fish
+function __PROG_complete
+    set -l tokens (commandline -opc)
+    set -e tokens[1]
+    set -l out (PROG __complete $tokens 2>/dev/null)
+    if test "$out" = ":files"
+        __fish_complete_path (commandline -ct)
+    else
+        printf '%s\n' $out
+    end
+end
+complete -c PROG -f -a '(__PROG_complete)'
+`,
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompleteArgs(t *testing.T) {
+	s := settings{TFVars: map[string]string{"prod": "p.tfvars", "dev": "d.tfvars", "sandbox": ""}}
+	tests := []struct {
+		name  string
+		words []string
+		want  []string
+	}{
+		{"operations", nil, []string{"upload", "download", "merge-remote", "clone-env", "retire", "versions", "versions-used", "changes", "blame", "put", "get", "list", "upload-lockfile", "download-lockfile", "init", "modules", "plan", "apply", "policy-check", "state", "workspace", "import", "taint", "untaint", "graph", "console", "test", "providers", "drift-detect", "plan-diff", "show", "plans", "approve", "approvals", "bundle", "status", "matrix", "preflight", "failover-check", "generate-iam-policy", "env", "config", "help", "version", "self-update", "completion"}},
+		{"env check", []string{"env"}, []string{"check"}},
+		{"config subcommands", []string{"config"}, []string{"path", "init", "show", "validate"}},
+		{"config show environments", []string{"config", "show"}, []string{"dev", "prod", "sandbox"}},
+		{"approve plans", []string{"approve", "prod"}, []string{"latest"}},
+		{"env check environments", []string{"env", "check", "upload"}, []string{"dev", "prod", "sandbox"}},
+		{"environments", []string{"plan"}, []string{"dev", "prod", "sandbox"}},
+		{"plan file", []string{"plan", "dev"}, []string{fileCompletion}},
+		{"policy-check plan file", []string{"policy-check", "prod"}, []string{fileCompletion}},
+		{"state subcommands", []string{"state"}, []string{"backup", "list", "show", "restore", "diff"}},
+		{"state environments", []string{"state", "backup"}, []string{"dev", "prod", "sandbox"}},
+		{"workspace subcommands", []string{"workspace"}, []string{"list", "new"}},
+		{"nothing after upload env", []string{"upload", "dev"}, nil},
+		{"help topics", []string{"help"}, []string{"exit-codes", "upload", "download", "merge-remote", "clone-env", "retire", "versions", "versions-used", "changes", "blame", "put", "get", "list", "upload-lockfile", "download-lockfile", "init", "modules", "plan", "apply", "policy-check", "state", "workspace", "import", "taint", "untaint", "graph", "console", "test", "providers", "drift-detect", "plan-diff", "show", "plans", "approve", "approvals", "bundle", "status", "matrix", "preflight", "failover-check", "generate-iam-policy", "env", "config", "help", "version", "self-update", "completion"}},
+		{"plan file after flags", []string{"plan", "--destroy", "dev"}, []string{fileCompletion}},
+		{"shells", []string{"completion"}, []string{"bash", "zsh", "fish"}},
+		{"unknown", []string{"frobnicate"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := completeArgs(tt.words, s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("completeArgs(%q) = %q, want %q", tt.words, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompletionScripts(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		if err := run([]string{"completion", shell}); err != nil {
+			t.Errorf("completion %s: %v", shell, err)
+		}
+	}
+	if got := exitCodeFor(run([]string{"completion", "tcsh"})); got != exitUsage {
+		t.Errorf("unsupported shell exit code = %d, want %d", got, exitUsage)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/dirs"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/metrics"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/notify"
+)
+
+// config path - which config files were looked for, which exist and which one is read, plus where state and cache go
+// config show - every setting with its value and where it came from, the sources are noted by loadSettings as it resolves them so they can't drift from what the commands use
+
+const (
+	statusUsed  = "used"
+	statusFound = "found"
+)
+
+type configFile struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+type configSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+func configCommand() *command {
+	return &command{
+		name:    "config",
+		args:    "path|init|show [env]|validate [file]",
+		summary: "Show which config files are looked for and where state and cache are kept (path), write a new config file by answering a few questions (init), show the settings and where each one comes from (show), or check a config file for every problem at once (validate).",
+		examples: []string{
+			"tfmanage config path",
+			"tfmanage config show",
+			"tfmanage config show prod",
+			"tfmanage config show --env-file ci.env --output json",
+			"tfmanage config init",
+			"tfmanage config init --from-env",
+			"tfmanage config validate",
+			"tfmanage config validate ci/tfmanage.yaml --output json",
+		},
+		minArgs: 1,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			fromEnv := fs.Bool("from-env", false, "config init: start from S3_BUCKET, S3_PATH, AWS_REGION, AWS_PROFILE and the <ENV>_TFVARS variables")
+			ci := fs.Bool("ci", false, "config init: running from a pipeline, fail rather than ask")
+			return func(ctx context.Context, a *app, args []string) error {
+				if args[0] != "init" && (*fromEnv || *ci) {
+					return usageError("--from-env and --ci are only for config init")
+				}
+				switch {
+				case args[0] == "init" && len(args) == 1:
+					return a.configInit(ctx, *fromEnv, *ci)
+				case args[0] == "init":
+					return usageError("config init takes no environment")
+				case args[0] == "path" && len(args) == 1:
+					return a.printConfigPaths()
+				case args[0] == "path":
+					return usageError("config path takes no environment")
+				case args[0] == "validate" && len(args) == 2:
+					return a.validateConfig(args[1])
+				case args[0] == "validate":
+					return a.validateConfig("")
+				case args[0] == "show" && len(args) == 2:
+					return a.printConfigSettings(args[1])
+				case args[0] == "show":
+					return a.printConfigSettings("")
+				}
+				return usageError("unknown config subcommand %q, use path, init, show or validate", args[0])
+			}
+		},
+	}
+}
+
+// configFiles lists the config candidates in lookup order - --config replaces the whole list, like it does when loading
+
+func (a *app) configFiles() []configFile {
+	candidates := config.Candidates()
+	if a.global.config != "" {
+		candidates = []string{a.global.config}
+	}
+	var files []configFile
+	used := false
+	for _, path := range candidates {
+		f := configFile{Path: path, Status: statusMissing}
+		if _, err := os.Stat(path); err == nil {
+			f.Status = statusFound
+			if !used {
+				f.Status, used = statusUsed, true
+			}
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+func (a *app) printConfigPaths() error {
+	files := a.configFiles()
+	stateDir, err := dirs.StateDir()
+	if err != nil {
+		stateDir = "unknown: " + err.Error()
+	}
+	cacheDir, err := dirs.CacheDir()
+	if err != nil {
+		cacheDir = "unknown: " + err.Error()
+	}
+
+	if a.out.json {
+		a.out.Event("config-path", map[string]any{"files": files, "state_dir": stateDir, "cache_dir": cacheDir})
+		return nil
+	}
+	var rows [][]string
+	for _, f := range files {
+		rows = append(rows, []string{f.Path, f.Status})
+	}
+	a.out.Table(a.out.humanOut(), []string{"CONFIG FILE", "STATUS"}, rows, func(col int, cell string) string {
+		if col == 1 && cell == statusUsed {
+			return a.out.green(cell)
+		}
+		return cell
+	})
+	a.out.Printf("\nState: %s\nCache: %s\n", stateDir, cacheDir)
+	return nil
+}
+
+// the settings that don't come from an env variable are named after their config key
+
+const (
+	settingCredentialsCommand    = "credentials_command"
+	settingMetricsJob            = "metrics.job_name"
+	settingPlanDir               = "plan_dir"
+	settingCIVariable            = "ci_variable"
+	settingGitHubRepo            = "github_repo"
+	settingExpectedBucketAccount = "expected_bucket_account"
+	settingReplicaBucket         = "replica_bucket"
+	settingReplicaRegion         = "replica_region"
+	settingRoleARN               = "role_arn"
+)
+
+// configSettings lists the settings with where loadSettings found each one, secrets masked. With an environment it adds where its tfvars go and how terraform runs for it
+
+func configSettings(s settings, environment string) []configSetting {
+	var settings []configSetting
+	add := func(name, value, from string) {
+		if from == "" {
+			from = "not set"
+			if value != "" {
+				from = "default"
+			}
+		}
+		settings = append(settings, configSetting{Name: name, Value: value, Source: from})
+	}
+	fromConfig := func(configKey string) string {
+		if configKey == "" {
+			return ""
+		}
+		return s.ConfigFile + ": " + configKey
+	}
+	masked := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return maskSecret(value)
+	}
+
+	for _, v := range [][2]string{
+		{"S3_BUCKET", s.S3Bucket},
+		{"S3_PATH", s.S3Path},
+		{"S3_ENDPOINT", s.S3Client.Endpoint},
+		{"KMS_KEY_ARN", s.KMSKeyARN},
+		{"AWS_REGION", s.AWSConfig.Region},
+		{"AWS_PROFILE", s.AWSConfig.Profile},
+		{"AWS_ACCESS_KEY_ID", masked(s.AWSConfig.AccessKeyID)},
+		{settingCredentialsCommand, s.AWSConfig.CredentialsCommand},
+		{settingRoleARN, s.AWSConfig.RoleARN},
+		{"HTTPS_PROXY", awsconfig.RedactProxyURL(cmp.Or(s.AWSConfig.ProxyURL, os.Getenv("HTTPS_PROXY")))},
+		{"AWS_CA_BUNDLE", s.AWSConfig.CABundle},
+		{notify.WebhookURLEnv, masked(s.Webhook)},
+		{metrics.PushgatewayURLEnv, masked(s.Pushgateway)},
+		{settingMetricsJob, cmp.Or(s.MetricsJob, metrics.DefaultJob)},
+		{"VAULT_ADDR", s.Vault.Address},
+		{"VAULT_NAMESPACE", s.Vault.Namespace},
+		{settingPlanDir, s.PlanDir},
+		{settingCIVariable, s.CIVariable},
+		{settingGitHubRepo, s.GitHubRepo},
+		{settingExpectedBucketAccount, s.ExpectedBucketAccount},
+		{settingReplicaBucket, s.ReplicaBucket},
+		{settingReplicaRegion, s.ReplicaRegion},
+	} {
+		add(v[0], v[1], s.Sources[v[0]])
+	}
+	envs := environmentNames(s)
+	if environment != "" {
+		envs = []string{environment}
+	}
+	for _, env := range envs {
+		add(tfvarsEnvVar(env), s.TFVars[env], s.Sources[tfvarsEnvVar(env)])
+	}
+	if environment == "" {
+		return settings
+	}
+
+	env := s.Terraform[environment]
+	prefix := "environments." + environment + "."
+	if env.Location != "" {
+		add("location", env.Location, fromConfig(prefix+"location"))
+	} else if s.S3Bucket != "" {
+		add("location", "s3://"+s.S3Bucket+"/"+s.S3Path, "S3_BUCKET and S3_PATH")
+	} else {
+		add("location", "", "")
+	}
+	if env.ReplicaBucket != "" {
+		add("replica", "s3://"+env.ReplicaBucket, fromConfig(prefix+"replica_bucket"))
+	} else if env.Location == "" && s.ReplicaBucket != "" {
+		add("replica", "s3://"+s.ReplicaBucket, fromConfig("replica_bucket"))
+	} else {
+		add("replica", "", "")
+	}
+	if f := env.Failover; f != nil {
+		add("failover", describeFailover(f.Bucket, cmp.Or(f.Prefix, s.S3Path), cmp.Or(f.Region, s.AWSConfig.Region), f.RoleARN), fromConfig(prefix+"failover"))
+	} else {
+		add("failover", "", "")
+	}
+	key, configKey := kmsKeyFrom(s, environment)
+	add("kms_key_arn", key, cmp.Or(fromConfig(configKey), s.Sources["KMS_KEY_ARN"]))
+	if env.Chdir != "" {
+		add("chdir", env.Chdir, fromConfig(prefix+"chdir"))
+	} else {
+		add("chdir", "", "")
+	}
+	if env.Workspace != "" {
+		add("workspace", env.Workspace, fromConfig(prefix+"workspace"))
+	} else {
+		add("workspace", "", "")
+	}
+	protected, configKey := protectedFrom(s, environment)
+	add("protected", strconv.FormatBool(protected), fromConfig(configKey))
+	if env.RequireCI {
+		add("require_ci", "true", fromConfig(prefix+"require_ci"))
+	} else {
+		add("require_ci", "false", "default")
+	}
+	if env.RequireChangeMessage {
+		add("require_change_message", "true", fromConfig(prefix+"require_change_message"))
+	} else {
+		add("require_change_message", "false", "default")
+	}
+	if len(env.AssumeRoles) > 0 {
+		add("assume_roles", strings.Join(env.AssumeRoles, ", "), fromConfig(prefix+"assume_roles"))
+	} else {
+		add("assume_roles", "", "")
+	}
+	return settings
+}
+
+func (a *app) printConfigSettings(environment string) error {
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	if environment != "" {
+		if _, ok := s.TFVars[environment]; !ok {
+			return usageError("invalid environment specified: %s (valid environments: %s)", environment, strings.Join(environmentNames(s), ", "))
+		}
+	}
+	settings := configSettings(s, environment)
+	if a.out.json {
+		fields := map[string]any{"settings": settings, "config_file": s.ConfigFile}
+		if environment != "" {
+			fields["environment"] = environment
+		}
+		a.out.Event("config-show", fields)
+		return nil
+	}
+	if s.ConfigFile != "" {
+		a.out.Printf("Config file: %s\n\n", s.ConfigFile)
+	}
+	var rows [][]string
+	for _, setting := range settings {
+		rows = append(rows, []string{setting.Name, setting.Value, setting.Source})
+	}
+	a.out.Table(a.out.humanOut(), []string{"SETTING", "VALUE", "SOURCE"}, rows, nil)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfigShowEnvironment(t *testing.T) {
+	inTempDir(t)
+	for _, name := range []string{"S3_BUCKET", "S3_PATH", "KMS_KEY_ARN", "AWS_REGION", "AWS_ACCESS_KEY_ID", "STAGING_TFVARS"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_PROFILE", "deploy")
+	t.Setenv("S3_PATH", "team/")
+	os.WriteFile("tfmanage.yaml", []byte(`bucket: config-bucket
+region: eu-west-1
+kms_key_arn: arn:aws:kms:eu-west-1:123456789012:key/global
+notify:
+  webhook: https://hooks.slack.com/services/T000/B000/XXXXXXXX
+environments:
+  staging:
+    tfvars: envs/staging.tfvars
+    kms_key: arn:aws:kms:eu-west-1:123456789012:key/staging
+    protected: true
+    assume_roles:
+      - arn:aws:iam::111111111111:role/deploy
+`), 0o644)
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"--output", "json", "--metrics-job-name", "nightly", "config", "show", "staging"}, &ui{json: true, stdout: &stdout, stderr: &bytes.Buffer{}}); err != nil {
+		t.Fatalf("config show staging: %v", err)
+	}
+	var event struct {
+		Event       string          `json:"event"`
+		Environment string          `json:"environment"`
+		ConfigFile  string          `json:"config_file"`
+		Settings    []configSetting `json:"settings"`
+	}
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		if json.Unmarshal([]byte(line), &event); event.Event == "config-show" {
+			break
+		}
+	}
+	if event.Environment != "staging" || event.ConfigFile != "tfmanage.yaml" {
+		t.Errorf("event = %+v", event)
+	}
+	got := map[string]configSetting{}
+	for _, s := range event.Settings {
+		got[s.Name] = s
+	}
+	for _, want := range []configSetting{
+		{"S3_BUCKET", "config-bucket", "tfmanage.yaml: bucket"},
+		{"S3_PATH", "team/", "S3_PATH"},
+		{"AWS_REGION", "eu-west-1", "tfmanage.yaml: region"},
+		{"AWS_PROFILE", "deploy", "AWS_PROFILE"},
+		{"TFMANAGE_WEBHOOK_URL", "http" + strings.Repeat("*", 47), "tfmanage.yaml: notify.webhook"},
+		{"metrics.job_name", "nightly", "--metrics-job-name"},
+		{"STAGING_TFVARS", "envs/staging.tfvars", "tfmanage.yaml: environments.staging.tfvars"},
+		{"location", "s3://config-bucket/team/", "S3_BUCKET and S3_PATH"},
+		{"kms_key_arn", "arn:aws:kms:eu-west-1:123456789012:key/staging", "tfmanage.yaml: environments.staging.kms_key"},
+		{"protected", "true", "tfmanage.yaml: environments.staging.protected"},
+		{"assume_roles", "arn:aws:iam::111111111111:role/deploy", "tfmanage.yaml: environments.staging.assume_roles"},
+		{"workspace", "", "not set"},
+	} {
+		if got[want.Name] != want {
+			t.Errorf("setting %s = %+v, want %+v", want.Name, got[want.Name], want)
+		}
+	}
+	if _, ok := got["DEV_TFVARS"]; ok {
+		t.Error("config show staging listed the tfvars of other environments")
+	}
+
+	if err := run([]string{"config", "show", "nope"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("config show of an unknown environment: %v", err)
+	}
+}
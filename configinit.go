@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+)
+
+// config init - asks the handful of questions a new checkout needs, checks each answer as it goes and writes a commented tfmanage.yaml, rather than the settings being pieced together from the README. --from-env starts from what S3_BUCKET, S3_PATH, AWS_REGION, AWS_PROFILE and the <ENV>_TFVARS variables already say
+
+var (
+	bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	regionPattern     = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+)
+
+// the ways config init can give the tool its credentials
+
+const (
+	authProfile = "profile"
+	authRole    = "role"
+	authDefault = "default"
+)
+
+var errNoMoreAnswers = errors.New("the answers ran out before the last question")
+
+// wizard asks the questions from one reader, so answers typed ahead aren't lost between them
+
+type wizard struct {
+	a  *app
+	in *bufio.Reader
+}
+
+// ask gives back the answer, or def for an empty one
+
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.a.out.stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.a.out.stderr, "%s: ", question)
+	}
+	answer, err := w.in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read the answer: %w", err)
+	}
+	if err != nil && answer == "" {
+		return "", errNoMoreAnswers
+	}
+	return cmp.Or(strings.TrimSpace(answer), def), nil
+}
+
+// askValid asks again until check is happy with the answer
+
+func (w *wizard) askValid(question, def string, check func(string) error) (string, error) {
+	for {
+		answer, err := w.ask(question, def)
+		if err != nil {
+			return "", err
+		}
+		if err := check(answer); err != nil {
+			w.a.out.Warnf("%v", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// yesNo is askYesNo on the wizard's reader
+
+func (w *wizard) yesNo(question string) (bool, error) {
+	answer, err := w.ask(question+" [y/N]", "")
+	if errors.Is(err, errNoMoreAnswers) {
+		return false, nil
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, err
+}
+
+// envTFVars are the environments the <ENV>_TFVARS variables name, with their tfvars
+
+func envTFVars() map[string]string {
+	found := map[string]string{}
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutSuffix(key, "_TFVARS")
+		if !ok || name == "" || value == "" {
+			continue
+		}
+		if name = strings.ToLower(name); environmentNamePattern.MatchString(name) {
+			found[name] = value
+		}
+	}
+	return found
+}
+
+func (a *app) configInit(ctx context.Context, fromEnv, ci bool) error {
+	alternatives := "set S3_BUCKET, S3_PATH, AWS_REGION and the <ENV>_TFVARS variables, or write " + config.DefaultFile + " by hand, see the Config file section of the README"
+	if ci {
+		return usageError("config init asks questions and --ci never does, %s", alternatives)
+	}
+	in := a.terminalInput()
+	if in == nil {
+		return usageError("config init asks questions and there is no terminal to answer them on, %s", alternatives)
+	}
+	w := &wizard{a: a, in: bufio.NewReader(in)}
+
+	path := cmp.Or(a.global.config, config.DefaultFile)
+	if _, err := os.Stat(path); err == nil {
+		replace, err := w.yesNo(path + " already exists, replace it?")
+		if err != nil {
+			return err
+		}
+		if !replace {
+			return fmt.Errorf("config init cancelled, %s is left as it is: %w", path, errNotConfirmed)
+		}
+	}
+
+	var defaults struct{ bucket, prefix, region, profile string }
+	if fromEnv {
+		defaults.bucket, defaults.prefix = os.Getenv("S3_BUCKET"), os.Getenv("S3_PATH")
+		defaults.region, defaults.profile = os.Getenv("AWS_REGION"), os.Getenv("AWS_PROFILE")
+	}
+	cfg := &config.Config{Environments: map[string]config.Environment{}}
+	var err error
+
+	if cfg.Region, err = w.askValid("AWS region", defaults.region, func(region string) error {
+		if !regionPattern.MatchString(region) {
+			return fmt.Errorf("%q isn't an AWS region such as us-east-1", region)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	authDefaultChoice := authDefault
+	if defaults.profile != "" {
+		authDefaultChoice = authProfile
+	}
+	auth, err := w.askValid("Credentials from a profile, a role assumed with the environment's credentials, or the default AWS_PROFILE and AWS_ACCESS_KEY_ID (profile/role/default)", authDefaultChoice, func(auth string) error {
+		if !slices.Contains([]string{authProfile, authRole, authDefault}, auth) {
+			return fmt.Errorf("%q isn't one of profile, role or default", auth)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	switch auth {
+	case authProfile:
+		if cfg.Profile, err = w.askValid("AWS profile", defaults.profile, func(profile string) error {
+			if profile == "" {
+				return errors.New("the profile can't be empty, pick default to go without one")
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	case authRole:
+		if cfg.RoleARN, err = w.askValid("Role ARN", "", func(role string) error {
+			if !roleARN.MatchString(role) {
+				return fmt.Errorf("%q isn't an IAM role ARN such as arn:aws:iam::123456789012:role/tfmanage", role)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		if cfg.Bucket, err = w.askValid("S3 bucket for the tfvars", defaults.bucket, func(bucket string) error {
+			if !bucketNamePattern.MatchString(bucket) {
+				return fmt.Errorf("%q isn't an S3 bucket name, those are 3 to 63 lowercase letters, digits, dots and hyphens", bucket)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if cfg.Prefix, err = w.ask("Prefix in the bucket, empty for none", defaults.prefix); err != nil {
+			return err
+		}
+		reached, err := a.reachBucket(ctx, cfg, w)
+		if err != nil {
+			return err
+		}
+		if reached {
+			break
+		}
+		defaults.bucket, defaults.prefix = cfg.Bucket, cfg.Prefix
+	}
+	return a.initEnvironments(cfg, w, fromEnv, path)
+}
+
+// reachBucket lists the prefix with the credentials the answers give, one that can't be listed is only used when they say so
+
+func (a *app) reachBucket(ctx context.Context, cfg *config.Config, w *wizard) (bool, error) {
+	s := settings{S3Bucket: cfg.Bucket, S3Path: cfg.Prefix, AWSConfig: awsconfig.FromEnv(), Sources: map[string]string{}}
+	s.AWSConfig.Region = cfg.Region
+	if cfg.Profile != "" {
+		s.AWSConfig.Profile = cfg.Profile
+	}
+	s.AWSConfig.RoleARN = cfg.RoleARN
+	store, err := newStore(ctx, s)
+	if err == nil {
+		_, err = store.List(ctx, cfg.Prefix)
+	}
+	if err == nil {
+		a.out.Printf("s3://%s/%s can be listed\n", cfg.Bucket, cfg.Prefix)
+		return true, nil
+	}
+	a.out.Warnf("Couldn't list s3://%s/%s: %v", cfg.Bucket, cfg.Prefix, err)
+	return w.yesNo("Use it anyway?")
+}
+
+// initEnvironments asks for each environment's tfvars, then writes the file
+
+func (a *app) initEnvironments(cfg *config.Config, w *wizard, fromEnv bool, path string) error {
+	tfvarsExists := func(fileName string) error {
+		if _, err := os.Stat(fileName); err != nil {
+			a.out.Warnf("%s doesn't exist here", fileName)
+			if ok, err := w.yesNo("Use it anyway?"); err != nil || !ok {
+				return cmp.Or(err, errors.New("pick another tfvars file"))
+			}
+		}
+		return nil
+	}
+	addEnvironment := func(name, def string) error {
+		fileName, err := w.askValid("tfvars of "+name, def, func(fileName string) error {
+			if fileName == "" {
+				return errors.New("the tfvars file can't be empty")
+			}
+			return tfvarsExists(fileName)
+		})
+		if err != nil {
+			return err
+		}
+		cfg.Environments[name] = config.Environment{TFVars: fileName}
+		return nil
+	}
+
+	if fromEnv {
+		found := envTFVars()
+		for _, name := range slices.Sorted(maps.Keys(found)) {
+			if err := addEnvironment(name, found[name]); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		name, err := w.ask("Another environment, empty to finish", "")
+		if errors.Is(err, errNoMoreAnswers) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			break
+		}
+		if !environmentNamePattern.MatchString(name) {
+			a.out.Warnf("%q can't be an environment name, it starts with a letter and has letters, digits, - and _", name)
+			continue
+		}
+		if err := addEnvironment(name, name+".tfvars"); err != nil {
+			return err
+		}
+	}
+
+	if err := config.Create(path, cfg); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if _, err := config.Load(path); err != nil {
+		return err
+	}
+	a.out.Event("config-init", map[string]any{"path": path, "bucket": cfg.Bucket, "prefix": cfg.Prefix, "region": cfg.Region, "environments": slices.Sorted(maps.Keys(cfg.Environments))})
+	a.out.Successf("Wrote %s, tfmanage config show lists what it sets", path)
+	return nil
+}
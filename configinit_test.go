@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+func TestConfigInit(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	os.WriteFile("dev.tfvars", []byte("a = 1\n"), 0o644)
+	os.WriteFile("prod.tfvars", []byte("a = 2\n"), 0o644)
+
+	// a bad region and a role name are asked again, qa's missing tfvars is used anyway
+	answers := strings.Join([]string{"us-east", "eu-west-1", "role", "tfmanage", "arn:aws:iam::111111111111:role/tfmanage", "Bad_Bucket", "tfvars-bucket", "team/", "dev", "", "Prod!", "prod", "", "qa", "", "y", ""}, "\n") + "\n"
+	var out bytes.Buffer
+	if err := runWithUI([]string{"config", "init"}, &ui{stdout: &out, stderr: io.Discard, stdin: strings.NewReader(answers)}); err != nil {
+		t.Fatalf("config init: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{`"us-east" isn't an AWS region`, `"tfmanage" isn't an IAM role ARN`, `"Bad_Bucket" isn't an S3 bucket name`, "s3://tfvars-bucket/team/ can be listed", "qa.tfvars doesn't exist", "Wrote tfmanage.yaml"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output doesn't say %q:\n%s", want, out.String())
+		}
+	}
+	cfg, err := config.Load(config.DefaultFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Bucket != "tfvars-bucket" || cfg.Prefix != "team/" || cfg.Region != "eu-west-1" || cfg.RoleARN != "arn:aws:iam::111111111111:role/tfmanage" || cfg.Profile != "" {
+		t.Errorf("config = %+v", cfg)
+	}
+	if len(cfg.Environments) != 3 || cfg.Environments["dev"].TFVars != "dev.tfvars" || cfg.Environments["qa"].TFVars != "qa.tfvars" {
+		t.Errorf("environments = %+v", cfg.Environments)
+	}
+
+	// it is never replaced without a yes
+	before, _ := os.ReadFile(config.DefaultFile)
+	if err := runWithUI([]string{"config", "init"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader("\n")}); !errors.Is(err, errNotConfirmed) {
+		t.Errorf("config init over an existing file = %v, want it refused", err)
+	}
+	if after, _ := os.ReadFile(config.DefaultFile); !bytes.Equal(before, after) {
+		t.Error("config init changed the existing file")
+	}
+}
+
+func TestConfigInitFromEnv(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	os.WriteFile("dev.tfvars", []byte("a = 1\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	os.WriteFile(config.DefaultFile, []byte("bucket: old\n"), 0o644)
+
+	// replace the file, take every default, and keep a bucket that can't be listed
+	store.ListErr = storage.ErrAccessDenied
+	answers := "y\n\n\n\n\n\ny\n\n\n"
+	if err := runWithUI([]string{"config", "init", "--from-env"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader(answers)}); err != nil {
+		t.Fatalf("config init --from-env: %v", err)
+	}
+	cfg, err := config.Load(config.DefaultFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Bucket != "tfvars-bucket" || cfg.Prefix != "team/" || cfg.Region != "us-east-1" || cfg.Profile != "deploy" || cfg.Environments["dev"].TFVars != "dev.tfvars" {
+		t.Errorf("config = %+v", cfg)
+	}
+}
+
+func TestConfigInitCI(t *testing.T) {
+	inTempDir(t)
+	err := runWithUI([]string{"config", "init", "--ci"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader("us-east-1\n")})
+	if exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "<ENV>_TFVARS") {
+		t.Errorf("config init --ci = %v, want a usage error pointing at the alternatives", err)
+	}
+	if _, err := os.Stat(config.DefaultFile); err == nil {
+		t.Error("config init --ci wrote the config file")
+	}
+	if err := run([]string{"config", "show", "--from-env"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("config show --from-env = %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// config validate - the whole config file checked at once: the format from the config package, then what the commands would otherwise trip over later, ARNs, account IDs, environments that collide and the local paths it names. Every run makes the same checks when it loads the config, so a broken file fails up front and the same way whatever the command, rather than at whatever first reads the bad setting
+
+var (
+	kmsKeyPattern = regexp.MustCompile(`^arn:aws[a-z-]*:kms:[a-z0-9-]+:\d{12}:(key|alias)/.+$`)
+	secretPattern = regexp.MustCompile(`^arn:aws[a-z-]*:secretsmanager:[a-z0-9-]+:\d{12}:secret:.+$`)
+)
+
+// settingsProblems are the problems with the settings that the config package can't know about. The local paths that don't exist are only warnings, a checkout doesn't need every environment's directory
+
+func settingsProblems(s settings, allowCredentialEnv bool) []config.Problem {
+	var problems []config.Problem
+	add := func(key, message, hint string) {
+		problems = append(problems, config.Problem{Key: key, Message: message, Hint: hint})
+	}
+	warn := func(key, message, hint string) {
+		problems = append(problems, config.Problem{Key: key, Message: message, Hint: hint, Warning: true})
+	}
+
+	for _, err := range []error{checkTerraformEnvConfig(s, allowCredentialEnv), checkSecretVarsConfig(s), checkVaultVarsConfig(s), checkAssumeRolesConfig(s)} {
+		if err != nil {
+			add("", err.Error(), "")
+		}
+	}
+	if s.ExpectedBucketAccount != "" && !accountID.MatchString(s.ExpectedBucketAccount) {
+		add("expected_bucket_account", fmt.Sprintf("expected_bucket_account %q isn't a 12 digit account ID", s.ExpectedBucketAccount), "")
+	}
+	checkKMSKey := func(key, value string) {
+		if strings.HasPrefix(value, "arn:") && !kmsKeyPattern.MatchString(value) {
+			add(key, fmt.Sprintf("%s %q is not a KMS key ARN", key, value), "use arn:aws:kms:<region>:<account>:key/<id>, or the key ID or alias on its own")
+		}
+	}
+	checkSecrets := func(where string, vars map[string]config.SecretVar) {
+		for _, name := range slices.Sorted(maps.Keys(vars)) {
+			if secret := vars[name].Secret; strings.HasPrefix(secret, "arn:") && !secretPattern.MatchString(secret) {
+				add(where+"."+name, fmt.Sprintf("%s.%s %q is not a Secrets Manager secret ARN", where, name, secret), "use the secret's full ARN, or its name on its own")
+			}
+		}
+	}
+	checkKMSKey("kms_key_arn", s.KMSKeyARN)
+	checkSecrets("secret_vars", s.SecretVars)
+	if s.Hooks.PolicyDir != "" {
+		if _, err := os.Stat(s.Hooks.PolicyDir); err != nil {
+			warn("hooks.policy_dir", fmt.Sprintf("hooks.policy_dir %s doesn't exist here", s.Hooks.PolicyDir), "plans can't be checked against its policies until it does")
+		}
+	}
+
+	// the environments whose tfvars end up in the same place, or come from the same <NAME>_TFVARS
+	keptAt := map[string][]string{}
+	envVars := map[string][]string{}
+	for _, env := range environmentNames(s) {
+		envVars[tfvarsEnvVar(env)] = append(envVars[tfvarsEnvVar(env)], env)
+		prefix := "environments." + env
+		e := s.Terraform[env]
+		checkKMSKey(prefix+".kms_key_arn", e.KMSKeyARN)
+		checkKMSKey(prefix+".kms_key", e.KMSKey)
+		checkSecrets(prefix+".secret_vars", e.SecretVars)
+		if f := e.Failover; f != nil && f.RoleARN != "" && !roleARN.MatchString(f.RoleARN) {
+			add(prefix+".failover.role_arn", fmt.Sprintf("%s.failover.role_arn %q is not an IAM role ARN", prefix, f.RoleARN), "use arn:aws:iam::<account>:role/<name>")
+		}
+		if e.Chdir != "" {
+			if _, err := os.Stat(e.Chdir); err != nil {
+				warn(prefix+".chdir", fmt.Sprintf("%s.chdir %s doesn't exist here", prefix, e.Chdir), "terraform can't run for "+env+" until it does")
+			}
+		}
+		loc, err := parseLocation(e.Location)
+		if err != nil {
+			add(prefix+".location", err.Error(), "")
+			continue
+		}
+		if loc.scheme == fileScheme {
+			if _, err := os.Stat(loc.name); err != nil {
+				warn(prefix+".location", fmt.Sprintf("%s.location %s doesn't exist here", prefix, loc.name), "")
+			}
+		}
+		fileName := s.TFVars[env]
+		if fileName == "" {
+			continue
+		}
+		var at string
+		switch loc.scheme {
+		case "":
+			if s.S3Bucket != "" {
+				at = "s3://" + s.S3Bucket + "/" + storage.Key(s.S3Path, fileName)
+			}
+		case s3Scheme:
+			at = "s3://" + loc.bucket + "/" + storage.Key(loc.name, fileName)
+		case fileScheme:
+			at = strings.TrimSuffix(e.Location, "/") + "/" + fileName
+		default:
+			at = e.Location
+		}
+		if at != "" {
+			keptAt[at] = append(keptAt[at], env)
+		}
+	}
+	for _, where := range slices.Sorted(maps.Keys(keptAt)) {
+		if envs := keptAt[where]; len(envs) > 1 {
+			add("environments."+envs[1], fmt.Sprintf("%s keep their tfvars in the same place, %s, an upload of one overwrites the others", strings.Join(envs, " and "), where), "give each of them its own tfvars file or location")
+		}
+	}
+	for _, envVar := range slices.Sorted(maps.Keys(envVars)) {
+		if envs := envVars[envVar]; len(envs) > 1 {
+			add("environments."+envs[1], fmt.Sprintf("%s have their tfvars set by the same variable, %s", strings.Join(envs, " and "), envVar), "rename one of them, - and _ are the same in the variable name")
+		}
+	}
+	return problems
+}
+
+// suggestKeys adds the key that was probably meant to the problems about unknown keys
+
+func suggestKeys(problems []config.Problem) {
+	for i, p := range problems {
+		if len(p.Known) == 0 {
+			continue
+		}
+		name := p.Key[strings.LastIndex(p.Key, ".")+1:]
+		best, bestDist := "", 3
+		for _, known := range p.Known {
+			if d := levenshtein(name, known); d < bestDist {
+				best, bestDist = known, d
+			}
+		}
+		if best != "" {
+			problems[i].Hint = fmt.Sprintf("did you mean %s?", best)
+		} else {
+			problems[i].Hint = "it is one of " + strings.Join(p.Known, ", ")
+		}
+	}
+}
+
+// withLines looks up the lines of the problems that only know their key
+
+func withLines(data []byte, problems []config.Problem) {
+	for i, p := range problems {
+		if p.Line == 0 && p.Key != "" {
+			problems[i].Line = config.Line(data, p.Key)
+		}
+	}
+}
+
+// checkConfig fails a run with the problems settingsProblems finds that aren't warnings, like config validate does
+
+func checkConfig(s settings, allowCredentialEnv bool) error {
+	problems := config.Errors(settingsProblems(s, allowCredentialEnv))
+	if len(problems) == 0 {
+		return nil
+	}
+	if data, err := os.ReadFile(s.ConfigFile); err == nil {
+		withLines(data, problems)
+	}
+	return withCode(exitConfig, &config.ValidationError{Path: s.ConfigFile, Problems: problems})
+}
+
+func (a *app) validateConfig(path string) error {
+	path = cmp.Or(path, a.global.config, config.Find())
+	if path == "" {
+		return usageError("there is no config file to validate, none of %s exists", strings.Join(config.Candidates(), ", "))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return withCode(exitConfig, fmt.Errorf("failed to read config file %s: %w", path, err))
+	}
+	problems := config.Validate(data)
+	if len(config.Errors(problems)) == 0 {
+		s, err := loadSettings(path)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, settingsProblems(s, a.global.allowCredentialEnv)...)
+	}
+	suggestKeys(problems)
+	withLines(data, problems)
+
+	errs := len(config.Errors(problems))
+	for _, p := range problems {
+		severity, message := "error", p.Message
+		if p.Warning {
+			severity = "warning"
+		}
+		if p.Hint != "" {
+			message += " - " + p.Hint
+		}
+		a.out.Event("config-problem", map[string]any{"file": path, "line": p.Line, "key": p.Key, "severity": severity, "message": p.Message, "hint": p.Hint})
+		if !a.out.json {
+			a.out.Finding(severity, path, p.Line, message)
+		}
+	}
+	if errs > 0 {
+		return withCode(exitConfig, fmt.Errorf("%s is invalid, it has %s", path, plural(errs, "error")))
+	}
+	if warnings := len(problems); warnings > 0 {
+		a.out.Successf("%s is valid, with %s", path, plural(warnings, "warning"))
+		return nil
+	}
+	a.out.Successf("%s is valid", path)
+	return nil
+}
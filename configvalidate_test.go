@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	validate := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := runWithUI(append([]string{"config", "validate"}, args...), &ui{stdout: &out, stderr: io.Discard})
+		return out.String(), err
+	}
+
+	os.WriteFile("tfmanage.yaml", []byte("bucket: tfvars\nprofle: deploy\ncache:\n  max_age: 24\nenvironments:\n  dev:\n    chdr: infra\n"), 0o644)
+	out, err := validate()
+	if exitCodeFor(err) != exitConfig {
+		t.Errorf("config validate of a broken file = %v, want exit code %d", err, exitConfig)
+	}
+	for _, want := range []string{
+		"tfmanage.yaml:2: unknown key profle at the top level - did you mean profile?",
+		"tfmanage.yaml:4: cache.max_age has to be a duration",
+		"tfmanage.yaml:7: unknown key chdr in environments.dev - did you mean chdir?",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't say %q:\n%s", want, out)
+		}
+	}
+	// every other command fails on it the same way, before it does anything
+	if err := run([]string{"download", "dev"}); exitCodeFor(err) != exitConfig || !strings.Contains(err.Error(), "line 7: unknown key chdr in environments.dev - did you mean chdir?") {
+		t.Errorf("download with a broken config = %v", err)
+	}
+
+	os.WriteFile("ci.yaml", []byte(`role_arn: arn:aws:iam::111111111111:role/tfmanage
+kms_key_arn: arn:aws:kms:us-east-1:key
+environments:
+  qa:
+    tfvars: shared.tfvars
+    chdir: infra/qa
+  q-a:
+    tfvars: shared.tfvars
+  q_a:
+    tfvars: q_a.tfvars
+`), 0o644)
+	out, err = validate("ci.yaml")
+	if exitCodeFor(err) != exitConfig {
+		t.Errorf("config validate ci.yaml = %v, want exit code %d", err, exitConfig)
+	}
+	for _, want := range []string{
+		`ci.yaml:2: kms_key_arn "arn:aws:kms:us-east-1:key" is not a KMS key ARN`,
+		"q-a and qa keep their tfvars in the same place, s3://tfvars-bucket/team/shared.tfvars",
+		"ci.yaml:9: q-a and q_a have their tfvars set by the same variable, Q_A_TFVARS",
+		"ci.yaml:6: environments.qa.chdir infra/qa doesn't exist here",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't say %q:\n%s", want, out)
+		}
+	}
+
+	os.WriteFile("ok.yaml", []byte("bucket: tfvars\nenvironments:\n  qa:\n    tfvars: qa.tfvars\n    chdir: infra\n"), 0o644)
+	if out, err := validate("ok.yaml"); err != nil || !strings.Contains(out, "ok.yaml is valid, with 1 warning") {
+		t.Errorf("config validate of a file with a missing chdir = %v:\n%s", err, out)
+	}
+	os.Mkdir("infra", 0o755)
+	if out, err := validate("ok.yaml"); err != nil || !strings.Contains(out, "ok.yaml is valid") || strings.Contains(out, "warning") {
+		t.Errorf("config validate ok.yaml = %v:\n%s", err, out)
+	}
+	if _, err := validate("missing.yaml"); exitCodeFor(err) != exitConfig {
+		t.Errorf("config validate of a missing file = %v, want a config error", err)
+	}
+}
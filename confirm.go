@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The confirmation gate - commands that change the state of a protected environment make you type its name first
+
+var errNotConfirmed = errors.New("the confirmation did not match")
+
+// protectedEnvironment is true for prod and anything the config marks as protected
+
+func (a *app) protectedEnvironment(environment string) bool {
+	s, err := a.loadSettings()
+	if err != nil {
+		return environment == "prod"
+	}
+	protected, _ := protectedFrom(s, environment)
+	return protected
+}
+
+// protectedFrom also gives the config key that protects the environment, empty for prod and unprotected environments
+
+func protectedFrom(s settings, environment string) (protected bool, configKey string) {
+	if environment == "prod" {
+		return true, ""
+	}
+	if s.Terraform[environment].Protected {
+		return true, "environments." + environment + ".protected"
+	}
+	return false, ""
+}
+
+// confirm asks for the environment name before action runs on a protected environment - yes skips the question, and without a terminal to ask on it has to be passed
+
+func (a *app) confirm(environment, action string, yes bool) error {
+	if yes || !a.protectedEnvironment(environment) {
+		return nil
+	}
+	return a.askToConfirm(environment, action, fmt.Sprintf("%s changes the state of the protected %s environment.", action, environment))
+}
+
+// terminalInput is where someone can type answers, nil when stdin is not a terminal
+
+func (a *app) terminalInput() io.Reader {
+	if f, ok := a.out.stdin.(*os.File); ok && !isTerminal(f) {
+		return nil
+	}
+	return a.out.stdin
+}
+
+// askToConfirm shows the warning and asks for the environment name, whatever the environment
+
+func (a *app) askToConfirm(environment, action, warning string) error {
+	in := a.terminalInput()
+	if in == nil {
+		return usageError("%s on %s needs confirmation, pass --yes when there is no terminal to type it in", action, environment)
+	}
+
+	a.out.Warnf("%s", warning)
+	fmt.Fprintf(a.out.stderr, "Type %s to continue: ", environment)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read the confirmation: %w", err)
+	}
+	if strings.TrimSpace(answer) != environment {
+		return fmt.Errorf("%s cancelled: %w", action, errNotConfirmed)
+	}
+	return nil
+}
+
+// askYesNo asks a question that defaults to no, the answer is no when there is no terminal to ask on
+
+func (a *app) askYesNo(question string) (bool, error) {
+	in := a.terminalInput()
+	if in == nil {
+		return false, nil
+	}
+	fmt.Fprintf(a.out.stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("failed to read the answer: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// console - terraform console with the environment's tfvars, attached straight to the terminal. It only reads, so nothing is locked
+
+func consoleCommand() *command {
+	return &command{
+		name:    "console",
+		args:    "<env>",
+		summary: "Open terraform console with the environment's tfvars, to try out expressions.",
+		examples: []string{
+			"tfmanage console dev",
+			"tfmanage console prod --chdir infra",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			return func(ctx context.Context, a *app, args []string) error {
+				if a.out.machineReadable() || a.terminalInput() == nil {
+					return usageError("console is interactive, it needs a terminal and can't be used with --output json or markdown")
+				}
+				dir := a.useEnvironment(args[0], *chdir)
+				fileName, err := a.prepare("console", args[0])
+				if err != nil {
+					return err
+				}
+				if err := a.prepareTerraform(ctx, args[0]); err != nil {
+					return err
+				}
+				return terraformConsole(ctx, a, dir, fileName)
+			}
+		},
+	}
+}
+
+// terraformConsole hands the terminal to terraform and exits the way it did
+
+func terraformConsole(ctx context.Context, a *app, chdir, varFile string) error {
+	run := tfexec.RunOptions{Stdin: a.out.stdin, Stdout: a.out.stdout, Stderr: a.out.stderr, Env: a.terraformEnv()}
+	a.out.Verbosef("Running terraform %v\n", tfexec.ConsoleArgs(chdir, varFile))
+	err := tfexec.Console(ctx, runner, chdir, varFile, run)
+	var tfErr *tfexec.ErrTerraformFailed
+	// docker's own exit code when it can't reach its daemon isn't terraform's
+	if errors.As(err, &tfErr) && tfErr.ExitCode > 0 && !errors.Is(err, tfexec.ErrDockerUnavailable) {
+		return withCode(tfErr.ExitCode, err)
+	}
+	return err
+}
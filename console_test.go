@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestConsole(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    chdir: infra\n    workspace: production\n"), 0o644)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+
+	stdin := strings.NewReader("var.region\n")
+	if err := runWithUI([]string{"console", "prod"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: stdin}); err != nil {
+		t.Fatalf("console: %v", err)
+	}
+	varFile, _ := filepath.Abs("prod.tfvars")
+	call := rec.Calls[0]
+	if want := []string{"-chdir=infra", "console", "-lock=false", "-var-file", varFile}; !slices.Equal(call.Args, want) || !slices.Contains(call.Opts.Env, "TF_WORKSPACE=production") {
+		t.Errorf("console ran %q with %q", call.Args, call.Opts.Env)
+	}
+	if call.Opts.Stdin != stdin || call.Opts.Redactor != nil {
+		t.Errorf("console didn't get the terminal as it is: %+v", call.Opts)
+	}
+
+	// terraform's exit code is the tool's
+	rec.Result = func([]string) error { return &tfexec.FakeExitError{Code: 3} }
+	if err := runWithUI([]string{"console", "prod"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: stdin}); exitCodeFor(err) != 3 {
+		t.Errorf("console exit code = %d (%v), want 3", exitCodeFor(err), err)
+	}
+
+	// without a terminal, or with machine readable output, it never starts
+	notTerminal, err := os.Open("prod.tfvars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notTerminal.Close()
+	if err := runWithUI([]string{"console", "prod"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: notTerminal}); exitCodeFor(err) != exitUsage {
+		t.Errorf("console without a terminal: %v", err)
+	}
+	if err := runWithUI([]string{"--output", "json", "console", "prod"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: stdin}); exitCodeFor(err) != exitUsage {
+		t.Errorf("console with --output json: %v", err)
+	}
+	if len(rec.Calls) != 2 {
+		t.Errorf("terraform calls = %q", rec.Args())
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// the credentials terraform runs with - its own AWS_PROFILE or keys from the environment, unless the environment has assume_roles, a failover role is in use or the credentials come from a credentials command, which terraform can't run itself
+
+// useTerraformCredentials works out the credentials of the terraform runs that follow. With assume_roles it prints who terraform runs as. The profile variables are blanked so they can't win over the keys terraform is given
+
+func (a *app) useTerraformCredentials(ctx context.Context, environment string) error {
+	if a.credentialVarsFor == environment {
+		return nil
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	a.credentialVars, a.credentialsExpire = nil, time.Time{}
+	if a.global.runner == "ssm" {
+		a.out.Verbosef("Terraform runs with the role of %s, not with credentials from here\n", a.global.instanceID)
+		a.credentialVarsFor = environment
+		return nil
+	}
+	roles := s.Terraform[environment].AssumeRoles
+	if len(roles) == 0 && a.global.sessionDuration != 0 {
+		a.out.Warnf("--session-duration only applies to assume_roles, which %s doesn't have", environment)
+	}
+
+	var creds aws.Credentials
+	switch {
+	case len(roles) > 0:
+		a.out.Verbosef("Assuming %s\n", strings.Join(roles, ", then "))
+		var arn string
+		if creds, arn, err = assumeRoles(ctx, s, roles, roleSessionName(environment), a.global.sessionDuration); err != nil {
+			return err
+		}
+		a.printIdentity(environment, arn, roles, creds)
+	case s.AWSConfig.RoleARN != "":
+		if creds, err = loadCredentials(ctx, s); err != nil {
+			return err
+		}
+		a.out.Verbosef("Terraform runs as the role %s\n", s.AWSConfig.RoleARN)
+	case s.AWSConfig.CredentialsCommand != "":
+		if creds, err = loadCredentials(ctx, s); err != nil {
+			return err
+		}
+		a.out.Verbosef("Passing the credentials from %s to terraform\n", s.AWSConfig.CredentialsCommand)
+	default:
+		a.credentialVarsFor = environment
+		return nil
+	}
+	a.credentialVars = []string{
+		"AWS_ACCESS_KEY_ID=" + creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + creds.SecretAccessKey,
+		"AWS_SESSION_TOKEN=" + creds.SessionToken,
+		"AWS_PROFILE=",
+		"AWS_DEFAULT_PROFILE=",
+	}
+	if creds.CanExpire {
+		a.credentialsExpire = creds.Expires
+	}
+	a.credentialVarsFor = environment
+	return nil
+}
+
+// loadCredentials gets the tool's own credentials, the credentials command runs again only once they have expired
+
+func loadCredentials(ctx context.Context, s settings) (aws.Credentials, error) {
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	return cfg.Credentials.Retrieve(ctx)
+}
+
+// printIdentity says who terraform runs as and until when - on stderr, so it doesn't end up in piped output such as state list's
+
+func (a *app) printIdentity(environment, arn string, roles []string, creds aws.Credentials) {
+	identity := map[string]any{"environment": environment, "arn": arn, "roles": roles}
+	line := fmt.Sprintf("Terraform runs in %s as %s", environment, a.out.green(arn))
+	if creds.CanExpire {
+		identity["expires"] = creds.Expires.UTC().Format(time.RFC3339)
+		line += ", until " + creds.Expires.Local().Format("15:04 MST")
+	}
+	fmt.Fprintf(a.out.stderr, "\n%s\n\n", line)
+	a.out.Event("identity", identity)
+}
+
+// defaultMinCredentialLifetime is --min-credential-lifetime's default, SSO and assumed role sessions often only last an hour
+
+const defaultMinCredentialLifetime = 15 * time.Minute
+
+// checkCredentialLifetime warns when terraform's credentials expire within --min-credential-lifetime, since terraform fails part way through when they run out. At a terminal it asks first unless --yes is passed. Credentials that don't expire, such as static keys, aren't checked
+
+func (a *app) checkCredentialLifetime(ctx context.Context, environment, action string, yes bool) error {
+	if a.global.minCredentialLifetime <= 0 {
+		return nil
+	}
+	expires := a.credentialsExpire
+	if a.credentialVars == nil {
+		// terraform finds the same credentials the tool uses
+		s, err := a.loadSettings()
+		if err != nil {
+			return err
+		}
+		creds, err := loadCredentials(ctx, s)
+		if err != nil {
+			a.out.Verbosef("Not checking when the AWS credentials expire: %v\n", err)
+			return nil
+		}
+		if creds.CanExpire {
+			expires = creds.Expires
+		}
+	}
+	if expires.IsZero() {
+		return nil
+	}
+	left := time.Until(expires)
+	if left >= a.global.minCredentialLifetime {
+		return nil
+	}
+
+	at := expires.Local().Format(time.RFC3339)
+	a.out.Event("credentials-expiring", map[string]any{"environment": environment, "expires": expires.UTC().Format(time.RFC3339)})
+	warning := fmt.Sprintf("The AWS credentials terraform runs with expire at %s, in %s. A %s that takes longer fails part way through, refresh them first", at, left.Round(time.Second), action)
+	if left <= 0 {
+		warning = fmt.Sprintf("The AWS credentials terraform runs with expired at %s, refresh them first", at)
+	}
+	if yes || a.terminalInput() == nil {
+		a.out.Warnf("%s", warning)
+		return nil
+	}
+	return a.askToConfirm(environment, action, warning+", or pass --yes to go ahead anyway.")
+}
+
+// prepareTerraform gets what terraform needs from outside the tool before it runs for the environment: its credentials and the secret variables
+
+func (a *app) prepareTerraform(ctx context.Context, environment string) error {
+	if err := a.useTerraformCredentials(ctx, environment); err != nil {
+		return err
+	}
+	return a.useSecretVars(ctx, environment)
+}
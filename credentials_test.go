@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestCredentialsCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are sh")
+	}
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	t.Setenv("AWS_REGION", "us-east-1")
+	os.WriteFile("tfmanage.yaml", []byte(`credentials_command: >-
+  echo '{"Version": 1, "AccessKeyId": "FROMCONFIG", "SecretAccessKey": "secret", "SessionToken": "token"}'
+`), 0o644)
+
+	if err := run([]string{"plan", "prod", "plan.out"}); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	env := rec.Calls[0].Opts.Env
+	for _, want := range []string{"AWS_ACCESS_KEY_ID=FROMCONFIG", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token", "AWS_PROFILE="} {
+		if !slices.Contains(env, want) {
+			t.Errorf("terraform env = %q, want %s", env, want)
+		}
+	}
+
+	calls := len(rec.Calls)
+	err := run([]string{"--credentials-command", "echo 'no session for account X, run corp-creds login' >&2; exit 1", "apply", "prod"})
+	if exitCodeFor(err) != exitCredentials || !strings.Contains(fmt.Sprint(err), "run corp-creds login") {
+		t.Errorf("apply with a failing --credentials-command: %v", err)
+	}
+	if len(rec.Calls) != calls {
+		t.Errorf("terraform ran without credentials: %q", rec.Args()[calls:])
+	}
+}
+
+func TestCredentialLifetime(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are sh")
+	}
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	t.Setenv("AWS_REGION", "us-east-1")
+	expires := time.Now().Add(5 * time.Minute).UTC().Truncate(time.Second)
+	command := `echo '{"Version": 1, "AccessKeyId": "AKID", "SecretAccessKey": "secret", "Expiration": "` + expires.Format(time.RFC3339) + `"}'`
+
+	planWith := func(stdin string, extra ...string) (string, error) {
+		var out bytes.Buffer
+		args := append([]string{"--credentials-command", command, "plan", "prod", "plan.out"}, extra...)
+		err := runWithUI(args, &ui{stdout: &out, stderr: &out, stdin: strings.NewReader(stdin)})
+		return out.String(), err
+	}
+
+	calls := len(rec.Calls)
+	out, err := planWith("")
+	if !errors.Is(err, errNotConfirmed) || !strings.Contains(out, expires.Local().Format(time.RFC3339)) {
+		t.Errorf("plan with credentials that expire in 5m: %v\n%s", err, out)
+	}
+	if len(rec.Calls) != calls {
+		t.Errorf("terraform ran before it was confirmed: %q", rec.Args()[calls:])
+	}
+	if _, err := planWith("prod\n"); err != nil {
+		t.Errorf("plan confirmed with the environment's name: %v", err)
+	}
+	if out, err := planWith("", "--yes"); err != nil || !strings.Contains(out, "expire at") {
+		t.Errorf("plan --yes: %v, want it to go ahead with a warning\n%s", err, out)
+	}
+	if out, err := planWith("", "--min-credential-lifetime", "1m"); err != nil || strings.Contains(out, "expire at") {
+		t.Errorf("plan --min-credential-lifetime 1m: %v, want no warning\n%s", err, out)
+	}
+
+	// keys without an expiry are never checked
+	command = `echo '{"Version": 1, "AccessKeyId": "AKID", "SecretAccessKey": "secret"}'`
+	if out, err := planWith(""); err != nil || strings.Contains(out, "expire") {
+		t.Errorf("plan with credentials that don't expire: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/deployments"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/ghactions"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+)
+
+// GitHub deployments - with GITHUB_TOKEN and a repository, every apply is a deployment of the commit to the environment of the same name, in_progress while terraform runs and success or failure after. GitHub being unreachable never fails an apply
+
+// deploySteps is the repository the apply is recorded in and the log its statuses link to
+
+type deploySteps struct {
+	repo   string
+	logURL string
+}
+
+// deployment is one apply's deployment, a nil one records nothing
+
+type deployment struct {
+	repo   deployments.Repo
+	id     int64
+	env    string
+	logURL string
+	start  time.Time
+}
+
+// checkDeploySteps says what is missing before anything runs, rather than finding out after the apply
+
+func checkDeploySteps(steps deploySteps) error {
+	if steps.repo == "" {
+		if steps.logURL != "" {
+			return usageError("--log-url is the link on the GitHub deployment, it needs --github-repo or github_repo")
+		}
+		return nil
+	}
+	if !deployments.ValidRepo(steps.repo) {
+		return usageError("--github-repo %q is not owner/name", steps.repo)
+	}
+	return nil
+}
+
+// startDeployment creates the deployment and marks it in progress, nil when there is none to record
+
+func (a *app) startDeployment(ctx context.Context, environment string, steps deploySteps) *deployment {
+	if steps.repo == "" {
+		return nil
+	}
+	if os.Getenv(deployments.TokenEnv) == "" {
+		a.out.Warnf("%s is not set, the apply isn't recorded as a GitHub deployment in %s", deployments.TokenEnv, steps.repo)
+		return nil
+	}
+	commit := gitinfo.Commit(ctx)
+	if commit == "" {
+		a.out.Warnf("There is no commit to deploy, the apply isn't recorded as a GitHub deployment")
+		return nil
+	}
+	d := &deployment{repo: deployments.FromEnv(steps.repo), env: environment, logURL: cmp.Or(steps.logURL, ghactions.RunURL()), start: time.Now()}
+	var err error
+	if d.id, err = d.repo.Create(ctx, commit, environment, "tfmanage apply "+environment); err != nil {
+		a.out.Warnf("Could not record the apply as a GitHub deployment: %v", err)
+		return nil
+	}
+	if err := d.repo.SetStatus(ctx, d.id, deployments.Status{State: deployments.StateInProgress, Description: "terraform apply is running", LogURL: d.logURL}); err != nil {
+		a.out.Warnf("%v", err)
+	}
+	a.out.Event("deployment", map[string]any{"environment": environment, "repo": steps.repo, "id": d.id, "commit": commit, "state": deployments.StateInProgress})
+	a.out.Verbosef("Recording the apply as deployment %d in %s\n", d.id, steps.repo)
+	return d
+}
+
+// finish sets the deployment to success or failure, with how long the apply took
+
+func (d *deployment) finish(ctx context.Context, a *app, applyErr error) {
+	if d == nil {
+		return
+	}
+	took := time.Since(d.start).Round(time.Second)
+	status := deployments.Status{State: deployments.StateSuccess, Description: fmt.Sprintf("Applied in %s", took), LogURL: d.logURL}
+	if applyErr != nil {
+		status.State, status.Description = deployments.StateFailure, fmt.Sprintf("Apply failed after %s", took)
+	}
+	// the apply may have been cancelled, the status is still worth setting
+	if err := d.repo.SetStatus(context.WithoutCancel(ctx), d.id, status); err != nil {
+		a.out.Warnf("%v", err)
+		return
+	}
+	a.out.Event("deployment", map[string]any{"environment": d.env, "repo": d.repo.Name, "id": d.id, "state": status.State, "duration_seconds": took.Seconds()})
+}
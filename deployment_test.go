@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestGitHubDeployment(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	t.Setenv("GITHUB_SHA", "0123456789abcdef")
+	t.Setenv("GITHUB_TOKEN", "ghs_secret")
+	t.Setenv("GITHUB_RUN_ID", "")
+
+	var states []string
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, `{"message":"Server Error"}`, http.StatusInternalServerError)
+			return
+		}
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path == "/repos/acme/infra/deployments" {
+			if body["ref"] != "0123456789abcdef" || body["environment"] != "dev" {
+				t.Errorf("deployment = %v", body)
+			}
+			w.Write([]byte(`{"id": 7}`))
+			return
+		}
+		if r.URL.Path != "/repos/acme/infra/deployments/7/statuses" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		states = append(states, body["state"].(string)+" "+body["log_url"].(string))
+	}))
+	defer srv.Close()
+	t.Setenv("GITHUB_API_URL", srv.URL)
+
+	apply := []string{"apply", "dev", "--github-repo", "acme/infra", "--log-url", "https://ci.example.com/runs/1"}
+	if err := run(apply); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if strings.Join(states, ", ") != "in_progress https://ci.example.com/runs/1, success https://ci.example.com/runs/1" {
+		t.Errorf("statuses = %q", states)
+	}
+
+	states = nil
+	rec.Result = func(args []string) error {
+		if args[0] == "apply" {
+			return &tfexec.FakeExitError{Code: 1}
+		}
+		return nil
+	}
+	if err := run(apply); err == nil {
+		t.Fatal("apply that failed gave no error")
+	}
+	if len(states) != 2 || !strings.HasPrefix(states[1], "failure") {
+		t.Errorf("statuses of a failed apply = %q", states)
+	}
+
+	// GitHub failing is a warning, and the token never shows up
+	rec.Result = nil
+	fail = true
+	var out bytes.Buffer
+	if err := runWithUI(apply, &ui{stdout: &out, stderr: &out}); err != nil {
+		t.Errorf("apply with GitHub down: %v", err)
+	}
+	if !strings.Contains(out.String(), "500") || strings.Contains(out.String(), "ghs_secret") {
+		t.Errorf("output with GitHub down:\n%s", out.String())
+	}
+
+	if err := runWithUI([]string{"apply", "dev", "--github-repo", "infra"}, &ui{stdout: io.Discard, stderr: io.Discard}); exitCodeFor(err) != exitUsage {
+		t.Errorf("--github-repo without an owner: %v, want a usage error", err)
+	}
+}
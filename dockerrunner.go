@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// --runner docker - terraform runs in a container of the image instead of whatever terraform the machine has, so every laptop and runner uses the same version
+
+// dockerCLI is what runs the docker commands, a variable so the tests can record them
+
+var dockerCLI tfexec.TerraformRunner = tfexec.ExecRunner{Binary: "docker"}
+
+// useDockerRunner swaps the runner for one that goes through docker until the returned func puts it back
+
+func (a *app) useDockerRunner() func() {
+	a.out.Verbosef("Running terraform in a container of %s\n", a.global.dockerImage)
+	old := runner
+	runner = tfexec.DockerRunner{Image: a.global.dockerImage, Docker: dockerCLI}
+	return func() { runner = old }
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestDockerRunnerFlag(t *testing.T) {
+	local := &tfexec.RecordingRunner{}
+	docker := &tfexec.RecordingRunner{}
+	useRunner(t, local)
+	swap(t, &dockerCLI, tfexec.TerraformRunner(docker))
+	inTempDir(t)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+
+	if err := run([]string{"--runner", "docker:1.9.5", "test", "prod"}); err != nil {
+		t.Fatalf("test in docker: %v", err)
+	}
+	if len(local.Calls) != 0 || len(docker.Calls) != 1 {
+		t.Fatalf("local terraform ran %q, docker %q", local.Args(), docker.Args())
+	}
+	args := docker.Calls[0].Args
+	if i := slices.Index(args, "hashicorp/terraform:1.9.5"); args[0] != "run" || i < 0 || args[i+1] != "test" {
+		t.Errorf("docker %q", args)
+	}
+	if runner != local {
+		t.Error("the docker runner wasn't put back after the command")
+	}
+
+	// the flag can go after the command too
+	if err := run([]string{"test", "prod", "--runner", "docker:registry.local/terraform:1.9"}); err != nil || !slices.Contains(docker.Calls[1].Args, "registry.local/terraform:1.9") {
+		t.Errorf("test with --runner after it: %v, %q", err, docker.Args())
+	}
+
+	if err := run([]string{"--runner", "podman", "test", "prod"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("--runner podman: %v, want a usage error", err)
+	}
+
+	docker.Stderr = "Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?"
+	docker.Result = func([]string) error { return &tfexec.FakeExitError{Code: 125} }
+	err := run([]string{"--runner", "docker", "test", "prod"})
+	if exitCodeFor(err) != exitConfig || !strings.Contains(hintFor(err), "Docker") {
+		t.Errorf("docker without a daemon: %v (exit code %d)", err, exitCodeFor(err))
+	}
+}
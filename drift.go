@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/notify"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/plansummary"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// drift-detect - a plan per environment that nobody keeps, only the exit code and the counts matter
+
+const (
+	statusDrift = "DRIFT"
+	statusClean = "CLEAN"
+	statusError = "ERROR"
+)
+
+var errDriftDetected = errors.New("drift detected")
+
+type driftResult struct {
+	Environment string               `json:"environment"`
+	Status      string               `json:"status"`
+	Summary     *plansummary.Summary `json:"summary,omitempty"`
+	Error       string               `json:"error,omitempty"`
+}
+
+func driftDetectCommand() *command {
+	return &command{
+		name:    "drift-detect",
+		args:    "<env|all>",
+		summary: "Plan without saving anything to see which environments have drifted. Exits 2 when any have.",
+		examples: []string{
+			"tfmanage drift-detect prod",
+			"tfmanage drift-detect all --output json",
+			"tfmanage drift-detect all --webhook https://hooks.slack.com/services/...",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			lockTimeout := fs.String("lock-timeout", "", "take the state lock and wait this long for it, like 10s (default: don't lock at all)")
+			webhook := fs.String("webhook", "", "post drift and failures to this webhook (default TFMANAGE_WEBHOOK_URL or notify.webhook)")
+			return func(ctx context.Context, a *app, args []string) error {
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				environments := []string{args[0]}
+				if args[0] == "all" {
+					environments = nil
+					for _, name := range environmentNames(s) {
+						if s.TFVars[name] != "" {
+							environments = append(environments, name)
+						}
+					}
+					if len(environments) == 0 {
+						return configError("no environment has a tfvars file set, there is nothing to check")
+					}
+				} else if err := a.checkEnvironment(args[0]); err != nil {
+					return err
+				}
+
+				var results []driftResult
+				for _, env := range environments {
+					res := detectDrift(ctx, a, env, *chdir, *lockTimeout)
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					a.out.Event("drift", map[string]any{"environment": res.Environment, "status": res.Status, "summary": res.Summary, "error": res.Error})
+					results = append(results, res)
+				}
+				a.printDrift(results)
+
+				url := *webhook
+				if url == "" {
+					url = s.Webhook
+				}
+				return a.driftOutcome(ctx, results, url)
+			}
+		},
+	}
+}
+
+// detectDrift plans one environment into a temp file that is thrown away, anything that goes wrong ends up in the ERROR row rather than stopping the others
+
+func detectDrift(ctx context.Context, a *app, environment, chdir, lockTimeout string) driftResult {
+	res := driftResult{Environment: environment, Status: statusError}
+	fileName, err := a.prepare("plan", environment)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if err := a.prepareTerraform(ctx, environment); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	f, err := os.CreateTemp("", "tfmanage-drift-*.tfplan")
+	if err != nil {
+		res.Error = fmt.Sprintf("failed to create a plan file: %v", err)
+		return res
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	opts := tfexec.PlanOptions{
+		Chdir:            a.useEnvironment(environment, chdir),
+		VarFile:          fileName,
+		Out:              f.Name(),
+		DetailedExitCode: true,
+		NoColor:          true,
+		NoLock:           lockTimeout == "",
+		LockTimeout:      lockTimeout,
+	}
+	// terraform's output is only shown with --verbose, its errors are kept for the table
+	var stderr bytes.Buffer
+	run := a.terraformOutput()
+	run.Stdout, run.Stderr = io.Discard, &stderr
+	if a.out.verbose {
+		run.Stdout, run.Stderr = a.out.stderr, io.MultiWriter(a.out.stderr, &stderr)
+	}
+	a.out.Printf("Checking %s for drift...\n", environment)
+	a.out.Verbosef("Running terraform %v\n", tfexec.PlanArgs(opts))
+
+	err = tfexec.Plan(ctx, runner, opts, run)
+	switch {
+	case err == nil:
+		res.Status = statusClean
+	case errors.Is(err, tfexec.ErrPlanHasChanges):
+		res.Status = statusDrift
+		data, err := tfexec.Show(ctx, runner, tfexec.ShowOptions{Chdir: opts.Chdir, PlanFile: opts.Out, JSON: true}, run)
+		if err == nil {
+			var summary plansummary.Summary
+			if summary, err = plansummary.Parse(data); err == nil {
+				res.Summary = &summary
+			}
+		}
+		if err != nil {
+			res.Error = fmt.Sprintf("could not count the changes: %v", err)
+		}
+	default:
+		res.Error = err.Error()
+		if line := firstError(stderr.String()); line != "" {
+			res.Error += ": " + line
+		}
+	}
+	return res
+}
+
+// firstError picks terraform's first "Error: ..." line, which is usually all that is needed to see what went wrong
+
+func firstError(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "Error: ") {
+			return strings.TrimPrefix(line, "Error: ")
+		}
+	}
+	return ""
+}
+
+func (a *app) printDrift(results []driftResult) {
+	if a.out.json {
+		return
+	}
+	var rows [][]string
+	for _, r := range results {
+		row := []string{r.Environment, r.Status, "", "", "", r.Error}
+		if r.Summary != nil {
+			row[2], row[3], row[4] = strconv.Itoa(r.Summary.Add), strconv.Itoa(r.Summary.Change), strconv.Itoa(r.Summary.Destroy)
+		}
+		rows = append(rows, row)
+	}
+	a.out.Printf("\n")
+	a.out.Table(a.out.humanOut(), []string{"ENVIRONMENT", "STATUS", "ADD", "CHANGE", "DESTROY", "DETAIL"}, rows, func(col int, cell string) string {
+		if col == 1 {
+			return a.out.statusColor(cell)
+		}
+		return cell
+	})
+}
+
+// driftOutcome sends the alert when there is something to alert about and picks the exit code - drift wins over failures since that is what the schedule is watching for
+
+func (a *app) driftOutcome(ctx context.Context, results []driftResult, webhook string) error {
+	var drifted, failed, parts []string
+	for _, r := range results {
+		switch r.Status {
+		case statusDrift:
+			drifted = append(drifted, r.Environment)
+			if r.Summary != nil {
+				parts = append(parts, fmt.Sprintf("%s drifted (%d to add, %d to change, %d to destroy)", r.Environment, r.Summary.Add, r.Summary.Change, r.Summary.Destroy))
+			} else {
+				parts = append(parts, r.Environment+" drifted")
+			}
+		case statusError:
+			failed = append(failed, r.Environment)
+			parts = append(parts, fmt.Sprintf("the %s check failed: %s", r.Environment, r.Error))
+		}
+	}
+
+	if webhook != "" && len(parts) > 0 {
+		msg := notify.Message{
+			Text:    "Terraform drift check: " + strings.Join(parts, "; "),
+			Details: map[string]any{"results": results},
+		}
+		if err := notify.Send(ctx, webhook, msg); err != nil {
+			a.out.Warnf("Could not send the drift notification: %v", err)
+		}
+	}
+
+	switch {
+	case len(drifted) > 0:
+		return withCode(exitPlanChanges, fmt.Errorf("%w in %s", errDriftDetected, strings.Join(drifted, ", ")))
+	case len(failed) > 0:
+		return withCode(exitTerraform, fmt.Errorf("the drift check failed for %s", strings.Join(failed, ", ")))
+	}
+	a.out.Successf("No drift in %d environment(s)", len(results))
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// withDriftRunner makes dev clean, staging drift and prod fail
+
+func withDriftRunner(t *testing.T) *tfexec.RecordingRunner {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{
+		OutputFor: func(args []string) string {
+			if slices.Contains(args, "show") {
+				return `{"format_version":"1.2","resource_changes":[{"address":"aws_s3_bucket.logs","change":{"actions":["update"]}}]}`
+			}
+			return ""
+		},
+		Result: func(args []string) error {
+			if !slices.Contains(args, "plan") {
+				return nil
+			}
+			switch {
+			case slices.Contains(args, "-chdir=staging"):
+				return &tfexec.FakeExitError{Code: 2}
+			case slices.Contains(args, "-chdir=prod"):
+				return &tfexec.FakeExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	useRunner(t, rec)
+	withTFVars(t, "dev", "staging", "prod")
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  staging:\n    chdir: staging\n  prod:\n    chdir: prod\n"), 0o644)
+	return rec
+}
+
+func TestDriftDetect(t *testing.T) {
+	rec := withDriftRunner(t)
+	var payload map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+	t.Setenv("TFMANAGE_WEBHOOK_URL", srv.URL)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"drift-detect", "all"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if exitCodeFor(err) != exitPlanChanges {
+		t.Fatalf("drift-detect all: %v, want exit 2", err)
+	}
+	for _, call := range rec.Args() {
+		if slices.Contains(call, "plan") && (!slices.Contains(call, "-lock=false") || !slices.Contains(call, "-detailed-exitcode")) {
+			t.Errorf("plan ran as %q", call)
+		}
+	}
+	out := stdout.String()
+	for _, want := range []string{"dev          CLEAN", "staging      DRIFT   0    1       0", "prod         ERROR"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table is missing %q:\n%s", want, out)
+		}
+	}
+	if text, _ := payload["text"].(string); !strings.Contains(text, "staging drifted (0 to add, 1 to change, 0 to destroy)") || !strings.Contains(text, "the prod check failed") {
+		t.Errorf("webhook text = %q", text)
+	}
+}
+
+func TestDriftDetectJSONAndExitCodes(t *testing.T) {
+	rec := withDriftRunner(t)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"--output", "json", "drift-detect", "dev", "--lock-timeout", "5s"}, &ui{json: true, stdout: &stdout, stderr: io.Discard})
+	if err != nil {
+		t.Fatalf("clean environment: %v", err)
+	}
+	var drift map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var event map[string]any
+		if json.Unmarshal([]byte(line), &event) == nil && event["event"] == "drift" {
+			drift = event
+		}
+	}
+	if drift["environment"] != "dev" || drift["status"] != "CLEAN" {
+		t.Errorf("drift event = %v", drift)
+	}
+	if plan := rec.Args()[0]; !slices.Contains(plan, "-lock-timeout=5s") || slices.Contains(plan, "-lock=false") {
+		t.Errorf("plan with --lock-timeout ran as %q", plan)
+	}
+
+	if err := run([]string{"drift-detect", "prod"}); exitCodeFor(err) != exitTerraform {
+		t.Errorf("failed environment: %v, want exit %d", err, exitTerraform)
+	}
+	if err := run([]string{"drift-detect", "nope"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("unknown environment: %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// env check - works out which settings an operation needs and whether they are there, without talking to AWS. The same checks run at the start of every real command
+
+const (
+	statusOK          = "ok"
+	statusMissing     = "missing"
+	statusFileMissing = "file-missing"
+	statusUnset       = "unset"
+)
+
+type requirement struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Status   string `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	// code is the exit code used when this requirement fails
+	code int
+}
+
+func (r requirement) failed() bool {
+	return r.Required && r.Status != statusOK
+}
+
+// needsS3 is true for the operations that talk to the bucket
+
+func needsS3(operation string) bool {
+	return slices.Contains([]string{"upload", "download", "state backup", "providers sync", "plan artifacts", "bundle", "apply records", "audit trail"}, operation)
+}
+
+// needsTFVars is false for the operations that never look at an environment's tfvars
+
+func needsTFVars(operation string) bool {
+	return !slices.Contains([]string{"state backup", "providers sync", "plan artifacts", "bundle", "apply records", "audit trail"}, operation)
+}
+
+// source says where a setting came from so people know what to change
+
+func source(envVar, value string) string {
+	if os.Getenv(envVar) != "" {
+		return "from " + envSource(envVar)
+	}
+	if value != "" {
+		return "from config file"
+	}
+	return ""
+}
+
+// envSource names the env variable, and the env file when it was set from one
+
+func envSource(envVar string) string {
+	if file, ok := envFileVars[envVar]; ok {
+		return envVar + " in " + file
+	}
+	return envVar
+}
+
+func checkValue(name, value string, required bool) requirement {
+	r := requirement{Name: name, Required: required, Status: statusOK, Detail: source(name, value), code: exitConfig}
+	if value == "" {
+		r.Status = statusUnset
+		if required {
+			r.Status = statusMissing
+		}
+	}
+	return r
+}
+
+// checkTFVars looks at the tfvars path of an environment - it has to exist for everything except download, which only needs somewhere to write it
+
+func checkTFVars(operation, environment, path string, required bool) requirement {
+	name := tfvarsEnvVar(environment)
+	r := checkValue(name, path, required)
+	if path == "" {
+		return r
+	}
+	r.Detail = strings.TrimSpace(path + " " + r.Detail)
+
+	target := path
+	if operation == "download" {
+		target = filepath.Dir(path)
+	}
+	if _, err := os.Stat(target); errors.Is(err, fs.ErrNotExist) {
+		r.Status = statusFileMissing
+		if operation == "download" {
+			r.Detail = "directory " + target + " does not exist"
+		} else {
+			r.Detail = path + " does not exist"
+		}
+	}
+	return r
+}
+
+func checkCredentials(s settings, required bool) requirement {
+	r := requirement{Name: "AWS_PROFILE or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY", Required: required, Status: statusOK, code: exitCredentials}
+	env := s.AWSConfig
+	switch {
+	case env.Profile != "":
+		r.Detail = "profile " + env.Profile + " " + source("AWS_PROFILE", env.Profile)
+	case env.AccessKeyID != "" && env.SecretAccessKey != "":
+		r.Detail = "access key " + maskSecret(env.AccessKeyID)
+	default:
+		r.Status = statusUnset
+		if required {
+			r.Status = statusMissing
+		}
+	}
+	r.Detail = strings.TrimSpace(r.Detail)
+	return r
+}
+
+// maskSecret keeps just enough of a value to recognise it
+
+func maskSecret(v string) string {
+	if len(v) <= 4 {
+		return "****"
+	}
+	return v[:4] + strings.Repeat("*", len(v)-4)
+}
+
+// checkRequirements gives the state of every setting the operation uses. An empty environment checks all of them without requiring any
+
+func checkRequirements(operation, environment string, s settings) []requirement {
+	var reqs []requirement
+	switch {
+	case !needsTFVars(operation):
+	case environment != "":
+		reqs = append(reqs, checkTFVars(operation, environment, s.TFVars[environment], true))
+	default:
+		for _, name := range environmentNames(s) {
+			reqs = append(reqs, checkTFVars(operation, name, s.TFVars[name], false))
+		}
+	}
+
+	return append(reqs, checkStoreRequirements(operation, environment, s)...)
+}
+
+// checkStoreRequirements is the part of checkRequirements about reaching AWS - an environment with its own location needs AWS but not S3_BUCKET, unless the location is a local directory
+
+func checkStoreRequirements(operation, environment string, s settings) []requirement {
+	aws := needsS3(operation) && !(environment != "" && isLocalLocation(s, environment) && needsTFVars(operation))
+	bucket := aws && !(environment != "" && hasLocation(s, environment) && needsTFVars(operation))
+	return []requirement{
+		checkValue("S3_BUCKET", s.S3Bucket, bucket),
+		checkValue("S3_PATH", s.S3Path, false),
+		checkValue("AWS_REGION", s.AWSConfig.Region, aws),
+		checkCredentials(s, aws),
+	}
+}
+
+// requirementsError turns the failed requirements into one error, using the exit code of the first one
+
+func requirementsError(operation string, reqs []requirement) error {
+	var failed []string
+	code := 0
+	for _, r := range reqs {
+		if !r.failed() {
+			continue
+		}
+		if code == 0 {
+			code = r.code
+		}
+		msg := r.Name + " is " + r.Status
+		if r.Detail != "" {
+			msg += " (" + r.Detail + ")"
+		}
+		failed = append(failed, msg)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return withCode(code, fmt.Errorf("%s cannot run: %s - run 'tfmanage env check %s' for details", operation, strings.Join(failed, ", "), operation))
+}
+
+// prepare is called at the start of every operation on an environment, it gives back the tfvars file once everything the operation needs is known to be set
+
+func (a *app) prepare(operation, environment string) (string, error) {
+	fileName, err := a.tfvarsFor(environment)
+	if err != nil {
+		return "", err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return "", err
+	}
+	return fileName, requirementsError(operation, checkRequirements(operation, environment, s))
+}
+
+func envCommand() *command {
+	return &command{
+		name:    "env",
+		args:    "check [operation] [environment]",
+		summary: "Check the env variables and config an operation needs, without calling AWS.",
+		examples: []string{
+			"tfmanage env check",
+			"tfmanage env check upload prod",
+			"tfmanage env check plan dev --output json",
+			"tfmanage env check upload prod --deep",
+		},
+		minArgs: 1,
+		maxArgs: 3,
+		setup: func(fs *flag.FlagSet) runFunc {
+			deep := fs.Bool("deep", false, "also check the AWS permissions the operation needs, like preflight - needs an operation and environment")
+			return func(ctx context.Context, a *app, args []string) error {
+				if args[0] != "check" {
+					return usageError("unknown env subcommand %q, the only one is check", args[0])
+				}
+				operations := []string{"upload", "download", "plan", "apply"}
+				environment := ""
+				if len(args) > 1 {
+					if !slices.Contains(operations, args[1]) {
+						return usageError("unknown operation %q, use one of %s", args[1], strings.Join(operations, ", "))
+					}
+					operations = args[1:2]
+				}
+				if len(args) > 2 {
+					environment = args[2]
+					if _, err := a.tfvarsFor(environment); err != nil && exitCodeFor(err) == exitUsage {
+						return err
+					}
+				}
+
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+
+				var errs []error
+				for _, op := range operations {
+					reqs := checkRequirements(op, environment, s)
+					err := requirementsError(op, reqs)
+					a.printRequirements(op, environment, reqs, err == nil)
+					if err != nil {
+						errs = append(errs, err)
+					}
+				}
+				if len(errs) > 0 {
+					return withCode(exitCodeFor(errs[0]), fmt.Errorf("required settings are missing for %d operation(s)", len(errs)))
+				}
+				if *deep {
+					if environment == "" {
+						return usageError("--deep needs an operation and an environment")
+					}
+					fileName, err := a.tfvarsFor(environment)
+					if err != nil {
+						return err
+					}
+					return a.preflight(ctx, s, environment, fileName, operations[0])
+				}
+				return nil
+			}
+		},
+	}
+}
+
+func (a *app) printRequirements(operation, environment string, reqs []requirement, ok bool) {
+	if a.out.json {
+		a.out.Event("env-check", map[string]any{"operation": operation, "environment": environment, "ok": ok, "checks": reqs})
+		return
+	}
+
+	title := operation
+	if environment != "" {
+		title += " " + environment
+	}
+	a.out.Printf("%s:\n", title)
+	var rows [][]string
+	for _, r := range reqs {
+		required := "no"
+		if r.Required {
+			required = "yes"
+		}
+		rows = append(rows, []string{r.Name, required, r.Status, r.Detail})
+	}
+	a.out.Table(a.out.humanOut(), []string{"VARIABLE", "REQUIRED", "STATUS", "DETAIL"}, rows, func(col int, cell string) string {
+		if col == 2 {
+			return a.out.statusColor(cell)
+		}
+		return cell
+	})
+	a.out.Printf("\n")
+}
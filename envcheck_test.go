@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+)
+
+func findRequirement(t *testing.T, reqs []requirement, name string) requirement {
+	t.Helper()
+	for _, r := range reqs {
+		if r.Name == name {
+			return r
+		}
+	}
+	t.Fatalf("no requirement %q in %+v", name, reqs)
+	return requirement{}
+}
+
+func TestCheckRequirements(t *testing.T) {
+	inTempDir(t)
+	os.WriteFile("dev.tfvars", nil, 0o644)
+
+	full := settings{
+		S3Bucket:  "bucket",
+		TFVars:    map[string]string{"dev": "dev.tfvars", "prod": "missing/prod.tfvars", "dr": ""},
+		AWSConfig: awsconfig.Env{Profile: "deploy", Region: "us-east-1"},
+	}
+
+	t.Run("upload with everything set", func(t *testing.T) {
+		reqs := checkRequirements("upload", "dev", full)
+		if err := requirementsError("upload", reqs); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("tfvars file missing", func(t *testing.T) {
+		reqs := checkRequirements("upload", "prod", full)
+		if r := findRequirement(t, reqs, "PROD_TFVARS"); r.Status != statusFileMissing {
+			t.Errorf("PROD_TFVARS status = %q", r.Status)
+		}
+		if got := exitCodeFor(requirementsError("upload", reqs)); got != exitConfig {
+			t.Errorf("exit code = %d, want %d", got, exitConfig)
+		}
+	})
+
+	t.Run("download only needs the directory", func(t *testing.T) {
+		s := full
+		s.TFVars = map[string]string{"dev": "new.tfvars"}
+		if err := requirementsError("download", checkRequirements("download", "dev", s)); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("plan does not need S3 or credentials", func(t *testing.T) {
+		s := settings{TFVars: full.TFVars}
+		reqs := checkRequirements("plan", "dev", s)
+		if err := requirementsError("plan", reqs); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		if r := findRequirement(t, reqs, "S3_BUCKET"); r.Required || r.Status != statusUnset {
+			t.Errorf("S3_BUCKET = %+v", r)
+		}
+	})
+
+	t.Run("credentials missing", func(t *testing.T) {
+		s := full
+		s.AWSConfig = awsconfig.Env{Region: "us-east-1", AccessKeyID: "AKIA"}
+		err := requirementsError("upload", checkRequirements("upload", "dev", s))
+		if got := exitCodeFor(err); got != exitCredentials {
+			t.Errorf("exit code = %d, want %d (%v)", got, exitCredentials, err)
+		}
+	})
+
+	t.Run("no environment checks them all as optional", func(t *testing.T) {
+		reqs := checkRequirements("upload", "", full)
+		if r := findRequirement(t, reqs, "DR_TFVARS"); r.Required || r.Status != statusUnset {
+			t.Errorf("DR_TFVARS = %+v", r)
+		}
+	})
+}
+
+func TestMaskSecret(t *testing.T) {
+	if got := maskSecret("AKIAABCDEFGH"); got != "AKIA********" {
+		t.Errorf("maskSecret() = %q", got)
+	}
+	if got := maskSecret("abc"); got != "****" {
+		t.Errorf("maskSecret() = %q", got)
+	}
+}
+
+func TestEnvCheckCommand(t *testing.T) {
+	inTempDir(t)
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	t.Setenv("S3_BUCKET", "")
+
+	if err := run([]string{"env", "check", "plan", "dev"}); err != nil {
+		t.Errorf("plan check failed: %v", err)
+	}
+	if got := exitCodeFor(run([]string{"env", "check", "upload", "dev"})); got != exitConfig {
+		t.Errorf("upload check exit code = %d, want %d", got, exitConfig)
+	}
+	if got := exitCodeFor(run([]string{"env", "check", "deploy"})); got != exitUsage {
+		t.Errorf("unknown operation exit code = %d, want %d", got, exitUsage)
+	}
+	if got := exitCodeFor(run([]string{"env", "check", "plan", "nowhere"})); got != exitUsage {
+		t.Errorf("unknown environment exit code = %d, want %d", got, exitUsage)
+	}
+}
+
+func TestOperationsValidateBeforeAWS(t *testing.T) {
+	inTempDir(t)
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	t.Setenv("S3_BUCKET", "")
+
+	err := run([]string{"upload", "dev"})
+	if got := exitCodeFor(err); got != exitConfig {
+		t.Errorf("upload without a bucket exit code = %d, want %d (%v)", got, exitConfig, err)
+	}
+}
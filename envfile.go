@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/dotenv"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/metrics"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/notify"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+// The env file - a .env in the working directory, or the one --env-file names, fills in the variables the tool reads that the real env leaves unset
+
+const defaultEnvFile = ".env"
+
+// knownEnvVars are the variables an env file may set, plus every <ENV>_TFVARS
+
+var knownEnvVars = []string{
+	"S3_BUCKET", "S3_PATH", "S3_ENDPOINT", "S3_FORCE_PATH_STYLE", "KMS_KEY_ARN",
+	"AWS_PROFILE", "AWS_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+	notify.WebhookURLEnv, metrics.PushgatewayURLEnv, tools.InfracostAPIKeyEnv,
+}
+
+// envFileVars maps each variable that was set from an env file to that file, so source can tell them apart from the real env
+
+var envFileVars = map[string]string{}
+
+func knownEnvVar(name string) bool {
+	return slices.Contains(knownEnvVars, name) || (strings.HasSuffix(name, "_TFVARS") && name != "_TFVARS")
+}
+
+// loadEnvFile sets the known variables from the env file. A missing .env is fine, a missing --env-file is not
+
+func (a *app) loadEnvFile() error {
+	envFileVars = map[string]string{}
+	path, explicit := a.global.envFile, a.global.envFile != ""
+	if !explicit {
+		path = defaultEnvFile
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return configError("failed to read the env file: %v", err)
+	}
+	defer f.Close()
+	vars, err := dotenv.Parse(f)
+	if err != nil {
+		return configError("failed to read the env file %s: %v", path, err)
+	}
+
+	for _, v := range vars {
+		switch {
+		case !knownEnvVar(v.Key):
+			a.out.Verbosef("Ignoring %s on line %d of %s, tfmanage doesn't use it\n", v.Key, v.Line, path)
+		case os.Getenv(v.Key) != "" && envFileVars[v.Key] == "":
+			a.out.Verbosef("Keeping %s from the environment over line %d of %s\n", v.Key, v.Line, path)
+		default:
+			if err := os.Setenv(v.Key, v.Value); err != nil {
+				return configError("failed to set %s from %s: %v", v.Key, path, err)
+			}
+			envFileVars[v.Key] = path
+		}
+	}
+	return nil
+}
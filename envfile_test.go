@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEnvFile(t *testing.T) {
+	inTempDir(t)
+	for _, name := range []string{"S3_BUCKET", "S3_PATH", "PROD_TFVARS", "TFM_UNKNOWN"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_PROFILE", "from-shell")
+	t.Cleanup(func() { envFileVars = map[string]string{} })
+	os.WriteFile(".env", []byte("# shared\nexport S3_BUCKET=env-bucket\nS3_PATH='team/'\nPROD_TFVARS=\"prod.tfvars\" # local copy\nAWS_PROFILE=from-file\nTFM_UNKNOWN=1\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := runWithUI([]string{"--output", "json", "--verbose", "config", "show"}, &ui{json: true, verbose: true, stdout: &stdout, stderr: &stderr}); err != nil {
+		t.Fatalf("config show: %v", err)
+	}
+	var event struct {
+		Event    string          `json:"event"`
+		Settings []configSetting `json:"settings"`
+	}
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		if json.Unmarshal([]byte(line), &event); event.Event == "config-show" {
+			break
+		}
+	}
+	got := map[string]configSetting{}
+	for _, s := range event.Settings {
+		got[s.Name] = s
+	}
+	for _, want := range []configSetting{
+		{"S3_BUCKET", "env-bucket", "S3_BUCKET in .env"},
+		{"S3_PATH", "team/", "S3_PATH in .env"},
+		{"PROD_TFVARS", "prod.tfvars", "PROD_TFVARS in .env"},
+		{"AWS_PROFILE", "from-shell", "AWS_PROFILE"},
+		{"DEV_TFVARS", "", "not set"},
+	} {
+		if got[want.Name] != want {
+			t.Errorf("setting %s = %+v, want %+v", want.Name, got[want.Name], want)
+		}
+	}
+	if os.Getenv("TFM_UNKNOWN") != "" {
+		t.Error("an unknown key from the env file was set")
+	}
+	for _, want := range []string{"Ignoring TFM_UNKNOWN on line 6 of .env", "Keeping AWS_PROFILE from the environment"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr %q does not contain %q", stderr.String(), want)
+		}
+	}
+}
+
+func TestEnvFileFlag(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("S3_BUCKET", "")
+	t.Cleanup(func() { envFileVars = map[string]string{} })
+	os.WriteFile("ci.env", []byte("S3_BUCKET=ci-bucket\n"), 0o644)
+
+	if err := run([]string{"config", "show", "--env-file", "ci.env"}); err != nil {
+		t.Fatalf("config show: %v", err)
+	}
+	if got := source("S3_BUCKET", os.Getenv("S3_BUCKET")); got != "from S3_BUCKET in ci.env" {
+		t.Errorf("source = %q", got)
+	}
+	if err := run([]string{"config", "show", "--env-file", "missing.env"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("missing --env-file: %v, want a config error", err)
+	}
+	if err := os.WriteFile(".env", []byte("S3_BUCKET=\"open\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"config", "show"}); exitCodeFor(err) != exitConfig || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("broken .env: %v, want a config error with the line", err)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// failover - once an environment has failed over, its tfvars, plans and audit trail are in another bucket in another region, reached as another role. environments.<env>.failover in the config says which, and --failover or TFM_FAILOVER=1 switches a run over to them rather than S3_BUCKET, AWS_REGION and the credentials being changed by hand under pressure. Every failover run says so first and leaves a record in the audit trail of the failover bucket
+
+const failoverEnv = "TFM_FAILOVER"
+
+// the failover-check results besides ok and missing
+
+const (
+	statusUnreachable = "unreachable"
+	statusDiffers     = "differs"
+)
+
+func (a *app) failoverRequested() bool {
+	return a.global.failover || envBool(failoverEnv)
+}
+
+// failoverEnvironment is the environment a command works on, an optional [env] counts too so config show and generate-iam-policy can show the failover settings
+
+func failoverEnvironment(c *command, positional []string) string {
+	i := c.envArg()
+	if i < 0 {
+		i = slices.IndexFunc(strings.Fields(c.args), func(arg string) bool { return strings.HasPrefix(arg, "[env]") })
+	}
+	if i < 0 || i >= len(positional) {
+		return ""
+	}
+	return positional[i]
+}
+
+// failoverFor is s switched over to the environment's failover bucket, region and role. The environment's own bucket location and replicas are those of what it failed over from, so they are dropped
+
+func failoverFor(s settings, environment string) (settings, error) {
+	f := s.Terraform[environment].Failover
+	configKey := "environments." + environment + ".failover"
+	switch {
+	case f == nil:
+		return settings{}, configError("%s has no failover in the config, add %s with the bucket, region and role it fails over to", environment, configKey)
+	case f.Bucket == "":
+		return settings{}, configError("%s.bucket is missing, it is the bucket %s fails over to", configKey, environment)
+	case f.RoleARN != "" && !roleARN.MatchString(f.RoleARN):
+		return settings{}, configError("%s.role_arn %q is not an IAM role ARN", configKey, f.RoleARN)
+	}
+	s.Sources = maps.Clone(s.Sources)
+	s.S3Bucket = f.Bucket
+	s.sourced("S3_BUCKET", f.Bucket, configKey+".bucket")
+	if f.Prefix != "" {
+		s.S3Path = f.Prefix
+		s.sourced("S3_PATH", f.Prefix, configKey+".prefix")
+	}
+	if f.Region != "" {
+		s.AWSConfig.Region = f.Region
+		s.sourced("AWS_REGION", f.Region, configKey+".region")
+	}
+	s.AWSConfig.RoleARN = f.RoleARN
+	env := s.Terraform[environment]
+	if loc, err := parseLocation(env.Location); err == nil && loc.scheme == s3Scheme {
+		env.Location = ""
+	}
+	env.ReplicaBucket, env.ReplicaRegion = "", ""
+	s.ReplicaBucket, s.ReplicaRegion = "", ""
+	s.Terraform = maps.Clone(s.Terraform)
+	s.Terraform[environment] = env
+	return s, nil
+}
+
+// describeFailover is the bucket, region and role a failover run uses, in words
+
+func describeFailover(bucket, prefix, region, role string) string {
+	line := fmt.Sprintf("s3://%s/%s in %s", bucket, prefix, region)
+	if role != "" {
+		line += " as " + role
+	}
+	return line
+}
+
+// useFailover switches the run's settings over for the environment the command works on. TFM_FAILOVER left set in the shell doesn't stop the commands without an environment, --failover does
+
+func (a *app) useFailover(ctx context.Context, c *command, positional []string) error {
+	environment := failoverEnvironment(c, positional)
+	if environment == "" {
+		if a.global.failover {
+			return usageError("--failover switches an environment over to its failover bucket, %s doesn't work on one", c.name)
+		}
+		return nil
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	if s.Terraform[environment].Failover == nil {
+		return usageError("%s has no failover in the config, --failover and %s=1 are only for environments with environments.<env>.failover", environment, failoverEnv)
+	}
+	failover, err := failoverFor(s, environment)
+	if err != nil {
+		return err
+	}
+	a.settings = &failover
+	a.out.Warnf("FAILOVER: %s %s uses %s", c.name, environment, describeFailover(failover.S3Bucket, failover.S3Path, failover.AWSConfig.Region, failover.AWSConfig.RoleARN))
+	a.out.Event("failover", map[string]any{"environment": environment, "command": c.name, "bucket": failover.S3Bucket, "prefix": failover.S3Path, "region": failover.AWSConfig.Region, "role": failover.AWSConfig.RoleARN})
+	a.recordFailover(ctx, failover, environment, c.name)
+	return nil
+}
+
+// failoverRecord is one failover run in the audit trail
+
+type failoverRecord struct {
+	Environment string    `json:"environment"`
+	Command     string    `json:"command"`
+	Bucket      string    `json:"bucket"`
+	Region      string    `json:"region"`
+	Role        string    `json:"role,omitempty"`
+	Caller      string    `json:"caller,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Host        string    `json:"host,omitempty"`
+	Commit      string    `json:"commit,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// recordFailover writes the run to the audit trail of the failover bucket. A failover is when things are already going wrong, so a record that can't be written only warns
+
+func (a *app) recordFailover(ctx context.Context, s settings, environment, command string) {
+	record := failoverRecord{
+		Environment: environment,
+		Command:     command,
+		Bucket:      s.S3Bucket,
+		Region:      s.AWSConfig.Region,
+		Role:        s.AWSConfig.RoleARN,
+		User:        cmp.Or(os.Getenv("USER"), os.Getenv("USERNAME")),
+		Commit:      gitinfo.Commit(ctx),
+		At:          time.Now().UTC(),
+	}
+	record.Caller, _ = callerIdentity(ctx, s)
+	record.Host, _ = os.Hostname()
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		a.out.Warnf("Could not record the failover run in the audit trail: %v", err)
+		return
+	}
+	store, err := newStore(ctx, s)
+	if err != nil {
+		a.out.Warnf("Could not record the failover run in the audit trail: %v", err)
+		return
+	}
+	key := storage.Key(s.S3Path, path.Join(auditPrefix, environment, record.At.Format(planTimeFormat)+"-failover.json"))
+	if _, err := storage.PutBytes(ctx, store, key, data); err != nil {
+		a.out.Warnf("Could not record the failover run in the audit trail: %v", err)
+		return
+	}
+	a.out.Verbosef("Recorded the failover run in s3://%s/%s\n", s.S3Bucket, key)
+}
+
+// failoverCheck is one row of failover-check
+
+type failoverCheck struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// checkFailover makes sure the role can be assumed, the bucket can be listed and the tfvars are there and the same as the usual bucket's, without switching anything over
+
+func (a *app) checkFailover(ctx context.Context, s settings, environment, fileName string) ([]failoverCheck, error) {
+	failover, err := failoverFor(s, environment)
+	if err != nil {
+		return nil, err
+	}
+	var checks []failoverCheck
+	add := func(check, status, detail string) {
+		checks = append(checks, failoverCheck{Check: check, Status: status, Detail: detail})
+	}
+	unreachable := func(err error) string {
+		if errors.Is(err, storage.ErrAccessDenied) {
+			return statusDenied
+		}
+		return statusUnreachable
+	}
+
+	identity := "credentials"
+	if failover.AWSConfig.RoleARN != "" {
+		identity = "role"
+	}
+	if caller, err := callerIdentity(ctx, failover); err != nil {
+		add(identity, statusUnreachable, err.Error())
+	} else {
+		add(identity, statusOK, "runs as "+caller)
+	}
+
+	store, err := newStore(ctx, failover)
+	if err == nil {
+		_, err = store.List(ctx, failover.S3Path)
+	}
+	if err != nil {
+		add("bucket", unreachable(err), err.Error())
+	} else {
+		add("bucket", statusOK, fmt.Sprintf("s3://%s/%s in %s can be listed", failover.S3Bucket, failover.S3Path, failover.AWSConfig.Region))
+	}
+
+	loc, err := tfvarsStore(ctx, failover, environment, fileName)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := loc.store.Head(ctx, loc.key)
+	switch {
+	case errors.Is(err, storage.ErrObjectNotFound):
+		add("tfvars", statusMissing, loc.url(loc.key)+" doesn't exist, the environment can't be downloaded after failing over")
+		return checks, nil
+	case err != nil:
+		add("tfvars", unreachable(err), err.Error())
+		return checks, nil
+	}
+	primary, err := tfvarsStore(ctx, s, environment, fileName)
+	var usual storage.ObjectInfo
+	if err == nil {
+		usual, err = primary.store.Head(ctx, primary.key)
+	}
+	sum, usualSum := remote.Metadata[storage.ChecksumMetadataKey], usual.Metadata[storage.ChecksumMetadataKey]
+	switch {
+	case err != nil:
+		add("tfvars", statusOK, fmt.Sprintf("%s is there, it can't be compared with %s: %v", loc.url(loc.key), primary.url(primary.key), err))
+	case sum == "" || usualSum == "":
+		add("tfvars", statusOK, fmt.Sprintf("%s is there, without the checksums to compare it with %s", loc.url(loc.key), primary.url(primary.key)))
+	case sum != usualSum:
+		add("tfvars", statusDiffers, fmt.Sprintf("%s isn't the same as %s, replication is behind or one of them was changed on its own", loc.url(loc.key), primary.url(primary.key)))
+	default:
+		add("tfvars", statusOK, fmt.Sprintf("%s is the same as %s", loc.url(loc.key), primary.url(primary.key)))
+	}
+	return checks, nil
+}
+
+func failoverCheckCommand() *command {
+	return &command{
+		name:    "failover-check",
+		args:    "<env>",
+		summary: "Check the environment's failover bucket, region and role can be reached and have its tfvars, ahead of a failover or as part of a DR drill.",
+		examples: []string{
+			"tfmanage failover-check dr",
+			"tfmanage failover-check dr --output json",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(ctx context.Context, a *app, args []string) error {
+				environment := args[0]
+				fileName, err := a.tfvarsFor(environment)
+				if err != nil {
+					return err
+				}
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				checks, err := a.checkFailover(ctx, s, environment, fileName)
+				if err != nil {
+					return err
+				}
+				failed := 0
+				var rows [][]string
+				for _, c := range checks {
+					if c.Status != statusOK && c.Status != statusDiffers {
+						failed++
+					}
+					a.out.Event("failover-check", map[string]any{"environment": environment, "check": c.Check, "status": c.Status, "detail": c.Detail})
+					rows = append(rows, []string{c.Check, c.Status, c.Detail})
+				}
+				if !a.out.json {
+					a.out.Table(a.out.humanOut(), []string{"CHECK", "STATUS", "DETAIL"}, rows, func(col int, cell string) string {
+						if col == 1 {
+							return a.out.statusColor(cell)
+						}
+						return cell
+					})
+				}
+				if failed > 0 {
+					return withCode(exitCheck, fmt.Errorf("%d of the %d failover checks of %s failed", failed, len(checks), environment))
+				}
+				return nil
+			}
+		},
+	}
+}
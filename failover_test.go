@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+const failoverRole = "arn:aws:iam::222222222222:role/tfmanage-failover"
+
+// withFailoverStore keeps the dr environment's failover bucket in its own memory store, next to the usual one
+func withFailoverStore(t *testing.T) (primary, failover *storage.MemoryStore, used *settings) {
+	t.Helper()
+	withTFVars(t, "dr", "dev")
+	primary = withMemoryStore(t)
+	failover = storage.NewMemoryStore()
+	used = &settings{}
+	swap(t, &newStore, func(_ context.Context, s settings) (storage.Backend, error) {
+		if s.S3Bucket == "tfvars-failover" {
+			*used = s
+			return failover, nil
+		}
+		return primary, nil
+	})
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dr:\n    failover:\n      bucket: tfvars-failover\n      region: us-west-2\n      role_arn: "+failoverRole+"\n"), 0o644)
+	os.WriteFile("dr.tfvars", []byte("region = \"us-west-2\"\n"), 0o644)
+	return primary, failover, used
+}
+
+func TestFailover(t *testing.T) {
+	primary, failover, used := withFailoverStore(t)
+	withCaller(t, "arn:aws:sts::222222222222:assumed-role/tfmanage-failover/tfmanage")
+
+	var out bytes.Buffer
+	if err := runWithUI([]string{"upload", "dr", "--allow-dirty", "--failover"}, &ui{stdout: &out, stderr: io.Discard}); err != nil {
+		t.Fatalf("upload --failover: %v", err)
+	}
+	if want := "FAILOVER: upload dr uses s3://tfvars-failover/team/ in us-west-2 as " + failoverRole; !strings.Contains(out.String(), want) {
+		t.Errorf("output doesn't say %q:\n%s", want, out.String())
+	}
+	if used.AWSConfig.Region != "us-west-2" || used.AWSConfig.RoleARN != failoverRole {
+		t.Errorf("failover store with %+v, want the failover region and role", used.AWSConfig)
+	}
+	if _, ok := failover.Bytes("team/dr.tfvars"); !ok || primary.Puts() != 0 {
+		t.Error("the upload didn't go to the failover bucket alone")
+	}
+	audit, err := failover.List(context.Background(), "team/audit/dr/")
+	if err != nil || len(audit) != 1 || !strings.HasSuffix(audit[0].Key, "-failover.json") {
+		t.Fatalf("audit trail = %+v, %v, want one failover record", audit, err)
+	}
+	data, _ := failover.Bytes(audit[0].Key)
+	var record failoverRecord
+	if err := json.Unmarshal(data, &record); err != nil || record.Command != "upload" || record.Role != failoverRole || record.Caller == "" {
+		t.Errorf("audit record = %+v, %v", record, err)
+	}
+
+	// TFM_FAILOVER does the same, and only for environments that have a failover
+	t.Setenv(failoverEnv, "1")
+	if err := run([]string{"download", "dr", "--force"}); err != nil {
+		t.Errorf("download with %s=1: %v", failoverEnv, err)
+	}
+	if err := run([]string{"download", "dev"}); exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "dev has no failover") {
+		t.Errorf("download of an environment without a failover = %v, want a usage error", err)
+	}
+	if err := run([]string{"config", "path"}); err != nil {
+		t.Errorf("config path with %s=1 = %v, want it left alone", failoverEnv, err)
+	}
+	t.Setenv(failoverEnv, "")
+	if err := run([]string{"config", "path", "--failover"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("config path --failover = %v, want a usage error", err)
+	}
+}
+
+func TestFailoverConfig(t *testing.T) {
+	withFailoverStore(t)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dr:\n    failover:\n      region: us-west-2\n      role_arn: tfmanage-failover\n"), 0o644)
+	if err := run([]string{"download", "dr", "--failover"}); exitCodeFor(err) != exitConfig || !strings.Contains(err.Error(), "failover.bucket is missing") {
+		t.Errorf("failover without a bucket = %v, want a config error", err)
+	}
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dr:\n    failover:\n      bucket: tfvars-failover\n      role_arn: tfmanage-failover\n"), 0o644)
+	if err := run([]string{"failover-check", "dr"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("failover with a role name = %v, want a config error", err)
+	}
+}
+
+func TestFailoverCheck(t *testing.T) {
+	primary, failover, _ := withFailoverStore(t)
+	withCaller(t, "arn:aws:sts::222222222222:assumed-role/tfmanage-failover/tfmanage")
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	check := func() error {
+		out.Reset()
+		return runWithUI([]string{"failover-check", "dr"}, &ui{stdout: &out, stderr: io.Discard})
+	}
+	if err := check(); exitCodeFor(err) != exitCheck || !strings.Contains(out.String(), "tfvars  missing") {
+		t.Errorf("failover-check without the tfvars = %v:\n%s", err, out.String())
+	}
+
+	if _, err := storage.UploadKey(ctx, primary, "team/dr.tfvars", "dr.tfvars", storage.UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.UploadKey(ctx, failover, "team/dr.tfvars", "dr.tfvars", storage.UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(); err != nil || !strings.Contains(out.String(), "is the same as s3://tfvars-bucket/team/dr.tfvars") {
+		t.Errorf("failover-check = %v:\n%s", err, out.String())
+	}
+	if audit, _ := failover.List(ctx, "team/audit/"); len(audit) != 0 {
+		t.Error("failover-check left a record in the audit trail, it doesn't fail over")
+	}
+
+	os.WriteFile("dr.tfvars", []byte("region = \"eu-west-1\"\n"), 0o644)
+	storage.UploadKey(ctx, primary, "team/dr.tfvars", "dr.tfvars", storage.UploadOptions{})
+	if err := check(); err != nil || !strings.Contains(out.String(), statusDiffers) {
+		t.Errorf("failover-check of a copy that is behind = %v, want it reported without failing:\n%s", err, out.String())
+	}
+
+	failover.ListErr = storage.ErrAccessDenied
+	if err := check(); exitCodeFor(err) != exitCheck || !strings.Contains(out.String(), "bucket  denied") {
+		t.Errorf("failover-check of a bucket the role can't list = %v:\n%s", err, out.String())
+	}
+}
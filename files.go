@@ -0,0 +1,262 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// put and get - files that aren't tfvars but belong to an environment, like backend configs or known_hosts, kept under files/<env>/ next to the tfvars with the same checksums, metadata and encryption
+
+const filesPrefix = "files"
+
+// fileObjectName cleans up the name a file is stored under, anything that would land outside the environment's files is refused
+
+func fileObjectName(name string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if name == "" || cleaned == "." || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") || strings.ContainsFunc(cleaned, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return "", usageError("%q is outside the environment's files, use a relative name such as backend.hcl or ssh/known_hosts", name)
+	}
+	return cleaned, nil
+}
+
+// filesLocation is where the environment's files are kept, the same store and key as its tfvars apart from the name. SSM and Secrets Manager only hold the one value so they have no room for files
+
+func (a *app) filesLocation(ctx context.Context, operation, environment string) (tfvarsLocation, string, error) {
+	if err := a.checkEnvironment(environment); err != nil {
+		return tfvarsLocation{}, "", err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return tfvarsLocation{}, "", err
+	}
+	if err := requirementsError(operation, checkStoreRequirements("download", environment, s)); err != nil {
+		return tfvarsLocation{}, "", err
+	}
+	loc, err := a.locate(ctx, s, environment, "")
+	if err != nil {
+		return tfvarsLocation{}, "", err
+	}
+	if loc.scheme == ssmScheme || loc.scheme == secretsManagerScheme {
+		return tfvarsLocation{}, "", usageError("the %s tfvars are in %s, which can't hold other files, %s only works with a bucket or a local directory", environment, loc.service, operation)
+	}
+	a.logKMSKey(s, environment)
+	return loc, storage.Key(loc.key, filesPrefix+"/"+environment+"/"), nil
+}
+
+// fileKey joins the prefix and the name, fileObjectName has already refused anything that would climb out of the prefix
+
+func fileKey(prefix, name string) (string, error) {
+	cleaned, err := fileObjectName(name)
+	if err != nil {
+		return "", err
+	}
+	return storage.Key(prefix, cleaned), nil
+}
+
+func putCommand() *command {
+	return &command{
+		name:    "put",
+		args:    "<env> <local-path>",
+		summary: "Upload any file, such as a backend config or known_hosts, to the environment's files in the bucket.",
+		examples: []string{
+			"tfmanage put prod backend.hcl",
+			"tfmanage put prod ~/.ssh/known_hosts --as ssh/known_hosts",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			as := fs.String("as", "", "the name to store the file under (default the file's own name)")
+			skipUnchanged := fs.Bool("skip-unchanged", false, "don't upload when the remote file already has the same content")
+			allowPublic := fs.Bool("allow-public-bucket", false, "upload even when the bucket allows public access")
+			allowCrossAccount := fs.Bool("allow-cross-account-bucket", false, "upload even when the bucket isn't owned by expected_bucket_account or the account of the AWS credentials")
+			strict := fs.Bool("strict", false, "fail when the bucket's owner or public access settings can't be checked, instead of warning")
+			contentType := fs.String("content-type", "", "the Content-Type the object is stored with (default from the file name)")
+			storageClass := fs.String("storage-class", "", "the S3 storage class of the file: "+strings.Join(storage.StorageClasses, ", ")+" (default STANDARD)")
+			allowDirty := fs.Bool("allow-dirty", false, "upload even when the environment requires a clean git checkout and the file has uncommitted changes")
+			return func(ctx context.Context, a *app, args []string) error {
+				environment, fileName := args[0], args[1]
+				if err := checkStorageClass(*storageClass); err != nil {
+					return err
+				}
+				name := cmp.Or(*as, filepath.Base(fileName))
+				loc, prefix, err := a.filesLocation(ctx, "put", environment)
+				if err != nil {
+					return err
+				}
+				key, err := fileKey(prefix, name)
+				if err != nil {
+					return err
+				}
+				if err := a.lockEnvironment(environment, "put"); err != nil {
+					return err
+				}
+				git := gitinfo.File(ctx, fileName)
+				if git.Dirty && !*allowDirty && a.requireCleanGit(environment) {
+					return usageError("%s has changes that aren't committed, commit them or pass --allow-dirty to upload it anyway", fileName)
+				}
+				if err := a.checkBucket(ctx, loc, bucketCheck{allowPublic: *allowPublic, allowCrossAccount: *allowCrossAccount, strict: *strict}); err != nil {
+					return err
+				}
+				a.out.Printf("Uploading %s to %s...\n", fileName, loc.service)
+
+				opts := storage.UploadOptions{SkipUnchanged: *skipUnchanged, Metadata: gitMetadata(git), ContentType: *contentType, StorageClass: *storageClass}
+				if loc.bucket != "" {
+					opts.KMSKeyID = loc.kmsKey
+				}
+				res, err := storage.UploadKey(ctx, loc.store, key, fileName, opts)
+				if err != nil {
+					return err
+				}
+				a.out.Event("put", map[string]any{"environment": environment, "file": fileName, "bucket": loc.bucket, "key": res.Key, "sha256": res.Checksum, "skipped": res.Skipped})
+				if res.Skipped {
+					a.out.Warnf("%s is unchanged at %s, skipping upload", fileName, loc.url(key))
+					return nil
+				}
+				a.out.Successf("Uploaded %s to %s", fileName, loc.url(key))
+				return nil
+			}
+		},
+	}
+}
+
+func getCommand() *command {
+	return &command{
+		name:    "get",
+		args:    "<env> <name>",
+		summary: "Download one of the environment's files stored with put.",
+		examples: []string{
+			"tfmanage get prod backend.hcl",
+			"tfmanage get prod ssh/known_hosts --to ~/.ssh/known_hosts",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			to := fs.String("to", "", "where to write the file (default its name in the current directory)")
+			restore := addRestoreFlags(fs)
+			return func(ctx context.Context, a *app, args []string) error {
+				environment, name := args[0], args[1]
+				if err := restore.check(); err != nil {
+					return err
+				}
+				loc, prefix, err := a.filesLocation(ctx, "get", environment)
+				if err != nil {
+					return err
+				}
+				key, err := fileKey(prefix, name)
+				if err != nil {
+					return err
+				}
+				fileName := cmp.Or(*to, path.Base(key))
+
+				var remote storage.ObjectInfo
+				if loc.bucket != "" {
+					if remote, err = loc.store.Head(ctx, key); err == nil {
+						a.checkObjectKey(loc, remote)
+					}
+				}
+				numBytes, err := storage.DownloadKey(ctx, loc.store, key, fileName)
+				if errors.Is(err, storage.ErrArchived) {
+					if err = a.restoreArchived(ctx, loc.store, loc.url(key), key, *restore, err); err == nil {
+						numBytes, err = storage.DownloadKey(ctx, loc.store, key, fileName)
+					}
+				}
+				if err != nil {
+					return kmsDecryptError(err, cmp.Or(remote.KMSKeyID, loc.kmsKey))
+				}
+				if sum := remote.Metadata[storage.ChecksumMetadataKey]; sum != "" {
+					if local, err := storage.FileChecksum(fileName); err == nil && local != sum {
+						return withCode(exitTransfer, fmt.Errorf("%s was written to %s but doesn't match the checksum it was uploaded with, it may have been changed in the bucket", loc.url(key), fileName))
+					}
+				}
+				a.out.Event("get", map[string]any{"environment": environment, "file": fileName, "bucket": loc.bucket, "key": key, "bytes": numBytes})
+				a.out.Successf("Downloaded %s to %s (%d bytes)", loc.url(key), fileName, numBytes)
+				return nil
+			}
+		},
+	}
+}
+
+func listCommand() *command {
+	return &command{
+		name:    "list",
+		args:    "<env>",
+		summary: "List the environment's tfvars and the files stored with put.",
+		examples: []string{
+			"tfmanage list prod",
+			"tfmanage list prod --output json",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(ctx context.Context, a *app, args []string) error {
+				environment := args[0]
+				fileName, err := a.tfvarsFor(environment)
+				if err != nil {
+					return err
+				}
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				if err := requirementsError("list", checkStoreRequirements("download", environment, s)); err != nil {
+					return err
+				}
+				loc, err := a.locate(ctx, s, environment, fileName)
+				if err != nil {
+					return err
+				}
+
+				var rows [][]string
+				add := func(kind string, o storage.ObjectInfo) {
+					class := o.StorageClass
+					if class == "" && loc.bucket != "" {
+						class = "STANDARD"
+					}
+					a.out.Event("file", map[string]any{"environment": environment, "kind": kind, "key": o.Key, "size": o.Size, "last_modified": o.LastModified, "storage_class": class, "restore": o.Restore.String()})
+					modified := ""
+					if !o.LastModified.IsZero() {
+						modified = o.LastModified.Format(time.RFC3339)
+					}
+					rows = append(rows, []string{kind, loc.url(o.Key), sizeOrBlank(o.Size), modified, class, o.Restore.String()})
+				}
+				if tfvars, err := loc.store.Head(ctx, loc.key); err == nil {
+					add("tfvars", tfvars)
+				} else if !errors.Is(err, storage.ErrObjectNotFound) {
+					return err
+				}
+				if loc.scheme != ssmScheme && loc.scheme != secretsManagerScheme {
+					base, err := a.locate(ctx, s, environment, "")
+					if err != nil {
+						return err
+					}
+					files, err := loc.store.List(ctx, storage.Key(base.key, filesPrefix+"/"+environment+"/"))
+					if err != nil {
+						return err
+					}
+					for _, f := range files {
+						add("file", f)
+					}
+				}
+				if a.out.json {
+					return nil
+				}
+				if len(rows) == 0 {
+					a.out.Printf("Nothing is stored for %s yet\n", environment)
+					return nil
+				}
+				a.out.Table(a.out.humanOut(), []string{"KIND", "LOCATION", "SIZE", "MODIFIED", "STORAGE CLASS", "RESTORE"}, rows, nil)
+				return nil
+			}
+		},
+	}
+}
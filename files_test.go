@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileKey(t *testing.T) {
+	for name, want := range map[string]string{
+		"backend.hcl":        "team/files/prod/backend.hcl",
+		"ssh/known_hosts":    "team/files/prod/ssh/known_hosts",
+		"./ssh//known_hosts": "team/files/prod/ssh/known_hosts",
+		`ssh\known_hosts`:    "team/files/prod/ssh/known_hosts",
+		"a/../backend.hcl":   "team/files/prod/backend.hcl",
+		"../dev/backend.hcl": "",
+		"/etc/passwd":        "",
+		"..":                 "",
+		".":                  "",
+		"":                   "",
+		"bad\nname":          "",
+	} {
+		got, err := fileKey("team/files/prod/", name)
+		if want == "" {
+			if exitCodeFor(err) != exitUsage {
+				t.Errorf("fileKey(%q) = %q, %v, want a usage error", name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("fileKey(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestPutGetList(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	t.Setenv("KMS_KEY_ARN", planKMSKey)
+	os.WriteFile("prod.tfvars", []byte("a = 1\n"), 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	os.WriteFile("known_hosts", []byte("github.com ssh-ed25519 AAAA\n"), 0o644)
+
+	if err := run([]string{"put", "prod", "known_hosts", "--as", "ssh/known_hosts"}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	info, err := store.Head(context.Background(), "team/files/prod/ssh/known_hosts")
+	if err != nil || info.KMSKeyID != planKMSKey || info.Metadata["sha256"] == "" || info.Metadata["git-sha"] == "" {
+		t.Fatalf("stored %+v, %v, want the file encrypted with its checksum and git metadata", info, err)
+	}
+	for _, as := range []string{"../../prod.tfvars", "../../prod/x"} {
+		if err := run([]string{"put", "prod", "known_hosts", "--as", as}); exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "outside the environment's files") {
+			t.Errorf("put --as %s: %v, want a usage error", as, err)
+		}
+	}
+	if store.Puts() != 1 {
+		t.Fatalf("%d puts, want only the known_hosts inside the files", store.Puts())
+	}
+	if err := run([]string{"upload", "prod"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	if err := run([]string{"get", "prod", "ssh/known_hosts", "--to", "copy"}); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if data, _ := os.ReadFile("copy"); string(data) != "github.com ssh-ed25519 AAAA\n" {
+		t.Errorf("got %q", data)
+	}
+	os.Remove("known_hosts")
+	if err := run([]string{"get", "prod", "ssh/known_hosts"}); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if _, err := os.Stat("known_hosts"); err != nil {
+		t.Errorf("get without --to: %v, want the file under its own name", err)
+	}
+	if err := run([]string{"get", "prod", "missing"}); exitCodeFor(err) == exitOK {
+		t.Errorf("get of a missing file gave no error")
+	}
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"list", "prod"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	for _, want := range []string{"s3://tfvars-bucket/team/prod.tfvars", "s3://tfvars-bucket/team/files/prod/ssh/known_hosts"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("list = %q, want %s in it", stdout.String(), want)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package main
+
+// without process groups to ask, terraform always gets the interrupt from tfmanage
+
+var inForeground = func() bool { return false }
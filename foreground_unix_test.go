@@ -0,0 +1,65 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// a SIGINT sent only to tfmanage, the way kill -INT or a CI runner aborting a job does, never reached terraform, so terraform gets it from tfmanage exactly once
+
+func TestInterruptToPIDReachesTerraform(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		foreground bool
+		want       string
+	}{
+		{"sent to the pid", false, "interrupted\ndone\n"},
+		// the terminal's Ctrl-C interrupts the whole foreground process group, terraform included
+		{"Ctrl-C at the terminal", true, "done\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			swap(t, &inForeground, func() bool { return tt.foreground })
+			ctx, stop, err := newContext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stop()
+			time.AfterFunc(100*time.Millisecond, func() { syscall.Kill(os.Getpid(), syscall.SIGINT) })
+
+			var out strings.Builder
+			tfexec.ExecRunner{Binary: "sh", KillDelay: 5 * time.Second}.Run(ctx, []string{"-c", "trap 'echo interrupted' INT; sleep 0.5; echo done"}, tfexec.RunOptions{Stdout: &out})
+			if out.String() != tt.want {
+				t.Errorf("terraform printed %q, want %q", out.String(), tt.want)
+			}
+			if got := errors.Is(context.Cause(ctx), tfexec.ErrInterrupted); got != tt.foreground {
+				t.Errorf("cancelled as a Ctrl-C at the terminal = %v, want %v", got, tt.foreground)
+			}
+		})
+	}
+}
+
+func TestInForegroundWithoutTerminal(t *testing.T) {
+	if os.Getenv("TFM_TEST_FOREGROUND") == "1" {
+		if inForeground() {
+			os.Exit(3)
+		}
+		os.Exit(0)
+	}
+	// a session of its own has no controlling terminal, like a CI job
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInForegroundWithoutTerminal$")
+	cmd.Env = append(os.Environ(), "TFM_TEST_FOREGROUND=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Run(); err != nil {
+		t.Errorf("inForeground() without a terminal = true, want false: %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build windows
+
+package main
+
+// a console's Ctrl-C goes to every process attached to it, there is no interrupting tfmanage alone
+
+var inForeground = func() bool { return true }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/iampolicy"
+)
+
+// generate-iam-policy - the policy the tool needs, built from the bucket, prefix and environment locations in the config so platform teams don't have to guess
+
+const (
+	modeReadOnly  = "read-only"
+	modeReadWrite = "read-write"
+)
+
+// objectsARN is every object under the prefix, the plans, state backups and provider mirrors live there too
+
+func objectsARN(partition, bucket, prefix string) string {
+	return fmt.Sprintf("arn:%s:s3:::%s/%s*", partition, bucket, prefix)
+}
+
+// iamPolicy builds the policy for the environments - read-only is download, versions, plan and status, read-write adds upload, apply, state backups with their pruning, stored plans and restores of archived objects
+
+func iamPolicy(s settings, environments []string, scope arnScope, write bool) (*iampolicy.Document, error) {
+	doc := iampolicy.NewDocument()
+	bucket := func(name, prefix string) {
+		bucketARN := fmt.Sprintf("arn:%s:s3:::%s", scope.partition, name)
+		doc.Allow("ListTfvarsBucket", []string{"s3:ListBucket", "s3:ListBucketVersions"}, bucketARN)
+		doc.Allow("ReadTfvars", []string{"s3:GetObject", "s3:GetObjectVersion"}, objectsARN(scope.partition, name, prefix))
+		if write {
+			doc.Allow("WriteTfvars", []string{"s3:PutObject", "s3:DeleteObject", "s3:RestoreObject"}, objectsARN(scope.partition, name, prefix))
+			doc.Allow("CheckBucketPublicAccess", []string{"s3:GetBucketPublicAccessBlock", "s3:GetBucketPolicyStatus"}, bucketARN)
+			doc.Allow("CheckBucketOwner", []string{"s3:GetBucketAcl", "s3:GetBucketOwnershipControls"}, bucketARN)
+		}
+	}
+	// the replica is only ever read from
+	replica := func(name, prefix string) {
+		doc.Allow("ListTfvarsReplica", []string{"s3:ListBucket", "s3:ListBucketVersions"}, fmt.Sprintf("arn:%s:s3:::%s", scope.partition, name))
+		doc.Allow("ReadTfvarsReplica", []string{"s3:GetObject", "s3:GetObjectVersion"}, objectsARN(scope.partition, name, prefix))
+	}
+	if s.S3Bucket != "" {
+		bucket(s.S3Bucket, s.S3Path)
+		if s.ReplicaBucket != "" {
+			replica(s.ReplicaBucket, s.S3Path)
+		}
+	}
+
+	var keyARNs, aliases []string
+	for _, environment := range environments {
+		env := s.Terraform[environment]
+		loc, err := parseLocation(env.Location)
+		if err != nil {
+			return nil, err
+		}
+		switch loc.scheme {
+		case s3Scheme:
+			bucket(loc.bucket, loc.name)
+			if env.ReplicaBucket != "" {
+				replica(env.ReplicaBucket, loc.name)
+			}
+		case "":
+			if env.ReplicaBucket != "" {
+				replica(env.ReplicaBucket, s.S3Path)
+			}
+		case ssmScheme:
+			param := scope.arn("ssm", "parameter"+loc.name)
+			doc.Allow("ReadParameters", []string{"ssm:GetParameter", "ssm:GetParameterHistory"}, param, param+"/chunks/*")
+			if write {
+				doc.Allow("WriteParameters", []string{"ssm:PutParameter"}, param, param+"/chunks/*")
+			}
+		case secretsManagerScheme:
+			secret := scope.arn("secretsmanager", "secret:"+loc.name+"-*")
+			doc.Allow("ReadSecrets", []string{"secretsmanager:GetSecretValue", "secretsmanager:ListSecretVersionIds"}, secret)
+			if write {
+				doc.Allow("WriteSecrets", []string{"secretsmanager:PutSecretValue", "secretsmanager:CreateSecret"}, secret)
+			}
+		}
+		key, _ := kmsKeyFor(s, environment)
+		switch {
+		case key == "" || loc.scheme == fileScheme:
+		case strings.HasPrefix(key, "alias/"):
+			if !slices.Contains(aliases, key) {
+				aliases = append(aliases, key)
+			}
+		default:
+			keyARNs = append(keyARNs, kmsKeyARN(key, scope))
+		}
+	}
+
+	kms := func(sid string, actions []string) {
+		if len(keyARNs) > 0 {
+			doc.Allow(sid, actions, keyARNs...)
+		}
+		// a policy naming an alias ARN doesn't cover the key behind it, the alias has to be matched with a condition
+		if len(aliases) > 0 {
+			st := doc.Allow(sid+"ByAlias", actions, scope.arn("kms", "key/*"))
+			st.Condition = map[string]map[string][]string{"ForAnyValue:StringEquals": {"kms:ResourceAliases": aliases}}
+		}
+	}
+	kms("DecryptTfvars", []string{"kms:Decrypt"})
+	if write {
+		kms("EncryptTfvars", []string{"kms:Encrypt", "kms:GenerateDataKey"})
+	}
+	return doc, nil
+}
+
+// needsAccount is true when some ARN has the account in it, S3 ARNs don't
+
+func needsAccount(s settings, environments []string) bool {
+	for _, environment := range environments {
+		loc, err := parseLocation(s.Terraform[environment].Location)
+		key, _ := kmsKeyFor(s, environment)
+		if err == nil && (loc.scheme == ssmScheme || loc.scheme == secretsManagerScheme || (key != "" && !strings.HasPrefix(key, "arn:"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func generateIAMPolicyCommand() *command {
+	return &command{
+		name:    "generate-iam-policy",
+		args:    "[env]",
+		summary: "Print a least-privilege IAM policy for the tool, scoped to the configured bucket, prefix and locations.",
+		examples: []string{
+			"tfmanage generate-iam-policy",
+			"tfmanage generate-iam-policy --mode read-only",
+			"tfmanage generate-iam-policy prod --account 123456789012 > tfmanage-policy.json",
+		},
+		minArgs: 0,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			mode := fs.String("mode", modeReadWrite, "read-only for download, versions and plan, or read-write to also upload, apply and back up state")
+			account := fs.String("account", "", "the AWS account ID for SSM, Secrets Manager and KMS ARNs, asked from STS when not set")
+			return func(ctx context.Context, a *app, args []string) error {
+				if *mode != modeReadOnly && *mode != modeReadWrite {
+					return usageError("unknown --mode %q, use %s or %s", *mode, modeReadOnly, modeReadWrite)
+				}
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				environments := environmentNames(s)
+				if len(args) == 1 {
+					if err := a.checkEnvironment(args[0]); err != nil {
+						return err
+					}
+					environments = args
+				}
+
+				region := s.AWSConfig.Region
+				if region == "" {
+					region = "*"
+				}
+				scope := arnScope{partition: "aws", region: region, account: *account}
+				if scope.account == "" && needsAccount(s, environments) {
+					caller, err := callerIdentity(ctx, s)
+					if err != nil {
+						return fmt.Errorf("the account ID is needed for the ARNs, pass --account or set AWS credentials: %w", err)
+					}
+					scope = scopeFor(caller, region)
+				}
+
+				doc, err := iamPolicy(s, environments, scope, *mode == modeReadWrite)
+				if err != nil {
+					return err
+				}
+				if len(doc.Statement) == 0 {
+					return configError("nothing to grant, set S3_BUCKET or give an environment an AWS location")
+				}
+				if a.out.json {
+					a.out.Event("iam-policy", map[string]any{"mode": *mode, "policy": doc})
+					return nil
+				}
+				data, err := json.MarshalIndent(doc, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(a.out.stdout, string(data))
+				return nil
+			}
+		},
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/iampolicy"
+)
+
+// generatePolicy runs generate-iam-policy and parses what it prints
+
+func generatePolicy(t *testing.T, args ...string) (iampolicy.Document, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	err := runWithUI(append([]string{"generate-iam-policy"}, args...), &ui{stdout: &stdout, stderr: io.Discard})
+	var doc iampolicy.Document
+	if err == nil {
+		if jsonErr := json.Unmarshal(stdout.Bytes(), &doc); jsonErr != nil {
+			t.Fatalf("the policy isn't valid JSON: %v\n%s", jsonErr, stdout.String())
+		}
+	}
+	return doc, err
+}
+
+func statement(doc iampolicy.Document, sid string) *iampolicy.Statement {
+	for _, s := range doc.Statement {
+		if s.Sid == sid {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestGenerateIAMPolicy(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	withCaller(t, deployerARN)
+	t.Setenv("AWS_REGION", "eu-west-1")
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dev: {}\n  small:\n    tfvars: small.tfvars\n    location: ssm:///tfvars/small\n    kms_key: alias/tfvars\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	doc, err := generatePolicy(t, "--mode", "read-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "2012-10-17" {
+		t.Errorf("version = %q", doc.Version)
+	}
+	if read := statement(doc, "ReadTfvars"); read == nil || !slices.Equal(read.Resource, []string{"arn:aws:s3:::tfvars-bucket/team/*"}) {
+		t.Errorf("ReadTfvars = %+v, want the objects under the prefix", read)
+	}
+	if params := statement(doc, "ReadParameters"); params == nil || params.Resource[0] != "arn:aws:ssm:eu-west-1:123456789012:parameter/tfvars/small" {
+		t.Errorf("ReadParameters = %+v, want the parameter ARN with the caller's account", params)
+	}
+	if alias := statement(doc, "DecryptTfvarsByAlias"); alias == nil || alias.Condition["ForAnyValue:StringEquals"]["kms:ResourceAliases"][0] != "alias/tfvars" {
+		t.Errorf("DecryptTfvarsByAlias = %+v, want the alias matched by condition", alias)
+	}
+	for _, sid := range []string{"WriteTfvars", "WriteParameters", "EncryptTfvars"} {
+		if statement(doc, sid) != nil {
+			t.Errorf("read-only policy has %s", sid)
+		}
+	}
+
+	doc, err = generatePolicy(t, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if write := statement(doc, "WriteTfvars"); write == nil || !slices.Contains(write.Action, "s3:PutObject") {
+		t.Errorf("WriteTfvars = %+v, want PutObject in read-write mode", write)
+	}
+	if statement(doc, "ReadParameters") != nil {
+		t.Error("the policy for dev has the small environment's parameter in it")
+	}
+
+	if _, err := generatePolicy(t, "--mode", "admin"); exitCodeFor(err) != exitUsage {
+		t.Errorf("--mode admin: %v, want a usage error", err)
+	}
+}
+
+func TestGenerateIAMPolicyReplica(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	withCaller(t, deployerARN)
+	os.WriteFile("tfmanage.yaml", []byte("replica_bucket: tfvars-dr\n"), 0o644)
+
+	doc, err := generatePolicy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read := statement(doc, "ReadTfvarsReplica"); read == nil || !slices.Equal(read.Resource, []string{"arn:aws:s3:::tfvars-dr/team/*"}) {
+		t.Errorf("ReadTfvarsReplica = %+v, want the replica's objects under the prefix", read)
+	}
+	if write := statement(doc, "WriteTfvars"); write == nil || slices.Contains(write.Resource, "arn:aws:s3:::tfvars-dr/team/*") {
+		t.Errorf("WriteTfvars = %+v, want the replica left out", write)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+func graphCommand() *command {
+	return &command{
+		name:    "graph",
+		args:    "<env>",
+		summary: "Write the terraform resource graph for an environment, as an image when graphviz is installed.",
+		examples: []string{
+			"tfmanage graph prod --out prod.svg",
+			"tfmanage graph dev --type plan --out dev.dot",
+			"tfmanage graph prod --plan prod.tfplan --out prod.png",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			graphType := fs.String("type", "apply", "plan graphs the configuration, apply graphs a plan made with the environment's tfvars")
+			planFile := fs.String("plan", "", "graph this saved plan instead of planning first")
+			out := fs.String("out", "", "write the graph to this file, .svg and .png are rendered with graphviz (default stdout)")
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			return func(ctx context.Context, a *app, args []string) error {
+				if *graphType != "plan" && *graphType != "apply" {
+					return usageError("invalid --type %q, use plan or apply", *graphType)
+				}
+				if *planFile != "" && *graphType == "plan" {
+					return usageError("--plan always graphs the apply, it can't be used with --type plan")
+				}
+				opts := tfexec.GraphOptions{Chdir: a.useEnvironment(args[0], *chdir), Type: *graphType, PlanFile: *planFile}
+
+				// only an apply graph without a saved plan needs the tfvars, to make one
+				var fileName string
+				var err error
+				if *graphType == "apply" && *planFile == "" {
+					fileName, err = a.prepare("plan", args[0])
+				} else {
+					err = a.checkEnvironment(args[0])
+				}
+				if err != nil {
+					return err
+				}
+				if fileName != "" {
+					if err := a.prepareTerraform(ctx, args[0]); err != nil {
+						return err
+					}
+				}
+				return terraformGraph(ctx, a, opts, fileName, *out)
+			}
+		},
+	}
+}
+
+// terraformGraph gets the DOT graph, planning to a temp file first when an apply graph has no plan, and writes it out
+
+func terraformGraph(ctx context.Context, a *app, opts tfexec.GraphOptions, varFile, out string) error {
+	if varFile != "" {
+		planFile, cleanup, err := planForApply(ctx, a, tfexec.ApplyOptions{Chdir: opts.Chdir, VarFile: varFile, NoColor: !a.out.color})
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		opts.PlanFile = planFile
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.GraphArgs(opts))
+	dot, err := tfexec.Graph(ctx, runner, opts, a.terraformOutput())
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err := a.out.stdout.Write(dot)
+		return err
+	}
+	return a.writeGraph(ctx, dot, out)
+}
+
+// writeGraph renders images with graphviz, anything else and a missing graphviz get the DOT as it is
+
+func (a *app) writeGraph(ctx context.Context, dot []byte, out string) error {
+	format, image := tools.DotFormat(out)
+	if !image {
+		return a.writeDOT(dot, out)
+	}
+
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", "tfmanage-*.dot")
+	if err != nil {
+		return fmt.Errorf("failed to create a temp file for the graph: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(dot)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the graph: %w", err)
+	}
+
+	err = tools.Dot(ctx, toolRunner, s.Hooks.Dot, format, f.Name(), out)
+	if errors.Is(err, tools.ErrNotInstalled) {
+		dotFile := strings.TrimSuffix(out, filepath.Ext(out)) + ".dot"
+		a.out.Warnf("graphviz is not installed so %s can't be rendered, writing the DOT graph to %s instead", out, dotFile)
+		return a.writeDOT(dot, dotFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to render the graph: %w", err)
+	}
+	a.out.Event("graph", map[string]any{"file": out, "format": format})
+	a.out.Successf("Wrote the graph to %s", out)
+	return nil
+}
+
+func (a *app) writeDOT(dot []byte, file string) error {
+	if err := os.WriteFile(file, dot, 0o644); err != nil {
+		return fmt.Errorf("failed to write the graph: %w", err)
+	}
+	a.out.Event("graph", map[string]any{"file": file, "format": "dot"})
+	a.out.Successf("Wrote the graph to %s", file)
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+const graphSample = "digraph {\n\t\"aws_s3_bucket.logs\"\n}\n"
+
+func withGraphRunners(t *testing.T, dotErr error) (*tfexec.RecordingRunner, *tools.RecordingRunner) {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{OutputFor: func(args []string) string {
+		if strings.Contains(strings.Join(args, " "), "graph") {
+			return graphSample
+		}
+		return ""
+	}}
+	dot := &tools.RecordingRunner{Result: func(c tools.Command) ([]byte, error) {
+		if dotErr != nil {
+			return nil, dotErr
+		}
+		// dot -T<format> -o <out> <in>
+		return nil, os.WriteFile(c.Args[2], []byte("<svg/>"), 0o644)
+	}}
+	useRunner(t, rec)
+	useTools(t, dot)
+	withTFVars(t, "dev")
+	return rec, dot
+}
+
+func TestGraphPlansWithTheTFVars(t *testing.T) {
+	rec, dot := withGraphRunners(t, nil)
+
+	if err := run([]string{"graph", "dev", "--out", "dev.svg", "--chdir", "infra"}); err != nil {
+		t.Fatalf("graph: %v", err)
+	}
+	calls := rec.Args()
+	if len(calls) != 2 || calls[0][1] != "plan" || calls[1][1] != "graph" || !strings.HasPrefix(calls[1][2], "-plan=") {
+		t.Fatalf("terraform calls = %q, want a plan and a graph of it", calls)
+	}
+	if calls[0][0] != "-chdir=infra" || calls[1][0] != "-chdir=infra" {
+		t.Errorf("--chdir was not passed: %q", calls)
+	}
+	if len(dot.Calls) != 1 || dot.Calls[0].Args[0] != "-Tsvg" {
+		t.Errorf("dot calls = %+v", dot.Calls)
+	}
+	if data, _ := os.ReadFile("dev.svg"); string(data) != "<svg/>" {
+		t.Errorf("dev.svg = %q", data)
+	}
+}
+
+func TestGraphWithoutGraphviz(t *testing.T) {
+	rec, _ := withGraphRunners(t, fmt.Errorf("%w: dot was not found", tools.ErrNotInstalled))
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"graph", "dev", "--type", "plan", "--out", "dev.png"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if err != nil {
+		t.Fatalf("graph: %v", err)
+	}
+	if calls := rec.Args(); len(calls) != 1 || strings.Join(calls[0], " ") != "graph -type=plan" {
+		t.Errorf("terraform calls = %q", calls)
+	}
+	if data, _ := os.ReadFile("dev.dot"); string(data) != graphSample {
+		t.Errorf("dev.dot = %q", data)
+	}
+	if !strings.Contains(stdout.String(), "graphviz is not installed") {
+		t.Errorf("output = %q, want it to say graphviz is missing", stdout.String())
+	}
+}
+
+func TestGraphToStdout(t *testing.T) {
+	withGraphRunners(t, nil)
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"graph", "dev", "--plan", "dev.tfplan"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("graph: %v", err)
+	}
+	if stdout.String() != graphSample {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+	for _, args := range [][]string{{"graph", "dev", "--type", "refresh"}, {"graph", "dev", "--type", "plan", "--plan", "p"}} {
+		if err := run(args); exitCodeFor(err) != exitUsage {
+			t.Errorf("%q gave %v, want a usage error", args, err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/ignore"
+)
+
+// ignore rules - every command that walks a directory leaves out what the built-in defaults and the .tfmanageignore at the top of that directory say, --no-ignore turns both off
+
+// skipFunc says whether a path under the walked directory is left out, rel is slash separated
+
+type skipFunc func(rel string, dir bool) bool
+
+// ignoreRules loads the rules for dir. Every path they leave out is logged with --verbose along with the pattern that did it
+
+func (a *app) ignoreRules(dir string, noIgnore bool) (skipFunc, error) {
+	if noIgnore {
+		a.out.Verbosef("Not reading %s in %s, --no-ignore is set\n", ignore.FileName, dir)
+		return func(string, bool) bool { return false }, nil
+	}
+	m, err := ignore.Load(dir)
+	if err != nil {
+		return nil, configError("%v", err)
+	}
+	return func(rel string, isDir bool) bool {
+		p, ignored := m.Match(rel, isDir)
+		if ignored {
+			a.out.Verbosef("Skipping %s, ignored by %s\n", path.Join(dir, rel), p)
+		}
+		return ignored
+	}, nil
+}
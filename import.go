@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func importCommand() *command {
+	return &command{
+		name:    "import",
+		args:    "<env> <address> <id>",
+		summary: "Import an existing resource into the state with the environment's tfvars.",
+		examples: []string{
+			"tfmanage import dev aws_s3_bucket.logs my-logs-bucket",
+			"tfmanage import prod 'aws_iam_role.ci[\"deploy\"]' deploy --plan-after",
+			"tfmanage import prod aws_s3_bucket.logs my-logs-bucket --dry-run",
+		},
+		minArgs: 3,
+		maxArgs: 3,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			dryRun := fs.Bool("dry-run", false, "print the terraform command instead of running it")
+			planAfter := fs.Bool("plan-after", false, "run terraform plan once the import is done")
+			yes := fs.Bool("yes", false, "don't ask for the environment name on protected environments")
+			return func(ctx context.Context, a *app, args []string) error {
+				fileName, err := a.prepare("import", args[0])
+				if err != nil {
+					return err
+				}
+				if err := a.lockEnvironment(args[0], "import"); err != nil {
+					return err
+				}
+				if strings.TrimSpace(args[1]) == "" || strings.TrimSpace(args[2]) == "" {
+					return usageError("import needs a resource address and an id")
+				}
+				// the dry run prints the var file the way terraform gets it, after -chdir
+				varFile, err := filepath.Abs(fileName)
+				if err != nil {
+					return err
+				}
+				opts := tfexec.ImportOptions{
+					Chdir:   a.useEnvironment(args[0], *chdir),
+					VarFile: varFile,
+					Address: args[1],
+					ID:      args[2],
+					NoColor: !a.out.color,
+				}
+				if *dryRun {
+					a.out.Printf("%s\n", a.commandLine(tfexec.ImportArgs(opts)))
+					return nil
+				}
+				if err := a.confirm(args[0], "import", *yes); err != nil {
+					return err
+				}
+				return terraformImport(ctx, a, args[0], opts, *planAfter)
+			}
+		},
+	}
+}
+
+// terraformImport runs the import and then either the plan or a reminder to run one
+
+func terraformImport(ctx context.Context, a *app, environment string, opts tfexec.ImportOptions, planAfter bool) error {
+	if err := a.prepareTerraform(ctx, environment); err != nil {
+		return err
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.ImportArgs(opts))
+	if err := tfexec.Import(ctx, runner, opts, a.terraformOutput()); err != nil {
+		return err
+	}
+	a.out.Event("import", map[string]any{"environment": environment, "address": opts.Address, "id": opts.ID})
+	a.out.Successf("Imported %s into the %s state", opts.Address, environment)
+
+	if !planAfter {
+		a.out.Printf("Run 'tfmanage plan %s <plan-file>' to check the configuration matches what was imported\n", environment)
+		return nil
+	}
+	plan := tfexec.PlanOptions{Chdir: opts.Chdir, VarFile: opts.VarFile, NoColor: opts.NoColor}
+	a.out.Verbosef("Running terraform %v\n", tfexec.PlanArgs(plan))
+	return tfexec.Plan(ctx, runner, plan, a.terraformOutput())
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_./=:@%+,-]+$`)
+
+// commandLine is a terraform command the way it would be typed in a shell, with the workspace in front when there is one
+
+func (a *app) commandLine(args []string) string {
+	words := []string{"terraform"}
+	if a.workspace != "" {
+		words = append([]string{"TF_WORKSPACE=" + shellQuote(a.workspace)}, words...)
+	}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
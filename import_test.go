@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func withImportRunner(t *testing.T) *tfexec.RecordingRunner {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	withTFVars(t, "prod")
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    workspace: production\n"), 0o644)
+	return rec
+}
+
+func TestImport(t *testing.T) {
+	rec := withImportRunner(t)
+	varFile, _ := filepath.Abs("prod.tfvars")
+
+	out := &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader("prod\n")}
+	if err := runWithUI([]string{"import", "prod", "aws_s3_bucket.logs", "my-logs", "--plan-after"}, out); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	calls := rec.Args()
+	if want := []string{"import", "-var-file", varFile, "-no-color", "aws_s3_bucket.logs", "my-logs"}; len(calls) != 2 || !slices.Equal(calls[0], want) {
+		t.Fatalf("terraform calls = %q, want %q and a plan", calls, want)
+	}
+	if calls[1][0] != "plan" || !slices.Contains(rec.Calls[0].Opts.Env, "TF_WORKSPACE=production") {
+		t.Errorf("plan after = %q, env = %q", calls[1], rec.Calls[0].Opts.Env)
+	}
+
+	// a wrong answer and no terminal both stop it before terraform runs
+	rec.Calls = nil
+	out.stdin = strings.NewReader("dev\n")
+	if err := runWithUI([]string{"import", "prod", "aws_s3_bucket.logs", "my-logs"}, out); !errors.Is(err, errNotConfirmed) {
+		t.Errorf("wrong confirmation: %v", err)
+	}
+	out.stdin = nil
+	if err := runWithUI([]string{"import", "prod", "aws_s3_bucket.logs", "my-logs"}, out); exitCodeFor(err) != exitUsage {
+		t.Errorf("no terminal: %v, want a usage error", err)
+	}
+	if len(rec.Calls) != 0 {
+		t.Errorf("terraform ran without confirmation: %q", rec.Args())
+	}
+	if err := runWithUI([]string{"import", "prod", "aws_s3_bucket.logs", "my-logs", "--yes"}, out); err != nil || len(rec.Calls) != 1 {
+		t.Errorf("--yes: %v, %d calls", err, len(rec.Calls))
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	rec := withImportRunner(t)
+	varFile, _ := filepath.Abs("prod.tfvars")
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"import", "prod", `aws_iam_role.ci["deploy"]`, "deploy", "--dry-run"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("import --dry-run: %v", err)
+	}
+	want := "TF_WORKSPACE=production terraform import -var-file " + varFile + ` -no-color 'aws_iam_role.ci["deploy"]' deploy` + "\n"
+	if stdout.String() != want {
+		t.Errorf("dry run printed %q, want %q", stdout.String(), want)
+	}
+	if len(rec.Calls) != 0 {
+		t.Errorf("dry run ran terraform: %q", rec.Args())
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for in, want := range map[string]string{"plain": "plain", "a b": "'a b'", "it's": `'it'\''s'`} {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
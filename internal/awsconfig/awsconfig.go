@@ -0,0 +1,244 @@
+// Package awsconfig builds the AWS config the tool uses from the environment.
+// No credentials are ever stored by the tool, they are only read from the env
+// or from the shared profile files.
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/buildinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tracing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+)
+
+var (
+	// ErrCredentialsNotSet is returned when neither a profile nor a key pair is available.
+	ErrCredentialsNotSet = errors.New("AWS_PROFILE environment variable or AWS access key and secret key are not set")
+	// ErrRegionNotSet is returned when AWS_REGION is empty.
+	ErrRegionNotSet = errors.New("AWS_REGION environment variable is not set")
+	// ErrLoadFailed is returned when the SDK could not load the config, usually a broken profile.
+	ErrLoadFailed = errors.New("failed to load AWS config")
+	// ErrIdentityFailed is returned when STS could not say who the credentials belong to.
+	ErrIdentityFailed = errors.New("failed to get the caller identity")
+	// ErrAssumeRoleFailed is returned when a role of a chain could not be assumed.
+	ErrAssumeRoleFailed = errors.New("failed to assume role")
+)
+
+const (
+	// MinSessionDuration and MaxSessionDuration are the shortest and longest
+	// sessions STS gives for an assumed role.
+	MinSessionDuration = 15 * time.Minute
+	MaxSessionDuration = 12 * time.Hour
+)
+
+// Env holds the AWS related environment variables.
+type Env struct {
+	Profile         string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// CredentialsCommand prints the credentials in the credential_process
+	// format, it wins over the profile and the keys.
+	CredentialsCommand string
+	// ProxyURL is the proxy every request goes through for one run, it wins
+	// over HTTPS_PROXY, HTTP_PROXY and NO_PROXY. Empty uses those.
+	ProxyURL string
+	// CABundle is a PEM file of the CAs to trust on top of the system's, for
+	// endpoints such as MinIO signed by an internal CA.
+	CABundle string
+	// InsecureSkipVerify turns off the TLS certificate check altogether.
+	InsecureSkipVerify bool
+	// RoleARN is a role assumed with the credentials above, every request
+	// is made as the role when it is set.
+	RoleARN string
+	// Timeouts bound every request, DefaultTimeouts when zero.
+	Timeouts Timeouts
+}
+
+// CredentialVars are the environment variables the AWS SDKs, terraform's
+// AWS provider included, pick credentials or the identity to assume from.
+var CredentialVars = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_SECURITY_TOKEN",
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_CONFIG_FILE",
+	"AWS_ROLE_ARN",
+	"AWS_ROLE_SESSION_NAME",
+	"AWS_WEB_IDENTITY_TOKEN_FILE",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+}
+
+// FromEnv reads the AWS variables from the process environment.
+func FromEnv() Env {
+	return Env{
+		Profile:         os.Getenv("AWS_PROFILE"),
+		Region:          os.Getenv("AWS_REGION"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		CABundle:        os.Getenv("AWS_CA_BUNDLE"),
+	}
+}
+
+// Validate checks that there is enough in the env to build a config. It only
+// needs one of the credentials command, the profile or the key pair, but it
+// always needs the region.
+func (e Env) Validate() error {
+	if e.CredentialsCommand == "" && e.Profile == "" && (e.AccessKeyID == "" || e.SecretAccessKey == "") {
+		return ErrCredentialsNotSet
+	}
+	if e.Region == "" {
+		return ErrRegionNotSet
+	}
+	return nil
+}
+
+// Load validates the env and loads the config. The credentials command wins
+// over everything else, then the profile wins over static keys, and RoleARN
+// is assumed with whichever it was. Every request carries tfmanage/<version>
+// in its user agent so bucket access logs show which build made it, and is a
+// span when the context is traced. The requests go through env's proxy, or the
+// one the proxy variables give, and trust env's CA bundle.
+func Load(ctx context.Context, env Env) (aws.Config, error) {
+	if err := env.Validate(); err != nil {
+		return aws.Config{}, err
+	}
+	client, err := httpClient(env)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(env.Region),
+		config.WithHTTPClient(client),
+		config.WithAPIOptions([]func(*middleware.Stack) error{
+			awsmiddleware.AddUserAgentKeyValue(buildinfo.Get().UserAgent()),
+			tracing.AWSMiddleware,
+		}),
+	}
+
+	switch {
+	case env.CredentialsCommand != "":
+		opts = append(opts, config.WithCredentialsProvider(commandCredentials(env.CredentialsCommand)))
+	case env.Profile != "":
+		opts = append(opts, config.WithSharedConfigProfile(env.Profile))
+	default:
+		opts = append(opts, config.WithCredentialsProvider(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     env.AccessKeyID,
+				SecretAccessKey: env.SecretAccessKey,
+				SessionToken:    env.SessionToken,
+			}, nil
+		})))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("%w: %w", ErrLoadFailed, err)
+	}
+	if env.RoleARN != "" {
+		base := cfg.Copy()
+		cfg.Credentials = aws.NewCredentialsCache(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return AssumeRoleChain(ctx, base, []string{env.RoleARN}, "tfmanage", 0)
+		}))
+	}
+
+	return cfg, nil
+}
+
+// httpClient is the SDK's client with its proxy, TLS config and timeouts set
+// explicitly, rather than left to whatever default transport the SDK ends up
+// with.
+func httpClient(env Env) (*awshttp.BuildableClient, error) {
+	proxy, err := ProxyFunc(env.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := tlsConfig(env)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := env.Timeouts.WithDefaults()
+	return awshttp.NewBuildableClient().
+		WithTimeout(timeouts.Request).
+		WithDialerOptions(timeouts.dialer).
+		WithTransportOptions(timeouts.transport, func(t *http.Transport) {
+			t.Proxy = proxy
+			if tlsConfig != nil {
+				t.TLSClientConfig = tlsConfig
+			}
+		}), nil
+}
+
+// CallerARN asks STS who the credentials in cfg belong to and returns the ARN.
+func CallerARN(ctx context.Context, cfg aws.Config) (string, error) {
+	out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrIdentityFailed, err)
+	}
+	return aws.ToString(out.Arn), nil
+}
+
+// AssumeRoleChain assumes roles in order, each one with the credentials of
+// the one before it and the first with cfg's. duration is the session of the
+// last role, 0 leaves it to STS, the roles before it get the shortest session
+// since they are only used to assume the next one. The error of a role that
+// can't be assumed says which step of the chain it was.
+func AssumeRoleChain(ctx context.Context, cfg aws.Config, roles []string, sessionName string, duration time.Duration) (aws.Credentials, error) {
+	var creds aws.Credentials
+	for i, role := range roles {
+		in := &sts.AssumeRoleInput{
+			RoleArn:         aws.String(role),
+			RoleSessionName: aws.String(sessionName),
+			DurationSeconds: aws.Int32(int32(MinSessionDuration.Seconds())),
+		}
+		if i == len(roles)-1 {
+			in.DurationSeconds = nil
+			if duration > 0 {
+				in.DurationSeconds = aws.Int32(int32(duration.Seconds()))
+			}
+		}
+		out, err := sts.NewFromConfig(cfg).AssumeRole(ctx, in)
+		if err != nil {
+			return aws.Credentials{}, fmt.Errorf("%w %s (step %d of %d): %w", ErrAssumeRoleFailed, role, i+1, len(roles), err)
+		}
+		creds = aws.Credentials{
+			AccessKeyID:     aws.ToString(out.Credentials.AccessKeyId),
+			SecretAccessKey: aws.ToString(out.Credentials.SecretAccessKey),
+			SessionToken:    aws.ToString(out.Credentials.SessionToken),
+			Source:          "AssumeRole",
+		}
+		if out.Credentials.Expiration != nil {
+			creds.CanExpire, creds.Expires = true, *out.Credentials.Expiration
+		}
+		cfg = WithCredentials(cfg, creds)
+	}
+	return creds, nil
+}
+
+// WithCredentials is a copy of cfg that uses creds.
+func WithCredentials(cfg aws.Config, creds aws.Credentials) aws.Config {
+	cfg = cfg.Copy()
+	cfg.Credentials = aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return creds, nil
+	})
+	return cfg
+}
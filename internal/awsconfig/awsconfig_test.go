@@ -0,0 +1,139 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		env  Env
+		want error
+	}{
+		{"profile", Env{Profile: "dev", Region: "us-east-1"}, nil},
+		{"keys", Env{AccessKeyID: "a", SecretAccessKey: "b", Region: "us-east-1"}, nil},
+		{"credentials command", Env{CredentialsCommand: "corp-creds issue", Region: "us-east-1"}, nil},
+		{"nothing", Env{Region: "us-east-1"}, ErrCredentialsNotSet},
+		{"half a key pair", Env{AccessKeyID: "a", Region: "us-east-1"}, ErrCredentialsNotSet},
+		{"no region", Env{Profile: "dev"}, ErrRegionNotSet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.env.Validate(); !errors.Is(err, tt.want) {
+				t.Fatalf("Validate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadStaticKeys(t *testing.T) {
+	cfg, err := Load(context.Background(), Env{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token", Region: "eu-west-1"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Region != "eu-west-1" {
+		t.Errorf("region = %q, want eu-west-1", cfg.Region)
+	}
+	if len(cfg.APIOptions) == 0 {
+		t.Error("the user agent middleware was not added")
+	}
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKID" || creds.SecretAccessKey != "secret" || creds.SessionToken != "token" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
+
+// fakeSTS answers AssumeRole with keys named after the role, and denies the roles in denied
+func fakeSTS(t *testing.T, denied string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		role := r.Form.Get("RoleArn")
+		signer := strings.Split(strings.SplitN(r.Header.Get("Authorization"), "Credential=", 2)[1], "/")[0]
+		calls = append(calls, fmt.Sprintf("%s as %s for %s", role, signer, r.Form.Get("DurationSeconds")))
+		if role == denied {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>not allowed</Message></Error></ErrorResponse>`)
+			return
+		}
+		name := role[strings.LastIndex(role, "/")+1:]
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials><AccessKeyId>%s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>2030-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`, name)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestAssumeRoleChain(t *testing.T) {
+	srv, calls := fakeSTS(t, "")
+	cfg, err := Load(context.Background(), Env{AccessKeyID: "BASE", SecretAccessKey: "secret", Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BaseEndpoint = aws.String(srv.URL)
+	roles := []string{"arn:aws:iam::111111111111:role/hop", "arn:aws:iam::222222222222:role/deploy"}
+	creds, err := AssumeRoleChain(context.Background(), cfg, roles, "tfmanage-prod", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("AssumeRoleChain() error = %v", err)
+	}
+	if creds.AccessKeyID != "deploy" || !creds.CanExpire || creds.Expires.Year() != 2030 {
+		t.Errorf("credentials = %+v, want the last role's", creds)
+	}
+	want := []string{
+		"arn:aws:iam::111111111111:role/hop as BASE for 900",
+		"arn:aws:iam::222222222222:role/deploy as hop for 7200",
+	}
+	if fmt.Sprint(*calls) != fmt.Sprint(want) {
+		t.Errorf("calls = %q, want %q", *calls, want)
+	}
+}
+
+func TestAssumeRoleChainNamesTheFailedStep(t *testing.T) {
+	srv, calls := fakeSTS(t, "arn:aws:iam::222222222222:role/deploy")
+	cfg, err := Load(context.Background(), Env{AccessKeyID: "BASE", SecretAccessKey: "secret", Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BaseEndpoint = aws.String(srv.URL)
+	roles := []string{"arn:aws:iam::111111111111:role/hop", "arn:aws:iam::222222222222:role/deploy", "arn:aws:iam::333333333333:role/never"}
+	_, err = AssumeRoleChain(context.Background(), cfg, roles, "tfmanage-prod", 0)
+	if !errors.Is(err, ErrAssumeRoleFailed) {
+		t.Fatalf("err = %v, want ErrAssumeRoleFailed", err)
+	}
+	if !strings.Contains(err.Error(), "role/deploy (step 2 of 3)") {
+		t.Errorf("err = %v, want it to name the second role", err)
+	}
+	if len(*calls) != 2 {
+		t.Errorf("calls = %q, the chain should stop at the failed role", *calls)
+	}
+}
+
+func TestLoadAssumesRoleARN(t *testing.T) {
+	srv, calls := fakeSTS(t, "")
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	cfg, err := Load(context.Background(), Env{AccessKeyID: "BASE", SecretAccessKey: "secret", Region: "us-west-2", RoleARN: "arn:aws:iam::111111111111:role/failover"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		creds, err := cfg.Credentials.Retrieve(context.Background())
+		if err != nil || creds.AccessKeyID != "failover" {
+			t.Fatalf("Retrieve() = %+v, %v, want the role's credentials", creds, err)
+		}
+	}
+	if want := []string{"arn:aws:iam::111111111111:role/failover as BASE for "}; fmt.Sprint(*calls) != fmt.Sprint(want) {
+		t.Errorf("calls = %q, want the role assumed once", *calls)
+	}
+}
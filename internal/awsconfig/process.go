@@ -0,0 +1,86 @@
+package awsconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ErrCredentialsCommandFailed is returned when the credentials command
+// failed or printed something that isn't credentials.
+var ErrCredentialsCommandFailed = errors.New("credentials command failed")
+
+// processOutput is what a credential_process prints, see
+// https://docs.aws.amazon.com/sdkref/latest/guide/feature-process-credentials.html
+type processOutput struct {
+	Version         int
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      *time.Time
+}
+
+// CommandProvider gets credentials by running a command that prints them in
+// the credential_process format, the same way the AWS CLI runs a profile's
+// credential_process.
+type CommandProvider struct {
+	Command string
+}
+
+// Retrieve runs the command. Its stderr is part of the error when it fails,
+// its stdout never is since it can have credentials in it.
+func (p CommandProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/C", p.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", p.Command)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return aws.Credentials{}, fmt.Errorf("%w: %s: %v: %s", ErrCredentialsCommandFailed, p.Command, err, msg)
+		}
+		return aws.Credentials{}, fmt.Errorf("%w: %s: %v", ErrCredentialsCommandFailed, p.Command, err)
+	}
+
+	var out processOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return aws.Credentials{}, fmt.Errorf("%w: %s didn't print credential_process JSON", ErrCredentialsCommandFailed, p.Command)
+	}
+	switch {
+	case out.Version != 1:
+		return aws.Credentials{}, fmt.Errorf("%w: %s printed Version %d, only 1 is supported", ErrCredentialsCommandFailed, p.Command, out.Version)
+	case out.AccessKeyID == "" || out.SecretAccessKey == "":
+		return aws.Credentials{}, fmt.Errorf("%w: %s printed no AccessKeyId or SecretAccessKey", ErrCredentialsCommandFailed, p.Command)
+	}
+	creds := aws.Credentials{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.SessionToken,
+		Source:          "CredentialsCommand",
+	}
+	if out.Expiration != nil {
+		creds.CanExpire, creds.Expires = true, *out.Expiration
+	}
+	return creds, nil
+}
+
+// commandCaches keeps one cache per command, so every config loaded in a run
+// shares the credentials and the command only runs again once they expire.
+var commandCaches sync.Map
+
+func commandCredentials(command string) aws.CredentialsProvider {
+	cache, _ := commandCaches.LoadOrStore(command, aws.NewCredentialsCache(CommandProvider{Command: command}))
+	return cache.(*aws.CredentialsCache)
+}
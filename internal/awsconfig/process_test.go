@@ -0,0 +1,80 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are sh")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "creds.json")
+	os.WriteFile(script, []byte(`{"Version": 1, "AccessKeyId": "AKID", "SecretAccessKey": "secret", "SessionToken": "token", "Expiration": "2030-01-01T00:00:00Z"}`), 0o644)
+
+	creds, err := CommandProvider{Command: "cat " + script}.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKID" || creds.SecretAccessKey != "secret" || creds.SessionToken != "token" || !creds.CanExpire || creds.Expires.Year() != 2030 {
+		t.Errorf("credentials = %+v", creds)
+	}
+
+	for _, tc := range []struct {
+		command, want string
+	}{
+		{"echo 'account X is not yours' >&2; exit 3", "account X is not yours"},
+		{"echo not json", "didn't print credential_process JSON"},
+		{`echo '{"Version": 2, "AccessKeyId": "a", "SecretAccessKey": "b"}'`, "only 1 is supported"},
+		{`echo '{"Version": 1, "AccessKeyId": "a"}'`, "no AccessKeyId or SecretAccessKey"},
+	} {
+		_, err := CommandProvider{Command: tc.command}.Retrieve(context.Background())
+		if !errors.Is(err, ErrCredentialsCommandFailed) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Retrieve(%q) error = %v, want it to say %q", tc.command, err, tc.want)
+		}
+	}
+}
+
+func TestCredentialsCommandIsCachedUntilItExpires(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are sh")
+	}
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	command := `echo run >> ` + runs + `; echo '{"Version": 1, "AccessKeyId": "AKID", "SecretAccessKey": "secret", "Expiration": "` + expires + `"}'`
+
+	for range 2 {
+		cfg, err := Load(context.Background(), Env{CredentialsCommand: command, Profile: "ignored", Region: "us-east-1"})
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		creds, err := cfg.Credentials.Retrieve(context.Background())
+		if err != nil || creds.AccessKeyID != "AKID" {
+			t.Fatalf("Retrieve() = %+v, %v", creds, err)
+		}
+	}
+	if data, _ := os.ReadFile(runs); strings.Count(string(data), "run") != 1 {
+		t.Errorf("the command ran %d times, want once while its credentials are valid", strings.Count(string(data), "run"))
+	}
+
+	// credentials that have expired run the command again
+	expired := `echo run >> ` + runs + `; echo '{"Version": 1, "AccessKeyId": "AKID", "SecretAccessKey": "secret", "Expiration": "` + time.Now().Add(-time.Second).UTC().Format(time.RFC3339) + `"}'`
+	provider := commandCredentials(expired)
+	os.Remove(runs)
+	for range 2 {
+		if _, err := provider.Retrieve(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, _ := os.ReadFile(runs); strings.Count(string(data), "run") != 2 {
+		t.Errorf("the command ran %d times, want it to run again once the credentials expire", strings.Count(string(data), "run"))
+	}
+}
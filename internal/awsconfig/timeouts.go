@@ -0,0 +1,59 @@
+package awsconfig
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeouts bound the AWS requests so a connection that died on the way, on a
+// flaky VPN say, fails and is retried instead of hanging. A zero field is
+// its DefaultTimeouts value.
+type Timeouts struct {
+	// Request is the whole request, reading the response body included.
+	Request time.Duration
+	// Connect is opening the TCP connection.
+	Connect time.Duration
+	// TLSHandshake is the TLS handshake once connected.
+	TLSHandshake time.Duration
+}
+
+// DefaultTimeouts are tighter than Go's, which has none for a request.
+var DefaultTimeouts = Timeouts{Request: 30 * time.Second, Connect: 5 * time.Second, TLSHandshake: 10 * time.Second}
+
+// MaxIdleConnsPerHost is how many idle connections to an endpoint are kept,
+// enough for the multipart transfers and the batch commands to reuse theirs
+// instead of handshaking again. The SDK keeps 10.
+const MaxIdleConnsPerHost = 64
+
+// WithDefaults fills in the zero fields.
+func (t Timeouts) WithDefaults() Timeouts {
+	if t.Request == 0 {
+		t.Request = DefaultTimeouts.Request
+	}
+	if t.Connect == 0 {
+		t.Connect = DefaultTimeouts.Connect
+	}
+	if t.TLSHandshake == 0 {
+		t.TLSHandshake = DefaultTimeouts.TLSHandshake
+	}
+	return t
+}
+
+// String is the timeouts for the verbose log.
+func (t Timeouts) String() string {
+	return fmt.Sprintf("request %s, connect %s, TLS handshake %s", t.Request, t.Connect, t.TLSHandshake)
+}
+
+func (t Timeouts) transport(tr *http.Transport) {
+	tr.TLSHandshakeTimeout = t.TLSHandshake
+	tr.MaxIdleConnsPerHost = MaxIdleConnsPerHost
+	if tr.MaxIdleConns < MaxIdleConnsPerHost {
+		tr.MaxIdleConns = MaxIdleConnsPerHost
+	}
+}
+
+func (t Timeouts) dialer(d *net.Dialer) {
+	d.Timeout = t.Connect
+}
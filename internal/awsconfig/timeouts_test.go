@@ -0,0 +1,85 @@
+package awsconfig
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// headBucket asks endpoint about a bucket once, without retries
+func headBucket(t *testing.T, endpoint string, timeouts Timeouts) (time.Duration, error) {
+	t.Helper()
+	cfg, err := Load(context.Background(), Env{AccessKeyID: "a", SecretAccessKey: "b", Region: "us-east-1", Timeouts: timeouts, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RetryMaxAttempts = 1
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+	})
+	start := time.Now()
+	_, err = client.HeadBucket(context.Background(), &s3.HeadBucketInput{Bucket: aws.String("tfvars")})
+	return time.Since(start), err
+}
+
+func TestRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	took, err := headBucket(t, srv.URL, Timeouts{Request: 100 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "Client.Timeout") || took > 5*time.Second {
+		t.Errorf("HeadBucket of a server that never answers = %v after %s, want the request timeout", err, took)
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	// accepts the connection and never says anything back
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	took, err := headBucket(t, "https://"+ln.Addr().String(), Timeouts{TLSHandshake: 100 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "TLS handshake timeout") || took > 5*time.Second {
+		t.Errorf("HeadBucket of a server that never handshakes = %v after %s, want the handshake timeout", err, took)
+	}
+}
+
+func TestHTTPClientSettings(t *testing.T) {
+	client, err := httpClient(Env{Timeouts: Timeouts{Connect: 2 * time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.GetTimeout(); got != DefaultTimeouts.Request {
+		t.Errorf("request timeout = %s, want the default %s", got, DefaultTimeouts.Request)
+	}
+	if got := client.GetDialer().Timeout; got != 2*time.Second {
+		t.Errorf("connect timeout = %s, want 2s", got)
+	}
+	tr := client.GetTransport()
+	if tr.TLSHandshakeTimeout != DefaultTimeouts.TLSHandshake || tr.MaxIdleConnsPerHost != MaxIdleConnsPerHost {
+		t.Errorf("transport has a handshake timeout of %s and keeps %d idle connections per host", tr.TLSHandshakeTimeout, tr.MaxIdleConnsPerHost)
+	}
+}
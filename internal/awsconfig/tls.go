@@ -0,0 +1,60 @@
+package awsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrCABundle is returned for a CA bundle that can't be read or has no
+// certificates in it.
+var ErrCABundle = errors.New("invalid CA bundle")
+
+// LoadCABundle reads the PEM certificates of a CA bundle into the system's
+// pool, so the endpoints with a public certificate keep working next to the
+// ones signed by the bundle's CAs. Every error names the file.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrCABundle, path, err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	certs := 0
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: certificate %d: %w", ErrCABundle, path, certs+1, err)
+		}
+		pool.AddCert(cert)
+		certs++
+	}
+	if certs == 0 {
+		return nil, fmt.Errorf("%w %s: no PEM certificates in it", ErrCABundle, path)
+	}
+	return pool, nil
+}
+
+// tlsConfig is the TLS config of env's requests, nil when the defaults do.
+func tlsConfig(env Env) (*tls.Config, error) {
+	if env.CABundle == "" && !env.InsecureSkipVerify {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: env.InsecureSkipVerify}
+	if env.CABundle != "" {
+		pool, err := LoadCABundle(env.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
@@ -0,0 +1,72 @@
+package awsconfig
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestLoadCABundle(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate\n"), 0o644)
+	broken := filepath.Join(dir, "broken.pem")
+	os.WriteFile(broken, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("junk")}), 0o644)
+	for _, path := range []string{filepath.Join(dir, "missing.pem"), empty, broken} {
+		_, err := LoadCABundle(path)
+		if !errors.Is(err, ErrCABundle) || !strings.Contains(err.Error(), path) {
+			t.Errorf("LoadCABundle(%s) = %v, want ErrCABundle naming the file", path, err)
+		}
+	}
+	if _, err := Load(context.Background(), Env{AccessKeyID: "a", SecretAccessKey: "b", Region: "us-east-1", CABundle: empty}); !errors.Is(err, ErrCABundle) {
+		t.Errorf("Load() with a bundle without certificates = %v, want ErrCABundle", err)
+	}
+}
+
+// TestCABundleWithEndpoint talks to a MinIO-like endpoint whose certificate
+// no system CA signed, path style like S3_FORCE_PATH_STYLE does
+func TestCABundleWithEndpoint(t *testing.T) {
+	var paths []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer srv.Close()
+	bundle := filepath.Join(t.TempDir(), "internal-ca.pem")
+	os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o644)
+
+	headBucket := func(env Env) error {
+		env.AccessKeyID, env.SecretAccessKey, env.Region = "a", "b", "us-east-1"
+		cfg, err := Load(context.Background(), env)
+		if err != nil {
+			return err
+		}
+		cfg.RetryMaxAttempts = 1
+		client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.BaseEndpoint = aws.String(srv.URL)
+			o.UsePathStyle = true
+		})
+		_, err = client.HeadBucket(context.Background(), &s3.HeadBucketInput{Bucket: aws.String("tfvars")})
+		return err
+	}
+	if err := headBucket(Env{}); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("HeadBucket without the bundle = %v, want a certificate error", err)
+	}
+	if err := headBucket(Env{CABundle: bundle}); err != nil {
+		t.Errorf("HeadBucket with the bundle: %v", err)
+	}
+	if err := headBucket(Env{InsecureSkipVerify: true}); err != nil {
+		t.Errorf("HeadBucket with InsecureSkipVerify: %v", err)
+	}
+	if len(paths) != 2 || paths[0] != "/tfvars" {
+		t.Errorf("requests = %q, want two path style ones", paths)
+	}
+}
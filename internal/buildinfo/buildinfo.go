@@ -0,0 +1,79 @@
+// Package buildinfo reports which build of the tool is running. Release
+// builds set the variables with the linker:
+//
+//	go build -ldflags "-X github.com/DrewDrabek/terraform-manage-script-AWS/internal/buildinfo.Version=1.4.0 \
+//	  -X github.com/DrewDrabek/terraform-manage-script-AWS/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/DrewDrabek/terraform-manage-script-AWS/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Anything not set that way falls back to what the Go toolchain embedded.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Set with -ldflags -X.
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info, using "devel" and "unknown" when nothing is known.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = strings.TrimPrefix(bi.Main.Version, "v")
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = s.Value
+				}
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "devel"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+// String is the one line printed by the version command.
+func (i Info) String() string {
+	commit := i.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	return fmt.Sprintf("tfmanage %s (commit %s, built %s, %s)", i.Version, commit, i.Date, i.GoVersion)
+}
+
+// UserAgent is the product/version pair sent to AWS.
+func (i Info) UserAgent() (string, string) {
+	return "tfmanage", i.Version
+}
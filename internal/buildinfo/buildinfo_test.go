@@ -0,0 +1,35 @@
+package buildinfo
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGetUsesLinkerValues(t *testing.T) {
+	oldV, oldC, oldD := Version, Commit, Date
+	t.Cleanup(func() { Version, Commit, Date = oldV, oldC, oldD })
+	Version, Commit, Date = "1.2.3", "0123456789abcdef", "2024-05-30T14:22:00Z"
+
+	info := Get()
+	if info.Version != "1.2.3" || info.Commit != "0123456789abcdef" || info.Date != "2024-05-30T14:22:00Z" {
+		t.Fatalf("Get() = %+v", info)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("GoVersion = %q", info.GoVersion)
+	}
+	if s := info.String(); !strings.Contains(s, "1.2.3") || !strings.Contains(s, "commit 0123456789ab,") {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestGetFallbacks(t *testing.T) {
+	oldV, oldC, oldD := Version, Commit, Date
+	t.Cleanup(func() { Version, Commit, Date = oldV, oldC, oldD })
+	Version, Commit, Date = "", "", ""
+
+	info := Get()
+	if info.Version == "" || info.Commit == "" || info.Date == "" {
+		t.Errorf("Get() left fields empty: %+v", info)
+	}
+}
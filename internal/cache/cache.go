@@ -0,0 +1,92 @@
+// Package cache keeps downloaded tfvars files in a per-user directory, one
+// folder per bucket and environment, with an index that records which remote
+// revision each file came from.
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotCached is returned by Read when nothing has been downloaded for the
+// environment yet.
+var ErrNotCached = errors.New("nothing is cached")
+
+const indexFile = "index.json"
+
+// Entry is the index of one environment's cached file.
+type Entry struct {
+	Key string `json:"key"`
+	// File is the path of the cached copy.
+	File         string    `json:"file"`
+	ETag         string    `json:"etag"`
+	VersionID    string    `json:"version_id,omitempty"`
+	SHA256       string    `json:"sha256"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+}
+
+// Cache is rooted at Root, normally dirs.CacheDir.
+type Cache struct {
+	Root string
+}
+
+// Dir is the folder for one bucket and environment.
+func (c Cache) Dir(bucket, environment string) string {
+	return filepath.Join(c.Root, bucket, environment)
+}
+
+// Path is where the cached copy of fileName lives.
+func (c Cache) Path(bucket, environment, fileName string) string {
+	return filepath.Join(c.Dir(bucket, environment), filepath.Base(fileName))
+}
+
+// Read returns the environment's index entry.
+func (c Cache) Read(bucket, environment string) (Entry, error) {
+	data, err := os.ReadFile(filepath.Join(c.Dir(bucket, environment), indexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Entry{}, fmt.Errorf("%w for %s", ErrNotCached, environment)
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to read the cache index: %w", err)
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Entry{}, fmt.Errorf("failed to read the cache index: %w", err)
+	}
+	return e, nil
+}
+
+// Write replaces the environment's index entry. The index is written to a
+// temporary file and renamed into place, so a concurrent Read sees either the
+// old entry or the new one and two writers never interleave.
+func (c Cache) Write(bucket, environment string, e Entry) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the cache index: %w", err)
+	}
+	dir := c.Dir(bucket, environment)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create the cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+indexFile+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write the cache index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write the cache index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write the cache index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, indexFile)); err != nil {
+		return fmt.Errorf("failed to write the cache index: %w", err)
+	}
+	return nil
+}
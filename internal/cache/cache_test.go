@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadWrite(t *testing.T) {
+	c := Cache{Root: t.TempDir()}
+	if _, err := c.Read("bucket", "dev"); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("Read() before Write = %v, want ErrNotCached", err)
+	}
+
+	want := Entry{Key: "team/dev.tfvars", File: "dev.tfvars", ETag: `"abc"`, VersionID: "v1", SHA256: "123", DownloadedAt: time.Date(2024, 5, 1, 13, 4, 5, 0, time.UTC)}
+	if err := c.Write("bucket", "dev", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Read("bucket", "dev")
+	if err != nil || got != want {
+		t.Errorf("Read() = %+v, %v, want %+v", got, err, want)
+	}
+	if p := c.Path("bucket", "dev", "envs/dev.tfvars"); p != filepath.Join(c.Root, "bucket", "dev", "dev.tfvars") {
+		t.Errorf("Path() = %s", p)
+	}
+}
+
+func TestWriteConcurrent(t *testing.T) {
+	c := Cache{Root: t.TempDir()}
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Write("bucket", "dev", Entry{ETag: fmt.Sprint(i)}); err != nil {
+				t.Error(err)
+			}
+			if _, err := c.Read("bucket", "dev"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, _ := os.ReadDir(c.Dir("bucket", "dev"))
+	if len(entries) != 1 || entries[0].Name() != indexFile {
+		t.Errorf("cache dir has %v, want only the index", entries)
+	}
+}
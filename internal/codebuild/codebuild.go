@@ -0,0 +1,339 @@
+// Package codebuild starts CodeBuild builds, follows their CloudWatch Logs
+// stream and stops them, calling the JSON APIs directly.
+package codebuild
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+var (
+	// ErrNotFound is returned for a project or build that doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAccessDenied is returned when the credentials aren't allowed to make
+	// the call.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrBuildFailed is returned for a build that failed, faulted or timed out.
+	ErrBuildFailed = errors.New("the build failed")
+	// ErrBuildStopped is returned for a build that was stopped.
+	ErrBuildStopped = errors.New("the build was stopped")
+)
+
+// Build statuses.
+const (
+	StatusInProgress = "IN_PROGRESS"
+	StatusSucceeded  = "SUCCEEDED"
+	StatusFailed     = "FAILED"
+	StatusFault      = "FAULT"
+	StatusTimedOut   = "TIMED_OUT"
+	StatusStopped    = "STOPPED"
+)
+
+// Build is what tfmanage needs to know about a build.
+type Build struct {
+	ID       string
+	Project  string
+	Status   string
+	Phase    string
+	Complete bool
+	// LogGroup and LogStream are empty until the build has started logging.
+	LogGroup  string
+	LogStream string
+	// FailedPhase and Message say where and why a build that didn't succeed
+	// stopped, when CodeBuild says so.
+	FailedPhase string
+	Message     string
+}
+
+// Err is nil for a build that succeeded or is still running,
+// ErrBuildStopped for a stopped one and ErrBuildFailed otherwise.
+func (b Build) Err() error {
+	switch b.Status {
+	case StatusSucceeded, StatusInProgress, "":
+		return nil
+	case StatusStopped:
+		return fmt.Errorf("%w: %s", ErrBuildStopped, b.ID)
+	}
+	err := fmt.Errorf("%w: %s is %s", ErrBuildFailed, b.ID, b.Status)
+	if b.FailedPhase != "" {
+		err = fmt.Errorf("%w in the %s phase", err, b.FailedPhase)
+	}
+	if b.Message != "" {
+		err = fmt.Errorf("%w: %s", err, b.Message)
+	}
+	return err
+}
+
+// ConsoleURL is the build's page in the AWS console.
+func ConsoleURL(region, project, id string) string {
+	return "https://" + region + ".console.aws.amazon.com/codesuite/codebuild/projects/" + url.PathEscape(project) + "/build/" + url.PathEscape(id) + "/?region=" + region
+}
+
+// Client calls CodeBuild and CloudWatch Logs directly, signed with the
+// credentials in Config.
+type Client struct {
+	Config aws.Config
+	// CodeBuildEndpoint and LogsEndpoint override
+	// https://codebuild.<region>.amazonaws.com and
+	// https://logs.<region>.amazonaws.com.
+	CodeBuildEndpoint string
+	LogsEndpoint      string
+}
+
+// NewClient creates a client for the region in cfg.
+func NewClient(cfg aws.Config) *Client {
+	return &Client{Config: cfg}
+}
+
+// apiError is the body of a failed call
+type apiError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+	// some errors spell it with a capital M
+	MessageUpper string `json:"Message"`
+}
+
+func (c *Client) call(ctx context.Context, service, target, endpoint string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	if endpoint == "" {
+		endpoint = "https://" + service + "." + c.Config.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	creds, err := c.Config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	}
+	sum := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), service, c.Config.Region, time.Now()); err != nil {
+		return err
+	}
+
+	var client aws.HTTPClient = http.DefaultClient
+	if c.Config.HTTPClient != nil {
+		client = c.Config.HTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e apiError
+		json.Unmarshal(data, &e)
+		code := e.Type[strings.LastIndex(e.Type, "#")+1:]
+		msg := e.Message + e.MessageUpper
+		action := target[strings.LastIndex(target, ".")+1:]
+		switch code {
+		case "ResourceNotFoundException":
+			return fmt.Errorf("%w: %s: %s", ErrNotFound, action, msg)
+		case "AccessDeniedException":
+			return fmt.Errorf("%w: %s: %s", ErrAccessDenied, action, msg)
+		}
+		return fmt.Errorf("%s: %s (%s, status %d)", action, msg, code, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *Client) codebuild(ctx context.Context, action string, in, out any) error {
+	return c.call(ctx, "codebuild", "CodeBuild_20161006."+action, c.CodeBuildEndpoint, in, out)
+}
+
+// build is a build as the API sends it
+type build struct {
+	ID            string `json:"id"`
+	ProjectName   string `json:"projectName"`
+	BuildStatus   string `json:"buildStatus"`
+	CurrentPhase  string `json:"currentPhase"`
+	BuildComplete bool   `json:"buildComplete"`
+	Logs          struct {
+		GroupName  string `json:"groupName"`
+		StreamName string `json:"streamName"`
+	} `json:"logs"`
+	Phases []struct {
+		PhaseType   string `json:"phaseType"`
+		PhaseStatus string `json:"phaseStatus"`
+		Contexts    []struct {
+			Message string `json:"message"`
+		} `json:"contexts"`
+	} `json:"phases"`
+}
+
+func (b build) build() Build {
+	out := Build{
+		ID:        b.ID,
+		Project:   b.ProjectName,
+		Status:    b.BuildStatus,
+		Phase:     b.CurrentPhase,
+		Complete:  b.BuildComplete,
+		LogGroup:  b.Logs.GroupName,
+		LogStream: b.Logs.StreamName,
+	}
+	for _, p := range b.Phases {
+		if p.PhaseStatus == "" || p.PhaseStatus == StatusSucceeded || p.PhaseStatus == StatusInProgress {
+			continue
+		}
+		out.FailedPhase = p.PhaseType
+		for _, c := range p.Contexts {
+			if c.Message != "" {
+				out.Message = c.Message
+			}
+		}
+	}
+	return out
+}
+
+// StartBuild starts a build of project with the environment variables added
+// to the project's, as plain text.
+func (c *Client) StartBuild(ctx context.Context, project string, env map[string]string) (Build, error) {
+	type variable struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+		Type  string `json:"type"`
+	}
+	in := struct {
+		ProjectName string     `json:"projectName"`
+		Env         []variable `json:"environmentVariablesOverride,omitempty"`
+	}{ProjectName: project}
+	for name, value := range env {
+		in.Env = append(in.Env, variable{Name: name, Value: value, Type: "PLAINTEXT"})
+	}
+	var out struct {
+		Build build `json:"build"`
+	}
+	if err := c.codebuild(ctx, "StartBuild", in, &out); err != nil {
+		return Build{}, fmt.Errorf("failed to start a build of %s: %w", project, err)
+	}
+	return out.Build.build(), nil
+}
+
+// Build gives back the build's current status.
+func (c *Client) Build(ctx context.Context, id string) (Build, error) {
+	var out struct {
+		Builds []build `json:"builds"`
+	}
+	if err := c.codebuild(ctx, "BatchGetBuilds", map[string][]string{"ids": {id}}, &out); err != nil {
+		return Build{}, err
+	}
+	if len(out.Builds) == 0 {
+		return Build{}, fmt.Errorf("%w: build %s", ErrNotFound, id)
+	}
+	return out.Builds[0].build(), nil
+}
+
+// StopBuild asks CodeBuild to stop the build, which takes a moment.
+func (c *Client) StopBuild(ctx context.Context, id string) (Build, error) {
+	var out struct {
+		Build build `json:"build"`
+	}
+	if err := c.codebuild(ctx, "StopBuild", map[string]string{"id": id}, &out); err != nil {
+		return Build{}, fmt.Errorf("failed to stop %s: %w", id, err)
+	}
+	return out.Build.build(), nil
+}
+
+// logEvents gives back the log stream's events after token, and the token
+// to go on from
+func (c *Client) logEvents(ctx context.Context, group, stream, token string) ([]string, string, error) {
+	in := map[string]any{"logGroupName": group, "logStreamName": stream, "startFromHead": true}
+	if token != "" {
+		in["nextToken"] = token
+	}
+	var out struct {
+		Events []struct {
+			Message string `json:"message"`
+		} `json:"events"`
+		NextForwardToken string `json:"nextForwardToken"`
+	}
+	if err := c.call(ctx, "logs", "Logs_20140328.GetLogEvents", c.LogsEndpoint, in, &out); err != nil {
+		return nil, token, err
+	}
+	messages := make([]string, len(out.Events))
+	for i, e := range out.Events {
+		messages[i] = e.Message
+	}
+	return messages, out.NextForwardToken, nil
+}
+
+// Follow writes the build's log to w as it comes, polling every interval,
+// and gives back the build once it is complete. When ctx is done first it
+// gives back the build as it last was and ctx's error.
+func (c *Client) Follow(ctx context.Context, id string, w io.Writer, interval time.Duration) (Build, error) {
+	var b Build
+	var token string
+	var err error
+	for {
+		complete := b.Complete
+		if b, err = c.Build(ctx, id); err != nil {
+			return b, err
+		}
+		if b.LogStream != "" {
+			if token, err = c.drain(ctx, b, token, w); err != nil {
+				return b, err
+			}
+		}
+		// the last lines reach CloudWatch a moment after the build completes, so there is one more round after it
+		if complete && b.Complete {
+			return b, nil
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return b, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// drain writes the events after token until there are no more
+func (c *Client) drain(ctx context.Context, b Build, token string, w io.Writer) (string, error) {
+	for {
+		messages, next, err := c.logEvents(ctx, b.LogGroup, b.LogStream, token)
+		if errors.Is(err, ErrNotFound) {
+			// the stream is named before its first line is written
+			return token, nil
+		}
+		if err != nil {
+			return token, fmt.Errorf("failed to read the log of %s: %w", b.ID, err)
+		}
+		for _, m := range messages {
+			io.WriteString(w, m)
+			if !strings.HasSuffix(m, "\n") {
+				io.WriteString(w, "\n")
+			}
+		}
+		if next == "" || next == token {
+			return token, nil
+		}
+		token = next
+	}
+}
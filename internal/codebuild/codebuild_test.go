@@ -0,0 +1,153 @@
+package codebuild
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// fakeAPI plays CodeBuild and CloudWatch Logs: each BatchGetBuilds gets the
+// next of statuses, the last one over and over, and the log grows by a line
+// per poll
+type fakeAPI struct {
+	mu       sync.Mutex
+	t        *testing.T
+	statuses []string
+	lines    []string
+	logged   int
+	started  map[string]any
+	stopped  int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var in map[string]any
+	json.NewDecoder(r.Body).Decode(&in)
+	target := r.Header.Get("X-Amz-Target")
+	service := "/codebuild/"
+	if strings.HasPrefix(target, "Logs_") {
+		service = "/logs/"
+	}
+	if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1"+service) {
+		f.t.Errorf("%s is not signed for %s: %v", target, service, r.Header)
+	}
+	switch target {
+	case "CodeBuild_20161006.StartBuild":
+		if in["projectName"] == "missing" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"ResourceNotFoundException","message":"Project cannot be found: arn:aws:codebuild:us-east-1:123456789012:project/missing"}`)
+			return
+		}
+		f.started = in
+		fmt.Fprint(w, `{"build":{"id":"deploy:1","projectName":"deploy","buildStatus":"IN_PROGRESS","currentPhase":"SUBMITTED"}}`)
+	case "CodeBuild_20161006.BatchGetBuilds":
+		status := f.statuses[0]
+		if len(f.statuses) > 1 {
+			f.statuses = f.statuses[1:]
+		}
+		if f.logged < len(f.lines) {
+			f.logged++
+		}
+		fmt.Fprintf(w, `{"builds":[{"id":"deploy:1","buildStatus":%q,"buildComplete":%t,"logs":{"groupName":"/aws/codebuild/deploy","streamName":"1"},"phases":[{"phaseType":"BUILD","phaseStatus":%q,"contexts":[{"statusCode":"COMMAND_EXECUTION_ERROR","message":"exit status 1"}]}]}]}`, status, status != StatusInProgress, status)
+	case "CodeBuild_20161006.StopBuild":
+		f.stopped++
+		fmt.Fprint(w, `{"build":{"id":"deploy:1","buildStatus":"IN_PROGRESS"}}`)
+	case "Logs_20140328.GetLogEvents":
+		if f.logged == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"ResourceNotFoundException","message":"The specified log stream does not exist."}`)
+			return
+		}
+		from := 0
+		if token, ok := in["nextToken"].(string); ok {
+			fmt.Sscanf(token, "f/%d", &from)
+		}
+		var events []map[string]string
+		for _, line := range f.lines[from:f.logged] {
+			events = append(events, map[string]string{"message": line})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"events": events, "nextForwardToken": fmt.Sprintf("f/%d", f.logged)})
+	default:
+		f.t.Errorf("unexpected call %s", target)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func newTestClient(t *testing.T, api *fakeAPI) *Client {
+	api.t = t
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return &Client{
+		Config: aws.Config{Region: "us-east-1", Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		})},
+		CodeBuildEndpoint: server.URL,
+		LogsEndpoint:      server.URL,
+	}
+}
+
+func TestStartAndFollow(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []string{StatusInProgress, StatusInProgress, StatusSucceeded},
+		lines:    []string{"[Container] Running command tfmanage apply prod\n", "Apply complete!", "[Container] Phase complete: BUILD State: SUCCEEDED\n"},
+	}
+	client := newTestClient(t, api)
+
+	b, err := client.StartBuild(context.Background(), "deploy", map[string]string{"TFMANAGE_ENVIRONMENT": "prod"})
+	if err != nil || b.ID != "deploy:1" {
+		t.Fatalf("StartBuild() = %+v, %v", b, err)
+	}
+	env := api.started["environmentVariablesOverride"].([]any)[0].(map[string]any)
+	if env["name"] != "TFMANAGE_ENVIRONMENT" || env["value"] != "prod" || env["type"] != "PLAINTEXT" {
+		t.Errorf("environment override %v", env)
+	}
+
+	var out bytes.Buffer
+	b, err = client.Follow(context.Background(), b.ID, &out, time.Millisecond)
+	if err != nil || b.Status != StatusSucceeded || b.Err() != nil {
+		t.Fatalf("Follow() = %+v, %v", b, err)
+	}
+	if got := out.String(); got != "[Container] Running command tfmanage apply prod\nApply complete!\n[Container] Phase complete: BUILD State: SUCCEEDED\n" {
+		t.Errorf("log %q, want every line once", got)
+	}
+
+	if _, err := client.StartBuild(context.Background(), "missing", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("StartBuild(missing) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFollowFailedAndCancelled(t *testing.T) {
+	client := newTestClient(t, &fakeAPI{statuses: []string{StatusFailed}})
+	b, err := client.Follow(context.Background(), "deploy:1", &bytes.Buffer{}, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Err(); !errors.Is(err, ErrBuildFailed) || !strings.Contains(err.Error(), "BUILD phase: exit status 1") {
+		t.Errorf("Err() = %v", err)
+	}
+	if err := (Build{ID: "deploy:1", Status: StatusStopped}).Err(); !errors.Is(err, ErrBuildStopped) {
+		t.Errorf("Err() = %v, want ErrBuildStopped", err)
+	}
+
+	api := &fakeAPI{statuses: []string{StatusInProgress}}
+	client = newTestClient(t, api)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.Follow(ctx, "deploy:1", &bytes.Buffer{}, time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Follow() = %v, want the context's error", err)
+	}
+	if _, err := client.StopBuild(context.Background(), "deploy:1"); err != nil || api.stopped != 1 {
+		t.Errorf("StopBuild() = %v, stopped %d times", err, api.stopped)
+	}
+}
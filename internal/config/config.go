@@ -0,0 +1,516 @@
+// Package config reads the optional tfmanage.yaml config file. Everything in
+// it can also be set with environment variables, which win over the file.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/dirs"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is looked for in the working directory when no --config is
+// given and there is no user config file.
+const DefaultFile = "tfmanage.yaml"
+
+// Candidates are the files Load looks for when no path is given, in order.
+// The first one that exists is used.
+func Candidates() []string {
+	return append(dirs.ConfigFiles(), DefaultFile)
+}
+
+// Find returns the first of the candidates that exists, or an empty path.
+func Find() string {
+	for _, path := range Candidates() {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// ErrNotFound is returned by Load when an explicitly requested file is missing.
+var ErrNotFound = errors.New("config file not found")
+
+// Config is the file format.
+type Config struct {
+	Bucket  string `yaml:"bucket"`
+	Prefix  string `yaml:"prefix"`
+	Region  string `yaml:"region"`
+	Profile string `yaml:"profile"`
+	// RoleARN is assumed with the profile, or the credentials of the
+	// environment, for everything the tool does.
+	RoleARN string `yaml:"role_arn"`
+	// CredentialsCommand prints the AWS credentials as credential_process
+	// JSON, it wins over the profile and the access keys.
+	CredentialsCommand string `yaml:"credentials_command"`
+	// PlanDir is where plan writes the plans it names itself, plans in the
+	// working directory when empty.
+	PlanDir string `yaml:"plan_dir"`
+	// GitHubRepo is the owner/name repository applies are recorded in as
+	// GitHub deployments, with the token in GITHUB_TOKEN.
+	GitHubRepo string `yaml:"github_repo"`
+	// CIVariable is one more variable that tells a CI system apart, on top
+	// of GITHUB_ACTIONS, GITLAB_CI and BUILDKITE, for require_ci.
+	CIVariable string `yaml:"ci_variable"`
+	// ExpectedBucketAccount is the 12 digit ID of the account the buckets
+	// uploads go to have to belong to, the account of the AWS credentials
+	// when empty.
+	ExpectedBucketAccount string `yaml:"expected_bucket_account"`
+	// ReplicaBucket is a copy of the bucket, usually kept by S3 replication
+	// in another region, that downloads read from when the bucket can't be
+	// reached. It is never written to. ReplicaRegion is its region, the
+	// bucket's when empty.
+	ReplicaBucket string `yaml:"replica_bucket"`
+	ReplicaRegion string `yaml:"replica_region"`
+	// KMSKeyARN encrypts the tfvars of every environment without a key of
+	// its own.
+	KMSKeyARN    string                 `yaml:"kms_key_arn"`
+	Environments map[string]Environment `yaml:"environments"`
+	Hooks        Hooks                  `yaml:"hooks"`
+	Retention    Retention              `yaml:"retention"`
+	Notify       Notify                 `yaml:"notify"`
+	Metrics      Metrics                `yaml:"metrics"`
+	Cache        Cache                  `yaml:"cache"`
+	Matrix       Matrix                 `yaml:"matrix"`
+	// Branches maps git branches to environments for the auto environment,
+	// keys can be globs such as release/*.
+	Branches map[string]string `yaml:"branches"`
+	// TerraformEnv is added to the environment of every terraform run. Values
+	// can use ${NAME} for variables of the tool's own environment.
+	TerraformEnv map[string]string `yaml:"terraform_env"`
+	// SecretVars maps terraform variables to the Secrets Manager secrets
+	// their values are read from for every plan and apply.
+	SecretVars map[string]SecretVar `yaml:"secret_vars"`
+	// Vault is the HashiCorp Vault VaultVars are read from.
+	Vault Vault `yaml:"vault"`
+	// VaultVars maps terraform variables to the Vault KV fields their values
+	// are read from for every plan and apply.
+	VaultVars map[string]VaultVar `yaml:"vault_vars"`
+
+	// Path is where the config was read from, empty when no file was used.
+	Path string `yaml:"-"`
+}
+
+// Environment is one entry under environments.
+type Environment struct {
+	TFVars string `yaml:"tfvars"`
+	// Chdir is the directory terraform runs in for this environment, the
+	// --chdir flag wins over it.
+	Chdir string `yaml:"chdir"`
+	// Workspace is selected with TF_WORKSPACE for every terraform command.
+	Workspace string `yaml:"workspace"`
+	// Protected asks for the environment name to be typed before commands
+	// that change its state. prod is always protected.
+	Protected bool `yaml:"protected"`
+	// RequireApproval makes apply refuse anything but a stored plan that was
+	// approved with the approve command, --no-approval turns it off.
+	RequireApproval bool `yaml:"require_approval"`
+	// RequiredApprovals is how many different people have to approve a plan
+	// before apply runs it, the person running the apply doesn't count.
+	// Setting it turns on RequireApproval.
+	RequiredApprovals int `yaml:"required_approvals"`
+	// RequireCI makes apply refuse to run outside a CI system unless
+	// --break-glass is passed.
+	RequireCI bool `yaml:"require_ci"`
+	// RequireChangeMessage refuses uploads without a change message, from -m
+	// or, with --ci, TFM_CHANGE_MESSAGE or the commit subject.
+	RequireChangeMessage bool `yaml:"require_change_message"`
+	// TagOnApply tags HEAD after every successful apply, like --tag-on-apply.
+	TagOnApply bool `yaml:"tag_on_apply"`
+	// RequireCleanGit refuses uploads of a tfvars file with uncommitted
+	// changes. It is on for prod when it isn't set.
+	RequireCleanGit *bool `yaml:"require_clean_git"`
+	// Location keeps the tfvars somewhere other than the bucket: another
+	// bucket as s3://bucket/prefix/, SSM Parameter Store as
+	// ssm:///path/to/parameter, Secrets Manager as secretsmanager://secret/name
+	// or a local directory as file:///path/to/dir.
+	Location string `yaml:"location"`
+	// ReplicaBucket and ReplicaRegion are the replica of the environment's
+	// bucket, the global ones are only used for environments without a
+	// location.
+	ReplicaBucket string `yaml:"replica_bucket"`
+	ReplicaRegion string `yaml:"replica_region"`
+	// KMSKeyARN encrypts the environment's tfvars: SSE-KMS in S3, and the
+	// parameter or secret of an SSM or Secrets Manager location. The global
+	// key, or the service's default key, is used when empty.
+	KMSKeyARN string `yaml:"kms_key_arn"`
+	// KMSKey is the older name of KMSKeyARN, used when that isn't set.
+	KMSKey string `yaml:"kms_key"`
+	// ExpectedStateKey is where the backend has to keep the environment's
+	// state for plan and apply to run: the backend key, or with a workspace
+	// the path the backend puts the workspace's state at.
+	ExpectedStateKey string `yaml:"expected_state_key"`
+	// StateKeyTemplate makes tfmanage init pass the backend key, built from
+	// <env>, <workspace> and <prefix>, and plan and apply check it.
+	// ManageStateKey does the same with the default template.
+	StateKeyTemplate string `yaml:"state_key_template"`
+	ManageStateKey   bool   `yaml:"manage_state_key"`
+	// TerraformEnv is added to the environment of the environment's terraform
+	// runs, on top of the global one.
+	TerraformEnv map[string]string `yaml:"terraform_env"`
+	// SecretVars are the environment's secret variables, on top of the
+	// global ones.
+	SecretVars map[string]SecretVar `yaml:"secret_vars"`
+	// VaultVars are the environment's Vault variables, on top of the global
+	// ones.
+	VaultVars map[string]VaultVar `yaml:"vault_vars"`
+	// AssumeRoles are role ARNs terraform's credentials are assumed through,
+	// in order, each with the credentials of the one before it. The last is
+	// the identity terraform runs as.
+	AssumeRoles []string `yaml:"assume_roles"`
+	// Failover is where the environment's tfvars, plans and audit trail are
+	// kept once it has failed over, used for runs with --failover.
+	Failover *Failover `yaml:"failover"`
+}
+
+// Failover is the bucket, region and role an environment uses in place of
+// the usual ones when it has failed over.
+type Failover struct {
+	Bucket string `yaml:"bucket"`
+	// Prefix is the bucket's S3_PATH, the usual one when empty.
+	Prefix string `yaml:"prefix"`
+	// Region is the bucket's region, and the region of every other AWS call
+	// the tool makes. AWS_REGION stays when it is empty.
+	Region string `yaml:"region"`
+	// RoleARN is assumed with the usual credentials, the tool and terraform
+	// both run as it.
+	RoleARN string `yaml:"role_arn"`
+}
+
+// SecretVar is where a secret variable's value is kept. It can be written
+// as the secret on its own, or with the key to read out of a JSON secret.
+type SecretVar struct {
+	// Secret is the ARN or name of the secret.
+	Secret string `yaml:"secret"`
+	// Key is the dotted path of the value in a JSON secret, such as
+	// db.password. The whole secret is the value when it is empty.
+	Key string `yaml:"key"`
+}
+
+// UnmarshalYAML accepts the secret on its own as well as the full form.
+func (v *SecretVar) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&v.Secret)
+	}
+	// Decode doesn't keep KnownFields, so the keys are checked here
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			if k := node.Content[i]; k.Value != "secret" && k.Value != "key" {
+				return fmt.Errorf("line %d: unknown key %q in a secret variable, use secret and key", k.Line, k.Value)
+			}
+		}
+	}
+	type plain SecretVar
+	return node.Decode((*plain)(v))
+}
+
+// Vault is how to reach and log in to Vault. VAULT_ADDR, VAULT_NAMESPACE and
+// VAULT_TOKEN win over it.
+type Vault struct {
+	Address   string `yaml:"address"`
+	Namespace string `yaml:"namespace"`
+	// AWSRole logs in with the AWS auth method as this Vault role, using the
+	// tool's AWS credentials, when VAULT_TOKEN isn't set.
+	AWSRole string `yaml:"aws_role"`
+	// AWSMount is where the AWS auth method is mounted, aws when empty.
+	AWSMount string `yaml:"aws_mount"`
+	// AWSServerID is sent as X-Vault-AWS-IAM-Server-ID for auth methods that
+	// require it.
+	AWSServerID string `yaml:"aws_server_id"`
+}
+
+// VaultVar is the field of a KV secret a variable's value is read from.
+type VaultVar struct {
+	// Mount is where the KV engine is mounted, secret when empty.
+	Mount string `yaml:"mount"`
+	Path  string `yaml:"path"`
+	Field string `yaml:"field"`
+	// Version pins a version of a KV v2 secret, the latest when 0.
+	Version int `yaml:"version"`
+	// KVVersion is the version of the engine, 1 or 2. It is 2 when empty.
+	KVVersion int `yaml:"kv_version"`
+}
+
+// Hooks switches on the optional steps that run around plan and apply.
+type Hooks struct {
+	// Cost prices every plan with infracost.
+	Cost bool `yaml:"cost"`
+	// Infracost is the infracost binary, "infracost" from the PATH when empty.
+	Infracost string `yaml:"infracost"`
+	// PolicyDir holds rego policies every plan has to pass before it is applied.
+	PolicyDir string `yaml:"policy_dir"`
+	// Conftest is the conftest binary, "conftest" from the PATH when empty.
+	Conftest string `yaml:"conftest"`
+	// Lint runs tflint before every plan, LintStrict fails the plan on warnings too.
+	Lint       bool `yaml:"lint"`
+	LintStrict bool `yaml:"lint_strict"`
+	// TFLint is the tflint binary, "tflint" from the PATH when empty.
+	TFLint string `yaml:"tflint"`
+	// FmtCheck runs terraform fmt -check and Validate terraform validate on
+	// the configuration before every plan.
+	FmtCheck bool `yaml:"fmt_check"`
+	Validate bool `yaml:"validate"`
+	// Scan runs checkov on the plan before every apply and fails it on checks
+	// at or above CheckovFailOn (HIGH when empty).
+	Scan          bool   `yaml:"scan"`
+	CheckovFailOn string `yaml:"checkov_fail_on"`
+	// Checkov is the checkov binary, "checkov" from the PATH when empty.
+	Checkov string `yaml:"checkov"`
+	// Dot is the graphviz binary the graph command renders images with,
+	// "dot" from the PATH when empty.
+	Dot string `yaml:"dot"`
+}
+
+// Notify is where alerts such as detected drift are sent.
+type Notify struct {
+	// Webhook is a Slack style incoming webhook URL.
+	Webhook string `yaml:"webhook"`
+}
+
+// Metrics is where each run's metrics are pushed.
+type Metrics struct {
+	// PushgatewayURL is the Prometheus Pushgateway, nothing is pushed when
+	// it is empty.
+	PushgatewayURL string `yaml:"pushgateway_url"`
+	// JobName is the job label, "tfmanage" when empty.
+	JobName string `yaml:"job_name"`
+}
+
+// Matrix configures the matrix report of the environments' tfvars.
+type Matrix struct {
+	// ExpectEqual are the variables that should have the same value in
+	// every environment, a difference in them fails the report.
+	ExpectEqual []string `yaml:"expect_equal"`
+}
+
+// Cache configures the tfvars cache used by download --cache.
+type Cache struct {
+	// MaxAge is how old a cached file can get before --use-cache warns about
+	// it, 24h when empty.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Retention limits how many of the files the tool generates, such as state
+// backups, are kept in the bucket.
+type Retention struct {
+	// Keep is how many of the newest files of each kind and environment are
+	// kept, 0 keeps everything.
+	Keep int `yaml:"keep"`
+	// StorageClass is the S3 storage class state backups and snapshots are
+	// written in, STANDARD when empty.
+	StorageClass string `yaml:"storage_class"`
+}
+
+// Load reads the config file at path. An empty path means the first of the
+// candidates that exists, and an empty Config when none do. A file Validate
+// finds problems in is a *ValidationError with all of them.
+func Load(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		if path = Find(); path == "" {
+			return &Config{}, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if explicit {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+		}
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	if problems := Errors(Validate(data)); len(problems) > 0 {
+		return nil, &ValidationError{Path: path, Problems: problems}
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// Parse decodes the config from YAML. Unknown keys are an error so typos do
+// not go unnoticed.
+func Parse(data []byte) (*Config, error) {
+	cfg := &Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// AddEnvironment adds name under environments in the config file at path,
+// with the tfvars and chdir of env, and creates the file when it doesn't
+// exist. The rest of the file keeps its keys and comments, though it is
+// written back with two space indentation. An environment that is already
+// in the file is an error.
+func AddEnvironment(path, name string, env Environment) error {
+	doc, err := readDocument(path)
+	if err != nil {
+		return err
+	}
+	envs, err := environmentsNode(path, doc, true)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(envs.Content); i += 2 {
+		if envs.Content[i].Value == name {
+			return fmt.Errorf("config file %s already has the %s environment", path, name)
+		}
+	}
+
+	entry := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, field := range [][2]string{{"tfvars", env.TFVars}, {"chdir", env.Chdir}} {
+		if field[1] != "" {
+			entry.Content = append(entry.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field[0]}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field[1]})
+		}
+	}
+	envs.Content = append(envs.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, entry)
+	return writeDocument(path, doc)
+}
+
+// Create writes a new config file at path with the settings config init asks
+// for, the bucket, prefix, region, profile, role and the tfvars of each
+// environment, and a comment above each one saying what it is for. Settings
+// that are empty are left out. An existing file is replaced.
+func Create(path string, cfg *Config) error {
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	add := func(parent *yaml.Node, key string, value *yaml.Node, comment string) {
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key, HeadComment: comment}, value)
+	}
+	scalar := func(value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	}
+	for _, setting := range []struct{ key, value, comment string }{
+		{"bucket", cfg.Bucket, "The bucket the tfvars, plans and state backups are kept in. S3_BUCKET wins over it."},
+		{"prefix", cfg.Prefix, "Where in the bucket they go. S3_PATH wins over it."},
+		{"region", cfg.Region, "The region of the bucket and of every AWS call. AWS_REGION wins over it."},
+		{"profile", cfg.Profile, "The AWS profile the tool's credentials come from. AWS_PROFILE wins over it,\nwithout either the credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY."},
+		{"role_arn", cfg.RoleARN, "Assumed with those credentials for everything the tool does, terraform runs as it too."},
+	} {
+		if setting.value != "" {
+			add(root, setting.key, scalar(setting.value), setting.comment)
+		}
+	}
+	if len(cfg.Environments) > 0 {
+		envs := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		names := make([]string, 0, len(cfg.Environments))
+		for name := range cfg.Environments {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			entry := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			add(entry, "tfvars", scalar(cfg.Environments[name].TFVars), "")
+			add(envs, name, entry, "")
+		}
+		add(root, "environments", envs, "The tfvars file of each environment. <ENV>_TFVARS, such as DEV_TFVARS, wins over it.\ndev, staging, prod, dr and management always exist, with or without an entry.")
+	}
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}, HeadComment: "tfmanage config, see tfmanage config show for every setting and where it comes from."}
+	return writeDocument(path, doc)
+}
+
+// RemoveEnvironment takes name out of environments in the config file at
+// path, keeping the rest of the file like AddEnvironment does. removed is
+// false, and the file untouched, when it isn't there or doesn't have the
+// environment.
+func RemoveEnvironment(path, name string) (removed bool, err error) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	doc, err := readDocument(path)
+	if err != nil {
+		return false, err
+	}
+	envs, err := environmentsNode(path, doc, false)
+	if err != nil || envs == nil {
+		return false, err
+	}
+	for i := 0; i+1 < len(envs.Content); i += 2 {
+		if envs.Content[i].Value == name {
+			envs.Content = append(envs.Content[:i], envs.Content[i+2:]...)
+			return true, writeDocument(path, doc)
+		}
+	}
+	return false, nil
+}
+
+// readDocument parses the config file at path as a node tree, so it can be
+// changed and written back without losing its comments. A missing file is
+// an empty mapping.
+func readDocument(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file %s isn't a mapping of settings", path)
+	}
+	return &doc, nil
+}
+
+// environmentsNode is the environments mapping of doc, added to it when
+// create is set and it has none. Without create a file without environments
+// gives nil.
+func environmentsNode(path string, doc *yaml.Node, create bool) (*yaml.Node, error) {
+	root := doc.Content[0]
+	var envs *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "environments" {
+			envs = root.Content[i+1]
+		}
+	}
+	switch {
+	case envs == nil && !create:
+		return nil, nil
+	case envs == nil:
+		envs = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "environments"}, envs)
+	case envs.Kind == yaml.ScalarNode && envs.Tag == "!!null":
+		if !create {
+			return nil, nil
+		}
+		*envs = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	case envs.Kind != yaml.MappingNode:
+		return nil, fmt.Errorf("environments in config file %s isn't a mapping", path)
+	}
+	return envs, nil
+}
+
+func writeDocument(path string, doc *yaml.Node) error {
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write config file %s: %w", path, err)
+	}
+	return nil
+}
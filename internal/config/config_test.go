@@ -0,0 +1,249 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+bucket: tfvars-bucket
+prefix: team/app/
+region: eu-west-1
+environments:
+  dev:
+    tfvars: envs/dev.tfvars
+  qa:
+    tfvars: envs/qa.tfvars
+hooks:
+  cost: true
+  infracost: /opt/bin/infracost
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Bucket != "tfvars-bucket" || cfg.Prefix != "team/app/" || cfg.Region != "eu-west-1" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if cfg.Environments["qa"].TFVars != "envs/qa.tfvars" {
+		t.Errorf("qa environment = %+v", cfg.Environments["qa"])
+	}
+	if !cfg.Hooks.Cost || cfg.Hooks.Infracost != "/opt/bin/infracost" {
+		t.Errorf("hooks = %+v", cfg.Hooks)
+	}
+}
+
+func TestParseRejectsUnknownKeys(t *testing.T) {
+	if _, err := Parse([]byte("buckt: typo\n")); err == nil {
+		t.Error("unknown key was accepted")
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	cfg, err := Parse(nil)
+	if err != nil || cfg == nil {
+		t.Fatalf("Parse(nil) = %v, %v", cfg, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "custom.yaml")
+	if err := os.WriteFile(path, []byte("bucket: b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil || cfg.Bucket != "b" || cfg.Path != path {
+		t.Fatalf("Load() = %+v, %v", cfg, err)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.yaml")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("explicit missing file gave %v, want ErrNotFound", err)
+	}
+
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	old, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(old)
+	cfg, err = Load("")
+	if err != nil || cfg.Path != "" {
+		t.Errorf("missing default file gave %+v, %v", cfg, err)
+	}
+}
+
+func TestLoadUserConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	old, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(old)
+
+	os.WriteFile(DefaultFile, []byte("bucket: local\n"), 0o644)
+	if cfg, err := Load(""); err != nil || cfg.Bucket != "local" {
+		t.Fatalf("Load() with only ./%s = %+v, %v", DefaultFile, cfg, err)
+	}
+
+	home := filepath.Join(dir, ".config", "tfmanage", "config.yaml")
+	os.MkdirAll(filepath.Dir(home), 0o755)
+	os.WriteFile(home, []byte("bucket: home\n"), 0o644)
+	if cfg, err := Load(""); err != nil || cfg.Bucket != "home" || cfg.Path != home {
+		t.Errorf("Load() = %+v, %v, want ~/.config to win over ./%s", cfg, err, DefaultFile)
+	}
+
+	xdg := filepath.Join(dir, "xdg", "tfmanage", "config.yaml")
+	os.MkdirAll(filepath.Dir(xdg), 0o755)
+	os.WriteFile(xdg, []byte("bucket: xdg\n"), 0o644)
+	if cfg, err := Load(""); err != nil || cfg.Bucket != "xdg" {
+		t.Errorf("Load() = %+v, %v, want $XDG_CONFIG_HOME to win", cfg, err)
+	}
+}
+
+func TestParseSecretVars(t *testing.T) {
+	cfg, err := Parse([]byte(`
+secret_vars:
+  db_password: arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf
+environments:
+  prod:
+    secret_vars:
+      api_key:
+        secret: prod/app
+        key: vendor.api_key
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := cfg.SecretVars["db_password"]; got != (SecretVar{Secret: "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf"}) {
+		t.Errorf("db_password = %+v", got)
+	}
+	if got := cfg.Environments["prod"].SecretVars["api_key"]; got != (SecretVar{Secret: "prod/app", Key: "vendor.api_key"}) {
+		t.Errorf("api_key = %+v", got)
+	}
+	if _, err := Parse([]byte("secret_vars:\n  x:\n    secrt: typo\n")); err == nil {
+		t.Error("unknown key in a secret variable was accepted")
+	}
+}
+
+func TestParseVaultVars(t *testing.T) {
+	cfg, err := Parse([]byte(`
+vault:
+  address: https://vault.example.com:8200
+  aws_role: deployer
+environments:
+  prod:
+    vault_vars:
+      db_password:
+        path: app/prod/db
+        field: password
+        version: 4
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Vault.Address != "https://vault.example.com:8200" || cfg.Vault.AWSRole != "deployer" {
+		t.Errorf("vault = %+v", cfg.Vault)
+	}
+	if got := cfg.Environments["prod"].VaultVars["db_password"]; got != (VaultVar{Path: "app/prod/db", Field: "password", Version: 4}) {
+		t.Errorf("db_password = %+v", got)
+	}
+}
+
+func TestAddEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tfmanage.yaml")
+	os.WriteFile(path, []byte("# shared settings\nbucket: b\nenvironments:\n  dev:\n    tfvars: envs/dev.tfvars # the default\n"), 0o644)
+
+	if err := AddEnvironment(path, "qa", Environment{TFVars: "envs/qa.tfvars", Chdir: "infra"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Bucket != "b" || cfg.Environments["dev"].TFVars != "envs/dev.tfvars" || cfg.Environments["qa"].TFVars != "envs/qa.tfvars" || cfg.Environments["qa"].Chdir != "infra" {
+		t.Errorf("config after AddEnvironment = %+v", cfg)
+	}
+	data, _ := os.ReadFile(path)
+	for _, comment := range []string{"# shared settings", "# the default"} {
+		if !bytes.Contains(data, []byte(comment)) {
+			t.Errorf("AddEnvironment dropped the comment %q:\n%s", comment, data)
+		}
+	}
+	if err := AddEnvironment(path, "qa", Environment{TFVars: "other.tfvars"}); err == nil {
+		t.Error("AddEnvironment() replaced an environment that exists")
+	}
+
+	fresh := filepath.Join(dir, "new.yaml")
+	if err := AddEnvironment(fresh, "qa", Environment{TFVars: "qa.tfvars"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := Load(fresh); err != nil || cfg.Environments["qa"].TFVars != "qa.tfvars" {
+		t.Errorf("Load() of a new config = %+v, %v", cfg, err)
+	}
+}
+
+func TestRemoveEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tfmanage.yaml")
+	os.WriteFile(path, []byte("# shared settings\nbucket: b\nenvironments:\n  dev:\n    tfvars: dev.tfvars # the default\n  qa:\n    tfvars: qa.tfvars\n"), 0o644)
+
+	removed, err := RemoveEnvironment(path, "qa")
+	if err != nil || !removed {
+		t.Fatalf("RemoveEnvironment() = %v, %v", removed, err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Environments["qa"]; ok || cfg.Environments["dev"].TFVars != "dev.tfvars" {
+		t.Errorf("config after RemoveEnvironment = %+v", cfg.Environments)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Contains(data, []byte("# the default")) {
+		t.Errorf("RemoveEnvironment dropped a comment:\n%s", data)
+	}
+	if removed, err := RemoveEnvironment(path, "qa"); removed || err != nil {
+		t.Errorf("RemoveEnvironment() of a missing environment = %v, %v", removed, err)
+	}
+	if removed, err := RemoveEnvironment(filepath.Join(dir, "none.yaml"), "qa"); removed || err != nil {
+		t.Errorf("RemoveEnvironment() without a file = %v, %v", removed, err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tfmanage.yaml")
+	want := &Config{
+		Bucket:  "tfvars",
+		Prefix:  "team/",
+		Region:  "eu-west-1",
+		RoleARN: "arn:aws:iam::111111111111:role/tfmanage",
+		Environments: map[string]Environment{
+			"prod": {TFVars: "envs/prod.tfvars"},
+			"dev":  {TFVars: "envs/dev.tfvars"},
+		},
+	}
+	if err := Create(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Bucket != want.Bucket || got.Prefix != want.Prefix || got.Region != want.Region || got.Profile != "" || got.RoleARN != want.RoleARN ||
+		got.Environments["dev"].TFVars != "envs/dev.tfvars" || got.Environments["prod"].TFVars != "envs/prod.tfvars" {
+		t.Errorf("Load() of the created config = %+v", got)
+	}
+	data, _ := os.ReadFile(path)
+	for _, comment := range []string{"# The bucket the tfvars", "# The tfvars file of each environment"} {
+		if !bytes.Contains(data, []byte(comment)) {
+			t.Errorf("created config is missing %q:\n%s", comment, data)
+		}
+	}
+	if bytes.Contains(data, []byte("profile")) {
+		t.Errorf("created config has the empty profile:\n%s", data)
+	}
+}
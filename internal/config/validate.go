@@ -0,0 +1,273 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is one thing wrong with a config file.
+type Problem struct {
+	// Line is the line of the file it is on, 0 when it isn't known.
+	Line int `json:"line,omitempty"`
+	// Key is the dotted path of the setting, such as environments.dev.chdir.
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+	// Hint says how to fix it, when there is more to say than the message.
+	Hint string `json:"hint,omitempty"`
+	// Known are the keys allowed where an unknown key was found, for
+	// suggesting the one that was meant.
+	Known []string `json:"-"`
+	// Warning is a problem that doesn't stop the file from being used.
+	Warning bool `json:"warning,omitempty"`
+}
+
+// String is the problem on one line, with its line number and hint.
+func (p Problem) String() string {
+	s := p.Message
+	if p.Line > 0 {
+		s = fmt.Sprintf("line %d: %s", p.Line, s)
+	}
+	if p.Hint != "" {
+		s += " - " + p.Hint
+	}
+	return s
+}
+
+// ValidationError is returned by Load for a file with problems that aren't
+// warnings. Path is empty for settings that didn't come from a file.
+type ValidationError struct {
+	Path     string
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := []string{"the settings are invalid:"}
+	if e.Path != "" {
+		lines[0] = fmt.Sprintf("config file %s is invalid:", e.Path)
+	}
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Errors are the problems that aren't warnings.
+func Errors(problems []Problem) []Problem {
+	var errs []Problem
+	for _, p := range problems {
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+	return errs
+}
+
+var syntaxErrorLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// Validate checks data against the file format: that it is YAML, that every
+// key is one the format has and is there once, that every value has the
+// right type, such as durations like 24h, and that the settings that need
+// each other are all there. It reports every problem it finds, not only the
+// first.
+func Validate(data []byte) []Problem {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		p := Problem{Message: strings.TrimPrefix(err.Error(), "yaml: "), Hint: "the file isn't valid YAML"}
+		if m := syntaxErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Message = strings.TrimPrefix(err.Error(), m[0])
+		}
+		return []Problem{p}
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	v := &validator{}
+	v.check(doc.Content[0], reflect.TypeOf(Config{}), "")
+	if len(v.problems) > 0 {
+		return v.problems
+	}
+
+	// the types are right, so what needs what can be checked on the decoded config
+	cfg := &Config{}
+	if err := doc.Decode(cfg); err != nil {
+		return []Problem{{Message: err.Error()}}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Environments)) {
+		if env := cfg.Environments[name]; env.Failover != nil && env.Failover.Bucket == "" {
+			key := "environments." + name + ".failover"
+			v.add(Line(data, key), key+".bucket", key+".bucket is missing, it is the bucket "+name+" fails over to", "add the bucket, or remove failover")
+		}
+	}
+	return v.problems
+}
+
+// validator walks the document next to the types it decodes into
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(line int, key, message, hint string) {
+	v.problems = append(v.problems, Problem{Line: line, Key: key, Message: message, Hint: hint})
+}
+
+var (
+	durationType    = reflect.TypeOf(time.Duration(0))
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+)
+
+func (v *validator) check(node *yaml.Node, t reflect.Type, key string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+	name := key
+	if name == "" {
+		name = "the file"
+	}
+	switch {
+	case t.Kind() == reflect.Pointer:
+		v.check(node, t.Elem(), key)
+	case t.Kind() == reflect.Struct && node.Kind == yaml.ScalarNode && reflect.PointerTo(t).Implements(unmarshalerType):
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.add(node.Line, key, fmt.Sprintf("%s: %v", name, err), "")
+		}
+	case t.Kind() == reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.add(node.Line, key, fmt.Sprintf("%s has to be a mapping of keys to values, not %s", name, describeNode(node)), "")
+			return
+		}
+		fields := map[string]reflect.Type{}
+		var known []string
+		for i := range t.NumField() {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			fields[tag] = t.Field(i).Type
+			known = append(known, tag)
+		}
+		v.mapping(node, key, func(k *yaml.Node, value *yaml.Node) {
+			field, ok := fields[k.Value]
+			if !ok {
+				where := "at the top level"
+				if key != "" {
+					where = "in " + key
+				}
+				v.problems = append(v.problems, Problem{Line: k.Line, Key: join(key, k.Value), Message: fmt.Sprintf("unknown key %s %s", k.Value, where), Known: known})
+				return
+			}
+			v.check(value, field, join(key, k.Value))
+		})
+	case t.Kind() == reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.add(node.Line, key, fmt.Sprintf("%s has to be a mapping of names to values, not %s", name, describeNode(node)), "")
+			return
+		}
+		v.mapping(node, key, func(k *yaml.Node, value *yaml.Node) {
+			v.check(value, t.Elem(), join(key, k.Value))
+		})
+	case t.Kind() == reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.add(node.Line, key, fmt.Sprintf("%s has to be a list, not %s", name, describeNode(node)), "write it as [a, b] or as one - item per line")
+			return
+		}
+		for i, item := range node.Content {
+			v.check(item, t.Elem(), fmt.Sprintf("%s[%d]", key, i))
+		}
+	case node.Kind != yaml.ScalarNode:
+		v.add(node.Line, key, fmt.Sprintf("%s has to be a single value, not %s", name, describeNode(node)), "")
+	case t == durationType && node.Tag == "!!int":
+		v.add(node.Line, key, fmt.Sprintf("%s has to be a duration, %s on its own is nanoseconds", name, node.Value), "add the unit, such as "+node.Value+"h or "+node.Value+"m")
+	case t == durationType:
+		if _, err := time.ParseDuration(node.Value); err != nil {
+			v.add(node.Line, key, fmt.Sprintf("%s has to be a duration, not %q", name, node.Value), "use a duration such as 24h, 90m or 1h30m")
+		}
+	default:
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.add(node.Line, key, fmt.Sprintf("%s has to be %s, not %q", name, describeType(t), node.Value), "")
+		}
+	}
+}
+
+// mapping calls each for every key of node, and reports the keys that are there twice
+func (v *validator) mapping(node *yaml.Node, key string, each func(k, value *yaml.Node)) {
+	seen := map[string]int{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, value := node.Content[i], node.Content[i+1]
+		if k.Tag == "!!merge" {
+			continue
+		}
+		if line, ok := seen[k.Value]; ok {
+			v.add(k.Line, join(key, k.Value), fmt.Sprintf("%s is there twice, on line %d and line %d", join(key, k.Value), line, k.Line), "remove one of them")
+			continue
+		}
+		seen[k.Value] = k.Line
+		each(k, value)
+	}
+}
+
+func join(key, name string) string {
+	if key == "" {
+		return name
+	}
+	return key + "." + name
+}
+
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return strconv.Quote(node.Value)
+}
+
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int64:
+		return "a whole number"
+	}
+	return "a " + t.Kind().String()
+}
+
+// Line is the line of the dotted key in the config file data, 0 when it
+// isn't there.
+func Line(data []byte, key string) int {
+	var doc yaml.Node
+	if yaml.Unmarshal(data, &doc) != nil || len(doc.Content) == 0 {
+		return 0
+	}
+	node, line := doc.Content[0], 0
+	for _, name := range strings.Split(key, ".") {
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		if node.Kind != yaml.MappingNode {
+			return 0
+		}
+		found := false
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == name {
+				node, line, found = node.Content[i+1], node.Content[i].Line, true
+			}
+		}
+		if !found {
+			return 0
+		}
+	}
+	return line
+}
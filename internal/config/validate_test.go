@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	data := []byte(`bucket: tfvars
+regoin: eu-west-1
+cache:
+  max_age: 24
+environments:
+  dev:
+    chdr: infra
+    protected: maybe
+    required_approvals: two
+    assume_roles: arn:aws:iam::111111111111:role/deploy
+    secret_vars:
+      db_password: prod/db
+      api_key:
+        secret: prod/api
+        field: token
+  dev:
+    tfvars: dev.tfvars
+  dr:
+    failover:
+      region: us-west-2
+`)
+	var got []string
+	for _, p := range Validate(data) {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"line 2: unknown key regoin at the top level",
+		"line 4: cache.max_age has to be a duration, 24 on its own is nanoseconds - add the unit, such as 24h or 24m",
+		"line 7: unknown key chdr in environments.dev",
+		`line 8: environments.dev.protected has to be true or false, not "maybe"`,
+		`line 9: environments.dev.required_approvals has to be a whole number, not "two"`,
+		`line 10: environments.dev.assume_roles has to be a list, not "arn:aws:iam::111111111111:role/deploy" - write it as [a, b] or as one - item per line`,
+		"line 15: unknown key field in environments.dev.secret_vars.api_key",
+		"line 16: environments.dev is there twice, on line 6 and line 16 - remove one of them",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// the decoded config is only checked once the types are right
+	problems := Validate([]byte("environments:\n  dr:\n    failover:\n      region: us-west-2\n"))
+	if len(problems) != 1 || problems[0].Line != 3 || !strings.Contains(problems[0].Message, "environments.dr.failover.bucket is missing") {
+		t.Errorf("Validate() of a failover without a bucket = %+v", problems)
+	}
+	if problems := Validate([]byte("bucket: [a\n")); len(problems) != 1 || problems[0].Line == 0 {
+		t.Errorf("Validate() of broken YAML = %+v, want one problem with its line", problems)
+	}
+	for _, ok := range []string{"", "# nothing yet\n", "cache:\n  max_age: 36h\nsecret_vars:\n  token: prod/token\n"} {
+		if problems := Validate([]byte(ok)); len(problems) != 0 {
+			t.Errorf("Validate(%q) = %+v", ok, problems)
+		}
+	}
+}
+
+func TestLoadValidates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tfmanage.yaml")
+	os.WriteFile(path, []byte("bucket: tfvars\nprofle: deploy\nenvironments:\n  dev:\n    protected: yes please\n"), 0o644)
+	_, err := Load(path)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || invalid.Path != path || len(invalid.Problems) != 2 {
+		t.Fatalf("Load() = %v, want both problems", err)
+	}
+	if invalid.Problems[0].Key != "profle" || !strings.Contains(strings.Join(invalid.Problems[0].Known, " "), "profile") {
+		t.Errorf("unknown key problem = %+v, want the keys it could have been", invalid.Problems[0])
+	}
+}
+
+func TestLine(t *testing.T) {
+	data := []byte("bucket: tfvars\nenvironments:\n  dev:\n    tfvars: dev.tfvars\n")
+	for key, want := range map[string]int{"bucket": 1, "environments.dev": 3, "environments.dev.tfvars": 4, "environments.prod": 0, "bucket.name": 0} {
+		if got := Line(data, key); got != want {
+			t.Errorf("Line(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
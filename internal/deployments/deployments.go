@@ -0,0 +1,143 @@
+// Package deployments records applies as GitHub deployments, so whatever
+// follows GitHub deployment statuses sees them. Only the REST endpoints for
+// creating a deployment and adding statuses to it are used.
+package deployments
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TokenEnv holds the token the API is called with. It is only ever sent in
+// the Authorization header.
+const TokenEnv = "GITHUB_TOKEN"
+
+// APIURLEnv is the API of GitHub Enterprise Server, GitHub Actions sets it
+// for every job.
+const APIURLEnv = "GITHUB_API_URL"
+
+// DefaultAPIURL is github.com's API.
+const DefaultAPIURL = "https://api.github.com"
+
+// The deployment states the tool sets.
+const (
+	StateInProgress = "in_progress"
+	StateSuccess    = "success"
+	StateFailure    = "failure"
+)
+
+// maxDescription is as long as GitHub takes a status description.
+const maxDescription = 140
+
+var repoName = regexp.MustCompile(`^[\w.-]+/[\w.-]+$`)
+
+// ValidRepo reports whether repo looks like owner/name.
+func ValidRepo(repo string) bool {
+	return repoName.MatchString(repo)
+}
+
+// Client is what the API is called with, a variable so tests can swap it.
+var Client = &http.Client{Timeout: 15 * time.Second}
+
+// Repo is one repository's deployments.
+type Repo struct {
+	// Name is owner/name.
+	Name  string
+	Token string
+	// APIURL is DefaultAPIURL when empty.
+	APIURL string
+}
+
+// FromEnv is the repository with the token and API URL from the
+// environment.
+func FromEnv(name string) Repo {
+	return Repo{Name: name, Token: os.Getenv(TokenEnv), APIURL: os.Getenv(APIURLEnv)}
+}
+
+// Create creates a deployment of ref to environment and gives back its ID.
+// The commit statuses of ref aren't checked, the apply has its own gates.
+func (r Repo) Create(ctx context.Context, ref, environment, description string) (int64, error) {
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err := r.post(ctx, "/deployments", map[string]any{
+		"ref":               ref,
+		"environment":       environment,
+		"description":       truncate(description),
+		"auto_merge":        false,
+		"required_contexts": []string{},
+	}, &created)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create the deployment: %w", err)
+	}
+	return created.ID, nil
+}
+
+// Status is one status of a deployment.
+type Status struct {
+	State       string
+	Description string
+	// LogURL is where the run's output can be read, left out when empty.
+	LogURL string
+}
+
+// SetStatus adds a status to the deployment.
+func (r Repo) SetStatus(ctx context.Context, id int64, s Status) error {
+	body := map[string]any{"state": s.State, "description": truncate(s.Description)}
+	if s.LogURL != "" {
+		body["log_url"] = s.LogURL
+	}
+	if err := r.post(ctx, fmt.Sprintf("/deployments/%d/statuses", id), body, nil); err != nil {
+		return fmt.Errorf("failed to set the deployment's status to %s: %w", s.State, err)
+	}
+	return nil
+}
+
+func (r Repo) post(ctx context.Context, path string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(cmp.Or(r.APIURL, DefaultAPIURL), "/") + "/repos/" + r.Name + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+r.Token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		answer, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GitHub answered %s: %s", resp.Status, strings.TrimSpace(string(answer)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to read GitHub's answer: %w", err)
+	}
+	return nil
+}
+
+func truncate(description string) string {
+	if len(description) <= maxDescription {
+		return description
+	}
+	return description[:maxDescription-3] + "..."
+}
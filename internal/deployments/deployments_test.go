@@ -0,0 +1,66 @@
+package deployments
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateAndSetStatus(t *testing.T) {
+	var requests []string
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		requests, bodies = append(requests, r.Method+" "+r.URL.Path), append(bodies, body)
+		if strings.HasSuffix(r.URL.Path, "/deployments") {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": 42}`))
+		}
+	}))
+	defer srv.Close()
+
+	repo := Repo{Name: "acme/infra", Token: "secret-token", APIURL: srv.URL + "/"}
+	id, err := repo.Create(context.Background(), "0123456789abcdef", "prod", "tfmanage apply prod")
+	if err != nil || id != 42 {
+		t.Fatalf("Create() = %d, %v", id, err)
+	}
+	if err := repo.SetStatus(context.Background(), id, Status{State: StateSuccess, Description: strings.Repeat("x", 200), LogURL: "https://ci.example.com/run/1"}); err != nil {
+		t.Fatalf("SetStatus(): %v", err)
+	}
+	if strings.Join(requests, ", ") != "POST /repos/acme/infra/deployments, POST /repos/acme/infra/deployments/42/statuses" {
+		t.Errorf("requests = %q", requests)
+	}
+	if bodies[0]["ref"] != "0123456789abcdef" || bodies[0]["environment"] != "prod" || bodies[0]["auto_merge"] != false {
+		t.Errorf("deployment = %v", bodies[0])
+	}
+	if d, _ := bodies[1]["description"].(string); bodies[1]["state"] != "success" || bodies[1]["log_url"] != "https://ci.example.com/run/1" || len(d) != maxDescription {
+		t.Errorf("status = %v", bodies[1])
+	}
+}
+
+func TestErrorsLeaveOutTheToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := Repo{Name: "acme/infra", Token: "secret-token", APIURL: srv.URL}.Create(context.Background(), "abc", "prod", "")
+	if err == nil || !strings.Contains(err.Error(), "401") || !strings.Contains(err.Error(), "Bad credentials") || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("Create() = %v", err)
+	}
+}
+
+func TestValidRepo(t *testing.T) {
+	for repo, want := range map[string]bool{"acme/infra": true, "acme/infra.live": true, "infra": false, "acme/infra/x": false, "": false} {
+		if got := ValidRepo(repo); got != want {
+			t.Errorf("ValidRepo(%q) = %v", repo, got)
+		}
+	}
+}
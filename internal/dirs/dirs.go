@@ -0,0 +1,80 @@
+// Package dirs is where the tool keeps files outside the working directory:
+// the user config file, state such as history and locks, and the cache. It
+// follows the XDG base directory variables everywhere they are set and falls
+// back to the platform's own directories otherwise.
+package dirs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+)
+
+// App is the folder name used under every base directory.
+const App = "tfmanage"
+
+// ConfigFileName is the name of the user config file under the config directories.
+const ConfigFileName = "config.yaml"
+
+// ConfigFiles returns the user config files to look for, best first:
+// $XDG_CONFIG_HOME, the platform config directory and ~/.config.
+func ConfigFiles() []string {
+	var bases []string
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		bases = append(bases, dir)
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		bases = append(bases, dir)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		bases = append(bases, filepath.Join(home, ".config"))
+	}
+
+	var files []string
+	for _, base := range bases {
+		file := filepath.Join(base, App, ConfigFileName)
+		if !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// StateDir is where history, locks and other state go: $XDG_STATE_HOME,
+// ~/.local/state on Linux and the platform config directory elsewhere.
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, App), nil
+	}
+	switch runtime.GOOS {
+	case "windows", "darwin", "ios":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, App, "state"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if home == "" {
+		return "", errors.New("neither $XDG_STATE_HOME nor $HOME are defined")
+	}
+	return filepath.Join(home, ".local", "state", App), nil
+}
+
+// CacheDir is where downloaded files are cached: $XDG_CACHE_HOME or the
+// platform cache directory.
+func CacheDir() (string, error) {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, App), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, App), nil
+}
@@ -0,0 +1,54 @@
+package dirs
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestConfigFiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+
+	files := ConfigFiles()
+	if len(files) == 0 || files[0] != filepath.Join(home, "xdg", "tfmanage", "config.yaml") {
+		t.Fatalf("ConfigFiles() = %q, want $XDG_CONFIG_HOME first", files)
+	}
+	if last := files[len(files)-1]; last != filepath.Join(home, ".config", "tfmanage", "config.yaml") {
+		t.Errorf("ConfigFiles() = %q, want ~/.config last", files)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", "")
+	if runtime.GOOS == "linux" {
+		if files := ConfigFiles(); len(files) != 1 {
+			t.Errorf("ConfigFiles() = %q, want ~/.config once", files)
+		}
+	}
+}
+
+func TestStateAndCacheDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", filepath.Join(home, "state"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, "cache"))
+
+	if dir, err := StateDir(); err != nil || dir != filepath.Join(home, "state", "tfmanage") {
+		t.Errorf("StateDir() = %s, %v", dir, err)
+	}
+	if dir, err := CacheDir(); err != nil || dir != filepath.Join(home, "cache", "tfmanage") {
+		t.Errorf("CacheDir() = %s, %v", dir, err)
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "")
+	if dir, _ := StateDir(); dir != filepath.Join(home, ".local", "state", "tfmanage") {
+		t.Errorf("StateDir() without XDG_STATE_HOME = %s", dir)
+	}
+	if dir, _ := CacheDir(); dir != filepath.Join(home, ".cache", "tfmanage") {
+		t.Errorf("CacheDir() without XDG_CACHE_HOME = %s", dir)
+	}
+}
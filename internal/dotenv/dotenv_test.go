@@ -0,0 +1,57 @@
+package dotenv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := `# team defaults
+S3_BUCKET=tfvars-bucket
+export S3_PATH = team/ # trailing comment
+
+DEV_TFVARS="envs/dev tfvars.tfvars"
+PROD_TFVARS='envs/#prod.tfvars'  # kept literally
+	export   AWS_PROFILE=deploy
+MULTI="a\nb \"quoted\" c\\d"
+EMPTY=
+URL=https://example.com/#anchor
+exported=1
+`
+	got, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Var{
+		{"S3_BUCKET", "tfvars-bucket", 2},
+		{"S3_PATH", "team/", 3},
+		{"DEV_TFVARS", "envs/dev tfvars.tfvars", 5},
+		{"PROD_TFVARS", "envs/#prod.tfvars", 6},
+		{"AWS_PROFILE", "deploy", 7},
+		{"MULTI", "a\nb \"quoted\" c\\d", 8},
+		{"EMPTY", "", 9},
+		{"URL", "https://example.com/#anchor", 10},
+		{"exported", "1", 11},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{
+		"S3_BUCKET",
+		"1BAD=x",
+		"export =x",
+		`S3_BUCKET="open`,
+		`S3_BUCKET='open`,
+		`S3_BUCKET="a" b`,
+	} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", input)
+		} else if !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("Parse(%q) = %v, want the line number", input, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package ghactions talks to the GitHub Actions runner through its workflow
+// commands and the files it points at with environment variables. None of it
+// needs network access.
+package ghactions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Detected reports whether the process runs inside a GitHub Actions job.
+func Detected() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// RunURL is the page of the current workflow run, empty outside GitHub
+// Actions.
+func RunURL() string {
+	server, repo, run := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID")
+	if server == "" || repo == "" || run == "" {
+		return ""
+	}
+	return server + "/" + repo + "/actions/runs/" + run
+}
+
+var (
+	escaper         = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	propertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// Annotate writes a workflow command such as ::error::message. level is
+// error, warning or notice.
+func Annotate(w io.Writer, level, message string) {
+	fmt.Fprintf(w, "::%s::%s\n", level, escaper.Replace(message))
+}
+
+// AnnotateFile is Annotate pointing at a line of a file, which GitHub shows
+// inline on the PR. A line of 0 marks the whole file.
+func AnnotateFile(w io.Writer, level, file string, line int, message string) {
+	props := "file=" + propertyEscaper.Replace(file)
+	if line > 0 {
+		props += fmt.Sprintf(",line=%d", line)
+	}
+	fmt.Fprintf(w, "::%s %s::%s\n", level, props, escaper.Replace(message))
+}
+
+// AppendSummary adds markdown to the job's step summary. It does nothing
+// when GITHUB_STEP_SUMMARY is not set.
+func AppendSummary(markdown string) error {
+	return appendTo("GITHUB_STEP_SUMMARY", func(w io.Writer) error {
+		_, err := io.WriteString(w, markdown+"\n")
+		return err
+	})
+}
+
+// SetOutputs sets step outputs through GITHUB_OUTPUT. It does nothing when
+// GITHUB_OUTPUT is not set.
+func SetOutputs(outputs map[string]string) error {
+	return appendTo("GITHUB_OUTPUT", func(w io.Writer) error {
+		for _, name := range slices.Sorted(maps.Keys(outputs)) {
+			if err := writeOutput(w, name, outputs[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeOutput uses the name=value form where it can and the heredoc form for
+// multi-line values
+func writeOutput(w io.Writer, name, value string) error {
+	if !strings.ContainsAny(value, "\r\n") {
+		_, err := fmt.Fprintf(w, "%s=%s\n", name, value)
+		return err
+	}
+	delim, err := delimiter()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s<<%s\n%s\n%s\n", name, delim, value, delim)
+	return err
+}
+
+func delimiter() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "ghadelimiter_" + hex.EncodeToString(b), nil
+}
+
+func appendTo(envVar string, write func(io.Writer) error) error {
+	path := os.Getenv(envVar)
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", envVar, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", envVar, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", envVar, err)
+	}
+	return nil
+}
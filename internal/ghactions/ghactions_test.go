@@ -0,0 +1,69 @@
+package ghactions
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestAnnotateEscapes(t *testing.T) {
+	var b bytes.Buffer
+	Annotate(&b, "error", "upload failed: 100% broken\nsecond line")
+	if got, want := b.String(), "::error::upload failed: 100%25 broken%0Asecond line\n"; got != want {
+		t.Errorf("Annotate() = %q, want %q", got, want)
+	}
+}
+
+func TestAnnotateFile(t *testing.T) {
+	var b bytes.Buffer
+	AnnotateFile(&b, "warning", "modules/a,b/main.tf", 12, "unused variable")
+	AnnotateFile(&b, "notice", "main.tf", 0, "note")
+	want := "::warning file=modules/a%2Cb/main.tf,line=12::unused variable\n::notice file=main.tf::note\n"
+	if b.String() != want {
+		t.Errorf("AnnotateFile() = %q, want %q", b.String(), want)
+	}
+}
+
+func TestSetOutputs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output")
+	t.Setenv("GITHUB_OUTPUT", path)
+
+	if err := SetOutputs(map[string]string{"changes": "true", "add": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetOutputs(map[string]string{"body": "line one\nline two"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	pattern := regexp.MustCompile(`^add=1\nchanges=true\nbody<<(ghadelimiter_[0-9a-f]+)\nline one\nline two\n(ghadelimiter_[0-9a-f]+)\n$`)
+	m := pattern.FindStringSubmatch(string(data))
+	if m == nil || m[1] != m[2] {
+		t.Errorf("GITHUB_OUTPUT = %q", data)
+	}
+}
+
+func TestUnsetFilesAreIgnored(t *testing.T) {
+	t.Setenv("GITHUB_OUTPUT", "")
+	t.Setenv("GITHUB_STEP_SUMMARY", "")
+	if err := SetOutputs(map[string]string{"changes": "true"}); err != nil {
+		t.Error(err)
+	}
+	if err := AppendSummary("# hi"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunURL(t *testing.T) {
+	t.Setenv("GITHUB_SERVER_URL", "https://github.com")
+	t.Setenv("GITHUB_REPOSITORY", "acme/infra")
+	t.Setenv("GITHUB_RUN_ID", "1234")
+	if got := RunURL(); got != "https://github.com/acme/infra/actions/runs/1234" {
+		t.Errorf("RunURL() = %q", got)
+	}
+	t.Setenv("GITHUB_RUN_ID", "")
+	if got := RunURL(); got != "" {
+		t.Errorf("RunURL() outside a run = %q", got)
+	}
+}
@@ -0,0 +1,130 @@
+// Package gitinfo reads what the tool needs to know about the git checkout it
+// runs in. Nothing here is required, every lookup gives back an empty value
+// outside a repository or without git installed.
+package gitinfo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Commit returns the commit being worked on. In CI the commit the provider
+// reports wins, since some checkouts are detached or shallow.
+func Commit(ctx context.Context) string {
+	if sha := os.Getenv("GITHUB_SHA"); sha != "" {
+		return sha
+	}
+	return git(ctx, "rev-parse", "HEAD")
+}
+
+// branchVars are where CI systems put the branch of a detached checkout, in
+// the order they are tried. GITHUB_HEAD_REF is only set on pull requests.
+var branchVars = []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "BRANCH_NAME", "GIT_BRANCH"}
+
+// Branch returns the branch being worked on. A detached HEAD, as most CI
+// checkouts are, falls back to the variables CI systems set for it and then
+// to GITHUB_REF when it names a branch. It is empty when nothing says.
+func Branch(ctx context.Context) string {
+	if branch := git(ctx, "rev-parse", "--abbrev-ref", "HEAD"); branch != "" && branch != "HEAD" {
+		return branch
+	}
+	for _, name := range branchVars {
+		if branch := os.Getenv(name); branch != "" {
+			return strings.TrimPrefix(branch, "origin/")
+		}
+	}
+	if ref, ok := strings.CutPrefix(os.Getenv("GITHUB_REF"), "refs/heads/"); ok {
+		return ref
+	}
+	return ""
+}
+
+// Short cuts a commit down to the usual 7 characters.
+func Short(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// FileStatus is what git knows about one file.
+type FileStatus struct {
+	// InRepo is false when the file is not inside a git work tree, the other
+	// fields are empty then.
+	InRepo bool
+	SHA    string
+	// Branch is empty on a detached HEAD.
+	Branch string
+	// Dirty is set when the file differs from HEAD, including files that are
+	// untracked or ignored and so only exist in the working copy.
+	Dirty bool
+}
+
+// File looks up the repository the file lives in, running git from the
+// file's directory rather than the working directory.
+func File(ctx context.Context, fileName string) FileStatus {
+	dir, base := filepath.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+	if git(ctx, "-C", dir, "rev-parse", "--is-inside-work-tree") != "true" {
+		return FileStatus{}
+	}
+	return FileStatus{
+		InRepo: true,
+		SHA:    git(ctx, "-C", dir, "rev-parse", "HEAD"),
+		Branch: git(ctx, "-C", dir, "symbolic-ref", "--short", "-q", "HEAD"),
+		Dirty:  git(ctx, "-C", dir, "status", "--porcelain", "--ignored", "--", base) != "",
+	}
+}
+
+// Subject returns the first line of the message of HEAD in the repository
+// dir is in, empty outside one.
+func Subject(ctx context.Context, dir string) string {
+	return git(ctx, "-C", dir, "log", "-1", "--format=%s")
+}
+
+// WorkTree reports whether dir is inside a git work tree, and whether any
+// tracked file in it has uncommitted changes. Untracked files don't count.
+func WorkTree(ctx context.Context, dir string) (inRepo, dirty bool) {
+	if git(ctx, "-C", dir, "rev-parse", "--is-inside-work-tree") != "true" {
+		return false, false
+	}
+	return true, git(ctx, "-C", dir, "status", "--porcelain", "--untracked-files=no") != ""
+}
+
+// Tag creates an annotated tag with the message at HEAD of the repository
+// dir is in.
+func Tag(ctx context.Context, dir, name, message string) error {
+	return gitRun(ctx, "-C", dir, "tag", "--annotate", "--message", message, name, "HEAD")
+}
+
+// PushTag pushes the tag to the remote.
+func PushTag(ctx context.Context, dir, remote, name string) error {
+	return gitRun(ctx, "-C", dir, "push", remote, "refs/tags/"+name)
+}
+
+// gitRun is git for the commands whose failure matters, git's own message is
+// part of the error.
+func gitRun(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("git %s: %w: %s", args[2], err, msg)
+		}
+		return fmt.Errorf("git %s: %w", args[2], err)
+	}
+	return nil
+}
+
+func git(ctx context.Context, args ...string) string {
+	out, err := exec.CommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
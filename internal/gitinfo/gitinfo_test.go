@@ -0,0 +1,163 @@
+package gitinfo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommitPrefersGitHubSHA(t *testing.T) {
+	t.Setenv("GITHUB_SHA", "0123456789abcdef")
+	if got := Commit(context.Background()); got != "0123456789abcdef" {
+		t.Errorf("Commit() = %q", got)
+	}
+}
+
+func TestCommitOutsideARepository(t *testing.T) {
+	t.Setenv("GITHUB_SHA", "")
+	t.Setenv("GIT_CEILING_DIRECTORIES", os.TempDir())
+	old, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+
+	if got := Commit(context.Background()); got != "" {
+		t.Errorf("Commit() = %q, want empty", got)
+	}
+}
+
+func TestShort(t *testing.T) {
+	if got := Short("0123456789abcdef"); got != "0123456" {
+		t.Errorf("Short() = %q", got)
+	}
+	if got := Short("abc"); got != "abc" {
+		t.Errorf("Short() = %q", got)
+	}
+}
+
+func TestFile(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "test"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	file := filepath.Join(dir, "prod.tfvars")
+	os.WriteFile(file, []byte("a = 1\n"), 0o644)
+	if got := File(ctx, file); !got.InRepo || !got.Dirty {
+		t.Errorf("File() on an untracked file = %+v, want dirty", got)
+	}
+
+	for _, args := range [][]string{{"add", "prod.tfvars"}, {"commit", "-q", "-m", "tfvars"}} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	got := File(ctx, file)
+	if !got.InRepo || got.Dirty || got.Branch != "main" || len(got.SHA) != 40 {
+		t.Errorf("File() on a committed file = %+v", got)
+	}
+	if got := Subject(ctx, dir); got != "tfvars" {
+		t.Errorf("Subject() = %q, want the commit's subject", got)
+	}
+
+	os.WriteFile(file, []byte("a = 2\n"), 0o644)
+	if got := File(ctx, file); !got.Dirty {
+		t.Errorf("File() on a modified file = %+v, want dirty", got)
+	}
+}
+
+func TestFileOutsideARepository(t *testing.T) {
+	t.Setenv("GIT_CEILING_DIRECTORIES", os.TempDir())
+	file := filepath.Join(t.TempDir(), "prod.tfvars")
+	os.WriteFile(file, nil, 0o644)
+	if got := File(context.Background(), file); got != (FileStatus{}) {
+		t.Errorf("File() = %+v, want nothing", got)
+	}
+}
+
+func TestBranchFallsBackToCIVariables(t *testing.T) {
+	t.Setenv("GIT_CEILING_DIRECTORIES", os.TempDir())
+	old, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+	for _, name := range append(branchVars, "GITHUB_REF") {
+		t.Setenv(name, "")
+	}
+
+	if got := Branch(context.Background()); got != "" {
+		t.Errorf("Branch() = %q, want empty", got)
+	}
+	t.Setenv("GITHUB_REF", "refs/tags/v1.0.0")
+	if got := Branch(context.Background()); got != "" {
+		t.Errorf("Branch() on a tag ref = %q, want empty", got)
+	}
+	t.Setenv("GITHUB_REF", "refs/heads/release/1.2")
+	if got := Branch(context.Background()); got != "release/1.2" {
+		t.Errorf("Branch() = %q, want the GITHUB_REF branch", got)
+	}
+	t.Setenv("CI_COMMIT_REF_NAME", "develop")
+	if got := Branch(context.Background()); got != "develop" {
+		t.Errorf("Branch() = %q, want CI_COMMIT_REF_NAME", got)
+	}
+}
+
+func TestWorkTreeAndTag(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+	if inRepo, _ := WorkTree(ctx, dir); inRepo {
+		t.Fatalf("WorkTree() outside a repository says it is in one")
+	}
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "test"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	file := filepath.Join(dir, "main.tf")
+	os.WriteFile(file, nil, 0o644)
+	for _, args := range [][]string{{"add", "main.tf"}, {"commit", "-q", "-m", "main"}} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "plan.out"), nil, 0o644)
+	if inRepo, dirty := WorkTree(ctx, dir); !inRepo || dirty {
+		t.Errorf("WorkTree() with only an untracked file = %v, %v", inRepo, dirty)
+	}
+	os.WriteFile(file, []byte("# changed\n"), 0o644)
+	if _, dirty := WorkTree(ctx, dir); !dirty {
+		t.Errorf("WorkTree() with a modified file isn't dirty")
+	}
+
+	if err := Tag(ctx, dir, "deploy/prod/2024-05-30T14-22", "Plan: 1 to add"); err != nil {
+		t.Fatalf("Tag(): %v", err)
+	}
+	if out, _ := exec.Command("git", "-C", dir, "tag", "-n1", "-l", "deploy/*").Output(); !strings.Contains(string(out), "Plan: 1 to add") {
+		t.Errorf("tags = %q", out)
+	}
+	if err := Tag(ctx, dir, "deploy/prod/2024-05-30T14-22", "again"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Tag() of an existing tag: %v", err)
+	}
+}
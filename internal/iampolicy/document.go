@@ -0,0 +1,50 @@
+package iampolicy
+
+import "slices"
+
+// Version is the policy language version every document uses.
+const Version = "2012-10-17"
+
+// Statement is one statement of an IAM policy document.
+type Statement struct {
+	Sid       string                         `json:"Sid"`
+	Effect    string                         `json:"Effect"`
+	Action    []string                       `json:"Action"`
+	Resource  []string                       `json:"Resource"`
+	Condition map[string]map[string][]string `json:"Condition,omitempty"`
+}
+
+// Document is an IAM policy document, ready to marshal.
+type Document struct {
+	Version   string       `json:"Version"`
+	Statement []*Statement `json:"Statement"`
+}
+
+// NewDocument creates an empty document.
+func NewDocument() *Document {
+	return &Document{Version: Version, Statement: []*Statement{}}
+}
+
+// Allow adds an Allow statement, or adds the actions and resources to the
+// statement with the same Sid when there already is one.
+func (d *Document) Allow(sid string, actions []string, resources ...string) *Statement {
+	for _, s := range d.Statement {
+		if s.Sid == sid {
+			s.Action = appendMissing(s.Action, actions...)
+			s.Resource = appendMissing(s.Resource, resources...)
+			return s
+		}
+	}
+	s := &Statement{Sid: sid, Effect: "Allow", Action: appendMissing(nil, actions...), Resource: appendMissing(nil, resources...)}
+	d.Statement = append(d.Statement, s)
+	return s
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
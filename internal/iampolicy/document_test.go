@@ -0,0 +1,25 @@
+package iampolicy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDocument(t *testing.T) {
+	d := NewDocument()
+	d.Allow("ReadObjects", []string{"s3:GetObject"}, "arn:aws:s3:::tfvars/team/*")
+	d.Allow("ReadObjects", []string{"s3:GetObject", "s3:GetObjectVersion"}, "arn:aws:s3:::other/*", "arn:aws:s3:::tfvars/team/*")
+	list := d.Allow("ListBucket", []string{"s3:ListBucket"}, "arn:aws:s3:::tfvars")
+	list.Condition = map[string]map[string][]string{"StringLike": {"s3:prefix": {"team/*"}}}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Version":"2012-10-17","Statement":[` +
+		`{"Sid":"ReadObjects","Effect":"Allow","Action":["s3:GetObject","s3:GetObjectVersion"],"Resource":["arn:aws:s3:::tfvars/team/*","arn:aws:s3:::other/*"]},` +
+		`{"Sid":"ListBucket","Effect":"Allow","Action":["s3:ListBucket"],"Resource":["arn:aws:s3:::tfvars"],"Condition":{"StringLike":{"s3:prefix":["team/*"]}}}]}`
+	if string(data) != want {
+		t.Errorf("document =\n%s\nwant\n%s", data, want)
+	}
+}
@@ -0,0 +1,193 @@
+// Package iampolicy checks whether the caller's IAM identity allows the AWS
+// actions tfmanage needs, with the IAM policy simulator.
+package iampolicy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// ErrSimulationDenied is returned when the credentials aren't allowed to run
+// the policy simulator, so the permissions can't be checked that way.
+var ErrSimulationDenied = errors.New("not allowed to simulate IAM policies")
+
+// Check is one action the tool needs on one resource.
+type Check struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+}
+
+// Decisions the simulator gives back.
+const (
+	Allowed      = "allowed"
+	ExplicitDeny = "explicitDeny"
+	ImplicitDeny = "implicitDeny"
+)
+
+// Result is the simulator's decision for a check.
+type Result struct {
+	Check
+	Decision string `json:"decision"`
+}
+
+// Allowed is true when the action is allowed on the resource.
+func (r Result) Allowed() bool {
+	return r.Decision == Allowed
+}
+
+// PrincipalARN turns the caller ARN STS gives back into the IAM user or role
+// the simulator evaluates. An assumed role session becomes its role, without
+// the role's path, which the session ARN doesn't have.
+func PrincipalARN(caller string) (string, error) {
+	parts := strings.SplitN(caller, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return "", fmt.Errorf("%q is not an ARN", caller)
+	}
+	partition, service, account, resource := parts[1], parts[2], parts[4], parts[5]
+	switch {
+	case service == "iam":
+		return caller, nil
+	case service == "sts" && strings.HasPrefix(resource, "assumed-role/"):
+		role, _, _ := strings.Cut(strings.TrimPrefix(resource, "assumed-role/"), "/")
+		return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, account, role), nil
+	}
+	return "", fmt.Errorf("the policy simulator can't evaluate %s, only IAM users and roles", caller)
+}
+
+// AccountID is the account in an ARN.
+func AccountID(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// Client calls iam:SimulatePrincipalPolicy directly, signed with the
+// credentials in Config.
+type Client struct {
+	Config aws.Config
+	// Endpoint overrides https://iam.amazonaws.com.
+	Endpoint string
+}
+
+// NewClient creates a client with the credentials in cfg.
+func NewClient(cfg aws.Config) *Client {
+	return &Client{Config: cfg}
+}
+
+type simulateResponse struct {
+	Results []struct {
+		Action   string `xml:"EvalActionName"`
+		Resource string `xml:"EvalResourceName"`
+		Decision string `xml:"EvalDecision"`
+	} `xml:"SimulatePrincipalPolicyResult>EvaluationResults>member"`
+	IsTruncated bool   `xml:"SimulatePrincipalPolicyResult>IsTruncated"`
+	Marker      string `xml:"SimulatePrincipalPolicyResult>Marker"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// Simulate evaluates every check against the principal's policies. The
+// simulator evaluates every action against every resource of a call, so each
+// resource gets its own call with only its actions.
+func (c *Client) Simulate(ctx context.Context, principal string, checks []Check) ([]Result, error) {
+	var resources []string
+	actions := map[string][]string{}
+	for _, check := range checks {
+		if _, ok := actions[check.Resource]; !ok {
+			resources = append(resources, check.Resource)
+		}
+		actions[check.Resource] = append(actions[check.Resource], check.Action)
+	}
+
+	var results []Result
+	for _, resource := range resources {
+		form := url.Values{
+			"Action":                {"SimulatePrincipalPolicy"},
+			"Version":               {"2010-05-08"},
+			"PolicySourceArn":       {principal},
+			"ResourceArns.member.1": {resource},
+		}
+		for i, action := range actions[resource] {
+			form.Set("ActionNames.member."+strconv.Itoa(i+1), action)
+		}
+		for {
+			var out simulateResponse
+			if err := c.call(ctx, form, &out); err != nil {
+				return nil, err
+			}
+			for _, r := range out.Results {
+				results = append(results, Result{Check: Check{Action: r.Action, Resource: resource}, Decision: r.Decision})
+			}
+			if !out.IsTruncated {
+				break
+			}
+			form.Set("Marker", out.Marker)
+		}
+	}
+	return results, nil
+}
+
+func (c *Client) call(ctx context.Context, form url.Values, out any) error {
+	body := []byte(form.Encode())
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://iam.amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	creds, err := c.Config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	// IAM is global, its requests are always signed for us-east-1
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "iam", "us-east-1", time.Now()); err != nil {
+		return err
+	}
+
+	var client aws.HTTPClient = http.DefaultClient
+	if c.Config.HTTPClient != nil {
+		client = c.Config.HTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		xml.Unmarshal(data, &e)
+		if e.Code == "AccessDenied" {
+			return fmt.Errorf("%w: %s", ErrSimulationDenied, e.Message)
+		}
+		return fmt.Errorf("SimulatePrincipalPolicy: %s (%s, status %d)", e.Message, e.Code, resp.StatusCode)
+	}
+	return xml.Unmarshal(data, out)
+}
@@ -0,0 +1,94 @@
+package iampolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestPrincipalARN(t *testing.T) {
+	for _, tc := range []struct {
+		caller, want string
+		bad          bool
+	}{
+		{"arn:aws:sts::123456789012:assumed-role/deploy/ci-session", "arn:aws:iam::123456789012:role/deploy", false},
+		{"arn:aws:iam::123456789012:user/alice", "arn:aws:iam::123456789012:user/alice", false},
+		{"arn:aws-us-gov:sts::123456789012:assumed-role/deploy/x", "arn:aws-us-gov:iam::123456789012:role/deploy", false},
+		{"arn:aws:sts::123456789012:federated-user/bob", "", true},
+		{"not-an-arn", "", true},
+	} {
+		got, err := PrincipalARN(tc.caller)
+		if got != tc.want || (err != nil) != tc.bad {
+			t.Errorf("PrincipalARN(%q) = %q, %v", tc.caller, got, err)
+		}
+	}
+	if got := AccountID("arn:aws:iam::123456789012:role/deploy"); got != "123456789012" {
+		t.Errorf("AccountID() = %q", got)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	calls := 0
+	denied := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		r.ParseForm()
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") || !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/iam/") {
+			t.Errorf("request is not signed for IAM: %v", r.Header)
+		}
+		if denied {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>not authorized to perform iam:SimulatePrincipalPolicy</Message></Error></ErrorResponse>`)
+			return
+		}
+		if r.Form.Get("PolicySourceArn") != "arn:aws:iam::123456789012:role/deploy" || r.Form.Get("ResourceArns.member.2") != "" {
+			t.Errorf("form = %v, want the principal and one resource", r.Form)
+		}
+		fmt.Fprint(w, `<SimulatePrincipalPolicyResponse><SimulatePrincipalPolicyResult><IsTruncated>false</IsTruncated><EvaluationResults>`)
+		for i := 1; r.Form.Get(fmt.Sprintf("ActionNames.member.%d", i)) != ""; i++ {
+			action := r.Form.Get(fmt.Sprintf("ActionNames.member.%d", i))
+			decision := "allowed"
+			if action == "s3:PutObject" {
+				decision = "implicitDeny"
+			}
+			fmt.Fprintf(w, `<member><EvalActionName>%s</EvalActionName><EvalResourceName>%s</EvalResourceName><EvalDecision>%s</EvalDecision></member>`, action, r.Form.Get("ResourceArns.member.1"), decision)
+		}
+		fmt.Fprint(w, `</EvaluationResults></SimulatePrincipalPolicyResult></SimulatePrincipalPolicyResponse>`)
+	}))
+	defer server.Close()
+
+	client := &Client{
+		Config: aws.Config{Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		})},
+		Endpoint: server.URL,
+	}
+	checks := []Check{
+		{"s3:ListBucket", "arn:aws:s3:::tfvars"},
+		{"s3:GetObject", "arn:aws:s3:::tfvars/team/dev.tfvars"},
+		{"s3:PutObject", "arn:aws:s3:::tfvars/team/dev.tfvars"},
+	}
+	results, err := client.Simulate(context.Background(), "arn:aws:iam::123456789012:role/deploy", checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || len(results) != 3 {
+		t.Fatalf("%d calls gave %+v, want one call per resource and a result per check", calls, results)
+	}
+	for _, r := range results {
+		if r.Allowed() == (r.Action == "s3:PutObject") {
+			t.Errorf("result %+v", r)
+		}
+	}
+
+	denied = true
+	if _, err := client.Simulate(context.Background(), "arn:aws:iam::123456789012:role/deploy", checks); !errors.Is(err, ErrSimulationDenied) {
+		t.Errorf("Simulate() without permission = %v, want ErrSimulationDenied", err)
+	}
+}
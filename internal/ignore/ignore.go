@@ -0,0 +1,232 @@
+// Package ignore matches paths against the patterns of a .tfmanageignore
+// file, which follow the rules of .gitignore: blank lines and lines starting
+// with # are skipped, a leading ! includes again what an earlier pattern left
+// out, a trailing / only matches directories, a / at the start or in the
+// middle anchors the pattern to the root and ** matches any number of
+// directories. The last pattern that matches a path decides, and nothing
+// inside an ignored directory can be included again.
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the ignore file read from the root of a directory.
+const FileName = ".tfmanageignore"
+
+// Defaults are always ignored unless a pattern of the file includes them
+// again: terraform's and git's own directories, backups and the temp files
+// of editors.
+var Defaults = []string{
+	".terraform/",
+	".git/",
+	"*.backup",
+	"*.swp",
+	"*.swo",
+	"*~",
+	".#*",
+	`\#*#`,
+	".DS_Store",
+}
+
+// Pattern is one line of an ignore file.
+type Pattern struct {
+	// Source is where the pattern comes from, a file and line or "default".
+	Source string
+	// Text is the line as it is written.
+	Text string
+
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+func (p Pattern) String() string {
+	return fmt.Sprintf("%s: %s", p.Source, p.Text)
+}
+
+// Matcher holds the patterns in the order they apply. The nil Matcher
+// ignores nothing.
+type Matcher struct {
+	patterns []Pattern
+}
+
+// Compile parses pattern, which came from source.
+func Compile(pattern, source string) (Pattern, bool, error) {
+	text := strings.TrimSuffix(pattern, "\r")
+	p := Pattern{Source: source, Text: text}
+	line := trimTrailingSpaces(text)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Pattern{}, false, nil
+	}
+	if strings.HasPrefix(line, "!") {
+		p.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly, line = true, strings.TrimSuffix(line, "/")
+	}
+	// a slash anywhere but at the end ties the pattern to the root
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return Pattern{}, false, fmt.Errorf("%s: %q matches nothing", source, text)
+	}
+	expr := translate(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return Pattern{}, false, fmt.Errorf("%s: bad pattern %q", source, text)
+	}
+	p.re = re
+	return p, true, nil
+}
+
+// trimTrailingSpaces drops the spaces at the end of a line that aren't
+// escaped with a backslash
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// translate turns a glob into a regular expression, * and ? never match a /
+// and ** only means any number of directories next to a /
+func translate(glob string) string {
+	var out strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			out.WriteString("(?:.*/)?")
+			i += 2
+		case glob[i:] == "**" && i > 0 && glob[i-1] == '/':
+			out.WriteString(".*")
+			i++
+		case c == '*':
+			out.WriteString("[^/]*")
+			for i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+			}
+		case c == '?':
+			out.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			out.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				// an unclosed bracket leaves the expression invalid, Compile reports it
+				out.WriteString("[")
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			out.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			out.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return out.String()
+}
+
+// Parse reads the patterns of an ignore file, name is used in their Source.
+func Parse(r io.Reader, name string) ([]Pattern, error) {
+	var patterns []Pattern
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		p, ok, err := Compile(scanner.Text(), fmt.Sprintf("%s:%d", name, n))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// New makes a Matcher of the Defaults, unless withDefaults is false, followed
+// by patterns.
+func New(withDefaults bool, patterns ...Pattern) *Matcher {
+	m := &Matcher{}
+	if withDefaults {
+		for _, d := range Defaults {
+			if p, ok, err := Compile(d, "default"); ok && err == nil {
+				m.patterns = append(m.patterns, p)
+			}
+		}
+	}
+	m.patterns = append(m.patterns, patterns...)
+	return m
+}
+
+// Load makes the Matcher of the Defaults and the ignore file in dir, when
+// there is one.
+func Load(dir string) (*Matcher, error) {
+	path := filepath.Join(dir, FileName)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return New(true), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	patterns, err := Parse(f, path)
+	if err != nil {
+		return nil, err
+	}
+	return New(true, patterns...), nil
+}
+
+// Match says whether the slash separated path rel, relative to the root the
+// patterns are for, is ignored and by which pattern. A path that isn't
+// ignored may still come with the ! pattern that included it.
+func (m *Matcher) Match(rel string, isDir bool) (Pattern, bool) {
+	if m == nil {
+		return Pattern{}, false
+	}
+	rel = strings.Trim(rel, "/")
+	for i := strings.IndexByte(rel, '/'); i >= 0; {
+		if p, ignored := m.match(rel[:i], true); ignored {
+			return p, true
+		}
+		next := strings.IndexByte(rel[i+1:], '/')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return m.match(rel, isDir)
+}
+
+func (m *Matcher) match(rel string, isDir bool) (Pattern, bool) {
+	var last *Pattern
+	for i := range m.patterns {
+		p := &m.patterns[i]
+		if (p.dirOnly && !isDir) || !p.re.MatchString(rel) {
+			continue
+		}
+		last = p
+	}
+	if last == nil {
+		return Pattern{}, false
+	}
+	return *last, !last.negate
+}
@@ -0,0 +1,139 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns string
+		path     string
+		dir      bool
+		want     bool
+	}{
+		{"name anywhere", "*.tf", "modules/net/main.tf", false, true},
+		{"name at the root", "*.tf", "main.tf", false, true},
+		{"no match", "*.tf", "main.tfvars", false, false},
+		{"star stops at slashes", "modules/*.tf", "modules/net/main.tf", false, false},
+		{"question mark", "?.tf", "a.tf", false, true},
+		{"character class", "[ab].tf", "b.tf", false, true},
+		{"negated class", "[!ab].tf", "b.tf", false, false},
+		{"directory suffix matches a directory", "examples/", "examples", true, true},
+		{"directory suffix skips files", "examples/", "examples", false, false},
+		{"directory suffix covers its files", "examples/", "examples/demo/main.tf", false, true},
+		{"directory suffix at any depth", "scratch/", "modules/net/scratch/a.tf", false, true},
+		{"leading slash anchors", "/main.tf", "modules/main.tf", false, false},
+		{"leading slash at the root", "/main.tf", "main.tf", false, true},
+		{"middle slash anchors", "modules/*/scratch.tf", "modules/net/scratch.tf", false, true},
+		{"middle slash not at the root", "net/scratch.tf", "modules/net/scratch.tf", false, false},
+		{"leading double star", "**/scratch.tf", "a/b/scratch.tf", false, true},
+		{"leading double star at the root", "**/scratch.tf", "scratch.tf", false, true},
+		{"trailing double star", "modules/**", "modules/net/main.tf", false, true},
+		{"middle double star", "modules/**/main.tf", "modules/a/b/main.tf", false, true},
+		{"middle double star no directories", "modules/**/main.tf", "modules/main.tf", false, true},
+		{"negation", "*.tf\n!main.tf", "main.tf", false, false},
+		{"last pattern wins", "!main.tf\n*.tf", "main.tf", false, true},
+		{"no including inside an ignored directory", "examples/\n!examples/keep.tf", "examples/keep.tf", false, true},
+		{"including inside an ignored directory's files", "examples/*\n!examples/keep.tf", "examples/keep.tf", false, false},
+		{"comment", "# main.tf", "# main.tf", false, false},
+		{"escaped hash", `\#notes`, "#notes", false, true},
+		{"escaped bang", `\!important`, "!important", false, true},
+		{"trailing spaces", "main.tf   ", "main.tf", false, true},
+		{"escaped trailing space", `main.tf\ `, "main.tf ", false, true},
+		{"dots are literal", "a.tf", "abtf", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns, err := Parse(strings.NewReader(tt.patterns), FileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, got := New(false, patterns...).Match(tt.path, tt.dir); got != tt.want {
+				t.Errorf("Match(%q, %v) with %q = %v, want %v", tt.path, tt.dir, tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	tests := []struct {
+		path string
+		dir  bool
+		want bool
+	}{
+		{".terraform", true, true},
+		{".terraform/modules/net/main.tf", false, true},
+		{"modules/net/.terraform", true, true},
+		{".git", true, true},
+		{"prod.tfvars.20240501T120000Z.backup", false, true},
+		{".main.tf.swp", false, true},
+		{".main.tf.swo", false, true},
+		{"main.tf~", false, true},
+		{".#main.tf", false, true},
+		{"#main.tf#", false, true},
+		{".DS_Store", false, true},
+		{"main.tf", false, false},
+		{".terraform.lock.hcl", false, false},
+		{".terraform", false, false},
+	}
+	m := New(true)
+	for _, tt := range tests {
+		if _, got := m.Match(tt.path, tt.dir); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.path, tt.dir, got, tt.want)
+		}
+	}
+	if _, got := New(false).Match(".terraform", true); got {
+		t.Error("a Matcher without the defaults ignored .terraform")
+	}
+	if _, got := (*Matcher)(nil).Match("main.tf", false); got {
+		t.Error("the nil Matcher ignored a file")
+	}
+}
+
+func TestMatchReportsPattern(t *testing.T) {
+	patterns, err := Parse(strings.NewReader("# scratch files\n*.tf\n!main.tf\n"), "x/.tfmanageignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(true, patterns...)
+	if p, ignored := m.Match("extra.tf", false); !ignored || p.String() != "x/.tfmanageignore:2: *.tf" {
+		t.Errorf("Match(extra.tf) = %q, %v", p, ignored)
+	}
+	if p, ignored := m.Match("main.tf", false); ignored || p.Text != "!main.tf" {
+		t.Errorf("Match(main.tf) = %q, %v, want it included by !main.tf", p, ignored)
+	}
+	if p, ignored := m.Match(".git/config", false); !ignored || p.String() != "default: .git/" {
+		t.Errorf("Match(.git/config) = %q, %v", p, ignored)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{"[z-a", "/", "!"} {
+		if _, err := Parse(strings.NewReader("ok.tf\n"+line+"\n"), FileName); err == nil || !strings.Contains(err.Error(), FileName+":2") {
+			t.Errorf("Parse(%q) = %v, want an error naming line 2", line, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ignored := m.Match(".terraform", true); !ignored {
+		t.Error("Load() without a file left out the defaults")
+	}
+	os.WriteFile(filepath.Join(dir, FileName), []byte("!.terraform/\n"), 0o644)
+	m, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ignored := m.Match(".terraform", true); ignored {
+		t.Error("a pattern in the file didn't override a default")
+	}
+}
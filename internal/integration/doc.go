@@ -0,0 +1,6 @@
+// Package integration holds tests that run the storage code against a real
+// S3 API provided by LocalStack. They need Docker and are behind the
+// integration build tag:
+//
+//	go test -tags integration ./internal/integration/...
+package integration
@@ -0,0 +1,253 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage/storagetest"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/localstack"
+)
+
+const (
+	localstackImage = "localstack/localstack:3.8"
+	bucket          = "tfmanage-integration"
+)
+
+// client is shared by every test, it points at the LocalStack container started in TestMain
+var client *s3.Client
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+// runTests is split out of TestMain so the container is terminated by the defer even when tests fail
+func runTests(m *testing.M) int {
+	ctx := context.Background()
+
+	ctr, err := localstack.Run(ctx, localstackImage)
+	defer func() {
+		if err := testcontainers.TerminateContainer(ctr); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to terminate localstack: %v\n", err)
+		}
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start localstack: %v\n", err)
+		return 1
+	}
+
+	host, err := ctr.Host(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get localstack host: %v\n", err)
+		return 1
+	}
+	port, err := ctr.MappedPort(ctx, "4566/tcp")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get localstack port: %v\n", err)
+		return 1
+	}
+
+	cfg, err := awsconfig.Load(ctx, awsconfig.Env{AccessKeyID: "test", SecretAccessKey: "test", Region: "us-east-1"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return 1
+	}
+	client = storage.NewS3Client(cfg, storage.S3ClientOptions{
+		Endpoint:     fmt.Sprintf("http://%s:%s", host, port.Port()),
+		UsePathStyle: true,
+	})
+
+	if err := createVersionedBucket(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create bucket: %v\n", err)
+		return 1
+	}
+	return m.Run()
+}
+
+func createVersionedBucket(ctx context.Context) error {
+	if _, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		return err
+	}
+	_, err := client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusEnabled},
+	})
+	return err
+}
+
+// inTempDir runs the test from a fresh directory since the file name is part of the key
+func inTempDir(t *testing.T) {
+	t.Helper()
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	inTempDir(t)
+	ctx := testContext(t)
+	store := storage.NewS3Store(client, bucket)
+	content := []byte("region = \"us-east-1\"\n")
+	if err := os.WriteFile("dev.tfvars", content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := storage.Upload(ctx, store, "roundtrip/", "dev.tfvars", storage.UploadOptions{})
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	info, err := store.Head(ctx, res.Key)
+	if err != nil {
+		t.Fatalf("Head() error = %v", err)
+	}
+	if info.Metadata[storage.ChecksumMetadataKey] != res.Checksum {
+		t.Errorf("checksum metadata = %q, want %q", info.Metadata[storage.ChecksumMetadataKey], res.Checksum)
+	}
+
+	os.Remove("dev.tfvars")
+	if _, err := storage.Download(ctx, store, "roundtrip/", "dev.tfvars"); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	got, err := os.ReadFile("dev.tfvars")
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("downloaded %q, %v", got, err)
+	}
+}
+
+func TestUploadSkipsUnchanged(t *testing.T) {
+	inTempDir(t)
+	ctx := testContext(t)
+	store := storage.NewS3Store(client, bucket)
+	if err := os.WriteFile("staging.tfvars", []byte("a = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := storage.Upload(ctx, store, "skip/", "staging.tfvars", storage.UploadOptions{SkipUnchanged: true})
+	if err != nil || first.Skipped {
+		t.Fatalf("first Upload() = %+v, %v", first, err)
+	}
+	second, err := storage.Upload(ctx, store, "skip/", "staging.tfvars", storage.UploadOptions{SkipUnchanged: true})
+	if err != nil {
+		t.Fatalf("second Upload() error = %v", err)
+	}
+	if !second.Skipped {
+		t.Error("unchanged file was uploaded again")
+	}
+
+	versions, err := client.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String("skip/staging.tfvars"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions.Versions) != 1 {
+		t.Errorf("versions = %d, want 1", len(versions.Versions))
+	}
+}
+
+// TestMultipartKeepsMetadata checks the checksum metadata survives the multipart path the s3 manager takes for big files
+func TestMultipartKeepsMetadata(t *testing.T) {
+	inTempDir(t)
+	ctx := testContext(t)
+	store := storage.NewS3Store(client, bucket)
+
+	content := make([]byte, 12<<20)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("big.tfvars", content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := storage.Upload(ctx, store, "multipart/", "big.tfvars", storage.UploadOptions{})
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	info, err := store.Head(ctx, res.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Metadata[storage.ChecksumMetadataKey] != res.Checksum {
+		t.Errorf("multipart upload lost the checksum metadata: %v", info.Metadata)
+	}
+
+	out := filepath.Join(t.TempDir(), "big.tfvars")
+	f, err := os.Create(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n, err := store.Get(ctx, storage.GetInput{Key: res.Key}, f)
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("Get() = %d, %v", n, err)
+	}
+	sum, err := storage.FileChecksum(out)
+	if err != nil || sum != res.Checksum {
+		t.Errorf("downloaded checksum = %q, want %q (%v)", sum, res.Checksum, err)
+	}
+}
+
+func TestVersionsAreKeptPerUpload(t *testing.T) {
+	inTempDir(t)
+	ctx := testContext(t)
+	store := storage.NewS3Store(client, bucket)
+
+	for i := range 3 {
+		if err := os.WriteFile("prod.tfvars", []byte(fmt.Sprintf("revision = %d\n", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.Upload(ctx, store, "versions/", "prod.tfvars", storage.UploadOptions{}); err != nil {
+			t.Fatalf("Upload() #%d error = %v", i, err)
+		}
+	}
+
+	versions, err := client.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String("versions/prod.tfvars"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions.Versions) != 3 {
+		t.Errorf("versions = %d, want 3", len(versions.Versions))
+	}
+}
+
+func TestHeadMissingObject(t *testing.T) {
+	store := storage.NewS3Store(client, bucket)
+	_, err := store.Head(testContext(t), "does/not/exist.tfvars")
+	if !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("Head() error = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestS3Conformance(t *testing.T) {
+	prefix := fmt.Sprintf("conformance/%d/", time.Now().UnixNano())
+	storagetest.Run(t, prefix, func(*testing.T) storage.Backend { return storage.NewS3Store(client, bucket) })
+}
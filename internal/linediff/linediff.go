@@ -0,0 +1,168 @@
+// Package linediff compares two texts line by line and writes the result as
+// a unified diff, the format git diff and diff -u print. It is meant for
+// small files such as tfvars, so it finds the shortest diff with a plain
+// longest common subsequence and gives up on that for very long changes.
+package linediff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxCells is the biggest table the longest common subsequence is worked out
+// in, longer changes are shown as every old line removed and every new one
+// added, which is still a correct diff
+const maxCells = 4 << 20
+
+type op struct {
+	kind byte
+	text string
+}
+
+// Unified gives back the unified diff from a to b with context unchanged
+// lines around each change, empty when they have the same lines. fromName
+// and toName are the names in the --- and +++ header lines.
+func Unified(fromName, toName string, a, b []byte, context int) string {
+	ops := diff(lines(a), lines(b))
+	var out strings.Builder
+	aLine, bLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			aLine, bLine = aLine+1, bLine+1
+			i++
+			continue
+		}
+		// the hunk starts context lines before the change and takes in every
+		// change that is at most twice the context lines further on
+		start := max(0, i-context)
+		end := i
+		for j := i; j < len(ops); {
+			if ops[j].kind != ' ' {
+				j++
+				end = j
+				continue
+			}
+			k := j
+			for k < len(ops) && ops[k].kind == ' ' {
+				k++
+			}
+			if k == len(ops) || k-j > 2*context {
+				break
+			}
+			j = k
+		}
+		stop := min(len(ops), end+context)
+
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		aCount, bCount := 0, 0
+		for _, o := range ops[start:stop] {
+			if o.kind != '+' {
+				aCount++
+			}
+			if o.kind != '-' {
+				bCount++
+			}
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, o := range ops[start:stop] {
+			out.WriteByte(o.kind)
+			out.WriteString(o.text)
+			out.WriteByte('\n')
+		}
+		aLine, bLine = aStart+aCount, bStart+bCount
+		i = stop
+	}
+	return out.String()
+}
+
+// hunkRange is the start,count of a hunk header, an empty range starts at the
+// line before it the way diff -u writes it
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func lines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// diff gives back the lines of a and b as unchanged, removed and added
+func diff(a, b []string) []op {
+	// the lines both start and end with are left out of the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var ops []op
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+	ops = append(ops, middle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+	return ops
+}
+
+func middle(a, b []string) []op {
+	var ops []op
+	if len(a)*len(b) > maxCells {
+		for _, line := range a {
+			ops = append(ops, op{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, op{'+', line})
+		}
+		return ops
+	}
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, op{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', b[j]})
+	}
+	return ops
+}
@@ -0,0 +1,60 @@
+package linediff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	before := "region = \"us-east-1\"\ninstance_type = \"t3.small\"\nreplicas = 2\n"
+	after := "region = \"us-east-1\"\ninstance_type = \"t3.large\"\nreplicas = 2\nmonitoring = true\n"
+	want := `--- a/prod.tfvars
++++ b/prod.tfvars
+@@ -1,3 +1,4 @@
+ region = "us-east-1"
+-instance_type = "t3.small"
++instance_type = "t3.large"
+ replicas = 2
++monitoring = true
+`
+	if got := Unified("a/prod.tfvars", "b/prod.tfvars", []byte(before), []byte(after), 3); got != want {
+		t.Errorf("Unified() =\n%s\nwant\n%s", got, want)
+	}
+	if got := Unified("a", "b", []byte(before), []byte(before), 3); got != "" {
+		t.Errorf("Unified() of the same text = %q, want nothing", got)
+	}
+}
+
+func TestUnifiedHunks(t *testing.T) {
+	var a, b []string
+	for i := range 20 {
+		a = append(a, fmt.Sprintf("line%d", i))
+		b = append(b, fmt.Sprintf("line%d", i))
+	}
+	b[1], b[17] = "changed1", "changed17"
+	got := Unified("a", "b", []byte(strings.Join(a, "\n")+"\n"), []byte(strings.Join(b, "\n")+"\n"), 1)
+	want := `--- a
++++ b
+@@ -1,3 +1,3 @@
+ line0
+-line1
++changed1
+ line2
+@@ -17,3 +17,3 @@
+ line16
+-line17
++changed17
+ line18
+`
+	if got != want {
+		t.Errorf("Unified() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnifiedFromNothing(t *testing.T) {
+	want := "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x = 1\n+y = 2\n"
+	if got := Unified("a", "b", nil, []byte("x = 1\ny = 2\n"), 3); got != want {
+		t.Errorf("Unified() = %q, want %q", got, want)
+	}
+}
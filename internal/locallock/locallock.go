@@ -0,0 +1,113 @@
+// Package locallock keeps two tfmanage processes on the same machine from
+// working on the same environment at once. The lock is an OS advisory lock
+// on a file, flock on Unix and LockFileEx on Windows, so it goes away with
+// the process however it ends. The file also records who holds it, which is
+// how a lock left behind by a crashed process is recognised.
+package locallock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLocked is returned when another process holds the lock.
+var ErrLocked = errors.New("locked by another process")
+
+// Holder is what the lock file says about the process holding it.
+type Holder struct {
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("process %d running %q since %s", h.PID, h.Command, h.Since.Local().Format(time.DateTime))
+}
+
+// Lock is a held lock.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes the lock at path without waiting and records holder in it.
+// When the file names a process that is gone, the lock is reclaimed and that
+// process is given back as stale.
+func Acquire(path string, holder Holder) (*Lock, *Holder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	previous, _ := readHolder(f)
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, errWouldBlock) {
+			return nil, nil, lockedError(path, previous)
+		}
+		return nil, nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	var stale *Holder
+	if previous.PID != 0 && previous.PID != os.Getpid() {
+		// without an OS lock the recorded process is all there is to go on
+		if !osLocking && processAlive(previous.PID) {
+			unlockFile(f)
+			f.Close()
+			return nil, nil, lockedError(path, previous)
+		}
+		stale = &previous
+	}
+
+	data, err := json.Marshal(holder)
+	if err == nil {
+		if err = f.Truncate(0); err == nil {
+			_, err = f.WriteAt(data, 0)
+		}
+	}
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return &Lock{file: f}, stale, nil
+}
+
+// Release empties the lock file and unlocks it. The file itself stays, so a
+// process that opened it in the meantime never locks a file nobody else can
+// find.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	truncErr := l.file.Truncate(0)
+	unlockErr := unlockFile(l.file)
+	closeErr := l.file.Close()
+	l.file = nil
+	return errors.Join(truncErr, unlockErr, closeErr)
+}
+
+func readHolder(f *os.File) (Holder, error) {
+	var h Holder
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return h, err
+	}
+	data := make([]byte, info.Size())
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return h, err
+	}
+	return h, json.Unmarshal(data, &h)
+}
+
+func lockedError(path string, holder Holder) error {
+	if holder.PID == 0 {
+		return fmt.Errorf("%w (%s)", ErrLocked, path)
+	}
+	return fmt.Errorf("%w: %s (%s)", ErrLocked, holder, path)
+}
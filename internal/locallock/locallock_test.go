@@ -0,0 +1,58 @@
+package locallock
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "prod.lock")
+	holder := Holder{PID: os.Getpid(), Command: "apply prod", Since: time.Now()}
+	lock, stale, err := Acquire(path, holder)
+	if err != nil || stale != nil {
+		t.Fatalf("Acquire() = %v, %v", stale, err)
+	}
+
+	// a second open of the file is another lock holder, even in the same process
+	_, _, err = Acquire(path, Holder{PID: os.Getpid(), Command: "upload prod"})
+	if !errors.Is(err, ErrLocked) || !strings.Contains(err.Error(), `"apply prod"`) {
+		t.Errorf("second Acquire() = %v, want ErrLocked naming the holder", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release() = %v", err)
+	}
+	if data, _ := os.ReadFile(path); len(data) != 0 {
+		t.Errorf("the lock file still says %q after Release", data)
+	}
+	again, stale, err := Acquire(path, holder)
+	if err != nil || stale != nil {
+		t.Fatalf("Acquire() after Release = %v, %v", stale, err)
+	}
+	again.Release()
+}
+
+func TestAcquireReclaimsStaleLock(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("can't start a process to outlive: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "dev.lock")
+	data, _ := json.Marshal(Holder{PID: cmd.Process.Pid, Command: "apply dev"})
+	os.WriteFile(path, data, 0o644)
+
+	lock, stale, err := Acquire(path, Holder{PID: os.Getpid(), Command: "upload dev"})
+	if err != nil {
+		t.Fatalf("Acquire() of a crashed process's lock = %v", err)
+	}
+	defer lock.Release()
+	if stale == nil || stale.PID != cmd.Process.Pid {
+		t.Errorf("stale = %+v, want the process that is gone", stale)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package locallock
+
+import (
+	"errors"
+	"os"
+)
+
+// there is no advisory lock to use here, the recorded process is checked instead
+const osLocking = false
+
+var errWouldBlock = errors.New("would block")
+
+func lockFile(f *os.File) error   { return nil }
+func unlockFile(f *os.File) error { return nil }
+
+// processAlive can't be checked, so a recorded process is taken to be running
+func processAlive(pid int) bool {
+	return pid != 0
+}
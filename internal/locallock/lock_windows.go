@@ -0,0 +1,37 @@
+//go:build windows
+
+package locallock
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+const osLocking = true
+
+var errWouldBlock = windows.ERROR_LOCK_VIOLATION
+
+// lockedRange is the byte locked, far past the holder record so other
+// processes can still read who holds the lock
+var lockedRange = windows.Overlapped{OffsetHigh: 1}
+
+func lockFile(f *os.File) error {
+	ol := lockedRange
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol)
+}
+
+func unlockFile(f *os.File) error {
+	ol := lockedRange
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol)
+}
+
+func processAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	return windows.GetExitCodeProcess(h, &code) == nil && code == 259 // STILL_ACTIVE
+}
@@ -0,0 +1,144 @@
+// Package metrics pushes gauges to a Prometheus Pushgateway. Every push
+// replaces the whole group of its grouping key, so a run overwrites the
+// numbers of the previous run of the same job, environment and operation
+// instead of piling up next to them.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PushgatewayURLEnv wins over the URL in the config file, which may carry
+// basic auth credentials that shouldn't be committed.
+const PushgatewayURLEnv = "TFMANAGE_PUSHGATEWAY_URL"
+
+// DefaultJob is the job label when none is configured.
+const DefaultJob = "tfmanage"
+
+// Gauge is one sample. Metrics of the same name are written together under
+// the first one's help text.
+type Gauge struct {
+	Name   string
+	Help   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Client is what Push sends with, a variable so tests can swap it.
+var Client = &http.Client{Timeout: 10 * time.Second}
+
+// Push replaces the group of job and grouping at the Pushgateway at base
+// with the gauges. The grouping labels are added to every gauge by the
+// Pushgateway, so the gauges don't need them.
+func Push(ctx context.Context, base, job string, grouping [][2]string, gauges []Gauge) error {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("invalid Pushgateway URL")
+	}
+	target := strings.TrimSuffix(u.String(), "/") + "/metrics/job/" + pathValue(job)
+	for _, label := range grouping {
+		target += "/" + label[0]
+		if v := pathValue(label[1]); strings.HasPrefix(v, "@base64/") {
+			target += v
+		} else {
+			target += "/" + v
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(Encode(gauges)))
+	if err != nil {
+		return errors.New("invalid Pushgateway URL")
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		// the url.Error would print the URL, user and password included
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("failed to push to %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		answer, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Pushgateway answered %s: %s", resp.Status, strings.TrimSpace(string(answer)))
+	}
+	return nil
+}
+
+// pathValue is a grouping value as a URL path segment, values the path
+// can't carry as they are use the base64 form the Pushgateway understands
+func pathValue(v string) string {
+	if v == "" || strings.Contains(v, "/") {
+		return "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(v))
+	}
+	return url.PathEscape(v)
+}
+
+// Encode writes the gauges in the Prometheus text format.
+func Encode(gauges []Gauge) []byte {
+	var names []string
+	byName := map[string][]Gauge{}
+	for _, g := range gauges {
+		if _, ok := byName[g.Name]; !ok {
+			names = append(names, g.Name)
+		}
+		byName[g.Name] = append(byName[g.Name], g)
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		samples := byName[name]
+		if samples[0].Help != "" {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(samples[0].Help))
+		}
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+		for _, g := range samples {
+			buf.WriteString(name)
+			if len(g.Labels) > 0 {
+				keys := make([]string, 0, len(g.Labels))
+				for k := range g.Labels {
+					keys = append(keys, k)
+				}
+				slices.Sort(keys)
+				pairs := make([]string, len(keys))
+				for i, k := range keys {
+					pairs[i] = k + `="` + escapeLabel(g.Labels[k]) + `"`
+				}
+				buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+			}
+			buf.WriteString(" " + formatValue(g.Value) + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,54 @@
+// Package notify posts alerts to a chat webhook. The payload has a "text"
+// field, which is all Slack and Teams incoming webhooks need, and whatever
+// structured details the caller adds next to it for other receivers.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WebhookURLEnv wins over the webhook URL in the config file, so the secret
+// part of the URL doesn't have to be committed.
+const WebhookURLEnv = "TFMANAGE_WEBHOOK_URL"
+
+// Message is one alert.
+type Message struct {
+	Text string `json:"text"`
+	// Details is added to the payload as it is.
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Client is what Send posts with, a variable so tests can swap it.
+var Client = &http.Client{Timeout: 15 * time.Second}
+
+// Send posts the message as JSON to url. Anything other than a 2xx answer is
+// an error, with the start of the response body in it.
+func Send(ctx context.Context, url string, m Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode the notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send the notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		answer, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook answered %s: %s", resp.Status, strings.TrimSpace(string(answer)))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("content type = %q", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	err := Send(context.Background(), srv.URL, Message{Text: "prod drifted", Details: map[string]any{"drifted": []string{"prod"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["text"] != "prod drifted" || got["details"] == nil {
+		t.Errorf("payload = %v", got)
+	}
+}
+
+func TestSendErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	err := Send(context.Background(), srv.URL, Message{Text: "x"})
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "invalid_token") {
+		t.Errorf("Send() = %v, want the status and body", err)
+	}
+}
@@ -0,0 +1,64 @@
+package plansummary
+
+import (
+	"slices"
+	"strings"
+)
+
+// Change is a resource whose planned action differs between two plans.
+// Before is empty when the resource only appears in the second plan and
+// After is empty when it only appears in the first.
+type Change struct {
+	Address string `json:"address"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+}
+
+// Actions maps every resource address in a plan to what the plan does with
+// it: create, update, delete, replace, read or no-op.
+func Actions(data []byte) (map[string]string, error) {
+	p, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	actions := make(map[string]string, len(p.ResourceChanges))
+	for _, rc := range p.ResourceChanges {
+		actions[rc.Address] = action(rc.Change.Actions)
+	}
+	return actions, nil
+}
+
+func action(actions []string) string {
+	if slices.Contains(actions, "create") && slices.Contains(actions, "delete") {
+		return "replace"
+	}
+	return strings.Join(actions, ",")
+}
+
+// Diff compares two plans on their addresses and actions only, so plans made
+// by different terraform versions can be compared. The changes are sorted by
+// address and empty when the plans are equivalent.
+func Diff(a, b []byte) ([]Change, error) {
+	before, err := Actions(a)
+	if err != nil {
+		return nil, err
+	}
+	after, err := Actions(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for addr, act := range before {
+		if after[addr] != act {
+			changes = append(changes, Change{Address: addr, Before: act, After: after[addr]})
+		}
+	}
+	for addr, act := range after {
+		if _, ok := before[addr]; !ok {
+			changes = append(changes, Change{Address: addr, After: act})
+		}
+	}
+	slices.SortFunc(changes, func(x, y Change) int { return strings.Compare(x.Address, y.Address) })
+	return changes, nil
+}
@@ -0,0 +1,41 @@
+package plansummary
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	// made by a newer terraform, which adds fields the diff doesn't look at
+	other := `{
+  "format_version": "1.2",
+  "terraform_version": "1.7.0",
+  "resource_changes": [
+    {"address": "aws_s3_bucket.logs", "change": {"actions": ["create"], "importing": null}},
+    {"address": "aws_instance.web", "change": {"actions": ["create", "delete"]}},
+    {"address": "aws_db_instance.main", "change": {"actions": ["delete", "create"]}},
+    {"address": "aws_vpc.main", "change": {"actions": ["no-op"]}},
+    {"address": "data.aws_ami.ubuntu", "change": {"actions": ["read"]}},
+    {"address": "aws_sqs_queue.jobs", "change": {"actions": ["create"]}}
+  ]
+}`
+	changes, err := Diff([]byte(planJSON), []byte(other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Address: "aws_iam_role.old", Before: "delete"},
+		{Address: "aws_instance.web", Before: "update", After: "replace"},
+		{Address: "aws_sqs_queue.jobs", After: "create"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Diff() = %+v, want %+v", changes, want)
+	}
+
+	if changes, err := Diff([]byte(planJSON), []byte(planJSON)); err != nil || len(changes) != 0 {
+		t.Errorf("Diff() of the same plan = %+v, %v", changes, err)
+	}
+	if _, err := Diff([]byte(planJSON), []byte(`{}`)); err == nil {
+		t.Error("Diff() with something that isn't a plan gave no error")
+	}
+}
@@ -0,0 +1,75 @@
+package plansummary
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Report is everything that goes into a rendered plan summary. Fields left
+// empty are left out of the output.
+type Report struct {
+	Environment string
+	Commit      string
+	Summary     Summary
+	Duration    time.Duration
+	// Cost is a one line description of the monthly cost change.
+	Cost string
+	// PlanText is the human readable plan, shown collapsed under the summary.
+	// It should not contain color codes.
+	PlanText string
+}
+
+// Markdown renders the report for places that show markdown, such as a
+// GitHub Actions step summary or a PR comment.
+func (r Report) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Plan for `%s`", r.Environment)
+	if r.Commit != "" {
+		fmt.Fprintf(&b, " at `%s`", r.Commit)
+	}
+	b.WriteString("\n\n")
+	if !r.Summary.HasChanges() {
+		b.WriteString("No changes.\n")
+		return b.String()
+	}
+
+	b.WriteString("| Add | Change | Destroy |\n|----:|-------:|--------:|\n")
+	fmt.Fprintf(&b, "| %d | %d | %d |\n", r.Summary.Add, r.Summary.Change, r.Summary.Destroy)
+	if len(r.Summary.Deleted) > 0 || len(r.Summary.Replaced) > 0 {
+		b.WriteString("\n**Destroyed resources**\n\n")
+		for _, addr := range r.Summary.Deleted {
+			fmt.Fprintf(&b, "- `%s`\n", addr)
+		}
+		for _, addr := range r.Summary.Replaced {
+			fmt.Fprintf(&b, "- `%s` (replaced)\n", addr)
+		}
+	}
+	if r.Cost != "" {
+		fmt.Fprintf(&b, "\n**Monthly cost:** %s\n", r.Cost)
+	}
+	if r.PlanText != "" {
+		fence := codeFence(r.PlanText)
+		fmt.Fprintf(&b, "\n<details><summary>Show plan</summary>\n\n%s\n%s\n%s\n\n</details>\n",
+			fence, strings.TrimRight(r.PlanText, "\n"), fence)
+	}
+	if r.Duration > 0 {
+		fmt.Fprintf(&b, "\nPlanned in %s.\n", r.Duration.Round(100*time.Millisecond))
+	}
+	return b.String()
+}
+
+// codeFence picks a fence longer than any run of backticks in the text so the
+// block can't be closed early
+func codeFence(text string) string {
+	longest, run := 0, 0
+	for _, c := range text {
+		if c == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
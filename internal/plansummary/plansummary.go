@@ -0,0 +1,92 @@
+// Package plansummary reads the JSON form of a saved plan (terraform show
+// -json) and boils it down to the counts and addresses people look at
+// before applying.
+package plansummary
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Summary is what a plan would do. Replaced resources count towards both
+// Add and Destroy, the same way terraform's own "Plan:" line counts them.
+type Summary struct {
+	Add     int `json:"add"`
+	Change  int `json:"change"`
+	Destroy int `json:"destroy"`
+	// Deleted and Replaced hold the addresses of resources that would be
+	// destroyed outright or destroyed and created again.
+	Deleted  []string `json:"deleted,omitempty"`
+	Replaced []string `json:"replaced,omitempty"`
+}
+
+// HasChanges reports whether applying the plan would change anything.
+func (s Summary) HasChanges() bool {
+	return s.Add+s.Change+s.Destroy > 0
+}
+
+// Destroyed returns every address the plan destroys, replaced ones included.
+func (s Summary) Destroyed() []string {
+	out := append(append([]string(nil), s.Deleted...), s.Replaced...)
+	slices.Sort(out)
+	return out
+}
+
+func (s Summary) String() string {
+	if !s.HasChanges() {
+		return "No changes."
+	}
+	return fmt.Sprintf("Plan: %d to add, %d to change, %d to destroy.", s.Add, s.Change, s.Destroy)
+}
+
+// plan is the part of terraform's JSON plan format the summary needs
+type plan struct {
+	FormatVersion   string `json:"format_version"`
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Change  struct {
+			Actions []string `json:"actions"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+func decode(data []byte) (plan, error) {
+	var p plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return plan{}, fmt.Errorf("failed to parse plan JSON: %w", err)
+	}
+	if p.FormatVersion == "" {
+		return plan{}, fmt.Errorf("failed to parse plan JSON: no format_version, is this the output of terraform show -json?")
+	}
+	return p, nil
+}
+
+// Parse builds a Summary from the output of terraform show -json <plan>.
+func Parse(data []byte) (Summary, error) {
+	p, err := decode(data)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	var s Summary
+	for _, rc := range p.ResourceChanges {
+		actions := rc.Change.Actions
+		switch {
+		case slices.Contains(actions, "create") && slices.Contains(actions, "delete"):
+			s.Add++
+			s.Destroy++
+			s.Replaced = append(s.Replaced, rc.Address)
+		case slices.Contains(actions, "create"):
+			s.Add++
+		case slices.Contains(actions, "update"):
+			s.Change++
+		case slices.Contains(actions, "delete"):
+			s.Destroy++
+			s.Deleted = append(s.Deleted, rc.Address)
+		}
+	}
+	slices.Sort(s.Deleted)
+	slices.Sort(s.Replaced)
+	return s, nil
+}
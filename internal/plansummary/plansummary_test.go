@@ -0,0 +1,90 @@
+package plansummary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const planJSON = `{
+  "format_version": "1.2",
+  "resource_changes": [
+    {"address": "aws_s3_bucket.logs", "change": {"actions": ["create"]}},
+    {"address": "aws_instance.web", "change": {"actions": ["update"]}},
+    {"address": "aws_iam_role.old", "change": {"actions": ["delete"]}},
+    {"address": "aws_db_instance.main", "change": {"actions": ["delete", "create"]}},
+    {"address": "aws_vpc.main", "change": {"actions": ["no-op"]}},
+    {"address": "data.aws_ami.ubuntu", "change": {"actions": ["read"]}}
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(planJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Summary{
+		Add: 2, Change: 1, Destroy: 2,
+		Deleted:  []string{"aws_iam_role.old"},
+		Replaced: []string{"aws_db_instance.main"},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("Parse() = %+v, want %+v", s, want)
+	}
+	if got := s.String(); got != "Plan: 2 to add, 1 to change, 2 to destroy." {
+		t.Errorf("String() = %q", got)
+	}
+	if got := s.Destroyed(); !reflect.DeepEqual(got, []string{"aws_db_instance.main", "aws_iam_role.old"}) {
+		t.Errorf("Destroyed() = %q", got)
+	}
+}
+
+func TestParseRejectsOtherJSON(t *testing.T) {
+	if _, err := Parse([]byte(`{"resources": []}`)); err == nil {
+		t.Error("expected an error for JSON without format_version")
+	}
+	if _, err := Parse([]byte(`Plan: 1 to add`)); err == nil {
+		t.Error("expected an error for plain text")
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	s, _ := Parse([]byte(planJSON))
+	md := Report{Environment: "prod", Summary: s, Duration: 83 * time.Second}.Markdown()
+	for _, want := range []string{
+		"### Plan for `prod`",
+		"| 2 | 1 | 2 |",
+		"- `aws_iam_role.old`",
+		"- `aws_db_instance.main` (replaced)",
+		"Planned in 1m23s.",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown is missing %q:\n%s", want, md)
+		}
+	}
+
+	empty := Report{Environment: "dev"}.Markdown()
+	if !strings.Contains(empty, "No changes.") || strings.Contains(empty, "| Add") {
+		t.Errorf("no-change markdown = %q", empty)
+	}
+}
+
+func TestMarkdownPlanText(t *testing.T) {
+	s, _ := Parse([]byte(planJSON))
+	md := Report{Environment: "prod", Commit: "abc1234", Summary: s, PlanText: "  + resource \"x\" {\n      user_data = \"```\"\n"}.Markdown()
+	for _, want := range []string{
+		"### Plan for `prod` at `abc1234`",
+		"<details><summary>Show plan</summary>\n\n````\n  + resource",
+		"\n````\n\n</details>",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown is missing %q:\n%s", want, md)
+		}
+	}
+
+	empty := Report{Environment: "dev", Commit: "abc1234", PlanText: "No changes."}.Markdown()
+	if empty != "### Plan for `dev` at `abc1234`\n\nNo changes.\n" {
+		t.Errorf("no-change markdown = %q", empty)
+	}
+}
@@ -0,0 +1,112 @@
+// Package redact masks secrets in terraform output before it reaches a
+// terminal or a CI log. It works line by line: attributes whose name looks
+// sensitive get their value replaced, and values that look like credentials
+// are masked wherever they appear. A Redactor masks given values, such as the
+// sensitive variables of a tfvars file, and a Writer masks output as it
+// streams, either way with the same Mask.
+package redact
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// Mask replaces every redacted value, whatever found it.
+const Mask = "(redacted)"
+
+// sensitiveWords are the parts of a name that say its value is a secret
+const sensitiveWords = `(?:password|passwd|secret|token|private_key|api_key|access_key|credential)`
+
+var (
+	// sensitiveAttribute matches name = value lines, such as the ones in
+	// terraform state show, where the name says the value is a secret
+	sensitiveAttribute = regexp.MustCompile(`(?i)^(\s*[-+~]?\s*"?[\w.-]*` + sensitiveWords + `[\w.-]*"?\s*[=:]\s*)(.+?)(\s*)$`)
+
+	sensitiveName = regexp.MustCompile(`(?i)` + sensitiveWords)
+
+	// values that are secrets whatever they are called
+	secretValues = []*regexp.Regexp{
+		regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+		regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	}
+
+	// values that are left alone because there is nothing in them to hide
+	harmless = regexp.MustCompile(`^(?:null|""|\(sensitive value\)|\(known after apply\)|true|false|\{|\[)$`)
+)
+
+// SensitiveName is true when an attribute's name says its value is a secret.
+func SensitiveName(name string) bool {
+	return sensitiveName.MatchString(name)
+}
+
+// SecretValue is true when a value looks like a credential, whatever it is
+// called.
+func SecretValue(value string) bool {
+	for _, re := range secretValues {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Line redacts one line of output.
+func Line(line string) string {
+	if m := sensitiveAttribute.FindStringSubmatchIndex(line); m != nil {
+		value := line[m[4]:m[5]]
+		if !harmless.MatchString(value) {
+			line = line[:m[4]] + Mask + line[m[5]:]
+		}
+	}
+	for _, re := range secretValues {
+		line = re.ReplaceAllString(line, Mask)
+	}
+	return line
+}
+
+// Writer redacts everything written through it before passing it on. Lines
+// are held back until they are complete, so a secret written in pieces is
+// still masked - call Flush when the output ends.
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	mask    func(line string) string
+	pending []byte
+}
+
+// NewWriter returns a Writer that writes output redacted by Line to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, mask: Line}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := w.mask(string(w.pending[:i])) + "\n"
+		w.pending = w.pending[i+1:]
+		if _, err := io.WriteString(w.w, line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes out a last line that did not end in a newline.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		return nil
+	}
+	line := w.mask(string(w.pending))
+	w.pending = nil
+	_, err := io.WriteString(w.w, line)
+	return err
+}
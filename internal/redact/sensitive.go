@@ -0,0 +1,164 @@
+package redact
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	variableBlock  = regexp.MustCompile(`(?m)^\s*variable\s+"([^"]+)"\s*\{`)
+	sensitiveTrue  = regexp.MustCompile(`(?m)^\s*sensitive\s*=\s*true\b`)
+	tfvarsAssign   = regexp.MustCompile(`^\s*([A-Za-z_][\w-]*)\s*=\s*(.*)$`)
+	heredocStart   = regexp.MustCompile(`^<<-?([A-Za-z_]\w*)\s*$`)
+	quotedStrings  = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+	trailingRemark = regexp.MustCompile(`\s*(?:#|//).*$`)
+)
+
+// SensitiveVariables gives back the names of the variables declared with
+// sensitive = true in the .tf files of dir. Modules aren't read, their
+// variables are set by the root module rather than the tfvars.
+func SensitiveVariables(dir string) ([]string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		text := string(data)
+		for _, m := range variableBlock.FindAllStringSubmatchIndex(text, -1) {
+			body := blockBody(text[m[1]:])
+			if sensitiveTrue.MatchString(body) && !slices.Contains(names, text[m[2]:m[3]]) {
+				names = append(names, text[m[2]:m[3]])
+			}
+		}
+	}
+	return names, nil
+}
+
+// blockBody is the text up to the brace closing a block, skipping braces in
+// strings and comments
+func blockBody(text string) string {
+	depth, inString := 1, false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '#' || (c == '/' && strings.HasPrefix(text[i:], "//")):
+			if end := strings.IndexByte(text[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				return text
+			}
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth--; depth == 0 {
+				return text[:i]
+			}
+		}
+	}
+	return text
+}
+
+// TFVarsValues gives back the values a tfvars file sets for the named
+// variables: strings, heredocs, bare numbers and booleans, and every string
+// inside a list or a map.
+func TFVarsValues(data []byte, names []string) []string {
+	var values []string
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		m := tfvarsAssign.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		wanted, rest := slices.Contains(names, m[1]), strings.TrimSpace(m[2])
+		switch {
+		case heredocStart.MatchString(rest):
+			marker := heredocStart.FindStringSubmatch(rest)[1]
+			var body []string
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != marker; i++ {
+				body = append(body, lines[i])
+			}
+			if wanted {
+				values = append(values, strings.Join(body, "\n"))
+			}
+		case strings.HasPrefix(rest, "{") || strings.HasPrefix(rest, "["):
+			collected := rest
+			for depth := bracketDepth(rest); depth > 0 && i+1 < len(lines); {
+				i++
+				collected += "\n" + lines[i]
+				depth += bracketDepth(lines[i])
+			}
+			if wanted {
+				for _, s := range quotedStrings.FindAllStringSubmatch(collected, -1) {
+					values = append(values, unquote(s[1]))
+				}
+			}
+		case !wanted:
+		case strings.HasPrefix(rest, `"`):
+			if s := quotedStrings.FindStringSubmatch(rest); s != nil {
+				values = append(values, unquote(s[1]))
+			}
+		default:
+			// true, false and null say nothing, and would match all over the output
+			if value := trailingRemark.ReplaceAllString(rest, ""); value != "true" && value != "false" && value != "null" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// bracketDepth is how many brackets a line opens and doesn't close, outside strings
+func bracketDepth(line string) int {
+	depth := 0
+	for _, c := range quotedStrings.ReplaceAllString(line, `""`) {
+		switch c {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+// unquote undoes the escapes in a string, they are close enough to Go's
+func unquote(s string) string {
+	if u, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		return u
+	}
+	return s
+}
+
+// ForTerraform creates a Redactor for the sensitive variables declared in
+// dir with the values varFile gives them. A missing varFile only leaves the
+// built-in patterns.
+func ForTerraform(dir, varFile string) (*Redactor, error) {
+	names, err := SensitiveVariables(dir)
+	if err != nil || len(names) == 0 || varFile == "" {
+		return NewRedactor(), err
+	}
+	data, err := os.ReadFile(varFile)
+	if os.IsNotExist(err) {
+		return NewRedactor(), nil
+	}
+	if err != nil {
+		return NewRedactor(), err
+	}
+	return NewRedactor(TFVarsValues(data, names)...), nil
+}
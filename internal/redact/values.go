@@ -0,0 +1,111 @@
+package redact
+
+import (
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// MinValueLength is the shortest value a Redactor masks, shorter ones would
+// match all over the output.
+const MinValueLength = 4
+
+// Redactor masks known secret values, such as the sensitive variables set in
+// a tfvars file, and values matching the built-in secret patterns. Values are
+// only masked where they are a whole token, so a value that happens to be
+// part of a longer word is left alone.
+type Redactor struct {
+	// values are longest first so a value inside another is never masked first
+	values []string
+	// attributes masks the values of attributes named like secrets too
+	attributes bool
+}
+
+// NewRedactor creates a Redactor for the values. Values over several lines
+// are masked line by line, and values shorter than MinValueLength are
+// ignored.
+func NewRedactor(values ...string) *Redactor {
+	r := &Redactor{}
+	r.Add(values...)
+	return r
+}
+
+// Add masks more values, the same way as the ones given to NewRedactor. It
+// must not be called while output is streaming through the Redactor.
+func (r *Redactor) Add(values ...string) {
+	for _, value := range values {
+		for _, line := range strings.Split(value, "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) >= MinValueLength && !slices.Contains(r.values, line) {
+				r.values = append(r.values, line)
+			}
+		}
+	}
+	sort.SliceStable(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+}
+
+// Len is the number of values masked besides the built-in patterns.
+func (r *Redactor) Len() int {
+	return len(r.values)
+}
+
+// WithAttributes gives back a Redactor for the same values that also masks
+// attributes named like secrets line by line, the way Line does, for output
+// such as terraform state show.
+func (r *Redactor) WithAttributes() *Redactor {
+	return &Redactor{values: r.values, attributes: true}
+}
+
+// Mask masks the values and the secret patterns in text, and the sensitive
+// attributes when the Redactor is WithAttributes.
+func (r *Redactor) Mask(text string) string {
+	if r.attributes {
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = Line(line)
+		}
+		text = strings.Join(lines, "\n")
+	}
+	for _, value := range r.values {
+		text = maskToken(text, value)
+	}
+	for _, re := range secretValues {
+		text = re.ReplaceAllString(text, Mask)
+	}
+	return text
+}
+
+// maskToken replaces value where it isn't joined to a letter, digit or
+// underscore on either side
+func maskToken(text, value string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(text, value)
+		if i < 0 {
+			break
+		}
+		end := i + len(value)
+		if (i == 0 || !isWordByte(text[i-1])) && (end == len(text) || !isWordByte(text[end])) {
+			b.WriteString(text[:i])
+			b.WriteString(Mask)
+		} else {
+			b.WriteString(text[:end])
+		}
+		text = text[end:]
+	}
+	if b.Len() == 0 {
+		return text
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// NewWriter returns a Writer that writes output masked by the Redactor to w.
+func (r *Redactor) NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, mask: r.Mask}
+}
@@ -0,0 +1,285 @@
+// Package selfupdate replaces the running binary with the latest GitHub
+// release. Nothing in it runs unless the self-update command asks for it.
+//
+// A release has one binary per platform, named with _<os>_<arch> at the end
+// (.exe on Windows), and a checksums.txt in the sha256sum format. Once
+// releases are signed, checksums.txt.sig is the base64 ed25519 signature of
+// checksums.txt and builds carry the public key in PublicKey.
+package selfupdate
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultRepo is where the releases are published.
+const DefaultRepo = "DrewDrabek/terraform-manage-script-AWS"
+
+// DefaultAPIURL is github.com's API.
+const DefaultAPIURL = "https://api.github.com"
+
+// The assets every platform's binary is checked against.
+const (
+	ChecksumsAsset = "checksums.txt"
+	SignatureAsset = "checksums.txt.sig"
+)
+
+// PublicKey is the base64 ed25519 key releases are signed with, set with
+// -ldflags -X. While it is empty signatures aren't checked; once it is set a
+// release without a valid signature is refused.
+var PublicKey string
+
+var (
+	// ErrNoAsset is returned when the release has no binary for the platform.
+	ErrNoAsset = errors.New("no release asset for this platform")
+	// ErrChecksum is returned when a binary doesn't match its published checksum,
+	// or has none.
+	ErrChecksum = errors.New("checksum mismatch")
+	// ErrSignature is returned when the checksums aren't signed by PublicKey.
+	ErrSignature = errors.New("invalid signature")
+	// ErrNotAVersion is returned for a version that can't be compared, such as
+	// a development build's.
+	ErrNotAVersion = errors.New("not a release version")
+)
+
+// Asset is one file of a release.
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+// Release is a published GitHub release.
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	URL    string  `json:"html_url"`
+	Assets []Asset `json:"assets"`
+}
+
+// Version is the tag without its v.
+func (r Release) Version() string {
+	return strings.TrimPrefix(r.Tag, "v")
+}
+
+// Asset finds the asset called name.
+func (r Release) Asset(name string) (Asset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Asset{}, false
+}
+
+// AssetFor finds the binary for goos and goarch.
+func (r Release) AssetFor(goos, goarch string) (Asset, error) {
+	suffix := "_" + goos + "_" + goarch
+	for _, a := range r.Assets {
+		name := a.Name
+		if goos == "windows" {
+			name = strings.TrimSuffix(name, ".exe")
+		}
+		if strings.HasSuffix(name, suffix) {
+			return a, nil
+		}
+	}
+	return Asset{}, fmt.Errorf("%w: %s has nothing for %s/%s", ErrNoAsset, r.Tag, goos, goarch)
+}
+
+// Doer sends the requests, an *http.Client does.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Source is the repository the releases come from.
+type Source struct {
+	// Repo is owner/name, DefaultRepo when empty.
+	Repo string
+	// APIURL is DefaultAPIURL when empty.
+	APIURL string
+	// Token, when set, is sent to the API so the rate limit is the token's.
+	Token  string
+	Client Doer
+}
+
+// Latest is the newest release that isn't a draft or a prerelease.
+func (s Source) Latest(ctx context.Context) (Release, error) {
+	url := strings.TrimSuffix(cmp.Or(s.APIURL, DefaultAPIURL), "/") + "/repos/" + cmp.Or(s.Repo, DefaultRepo) + "/releases/latest"
+	data, err := s.get(ctx, url, "application/vnd.github+json", true)
+	if err != nil {
+		return Release{}, fmt.Errorf("failed to get the latest release: %w", err)
+	}
+	var r Release
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Release{}, fmt.Errorf("failed to read the latest release: %w", err)
+	}
+	if r.Tag == "" {
+		return Release{}, errors.New("the latest release has no tag")
+	}
+	return r, nil
+}
+
+// Download reads an asset.
+func (s Source) Download(ctx context.Context, a Asset) ([]byte, error) {
+	data, err := s.get(ctx, a.URL, "application/octet-stream", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", a.Name, err)
+	}
+	return data, nil
+}
+
+func (s Source) get(ctx context.Context, url, accept string, api bool) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if api && s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	var client Doer = http.DefaultClient
+	if s.Client != nil {
+		client = s.Client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Verify checks data against the checksum of name in checksums, and the
+// checksums against their signature when PublicKey is set.
+func Verify(data []byte, name string, checksums, signature []byte) error {
+	if PublicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("%w: the build's public key can't be read", ErrSignature)
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil || !ed25519.Verify(key, checksums, sig) {
+			return fmt.Errorf("%w: %s isn't signed by the release key", ErrSignature, ChecksumsAsset)
+		}
+	}
+	sum := sha256.Sum256(data)
+	for _, line := range strings.Split(string(checksums), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+				return fmt.Errorf("%w: %s has sha256 %x, the release says %s", ErrChecksum, name, sum, fields[0])
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s isn't in %s", ErrChecksum, name, ChecksumsAsset)
+}
+
+// Newer reports whether latest is a later version than current. Versions
+// are dotted numbers with an optional -prerelease, which comes before the
+// release itself.
+func Newer(latest, current string) (bool, error) {
+	l, lpre, err := parseVersion(latest)
+	if err != nil {
+		return false, err
+	}
+	c, cpre, err := parseVersion(current)
+	if err != nil {
+		return false, err
+	}
+	for i := range max(len(l), len(c)) {
+		var lv, cv int
+		if i < len(l) {
+			lv = l[i]
+		}
+		if i < len(c) {
+			cv = c[i]
+		}
+		if lv != cv {
+			return lv > cv, nil
+		}
+	}
+	if lpre == "" || cpre == "" {
+		return lpre == "" && cpre != "", nil
+	}
+	return lpre > cpre, nil
+}
+
+func parseVersion(v string) ([]int, string, error) {
+	core, pre, _ := strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	core, _, _ = strings.Cut(core, "+")
+	var parts []int
+	for _, p := range strings.Split(core, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, "", fmt.Errorf("%w: %q", ErrNotAVersion, v)
+		}
+		parts = append(parts, n)
+	}
+	return parts, pre, nil
+}
+
+// Replace swaps the executable at exe for data. The new binary is written
+// next to it first so the swap is a rename on the same filesystem, and a
+// failure leaves the old one in place. Windows can't replace a running
+// executable but can rename it, so there the old one is moved to
+// <exe>.old first, CleanUp removes it on a later run.
+func Replace(exe string, data []byte, windows bool) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".new-*")
+	if err != nil {
+		return fmt.Errorf("can't write next to %s: %w", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, bytes.NewReader(data)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return err
+	}
+	if !windows {
+		return os.Rename(tmp.Name(), exe)
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		// put the old one back so there is still something to run
+		os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
+
+// CleanUp removes what a Windows update left behind, it can only go once the
+// old binary stopped running.
+func CleanUp(exe string) {
+	os.Remove(exe + ".old")
+}
@@ -0,0 +1,130 @@
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		latest, current string
+		want            bool
+	}{
+		{"1.5.0", "1.4.9", true},
+		{"1.10.0", "1.9.0", true},
+		{"v1.4.0", "1.4.0", false},
+		{"1.4", "1.4.0", false},
+		{"1.4.0", "1.5.0", false},
+		{"1.4.0", "1.4.0-rc.1", true},
+		{"1.4.0-rc.2", "1.4.0-rc.1", true},
+		{"1.4.0-rc.1", "1.4.0", false},
+	}
+	for _, tt := range tests {
+		if got, err := Newer(tt.latest, tt.current); err != nil || got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v, %v, want %v", tt.latest, tt.current, got, err, tt.want)
+		}
+	}
+	if _, err := Newer("1.4.0", "devel"); !errors.Is(err, ErrNotAVersion) {
+		t.Errorf("Newer() of a devel build = %v, want ErrNotAVersion", err)
+	}
+}
+
+func TestAssetFor(t *testing.T) {
+	r := Release{Tag: "v1.4.0", Assets: []Asset{{Name: "tfmanage_1.4.0_linux_amd64"}, {Name: "tfmanage_1.4.0_linux_arm64"}, {Name: "tfmanage_1.4.0_windows_amd64.exe"}, {Name: ChecksumsAsset}}}
+	for _, tt := range [][3]string{{"linux", "arm64", "tfmanage_1.4.0_linux_arm64"}, {"windows", "amd64", "tfmanage_1.4.0_windows_amd64.exe"}} {
+		if a, err := r.AssetFor(tt[0], tt[1]); err != nil || a.Name != tt[2] {
+			t.Errorf("AssetFor(%s, %s) = %q, %v", tt[0], tt[1], a.Name, err)
+		}
+	}
+	if _, err := r.AssetFor("darwin", "arm64"); !errors.Is(err, ErrNoAsset) {
+		t.Errorf("AssetFor(darwin) = %v, want ErrNoAsset", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := []byte("new binary")
+	checksums := []byte(fmt.Sprintf("%x  tfmanage_linux_amd64\n%x *tfmanage_darwin_arm64\n", sha256.Sum256(data), sha256.Sum256([]byte("other"))))
+	if err := Verify(data, "tfmanage_linux_amd64", checksums, nil); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	if err := Verify(data, "tfmanage_darwin_arm64", checksums, nil); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Verify() of the wrong binary = %v, want ErrChecksum", err)
+	}
+	if err := Verify(data, "tfmanage_windows_amd64.exe", checksums, nil); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Verify() of a binary without a checksum = %v, want ErrChecksum", err)
+	}
+
+	public, private, _ := ed25519.GenerateKey(nil)
+	old := PublicKey
+	PublicKey = base64.StdEncoding.EncodeToString(public)
+	t.Cleanup(func() { PublicKey = old })
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, checksums)) + "\n")
+	if err := Verify(data, "tfmanage_linux_amd64", checksums, signature); err != nil {
+		t.Errorf("Verify() with a signature = %v", err)
+	}
+	tampered := append([]byte(nil), checksums...)
+	tampered[0] ^= 1
+	if err := Verify(data, "tfmanage_linux_amd64", tampered, signature); !errors.Is(err, ErrSignature) {
+		t.Errorf("Verify() of tampered checksums = %v, want ErrSignature", err)
+	}
+	if err := Verify(data, "tfmanage_linux_amd64", checksums, nil); !errors.Is(err, ErrSignature) {
+		t.Errorf("Verify() without the signature = %v, want ErrSignature", err)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acme/tfmanage/releases/latest" || r.Header.Get("Authorization") != "Bearer t0ken" {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"tag_name":"v1.5.0","html_url":"https://github.com/acme/tfmanage/releases/tag/v1.5.0","assets":[{"name":"checksums.txt","browser_download_url":"https://example.com/checksums.txt","size":10}]}`)
+	}))
+	defer srv.Close()
+
+	r, err := Source{Repo: "acme/tfmanage", APIURL: srv.URL, Token: "t0ken"}.Latest(context.Background())
+	if err != nil {
+		t.Fatalf("Latest() error = %v", err)
+	}
+	if r.Version() != "1.5.0" || len(r.Assets) != 1 || r.Assets[0].URL != "https://example.com/checksums.txt" {
+		t.Errorf("Latest() = %+v", r)
+	}
+	if _, err := (Source{Repo: "acme/other", APIURL: srv.URL}).Latest(context.Background()); err == nil {
+		t.Error("Latest() of a repository without releases succeeded")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	for _, windows := range []bool{false, true} {
+		t.Run(fmt.Sprint("windows=", windows), func(t *testing.T) {
+			exe := filepath.Join(t.TempDir(), "tfmanage")
+			os.WriteFile(exe, []byte("old"), 0o750)
+			if err := Replace(exe, []byte("new"), windows); err != nil {
+				t.Fatalf("Replace() error = %v", err)
+			}
+			if data, _ := os.ReadFile(exe); string(data) != "new" {
+				t.Errorf("the executable is %q", data)
+			}
+			if info, _ := os.Stat(exe); info.Mode().Perm() != 0o751 {
+				t.Errorf("the executable's mode is %s", info.Mode())
+			}
+			_, err := os.Stat(exe + ".old")
+			if windows != (err == nil) {
+				t.Errorf("the old binary left behind: %v", err)
+			}
+			CleanUp(exe)
+			if entries, _ := os.ReadDir(filepath.Dir(exe)); len(entries) != 1 {
+				t.Errorf("left behind %v", entries)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Package statediff compares two terraform state files resource by resource.
+// States are decoded one resource at a time and the differences are handed
+// to a callback as they are found, so large states never become one big
+// string.
+package statediff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+)
+
+// Actions a Change can have.
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+)
+
+// Instance is one resource instance of a state, with its attributes left as
+// JSON.
+type Instance struct {
+	Address    string
+	Attributes map[string]json.RawMessage
+	// Sensitive are the top level attributes terraform marked as sensitive.
+	Sensitive map[string]bool
+}
+
+// State is every resource instance of a state, by address.
+type State map[string]Instance
+
+// AttributeChange is one top level attribute that differs. Before is empty
+// when the attribute is new and After when it went away.
+type AttributeChange struct {
+	Name      string `json:"name"`
+	Before    string `json:"before,omitempty"`
+	After     string `json:"after,omitempty"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+}
+
+// Change is a resource instance that was added, removed or modified.
+// Attributes is only set for modified ones.
+type Change struct {
+	Address    string            `json:"address"`
+	Action     string            `json:"action"`
+	Attributes []AttributeChange `json:"attributes,omitempty"`
+}
+
+// Summary counts the changes.
+type Summary struct {
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+	Modified int `json:"modified"`
+}
+
+// resource is a resource as the state file keeps it
+type resource struct {
+	Module    string `json:"module"`
+	Mode      string `json:"mode"`
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Instances []struct {
+		IndexKey            json.RawMessage            `json:"index_key"`
+		Attributes          map[string]json.RawMessage `json:"attributes"`
+		SensitiveAttributes []json.RawMessage          `json:"sensitive_attributes"`
+	} `json:"instances"`
+}
+
+// Read decodes a state, reading its resources one at a time.
+func Read(r io.Reader) (State, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("not a terraform state: %v", orUnexpected(err, tok))
+	}
+	state := State{}
+	seenVersion := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("not a terraform state: %w", err)
+		}
+		switch tok {
+		case "version":
+			seenVersion = true
+			fallthrough
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, fmt.Errorf("not a terraform state: %w", err)
+			}
+			continue
+		case "resources":
+		}
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil, fmt.Errorf("not a terraform state: resources is %v", orUnexpected(err, tok))
+		}
+		for dec.More() {
+			var res resource
+			if err := dec.Decode(&res); err != nil {
+				return nil, fmt.Errorf("not a terraform state: %w", err)
+			}
+			for _, inst := range res.Instances {
+				address := resourceAddress(res) + indexSuffix(inst.IndexKey)
+				state[address] = Instance{Address: address, Attributes: inst.Attributes, Sensitive: sensitivePaths(inst.SensitiveAttributes)}
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, fmt.Errorf("not a terraform state: %w", err)
+		}
+	}
+	if !seenVersion {
+		return nil, fmt.Errorf("not a terraform state: it has no version")
+	}
+	return state, nil
+}
+
+func orUnexpected(err error, tok json.Token) any {
+	if err != nil {
+		return err
+	}
+	return fmt.Sprintf("unexpected %v", tok)
+}
+
+func resourceAddress(res resource) string {
+	address := res.Type + "." + res.Name
+	if res.Mode == "data" {
+		address = "data." + address
+	}
+	if res.Module != "" {
+		address = res.Module + "." + address
+	}
+	return address
+}
+
+// indexSuffix is the [0] or ["key"] of count and for_each instances
+func indexSuffix(key json.RawMessage) string {
+	if len(key) == 0 || string(key) == "null" {
+		return ""
+	}
+	return "[" + string(key) + "]"
+}
+
+// sensitivePaths picks the top level attributes out of sensitive_attributes,
+// which are paths of get_attr and index steps
+func sensitivePaths(paths []json.RawMessage) map[string]bool {
+	sensitive := map[string]bool{}
+	for _, p := range paths {
+		var steps []struct {
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		}
+		if json.Unmarshal(p, &steps) != nil || len(steps) == 0 || steps[0].Type != "get_attr" {
+			continue
+		}
+		if name, err := strconv.Unquote(string(steps[0].Value)); err == nil {
+			sensitive[name] = true
+		}
+	}
+	return sensitive
+}
+
+// Diff compares the states and calls fn with each change, sorted by address.
+// Sensitive values, by terraform's marking or by their name, and values that
+// look like credentials are never passed on.
+func Diff(before, after State, fn func(Change) error) (Summary, error) {
+	addresses := make([]string, 0, len(before)+len(after))
+	for address := range before {
+		addresses = append(addresses, address)
+	}
+	for address := range after {
+		if _, ok := before[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+	slices.Sort(addresses)
+
+	var summary Summary
+	for _, address := range addresses {
+		a, inBefore := before[address]
+		b, inAfter := after[address]
+		var c Change
+		switch {
+		case !inBefore:
+			c = Change{Address: address, Action: Added}
+			summary.Added++
+		case !inAfter:
+			c = Change{Address: address, Action: Removed}
+			summary.Removed++
+		default:
+			attributes := diffAttributes(a, b)
+			if len(attributes) == 0 {
+				continue
+			}
+			c = Change{Address: address, Action: Modified, Attributes: attributes}
+			summary.Modified++
+		}
+		if err := fn(c); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+func diffAttributes(a, b Instance) []AttributeChange {
+	names := make([]string, 0, len(a.Attributes))
+	for name := range a.Attributes {
+		names = append(names, name)
+	}
+	for name := range b.Attributes {
+		if _, ok := a.Attributes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var changes []AttributeChange
+	for _, name := range names {
+		before, after := compact(a.Attributes[name]), compact(b.Attributes[name])
+		if before == after {
+			continue
+		}
+		c := AttributeChange{Name: name, Before: before, After: after}
+		if a.Sensitive[name] || b.Sensitive[name] || redact.SensitiveName(name) || redact.SecretValue(before) || redact.SecretValue(after) {
+			c.Sensitive = true
+			c.Before, c.After = masked(before), masked(after)
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func compact(value json.RawMessage) string {
+	if len(value) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, value) != nil {
+		return string(value)
+	}
+	return buf.String()
+}
+
+// masked keeps whether there was a value, not what it was
+func masked(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redact.Mask
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// slowStore blocks every transfer until the context is done, like a stalled connection would
+type slowStore struct {
+	*MemoryStore
+	started chan struct{}
+}
+
+func newSlowStore() *slowStore {
+	return &slowStore{MemoryStore: NewMemoryStore(), started: make(chan struct{}, 1)}
+}
+
+func (s *slowStore) wait(ctx context.Context) error {
+	s.started <- struct{}{}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Minute):
+		return errors.New("slow store was never cancelled")
+	}
+}
+
+func (s *slowStore) Put(ctx context.Context, in PutInput) (ObjectInfo, error) {
+	return ObjectInfo{}, s.wait(ctx)
+}
+
+func (s *slowStore) Get(ctx context.Context, in GetInput, w io.WriterAt) (int64, error) {
+	// write part of the object first so there is something to clean up
+	w.WriteAt([]byte("partial"), 0)
+	return 0, s.wait(ctx)
+}
+
+// cancelWhenStarted cancels the context as soon as the store has started a transfer
+func cancelWhenStarted(s *slowStore) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.started
+		cancel()
+	}()
+	return ctx
+}
+
+func TestUploadCancelled(t *testing.T) {
+	chdir(t, t.TempDir())
+	writeFile(t, "dev.tfvars", "a = 1")
+	store := newSlowStore()
+
+	start := time.Now()
+	_, err := Upload(cancelWhenStarted(store), store, "", "dev.tfvars", UploadOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Upload() error = %v, want context.Canceled", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Upload() took %v to return after cancel", time.Since(start))
+	}
+}
+
+func TestDownloadCancelledLeavesNoPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	writeFile(t, "dev.tfvars", "original")
+	store := newSlowStore()
+
+	start := time.Now()
+	_, err := Download(cancelWhenStarted(store), store, "", "dev.tfvars")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Download() error = %v, want context.Canceled", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Download() took %v to return after cancel", time.Since(start))
+	}
+
+	data, err := os.ReadFile("dev.tfvars")
+	if err != nil || string(data) != "original" {
+		t.Errorf("existing file was changed to %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("leftover files after cancelled download: %v", names)
+	}
+}
+
+func TestDownloadFailureLeavesNoNewFile(t *testing.T) {
+	chdir(t, t.TempDir())
+	if _, err := Download(context.Background(), NewMemoryStore(), "", "dev.tfvars"); err == nil {
+		t.Fatal("Download() of a missing object succeeded")
+	}
+	if _, err := os.Stat("dev.tfvars"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("failed download created dev.tfvars: %v", err)
+	}
+}
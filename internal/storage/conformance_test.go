@@ -0,0 +1,26 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage/storagetest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storagetest.Run(t, "team/", func(*testing.T) storage.Backend { return storage.NewMemoryStore() })
+}
+
+func TestSSMConformance(t *testing.T) {
+	storagetest.Run(t, "/tfmanage/", func(*testing.T) storage.Backend { return storage.NewSSMStore(storage.NewFakeSSM(), "") })
+}
+
+func TestSecretsManagerConformance(t *testing.T) {
+	storagetest.Run(t, "tfmanage/", func(*testing.T) storage.Backend {
+		return storage.NewSecretsManagerStore(storage.NewFakeSecrets(), "")
+	})
+}
+
+func TestFileConformance(t *testing.T) {
+	storagetest.Run(t, "team/", func(t *testing.T) storage.Backend { return storage.NewFileStore(t.TempDir()) })
+}
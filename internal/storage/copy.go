@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tracing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// CopyInput is what Copy copies: the current version of Source to Key in the
+// same store, with its metadata.
+type CopyInput struct {
+	Source string
+	Key    string
+	// StorageClass is the S3 storage class of the copy, such as GLACIER,
+	// STANDARD when empty.
+	StorageClass string
+	// KMSKeyID encrypts the copy with SSE-KMS under this key.
+	KMSKeyID string
+}
+
+// Copier is implemented by the backends that copy an object without it
+// passing through the client, and can change its storage class on the way.
+type Copier interface {
+	Copy(ctx context.Context, in CopyInput) (ObjectInfo, error)
+}
+
+// Copy copies an object within store. A backend that isn't a Copier has it
+// read and written again, which can't change the storage class, so asking
+// for one is ErrUnsupported there.
+func Copy(ctx context.Context, store Backend, in CopyInput) (_ ObjectInfo, err error) {
+	ctx, span := tracing.Start(ctx, "storage.copy", tracing.String("storage.key", in.Key), tracing.String("storage.source", in.Source))
+	defer func() { span.End(err) }()
+
+	if c, ok := store.(Copier); ok {
+		return c.Copy(ctx, in)
+	}
+	if in.StorageClass != "" {
+		return ObjectInfo{}, fmt.Errorf("%w: storage class %s", ErrUnsupported, in.StorageClass)
+	}
+	info, err := store.Head(ctx, in.Source)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	var buf writeAtBuffer
+	if _, err := store.Get(ctx, GetInput{Key: in.Source}, &buf); err != nil {
+		return ObjectInfo{}, err
+	}
+	return store.Put(ctx, PutInput{
+		Key:         in.Key,
+		Body:        bytes.NewReader(buf.data),
+		Metadata:    info.Metadata,
+		KMSKeyID:    in.KMSKeyID,
+		ContentType: info.ContentType,
+	})
+}
+
+// Copy copies the object inside the bucket with CopyObject, metadata and
+// headers included.
+func (s *S3Store) Copy(ctx context.Context, in CopyInput) (ObjectInfo, error) {
+	copyIn := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.Bucket),
+		Key:               aws.String(in.Key),
+		CopySource:        aws.String((&url.URL{Path: s.Bucket + "/" + in.Source}).EscapedPath()),
+		MetadataDirective: types.MetadataDirectiveCopy,
+	}
+	if in.StorageClass != "" {
+		copyIn.StorageClass = types.StorageClass(in.StorageClass)
+	}
+	if in.KMSKeyID != "" {
+		copyIn.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		copyIn.SSEKMSKeyId = aws.String(in.KMSKeyID)
+	}
+	out, err := s.Client.CopyObject(ctx, copyIn)
+	if err != nil {
+		return ObjectInfo{}, mapS3Error(err, s.Bucket, in.Source)
+	}
+	info := ObjectInfo{
+		Key:          in.Key,
+		VersionID:    aws.ToString(out.VersionId),
+		KMSKeyID:     aws.ToString(out.SSEKMSKeyId),
+		StorageClass: in.StorageClass,
+	}
+	if out.CopyObjectResult != nil {
+		info.ETag = aws.ToString(out.CopyObjectResult.ETag)
+		info.LastModified = aws.ToTime(out.CopyObjectResult.LastModified)
+	}
+	return info, nil
+}
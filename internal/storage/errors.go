@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+var (
+	// ErrObjectNotFound is returned when the requested key does not exist.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrBucketNotFound is returned when the bucket itself does not exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrAccessDenied is returned when the credentials are not allowed to do the operation.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrLocalFileMissing is returned when the local file to upload does not exist.
+	ErrLocalFileMissing = errors.New("local file missing")
+	// ErrTransferFailed wraps any other failure talking to the store during a transfer.
+	ErrTransferFailed = errors.New("transfer failed")
+	// ErrTooLarge is returned when a file is bigger than the store can hold.
+	ErrTooLarge = errors.New("file too large")
+	// ErrUnsupported is returned by a Backend for an operation it can't do.
+	ErrUnsupported = errors.New("not supported by this backend")
+	// ErrPreconditionFailed is returned by a conditional Put when the object
+	// isn't the version it was meant to replace.
+	ErrPreconditionFailed = errors.New("the object has changed")
+	// ErrArchived is returned when reading an object in an archive storage
+	// class, GLACIER or DEEP_ARCHIVE, that hasn't been restored.
+	ErrArchived = errors.New("the object is archived and has to be restored first")
+	// ErrRestoreInProgress is returned by Restore when the object is being
+	// restored already.
+	ErrRestoreInProgress = errors.New("the object is being restored already")
+)
+
+// mapS3Error turns the S3 responses we care about into the errors above. The
+// original error stays wrapped so its details are still available.
+func mapS3Error(err error, bucket, key string) error {
+	if err == nil {
+		return nil
+	}
+	location := "s3://" + bucket + "/" + key
+
+	var nsk *types.NoSuchKey
+	var nf *types.NotFound
+	var nsb *types.NoSuchBucket
+	var ios *types.InvalidObjectState
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &nsb), errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchBucket":
+		return fmt.Errorf("%w: s3://%s: %w", ErrBucketNotFound, bucket, err)
+	case errors.As(err, &nsk), errors.As(err, &nf):
+		return fmt.Errorf("%w: %s: %w", ErrObjectNotFound, location, err)
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDenied", httpStatus(err) == http.StatusForbidden:
+		return fmt.Errorf("%w: %s: %w", ErrAccessDenied, location, err)
+	case errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict"), httpStatus(err) == http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %s: %w", ErrPreconditionFailed, location, err)
+	case errors.As(err, &ios), errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState":
+		return fmt.Errorf("%w: %s: %w", ErrArchived, location, err)
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress":
+		return fmt.Errorf("%w: %s: %w", ErrRestoreInProgress, location, err)
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented", httpStatus(err) == http.StatusNotImplemented:
+		return fmt.Errorf("%w: %s: %w", ErrUnsupported, location, err)
+	}
+	return err
+}
+
+func httpStatus(err error) int {
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode()
+	}
+	return 0
+}
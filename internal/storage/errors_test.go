@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestMapS3Error(t *testing.T) {
+	forbidden := &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusForbidden}},
+		Err:      errors.New("Forbidden"),
+	}
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"no such key", &types.NoSuchKey{}, ErrObjectNotFound},
+		{"head not found", &types.NotFound{}, ErrObjectNotFound},
+		{"no such bucket", &types.NoSuchBucket{}, ErrBucketNotFound},
+		{"no such bucket code", &smithy.GenericAPIError{Code: "NoSuchBucket"}, ErrBucketNotFound},
+		{"access denied code", &smithy.GenericAPIError{Code: "AccessDenied"}, ErrAccessDenied},
+		{"head forbidden", forbidden, ErrAccessDenied},
+		{"precondition failed code", &smithy.GenericAPIError{Code: "PreconditionFailed"}, ErrPreconditionFailed},
+		{"conditional write conflict", &smithy.GenericAPIError{Code: "ConditionalRequestConflict"}, ErrPreconditionFailed},
+		{"not implemented", &smithy.GenericAPIError{Code: "NotImplemented"}, ErrUnsupported},
+		{"archived", &types.InvalidObjectState{}, ErrArchived},
+		{"archived code", &smithy.GenericAPIError{Code: "InvalidObjectState"}, ErrArchived},
+		{"restore in progress", &smithy.GenericAPIError{Code: "RestoreAlreadyInProgress"}, ErrRestoreInProgress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mapS3Error(tt.err, "bucket", "tfvars/dev.tfvars")
+			if !errors.Is(got, tt.want) {
+				t.Errorf("mapS3Error() = %v, want %v", got, tt.want)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("mapS3Error() = %v lost the original error", got)
+			}
+		})
+	}
+
+	other := errors.New("slow down")
+	if got := mapS3Error(other, "bucket", "key"); got != other {
+		t.Errorf("unrelated error was changed to %v", got)
+	}
+	if mapS3Error(nil, "bucket", "key") != nil {
+		t.Error("nil error was not kept nil")
+	}
+}
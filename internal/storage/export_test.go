@@ -0,0 +1,8 @@
+package storage
+
+// the fakes are exported to the conformance tests in storage_test
+
+var (
+	NewFakeSSM     = func() SSMAPI { return newFakeSSM() }
+	NewFakeSecrets = func() SecretsManagerAPI { return newFakeSecrets() }
+)
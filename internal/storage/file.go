@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileVersionsDir holds the copies of every version under the root, one
+// folder per key, so it never clashes with a key.
+const fileVersionsDir = ".versions"
+
+// FileStore is the Backend backed by a local directory, for working offline
+// and for tests that shouldn't need AWS. Keys are paths under Root. Every Put
+// also keeps a timestamped copy under .versions/<key>/ with a JSON sidecar
+// holding its checksum and metadata.
+type FileStore struct {
+	Root string
+}
+
+// fileSidecar is the JSON kept next to every version copy
+type fileSidecar struct {
+	VersionID    string            `json:"version_id"`
+	Size         int64             `json:"size"`
+	SHA256       string            `json:"sha256"`
+	LastModified time.Time         `json:"last_modified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+func (s fileSidecar) info(key string) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         s.Size,
+		ETag:         `"` + s.SHA256 + `"`,
+		VersionID:    s.VersionID,
+		LastModified: s.LastModified,
+		Metadata:     s.Metadata,
+	}
+}
+
+// NewFileStore creates a store rooted at dir.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Root: dir}
+}
+
+// path is where key lives under the root, keys can't climb out of it
+func (s *FileStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)[1:]
+	if clean == "" || clean != strings.TrimPrefix(key, "/") || clean == fileVersionsDir || strings.HasPrefix(clean, fileVersionsDir+"/") {
+		return "", fmt.Errorf("invalid key %q for %s", key, s.Root)
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(clean)), nil
+}
+
+func (s *FileStore) versionsDir(key string) string {
+	return filepath.Join(s.Root, fileVersionsDir, filepath.FromSlash(path.Clean("/" + key)[1:]))
+}
+
+func (s *FileStore) Put(ctx context.Context, in PutInput) (ObjectInfo, error) {
+	if in.IfMatch != "" {
+		return ObjectInfo{}, fmt.Errorf("conditional writes: %w", ErrUnsupported)
+	}
+	p, err := s.path(in.Key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	sum := sha256.Sum256(data)
+	now := time.Now().UTC()
+	sidecar := fileSidecar{
+		VersionID:    now.Format("20060102T150405.000000000Z"),
+		Size:         int64(len(data)),
+		SHA256:       hex.EncodeToString(sum[:]),
+		LastModified: now,
+		Metadata:     in.Metadata,
+	}
+
+	dir := s.versionsDir(in.Key)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	// two puts in the same nanosecond still get their own version
+	for n := 1; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, sidecar.VersionID)); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		sidecar.VersionID = fmt.Sprintf("%s-%d", now.Format("20060102T150405.000000000Z"), n)
+	}
+	encoded, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, sidecar.VersionID), data); err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, sidecar.VersionID+".json"), encoded); err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to create %s: %w", filepath.Dir(p), err)
+	}
+	if err := writeFileAtomic(p, data); err != nil {
+		return ObjectInfo{}, err
+	}
+	return sidecar.info(in.Key), nil
+}
+
+// writeFileAtomic writes through a temp file so readers never see half a file
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *FileStore) Get(ctx context.Context, in GetInput, w io.WriterAt) (int64, error) {
+	p, err := s.path(in.Key)
+	if err != nil {
+		return 0, err
+	}
+	if in.VersionID != "" {
+		if strings.ContainsAny(in.VersionID, `/\`) || strings.HasPrefix(in.VersionID, ".") {
+			return 0, fmt.Errorf("invalid version %q of %s", in.VersionID, in.Key)
+		}
+		p = filepath.Join(s.versionsDir(in.Key), in.VersionID)
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("%w: %s", ErrObjectNotFound, p)
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.WriteAt(data, 0)
+	return int64(n), err
+}
+
+// Head gives the newest sidecar of key. A file that was changed or put
+// there by hand, so the sidecar doesn't match, is described from its content.
+func (s *FileStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && stat.IsDir()) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrObjectNotFound, p)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	sum, err := FileChecksum(p)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	sidecars, err := s.sidecars(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(sidecars) > 0 && sidecars[0].SHA256 == sum {
+		info := sidecars[0].info(key)
+		info.Metadata = withChecksum(info.Metadata, sum)
+		return info, nil
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ETag:         `"` + sum + `"`,
+		LastModified: stat.ModTime().UTC(),
+		Metadata:     map[string]string{ChecksumMetadataKey: sum},
+	}, nil
+}
+
+// withChecksum makes sure the checksum metadata matches the content
+func withChecksum(metadata map[string]string, sum string) map[string]string {
+	out := map[string]string{ChecksumMetadataKey: sum}
+	for k, v := range metadata {
+		if k != ChecksumMetadataKey {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// sidecars reads the sidecars of key, newest first
+func (s *FileStore) sidecars(key string) ([]fileSidecar, error) {
+	matches, err := filepath.Glob(filepath.Join(s.versionsDir(key), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var sidecars []fileSidecar
+	for _, m := range matches {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+		var sc fileSidecar
+		if err := json.Unmarshal(data, &sc); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", m, err)
+		}
+		sidecars = append(sidecars, sc)
+	}
+	sort.Slice(sidecars, func(i, j int) bool {
+		if !sidecars[i].LastModified.Equal(sidecars[j].LastModified) {
+			return sidecars[i].LastModified.After(sidecars[j].LastModified)
+		}
+		return sidecars[i].VersionID > sidecars[j].VersionID
+	})
+	return sidecars, nil
+}
+
+func (s *FileStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(s.Root, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == s.Root {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if key == fileVersionsDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Versions gives every version kept under .versions, newest first, even
+// after the key was deleted.
+func (s *FileStore) Versions(ctx context.Context, key string) ([]ObjectInfo, error) {
+	if _, err := s.path(key); err != nil {
+		return nil, err
+	}
+	sidecars, err := s.sidecars(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sidecars) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, filepath.Join(s.Root, filepath.FromSlash(key)))
+	}
+	versions := make([]ObjectInfo, 0, len(sidecars))
+	for _, sc := range sidecars {
+		versions = append(versions, sc.info(key))
+	}
+	return versions, nil
+}
+
+// Delete removes the current file and keeps its versions, like a versioned
+// bucket does.
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
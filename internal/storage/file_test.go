@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStoreLayout(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStore(t.TempDir())
+	info, err := store.Put(ctx, PutInput{Key: "team/dev.tfvars", Body: strings.NewReader("a = 1\n"), Metadata: map[string]string{"git-sha": "abc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(store.Root, "team", "dev.tfvars")); err != nil || string(data) != "a = 1\n" {
+		t.Errorf("current file = %q, %v", data, err)
+	}
+	copyPath := filepath.Join(store.Root, fileVersionsDir, "team", "dev.tfvars", info.VersionID)
+	if data, err := os.ReadFile(copyPath); err != nil || string(data) != "a = 1\n" {
+		t.Errorf("version copy = %q, %v", data, err)
+	}
+	if _, err := os.Stat(copyPath + ".json"); err != nil {
+		t.Errorf("sidecar: %v", err)
+	}
+
+	head, err := store.Head(ctx, "team/dev.tfvars")
+	if err != nil || head.VersionID != info.VersionID || head.Metadata["git-sha"] != "abc" {
+		t.Errorf("Head() = %+v, %v, want the sidecar's version and metadata", head, err)
+	}
+
+	if _, err := store.Put(ctx, PutInput{Key: "team/dev.tfvars", Body: strings.NewReader("a = 3\n")}); err != nil {
+		t.Fatal(err)
+	}
+	var buf writeAtBuffer
+	if _, err := store.Get(ctx, GetInput{Key: "team/dev.tfvars", VersionID: info.VersionID}, &buf); err != nil || string(buf.data) != "a = 1\n" {
+		t.Errorf("Get() of the first version = %q, %v", buf.data, err)
+	}
+	if _, err := store.Get(ctx, GetInput{Key: "team/dev.tfvars", VersionID: "../../escape"}, &buf); err == nil {
+		t.Error("Get() of a version outside the versions directory succeeded")
+	}
+
+	// editing the file by hand means the sidecar no longer describes it
+	os.WriteFile(filepath.Join(store.Root, "team", "dev.tfvars"), []byte("a = 2\n"), 0o644)
+	head, err = store.Head(ctx, "team/dev.tfvars")
+	if err != nil || head.ETag == info.ETag || head.VersionID != "" {
+		t.Errorf("Head() of an edited file = %+v, %v, want a new etag and no version", head, err)
+	}
+
+	objects, err := store.List(ctx, "")
+	if err != nil || len(objects) != 1 || objects[0].Key != "team/dev.tfvars" {
+		t.Errorf("List() = %+v, %v, want only the current file", objects, err)
+	}
+
+	for _, key := range []string{"../escape", "team/../../escape", ".versions/team/dev.tfvars", ""} {
+		if _, err := store.Put(ctx, PutInput{Key: key, Body: strings.NewReader("x")}); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory Backend used by the tests. The *Err fields can be
+// set to make the matching operation fail.
+type MemoryStore struct {
+	mu      sync.Mutex
+	objects map[string]memoryObject
+	// history has every version ever put, oldest first, like a versioned bucket
+	history map[string][]memoryObject
+	puts    int
+	// publicAccessChecks counts the calls to PublicAccess
+	publicAccessChecks int
+
+	PutErr      error
+	GetErr      error
+	HeadErr     error
+	ListErr     error
+	VersionsErr error
+	DeleteErr   error
+
+	// RestorePolls is how many Heads a restore stays in progress for, the
+	// restore is done by the next one.
+	RestorePolls int
+	// restores counts down the Heads left of each restore in progress
+	restores map[string]int
+	// Restores has the RestoreInput of every Restore call.
+	Restores []RestoreInput
+
+	// Access is what PublicAccess gives back, nil means fully blocked.
+	Access          *PublicAccess
+	PublicAccessErr error
+
+	// Owner is the account OwnedBy says owns the bucket, any account when
+	// empty. Grants are what ACL gives back.
+	Owner        string
+	Grants       []string
+	OwnershipErr error
+}
+
+type memoryObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+// NewMemoryStore returns an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: map[string]memoryObject{}, history: map[string][]memoryObject{}}
+}
+
+func (m *MemoryStore) Put(ctx context.Context, in PutInput) (ObjectInfo, error) {
+	if m.PutErr != nil {
+		return ObjectInfo{}, m.PutErr
+	}
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	sum := md5.Sum(data)
+	meta := map[string]string{}
+	for k, v := range in.Metadata {
+		meta[k] = v
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.objects[in.Key]; in.IfMatch != "" && (!ok || current.info.ETag != in.IfMatch) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrPreconditionFailed, in.Key)
+	}
+	m.puts++
+	info := ObjectInfo{
+		Key:          in.Key,
+		Size:         int64(len(data)),
+		ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		VersionID:    fmt.Sprintf("v%d", m.puts),
+		LastModified: time.Now().UTC(),
+		Metadata:     meta,
+		KMSKeyID:     in.KMSKeyID,
+		ContentType:  in.ContentType,
+	}
+	if !SameStorageClass(in.StorageClass, "") {
+		info.StorageClass = in.StorageClass
+	}
+	m.objects[in.Key] = memoryObject{data: data, info: info}
+	m.history[in.Key] = append(m.history[in.Key], memoryObject{data: data, info: info})
+	return info, nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, in GetInput, w io.WriterAt) (int64, error) {
+	if m.GetErr != nil {
+		return 0, m.GetErr
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	obj, ok := m.objects[in.Key]
+	if in.VersionID != "" {
+		i := slices.IndexFunc(m.history[in.Key], func(o memoryObject) bool { return o.info.VersionID == in.VersionID })
+		if ok = i >= 0; ok {
+			obj = m.history[in.Key][i]
+		}
+	}
+	m.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrObjectNotFound, in.Key)
+	}
+	if NeedsRestore(obj.info.StorageClass) && !obj.info.Restore.Restored() {
+		return 0, fmt.Errorf("%w: %s is in %s", ErrArchived, in.Key, obj.info.StorageClass)
+	}
+	n, err := w.WriteAt(obj.data, 0)
+	return int64(n), err
+}
+
+func (m *MemoryStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	if m.HeadErr != nil {
+		return ObjectInfo{}, m.HeadErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	if left, restoring := m.restores[key]; restoring {
+		if left > 0 {
+			m.restores[key] = left - 1
+		} else {
+			delete(m.restores, key)
+			obj.info.Restore = RestoreStatus{Expiry: time.Now().Add(24 * time.Hour).UTC()}
+			m.objects[key] = obj
+		}
+	}
+	return obj.info, nil
+}
+
+func (m *MemoryStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	if m.ListErr != nil {
+		return nil, m.ListErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []ObjectInfo
+	for k, obj := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, obj.info)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func (m *MemoryStore) Versions(ctx context.Context, key string) ([]ObjectInfo, error) {
+	if m.VersionsErr != nil {
+		return nil, m.VersionsErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	history := m.history[key]
+	if len(history) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	versions := make([]ObjectInfo, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		versions = append(versions, history[i].info)
+	}
+	return versions, nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	if m.DeleteErr != nil {
+		return m.DeleteErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// Copy copies the current version of Source, giving the copy StorageClass.
+func (m *MemoryStore) Copy(ctx context.Context, in CopyInput) (ObjectInfo, error) {
+	if m.PutErr != nil {
+		return ObjectInfo{}, m.PutErr
+	}
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src, ok := m.objects[in.Source]
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrObjectNotFound, in.Source)
+	}
+	m.puts++
+	info := src.info
+	info.Key = in.Key
+	info.VersionID = fmt.Sprintf("v%d", m.puts)
+	info.LastModified = time.Now().UTC()
+	info.StorageClass = ""
+	if !SameStorageClass(in.StorageClass, "") {
+		info.StorageClass = in.StorageClass
+	}
+	info.Restore = RestoreStatus{}
+	if in.KMSKeyID != "" {
+		info.KMSKeyID = in.KMSKeyID
+	}
+	obj := memoryObject{data: bytes.Clone(src.data), info: info}
+	m.objects[in.Key] = obj
+	m.history[in.Key] = append(m.history[in.Key], obj)
+	return info, nil
+}
+
+// Restore starts a restore that is done after RestorePolls more Heads.
+func (m *MemoryStore) Restore(ctx context.Context, in RestoreInput) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[in.Key]
+	switch {
+	case !ok:
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, in.Key)
+	case !NeedsRestore(obj.info.StorageClass):
+		return fmt.Errorf("%s is in %s, it can be read without a restore", in.Key, cmp.Or(obj.info.StorageClass, "STANDARD"))
+	case obj.info.Restore.InProgress:
+		return fmt.Errorf("%w: %s", ErrRestoreInProgress, in.Key)
+	}
+	m.Restores = append(m.Restores, in)
+	if m.restores == nil {
+		m.restores = map[string]int{}
+	}
+	m.restores[in.Key] = m.RestorePolls
+	obj.info.Restore = RestoreStatus{InProgress: true}
+	m.objects[in.Key] = obj
+	return nil
+}
+
+// Puts reports how many successful Put calls were made.
+func (m *MemoryStore) Puts() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.puts
+}
+
+// Bytes returns the stored content for key.
+func (m *MemoryStore) Bytes(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[key]
+	return bytes.Clone(obj.data), ok
+}
+
+// PublicAccess gives back Access, like a bucket with those settings.
+func (m *MemoryStore) PublicAccess(ctx context.Context) (PublicAccess, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.publicAccessChecks++
+	if m.PublicAccessErr != nil {
+		return PublicAccess{}, m.PublicAccessErr
+	}
+	if m.Access == nil {
+		return BlockedPublicAccess, nil
+	}
+	return *m.Access, nil
+}
+
+// PublicAccessChecks is how many times PublicAccess was called.
+func (m *MemoryStore) PublicAccessChecks() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.publicAccessChecks
+}
+
+// OwnedBy reports whether account is Owner, or true when Owner is empty.
+func (m *MemoryStore) OwnedBy(ctx context.Context, account string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.OwnershipErr != nil {
+		return false, m.OwnershipErr
+	}
+	return m.Owner == "" || m.Owner == account, nil
+}
+
+// ACL gives back Grants, like a bucket whose ACL has them.
+func (m *MemoryStore) ACL(ctx context.Context) (BucketACL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.OwnershipErr != nil {
+		return BucketACL{}, m.OwnershipErr
+	}
+	return BucketACL{OwnerID: "memory", Grants: slices.Clone(m.Grants)}, nil
+}
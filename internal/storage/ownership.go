@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// The groups an ACL can grant to, besides S3's log delivery.
+const (
+	allUsersGroup           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	authenticatedUsersGroup = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+)
+
+// BucketACL is who the bucket's ACL lets in besides its owner.
+type BucketACL struct {
+	// OwnerID is the canonical user ID of the bucket's owner.
+	OwnerID string
+	// ObjectOwnership is the bucket's Object Ownership setting, empty when
+	// it has none. With BucketOwnerEnforced ACLs are turned off.
+	ObjectOwnership string
+	// Grants describes each grant to anyone but the owner, in words.
+	Grants []string
+}
+
+// OwnershipChecker is implemented by the backends that keep files in a
+// bucket, so uploads can make sure the bucket belongs to the account they
+// expect.
+type OwnershipChecker interface {
+	// OwnedBy reports whether the account with the 12 digit ID owns the
+	// bucket.
+	OwnedBy(ctx context.Context, account string) (bool, error)
+	ACL(ctx context.Context) (BucketACL, error)
+}
+
+// OwnedBy asks S3 with HeadBucket and ExpectedBucketOwner, which fails with
+// 403 for a bucket of another account. A HeadBucket without it goes first,
+// so a 403 for the caller not being allowed in at all is ErrAccessDenied
+// rather than an owner that doesn't match.
+func (s *S3Store) OwnedBy(ctx context.Context, account string) (bool, error) {
+	if _, err := s.Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.Bucket)}); err != nil {
+		return false, mapS3Error(err, s.Bucket, "")
+	}
+	_, err := s.Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.Bucket), ExpectedBucketOwner: aws.String(account)})
+	if err := mapS3Error(err, s.Bucket, ""); errors.Is(err, ErrAccessDenied) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ACL reads the bucket's Object Ownership setting and ACL. A bucket with
+// BucketOwnerEnforced ignores ACLs, so its grants aren't read.
+func (s *S3Store) ACL(ctx context.Context) (BucketACL, error) {
+	var acl BucketACL
+	controls, err := s.Client.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{Bucket: aws.String(s.Bucket)})
+	switch {
+	case isS3ErrorCode(err, "OwnershipControlsNotFoundError"):
+	case err != nil:
+		return BucketACL{}, mapS3Error(err, s.Bucket, "")
+	case controls.OwnershipControls != nil:
+		for _, rule := range controls.OwnershipControls.Rules {
+			acl.ObjectOwnership = string(rule.ObjectOwnership)
+		}
+	}
+
+	out, err := s.Client.GetBucketAcl(ctx, &s3.GetBucketAclInput{Bucket: aws.String(s.Bucket)})
+	if err != nil {
+		return BucketACL{}, mapS3Error(err, s.Bucket, "")
+	}
+	if out.Owner != nil {
+		acl.OwnerID = aws.ToString(out.Owner.ID)
+	}
+	if acl.ObjectOwnership == string(types.ObjectOwnershipBucketOwnerEnforced) {
+		return acl, nil
+	}
+	for _, g := range out.Grants {
+		if g.Grantee == nil {
+			continue
+		}
+		var who string
+		switch g.Grantee.Type {
+		case types.TypeCanonicalUser:
+			if aws.ToString(g.Grantee.ID) == acl.OwnerID {
+				continue
+			}
+			who = "canonical user " + aws.ToString(g.Grantee.ID)
+		case types.TypeAmazonCustomerByEmail:
+			who = aws.ToString(g.Grantee.EmailAddress)
+		case types.TypeGroup:
+			switch aws.ToString(g.Grantee.URI) {
+			case allUsersGroup:
+				who = "everyone"
+			case authenticatedUsersGroup:
+				who = "any AWS account"
+			default:
+				// S3's log delivery group writes the access logs
+				continue
+			}
+		}
+		acl.Grants = append(acl.Grants, fmt.Sprintf("%s to %s", g.Permission, who))
+	}
+	return acl, nil
+}
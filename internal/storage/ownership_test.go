@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ownershipServer plays buckets owned by account 111111111111, with
+// the ACL named after the bucket
+func ownershipServer(t *testing.T) *S3Store {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket := r.URL.Path[1:]
+		query := r.URL.Query()
+		_, ownershipControls := query["ownershipControls"]
+		_, acl := query["acl"]
+		switch {
+		case bucket == "denied":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		case r.Method == http.MethodHead:
+			if owner := r.Header.Get("X-Amz-Expected-Bucket-Owner"); owner != "" && owner != "111111111111" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		case ownershipControls && bucket == "enforced":
+			fmt.Fprint(w, `<OwnershipControls><Rule><ObjectOwnership>BucketOwnerEnforced</ObjectOwnership></Rule></OwnershipControls>`)
+		case ownershipControls:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>OwnershipControlsNotFoundError</Code><Message>The bucket ownership controls were not found</Message></Error>`)
+		case acl:
+			fmt.Fprint(w, `<AccessControlPolicy><Owner><ID>owner-id</ID></Owner><AccessControlList>`+
+				`<Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="CanonicalUser"><ID>owner-id</ID></Grantee><Permission>FULL_CONTROL</Permission></Grant>`+
+				`<Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="CanonicalUser"><ID>other-id</ID></Grantee><Permission>READ</Permission></Grant>`+
+				`<Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="Group"><URI>http://acs.amazonaws.com/groups/s3/LogDelivery</URI></Grantee><Permission>WRITE</Permission></Grant>`+
+				`<Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="Group"><URI>http://acs.amazonaws.com/groups/global/AuthenticatedUsers</URI></Grantee><Permission>READ</Permission></Grant>`+
+				`</AccessControlList></AccessControlPolicy>`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	t.Cleanup(server.Close)
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+	}
+	return NewS3Store(NewS3Client(cfg, S3ClientOptions{Endpoint: server.URL, UsePathStyle: true}), "tfvars")
+}
+
+func TestS3OwnedBy(t *testing.T) {
+	store := ownershipServer(t)
+	ctx := context.Background()
+	if owned, err := store.OwnedBy(ctx, "111111111111"); !owned || err != nil {
+		t.Errorf("OwnedBy(owner) = %t, %v", owned, err)
+	}
+	if owned, err := store.OwnedBy(ctx, "222222222222"); owned || err != nil {
+		t.Errorf("OwnedBy(another account) = %t, %v, want false without an error", owned, err)
+	}
+	store.Bucket = "denied"
+	if _, err := store.OwnedBy(ctx, "111111111111"); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("OwnedBy() without access = %v, want ErrAccessDenied", err)
+	}
+}
+
+func TestS3ACL(t *testing.T) {
+	store := ownershipServer(t)
+	ctx := context.Background()
+	acl, err := store.ACL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"READ to canonical user other-id", "READ to any AWS account"}; acl.OwnerID != "owner-id" || !slices.Equal(acl.Grants, want) {
+		t.Errorf("ACL() = %+v, want the grants %q", acl, want)
+	}
+
+	store.Bucket = "enforced"
+	if acl, err := store.ACL(ctx); err != nil || acl.ObjectOwnership != "BucketOwnerEnforced" || len(acl.Grants) != 0 {
+		t.Errorf("ACL() with ACLs turned off = %+v, %v", acl, err)
+	}
+	store.Bucket = "denied"
+	if _, err := store.ACL(ctx); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("ACL() without access = %v, want ErrAccessDenied", err)
+	}
+}
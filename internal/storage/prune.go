@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Prune keeps the newest keep objects under prefix and deletes the rest,
+// returning the deleted keys. Objects are ordered by LastModified and then by
+// key, so keys that start with a timestamp prune in the expected order even
+// when the store does not report times. keep of 0 or less keeps everything.
+func Prune(ctx context.Context, store Backend, prefix string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s for pruning: %w", prefix, err)
+	}
+	if len(objects) <= keep {
+		return nil, nil
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		if !objects[i].LastModified.Equal(objects[j].LastModified) {
+			return objects[i].LastModified.After(objects[j].LastModified)
+		}
+		return objects[i].Key > objects[j].Key
+	})
+	var deleted []string
+	for _, o := range objects[keep:] {
+		if err := store.Delete(ctx, o.Key); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s: %w", o.Key, err)
+		}
+		deleted = append(deleted, o.Key)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for _, key := range []string{
+		"backups/dev/20240101T000000Z-serial1.json",
+		"backups/dev/20240301T000000Z-serial3.json",
+		"backups/dev/20240201T000000Z-serial2.json",
+		"backups/prod/20240101T000000Z-serial9.json",
+	} {
+		store.Put(ctx, PutInput{Key: key, Body: strings.NewReader("{}")})
+	}
+
+	deleted, err := Prune(ctx, store, "backups/dev/", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"backups/dev/20240101T000000Z-serial1.json"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted %q, want %q", deleted, want)
+	}
+	left, _ := store.List(ctx, "backups/")
+	if len(left) != 3 {
+		t.Errorf("%d objects left, want 3", len(left))
+	}
+
+	if deleted, _ := Prune(ctx, store, "backups/dev/", 0); deleted != nil {
+		t.Errorf("keep 0 deleted %q", deleted)
+	}
+}
+
+func TestPruneDeleteFailure(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.Put(ctx, PutInput{Key: "a/1", Body: strings.NewReader("{}")})
+	store.Put(ctx, PutInput{Key: "a/2", Body: strings.NewReader("{}")})
+	store.DeleteErr = ErrAccessDenied
+
+	if _, err := Prune(ctx, store, "a/", 1); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("err = %v, want ErrAccessDenied", err)
+	}
+}
+
+func TestPutBytes(t *testing.T) {
+	store := NewMemoryStore()
+	res, err := PutBytes(context.Background(), store, "state/backup.json", []byte(`{"serial":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := store.Head(context.Background(), "state/backup.json")
+	if info.Metadata[ChecksumMetadataKey] != res.Checksum || res.Checksum == "" {
+		t.Errorf("checksum metadata = %q, result = %q", info.Metadata[ChecksumMetadataKey], res.Checksum)
+	}
+}
+
+func TestPutBytesWith(t *testing.T) {
+	store := NewMemoryStore()
+	opts := UploadOptions{KMSKeyID: "alias/state", Metadata: map[string]string{"serial": "4", ChecksumMetadataKey: "not it"}}
+	res, err := PutBytesWith(context.Background(), store, "state/snapshot.json.gz", []byte("gzipped"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := store.Head(context.Background(), "state/snapshot.json.gz")
+	if info.Metadata["serial"] != "4" || info.Metadata[ChecksumMetadataKey] != res.Checksum || info.KMSKeyID != "alias/state" {
+		t.Errorf("Head() = %+v, want the metadata, the real checksum and the KMS key", info)
+	}
+}
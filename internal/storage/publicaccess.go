@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// PublicAccess is what a bucket's settings say about anyone being able to
+// read it: the four Block Public Access settings, and S3's own verdict on
+// the bucket policy.
+type PublicAccess struct {
+	BlockPublicACLs       bool
+	IgnorePublicACLs      bool
+	BlockPublicPolicy     bool
+	RestrictPublicBuckets bool
+	PolicyIsPublic        bool
+}
+
+// BlockedPublicAccess is a bucket with every public access setting on.
+var BlockedPublicAccess = PublicAccess{BlockPublicACLs: true, IgnorePublicACLs: true, BlockPublicPolicy: true, RestrictPublicBuckets: true}
+
+// Public is true unless public access is fully blocked and the policy isn't public.
+func (p PublicAccess) Public() bool {
+	return len(p.Problems()) > 0
+}
+
+// Problems says in words what leaves the bucket open, one entry per setting.
+func (p PublicAccess) Problems() []string {
+	var problems []string
+	if !p.BlockPublicACLs {
+		problems = append(problems, "BlockPublicAcls is off, new public ACLs can be put on the bucket and its objects")
+	}
+	if !p.IgnorePublicACLs {
+		problems = append(problems, "IgnorePublicAcls is off, public ACLs on the bucket and its objects are honoured")
+	}
+	if !p.BlockPublicPolicy {
+		problems = append(problems, "BlockPublicPolicy is off, a bucket policy granting public access can be set")
+	}
+	if !p.RestrictPublicBuckets {
+		problems = append(problems, "RestrictPublicBuckets is off, a public bucket policy gives anyone access")
+	}
+	if p.PolicyIsPublic {
+		problems = append(problems, "S3 reports the bucket policy as public")
+	}
+	return problems
+}
+
+// PublicAccessChecker is implemented by the backends that keep files in a
+// bucket, so uploads can refuse to put secrets in a public one.
+type PublicAccessChecker interface {
+	PublicAccess(ctx context.Context) (PublicAccess, error)
+}
+
+// PublicAccess reads the bucket's Block Public Access settings and policy
+// status. A bucket without a Block Public Access configuration has every
+// setting off, and one without a policy has a policy that isn't public.
+func (s *S3Store) PublicAccess(ctx context.Context) (PublicAccess, error) {
+	var access PublicAccess
+	block, err := s.Client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(s.Bucket)})
+	switch {
+	case isS3ErrorCode(err, "NoSuchPublicAccessBlockConfiguration"):
+	case err != nil:
+		return PublicAccess{}, mapS3Error(err, s.Bucket, "")
+	case block.PublicAccessBlockConfiguration != nil:
+		c := block.PublicAccessBlockConfiguration
+		access.BlockPublicACLs = aws.ToBool(c.BlockPublicAcls)
+		access.IgnorePublicACLs = aws.ToBool(c.IgnorePublicAcls)
+		access.BlockPublicPolicy = aws.ToBool(c.BlockPublicPolicy)
+		access.RestrictPublicBuckets = aws.ToBool(c.RestrictPublicBuckets)
+	}
+
+	status, err := s.Client.GetBucketPolicyStatus(ctx, &s3.GetBucketPolicyStatusInput{Bucket: aws.String(s.Bucket)})
+	switch {
+	case isS3ErrorCode(err, "NoSuchBucketPolicy"):
+	case err != nil:
+		return PublicAccess{}, mapS3Error(err, s.Bucket, "")
+	case status.PolicyStatus != nil:
+		access.PolicyIsPublic = aws.ToBool(status.PolicyStatus.IsPublic)
+	}
+	return access, nil
+}
+
+func isS3ErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// publicAccessServer answers the two public access calls for each bucket
+func publicAccessServer(t *testing.T) *S3Store {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3Error := func(status int, code string) {
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+		}
+		bucket := r.URL.Path[1:]
+		_, policyStatus := r.URL.Query()["policyStatus"]
+		switch {
+		case bucket == "denied":
+			s3Error(http.StatusForbidden, "AccessDenied")
+		case bucket == "unconfigured" && !policyStatus:
+			s3Error(http.StatusNotFound, "NoSuchPublicAccessBlockConfiguration")
+		case bucket == "unconfigured":
+			s3Error(http.StatusNotFound, "NoSuchBucketPolicy")
+		case !policyStatus:
+			fmt.Fprint(w, `<PublicAccessBlockConfiguration><BlockPublicAcls>true</BlockPublicAcls><IgnorePublicAcls>true</IgnorePublicAcls><BlockPublicPolicy>true</BlockPublicPolicy><RestrictPublicBuckets>true</RestrictPublicBuckets></PublicAccessBlockConfiguration>`)
+		default:
+			fmt.Fprintf(w, `<PolicyStatus><IsPublic>%t</IsPublic></PolicyStatus>`, bucket == "public-policy")
+		}
+	}))
+	t.Cleanup(server.Close)
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+	}
+	return NewS3Store(NewS3Client(cfg, S3ClientOptions{Endpoint: server.URL, UsePathStyle: true}), "")
+}
+
+func TestS3PublicAccess(t *testing.T) {
+	store := publicAccessServer(t)
+	ctx := context.Background()
+	for _, tc := range []struct {
+		bucket   string
+		problems int
+		err      error
+	}{
+		{"private", 0, nil},
+		{"public-policy", 1, nil},
+		{"unconfigured", 4, nil},
+		{"denied", 0, ErrAccessDenied},
+	} {
+		store.Bucket = tc.bucket
+		access, err := store.PublicAccess(ctx)
+		if !errors.Is(err, tc.err) || (err == nil && len(access.Problems()) != tc.problems) {
+			t.Errorf("PublicAccess() of %s = %+v, %v, want %d problems and error %v", tc.bucket, access, err, tc.problems, tc.err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrPrimaryOnly is returned for a write to a ReplicaStore whose primary
+// can't be reached, writes never go to the replica.
+var ErrPrimaryOnly = errors.New("writes only go to the primary")
+
+// ErrPrimaryVersion is returned for a read of one of Primary's versions
+// while it can't be reached. Replica keeps its own version IDs, so the
+// version can't be read from it.
+var ErrPrimaryVersion = errors.New("the version is only in the primary")
+
+// Unavailable reports whether err looks like the store's region being down
+// rather than something wrong with the request: a timeout, a connection
+// that couldn't be made or was dropped, or a 5xx response.
+func Unavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || httpStatus(err) >= http.StatusInternalServerError
+}
+
+// ReplicaStore reads from Primary, and from Replica when Primary is
+// Unavailable, such as a bucket replicated to another region for disaster
+// recovery. Writes only ever go to Primary, one that can't be made is
+// ErrPrimaryOnly rather than a write to the replica that replication would
+// never bring back.
+type ReplicaStore struct {
+	Primary Backend
+	Replica Backend
+	// PrimaryName and ReplicaName are how the errors refer to the two, such
+	// as s3://bucket.
+	PrimaryName string
+	ReplicaName string
+	// OnFallback is called for each read served from Replica, with the
+	// error Primary gave.
+	OnFallback func(op, key string, err error)
+
+	// writing is set for the store a write checks the object through, its
+	// reads go to Primary only
+	writing bool
+
+	usedReplica atomic.Bool
+
+	// replicaVersions are the versions Head, List and Versions handed out
+	// from Replica, the only ones Get reads from it
+	mu              sync.Mutex
+	replicaVersions map[objectVersion]bool
+}
+
+type objectVersion struct {
+	key, versionID string
+}
+
+// writer is the store for a write, whose reads of what it replaces have to
+// be Primary's.
+func (r *ReplicaStore) writer() *ReplicaStore {
+	return &ReplicaStore{Primary: r.Primary, Replica: r.Replica, PrimaryName: r.PrimaryName, ReplicaName: r.ReplicaName, writing: true}
+}
+
+// UsedReplica reports whether any read was served from the replica.
+func (r *ReplicaStore) UsedReplica() bool {
+	return r.usedReplica.Load()
+}
+
+// fallback reports whether the read that failed with err is tried on the
+// replica, and says so when it is
+func (r *ReplicaStore) fallback(ctx context.Context, op, key string, err error) bool {
+	// a cancelled run isn't an outage
+	if r.writing || ctx.Err() != nil || !Unavailable(err) {
+		return false
+	}
+	r.usedReplica.Store(true)
+	if r.OnFallback != nil {
+		r.OnFallback(op, key, err)
+	}
+	return true
+}
+
+func (r *ReplicaStore) Get(ctx context.Context, in GetInput, w io.WriterAt) (int64, error) {
+	n, err := r.Primary.Get(ctx, in, w)
+	if !r.fallback(ctx, "get", in.Key, err) {
+		return n, r.primaryOnly(ctx, err)
+	}
+	// the replica has its own version IDs, one of the primary's would be another version there or none
+	if in.VersionID != "" && !r.fromReplica(in.Key, in.VersionID) {
+		return n, fmt.Errorf("%w: %s can't be reached, and version %s of %s is one of its own, %s has other version IDs: %w", ErrPrimaryVersion, r.PrimaryName, in.VersionID, in.Key, r.ReplicaName, err)
+	}
+	if n, err = r.Replica.Get(ctx, in, w); err != nil {
+		return n, err
+	}
+	// what the primary wrote before it failed can be longer than the replica's copy
+	if t, ok := w.(interface{ Truncate(int64) error }); ok {
+		if err := t.Truncate(n); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (r *ReplicaStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := r.Primary.Head(ctx, key)
+	if !r.fallback(ctx, "head", key, err) {
+		return info, r.primaryOnly(ctx, err)
+	}
+	info, err = r.Replica.Head(ctx, key)
+	r.remember(key, info)
+	return info, err
+}
+
+func (r *ReplicaStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects, err := r.Primary.List(ctx, prefix)
+	if !r.fallback(ctx, "list", prefix, err) {
+		return objects, r.primaryOnly(ctx, err)
+	}
+	objects, err = r.Replica.List(ctx, prefix)
+	for _, o := range objects {
+		r.remember(o.Key, o)
+	}
+	return objects, err
+}
+
+func (r *ReplicaStore) Versions(ctx context.Context, key string) ([]ObjectInfo, error) {
+	versions, err := r.Primary.Versions(ctx, key)
+	if !r.fallback(ctx, "versions", key, err) {
+		return versions, r.primaryOnly(ctx, err)
+	}
+	versions, err = r.Replica.Versions(ctx, key)
+	r.remember(key, versions...)
+	return versions, err
+}
+
+// remember records the versions of key that were handed out from Replica
+func (r *ReplicaStore) remember(key string, objects ...ObjectInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.replicaVersions == nil {
+		r.replicaVersions = map[objectVersion]bool{}
+	}
+	for _, o := range objects {
+		if o.VersionID != "" {
+			r.replicaVersions[objectVersion{key, o.VersionID}] = true
+		}
+	}
+}
+
+// fromReplica reports whether the version was handed out from Replica
+func (r *ReplicaStore) fromReplica(key, versionID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.replicaVersions[objectVersion{key, versionID}]
+}
+
+func (r *ReplicaStore) Put(ctx context.Context, in PutInput) (ObjectInfo, error) {
+	info, err := r.Primary.Put(ctx, in)
+	return info, r.primaryOnly(ctx, err)
+}
+
+func (r *ReplicaStore) Delete(ctx context.Context, key string) error {
+	return r.primaryOnly(ctx, r.Primary.Delete(ctx, key))
+}
+
+// Copy copies within Primary, like Copy does for a store that isn't a Copier
+// when Primary isn't one either.
+func (r *ReplicaStore) Copy(ctx context.Context, in CopyInput) (ObjectInfo, error) {
+	info, err := Copy(ctx, r.Primary, in)
+	return info, r.primaryOnly(ctx, err)
+}
+
+// Restore restores the object in Primary, ErrUnsupported when Primary can't.
+func (r *ReplicaStore) Restore(ctx context.Context, in RestoreInput) error {
+	restorer, ok := r.Primary.(Restorer)
+	if !ok {
+		return ErrUnsupported
+	}
+	return r.primaryOnly(ctx, restorer.Restore(ctx, in))
+}
+
+// PublicAccess is Primary's, the bucket uploads go to.
+func (r *ReplicaStore) PublicAccess(ctx context.Context) (PublicAccess, error) {
+	checker, ok := r.Primary.(PublicAccessChecker)
+	if !ok {
+		return PublicAccess{}, ErrUnsupported
+	}
+	return checker.PublicAccess(ctx)
+}
+
+// OwnedBy is Primary's, the bucket uploads go to.
+func (r *ReplicaStore) OwnedBy(ctx context.Context, account string) (bool, error) {
+	checker, ok := r.Primary.(OwnershipChecker)
+	if !ok {
+		return false, ErrUnsupported
+	}
+	return checker.OwnedBy(ctx, account)
+}
+
+// ACL is Primary's, the bucket uploads go to.
+func (r *ReplicaStore) ACL(ctx context.Context) (BucketACL, error) {
+	checker, ok := r.Primary.(OwnershipChecker)
+	if !ok {
+		return BucketACL{}, ErrUnsupported
+	}
+	return checker.ACL(ctx)
+}
+
+// primaryOnly is ErrPrimaryOnly for a write, or a read for one, that failed
+// because Primary can't be reached.
+func (r *ReplicaStore) primaryOnly(ctx context.Context, err error) error {
+	if ctx.Err() != nil || !Unavailable(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %s can't be reached, and %s is only read from when it can't: %w", ErrPrimaryOnly, r.PrimaryName, r.ReplicaName, err)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestUnavailable(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{fmt.Errorf("get: %w", refused), true},
+		{context.DeadlineExceeded, true},
+		{ErrObjectNotFound, false},
+		{ErrAccessDenied, false},
+		{context.Canceled, false},
+	} {
+		if got := Unavailable(tc.err); got != tc.want {
+			t.Errorf("Unavailable(%v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestReplicaStore(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := NewMemoryStore(), NewMemoryStore()
+	primary.Put(ctx, PutInput{Key: "team/dev.tfvars", Body: bytes.NewReader([]byte("primary = true\n"))})
+	replica.Put(ctx, PutInput{Key: "team/dev.tfvars", Body: bytes.NewReader([]byte("a = 1\n"))})
+	var fallbacks []string
+	store := &ReplicaStore{Primary: primary, Replica: replica, PrimaryName: "s3://tfvars", ReplicaName: "s3://tfvars-dr", OnFallback: func(op, key string, err error) {
+		fallbacks = append(fallbacks, op+" "+key)
+	}}
+
+	if _, err := store.Head(ctx, "team/dev.tfvars"); err != nil || store.UsedReplica() {
+		t.Fatalf("Head() with the primary up = %v, used the replica %t", err, store.UsedReplica())
+	}
+	primary.HeadErr = ErrObjectNotFound
+	if _, err := store.Head(ctx, "team/dev.tfvars"); !errors.Is(err, ErrObjectNotFound) || store.UsedReplica() {
+		t.Errorf("Head() of a missing object = %v, want the primary's answer", err)
+	}
+
+	outage := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	primary.HeadErr, primary.GetErr, primary.PutErr = outage, outage, outage
+	if info, err := store.Head(ctx, "team/dev.tfvars"); err != nil || info.Size != 6 {
+		t.Errorf("Head() in an outage = %+v, %v, want the replica's object", info, err)
+	}
+	// the primary's longer copy was half written when it failed
+	fileName := filepath.Join(t.TempDir(), "dev.tfvars")
+	os.WriteFile(fileName, []byte("primary = tr"), 0o644)
+	file, _ := os.OpenFile(fileName, os.O_RDWR, 0)
+	if _, err := store.Get(ctx, GetInput{Key: "team/dev.tfvars"}, file); err != nil {
+		t.Fatalf("Get() in an outage: %v", err)
+	}
+	file.Close()
+	if data, _ := os.ReadFile(fileName); string(data) != "a = 1\n" {
+		t.Errorf("Get() wrote %q, want the replica's object and nothing else", data)
+	}
+	if !store.UsedReplica() || len(fallbacks) != 2 {
+		t.Errorf("fallbacks %q", fallbacks)
+	}
+
+	_, err := store.Put(ctx, PutInput{Key: "team/dev.tfvars", Body: bytes.NewReader(nil)})
+	if !errors.Is(err, ErrPrimaryOnly) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Put() in an outage = %v, want ErrPrimaryOnly", err)
+	}
+	if replica.Puts() != 1 {
+		t.Error("a write went to the replica")
+	}
+	primary.PutErr = ErrAccessDenied
+	if _, err := store.Put(ctx, PutInput{Key: "team/dev.tfvars", Body: bytes.NewReader(nil)}); errors.Is(err, ErrPrimaryOnly) || !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Put() refused by the primary = %v, want its own error", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := store.Head(cancelled, "team/dev.tfvars"); !errors.Is(err, outage) || len(fallbacks) != 2 {
+		t.Errorf("Head() of a cancelled run = %v, want no fallback", err)
+	}
+}
+
+func TestReplicaStoreVersions(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := NewMemoryStore(), NewMemoryStore()
+	for _, content := range []string{"a = 1\n", "a = 2\n"} {
+		primary.Put(ctx, PutInput{Key: "dev.tfvars", Body: bytes.NewReader([]byte(content))})
+	}
+	// replication wrote the same two versions under its own IDs, after an object of its own
+	replica.Put(ctx, PutInput{Key: "other.tfvars", Body: bytes.NewReader(nil)})
+	for _, content := range []string{"a = 1\n", "a = 2\n"} {
+		replica.Put(ctx, PutInput{Key: "dev.tfvars", Body: bytes.NewReader([]byte(content))})
+	}
+	store := &ReplicaStore{Primary: primary, Replica: replica, PrimaryName: "s3://tfvars", ReplicaName: "s3://tfvars-dr"}
+	outage := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	primary.GetErr, primary.HeadErr, primary.VersionsErr = outage, outage, outage
+
+	// v1 is the primary's first version, and isn't a version of dev.tfvars in the replica at all
+	if _, err := GetVersionBytes(ctx, store, "dev.tfvars", "v1"); !errors.Is(err, ErrPrimaryVersion) {
+		t.Errorf("GetVersionBytes() of the primary's version in an outage = %v, want ErrPrimaryVersion", err)
+	}
+	// the ones Head and Versions got from the replica can be read
+	if info, err := store.Head(ctx, "dev.tfvars"); err != nil {
+		t.Fatal(err)
+	} else if data, err := GetVersionBytes(ctx, store, "dev.tfvars", info.VersionID); err != nil || string(data) != "a = 2\n" {
+		t.Errorf("GetVersionBytes() of the version Head gave = %q, %v", data, err)
+	}
+	versions, err := store.Versions(ctx, "dev.tfvars")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("Versions() in an outage = %+v, %v", versions, err)
+	}
+	want := map[string]string{"v2": "a = 1\n", "v3": "a = 2\n"}
+	for _, v := range versions {
+		data, err := GetVersionBytes(ctx, store, "dev.tfvars", v.VersionID)
+		if want := want[v.VersionID]; err != nil || string(data) != want {
+			t.Errorf("GetVersionBytes(%s) = %q, %v, want the replica's %q", v.VersionID, data, err, want)
+		}
+	}
+}
+
+func TestReplicaStoreGetBytes(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := NewMemoryStore(), NewMemoryStore()
+	replica.Put(ctx, PutInput{Key: "dev.tfvars", Body: bytes.NewReader([]byte("a = 1\n"))})
+	// the primary got part of a longer object out before it failed
+	store := &ReplicaStore{Primary: failingStore{Backend: primary, written: "primary = tr"}, Replica: replica}
+	if data, err := GetBytes(ctx, store, "dev.tfvars"); err != nil || string(data) != "a = 1\n" {
+		t.Errorf("GetBytes() = %q, %v, want the replica's object and nothing else", data, err)
+	}
+}
+
+// failingStore writes some of an object and then fails as if its region were down
+type failingStore struct {
+	Backend
+	written string
+}
+
+func (f failingStore) Get(_ context.Context, _ GetInput, w io.WriterAt) (int64, error) {
+	n, _ := w.WriteAt([]byte(f.written), 0)
+	return int64(n), io.ErrUnexpectedEOF
+}
+
+func TestUploadKeyIgnoresReplica(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := NewMemoryStore(), NewMemoryStore()
+	fileName := filepath.Join(t.TempDir(), "dev.tfvars")
+	os.WriteFile(fileName, []byte("a = 1\n"), 0o644)
+	if _, err := UploadKey(ctx, replica, "dev.tfvars", fileName, UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	primary.HeadErr = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	primary.PutErr = primary.HeadErr
+	store := &ReplicaStore{Primary: primary, Replica: replica}
+	// the replica has the same file, which mustn't make the upload a no-op
+	res, err := UploadKey(ctx, store, "dev.tfvars", fileName, UploadOptions{SkipUnchanged: true})
+	if !errors.Is(err, ErrPrimaryOnly) || res.Skipped || store.UsedReplica() {
+		t.Errorf("UploadKey() in an outage = %+v, %v, want ErrPrimaryOnly", res, err)
+	}
+}
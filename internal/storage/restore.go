@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// StorageClasses are the S3 storage classes objects can be put in.
+var StorageClasses = []string{"STANDARD", "STANDARD_IA", "ONEZONE_IA", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"}
+
+// RestoreTiers are how fast a restore is done, and how much it costs.
+var RestoreTiers = []string{"Expedited", "Standard", "Bulk"}
+
+// NeedsRestore is true for the storage classes whose objects can't be read
+// until they are restored.
+func NeedsRestore(class string) bool {
+	return class == "GLACIER" || class == "DEEP_ARCHIVE"
+}
+
+// SameStorageClass compares two classes, with empty the same as STANDARD.
+func SameStorageClass(a, b string) bool {
+	normal := func(class string) string {
+		if class == "" {
+			return "STANDARD"
+		}
+		return strings.ToUpper(class)
+	}
+	return normal(a) == normal(b)
+}
+
+// ValidStorageClass checks class is one of StorageClasses.
+func ValidStorageClass(class string) error {
+	if !slices.Contains(StorageClasses, class) {
+		return fmt.Errorf("unknown storage class %q, use one of %s", class, strings.Join(StorageClasses, ", "))
+	}
+	return nil
+}
+
+// RestoreStatus is where a restore of an archived object is at. Both fields
+// are zero when no restore was asked for.
+type RestoreStatus struct {
+	InProgress bool
+	// Expiry is when the restored copy goes away again, set once the
+	// restore is done.
+	Expiry time.Time
+}
+
+// Restored is true while a restored copy can be read.
+func (r RestoreStatus) Restored() bool {
+	return !r.InProgress && !r.Expiry.IsZero()
+}
+
+// String describes the status for the list command.
+func (r RestoreStatus) String() string {
+	switch {
+	case r.InProgress:
+		return "restoring"
+	case r.Restored():
+		return "restored until " + r.Expiry.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+// RestoreInput asks for a temporary copy of an archived object that can be
+// read for Days days, made with Tier.
+type RestoreInput struct {
+	Key  string
+	Days int
+	Tier string
+}
+
+// Restorer is implemented by the backends with archive storage classes.
+type Restorer interface {
+	Restore(ctx context.Context, in RestoreInput) error
+}
+
+// Restore starts a restore with RestoreObject. One already in progress is
+// ErrRestoreInProgress.
+func (s *S3Store) Restore(ctx context.Context, in RestoreInput) error {
+	_, err := s.Client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(in.Key),
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(int32(in.Days)),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: types.Tier(in.Tier)},
+		},
+	})
+	return mapS3Error(err, s.Bucket, in.Key)
+}
+
+// parseRestoreHeader reads the x-amz-restore header HeadObject gives back,
+// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+func parseRestoreHeader(header string) RestoreStatus {
+	var status RestoreStatus
+	if header == "" {
+		return status
+	}
+	status.InProgress = strings.Contains(header, `ongoing-request="true"`)
+	if _, rest, ok := strings.Cut(header, `expiry-date="`); ok {
+		if date, _, ok := strings.Cut(rest, `"`); ok {
+			status.Expiry, _ = time.Parse(time.RFC1123, date)
+		}
+	}
+	return status
+}
+
+func restoreStatus(r *types.RestoreStatus) RestoreStatus {
+	if r == nil {
+		return RestoreStatus{}
+	}
+	return RestoreStatus{InProgress: aws.ToBool(r.IsRestoreInProgress), Expiry: aws.ToTime(r.RestoreExpiryDate)}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRestoreHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   RestoreStatus
+	}{
+		{"", RestoreStatus{}},
+		{`ongoing-request="true"`, RestoreStatus{InProgress: true}},
+		{`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`, RestoreStatus{Expiry: time.Date(2012, 12, 21, 0, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		got := parseRestoreHeader(tt.header)
+		if got.InProgress != tt.want.InProgress || !got.Expiry.Equal(tt.want.Expiry) {
+			t.Errorf("parseRestoreHeader(%q) = %+v, want %+v", tt.header, got, tt.want)
+		}
+	}
+	if !parseRestoreHeader(`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`).Restored() {
+		t.Error("a finished restore isn't Restored()")
+	}
+}
+
+func TestMemoryRestore(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+	m.RestorePolls = 1
+	if _, err := PutBytesWith(ctx, m, "team/dev.tfvars", []byte("a = 1\n"), UploadOptions{StorageClass: "DEEP_ARCHIVE"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetBytes(ctx, m, "team/dev.tfvars"); !errors.Is(err, ErrArchived) {
+		t.Fatalf("GetBytes() of an archived object = %v, want ErrArchived", err)
+	}
+	if err := m.Restore(ctx, RestoreInput{Key: "team/dev.tfvars", Days: 1, Tier: "Bulk"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Restore(ctx, RestoreInput{Key: "team/dev.tfvars", Days: 1, Tier: "Bulk"}); !errors.Is(err, ErrRestoreInProgress) {
+		t.Errorf("second Restore() = %v, want ErrRestoreInProgress", err)
+	}
+	if info, _ := m.Head(ctx, "team/dev.tfvars"); !info.Restore.InProgress {
+		t.Errorf("Head() during the restore = %+v", info.Restore)
+	}
+	if info, _ := m.Head(ctx, "team/dev.tfvars"); !info.Restore.Restored() || info.StorageClass != "DEEP_ARCHIVE" {
+		t.Errorf("Head() after the restore = %+v", info)
+	}
+	if data, err := GetBytes(ctx, m, "team/dev.tfvars"); err != nil || string(data) != "a = 1\n" {
+		t.Errorf("GetBytes() after the restore = %q, %v", data, err)
+	}
+}
+
+func TestUploadKeyMovesStorageClass(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	writeFile(t, "dev.tfvars", "a = 1\n")
+	ctx := context.Background()
+	m := NewMemoryStore()
+	if _, err := Upload(ctx, m, "team/", "dev.tfvars", UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Upload(ctx, m, "team/", "dev.tfvars", UploadOptions{SkipUnchanged: true, StorageClass: "STANDARD_IA"})
+	if err != nil || res.Skipped {
+		t.Fatalf("Upload() into another class = %+v, %v, want it uploaded again", res, err)
+	}
+	if info, _ := m.Head(ctx, "team/dev.tfvars"); info.StorageClass != "STANDARD_IA" {
+		t.Errorf("storage class = %q, want STANDARD_IA", info.StorageClass)
+	}
+	if res, _ := Upload(ctx, m, "team/", "dev.tfvars", UploadOptions{SkipUnchanged: true, StorageClass: "STANDARD_IA"}); !res.Skipped {
+		t.Error("an unchanged upload in the same class wasn't skipped")
+	}
+}
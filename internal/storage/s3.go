@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Store is the Backend backed by a real S3 bucket. Transfers go through the
+// s3 manager so large files are sent as multipart uploads.
+type S3Store struct {
+	Client *s3.Client
+	Bucket string
+}
+
+// S3ClientOptions are the client settings needed for S3 compatible endpoints
+// such as LocalStack or MinIO.
+type S3ClientOptions struct {
+	// Endpoint overrides the S3 endpoint URL when set.
+	Endpoint string
+	// UsePathStyle addresses buckets as endpoint/bucket instead of bucket.endpoint.
+	UsePathStyle bool
+}
+
+// NewS3Client creates the S3 client with the endpoint options applied.
+func NewS3Client(cfg aws.Config, opts S3ClientOptions) *s3.Client {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+		o.UsePathStyle = opts.UsePathStyle
+	})
+}
+
+// NewS3Store creates a store for the bucket using the given client.
+func NewS3Store(client *s3.Client, bucket string) *S3Store {
+	return &S3Store{Client: client, Bucket: bucket}
+}
+
+func (s *S3Store) Put(ctx context.Context, in PutInput) (ObjectInfo, error) {
+	uploader := manager.NewUploader(s.Client)
+	put := &s3.PutObjectInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(in.Key),
+		Body:     in.Body,
+		Metadata: in.Metadata,
+	}
+	if in.ContentType != "" {
+		put.ContentType = aws.String(in.ContentType)
+	}
+	if in.CacheControl != "" {
+		put.CacheControl = aws.String(in.CacheControl)
+	}
+	if in.ContentDisposition != "" {
+		put.ContentDisposition = aws.String(in.ContentDisposition)
+	}
+	if in.KMSKeyID != "" {
+		put.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		put.SSEKMSKeyId = aws.String(in.KMSKeyID)
+	}
+	if in.StorageClass != "" {
+		put.StorageClass = types.StorageClass(in.StorageClass)
+	}
+	if in.IfMatch != "" {
+		put.IfMatch = aws.String(in.IfMatch)
+	}
+	out, err := uploader.Upload(ctx, put)
+	if err != nil {
+		err = mapS3Error(err, s.Bucket, in.Key)
+		// a conditional write to a key that is gone fails with 404
+		if in.IfMatch != "" && errors.Is(err, ErrObjectNotFound) {
+			err = fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:          in.Key,
+		ETag:         aws.ToString(out.ETag),
+		VersionID:    aws.ToString(out.VersionID),
+		Metadata:     in.Metadata,
+		KMSKeyID:     aws.ToString(out.SSEKMSKeyId),
+		ContentType:  in.ContentType,
+		StorageClass: storageClass(types.ObjectStorageClass(in.StorageClass)),
+	}, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, in GetInput, w io.WriterAt) (int64, error) {
+	downloader := manager.NewDownloader(s.Client)
+	get := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(in.Key),
+	}
+	if in.VersionID != "" {
+		get.VersionId = aws.String(in.VersionID)
+	}
+	n, err := downloader.Download(ctx, w, get)
+	return n, mapS3Error(err, s.Bucket, in.Key)
+}
+
+func (s *S3Store) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, mapS3Error(err, s.Bucket, key)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ETag:         aws.ToString(out.ETag),
+		VersionID:    aws.ToString(out.VersionId),
+		LastModified: aws.ToTime(out.LastModified),
+		Metadata:     out.Metadata,
+		KMSKeyID:     aws.ToString(out.SSEKMSKeyId),
+		ContentType:  aws.ToString(out.ContentType),
+		StorageClass: storageClass(types.ObjectStorageClass(out.StorageClass)),
+		Restore:      parseRestoreHeader(aws.ToString(out.Restore)),
+	}, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket:                   aws.String(s.Bucket),
+		Prefix:                   aws.String(prefix),
+		OptionalObjectAttributes: []types.OptionalObjectAttributes{types.OptionalObjectAttributesRestoreStatus},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapS3Error(err, s.Bucket, prefix)
+		}
+		for _, o := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(o.Key),
+				Size:         aws.ToInt64(o.Size),
+				ETag:         aws.ToString(o.ETag),
+				LastModified: aws.ToTime(o.LastModified),
+				StorageClass: storageClass(o.StorageClass),
+				Restore:      restoreStatus(o.RestoreStatus),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// Versions lists the versions of key, a bucket without versioning has only
+// the current one with version ID null.
+func (s *S3Store) Versions(ctx context.Context, key string) ([]ObjectInfo, error) {
+	var versions []ObjectInfo
+	paginator := s3.NewListObjectVersionsPaginator(s.Client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(key),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapS3Error(err, s.Bucket, key)
+		}
+		for _, v := range page.Versions {
+			if aws.ToString(v.Key) != key {
+				continue
+			}
+			versions = append(versions, ObjectInfo{
+				Key:          key,
+				Size:         aws.ToInt64(v.Size),
+				ETag:         aws.ToString(v.ETag),
+				VersionID:    aws.ToString(v.VersionId),
+				LastModified: aws.ToTime(v.LastModified),
+			})
+		}
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: s3://%s/%s", ErrObjectNotFound, s.Bucket, key)
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].LastModified.After(versions[j].LastModified) })
+	return versions, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	return mapS3Error(err, s.Bucket, key)
+}
+
+// storageClass leaves STANDARD out, so ObjectInfo only names the classes
+// worth mentioning
+func storageClass(class types.ObjectStorageClass) string {
+	if class == types.ObjectStorageClassStandard {
+		return ""
+	}
+	return string(class)
+}
@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// SecretsManagerMaxSize is the largest secret value Secrets Manager accepts.
+const SecretsManagerMaxSize = 64 * 1024
+
+// SecretVersion is one version of a secret. Stages are its staging labels,
+// such as AWSCURRENT and AWSPREVIOUS.
+type SecretVersion struct {
+	VersionID   string
+	Stages      []string
+	CreatedDate time.Time
+}
+
+// SecretValue is a secret version with its value.
+type SecretValue struct {
+	SecretVersion
+	Value string
+}
+
+// SecretsManagerAPI is the part of Secrets Manager the store uses.
+type SecretsManagerAPI interface {
+	// GetSecretValue reads the version, or AWSCURRENT when it is empty.
+	GetSecretValue(ctx context.Context, name, versionID string) (SecretValue, error)
+	PutSecretValue(ctx context.Context, name, value string) (string, error)
+	CreateSecret(ctx context.Context, name, value, kmsKeyID string) (string, error)
+	ListSecrets(ctx context.Context, prefix string) ([]string, error)
+	ListSecretVersionIDs(ctx context.Context, name string) ([]SecretVersion, error)
+	DeleteSecret(ctx context.Context, name string) error
+}
+
+// SecretsManagerStore is the Backend backed by AWS Secrets Manager. Keys are
+// secret names, a missing secret is created on the first Put with KMSKeyID,
+// or the account's aws/secretsmanager key when it is empty. Secrets have no
+// metadata of their own, so only the checksum is known and it is worked out
+// from the value.
+type SecretsManagerStore struct {
+	Client   SecretsManagerAPI
+	KMSKeyID string
+}
+
+// NewSecretsManagerStore creates a store using the given client.
+func NewSecretsManagerStore(client SecretsManagerAPI, kmsKeyID string) *SecretsManagerStore {
+	return &SecretsManagerStore{Client: client, KMSKeyID: kmsKeyID}
+}
+
+func (s *SecretsManagerStore) Put(ctx context.Context, in PutInput) (ObjectInfo, error) {
+	if in.IfMatch != "" {
+		return ObjectInfo{}, fmt.Errorf("conditional writes: %w", ErrUnsupported)
+	}
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(data) > SecretsManagerMaxSize {
+		return ObjectInfo{}, fmt.Errorf("%w: secret %s would be %d bytes but Secrets Manager holds at most 64KB, keep this file in S3 instead", ErrTooLarge, in.Key, len(data))
+	}
+	version, err := s.Client.PutSecretValue(ctx, in.Key, string(data))
+	if errors.Is(err, ErrObjectNotFound) {
+		version, err = s.Client.CreateSecret(ctx, in.Key, string(data), s.KMSKeyID)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	sum := sha256.Sum256(data)
+	return ObjectInfo{
+		Key:       in.Key,
+		Size:      int64(len(data)),
+		ETag:      `"` + hex.EncodeToString(sum[:]) + `"`,
+		VersionID: version,
+		Metadata:  in.Metadata,
+	}, nil
+}
+
+func (s *SecretsManagerStore) Get(ctx context.Context, in GetInput, w io.WriterAt) (int64, error) {
+	secret, err := s.Client.GetSecretValue(ctx, in.Key, in.VersionID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.WriteAt([]byte(secret.Value), 0)
+	return int64(n), err
+}
+
+func (s *SecretsManagerStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	secret, err := s.Client.GetSecretValue(ctx, key, "")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	sum := sha256.Sum256([]byte(secret.Value))
+	checksum := hex.EncodeToString(sum[:])
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(secret.Value)),
+		ETag:         `"` + checksum + `"`,
+		VersionID:    secret.VersionID,
+		LastModified: secret.CreatedDate,
+		Metadata:     map[string]string{ChecksumMetadataKey: checksum},
+	}, nil
+}
+
+// List gives the names of the secrets starting with prefix.
+func (s *SecretsManagerStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	names, err := s.Client.ListSecrets(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var out []ObjectInfo
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			out = append(out, ObjectInfo{Key: name})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// Versions gives the secret's versions newest first, with their staging
+// labels in the "stages" metadata.
+func (s *SecretsManagerStore) Versions(ctx context.Context, key string) ([]ObjectInfo, error) {
+	versions, err := s.Client.ListSecretVersionIDs(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var out []ObjectInfo
+	for _, v := range versions {
+		out = append(out, ObjectInfo{
+			Key:          key,
+			VersionID:    v.VersionID,
+			LastModified: v.CreatedDate,
+			Metadata:     map[string]string{"stages": strings.Join(v.Stages, ",")},
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastModified.After(out[j].LastModified) })
+	return out, nil
+}
+
+// Delete schedules the secret for deletion with the default recovery window,
+// so it can still be restored for a while.
+func (s *SecretsManagerStore) Delete(ctx context.Context, key string) error {
+	return s.Client.DeleteSecret(ctx, key)
+}
+
+// SecretsManagerClient calls the Secrets Manager JSON API directly, signed
+// with the credentials in Config.
+type SecretsManagerClient struct {
+	Config aws.Config
+	// Endpoint overrides https://secretsmanager.<region>.amazonaws.com.
+	Endpoint string
+}
+
+// NewSecretsManagerClient creates a client for the region in cfg.
+func NewSecretsManagerClient(cfg aws.Config) *SecretsManagerClient {
+	return &SecretsManagerClient{Config: cfg}
+}
+
+// secretsManagerError is the body of a failed call
+type secretsManagerError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+	// some errors spell it with a capital M
+	MessageUpper string `json:"Message"`
+}
+
+func (c *SecretsManagerClient) call(ctx context.Context, name, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + c.Config.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager."+action)
+
+	creds, err := c.Config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	}
+	sum := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "secretsmanager", c.Config.Region, time.Now()); err != nil {
+		return err
+	}
+
+	var client aws.HTTPClient = http.DefaultClient
+	if c.Config.HTTPClient != nil {
+		client = c.Config.HTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e secretsManagerError
+		json.Unmarshal(data, &e)
+		code := e.Type[strings.LastIndex(e.Type, "#")+1:]
+		msg := e.Message + e.MessageUpper
+		location := "secretsmanager://" + name
+		switch code {
+		case "ResourceNotFoundException":
+			return fmt.Errorf("%w: %s: %s", ErrObjectNotFound, location, msg)
+		case "AccessDeniedException":
+			return fmt.Errorf("%w: %s: %s", ErrAccessDenied, location, msg)
+		}
+		return fmt.Errorf("%s %s: %s (%s, status %d)", action, location, msg, code, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// epochSeconds is how the JSON API sends timestamps
+type epochSeconds float64
+
+func (e epochSeconds) time() time.Time {
+	sec, frac := math.Modf(float64(e))
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
+
+func (c *SecretsManagerClient) GetSecretValue(ctx context.Context, name, versionID string) (SecretValue, error) {
+	var out struct {
+		VersionId     string
+		VersionStages []string
+		CreatedDate   epochSeconds
+		SecretString  string
+	}
+	in := map[string]string{"SecretId": name}
+	if versionID != "" {
+		in["VersionId"] = versionID
+	}
+	if err := c.call(ctx, name, "GetSecretValue", in, &out); err != nil {
+		return SecretValue{}, err
+	}
+	return SecretValue{
+		SecretVersion: SecretVersion{VersionID: out.VersionId, Stages: out.VersionStages, CreatedDate: out.CreatedDate.time()},
+		Value:         out.SecretString,
+	}, nil
+}
+
+func (c *SecretsManagerClient) PutSecretValue(ctx context.Context, name, value string) (string, error) {
+	var out struct{ VersionId string }
+	err := c.call(ctx, name, "PutSecretValue", map[string]string{"SecretId": name, "SecretString": value}, &out)
+	return out.VersionId, err
+}
+
+func (c *SecretsManagerClient) CreateSecret(ctx context.Context, name, value, kmsKeyID string) (string, error) {
+	in := map[string]string{"Name": name, "SecretString": value, "Description": "tfvars uploaded by tfmanage"}
+	if kmsKeyID != "" {
+		in["KmsKeyId"] = kmsKeyID
+	}
+	var out struct{ VersionId string }
+	err := c.call(ctx, name, "CreateSecret", in, &out)
+	return out.VersionId, err
+}
+
+func (c *SecretsManagerClient) ListSecrets(ctx context.Context, prefix string) ([]string, error) {
+	in := map[string]any{"Filters": []map[string]any{{"Key": "name", "Values": []string{prefix}}}}
+	var names []string
+	for {
+		var out struct {
+			SecretList []struct{ Name string }
+			NextToken  string
+		}
+		if err := c.call(ctx, prefix, "ListSecrets", in, &out); err != nil {
+			return nil, err
+		}
+		for _, s := range out.SecretList {
+			names = append(names, s.Name)
+		}
+		if out.NextToken == "" {
+			return names, nil
+		}
+		in["NextToken"] = out.NextToken
+	}
+}
+
+func (c *SecretsManagerClient) ListSecretVersionIDs(ctx context.Context, name string) ([]SecretVersion, error) {
+	in := map[string]any{"SecretId": name, "IncludeDeprecated": true}
+	var versions []SecretVersion
+	for {
+		var out struct {
+			Versions []struct {
+				VersionId     string
+				VersionStages []string
+				CreatedDate   epochSeconds
+			}
+			NextToken string
+		}
+		if err := c.call(ctx, name, "ListSecretVersionIds", in, &out); err != nil {
+			return nil, err
+		}
+		for _, v := range out.Versions {
+			versions = append(versions, SecretVersion{VersionID: v.VersionId, Stages: v.VersionStages, CreatedDate: v.CreatedDate.time()})
+		}
+		if out.NextToken == "" {
+			return versions, nil
+		}
+		in["NextToken"] = out.NextToken
+	}
+}
+
+func (c *SecretsManagerClient) DeleteSecret(ctx context.Context, name string) error {
+	return c.call(ctx, name, "DeleteSecret", map[string]string{"SecretId": name}, nil)
+}
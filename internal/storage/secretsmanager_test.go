@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// fakeSecrets keeps every version of every secret, the last one is AWSCURRENT
+type fakeSecrets struct {
+	secrets map[string][]SecretValue
+	kmsKeys map[string]string
+}
+
+func newFakeSecrets() *fakeSecrets {
+	return &fakeSecrets{secrets: map[string][]SecretValue{}, kmsKeys: map[string]string{}}
+}
+
+func (f *fakeSecrets) add(name, value string) string {
+	versions := f.secrets[name]
+	for i := range versions {
+		versions[i].Stages = nil
+	}
+	if len(versions) > 0 {
+		versions[len(versions)-1].Stages = []string{"AWSPREVIOUS"}
+	}
+	id := fmt.Sprintf("%s-v%d", name, len(versions)+1)
+	created := time.Date(2024, 5, 1, 12, len(versions), 0, 0, time.UTC)
+	f.secrets[name] = append(versions, SecretValue{SecretVersion{id, []string{"AWSCURRENT"}, created}, value})
+	return id
+}
+
+func (f *fakeSecrets) GetSecretValue(ctx context.Context, name, versionID string) (SecretValue, error) {
+	versions, ok := f.secrets[name]
+	if !ok {
+		return SecretValue{}, fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	}
+	if versionID == "" {
+		return versions[len(versions)-1], nil
+	}
+	for _, v := range versions {
+		if v.VersionID == versionID {
+			return v, nil
+		}
+	}
+	return SecretValue{}, fmt.Errorf("%w: %s version %s", ErrObjectNotFound, name, versionID)
+}
+
+func (f *fakeSecrets) PutSecretValue(ctx context.Context, name, value string) (string, error) {
+	if _, ok := f.secrets[name]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	}
+	return f.add(name, value), nil
+}
+
+func (f *fakeSecrets) CreateSecret(ctx context.Context, name, value, kmsKeyID string) (string, error) {
+	f.kmsKeys[name] = kmsKeyID
+	return f.add(name, value), nil
+}
+
+func (f *fakeSecrets) ListSecrets(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	for name := range f.secrets {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (f *fakeSecrets) ListSecretVersionIDs(ctx context.Context, name string) ([]SecretVersion, error) {
+	if _, ok := f.secrets[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	}
+	var versions []SecretVersion
+	for _, v := range f.secrets[name] {
+		versions = append(versions, v.SecretVersion)
+	}
+	return versions, nil
+}
+
+func (f *fakeSecrets) DeleteSecret(ctx context.Context, name string) error {
+	delete(f.secrets, name)
+	return nil
+}
+
+func TestSecretsManagerStore(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeSecrets()
+	store := NewSecretsManagerStore(fake, "alias/prod")
+
+	if _, err := store.Put(ctx, PutInput{Key: "tfvars/prod", Body: strings.NewReader("a = 1\n")}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.kmsKeys["tfvars/prod"] != "alias/prod" {
+		t.Errorf("the secret was created with KMS key %q", fake.kmsKeys["tfvars/prod"])
+	}
+	info, err := store.Put(ctx, PutInput{Key: "tfvars/prod", Body: strings.NewReader("a = 2\n")})
+	if err != nil || info.VersionID != "tfvars/prod-v2" {
+		t.Fatalf("second Put() = %+v, %v", info, err)
+	}
+
+	var buf writeAtBuffer
+	if _, err := store.Get(ctx, GetInput{Key: "tfvars/prod"}, &buf); err != nil || string(buf.data) != "a = 2\n" {
+		t.Errorf("Get() = %q, %v", buf.data, err)
+	}
+	head, err := store.Head(ctx, "tfvars/prod")
+	if err != nil || head.ETag != info.ETag || head.Metadata[ChecksumMetadataKey] == "" {
+		t.Errorf("Head() = %+v, %v, want the etag Put gave back", head, err)
+	}
+
+	versions, err := store.Versions(ctx, "tfvars/prod")
+	if err != nil || len(versions) != 2 || versions[0].Metadata["stages"] != "AWSCURRENT" || versions[1].Metadata["stages"] != "AWSPREVIOUS" {
+		t.Errorf("Versions() = %+v, %v, want the newest first with its staging labels", versions, err)
+	}
+	buf = writeAtBuffer{}
+	if _, err := store.Get(ctx, GetInput{Key: "tfvars/prod", VersionID: versions[1].VersionID}, &buf); err != nil || string(buf.data) != "a = 1\n" {
+		t.Errorf("Get() of the previous version = %q, %v", buf.data, err)
+	}
+
+	_, err = store.Put(ctx, PutInput{Key: "tfvars/prod", Body: strings.NewReader(strings.Repeat("x", SecretsManagerMaxSize+1))})
+	if !errors.Is(err, ErrTooLarge) || !strings.Contains(err.Error(), "S3") {
+		t.Errorf("Put() of 64KB+1 = %v, want ErrTooLarge suggesting S3", err)
+	}
+}
+
+func TestSecretsManagerClient(t *testing.T) {
+	var targets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			t.Errorf("request is not signed: %v", r.Header)
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.GetSecretValue":
+			if strings.Contains(string(body), "missing") {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`)
+				return
+			}
+			fmt.Fprint(w, `{"VersionId":"v1","VersionStages":["AWSCURRENT"],"CreatedDate":1714564800.5,"SecretString":"a = 1\n"}`)
+		case "secretsmanager.ListSecretVersionIds":
+			fmt.Fprint(w, `{"Versions":[{"VersionId":"v1","VersionStages":["AWSPREVIOUS"],"CreatedDate":1714564800}]}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.secretsmanager#AccessDeniedException","Message":"no"}`)
+		}
+	}))
+	defer server.Close()
+
+	client := &SecretsManagerClient{
+		Config: aws.Config{
+			Region: "us-east-1",
+			Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+			}),
+		},
+		Endpoint: server.URL,
+	}
+	ctx := context.Background()
+
+	secret, err := client.GetSecretValue(ctx, "tfvars/dev", "")
+	if err != nil || secret.Value != "a = 1\n" || secret.VersionID != "v1" || !secret.CreatedDate.Equal(time.Date(2024, 5, 1, 12, 0, 0, 5e8, time.UTC)) {
+		t.Errorf("GetSecretValue() = %+v, %v", secret, err)
+	}
+	if _, err := client.GetSecretValue(ctx, "missing", ""); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("GetSecretValue() of a missing secret = %v, want ErrObjectNotFound", err)
+	}
+	if versions, err := client.ListSecretVersionIDs(ctx, "tfvars/dev"); err != nil || len(versions) != 1 || versions[0].Stages[0] != "AWSPREVIOUS" {
+		t.Errorf("ListSecretVersionIDs() = %+v, %v", versions, err)
+	}
+	if _, err := client.PutSecretValue(ctx, "tfvars/dev", "x"); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("PutSecretValue() = %v, want ErrAccessDenied", err)
+	}
+	if targets[len(targets)-1] != "secretsmanager.PutSecretValue" {
+		t.Errorf("targets = %v", targets)
+	}
+}
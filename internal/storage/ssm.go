@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+)
+
+// SSMChunkSize is the largest value a single parameter holds. Anything bigger
+// is split into chunk parameters under <name>/chunks/ and the parameter
+// itself holds a manifest listing them.
+const SSMChunkSize = 8 * 1024
+
+// SSMAPI is the part of the SSM client the store uses.
+type SSMAPI interface {
+	PutParameter(ctx context.Context, in *ssm.PutParameterInput, optFns ...func(*ssm.Options)) (*ssm.PutParameterOutput, error)
+	GetParameter(ctx context.Context, in *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+	GetParameterHistory(ctx context.Context, in *ssm.GetParameterHistoryInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterHistoryOutput, error)
+	DescribeParameters(ctx context.Context, in *ssm.DescribeParametersInput, optFns ...func(*ssm.Options)) (*ssm.DescribeParametersOutput, error)
+	DeleteParameters(ctx context.Context, in *ssm.DeleteParametersInput, optFns ...func(*ssm.Options)) (*ssm.DeleteParametersOutput, error)
+}
+
+// SSMStore is the Backend backed by SSM Parameter Store. Keys are parameter
+// names and every file is kept as a SecureString, encrypted with KMSKeyID or
+// the account's default key when it is empty. The metadata is kept in the
+// parameter description, so it is only available from Versions.
+type SSMStore struct {
+	Client   SSMAPI
+	KMSKeyID string
+}
+
+// ssmManifest is the value of a parameter whose content was chunked.
+// Versions are the chunk parameter versions, so older versions of the
+// parameter still read the chunks they were written with.
+type ssmManifest struct {
+	Chunks   int     `json:"tfmanage_chunks"`
+	Versions []int64 `json:"versions"`
+	SHA256   string  `json:"sha256"`
+}
+
+// NewSSMStore creates a store using the given client.
+func NewSSMStore(client SSMAPI, kmsKeyID string) *SSMStore {
+	return &SSMStore{Client: client, KMSKeyID: kmsKeyID}
+}
+
+func (s *SSMStore) Put(ctx context.Context, in PutInput) (ObjectInfo, error) {
+	if in.IfMatch != "" {
+		return ObjectInfo{}, fmt.Errorf("conditional writes: %w", ErrUnsupported)
+	}
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(data) == 0 {
+		return ObjectInfo{}, fmt.Errorf("ssm parameter %s: parameters can't be empty", in.Key)
+	}
+	if !utf8.Valid(data) {
+		return ObjectInfo{}, fmt.Errorf("ssm parameter %s: only text files can be kept in parameters", in.Key)
+	}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	value := string(data)
+	if chunks := splitChunks(value, SSMChunkSize); len(chunks) > 1 {
+		manifest := ssmManifest{Chunks: len(chunks), SHA256: checksum}
+		for i, chunk := range chunks {
+			version, err := s.put(ctx, ssmChunkName(in.Key, i), chunk, "")
+			if err != nil {
+				return ObjectInfo{}, err
+			}
+			manifest.Versions = append(manifest.Versions, version)
+		}
+		encoded, err := json.Marshal(manifest)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		value = string(encoded)
+	}
+
+	description, err := json.Marshal(in.Metadata)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	version, err := s.put(ctx, in.Key, value, string(description))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:       in.Key,
+		Size:      int64(len(data)),
+		ETag:      `"` + checksum + `"`,
+		VersionID: strconv.FormatInt(version, 10),
+		Metadata:  in.Metadata,
+	}, nil
+}
+
+func (s *SSMStore) put(ctx context.Context, name, value, description string) (int64, error) {
+	in := &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(value),
+		Type:      types.ParameterTypeSecureString,
+		Tier:      types.ParameterTierIntelligentTiering,
+		Overwrite: aws.Bool(true),
+	}
+	if description != "" {
+		in.Description = aws.String(description)
+	}
+	if s.KMSKeyID != "" {
+		in.KeyId = aws.String(s.KMSKeyID)
+	}
+	out, err := s.Client.PutParameter(ctx, in)
+	if err != nil {
+		return 0, mapSSMError(err, name)
+	}
+	return out.Version, nil
+}
+
+func (s *SSMStore) Get(ctx context.Context, in GetInput, w io.WriterAt) (int64, error) {
+	data, _, err := s.read(ctx, in.Key, in.VersionID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.WriteAt(data, 0)
+	return int64(n), err
+}
+
+// Head reads the whole parameter, there is no cheaper way to get its checksum
+func (s *SSMStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	_, info, err := s.read(ctx, key, "")
+	return info, err
+}
+
+// read gets a parameter, or one version of it, and puts its chunks back
+// together. The manifest of every version names the chunk versions it needs.
+func (s *SSMStore) read(ctx context.Context, name, version string) ([]byte, ObjectInfo, error) {
+	selector := name
+	if version != "" {
+		selector += ":" + version
+	}
+	param, err := s.get(ctx, selector)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	data := []byte(aws.ToString(param.Value))
+
+	var manifest ssmManifest
+	if json.Unmarshal(data, &manifest) == nil && manifest.Chunks > 0 {
+		if len(manifest.Versions) != manifest.Chunks {
+			return nil, ObjectInfo{}, fmt.Errorf("ssm parameter %s: the manifest lists %d chunk versions for %d chunks", name, len(manifest.Versions), manifest.Chunks)
+		}
+		var buf bytes.Buffer
+		for i, version := range manifest.Versions {
+			chunk, err := s.get(ctx, fmt.Sprintf("%s:%d", ssmChunkName(name, i), version))
+			if err != nil {
+				return nil, ObjectInfo{}, err
+			}
+			buf.WriteString(aws.ToString(chunk.Value))
+		}
+		data = buf.Bytes()
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	if manifest.Chunks > 0 && checksum != manifest.SHA256 {
+		return nil, ObjectInfo{}, fmt.Errorf("ssm parameter %s: the chunks don't match the checksum in the manifest", name)
+	}
+	return data, ObjectInfo{
+		Key:          name,
+		Size:         int64(len(data)),
+		ETag:         `"` + checksum + `"`,
+		VersionID:    strconv.FormatInt(param.Version, 10),
+		LastModified: aws.ToTime(param.LastModifiedDate),
+		Metadata:     map[string]string{ChecksumMetadataKey: checksum},
+	}, nil
+}
+
+func (s *SSMStore) get(ctx context.Context, name string) (*types.Parameter, error) {
+	out, err := s.Client.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return nil, mapSSMError(err, name)
+	}
+	return out.Parameter, nil
+}
+
+// List gives the parameters whose names start with prefix, without their
+// chunks. It doesn't decrypt anything, so sizes and checksums are not set.
+func (s *SSMStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	params, err := s.describe(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var out []ObjectInfo
+	for _, p := range params {
+		name := aws.ToString(p.Name)
+		if strings.Contains(name, ssmChunkDir) {
+			continue
+		}
+		out = append(out, ObjectInfo{
+			Key:          name,
+			VersionID:    strconv.FormatInt(p.Version, 10),
+			LastModified: aws.ToTime(p.LastModifiedDate),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func (s *SSMStore) describe(ctx context.Context, prefix string) ([]types.ParameterMetadata, error) {
+	in := &ssm.DescribeParametersInput{
+		ParameterFilters: []types.ParameterStringFilter{{Key: aws.String("Name"), Option: aws.String("BeginsWith"), Values: []string{prefix}}},
+	}
+	var params []types.ParameterMetadata
+	for {
+		out, err := s.Client.DescribeParameters(ctx, in)
+		if err != nil {
+			return nil, mapSSMError(err, prefix)
+		}
+		params = append(params, out.Parameters...)
+		if out.NextToken == nil {
+			return params, nil
+		}
+		in.NextToken = out.NextToken
+	}
+}
+
+// Versions maps the parameter history onto objects, newest first, with the
+// metadata each version was written with.
+func (s *SSMStore) Versions(ctx context.Context, key string) ([]ObjectInfo, error) {
+	in := &ssm.GetParameterHistoryInput{Name: aws.String(key)}
+	var out []ObjectInfo
+	for {
+		page, err := s.Client.GetParameterHistory(ctx, in)
+		if err != nil {
+			return nil, mapSSMError(err, key)
+		}
+		for _, h := range page.Parameters {
+			info := ObjectInfo{
+				Key:          key,
+				VersionID:    strconv.FormatInt(h.Version, 10),
+				LastModified: aws.ToTime(h.LastModifiedDate),
+			}
+			if d := aws.ToString(h.Description); d != "" {
+				json.Unmarshal([]byte(d), &info.Metadata)
+			}
+			out = append(out, info)
+		}
+		if page.NextToken == nil {
+			break
+		}
+		in.NextToken = page.NextToken
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastModified.After(out[j].LastModified) })
+	return out, nil
+}
+
+// Delete removes the parameter and all of its chunks.
+func (s *SSMStore) Delete(ctx context.Context, key string) error {
+	chunks, err := s.describe(ctx, key+ssmChunkDir)
+	if err != nil {
+		return err
+	}
+	names := []string{key}
+	for _, c := range chunks {
+		names = append(names, aws.ToString(c.Name))
+	}
+	// DeleteParameters takes at most 10 names at a time
+	for len(names) > 0 {
+		batch := names[:min(10, len(names))]
+		names = names[len(batch):]
+		if _, err := s.Client.DeleteParameters(ctx, &ssm.DeleteParametersInput{Names: batch}); err != nil {
+			return mapSSMError(err, key)
+		}
+	}
+	return nil
+}
+
+const ssmChunkDir = "/chunks/"
+
+func ssmChunkName(name string, i int) string {
+	return name + ssmChunkDir + strconv.Itoa(i+1)
+}
+
+// splitChunks cuts s into pieces of at most size bytes without splitting a
+// UTF-8 character, parameter values have to be valid text.
+func splitChunks(s string, size int) []string {
+	var chunks []string
+	for len(s) > size {
+		end := size
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		chunks = append(chunks, s[:end])
+		s = s[end:]
+	}
+	return append(chunks, s)
+}
+
+// mapSSMError turns missing parameters and denied calls into the same errors
+// the S3 store uses.
+func mapSSMError(err error, name string) error {
+	location := "ssm://" + name
+	var notFound *types.ParameterNotFound
+	var versionNotFound *types.ParameterVersionNotFound
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &notFound), errors.As(err, &versionNotFound):
+		return fmt.Errorf("%w: %s: %w", ErrObjectNotFound, location, err)
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException":
+		return fmt.Errorf("%w: %s: %w", ErrAccessDenied, location, err)
+	}
+	return err
+}
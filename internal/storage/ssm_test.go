@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// fakeSSM keeps every version of every parameter, like Parameter Store does
+type fakeSSM struct {
+	params map[string][]types.ParameterHistory
+	now    time.Time
+	puts   []*ssm.PutParameterInput
+}
+
+func newFakeSSM() *fakeSSM {
+	return &fakeSSM{params: map[string][]types.ParameterHistory{}, now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeSSM) PutParameter(ctx context.Context, in *ssm.PutParameterInput, optFns ...func(*ssm.Options)) (*ssm.PutParameterOutput, error) {
+	name := aws.ToString(in.Name)
+	if len(aws.ToString(in.Value)) > SSMChunkSize {
+		return nil, fmt.Errorf("value of %s is too long", name)
+	}
+	f.puts = append(f.puts, in)
+	f.now = f.now.Add(time.Minute)
+	version := int64(len(f.params[name]) + 1)
+	f.params[name] = append(f.params[name], types.ParameterHistory{
+		Name: in.Name, Value: in.Value, Description: in.Description, KeyId: in.KeyId,
+		Version: version, LastModifiedDate: aws.Time(f.now),
+	})
+	return &ssm.PutParameterOutput{Version: version}, nil
+}
+
+func (f *fakeSSM) GetParameter(ctx context.Context, in *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	name, selector, _ := strings.Cut(aws.ToString(in.Name), ":")
+	history := f.params[name]
+	if len(history) == 0 {
+		return nil, &types.ParameterNotFound{}
+	}
+	h := history[len(history)-1]
+	if selector != "" {
+		var v int
+		fmt.Sscan(selector, &v)
+		if v < 1 || v > len(history) {
+			return nil, &types.ParameterVersionNotFound{}
+		}
+		h = history[v-1]
+	}
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Name: h.Name, Value: h.Value, Version: h.Version, LastModifiedDate: h.LastModifiedDate}}, nil
+}
+
+func (f *fakeSSM) GetParameterHistory(ctx context.Context, in *ssm.GetParameterHistoryInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterHistoryOutput, error) {
+	history, ok := f.params[aws.ToString(in.Name)]
+	if !ok {
+		return nil, &types.ParameterNotFound{}
+	}
+	return &ssm.GetParameterHistoryOutput{Parameters: history}, nil
+}
+
+func (f *fakeSSM) DescribeParameters(ctx context.Context, in *ssm.DescribeParametersInput, optFns ...func(*ssm.Options)) (*ssm.DescribeParametersOutput, error) {
+	prefix := in.ParameterFilters[0].Values[0]
+	var out ssm.DescribeParametersOutput
+	for name, history := range f.params {
+		if strings.HasPrefix(name, prefix) {
+			latest := history[len(history)-1]
+			out.Parameters = append(out.Parameters, types.ParameterMetadata{Name: aws.String(name), Version: latest.Version, LastModifiedDate: latest.LastModifiedDate})
+		}
+	}
+	return &out, nil
+}
+
+func (f *fakeSSM) DeleteParameters(ctx context.Context, in *ssm.DeleteParametersInput, optFns ...func(*ssm.Options)) (*ssm.DeleteParametersOutput, error) {
+	if len(in.Names) > 10 {
+		return nil, errors.New("too many names")
+	}
+	for _, name := range in.Names {
+		delete(f.params, name)
+	}
+	return &ssm.DeleteParametersOutput{DeletedParameters: in.Names}, nil
+}
+
+func TestSSMStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeSSM()
+	store := NewSSMStore(fake, "alias/tfvars")
+
+	small := []byte("region = \"eu-west-1\"\n")
+	info, err := store.Put(ctx, PutInput{Key: "/tfvars/dev", Body: bytes.NewReader(small), Metadata: map[string]string{ChecksumMetadataKey: "abc", "git-sha": "123"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.VersionID != "1" || len(fake.puts) != 1 {
+		t.Errorf("Put() = %+v after %d puts, want version 1 in one put", info, len(fake.puts))
+	}
+	if p := fake.puts[0]; p.Type != types.ParameterTypeSecureString || aws.ToString(p.KeyId) != "alias/tfvars" || aws.ToString(p.Value) != string(small) {
+		t.Errorf("put %+v, want a SecureString with the KMS key and the content as is", p)
+	}
+
+	var buf writeAtBuffer
+	if n, err := store.Get(ctx, GetInput{Key: "/tfvars/dev"}, &buf); err != nil || int(n) != len(small) || !bytes.Equal(buf.data, small) {
+		t.Errorf("Get() = %d, %v, %q", n, err, buf.data)
+	}
+	head, err := store.Head(ctx, "/tfvars/dev")
+	if err != nil || head.ETag != info.ETag || head.Metadata[ChecksumMetadataKey] != strings.Trim(info.ETag, `"`) {
+		t.Errorf("Head() = %+v, %v, want the etag and checksum of the content", head, err)
+	}
+
+	if _, err := store.Head(ctx, "/tfvars/missing"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Head() of a missing parameter = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestSSMStoreChunks(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeSSM()
+	store := NewSSMStore(fake, "")
+
+	// multi-byte characters make sure a chunk never ends half way through one
+	big := []byte(strings.Repeat("tags = { owner = \"équipe\" }\n", 1000))
+	if _, err := store.Put(ctx, PutInput{Key: "/tfvars/prod", Body: bytes.NewReader(big)}); err != nil {
+		t.Fatal(err)
+	}
+	chunks := (len(big) + SSMChunkSize - 1) / SSMChunkSize
+	if len(fake.puts) < chunks+1 {
+		t.Errorf("%d puts, want at least %d chunks and the manifest", len(fake.puts), chunks)
+	}
+	var buf writeAtBuffer
+	if _, err := store.Get(ctx, GetInput{Key: "/tfvars/prod"}, &buf); err != nil || !bytes.Equal(buf.data, big) {
+		t.Fatalf("Get() of a chunked parameter = %v, content matches %v", err, bytes.Equal(buf.data, big))
+	}
+
+	// a smaller second version leaves the old chunks for the history
+	if _, err := store.Put(ctx, PutInput{Key: "/tfvars/prod", Body: strings.NewReader("small\n"), Metadata: map[string]string{"git-sha": "456"}}); err != nil {
+		t.Fatal(err)
+	}
+	buf = writeAtBuffer{}
+	if _, err := store.Get(ctx, GetInput{Key: "/tfvars/prod"}, &buf); err != nil || string(buf.data) != "small\n" {
+		t.Errorf("Get() after the update = %q, %v", buf.data, err)
+	}
+
+	versions, err := store.Versions(ctx, "/tfvars/prod")
+	if err != nil || len(versions) != 2 || versions[0].VersionID != "2" || versions[0].Metadata["git-sha"] != "456" {
+		t.Errorf("Versions() = %+v, %v, want 2 versions newest first with their metadata", versions, err)
+	}
+	buf = writeAtBuffer{}
+	if _, err := store.Get(ctx, GetInput{Key: "/tfvars/prod", VersionID: "1"}, &buf); err != nil || !bytes.Equal(buf.data, big) {
+		t.Errorf("Get() of the chunked first version = %v, content matches %v", err, bytes.Equal(buf.data, big))
+	}
+	list, err := store.List(ctx, "/tfvars/")
+	if err != nil || len(list) != 1 || list[0].Key != "/tfvars/prod" {
+		t.Errorf("List() = %+v, %v, want only the parameter without its chunks", list, err)
+	}
+
+	if err := store.Delete(ctx, "/tfvars/prod"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.params) != 0 {
+		t.Errorf("Delete() left %d parameters", len(fake.params))
+	}
+}
+
+func TestSSMStoreRejectsBinary(t *testing.T) {
+	store := NewSSMStore(newFakeSSM(), "")
+	if _, err := store.Put(context.Background(), PutInput{Key: "/tfvars/dev", Body: bytes.NewReader([]byte{0xff, 0xfe})}); err == nil {
+		t.Error("Put() of invalid UTF-8 succeeded")
+	}
+}
@@ -0,0 +1,84 @@
+// Package storage holds the remote storage the tfvars files are kept in. The
+// Backend interface is deliberately small so the upload and download logic
+// works the same against S3, SSM Parameter Store and Secrets Manager, and can
+// be tested against the in-memory implementation instead of a real bucket.
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ChecksumMetadataKey is the object metadata key holding the hex SHA-256 of the content.
+const ChecksumMetadataKey = "sha256"
+
+// ObjectInfo describes an object in the store.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	VersionID    string
+	LastModified time.Time
+	Metadata     map[string]string
+	// KMSKeyID is the key the object is encrypted with, for backends that
+	// encrypt each object with SSE-KMS.
+	KMSKeyID string
+	// ContentType is the object's media type, for backends that keep one.
+	ContentType string
+	// StorageClass is the object's S3 storage class, empty for STANDARD and
+	// for backends without classes.
+	StorageClass string
+	// Restore is how far a restore of an object in an archive class got.
+	Restore RestoreStatus
+}
+
+// PutInput is what gets written by Put.
+type PutInput struct {
+	Key      string
+	Body     io.Reader
+	Metadata map[string]string
+	// KMSKeyID encrypts the object with SSE-KMS under this key. Backends with
+	// a key of their own, such as SSM, ignore it.
+	KMSKeyID string
+	// ContentType, CacheControl and ContentDisposition are the HTTP headers
+	// the object is served with. Backends that never serve objects over HTTP,
+	// such as SSM, ignore them.
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	// StorageClass is the S3 storage class the object is stored in, STANDARD
+	// when empty. Backends without storage classes ignore it.
+	StorageClass string
+	// IfMatch makes the write conditional: the object is only replaced when
+	// its current ETag is this one, ErrPreconditionFailed otherwise, also when
+	// it no longer exists. Backends that can't write conditionally return
+	// ErrUnsupported, UploadKey falls back to comparing the ETag with Head.
+	IfMatch string
+}
+
+// GetInput selects the object read by Get.
+type GetInput struct {
+	Key string
+	// VersionID reads that version, one Versions gave back, instead of the
+	// current one.
+	VersionID string
+}
+
+// Backend is the set of operations the commands need from a store. A backend
+// that can't do one of them returns ErrUnsupported from it.
+type Backend interface {
+	Put(ctx context.Context, in PutInput) (ObjectInfo, error)
+	Get(ctx context.Context, in GetInput, w io.WriterAt) (int64, error)
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Versions gives every version of key, newest first.
+	Versions(ctx context.Context, key string) ([]ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Key builds the object key for a file under the configured prefix. The
+// prefix is used as is, so it needs its own trailing slash.
+func Key(prefix, name string) string {
+	return prefix + name
+}
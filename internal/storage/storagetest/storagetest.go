@@ -0,0 +1,182 @@
+// Package storagetest is the conformance suite every storage.Backend has to
+// pass. The in-memory, SSM and Secrets Manager backends run it in the storage
+// tests, and the S3 backend runs it against LocalStack in the integration
+// tests.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// Run checks newBackend's backends behave the way the commands expect. Each
+// subtest gets a fresh backend and keys under prefix, which has to be a valid
+// key prefix for the backend such as "/tfmanage-test/" for SSM. Operations
+// that return storage.ErrUnsupported skip their subtest.
+func Run(t *testing.T, prefix string, newBackend func(t *testing.T) storage.Backend) {
+	ctx := context.Background()
+
+	t.Run("missing key", func(t *testing.T) {
+		b := newBackend(t)
+		if _, err := b.Head(ctx, prefix+"missing"); !errors.Is(err, storage.ErrObjectNotFound) {
+			t.Errorf("Head() of a missing key = %v, want ErrObjectNotFound", err)
+		}
+		var buf buffer
+		if _, err := b.Get(ctx, storage.GetInput{Key: prefix + "missing"}, &buf); !errors.Is(err, storage.ErrObjectNotFound) {
+			t.Errorf("Get() of a missing key = %v, want ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		b := newBackend(t)
+		key := prefix + "dev.tfvars"
+		data := []byte("region = \"eu-west-1\"\n")
+		put := mustPut(t, b, key, data)
+
+		var buf buffer
+		n, err := b.Get(ctx, storage.GetInput{Key: key}, &buf)
+		if err != nil || n != int64(len(data)) || !bytes.Equal(buf.data, data) {
+			t.Errorf("Get() = %d, %v, %q, want %q", n, err, buf.data, data)
+		}
+		head, err := b.Head(ctx, key)
+		if err != nil {
+			t.Fatalf("Head() = %v", err)
+		}
+		if head.Key != key || head.Size != int64(len(data)) || head.ETag != put.ETag {
+			t.Errorf("Head() = %+v, want key %s, size %d and the etag from Put %s", head, key, len(data), put.ETag)
+		}
+		if head.Metadata[storage.ChecksumMetadataKey] != checksum(data) {
+			t.Errorf("Head() checksum = %q, want %q", head.Metadata[storage.ChecksumMetadataKey], checksum(data))
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		b := newBackend(t)
+		key := prefix + "prod.tfvars"
+		first := mustPut(t, b, key, []byte("a = 1\n"))
+		second := mustPut(t, b, key, []byte("a = 2\n"))
+		if first.ETag == second.ETag {
+			t.Errorf("different content has the same etag %s", first.ETag)
+		}
+		var buf buffer
+		if _, err := b.Get(ctx, storage.GetInput{Key: key}, &buf); err != nil || string(buf.data) != "a = 2\n" {
+			t.Errorf("Get() after an overwrite = %q, %v", buf.data, err)
+		}
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		b := newBackend(t)
+		key := prefix + "staging.tfvars"
+		mustPut(t, b, key, []byte("a = 1\n"))
+		latest := mustPut(t, b, key, []byte("a = 2\n"))
+		versions, err := b.Versions(ctx, key)
+		if errors.Is(err, storage.ErrUnsupported) {
+			t.Skip("the backend has no versions")
+		}
+		if err != nil {
+			t.Fatalf("Versions() = %v", err)
+		}
+		if len(versions) != 2 || versions[0].VersionID == versions[1].VersionID {
+			t.Fatalf("Versions() = %+v, want 2 distinct versions", versions)
+		}
+		if latest.VersionID != "" && versions[0].VersionID != latest.VersionID {
+			t.Errorf("newest version = %s, want %s from the last Put", versions[0].VersionID, latest.VersionID)
+		}
+		if _, err := b.Versions(ctx, prefix+"missing"); !errors.Is(err, storage.ErrObjectNotFound) {
+			t.Errorf("Versions() of a missing key = %v, want ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		b := newBackend(t)
+		mustPut(t, b, prefix+"list/a", []byte("a\n"))
+		mustPut(t, b, prefix+"list/b", []byte("b\n"))
+		mustPut(t, b, prefix+"other", []byte("c\n"))
+		objects, err := b.List(ctx, prefix+"list/")
+		if errors.Is(err, storage.ErrUnsupported) {
+			t.Skip("the backend can't list")
+		}
+		if err != nil {
+			t.Fatalf("List() = %v", err)
+		}
+		var keys []string
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+		}
+		if len(keys) != 2 || keys[0] != prefix+"list/a" || keys[1] != prefix+"list/b" {
+			t.Errorf("List() = %v, want the two keys under the prefix", keys)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		b := newBackend(t)
+		key := prefix + "dr.tfvars"
+		mustPut(t, b, key, []byte("a = 1\n"))
+		if err := b.Delete(ctx, key); errors.Is(err, storage.ErrUnsupported) {
+			t.Skip("the backend can't delete")
+		} else if err != nil {
+			t.Fatalf("Delete() = %v", err)
+		}
+		if _, err := b.Head(ctx, key); !errors.Is(err, storage.ErrObjectNotFound) {
+			t.Errorf("Head() after Delete = %v, want ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		b := newBackend(t)
+		data := []byte("a = 1\n")
+		mustPut(t, b, prefix+"dev.tfvars", data)
+		if _, err := storage.Copy(ctx, b, storage.CopyInput{Source: prefix + "dev.tfvars", Key: prefix + "archive/dev.tfvars"}); errors.Is(err, storage.ErrUnsupported) {
+			t.Skip("the backend can't copy")
+		} else if err != nil {
+			t.Fatalf("Copy() = %v", err)
+		}
+		var buf buffer
+		if _, err := b.Get(ctx, storage.GetInput{Key: prefix + "archive/dev.tfvars"}, &buf); err != nil || !bytes.Equal(buf.data, data) {
+			t.Errorf("Get() of the copy = %q, %v, want %q", buf.data, err, data)
+		}
+		if info, err := b.Head(ctx, prefix+"archive/dev.tfvars"); err != nil || info.Metadata[storage.ChecksumMetadataKey] != checksum(data) {
+			t.Errorf("Head() of the copy = %+v, %v, want the source's checksum", info, err)
+		}
+		if _, err := b.Head(ctx, prefix+"dev.tfvars"); err != nil {
+			t.Errorf("Head() of the source after Copy = %v", err)
+		}
+	})
+}
+
+func mustPut(t *testing.T, b storage.Backend, key string, data []byte) storage.ObjectInfo {
+	t.Helper()
+	info, err := b.Put(context.Background(), storage.PutInput{
+		Key:      key,
+		Body:     bytes.NewReader(data),
+		Metadata: map[string]string{storage.ChecksumMetadataKey: checksum(data)},
+	})
+	if err != nil {
+		t.Fatalf("Put(%s) = %v", key, err)
+	}
+	return info
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// buffer is an io.WriterAt in memory
+type buffer struct {
+	data []byte
+}
+
+func (b *buffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	copy(b.data[off:], p)
+	return len(p), nil
+}
@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tracing"
+)
+
+// UploadResult is what Upload did.
+type UploadResult struct {
+	Key      string
+	Checksum string
+	// Skipped is set when the remote object already had the same content.
+	Skipped bool
+	// VersionID is the version the upload made, for backends that keep
+	// versions.
+	VersionID string
+	// ETag is the ETag of the remote object after the upload, or of the one
+	// that was already there when it was skipped.
+	ETag string
+}
+
+// UploadOptions change how Upload behaves.
+type UploadOptions struct {
+	// SkipUnchanged leaves the remote object alone when it already has the
+	// same checksum. Without it every upload writes.
+	SkipUnchanged bool
+	// Metadata is stored with the object next to the checksum.
+	Metadata map[string]string
+	// KMSKeyID is the SSE-KMS key the object is encrypted with.
+	KMSKeyID string
+	// ContentType overrides the media type ContentTypeFor picks from the
+	// file name.
+	ContentType string
+	// CacheControl is the object's Cache-Control, no-cache for files named
+	// like tfvars when empty.
+	CacheControl string
+	// StorageClass is the S3 storage class of the object, STANDARD when
+	// empty. With SkipUnchanged, an unchanged file in another class is
+	// still uploaded again.
+	StorageClass string
+	// IfMatch only replaces the remote object when its ETag is this one, the
+	// upload fails with ErrPreconditionFailed otherwise. Backends that can't
+	// write conditionally get a Head and a compare right before the Put.
+	IfMatch string
+	// Skip leaves out the files and directories UploadDir walks into that it
+	// says yes to, by their slash separated path under the directory.
+	Skip func(rel string, dir bool) bool
+}
+
+// ContentTypeFor is the media type of a file by its name: JSON for .json
+// files such as .tfvars.json and plan sidecars, UTF-8 text for .tfvars, a
+// diff for the .diff files of the change journal, gzip for .gz, and plain
+// bytes for anything else, saved plans included.
+func ContentTypeFor(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".json"):
+		return "application/json"
+	case strings.HasSuffix(name, ".tfvars"):
+		return "text/plain; charset=utf-8"
+	case strings.HasSuffix(name, ".diff"):
+		return "text/x-diff; charset=utf-8"
+	case strings.HasSuffix(name, ".gz"):
+		return "application/gzip"
+	}
+	return "application/octet-stream"
+}
+
+// withHeaders fills in the HTTP headers of an object stored under name. The
+// tfvars are never cached, so a CDN or proxy in front of the bucket can't
+// serve an old config, and every object downloads under its own file name.
+func withHeaders(in PutInput, name string, opts UploadOptions) PutInput {
+	in.ContentType = opts.ContentType
+	if in.ContentType == "" {
+		in.ContentType = ContentTypeFor(name)
+	}
+	in.CacheControl = opts.CacheControl
+	if in.CacheControl == "" && (strings.HasSuffix(name, ".tfvars") || strings.HasSuffix(name, ".tfvars.json")) {
+		in.CacheControl = "no-cache"
+	}
+	in.StorageClass = opts.StorageClass
+	in.ContentDisposition = mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(filepath.ToSlash(name))})
+	return in
+}
+
+// Upload sends the local file to prefix+fileName. The SHA-256 of the file is
+// stored in the object metadata. With opts.SkipUnchanged the upload is
+// skipped when the remote object already carries the same checksum.
+func Upload(ctx context.Context, store Backend, prefix, fileName string, opts UploadOptions) (UploadResult, error) {
+	return UploadKey(ctx, store, Key(prefix, fileName), fileName, opts)
+}
+
+// UploadDir uploads every file under dir to prefix plus its path relative to
+// dir, leaving out whatever opts.Skip says and, with opts.SkipUnchanged,
+// the files that are unchanged.
+func UploadDir(ctx context.Context, store Backend, prefix, dir string, opts UploadOptions) ([]UploadResult, error) {
+	var results []UploadResult
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if opts.Skip != nil && opts.Skip(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		res, err := UploadKey(ctx, store, Key(prefix, rel), p, opts)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+		return nil
+	})
+	return results, err
+}
+
+// UploadKey is Upload for a file whose key is not its name under a prefix.
+func UploadKey(ctx context.Context, store Backend, key, fileName string, opts UploadOptions) (_ UploadResult, err error) {
+	ctx, span := tracing.Start(ctx, "storage.upload", tracing.String("storage.key", key))
+	defer func() { span.End(err) }()
+
+	sum, err := FileChecksum(fileName)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	result := UploadResult{Key: key, Checksum: sum}
+	// the replica's copy is no reason to skip the upload
+	if replica, ok := store.(*ReplicaStore); ok {
+		store = replica.writer()
+	}
+
+	// the head is only an optimisation so any failure here just means we upload
+
+	if opts.SkipUnchanged {
+		// the same content in another storage class is uploaded again to move it
+		if remote, err := store.Head(ctx, key); err == nil && remote.Metadata[ChecksumMetadataKey] == sum && (opts.StorageClass == "" || SameStorageClass(remote.StorageClass, opts.StorageClass)) {
+			span.SetAttributes(tracing.Bool("storage.skipped", true))
+			result.Skipped, result.ETag, result.VersionID = true, remote.ETag, remote.VersionID
+			return result, nil
+		}
+	}
+
+	file, err := openLocal(fileName)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil {
+		span.SetAttributes(tracing.Int("storage.bytes", info.Size()))
+	}
+
+	metadata := map[string]string{ChecksumMetadataKey: sum}
+	for k, v := range opts.Metadata {
+		if k != ChecksumMetadataKey {
+			metadata[k] = v
+		}
+	}
+	in := withHeaders(PutInput{
+		Key:      key,
+		Body:     file,
+		Metadata: metadata,
+		KMSKeyID: opts.KMSKeyID,
+		IfMatch:  opts.IfMatch,
+	}, fileName, opts)
+	info, err := store.Put(ctx, in)
+	if errors.Is(err, ErrUnsupported) && in.IfMatch != "" {
+		info, err = putIfMatchFallback(ctx, store, in, file)
+	}
+	if err != nil {
+		return UploadResult{}, transferFailed("upload", err)
+	}
+	result.VersionID, result.ETag = info.VersionID, info.ETag
+	return result, nil
+}
+
+// putIfMatchFallback is the conditional Put of a backend that can't do one,
+// the ETag is compared with Head right before an unconditional Put. Another
+// upload can still slip in between the two.
+func putIfMatchFallback(ctx context.Context, store Backend, in PutInput, file *os.File) (ObjectInfo, error) {
+	remote, err := store.Head(ctx, in.Key)
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+		return ObjectInfo{}, fmt.Errorf("%w: %s no longer exists", ErrPreconditionFailed, in.Key)
+	case err != nil:
+		return ObjectInfo{}, err
+	case remote.ETag != in.IfMatch:
+		return ObjectInfo{}, fmt.Errorf("%w: %s has ETag %s, not %s", ErrPreconditionFailed, in.Key, remote.ETag, in.IfMatch)
+	}
+	// the refused Put may have read some of the file already
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ObjectInfo{}, err
+	}
+	in.Body, in.IfMatch = file, ""
+	return store.Put(ctx, in)
+}
+
+// PutBytes stores data under key with its checksum in the metadata, like
+// Upload does for files. It never skips.
+func PutBytes(ctx context.Context, store Backend, key string, data []byte) (UploadResult, error) {
+	return PutBytesEncrypted(ctx, store, key, data, "")
+}
+
+// PutBytesEncrypted is PutBytes with the object encrypted with a KMS key,
+// when kmsKeyID isn't empty.
+func PutBytesEncrypted(ctx context.Context, store Backend, key string, data []byte, kmsKeyID string) (UploadResult, error) {
+	return PutBytesWith(ctx, store, key, data, UploadOptions{KMSKeyID: kmsKeyID})
+}
+
+// PutBytesWith is PutBytes with the metadata and KMS key from opts. SkipUnchanged
+// is ignored, it never skips.
+func PutBytesWith(ctx context.Context, store Backend, key string, data []byte, opts UploadOptions) (_ UploadResult, err error) {
+	ctx, span := tracing.Start(ctx, "storage.upload", tracing.String("storage.key", key), tracing.Int("storage.bytes", int64(len(data))))
+	defer func() { span.End(err) }()
+
+	sum := sha256.Sum256(data)
+	result := UploadResult{Key: key, Checksum: hex.EncodeToString(sum[:])}
+	metadata := map[string]string{ChecksumMetadataKey: result.Checksum}
+	for k, v := range opts.Metadata {
+		if k != ChecksumMetadataKey {
+			metadata[k] = v
+		}
+	}
+	_, err = store.Put(ctx, withHeaders(PutInput{
+		Key:      key,
+		Body:     bytes.NewReader(data),
+		Metadata: metadata,
+		KMSKeyID: opts.KMSKeyID,
+	}, key, opts))
+	if err != nil {
+		return UploadResult{}, transferFailed("upload", err)
+	}
+	return result, nil
+}
+
+// GetBytes reads a small object, such as a sidecar, into memory.
+func GetBytes(ctx context.Context, store Backend, key string) ([]byte, error) {
+	return GetVersionBytes(ctx, store, key, "")
+}
+
+// GetVersionBytes is GetBytes for one version of the object, the current one
+// when versionID is empty.
+func GetVersionBytes(ctx context.Context, store Backend, key, versionID string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "storage.download", tracing.String("storage.key", key))
+	defer func() { span.End(err) }()
+	if versionID != "" {
+		span.SetAttributes(tracing.String("storage.version_id", versionID))
+	}
+
+	var buf writeAtBuffer
+	if _, err := store.Get(ctx, GetInput{Key: key, VersionID: versionID}, &buf); err != nil {
+		return nil, transferFailed("download", err)
+	}
+	span.SetAttributes(tracing.Int("storage.bytes", int64(len(buf.data))))
+	return buf.data, nil
+}
+
+// writeAtBuffer is an in-memory io.WriterAt
+type writeAtBuffer struct {
+	data []byte
+}
+
+func (b *writeAtBuffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	copy(b.data[off:], p)
+	return len(p), nil
+}
+
+// Truncate drops everything from size on, such as what a failed read wrote
+// before the read that replaced it
+func (b *writeAtBuffer) Truncate(size int64) error {
+	if int(size) < len(b.data) {
+		b.data = b.data[:size]
+	}
+	return nil
+}
+
+// Download writes prefix+fileName from the store to the local fileName and
+// returns the number of bytes written. The object is written to a temporary
+// file next to fileName first, so a failed or cancelled download never leaves
+// a partial file behind and never clobbers the existing one.
+func Download(ctx context.Context, store Backend, prefix, fileName string) (int64, error) {
+	return DownloadKey(ctx, store, Key(prefix, fileName), fileName)
+}
+
+// DownloadKey is Download for an object whose key is not the file name under
+// a prefix.
+func DownloadKey(ctx context.Context, store Backend, key, fileName string) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "storage.download", tracing.String("storage.key", key))
+	defer func() { span.End(err) }()
+
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(fileName); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".download-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file %q, %w", fileName, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := store.Get(ctx, GetInput{Key: key}, tmp)
+	if err != nil {
+		return 0, transferFailed("download", err)
+	}
+	span.SetAttributes(tracing.Int("storage.bytes", n))
+	if err := tmp.Chmod(mode); err != nil {
+		return 0, fmt.Errorf("failed to set permissions on %q, %w", fileName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write file %q, %w", fileName, err)
+	}
+	if err := os.Rename(tmp.Name(), fileName); err != nil {
+		return 0, fmt.Errorf("failed to create file %q, %w", fileName, err)
+	}
+	return n, nil
+}
+
+// FileChecksum returns the hex SHA-256 of a local file.
+func FileChecksum(fileName string) (string, error) {
+	file, err := openLocal(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to read file %q, %w", fileName, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// openLocal opens a file that is about to be uploaded, a missing file is reported as ErrLocalFileMissing
+func openLocal(fileName string) (*os.File, error) {
+	file, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrLocalFileMissing, fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %q, %w", fileName, err)
+	}
+	return file, nil
+}
+
+// transferFailed marks errors that are not one of the specific ones as ErrTransferFailed
+func transferFailed(op string, err error) error {
+	for _, known := range []error{ErrObjectNotFound, ErrBucketNotFound, ErrAccessDenied, ErrPreconditionFailed, ErrArchived} {
+		if errors.Is(err, known) {
+			return fmt.Errorf("%s failed: %w", op, err)
+		}
+	}
+	return fmt.Errorf("%w: %s: %w", ErrTransferFailed, op, err)
+}
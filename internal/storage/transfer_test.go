@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// chdir moves the test into dir, the transfer functions use the file name as part of the key
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		prefix, name, want string
+	}{
+		{"", "dev.tfvars", "dev.tfvars"},
+		{"tfvars/", "dev.tfvars", "tfvars/dev.tfvars"},
+		{"team/app/", "envs/prod.tfvars", "team/app/envs/prod.tfvars"},
+	}
+	for _, tt := range tests {
+		if got := Key(tt.prefix, tt.name); got != tt.want {
+			t.Errorf("Key(%q, %q) = %q, want %q", tt.prefix, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUploadStoresContentAndChecksum(t *testing.T) {
+	chdir(t, t.TempDir())
+	writeFile(t, "dev.tfvars", `region = "us-east-1"`)
+	store := NewMemoryStore()
+
+	res, err := Upload(context.Background(), store, "tfvars/", "dev.tfvars", UploadOptions{})
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if res.Key != "tfvars/dev.tfvars" || res.Skipped {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	data, ok := store.Bytes("tfvars/dev.tfvars")
+	if !ok || string(data) != `region = "us-east-1"` {
+		t.Fatalf("stored content = %q, %v", data, ok)
+	}
+	info, err := store.Head(context.Background(), "tfvars/dev.tfvars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Metadata[ChecksumMetadataKey] != res.Checksum {
+		t.Errorf("checksum metadata = %q, want %q", info.Metadata[ChecksumMetadataKey], res.Checksum)
+	}
+}
+
+func TestUploadSkipsUnchanged(t *testing.T) {
+	chdir(t, t.TempDir())
+	writeFile(t, "dev.tfvars", "a = 1")
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	if _, err := Upload(ctx, store, "", "dev.tfvars", UploadOptions{SkipUnchanged: true}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Upload(ctx, store, "", "dev.tfvars", UploadOptions{SkipUnchanged: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Skipped {
+		t.Error("second upload of identical content was not skipped")
+	}
+	if store.Puts() != 1 {
+		t.Errorf("puts = %d, want 1", store.Puts())
+	}
+
+	writeFile(t, "dev.tfvars", "a = 2")
+	res, err = Upload(ctx, store, "", "dev.tfvars", UploadOptions{SkipUnchanged: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Skipped || store.Puts() != 2 {
+		t.Errorf("changed file was not uploaded: %+v, puts = %d", res, store.Puts())
+	}
+}
+
+func TestUploadHeadFailureStillUploads(t *testing.T) {
+	chdir(t, t.TempDir())
+	writeFile(t, "dev.tfvars", "a = 1")
+	store := NewMemoryStore()
+	store.HeadErr = errors.New("access denied")
+
+	res, err := Upload(context.Background(), store, "", "dev.tfvars", UploadOptions{SkipUnchanged: true})
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if res.Skipped || store.Puts() != 1 {
+		t.Errorf("expected an upload, got %+v with %d puts", res, store.Puts())
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	t.Run("missing local file", func(t *testing.T) {
+		_, err := Upload(context.Background(), NewMemoryStore(), "", "missing.tfvars", UploadOptions{})
+		if !errors.Is(err, ErrLocalFileMissing) {
+			t.Fatalf("error = %v, want ErrLocalFileMissing", err)
+		}
+	})
+
+	t.Run("put fails", func(t *testing.T) {
+		writeFile(t, "dev.tfvars", "a = 1")
+		store := NewMemoryStore()
+		store.PutErr = errors.New("boom")
+		_, err := Upload(context.Background(), store, "", "dev.tfvars", UploadOptions{})
+		if !errors.Is(err, store.PutErr) {
+			t.Fatalf("error = %v, want wrapped put error", err)
+		}
+	})
+}
+
+func TestDownload(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	store := NewMemoryStore()
+	ctx := context.Background()
+	writeFile(t, "dev.tfvars", "a = 1")
+	if _, err := Upload(ctx, store, "p/", "dev.tfvars", UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove("dev.tfvars")
+
+	n, err := Download(ctx, store, "p/", "dev.tfvars")
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if n != 5 {
+		t.Errorf("bytes = %d, want 5", n)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "dev.tfvars"))
+	if err != nil || string(data) != "a = 1" {
+		t.Fatalf("downloaded content = %q, %v", data, err)
+	}
+}
+
+func TestDownloadMissingObject(t *testing.T) {
+	chdir(t, t.TempDir())
+	_, err := Download(context.Background(), NewMemoryStore(), "p/", "dev.tfvars")
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("error = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestUploadWritesUnchanged(t *testing.T) {
+	chdir(t, t.TempDir())
+	writeFile(t, "dev.tfvars", "a = 1")
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	for range 2 {
+		res, err := Upload(ctx, store, "", "dev.tfvars", UploadOptions{})
+		if err != nil || res.Skipped {
+			t.Fatalf("Upload() = %+v, %v", res, err)
+		}
+	}
+	if store.Puts() != 2 {
+		t.Errorf("puts = %d, want 2", store.Puts())
+	}
+}
+
+func TestUploadIfMatch(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]Backend{
+		"conditional put":  NewMemoryStore(),
+		"head and compare": NewFileStore(t.TempDir()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			chdir(t, t.TempDir())
+			writeFile(t, "dev.tfvars", "a = 1")
+			base, err := Upload(ctx, store, "", "dev.tfvars", UploadOptions{})
+			if err != nil || base.ETag == "" {
+				t.Fatalf("Upload() = %+v, %v, want an ETag", base, err)
+			}
+
+			writeFile(t, "dev.tfvars", "a = 2")
+			next, err := Upload(ctx, store, "", "dev.tfvars", UploadOptions{IfMatch: base.ETag})
+			if err != nil || next.ETag == base.ETag {
+				t.Fatalf("Upload() on top of the base = %+v, %v", next, err)
+			}
+
+			// the base is no longer what the store has
+			writeFile(t, "dev.tfvars", "a = 3")
+			if _, err := Upload(ctx, store, "", "dev.tfvars", UploadOptions{IfMatch: base.ETag}); !errors.Is(err, ErrPreconditionFailed) || errors.Is(err, ErrTransferFailed) {
+				t.Errorf("Upload() on top of an old base = %v, want ErrPreconditionFailed", err)
+			}
+			if data, _ := GetBytes(ctx, store, "dev.tfvars"); string(data) != "a = 2" {
+				t.Errorf("the refused upload replaced the object with %q", data)
+			}
+			writeFile(t, "gone.tfvars", "a = 1")
+			if _, err := Upload(ctx, store, "", "gone.tfvars", UploadOptions{IfMatch: base.ETag}); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("Upload() with IfMatch of an object that doesn't exist = %v, want ErrPreconditionFailed", err)
+			}
+		})
+	}
+}
+
+func TestUploadMetadata(t *testing.T) {
+	chdir(t, t.TempDir())
+	writeFile(t, "dev.tfvars", "a = 1")
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	res, err := Upload(ctx, store, "", "dev.tfvars", UploadOptions{Metadata: map[string]string{"git-sha": "abc", ChecksumMetadataKey: "nope"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := store.Head(ctx, "dev.tfvars")
+	if info.Metadata["git-sha"] != "abc" || info.Metadata[ChecksumMetadataKey] != res.Checksum {
+		t.Errorf("metadata = %v", info.Metadata)
+	}
+}
+
+func TestUploadKMSKey(t *testing.T) {
+	chdir(t, t.TempDir())
+	writeFile(t, "dev.tfvars", "a = 1")
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	key := "arn:aws:kms:eu-west-1:111111111111:key/dev"
+	if _, err := Upload(ctx, store, "", "dev.tfvars", UploadOptions{KMSKeyID: key}); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := store.Head(ctx, "dev.tfvars"); info.KMSKeyID != key {
+		t.Errorf("object encrypted with %q, want %q", info.KMSKeyID, key)
+	}
+}
+
+func TestUploadDir(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "registry.terraform.io", "hashicorp", "aws"), 0o755)
+	writeFile(t, filepath.Join(dir, "registry.terraform.io", "hashicorp", "aws", "5.31.0.json"), "{}")
+	writeFile(t, filepath.Join(dir, "registry.terraform.io", "hashicorp", "aws", "index.json"), "{}")
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	results, err := UploadDir(ctx, store, "mirror/", dir, UploadOptions{SkipUnchanged: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Key != "mirror/registry.terraform.io/hashicorp/aws/5.31.0.json" {
+		t.Fatalf("UploadDir() = %+v", results)
+	}
+	results, err = UploadDir(ctx, store, "mirror/", dir, UploadOptions{SkipUnchanged: true})
+	if err != nil || !results[0].Skipped || !results[1].Skipped || store.Puts() != 2 {
+		t.Errorf("second sync = %+v, %v, puts = %d, want everything skipped", results, err, store.Puts())
+	}
+
+	os.MkdirAll(filepath.Join(dir, "tmp"), 0o755)
+	writeFile(t, filepath.Join(dir, "tmp", "partial.zip"), "")
+	writeFile(t, filepath.Join(dir, "notes.swp"), "")
+	skip := func(rel string, dir bool) bool { return rel == "tmp" || path.Ext(rel) == ".swp" }
+	results, err = UploadDir(ctx, store, "mirror/", dir, UploadOptions{SkipUnchanged: true, Skip: skip})
+	if err != nil || len(results) != 2 {
+		t.Errorf("sync with Skip = %+v, %v, want just the two provider files", results, err)
+	}
+}
+
+func TestGetBytes(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	if _, err := PutBytes(ctx, store, "plans/dev/p.tfplan.json", []byte(`{"environment":"dev"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := GetBytes(ctx, store, "plans/dev/p.tfplan.json"); err != nil || string(data) != `{"environment":"dev"}` {
+		t.Errorf("GetBytes() = %q, %v", data, err)
+	}
+	if _, err := GetBytes(ctx, store, "missing"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("GetBytes() of a missing key = %v", err)
+	}
+}
+
+// headersServer is an S3 endpoint that keeps the headers of every object put to it
+func headersServer(t *testing.T) (*S3Store, map[string]http.Header) {
+	t.Helper()
+	puts := map[string]http.Header{}
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.Method == http.MethodPut {
+			mu.Lock()
+			puts[strings.TrimPrefix(r.URL.Path, "/bucket/")] = r.Header.Clone()
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	t.Cleanup(server.Close)
+	cfg := aws.Config{Region: "us-east-1", Credentials: aws.AnonymousCredentials{}}
+	return NewS3Store(NewS3Client(cfg, S3ClientOptions{Endpoint: server.URL, UsePathStyle: true}), "bucket"), puts
+}
+
+func TestUploadHeaders(t *testing.T) {
+	chdir(t, t.TempDir())
+	os.Mkdir("envs", 0o755)
+	writeFile(t, "envs/dev.tfvars", "a = 1")
+	writeFile(t, "prod.tfvars.json", `{"a": 1}`)
+	writeFile(t, "prod.tfplan", "plan bytes")
+	store, puts := headersServer(t)
+	ctx := context.Background()
+
+	for _, up := range []struct{ key, file, contentType string }{
+		{"dev.tfvars", "envs/dev.tfvars", ""},
+		{"prod.tfvars.json", "prod.tfvars.json", ""},
+		{"plans/prod.tfplan", "prod.tfplan", ""},
+		{"custom.tfvars", "envs/dev.tfvars", "text/x-hcl"},
+	} {
+		if _, err := UploadKey(ctx, store, up.key, up.file, UploadOptions{ContentType: up.contentType}); err != nil {
+			t.Fatalf("UploadKey(%s): %v", up.file, err)
+		}
+	}
+	if _, err := PutBytes(ctx, store, "plans/prod.tfplan.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PutBytes(ctx, store, "changes/prod/20240501T130405Z.diff", []byte("-a\n+b\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string][3]string{
+		"dev.tfvars":                         {"text/plain; charset=utf-8", "no-cache", `attachment; filename=dev.tfvars`},
+		"prod.tfvars.json":                   {"application/json", "no-cache", `attachment; filename=prod.tfvars.json`},
+		"plans/prod.tfplan":                  {"application/octet-stream", "", `attachment; filename=prod.tfplan`},
+		"plans/prod.tfplan.json":             {"application/json", "", `attachment; filename=prod.tfplan.json`},
+		"changes/prod/20240501T130405Z.diff": {"text/x-diff; charset=utf-8", "", `attachment; filename=20240501T130405Z.diff`},
+		"custom.tfvars":                      {"text/x-hcl", "no-cache", `attachment; filename=dev.tfvars`},
+	} {
+		h, ok := puts[key]
+		if !ok {
+			t.Errorf("%s was not put, puts = %v", key, puts)
+			continue
+		}
+		if got := [3]string{h.Get("Content-Type"), h.Get("Cache-Control"), h.Get("Content-Disposition")}; got != want {
+			t.Errorf("%s put with Content-Type, Cache-Control, Content-Disposition = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestCopyStorageClass(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+	if _, err := PutBytes(ctx, m, "team/plans/dev/a.tfplan", []byte("plan")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := Copy(ctx, m, CopyInput{Source: "team/plans/dev/a.tfplan", Key: "team/archive/dev/a.tfplan", StorageClass: "GLACIER"})
+	if err != nil || info.StorageClass != "GLACIER" {
+		t.Fatalf("Copy() = %+v, %v, want a GLACIER copy", info, err)
+	}
+	if data, ok := m.Bytes("team/archive/dev/a.tfplan"); !ok || string(data) != "plan" {
+		t.Errorf("copy = %q, %v", data, ok)
+	}
+
+	files := NewFileStore(t.TempDir())
+	if _, err := PutBytes(ctx, files, "a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Copy(ctx, files, CopyInput{Source: "a", Key: "b", StorageClass: "GLACIER"}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Copy() with a storage class to a file store = %v, want ErrUnsupported", err)
+	}
+}
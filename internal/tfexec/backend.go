@@ -0,0 +1,146 @@
+package tfexec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrNoBackend is returned by ReadBackend for a directory whose state is
+// local, without a backend block.
+var ErrNoBackend = errors.New("no backend is configured")
+
+// DefaultWorkspaceKeyPrefix is where the s3 backend keeps the states of the
+// workspaces other than default.
+const DefaultWorkspaceKeyPrefix = "env:"
+
+// Backend is the backend a terraform directory keeps its state in.
+type Backend struct {
+	Type string
+	// Key is where the state of the default workspace is: the key of the s3
+	// and azurerm backends, the prefix of gcs or the path of local.
+	Key                string
+	WorkspaceKeyPrefix string
+	// Source is the file the backend was read from.
+	Source string
+}
+
+// StatePath is where the state of workspace is kept. The s3 backend puts
+// workspaces other than default under <workspace_key_prefix>/<workspace>/.
+func (b Backend) StatePath(workspace string) string {
+	if workspace == "" || workspace == "default" || b.Type != "s3" {
+		return b.Key
+	}
+	prefix := b.WorkspaceKeyPrefix
+	if prefix == "" {
+		prefix = DefaultWorkspaceKeyPrefix
+	}
+	return prefix + "/" + workspace + "/" + b.Key
+}
+
+// backendKeyAttributes are the settings that say where a backend keeps the state
+var backendKeyAttributes = []string{"key", "prefix", "path"}
+
+// ReadBackend gives back the backend dir is initialized with, from the copy
+// terraform init keeps in .terraform/terraform.tfstate. Before init has run
+// the backend block of the .tf files is read instead, settings passed with
+// -backend-config are only in the former.
+func ReadBackend(dir string) (Backend, error) {
+	if dir == "" {
+		dir = "."
+	}
+	cached := filepath.Join(dir, ".terraform", "terraform.tfstate")
+	data, err := os.ReadFile(cached)
+	if err == nil {
+		var state struct {
+			Backend *struct {
+				Type   string         `json:"type"`
+				Config map[string]any `json:"config"`
+			} `json:"backend"`
+		}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return Backend{}, fmt.Errorf("failed to read %s: %w", cached, err)
+		}
+		if state.Backend == nil {
+			return Backend{}, fmt.Errorf("%w in %s", ErrNoBackend, cached)
+		}
+		b := Backend{Type: state.Backend.Type, Source: cached}
+		b.WorkspaceKeyPrefix, _ = state.Backend.Config["workspace_key_prefix"].(string)
+		for _, attr := range backendKeyAttributes {
+			if v, ok := state.Backend.Config[attr].(string); ok && v != "" {
+				b.Key = v
+				break
+			}
+		}
+		return b, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return Backend{}, err
+	}
+	return readBackendBlock(dir)
+}
+
+var (
+	backendBlock     = regexp.MustCompile(`(?m)^\s*backend\s+"([^"]+)"\s*\{`)
+	backendAttribute = regexp.MustCompile(`(?m)^\s*(\w+)\s*=\s*"([^"]*)"`)
+)
+
+// readBackendBlock finds the backend block in the .tf files of dir
+func readBackendBlock(dir string) (Backend, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return Backend{}, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return Backend{}, err
+		}
+		text := string(data)
+		m := backendBlock.FindStringSubmatchIndex(text)
+		if m == nil {
+			continue
+		}
+		body := blockBody(text[m[1]:])
+		b := Backend{Type: text[m[2]:m[3]], Source: file}
+		attrs := map[string]string{}
+		for _, a := range backendAttribute.FindAllStringSubmatch(body, -1) {
+			attrs[a[1]] = a[2]
+		}
+		b.WorkspaceKeyPrefix = attrs["workspace_key_prefix"]
+		for _, attr := range backendKeyAttributes {
+			if attrs[attr] != "" {
+				b.Key = attrs[attr]
+				break
+			}
+		}
+		return b, nil
+	}
+	return Backend{}, fmt.Errorf("%w in %s", ErrNoBackend, dir)
+}
+
+// blockBody is the text up to the brace that closes the block, nested blocks
+// such as assume_role and braces in strings included
+func blockBody(text string) string {
+	depth, inString := 1, false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth--; depth == 0 {
+				return text[:i]
+			}
+		}
+	}
+	return text
+}
@@ -0,0 +1,44 @@
+package tfexec
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBackend(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ReadBackend(dir); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("ReadBackend() without a backend = %v, want ErrNoBackend", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(`terraform {
+  backend "s3" {
+    bucket = "states"
+    assume_role {
+      role_arn = "arn:aws:iam::123456789012:role/state"
+    }
+    key    = "staging/app/terraform.tfstate"
+  }
+}
+`), 0o644)
+	b, err := ReadBackend(dir)
+	if err != nil || b.Type != "s3" || b.Key != "staging/app/terraform.tfstate" || b.Source != filepath.Join(dir, "backend.tf") {
+		t.Fatalf("ReadBackend() from the block = %+v, %v", b, err)
+	}
+
+	// after init the cached config wins, it has the -backend-config settings
+	os.MkdirAll(filepath.Join(dir, ".terraform"), 0o755)
+	os.WriteFile(filepath.Join(dir, ".terraform", "terraform.tfstate"), []byte(`{"version":3,"backend":{"type":"s3","config":{"bucket":"states","key":"prod/app/terraform.tfstate","workspace_key_prefix":"workspaces"}}}`), 0o644)
+	b, err = ReadBackend(dir)
+	if err != nil || b.Key != "prod/app/terraform.tfstate" {
+		t.Fatalf("ReadBackend() after init = %+v, %v", b, err)
+	}
+	if got := b.StatePath("blue"); got != "workspaces/blue/prod/app/terraform.tfstate" {
+		t.Errorf("StatePath(blue) = %q", got)
+	}
+	if got := b.StatePath("default"); got != "prod/app/terraform.tfstate" {
+		t.Errorf("StatePath(default) = %q", got)
+	}
+}
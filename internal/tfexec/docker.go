@@ -0,0 +1,296 @@
+package tfexec
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDockerImage is the image DockerRunner uses when it isn't given one.
+const DefaultDockerImage = "hashicorp/terraform:latest"
+
+// ErrDockerUnavailable is returned when docker isn't installed or its daemon
+// can't be reached.
+var ErrDockerUnavailable = errors.New("docker is not available")
+
+// DockerImage turns what follows docker: in --runner docker:<image> into an
+// image. A bare version such as 1.9.5 is that tag of hashicorp/terraform.
+func DockerImage(spec string) string {
+	if spec == "" {
+		return DefaultDockerImage
+	}
+	if versionTag.MatchString(spec) {
+		return "hashicorp/terraform:" + spec
+	}
+	return spec
+}
+
+var versionTag = regexp.MustCompile(`^\d+\.\d+(\.\d+)?(-[0-9A-Za-z.]+)?$`)
+
+// dockerPassthrough are the prefixes of the variables of this machine's
+// environment the container gets as well, on top of RunOptions.Env.
+var dockerPassthrough = []string{"AWS_", "TF_"}
+
+// dockerFileVars are the variables that name a file terraform or the AWS SDK
+// reads, the file is mounted read-only at the same path.
+var dockerFileVars = []string{"AWS_CA_BUNDLE", "AWS_CONFIG_FILE", "AWS_SHARED_CREDENTIALS_FILE", "AWS_WEB_IDENTITY_TOKEN_FILE", "TF_CLI_CONFIG_FILE"}
+
+// dockerHome is HOME in the container. It has to be writable by any user,
+// since terraform runs as this machine's user so what it writes isn't owned
+// by root.
+const dockerHome = "/tmp"
+
+// DockerRunner runs terraform in a container instead of on this machine. The
+// working directory is mounted at the same path, so relative and absolute
+// paths mean the same inside and out. The tfvars are mounted read-only, and
+// other files given as absolute paths outside the working directory, such as
+// a plan in the temp directory, get their directory mounted. The variables
+// of RunOptions.Env and the AWS_ and TF_ variables are passed on by name, so
+// their values are never on the docker command line, and with a profile
+// instead of keys ~/.aws is mounted read-only.
+//
+// The container's exit code is terraform's. When the context is cancelled
+// the container is interrupted and killed after KillDelay like ExecRunner
+// does it, rather than leaving it running when the docker CLI exits.
+type DockerRunner struct {
+	// Image is the terraform image, DefaultDockerImage when empty. Its
+	// entrypoint has to be terraform, like hashicorp/terraform's.
+	Image string
+	// Docker runs the docker CLI, ExecRunner{Binary: "docker"} when nil.
+	Docker TerraformRunner
+	// KillDelay is how long an interrupted container gets to stop by itself,
+	// DefaultKillDelay when zero.
+	KillDelay time.Duration
+}
+
+func (r DockerRunner) Run(ctx context.Context, args []string, opts RunOptions) error {
+	docker := r.Docker
+	if docker == nil {
+		docker = ExecRunner{Binary: "docker"}
+	}
+	name, err := randomName("tfmanage-")
+	if err != nil {
+		return err
+	}
+	dockerArgs, err := r.runArgs(name, args, opts)
+	if err != nil {
+		return err
+	}
+
+	run := opts
+	watcher := &daemonWatcher{}
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	run.Stderr = io.MultiWriter(stderr, watcher)
+
+	stopped := make(chan struct{})
+	var stopping sync.WaitGroup
+	stopping.Add(1)
+	go func() {
+		defer stopping.Done()
+		select {
+		case <-ctx.Done():
+			r.stop(docker, name, stopped)
+		case <-stopped:
+		}
+	}()
+	// the docker CLI isn't cancelled with ctx, the container is, and the CLI exits with its exit code
+	err = docker.Run(context.WithoutCancel(ctx), dockerArgs, run)
+	if ctx.Err() != nil {
+		// a Ctrl-C at the terminal can end the CLI before the container has stopped
+		docker.Run(context.Background(), []string{"wait", name}, RunOptions{Stdout: io.Discard, Stderr: io.Discard})
+	}
+	close(stopped)
+	stopping.Wait()
+
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return fmt.Errorf("%w: the docker CLI isn't installed or isn't on the PATH", ErrDockerUnavailable)
+	case err != nil && watcher.seen:
+		return fmt.Errorf("%w: the docker daemon can't be reached: %w", ErrDockerUnavailable, err)
+	}
+	return err
+}
+
+// stop interrupts the container so terraform can release its state lock,
+// and kills it when it is still running after the kill delay
+func (r DockerRunner) stop(docker TerraformRunner, name string, stopped <-chan struct{}) {
+	quiet := RunOptions{Stdout: io.Discard, Stderr: io.Discard}
+	docker.Run(context.Background(), []string{"kill", "--signal", "INT", name}, quiet)
+	delay := r.KillDelay
+	if delay == 0 {
+		delay = DefaultKillDelay
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		docker.Run(context.Background(), []string{"kill", name}, quiet)
+	case <-stopped:
+	}
+}
+
+// runArgs builds the docker run command for terraform's args. The -e
+// variables take their values from the docker CLI's environment, which is
+// opts.Env on top of this process's
+func (r DockerRunner) runArgs(name string, args []string, opts RunOptions) ([]string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	workdir := cwd
+	if opts.Dir != "" {
+		if workdir, err = filepath.Abs(opts.Dir); err != nil {
+			return nil, err
+		}
+	}
+	image := r.Image
+	if image == "" {
+		image = DefaultDockerImage
+	}
+
+	// --sig-proxy=false: stop interrupts the container once, the CLI forwarding a Ctrl-C as well would make it two and terraform exit without cleaning up
+	dockerArgs := []string{"run", "--rm", "--name", name, "--sig-proxy=false"}
+	switch {
+	case isTerminal(opts.Stdin):
+		dockerArgs = append(dockerArgs, "-i", "-t")
+	case opts.Stdin != nil:
+		dockerArgs = append(dockerArgs, "-i")
+	}
+	if runtime.GOOS != "windows" {
+		dockerArgs = append(dockerArgs, "--user", strconv.Itoa(os.Getuid())+":"+strconv.Itoa(os.Getgid()))
+	}
+
+	mounts := []string{cwd + ":" + cwd}
+	mount := func(path, options string) {
+		m := path + ":" + path + options
+		if !slices.Contains(mounts, m) {
+			mounts = append(mounts, m)
+		}
+	}
+	for i, arg := range args {
+		flagName, value, isFlag := strings.Cut(arg, "=")
+		switch {
+		case arg == "-var-file" && i+1 < len(args):
+			mount(absOrSelf(args[i+1]), ":ro")
+		case isFlag && flagName == "-var-file":
+			mount(absOrSelf(value), ":ro")
+		case isFlag && strings.HasPrefix(flagName, "-") && filepath.IsAbs(value) && !within(cwd, value):
+			mount(filepath.Dir(value), "")
+		case filepath.IsAbs(arg) && !within(cwd, arg) && (i == 0 || args[i-1] != "-var-file"):
+			mount(filepath.Dir(arg), "")
+		}
+	}
+
+	names := map[string]bool{}
+	for _, e := range os.Environ() {
+		name, _, _ := strings.Cut(e, "=")
+		for _, prefix := range dockerPassthrough {
+			if strings.HasPrefix(name, prefix) {
+				names[name] = true
+			}
+		}
+	}
+	for _, e := range opts.Env {
+		name, _, _ := strings.Cut(e, "=")
+		names[name] = true
+	}
+	lookup := func(name string) string {
+		value := os.Getenv(name)
+		for _, e := range opts.Env {
+			if v, ok := strings.CutPrefix(e, name+"="); ok {
+				value = v
+			}
+		}
+		return value
+	}
+	for _, v := range dockerFileVars {
+		if path := lookup(v); filepath.IsAbs(path) {
+			mount(path, ":ro")
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil && lookup("AWS_PROFILE") != "" && lookup("AWS_ACCESS_KEY_ID") == "" {
+		if info, err := os.Stat(filepath.Join(home, ".aws")); err == nil && info.IsDir() {
+			mounts = append(mounts, filepath.Join(home, ".aws")+":"+dockerHome+"/.aws:ro")
+		}
+	}
+	for _, m := range mounts {
+		dockerArgs = append(dockerArgs, "-v", m)
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	slices.Sort(sorted)
+	for _, name := range sorted {
+		dockerArgs = append(dockerArgs, "-e", name)
+	}
+	dockerArgs = append(dockerArgs, "-e", "HOME="+dockerHome, "-w", workdir, image)
+	return append(dockerArgs, args...), nil
+}
+
+func absOrSelf(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// within reports whether path is dir or under it
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// randomName is prefix and 12 random hex digits, for names that mustn't clash between runs
+func randomName(prefix string) (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// daemonUnreachable is what the docker CLI says when there is no daemon to talk to
+var daemonUnreachable = regexp.MustCompile(`(?i)(cannot connect to the docker daemon|error during connect|is the docker daemon running|permission denied while trying to connect to the docker daemon)`)
+
+// daemonWatcher remembers whether the docker CLI said it can't reach the daemon
+type daemonWatcher struct {
+	tail []byte
+	seen bool
+}
+
+func (w *daemonWatcher) Write(p []byte) (int, error) {
+	w.tail = append(w.tail, p...)
+	if daemonUnreachable.Match(w.tail) {
+		w.seen = true
+	}
+	if len(w.tail) > 256 {
+		w.tail = w.tail[len(w.tail)-256:]
+	}
+	return len(p), nil
+}
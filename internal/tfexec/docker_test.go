@@ -0,0 +1,146 @@
+package tfexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDockerImage(t *testing.T) {
+	for spec, want := range map[string]string{
+		"":                          DefaultDockerImage,
+		"1.9.5":                     "hashicorp/terraform:1.9.5",
+		"1.10.0-beta1":              "hashicorp/terraform:1.10.0-beta1",
+		"registry.local/tf:1.9":     "registry.local/tf:1.9",
+		"hashicorp/terraform:1.8.0": "hashicorp/terraform:1.8.0",
+	} {
+		if got := DockerImage(spec); got != want {
+			t.Errorf("DockerImage(%q) = %q, want %q", spec, got, want)
+		}
+	}
+}
+
+// pair finds flag followed by value in args
+func pair(args []string, flag, value string) bool {
+	for i := range len(args) - 1 {
+		if args[i] == flag && args[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDockerRunArgs(t *testing.T) {
+	cwd, _ := os.Getwd()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.Mkdir(filepath.Join(home, ".aws"), 0o700)
+	t.Setenv("AWS_PROFILE", "deploy")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("TF_LOG", "info")
+	planDir := t.TempDir()
+	varFile := filepath.Join(cwd, "prod.tfvars")
+
+	docker := &RecordingRunner{}
+	r := DockerRunner{Image: "hashicorp/terraform:1.9.5", Docker: docker}
+	args := []string{"-chdir=infra", "plan", "-var-file", varFile, "-out=" + filepath.Join(planDir, "prod.tfplan")}
+	env := []string{"TF_WORKSPACE=production", "TF_VAR_db_password=hunter2"}
+	if err := r.Run(context.Background(), args, RunOptions{Env: env, Stdin: strings.NewReader("")}); err != nil {
+		t.Fatal(err)
+	}
+	call := docker.Calls[0]
+	got := call.Args
+	if !slices.Equal(got[:2], []string{"run", "--rm"}) || !slices.Contains(got, "--sig-proxy=false") || !slices.Equal(got[len(got)-len(args)-1:], append([]string{"hashicorp/terraform:1.9.5"}, args...)) {
+		t.Fatalf("docker %q", got)
+	}
+	for _, p := range [][2]string{
+		{"-v", cwd + ":" + cwd},
+		{"-v", varFile + ":" + varFile + ":ro"},
+		{"-v", planDir + ":" + planDir},
+		{"-v", filepath.Join(home, ".aws") + ":/tmp/.aws:ro"},
+		{"-e", "TF_WORKSPACE"},
+		{"-e", "TF_VAR_db_password"},
+		{"-e", "TF_LOG"},
+		{"-e", "AWS_PROFILE"},
+		{"-e", "HOME=/tmp"},
+		{"-w", cwd},
+	} {
+		if !pair(got, p[0], p[1]) {
+			t.Errorf("docker %q is missing %s %s", got, p[0], p[1])
+		}
+	}
+	// a pipe is passed on, but only a terminal gets a tty
+	if !slices.Contains(got, "-i") || slices.Contains(got, "-t") {
+		t.Errorf("docker %q, want -i without -t", got)
+	}
+	if strings.Contains(strings.Join(got, " "), "hunter2") || !slices.Equal(call.Opts.Env, env) {
+		t.Errorf("the variables' values should only be in the docker CLI's environment: %q", got)
+	}
+}
+
+// blockingDocker is a docker CLI whose run only ends once the container was killed
+type blockingDocker struct {
+	mu     sync.Mutex
+	calls  [][]string
+	killed chan struct{}
+}
+
+func (d *blockingDocker) Run(ctx context.Context, args []string, opts RunOptions) error {
+	d.mu.Lock()
+	d.calls = append(d.calls, args)
+	d.mu.Unlock()
+	switch args[0] {
+	case "run":
+		<-d.killed
+		return &FakeExitError{Code: 130}
+	case "kill":
+		close(d.killed)
+	}
+	return nil
+}
+
+func TestDockerRunnerCancel(t *testing.T) {
+	docker := &blockingDocker{killed: make(chan struct{})}
+	r := DockerRunner{Docker: docker, KillDelay: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	err := r.Run(ctx, []string{"apply"}, RunOptions{})
+	if code, ok := ExitCode(err); !ok || code != 130 {
+		t.Errorf("Run() = %v, want the container's exit code", err)
+	}
+	name := docker.calls[0][slices.Index(docker.calls[0], "--name")+1]
+	if want := []string{"kill", "--signal", "INT", name}; !slices.Equal(docker.calls[1], want) {
+		t.Errorf("docker calls %q, want the container interrupted", docker.calls)
+	}
+	if last := docker.calls[len(docker.calls)-1]; !slices.Equal(last, []string{"wait", name}) {
+		t.Errorf("docker calls %q, want a wait for the container", docker.calls)
+	}
+}
+
+func TestDockerUnavailable(t *testing.T) {
+	r := DockerRunner{Docker: &RecordingRunner{
+		Stderr: "docker: Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?\n",
+		Result: func([]string) error { return &FakeExitError{Code: 125} },
+	}}
+	if err := r.Run(context.Background(), []string{"plan"}, RunOptions{Stderr: &strings.Builder{}}); !errors.Is(err, ErrDockerUnavailable) {
+		t.Errorf("Run() without a daemon = %v", err)
+	}
+	r = DockerRunner{Docker: &RecordingRunner{Result: func([]string) error { return fmt.Errorf("exec: %w", exec.ErrNotFound) }}}
+	if err := r.Run(context.Background(), []string{"plan"}, RunOptions{}); !errors.Is(err, ErrDockerUnavailable) {
+		t.Errorf("Run() without docker = %v", err)
+	}
+	// terraform failing in the container is only terraform failing
+	r = DockerRunner{Docker: &RecordingRunner{Result: func([]string) error { return &FakeExitError{Code: 1} }}}
+	if err := r.Run(context.Background(), []string{"plan"}, RunOptions{}); errors.Is(err, ErrDockerUnavailable) {
+		t.Errorf("Run() = %v", err)
+	}
+}
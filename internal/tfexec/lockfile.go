@@ -0,0 +1,169 @@
+package tfexec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LockFileName is the dependency lock file terraform init writes next to the
+// configuration.
+const LockFileName = ".terraform.lock.hcl"
+
+// LockedProvider is one provider block of a dependency lock file.
+type LockedProvider struct {
+	// Source is the provider's full address, like
+	// registry.terraform.io/hashicorp/aws.
+	Source  string `json:"source"`
+	Version string `json:"version,omitempty"`
+	// Constraints is the version constraint from the configuration. A block
+	// can have it without a version when init hasn't picked one yet.
+	Constraints string `json:"constraints,omitempty"`
+	// Hashes is how many package hashes are recorded, there are more with
+	// every platform the lock file was made for.
+	Hashes int `json:"hashes,omitempty"`
+}
+
+// ShortSource is the source without the public registry's host, the way
+// terraform prints it.
+func (p LockedProvider) ShortSource() string {
+	return strings.TrimPrefix(p.Source, "registry.terraform.io/")
+}
+
+var (
+	lockProviderLine = regexp.MustCompile(`^provider\s+"([^"]+)"\s*\{\s*$`)
+	lockValueLine    = regexp.MustCompile(`^(version|constraints)\s*=\s*"([^"]*)"\s*$`)
+	lockHashesLine   = regexp.MustCompile(`^hashes\s*=\s*\[`)
+	lockQuoted       = regexp.MustCompile(`"[^"]*"`)
+)
+
+// ReadLockFile reads the lock file in dir. A missing file is an error that
+// errors.Is(err, fs.ErrNotExist) matches.
+func ReadLockFile(dir string) ([]LockedProvider, error) {
+	data, err := os.ReadFile(filepath.Join(dir, LockFileName))
+	if err != nil {
+		return nil, err
+	}
+	return ParseLockFile(data)
+}
+
+// ParseLockFile reads the provider blocks of a lock file, sorted by source.
+// It only understands the layout terraform writes: one attribute per line
+// and hashes as a list that can span lines.
+func ParseLockFile(data []byte) ([]LockedProvider, error) {
+	var providers []LockedProvider
+	var current *LockedProvider
+	inHashes := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if inHashes {
+			current.Hashes += len(lockQuoted.FindAllString(line, -1))
+			inHashes = !strings.Contains(line, "]")
+			continue
+		}
+		if current == nil {
+			m := lockProviderLine.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("%s line %d: expected a provider block, got %q", LockFileName, n, line)
+			}
+			current = &LockedProvider{Source: m[1]}
+			continue
+		}
+		switch {
+		case line == "}":
+			providers = append(providers, *current)
+			current = nil
+		case lockHashesLine.MatchString(line):
+			rest := lockHashesLine.ReplaceAllString(line, "")
+			current.Hashes += len(lockQuoted.FindAllString(rest, -1))
+			inHashes = !strings.Contains(rest, "]")
+		default:
+			if m := lockValueLine.FindStringSubmatch(line); m != nil {
+				if m[1] == "version" {
+					current.Version = m[2]
+				} else {
+					current.Constraints = m[2]
+				}
+			}
+			// anything else is left for newer terraform versions to add
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, fmt.Errorf("%s: the block of %s isn't closed", LockFileName, current.Source)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Source < providers[j].Source })
+	return providers, nil
+}
+
+// LockChange is how one provider differs between two lock files. From is
+// the zero LockedProvider for a provider that was added, To for one that was
+// removed.
+type LockChange struct {
+	From, To LockedProvider
+}
+
+// String says what changed the way people talk about it, a version bump
+// rather than a list of hashes.
+func (c LockChange) String() string {
+	switch {
+	case c.From.Source == "":
+		return "+ " + c.To.ShortSource() + " " + lockedVersion(c.To)
+	case c.To.Source == "":
+		return "- " + c.From.ShortSource() + " " + lockedVersion(c.From)
+	case c.From.Version != c.To.Version:
+		return "~ " + c.To.ShortSource() + " " + lockedVersion(c.From) + " -> " + lockedVersion(c.To)
+	case c.From.Constraints != c.To.Constraints:
+		return fmt.Sprintf("~ %s %s constraints %q -> %q", c.To.ShortSource(), lockedVersion(c.To), c.From.Constraints, c.To.Constraints)
+	}
+	return fmt.Sprintf("~ %s %s package hashes %d -> %d", c.To.ShortSource(), lockedVersion(c.To), c.From.Hashes, c.To.Hashes)
+}
+
+func lockedVersion(p LockedProvider) string {
+	if p.Version == "" {
+		return "(" + p.Constraints + ")"
+	}
+	return p.Version
+}
+
+// DiffLockFiles lists the providers that were added, removed or changed
+// going from one lock file to the other, sorted by source. A provider whose
+// hashes changed without a new version, such as one locked for more
+// platforms, is a change too.
+func DiffLockFiles(from, to []LockedProvider) []LockChange {
+	before := map[string]LockedProvider{}
+	for _, p := range from {
+		before[p.Source] = p
+	}
+	var changes []LockChange
+	for _, p := range to {
+		old, ok := before[p.Source]
+		delete(before, p.Source)
+		if !ok || old != p {
+			changes = append(changes, LockChange{From: old, To: p})
+		}
+	}
+	for _, p := range before {
+		changes = append(changes, LockChange{From: p})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].source() < changes[j].source() })
+	return changes
+}
+
+func (c LockChange) source() string {
+	if c.To.Source != "" {
+		return c.To.Source
+	}
+	return c.From.Source
+}
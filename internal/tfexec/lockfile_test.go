@@ -0,0 +1,87 @@
+package tfexec
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+const lockFile = `# This file is maintained automatically by "terraform init".
+# Manual edits may be lost in future updates.
+
+provider "registry.terraform.io/hashicorp/random" {
+  version     = "3.6.0"
+  constraints = "~> 3.5"
+  hashes      = ["h1:R5Ucn26riKIEijcsiOMBR3uOAjuOMfI1x7XvH4P6B1w="]
+}
+
+provider "registry.terraform.io/hashicorp/aws" {
+  version     = "5.31.0"
+  constraints = ">= 5.0.0, < 6.0.0"
+  hashes = [
+    "h1:ltxyuBWIy9cq0kIKDJH1jeWJy/y7XJLjS4QrsQK4plA=",
+    "zh:0cdb9c2083bf0902442384f7309367791e4640581652dda456f2d6d7abf0de8d",
+    "zh:2fe4884cb9642f48a5889f8dff8f5f511418a18537a9dfa77ada3bcdad391e4e",
+  ]
+}
+
+provider "example.com/acme/widgets" {
+  constraints = "1.2.0"
+}
+`
+
+func TestParseLockFile(t *testing.T) {
+	got, err := ParseLockFile([]byte(lockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LockedProvider{
+		{Source: "example.com/acme/widgets", Constraints: "1.2.0"},
+		{Source: "registry.terraform.io/hashicorp/aws", Version: "5.31.0", Constraints: ">= 5.0.0, < 6.0.0", Hashes: 3},
+		{Source: "registry.terraform.io/hashicorp/random", Version: "3.6.0", Constraints: "~> 3.5", Hashes: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLockFile() = %+v, want %+v", got, want)
+	}
+	if got[1].ShortSource() != "hashicorp/aws" || got[0].ShortSource() != "example.com/acme/widgets" {
+		t.Errorf("ShortSource() = %q, %q", got[1].ShortSource(), got[0].ShortSource())
+	}
+
+	for _, bad := range []string{`terraform {}`, "provider \"registry.terraform.io/hashicorp/aws\" {\n  version = \"5.31.0\"\n"} {
+		if _, err := ParseLockFile([]byte(bad)); err == nil {
+			t.Errorf("ParseLockFile(%q) gave no error", bad)
+		}
+	}
+	if _, err := ReadLockFile(t.TempDir()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadLockFile() of a directory without one = %v, want not exist", err)
+	}
+}
+
+func TestDiffLockFiles(t *testing.T) {
+	aws := LockedProvider{Source: "registry.terraform.io/hashicorp/aws", Version: "5.30.0", Constraints: "~> 5.0", Hashes: 2}
+	newerAWS := aws
+	newerAWS.Version = "5.31.0"
+	morePlatforms := aws
+	morePlatforms.Hashes = 6
+	null := LockedProvider{Source: "registry.terraform.io/hashicorp/null", Version: "3.2.1"}
+	widgets := LockedProvider{Source: "example.com/acme/widgets", Constraints: "1.2.0"}
+
+	for _, tc := range []struct {
+		name     string
+		from, to []LockedProvider
+		want     []string
+	}{
+		{"same", []LockedProvider{aws, null}, []LockedProvider{aws, null}, nil},
+		{"upgrade, add and remove", []LockedProvider{aws, null}, []LockedProvider{newerAWS, widgets}, []string{"+ example.com/acme/widgets (1.2.0)", "~ hashicorp/aws 5.30.0 -> 5.31.0", "- hashicorp/null 3.2.1"}},
+		{"more platforms", []LockedProvider{aws}, []LockedProvider{morePlatforms}, []string{"~ hashicorp/aws 5.30.0 package hashes 2 -> 6"}},
+	} {
+		var got []string
+		for _, c := range DiffLockFiles(tc.from, tc.to) {
+			got = append(got, c.String())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: DiffLockFiles() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package tfexec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DataDir is where terraform keeps the modules, providers and backend of
+// chdir: TF_DATA_DIR when it is set, .terraform otherwise.
+func DataDir(chdir string) string {
+	dir := os.Getenv("TF_DATA_DIR")
+	if dir == "" {
+		dir = ".terraform"
+	}
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(chdir, dir)
+}
+
+// InstalledModule is one module call terraform init or get installed.
+type InstalledModule struct {
+	// Key is the module's path in the configuration, like vpc or vpc.subnets.
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	// Version is empty for sources without versions, such as git.
+	Version string `json:"version,omitempty"`
+}
+
+// ReadModules reads the modules installed in dataDir, sorted by key. Before
+// any module was installed there are none.
+func ReadModules(dataDir string) ([]InstalledModule, error) {
+	manifest := filepath.Join(dataDir, "modules", "modules.json")
+	data, err := os.ReadFile(manifest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m struct {
+		Modules []struct {
+			Key     string `json:"Key"`
+			Source  string `json:"Source"`
+			Version string `json:"Version"`
+		} `json:"Modules"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", manifest, err)
+	}
+	var modules []InstalledModule
+	for _, mod := range m.Modules {
+		// the root module is in the manifest too
+		if mod.Key != "" {
+			modules = append(modules, InstalledModule{Key: mod.Key, Source: mod.Source, Version: mod.Version})
+		}
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Key < modules[j].Key })
+	return modules, nil
+}
+
+// ModuleChange is how one module call differs between two installs. From is
+// the zero InstalledModule for a module that was added, To for one that was
+// removed.
+type ModuleChange struct {
+	From, To InstalledModule
+}
+
+// String says what changed like LockChange does.
+func (c ModuleChange) String() string {
+	switch {
+	case c.From.Key == "":
+		return "+ module." + c.To.Key + " " + moduleVersion(c.To)
+	case c.To.Key == "":
+		return "- module." + c.From.Key + " " + moduleVersion(c.From)
+	case c.From.Source != c.To.Source:
+		return "~ module." + c.To.Key + " " + moduleVersion(c.From) + " -> " + moduleVersion(c.To)
+	}
+	return "~ module." + c.To.Key + " " + shortModuleSource(c.To.Source) + " " + c.From.Version + " -> " + c.To.Version
+}
+
+func moduleVersion(m InstalledModule) string {
+	if m.Version == "" {
+		return shortModuleSource(m.Source)
+	}
+	return shortModuleSource(m.Source) + " " + m.Version
+}
+
+// shortModuleSource is a registry source the way it is written in the configuration
+func shortModuleSource(source string) string {
+	return strings.TrimPrefix(source, "registry.terraform.io/")
+}
+
+// DiffModules lists the module calls that were added, removed or changed
+// going from one install to the other, sorted by key.
+func DiffModules(from, to []InstalledModule) []ModuleChange {
+	before := map[string]InstalledModule{}
+	for _, m := range from {
+		before[m.Key] = m
+	}
+	var changes []ModuleChange
+	for _, m := range to {
+		old, ok := before[m.Key]
+		delete(before, m.Key)
+		if !ok || old != m {
+			changes = append(changes, ModuleChange{From: old, To: m})
+		}
+	}
+	for _, m := range before {
+		changes = append(changes, ModuleChange{From: m})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].key() < changes[j].key() })
+	return changes
+}
+
+func (c ModuleChange) key() string {
+	if c.To.Key != "" {
+		return c.To.Key
+	}
+	return c.From.Key
+}
@@ -0,0 +1,59 @@
+package tfexec
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeModules(t *testing.T, dataDir, manifest string) {
+	t.Helper()
+	os.MkdirAll(filepath.Join(dataDir, "modules"), 0o755)
+	if err := os.WriteFile(filepath.Join(dataDir, "modules", "modules.json"), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffModules(t *testing.T) {
+	dir := t.TempDir()
+	if modules, err := ReadModules(dir); err != nil || modules != nil {
+		t.Errorf("ReadModules() without a manifest = %v, %v", modules, err)
+	}
+
+	writeModules(t, dir, `{"Modules":[{"Key":"","Source":"","Dir":"."},
+{"Key":"vpc","Source":"registry.terraform.io/terraform-aws-modules/vpc/aws","Version":"5.1.1","Dir":".terraform/modules/vpc"},
+{"Key":"dns","Source":"git::https://example.com/dns.git?ref=v1","Dir":".terraform/modules/dns"},
+{"Key":"old","Source":"./modules/old","Dir":"modules/old"}]}`)
+	before, err := ReadModules(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 3 || before[0].Key != "dns" {
+		t.Fatalf("ReadModules() = %+v", before)
+	}
+
+	writeModules(t, dir, `{"Modules":[{"Key":"","Source":"","Dir":"."},
+{"Key":"vpc","Source":"registry.terraform.io/terraform-aws-modules/vpc/aws","Version":"5.2.0","Dir":".terraform/modules/vpc"},
+{"Key":"dns","Source":"git::https://example.com/dns.git?ref=v2","Dir":".terraform/modules/dns"},
+{"Key":"new","Source":"./modules/new","Dir":"modules/new"}]}`)
+	after, _ := ReadModules(dir)
+	var got []string
+	for _, c := range DiffModules(before, after) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"~ module.dns git::https://example.com/dns.git?ref=v1 -> git::https://example.com/dns.git?ref=v2",
+		"+ module.new ./modules/new",
+		"- module.old ./modules/old",
+		"~ module.vpc terraform-aws-modules/vpc/aws 5.1.1 -> 5.2.0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffModules() = %q, want %q", got, want)
+	}
+
+	t.Setenv("TF_DATA_DIR", "data")
+	if got := DataDir("infra"); got != filepath.Join("infra", "data") {
+		t.Errorf("DataDir() = %q", got)
+	}
+}
@@ -0,0 +1,77 @@
+package tfexec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"regexp"
+)
+
+// The first versions with terraform providers mirror and providers lock.
+var (
+	ProvidersMirrorSince = Version{Major: 0, Minor: 13, Patch: 0}
+	ProvidersLockSince   = Version{Major: 0, Minor: 14, Patch: 0}
+)
+
+// ProvidersMirrorArgs builds the argument list for terraform providers
+// mirror into dir for the given platforms.
+func ProvidersMirrorArgs(chdir, dir string, platforms []string) []string {
+	args := append(globalArgs(chdir), "providers", "mirror")
+	for _, p := range platforms {
+		args = append(args, "-platform="+p)
+	}
+	return append(args, dir)
+}
+
+// ProvidersLockArgs builds the argument list for terraform providers lock
+// for the given platforms.
+func ProvidersLockArgs(chdir string, platforms []string) []string {
+	args := append(globalArgs(chdir), "providers", "lock")
+	for _, p := range platforms {
+		args = append(args, "-platform="+p)
+	}
+	return args
+}
+
+// ProvidersMirror runs terraform providers mirror, streaming its output, and
+// returns how many providers it mirrored.
+func ProvidersMirror(ctx context.Context, r TerraformRunner, chdir, dir string, platforms []string, run RunOptions) (int, error) {
+	var err error
+	if dir, err = absPath("mirror", dir); err != nil {
+		return 0, err
+	}
+	return countProviders(ctx, r, "providers mirror", ProvidersMirrorArgs(chdir, dir, platforms), run)
+}
+
+// ProvidersLock runs terraform providers lock, streaming its output, and
+// returns how many providers it locked.
+func ProvidersLock(ctx context.Context, r TerraformRunner, chdir string, platforms []string, run RunOptions) (int, error) {
+	return countProviders(ctx, r, "providers lock", ProvidersLockArgs(chdir, platforms), run)
+}
+
+// providerLine matches the line terraform prints as it starts on each
+// provider, "- Mirroring hashicorp/aws..." or "- Fetching hashicorp/aws 5.31.0 for linux_amd64..."
+var providerLine = regexp.MustCompile(`^- (?:Mirroring|Fetching) (\S+?)(?:\.\.\.| )`)
+
+func countProviders(ctx context.Context, r TerraformRunner, command string, args []string, run RunOptions) (int, error) {
+	var out bytes.Buffer
+	stdout := run.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	run.Stdout = io.MultiWriter(stdout, &out)
+	if err := execute(ctx, r, command, args, run); err != nil {
+		return 0, err
+	}
+
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		if m := providerLine.FindStringSubmatch(scanner.Text()); m != nil {
+			seen[m[1]] = true
+		}
+	}
+	return len(seen), nil
+}
@@ -0,0 +1,52 @@
+package tfexec
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const mirrorOutput = `- Mirroring hashicorp/aws...
+  - Selected v5.31.0 to match dependency lock file
+  - Downloading package for linux_amd64...
+  - Downloading package for darwin_arm64...
+- Mirroring registry.example.com/acme/internal...
+  - Selected v1.2.0 to match dependency lock file
+  - Downloading package for linux_amd64...
+`
+
+const lockOutput = `- Fetching hashicorp/aws 5.31.0 for linux_amd64...
+- Retrieved hashicorp/aws 5.31.0 for linux_amd64 (signed by HashiCorp)
+- Fetching hashicorp/aws 5.31.0 for darwin_arm64...
+- Fetching hashicorp/random 3.6.0 for linux_amd64...
+- Obtained hashicorp/aws checksums for linux_amd64; All checksums for this platform were already tracked in the lock file
+`
+
+func TestProvidersArgs(t *testing.T) {
+	platforms := []string{"linux_amd64", "darwin_arm64"}
+	if got := ProvidersMirrorArgs("infra", "/m", platforms); !reflect.DeepEqual(got, []string{"-chdir=infra", "providers", "mirror", "-platform=linux_amd64", "-platform=darwin_arm64", "/m"}) {
+		t.Errorf("ProvidersMirrorArgs() = %q", got)
+	}
+	if got := ProvidersLockArgs("", platforms[:1]); !reflect.DeepEqual(got, []string{"providers", "lock", "-platform=linux_amd64"}) {
+		t.Errorf("ProvidersLockArgs() = %q", got)
+	}
+}
+
+func TestProvidersCounts(t *testing.T) {
+	r := &RecordingRunner{Output: mirrorOutput}
+	n, err := ProvidersMirror(context.Background(), r, "", "mirror", nil, RunOptions{Stdout: io.Discard})
+	if err != nil || n != 2 {
+		t.Errorf("ProvidersMirror() = %d, %v, want 2", n, err)
+	}
+	if dir, _ := filepath.Abs("mirror"); r.Calls[0].Args[2] != dir {
+		t.Errorf("mirror dir = %q, want it absolute", r.Calls[0].Args[2])
+	}
+
+	r = &RecordingRunner{Output: lockOutput}
+	n, err = ProvidersLock(context.Background(), r, "", nil, RunOptions{Stdout: io.Discard})
+	if err != nil || n != 2 {
+		t.Errorf("ProvidersLock() = %d, %v, want 2", n, err)
+	}
+}
@@ -0,0 +1,194 @@
+package tfexec
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tracing"
+)
+
+// RunOptions control how a terraform command is run.
+type RunOptions struct {
+	// Dir is the working directory of the process, empty means the current one.
+	Dir string
+	// Env is added to the environment of the process.
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Redactor, when set, masks secrets in the output streamed to Stdout and
+	// Stderr. Output a command captures is left as it is.
+	Redactor *redact.Redactor
+}
+
+// TerraformRunner runs terraform with the given arguments. The arguments do
+// not include the terraform binary itself.
+type TerraformRunner interface {
+	Run(ctx context.Context, args []string, opts RunOptions) error
+}
+
+// ErrInterrupted is the cause to cancel the context with for a Ctrl-C at the
+// terminal. The terminal has already interrupted a terraform ExecRunner
+// started along with tfmanage, so ExecRunner doesn't interrupt it again - a
+// second interrupt makes terraform exit at once and leave its state lock
+// behind.
+var ErrInterrupted = errors.New("interrupted")
+
+// interruptedAtTerminal is whether terraform already got the interrupt that cancelled ctx
+func interruptedAtTerminal(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrInterrupted)
+}
+
+// ExecRunner runs the terraform binary on this machine, streaming its output.
+// When the context is cancelled terraform is interrupted first so it can
+// release its state lock, unless the cause is ErrInterrupted, and killed if
+// it has not exited after KillDelay.
+// When the context is traced each run is a span, and terraform gets its
+// TRACEPARENT so its own spans land under it.
+type ExecRunner struct {
+	// Binary is the terraform executable, "terraform" from the PATH when empty.
+	Binary string
+	// KillDelay is how long terraform gets after the interrupt, DefaultKillDelay when zero.
+	KillDelay time.Duration
+}
+
+// DefaultKillDelay is how long an interrupted terraform gets to exit by itself.
+const DefaultKillDelay = 10 * time.Second
+
+func (r ExecRunner) Run(ctx context.Context, args []string, opts RunOptions) (err error) {
+	binary := r.Binary
+	if binary == "" {
+		binary = "terraform"
+	}
+	ctx, span := tracing.Start(ctx, "terraform "+subcommand(args), tracing.String("terraform.command", subcommand(args)))
+	defer func() {
+		if code, ok := ExitCode(err); ok {
+			span.SetAttributes(tracing.Int("process.exit_code", int64(code)))
+		} else if err == nil {
+			span.SetAttributes(tracing.Int("process.exit_code", 0))
+		}
+		span.End(err)
+	}()
+
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Cancel = func() error {
+		if interruptedAtTerminal(ctx) {
+			return nil
+		}
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = r.KillDelay
+	if cmd.WaitDelay == 0 {
+		cmd.WaitDelay = DefaultKillDelay
+	}
+	cmd.Dir = opts.Dir
+	env := opts.Env
+	if traceparent := span.Traceparent(); traceparent != "" {
+		env = append(env[:len(env):len(env)], tracing.TraceparentEnv+"="+traceparent)
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = opts.Stdout
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	cmd.Stderr = opts.Stderr
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	return cmd.Run()
+}
+
+// subcommand is the first argument that isn't a flag, such as plan in -chdir=x plan
+func subcommand(args []string) string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+	return ""
+}
+
+// ExitCode gives back the exit code carried by err, if it has one.
+func ExitCode(err error) (int, bool) {
+	var coder interface{ ExitCode() int }
+	if errors.As(err, &coder) {
+		return coder.ExitCode(), true
+	}
+	return 0, false
+}
+
+// RecordingRunner is a TerraformRunner for tests. It records every call and
+// never runs terraform.
+type RecordingRunner struct {
+	mu    sync.Mutex
+	Calls []RecordedCall
+
+	// Result, when set, decides what each call returns.
+	Result func(args []string) error
+	// Output is written to the call's stdout, if there is one.
+	Output string
+	// OutputFor, when set, decides the output per call instead of Output.
+	OutputFor func(args []string) string
+	// Stderr is written to the call's stderr, if there is one.
+	Stderr string
+}
+
+// RecordedCall is one call made to a RecordingRunner.
+type RecordedCall struct {
+	Args []string
+	Opts RunOptions
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, args []string, opts RunOptions) error {
+	r.mu.Lock()
+	r.Calls = append(r.Calls, RecordedCall{Args: append([]string(nil), args...), Opts: opts})
+	r.mu.Unlock()
+
+	output := r.Output
+	if r.OutputFor != nil {
+		output = r.OutputFor(args)
+	}
+	if output != "" && opts.Stdout != nil {
+		io.WriteString(opts.Stdout, output)
+	}
+	if r.Stderr != "" && opts.Stderr != nil {
+		io.WriteString(opts.Stderr, r.Stderr)
+	}
+	if r.Result != nil {
+		return r.Result(args)
+	}
+	return nil
+}
+
+// Args returns the arguments of every recorded call.
+func (r *RecordingRunner) Args() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([][]string, len(r.Calls))
+	for i, c := range r.Calls {
+		out[i] = c.Args
+	}
+	return out
+}
+
+// FakeExitError is an error with an exit code, as returned by a failed process.
+type FakeExitError struct {
+	Code int
+}
+
+func (e *FakeExitError) Error() string { return "exit status " + strconv.Itoa(e.Code) }
+func (e *FakeExitError) ExitCode() int { return e.Code }
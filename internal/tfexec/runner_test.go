@@ -0,0 +1,91 @@
+package tfexec
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tracing"
+)
+
+func TestExecRunnerStopsOnCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses the sleep binary")
+	}
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep is not installed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err = ExecRunner{Binary: sleep, KillDelay: time.Second}.Run(ctx, []string{"30"}, RunOptions{})
+	if err == nil {
+		t.Fatal("Run() succeeded after cancel")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() took %v to return after cancel", elapsed)
+	}
+}
+
+func TestExecRunnerInterruptsOnce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	for _, tt := range []struct {
+		name            string
+		cause           error
+		wantInterrupted bool
+	}{
+		{"cancelled", nil, true},
+		// the terminal's Ctrl-C already reached terraform
+		{"interrupted at the terminal", ErrInterrupted, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			time.AfterFunc(100*time.Millisecond, func() { cancel(tt.cause) })
+
+			var out strings.Builder
+			ExecRunner{Binary: "sh", KillDelay: 5 * time.Second}.Run(ctx, []string{"-c", "trap 'echo interrupted' INT; sleep 0.5; echo done"}, RunOptions{Stdout: &out})
+			if got := strings.Contains(out.String(), "interrupted"); got != tt.wantInterrupted {
+				t.Errorf("terraform interrupted = %v, want %v:\n%s", got, tt.wantInterrupted, out.String())
+			}
+			if !strings.Contains(out.String(), "done") {
+				t.Errorf("terraform didn't get to exit by itself:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestExecRunnerExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	err := ExecRunner{Binary: "sh"}.Run(context.Background(), []string{"-c", "exit 3"}, RunOptions{})
+	if code, ok := ExitCode(err); !ok || code != 3 {
+		t.Errorf("ExitCode() = %d, %v, want 3", code, ok)
+	}
+}
+
+func TestExecRunnerTraceparent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv(tracing.TracesEndpointEnv, "http://127.0.0.1:1/v1/traces")
+	t.Setenv(tracing.TraceparentEnv, "")
+	ctx := tracing.WithTracer(context.Background(), tracing.FromEnv(""))
+
+	var out strings.Builder
+	err := ExecRunner{Binary: "sh"}.Run(ctx, []string{"-c", "echo $TRACEPARENT"}, RunOptions{Stdout: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(out.String()); !strings.HasPrefix(got, "00-") || len(got) != 55 {
+		t.Errorf("terraform got TRACEPARENT %q, want the span's", got)
+	}
+}
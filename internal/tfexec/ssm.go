@@ -0,0 +1,553 @@
+package tfexec
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// DefaultSSMRoot is where SSMRunner keeps the working directories on the
+// instance when it isn't given a root.
+const DefaultSSMRoot = "/var/lib/tfmanage"
+
+// DefaultSSMTimeout is how long terraform may run on the instance when
+// SSMRunner isn't given a timeout.
+const DefaultSSMTimeout = time.Hour
+
+// DefaultSSMPollInterval is how often SSMRunner asks for the command's status
+// and output.
+const DefaultSSMPollInterval = 2 * time.Second
+
+// SSMOutputLimit is how much of a command's stdout, and of its stderr,
+// GetCommandInvocation gives back. The rest is only in the S3 output.
+const SSMOutputLimit = 24000
+
+// ErrSSMCommandFailed is returned when terraform didn't run to the end on the
+// instance: the command wasn't delivered, timed out, was cancelled outside
+// tfmanage, or the instance couldn't get the files.
+var ErrSSMCommandFailed = errors.New("the SSM command failed")
+
+// SSMCommandAPI is the part of the SSM client SSMRunner uses.
+type SSMCommandAPI interface {
+	SendCommand(ctx context.Context, in *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error)
+	GetCommandInvocation(ctx context.Context, in *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error)
+	CancelCommand(ctx context.Context, in *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error)
+}
+
+// SSMStaging is the bucket SSMRunner and the instance hand files to each
+// other through. Get fails with fs.ErrNotExist for a key that isn't there.
+type SSMStaging interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// RemoteExitError is terraform's exit code on the instance.
+type RemoteExitError struct {
+	InstanceID string
+	Code       int
+}
+
+func (e *RemoteExitError) Error() string {
+	return "exit status " + strconv.Itoa(e.Code) + " on " + e.InstanceID
+}
+
+func (e *RemoteExitError) ExitCode() int { return e.Code }
+
+// ssmSetupFailed is the exit code of the command when the instance couldn't
+// get the files, so it isn't mistaken for terraform's
+const ssmSetupFailed = 125
+
+// SSMRunner runs terraform on an EC2 instance with SSM Run Command, so it runs
+// with the instance's role rather than the caller's credentials. Each run
+// uploads the configuration, the files the arguments name and the variables
+// of RunOptions.Env to Staging, and sends an AWS-RunShellScript command that
+// downloads them with the AWS CLI and runs terraform. The working directory is
+// kept on the instance under Root at its local path, so .terraform stays
+// there between runs, and files terraform writes with -out come back.
+//
+// The AWS_ variables of RunOptions.Env are left out, terraform uses the
+// instance's credentials. Output is written as the invocation reports it and
+// completed from the command's S3 output, which isn't cut off. The exit code
+// is terraform's. When the context is cancelled terraform is interrupted on
+// the instance and the command cancelled after KillDelay.
+type SSMRunner struct {
+	Client     SSMCommandAPI
+	InstanceID string
+	// Bucket and Prefix are where Staging keeps its keys, the instance reads
+	// and writes s3://Bucket/Prefix... with the AWS CLI.
+	Bucket, Prefix string
+	Staging        SSMStaging
+	// Root is the directory on the instance the working directories are kept
+	// in, DefaultSSMRoot when empty.
+	Root string
+	// Timeout is how long terraform may run, DefaultSSMTimeout when zero.
+	Timeout time.Duration
+	// PollInterval is DefaultSSMPollInterval when zero.
+	PollInterval time.Duration
+	// KillDelay is how long an interrupted terraform gets before the command
+	// is cancelled, DefaultKillDelay when zero.
+	KillDelay time.Duration
+}
+
+// ssmFile is a local file and where it is kept on the instance and in staging
+type ssmFile struct {
+	local, remote, key string
+}
+
+// ssmRun is what one terraform run puts where
+type ssmRun struct {
+	// stage is the key prefix of the run's staged files and output
+	stage string
+	// workdir is the local directory terraform runs in, configDir the one
+	// uploaded as the configuration
+	workdir, configDir string
+	pidFile            string
+	// args are terraform's with the absolute paths moved under the root
+	args    []string
+	inputs  []ssmFile
+	outputs []ssmFile
+	// keys are everything staged, removed once the run is over
+	keys []string
+}
+
+func (r SSMRunner) Run(ctx context.Context, args []string, opts RunOptions) error {
+	if opts.Stdin != nil {
+		return fmt.Errorf("%w: terraform %s reads from stdin, which a command sent through SSM doesn't have", ErrSSMCommandFailed, subcommand(args))
+	}
+	name, err := randomName("tfmanage-")
+	if err != nil {
+		return err
+	}
+	run, err := r.plan(name, args, opts)
+	if err != nil {
+		return err
+	}
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	// the environment holds the secret variables, it is removed whatever happens to the command
+	defer r.cleanup(context.WithoutCancel(ctx), run, stderr)
+	if err := r.stage(ctx, run, opts); err != nil {
+		return err
+	}
+
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = DefaultSSMTimeout
+	}
+	sent, err := r.Client.SendCommand(ctx, &ssm.SendCommandInput{
+		DocumentName: aws.String("AWS-RunShellScript"),
+		InstanceIds:  []string{r.InstanceID},
+		Comment:      aws.String("tfmanage: terraform " + subcommand(args)),
+		Parameters: map[string][]string{
+			"commands":         r.script(run),
+			"executionTimeout": {strconv.Itoa(int(timeout.Seconds()))},
+		},
+		OutputS3BucketName: aws.String(r.Bucket),
+		OutputS3KeyPrefix:  aws.String(run.stage + "output"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send terraform %s to %s: %w", subcommand(args), r.InstanceID, err)
+	}
+	commandID := aws.ToString(sent.Command.CommandId)
+	run.keys = append(run.keys, r.outputKey(run, commandID, "stdout"), r.outputKey(run, commandID, "stderr"))
+	inv, err := r.wait(ctx, run, commandID, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	r.fetchOutputs(context.WithoutCancel(ctx), run, stderr)
+	return r.result(ctx, commandID, inv)
+}
+
+// plan works out what goes where: the files to upload, the ones to bring
+// back and the args with absolute paths moved under the root
+func (r SSMRunner) plan(name string, args []string, opts RunOptions) (*ssmRun, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	workdir := cwd
+	if opts.Dir != "" {
+		if workdir, err = filepath.Abs(opts.Dir); err != nil {
+			return nil, err
+		}
+	}
+	// relative paths are terraform's, so they start from -chdir
+	base := workdir
+	for _, arg := range args {
+		if chdir, ok := strings.CutPrefix(arg, "-chdir="); ok {
+			base = resolve(workdir, chdir)
+		}
+	}
+	run := &ssmRun{
+		stage:     r.Prefix + name + "/",
+		workdir:   workdir,
+		configDir: workdir,
+		pidFile:   path.Join(r.root(), name+".pid"),
+	}
+	// the current directory has the modules the configuration refers to with ../, a directory outside it like an unpacked bundle goes by itself
+	if !within(workdir, base) {
+		run.configDir = base
+	}
+	file := func(kind, local string) ssmFile {
+		local = resolve(base, local)
+		key := run.stage + kind + "/" + strconv.Itoa(len(run.inputs)+len(run.outputs))
+		return ssmFile{local: local, remote: r.remotePath(local), key: key}
+	}
+	for i, arg := range args {
+		flagName, value, isFlag := strings.Cut(arg, "=")
+		switch {
+		case isFlag && flagName == "-var-file":
+			run.inputs = append(run.inputs, file("in", value))
+		case isFlag && flagName == "-out":
+			run.outputs = append(run.outputs, file("out", value))
+		case strings.HasPrefix(arg, "-"):
+		case i > 0 && args[i-1] == "-out":
+			run.outputs = append(run.outputs, file("out", arg))
+		case i > 0 && args[i-1] == "-var-file", isRegularFile(resolve(base, arg)):
+			// a saved plan to apply or show is a file argument too
+			run.inputs = append(run.inputs, file("in", arg))
+		}
+		switch {
+		case isFlag && strings.HasPrefix(flagName, "-") && filepath.IsAbs(value):
+			arg = flagName + "=" + r.remotePath(value)
+		case filepath.IsAbs(arg):
+			arg = r.remotePath(arg)
+		}
+		run.args = append(run.args, arg)
+	}
+	return run, nil
+}
+
+func (r SSMRunner) root() string {
+	if r.Root == "" {
+		return DefaultSSMRoot
+	}
+	return r.Root
+}
+
+// remotePath is where a local absolute path is kept on the instance
+func (r SSMRunner) remotePath(local string) string {
+	return path.Join(r.root(), filepath.ToSlash(strings.TrimPrefix(local, filepath.VolumeName(local))))
+}
+
+// outputKey is where SSM puts a stream of the command's output, under OutputS3KeyPrefix
+func (r SSMRunner) outputKey(run *ssmRun, commandID, stream string) string {
+	return run.stage + "output/" + commandID + "/" + r.InstanceID + "/awsrunShellScript/0.awsrunShellScript/" + stream
+}
+
+func resolve(base, p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(base, p)
+}
+
+func isRegularFile(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// stage uploads the configuration, the input files and the environment
+func (r SSMRunner) stage(ctx context.Context, run *ssmRun, opts RunOptions) error {
+	archive, err := tarDir(run.configDir)
+	if err != nil {
+		return fmt.Errorf("failed to pack %s: %w", run.configDir, err)
+	}
+	uploads := map[string][]byte{
+		run.stage + "config.tar.gz": archive,
+		run.stage + "env":           ssmEnvFile(opts.Env),
+	}
+	for _, f := range run.inputs {
+		if uploads[f.key], err = os.ReadFile(f.local); err != nil {
+			return err
+		}
+	}
+	for key, data := range uploads {
+		run.keys = append(run.keys, key)
+		if err := r.Staging.Put(ctx, key, data); err != nil {
+			return fmt.Errorf("failed to stage the files for %s: %w", r.InstanceID, err)
+		}
+	}
+	for _, f := range run.outputs {
+		run.keys = append(run.keys, f.key)
+	}
+	return nil
+}
+
+// script is the shell script the instance runs. Exit code ssmSetupFailed
+// means it couldn't get the files, anything else is terraform's
+func (r SSMRunner) script(run *ssmRun) []string {
+	q := shellQuote
+	s3 := func(key string) string { return q("s3://" + r.Bucket + "/" + key) }
+	lines := []string{
+		"set -u",
+		"fail() { echo \"tfmanage: $*\" >&2; exit " + strconv.Itoa(ssmSetupFailed) + "; }",
+		"tmp=$(mktemp -d) || fail \"can't create a temporary directory\"",
+		"trap 'rm -rf \"$tmp\"' EXIT",
+		"config=" + q(r.remotePath(run.configDir)),
+		"mkdir -p \"$config\" " + q(r.remotePath(run.workdir)) + " || fail \"can't create $config\"",
+		// what was deleted locally goes on the instance too, .terraform and the other dot files stay
+		"find \"$config\" -mindepth 1 -maxdepth 1 ! -name '.*' -exec rm -rf {} + || fail \"can't clear $config\"",
+		"aws s3 cp --only-show-errors " + s3(run.stage+"config.tar.gz") + " \"$tmp/config.tar.gz\" && tar -xzf \"$tmp/config.tar.gz\" -C \"$config\" || fail \"can't download the configuration\"",
+		"aws s3 cp --only-show-errors " + s3(run.stage+"env") + " \"$tmp/env\" || fail \"can't download the environment\"",
+	}
+	for _, f := range run.inputs {
+		lines = append(lines, "mkdir -p "+q(path.Dir(f.remote))+" && aws s3 cp --only-show-errors "+s3(f.key)+" "+q(f.remote)+" || fail \"can't download \""+q(f.local))
+	}
+	for _, f := range run.outputs {
+		lines = append(lines, "mkdir -p "+q(path.Dir(f.remote))+" || fail \"can't create \""+q(path.Dir(f.remote)))
+	}
+	terraform := make([]string, len(run.args))
+	for i, arg := range run.args {
+		terraform[i] = q(arg)
+	}
+	lines = append(lines,
+		"set -a && . \"$tmp/env\" && set +a || fail \"can't read the environment\"",
+		"cd "+q(r.remotePath(run.workdir))+" || fail \"can't change to the working directory\"",
+		// the pid is for the interrupt when tfmanage is cancelled
+		"sh -c 'echo $$ > \"$0\"; exec terraform \"$@\"' "+q(run.pidFile)+" "+strings.Join(terraform, " ")+" </dev/null",
+		"code=$?",
+		"rm -f "+q(run.pidFile),
+	)
+	for _, f := range run.outputs {
+		lines = append(lines, "if [ -f "+q(f.remote)+" ]; then aws s3 cp --only-show-errors "+q(f.remote)+" "+s3(f.key)+" || { echo \"tfmanage: can't upload \""+q(f.remote)+" >&2; code="+strconv.Itoa(ssmSetupFailed)+"; }; fi")
+	}
+	return append(lines, "exit $code")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ssmEnvFile is the variables terraform gets on the instance as a shell
+// script: the TF_VAR_ variables of this machine and env on top. The AWS_
+// credential variables are left out so the instance's role is used
+func ssmEnvFile(env []string) []byte {
+	var vars []string
+	for _, e := range os.Environ() {
+		if strings.HasPrefix(e, "TF_VAR_") {
+			vars = append(vars, e)
+		}
+	}
+	var b bytes.Buffer
+	for _, e := range append(vars, env...) {
+		name, value, _ := strings.Cut(e, "=")
+		if !envName.MatchString(name) || (strings.HasPrefix(name, "AWS_") && name != "AWS_REGION" && name != "AWS_DEFAULT_REGION") {
+			continue
+		}
+		b.WriteString(name + "=" + shellQuote(value) + "\n")
+	}
+	return b.Bytes()
+}
+
+// tarDir packs the regular files under dir, leaving out the directories
+// whose name starts with a dot such as .terraform and .git
+func tarDir(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: filepath.ToSlash(rel), Mode: int64(info.Mode().Perm()), Size: int64(len(data)), ModTime: info.ModTime(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// wait polls the invocation until it is over, writing the output as it
+// comes. A cancelled ctx interrupts terraform, and cancels the command when
+// it hasn't stopped after the kill delay
+func (r SSMRunner) wait(ctx context.Context, run *ssmRun, commandID string, stdout, stderr io.Writer) (*ssm.GetCommandInvocationOutput, error) {
+	bg := context.WithoutCancel(ctx)
+	interval := r.PollInterval
+	if interval == 0 {
+		interval = DefaultSSMPollInterval
+	}
+	killDelay := r.KillDelay
+	if killDelay == 0 {
+		killDelay = DefaultKillDelay
+	}
+	var stdoutSeen, stderrSeen int
+	var interrupted time.Time
+	cancelled := false
+	for {
+		inv, err := r.Client.GetCommandInvocation(bg, &ssm.GetCommandInvocationInput{CommandId: aws.String(commandID), InstanceId: aws.String(r.InstanceID)})
+		var notYet *types.InvocationDoesNotExist
+		switch {
+		case errors.As(err, &notYet):
+			// the invocation shows up a moment after SendCommand
+		case err != nil:
+			return nil, fmt.Errorf("failed to get the status of SSM command %s on %s: %w", commandID, r.InstanceID, err)
+		default:
+			stdoutSeen = writeNew(stdout, aws.ToString(inv.StandardOutputContent), stdoutSeen)
+			stderrSeen = writeNew(stderr, aws.ToString(inv.StandardErrorContent), stderrSeen)
+			if finished(inv.Status) {
+				r.completeOutput(bg, run, commandID, "stdout", stdout, stderr, stdoutSeen)
+				r.completeOutput(bg, run, commandID, "stderr", stderr, stderr, stderrSeen)
+				return inv, nil
+			}
+		}
+		if ctx.Err() != nil {
+			switch {
+			case interrupted.IsZero():
+				r.interrupt(bg, run)
+				interrupted = time.Now()
+			case !cancelled && time.Since(interrupted) >= killDelay:
+				r.Client.CancelCommand(bg, &ssm.CancelCommandInput{CommandId: aws.String(commandID), InstanceIds: []string{r.InstanceID}})
+				cancelled = true
+			}
+		}
+		done := ctx.Done()
+		if !interrupted.IsZero() {
+			done = nil
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-done:
+		}
+		timer.Stop()
+	}
+}
+
+func finished(status types.CommandInvocationStatus) bool {
+	switch status {
+	case types.CommandInvocationStatusPending, types.CommandInvocationStatusInProgress, types.CommandInvocationStatusDelayed, types.CommandInvocationStatusCancelling:
+		return false
+	}
+	return true
+}
+
+// writeNew writes what was added to content since seen bytes of it were written
+func writeNew(w io.Writer, content string, seen int) int {
+	if len(content) <= seen {
+		return seen
+	}
+	io.WriteString(w, content[seen:])
+	return len(content)
+}
+
+// completeOutput writes the rest of a stream from the command's S3 output,
+// which has all of it where the invocation stops at SSMOutputLimit
+func (r SSMRunner) completeOutput(ctx context.Context, run *ssmRun, commandID, stream string, w, stderr io.Writer, seen int) {
+	full, err := r.Staging.Get(ctx, r.outputKey(run, commandID, stream))
+	switch {
+	case err == nil && len(full) > seen:
+		w.Write(full[seen:])
+	case err != nil && seen >= SSMOutputLimit:
+		fmt.Fprintf(stderr, "tfmanage: the %s of terraform on %s was cut off at %d characters and the rest couldn't be read from S3: %v\n", stream, r.InstanceID, SSMOutputLimit, err)
+	}
+}
+
+// interrupt sends terraform an interrupt so it can release its state lock
+func (r SSMRunner) interrupt(ctx context.Context, run *ssmRun) {
+	pid := shellQuote(run.pidFile)
+	r.Client.SendCommand(ctx, &ssm.SendCommandInput{
+		DocumentName: aws.String("AWS-RunShellScript"),
+		InstanceIds:  []string{r.InstanceID},
+		Comment:      aws.String("tfmanage: interrupt terraform"),
+		Parameters:   map[string][]string{"commands": {"[ -f " + pid + " ] && kill -INT \"$(cat " + pid + ")\" || true"}},
+	})
+}
+
+// fetchOutputs brings back the files terraform wrote with -out
+func (r SSMRunner) fetchOutputs(ctx context.Context, run *ssmRun, stderr io.Writer) {
+	for _, f := range run.outputs {
+		data, err := r.Staging.Get(ctx, f.key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = os.WriteFile(f.local, data, 0o600)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "tfmanage: couldn't bring %s back from %s: %v\n", f.local, r.InstanceID, err)
+		}
+	}
+}
+
+// result turns how the invocation ended into terraform's exit code or an ErrSSMCommandFailed
+func (r SSMRunner) result(ctx context.Context, commandID string, inv *ssm.GetCommandInvocationOutput) error {
+	switch {
+	case inv.Status == types.CommandInvocationStatusSuccess:
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case inv.Status == types.CommandInvocationStatusFailed && inv.ResponseCode == ssmSetupFailed:
+		return fmt.Errorf("%w: %s couldn't get the files to run terraform with, see the output above", ErrSSMCommandFailed, r.InstanceID)
+	case inv.Status == types.CommandInvocationStatusFailed && inv.ResponseCode > 0:
+		return &RemoteExitError{InstanceID: r.InstanceID, Code: int(inv.ResponseCode)}
+	}
+	details := aws.ToString(inv.StatusDetails)
+	if details == "" {
+		details = string(inv.Status)
+	}
+	return fmt.Errorf("%w: command %s on %s: %s", ErrSSMCommandFailed, commandID, r.InstanceID, details)
+}
+
+// cleanup removes the staged files and the command's output
+func (r SSMRunner) cleanup(ctx context.Context, run *ssmRun, stderr io.Writer) {
+	for _, key := range run.keys {
+		if err := r.Staging.Delete(ctx, key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(stderr, "tfmanage: couldn't remove the staged files under s3://%s/%s, remove them by hand: %v\n", r.Bucket, run.stage, err)
+			return
+		}
+	}
+}
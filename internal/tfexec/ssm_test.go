@@ -0,0 +1,246 @@
+package tfexec
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+type memStaging struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memStaging) Put(ctx context.Context, key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.objects == nil {
+		m.objects = map[string][]byte{}
+	}
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memStaging) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return data, nil
+}
+
+func (m *memStaging) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// fakeSSM plays the instance: the first command gets the invocations of
+// polls in turn, the last one over and over
+type fakeSSM struct {
+	mu        sync.Mutex
+	sent      []*ssm.SendCommandInput
+	cancelled int
+	polls     []*ssm.GetCommandInvocationOutput
+	// onSend runs for the first command, before any poll
+	onSend func(in *ssm.SendCommandInput)
+}
+
+func (f *fakeSSM) SendCommand(ctx context.Context, in *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	f.mu.Lock()
+	f.sent = append(f.sent, in)
+	first := len(f.sent) == 1
+	f.mu.Unlock()
+	if first && f.onSend != nil {
+		f.onSend(in)
+	}
+	return &ssm.SendCommandOutput{Command: &types.Command{CommandId: aws.String("cmd-1")}}, nil
+}
+
+func (f *fakeSSM) GetCommandInvocation(ctx context.Context, in *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cancelled > 0 {
+		return &ssm.GetCommandInvocationOutput{Status: types.CommandInvocationStatusCancelled, ResponseCode: -1}, nil
+	}
+	inv := f.polls[0]
+	if len(f.polls) > 1 {
+		f.polls = f.polls[1:]
+	}
+	if inv == nil {
+		return nil, &types.InvocationDoesNotExist{}
+	}
+	return inv, nil
+}
+
+func (f *fakeSSM) CancelCommand(ctx context.Context, in *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancelled++
+	return &ssm.CancelCommandOutput{}, nil
+}
+
+func untar(t *testing.T, data []byte) []string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestSSMRunner(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	os.WriteFile("main.tf", []byte(`module "x" { source = "./modules/x" }`), 0o644)
+	os.MkdirAll(filepath.Join("modules", "x"), 0o755)
+	os.WriteFile(filepath.Join("modules", "x", "main.tf"), nil, 0o644)
+	os.MkdirAll(".terraform", 0o755)
+	os.WriteFile(filepath.Join(".terraform", "terraform.tfstate"), nil, 0o644)
+	varFile := filepath.Join(t.TempDir(), "prod.tfvars")
+	os.WriteFile(varFile, []byte(`region = "eu-west-1"`), 0o644)
+	planFile := filepath.Join(t.TempDir(), "prod.tfplan")
+	t.Setenv("TF_VAR_from_shell", "yes")
+
+	staging := &memStaging{}
+	r := SSMRunner{InstanceID: "i-0abc", Bucket: "tfvars-bucket", Prefix: "team/ssm/", Staging: staging, PollInterval: time.Millisecond}
+	var env, config []byte
+	client := &fakeSSM{
+		polls: []*ssm.GetCommandInvocationOutput{
+			nil,
+			{Status: types.CommandInvocationStatusInProgress, StandardOutputContent: aws.String("Refreshing state...\n")},
+			{Status: types.CommandInvocationStatusFailed, ResponseCode: 2, StandardOutputContent: aws.String("Refreshing state...\nPlan: 1 to add")},
+		},
+	}
+	client.onSend = func(in *ssm.SendCommandInput) {
+		stage := strings.TrimSuffix(aws.ToString(in.OutputS3KeyPrefix), "output")
+		env, _ = staging.Get(context.Background(), stage+"env")
+		config, _ = staging.Get(context.Background(), stage+"config.tar.gz")
+		staging.Put(context.Background(), stage+"out/1", []byte("the plan"))
+		staging.Put(context.Background(), stage+"output/cmd-1/i-0abc/awsrunShellScript/0.awsrunShellScript/stdout", []byte("Refreshing state...\nPlan: 1 to add, 0 to change, 0 to destroy.\n"))
+	}
+	r.Client = client
+
+	var stdout bytes.Buffer
+	args := []string{"plan", "-var-file=" + varFile, "-out=" + planFile, "-detailed-exitcode"}
+	runEnv := []string{"TF_WORKSPACE=production", "TF_VAR_db_password=it's secret", "AWS_ACCESS_KEY_ID=AKIA", "AWS_REGION=eu-west-1"}
+	err := r.Run(context.Background(), args, RunOptions{Env: runEnv, Stdout: &stdout, Stderr: io.Discard})
+	if code, ok := ExitCode(err); !ok || code != 2 {
+		t.Fatalf("Run() = %v, want terraform's exit code 2", err)
+	}
+	if got := stdout.String(); got != "Refreshing state...\nPlan: 1 to add, 0 to change, 0 to destroy.\n" {
+		t.Errorf("stdout %q, want all of the S3 output once", got)
+	}
+	if data, _ := os.ReadFile(planFile); string(data) != "the plan" {
+		t.Errorf("the plan file has %q, want what terraform wrote on the instance", data)
+	}
+	if len(staging.objects) != 0 {
+		t.Errorf("staged files left behind: %v", staging.objects)
+	}
+
+	in := client.sent[0]
+	if aws.ToString(in.DocumentName) != "AWS-RunShellScript" || !slices.Equal(in.InstanceIds, []string{"i-0abc"}) || in.Parameters["executionTimeout"][0] != "3600" || aws.ToString(in.OutputS3BucketName) != "tfvars-bucket" {
+		t.Errorf("SendCommand %+v", in)
+	}
+	script := strings.Join(in.Parameters["commands"], "\n")
+	for _, want := range []string{
+		"config='" + DefaultSSMRoot + dir + "'",
+		"'s3://tfvars-bucket/team/ssm/tfmanage-",
+		"'" + DefaultSSMRoot + varFile + "'",
+		"'plan' '-var-file=" + DefaultSSMRoot + varFile + "' '-out=" + DefaultSSMRoot + planFile + "' '-detailed-exitcode' </dev/null",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script is missing %q:\n%s", want, script)
+		}
+	}
+	if got := string(env); got != "TF_VAR_from_shell='yes'\nTF_WORKSPACE='production'\nTF_VAR_db_password='it'\\''s secret'\nAWS_REGION='eu-west-1'\n" {
+		t.Errorf("env file %q", got)
+	}
+	if names := untar(t, config); !slices.Equal(names, []string{"main.tf", "modules/x/main.tf"}) {
+		t.Errorf("config archive has %q", names)
+	}
+}
+
+func TestSSMRunnerCancel(t *testing.T) {
+	t.Chdir(t.TempDir())
+	client := &fakeSSM{polls: []*ssm.GetCommandInvocationOutput{{Status: types.CommandInvocationStatusInProgress}}}
+	r := SSMRunner{Client: client, InstanceID: "i-0abc", Bucket: "b", Staging: &memStaging{}, PollInterval: time.Millisecond, KillDelay: 5 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	client.onSend = func(*ssm.SendCommandInput) { cancel() }
+
+	err := r.Run(ctx, []string{"apply", "-auto-approve"}, RunOptions{Stdout: io.Discard, Stderr: io.Discard})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() = %v, want context.Canceled", err)
+	}
+	if len(client.sent) != 2 || !strings.Contains(client.sent[1].Parameters["commands"][0], "kill -INT") {
+		t.Fatalf("want terraform interrupted, sent %d commands", len(client.sent))
+	}
+	if client.cancelled != 1 {
+		t.Errorf("CancelCommand called %d times, want once after the kill delay", client.cancelled)
+	}
+}
+
+func TestSSMRunnerFailures(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for name, tc := range map[string]struct {
+		inv  *ssm.GetCommandInvocationOutput
+		want string
+	}{
+		"setup": {
+			&ssm.GetCommandInvocationOutput{Status: types.CommandInvocationStatusFailed, ResponseCode: ssmSetupFailed},
+			"couldn't get the files",
+		},
+		"timed out": {
+			&ssm.GetCommandInvocationOutput{Status: types.CommandInvocationStatusTimedOut, ResponseCode: -1, StatusDetails: aws.String("ExecutionTimedOut")},
+			"ExecutionTimedOut",
+		},
+		"undeliverable": {
+			&ssm.GetCommandInvocationOutput{Status: types.CommandInvocationStatusFailed, ResponseCode: -1, StatusDetails: aws.String("Undeliverable")},
+			"Undeliverable",
+		},
+	} {
+		client := &fakeSSM{polls: []*ssm.GetCommandInvocationOutput{tc.inv}}
+		r := SSMRunner{Client: client, InstanceID: "i-0abc", Bucket: "b", Staging: &memStaging{}, PollInterval: time.Millisecond}
+		err := r.Run(context.Background(), []string{"init"}, RunOptions{Stdout: io.Discard, Stderr: io.Discard})
+		if !errors.Is(err, ErrSSMCommandFailed) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Run() = %v", name, err)
+		}
+		if _, ok := ExitCode(err); ok {
+			t.Errorf("%s: %v has an exit code, it isn't terraform's", name, err)
+		}
+	}
+
+	r := SSMRunner{Client: &fakeSSM{}, InstanceID: "i-0abc", Staging: &memStaging{}}
+	if err := r.Run(context.Background(), []string{"console"}, RunOptions{Stdin: os.Stdin}); !errors.Is(err, ErrSSMCommandFailed) {
+		t.Errorf("console through SSM: %v", err)
+	}
+}
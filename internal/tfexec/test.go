@@ -0,0 +1,122 @@
+package tfexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// TestOptions are the inputs to terraform test.
+type TestOptions struct {
+	Chdir   string
+	VarFile string
+	// Filters limit the run to these test files, all of them when empty.
+	Filters []string
+	// JSON asks terraform for its machine readable output, which is what the
+	// TestSummary is read from.
+	JSON    bool
+	NoColor bool
+}
+
+// TestSummary is what terraform test -json reported at the end.
+type TestSummary struct {
+	Status  string `json:"status"`
+	Passed  int    `json:"passed"`
+	Failed  int    `json:"failed"`
+	Errored int    `json:"errored"`
+	Skipped int    `json:"skipped"`
+	// Failures are the runs that failed or errored, as file/run.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// TestArgs builds the argument list for terraform test.
+func TestArgs(o TestOptions) []string {
+	args := append(globalArgs(o.Chdir), "test")
+	if o.VarFile != "" {
+		args = append(args, "-var-file", o.VarFile)
+	}
+	for _, f := range o.Filters {
+		args = append(args, "-filter="+f)
+	}
+	if o.JSON {
+		args = append(args, "-json")
+	}
+	if o.NoColor {
+		args = append(args, "-no-color")
+	}
+	return args
+}
+
+// Test runs terraform test, streaming its output. With o.JSON the output is
+// also read as it goes by and the summary is returned, even when tests failed.
+func Test(ctx context.Context, r TerraformRunner, o TestOptions, run RunOptions) (TestSummary, error) {
+	var err error
+	if o.VarFile, err = absPath("tfvars", o.VarFile); err != nil {
+		return TestSummary{}, err
+	}
+	if !o.JSON {
+		return TestSummary{}, execute(ctx, r, "test", TestArgs(o), run)
+	}
+	stdout := run.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	results := &testResults{}
+	run.Stdout = io.MultiWriter(stdout, results)
+	err = execute(ctx, r, "test", TestArgs(o), run)
+	results.Write([]byte("\n"))
+	return results.summary, err
+}
+
+// testMessage is the part of a terraform test -json line the summary needs, see
+// https://developer.hashicorp.com/terraform/internals/machine-readable-ui
+type testMessage struct {
+	Type    string       `json:"type"`
+	Summary *TestSummary `json:"test_summary"`
+	Run     *struct {
+		Path     string `json:"path"`
+		Run      string `json:"run"`
+		Progress string `json:"progress"`
+		Status   string `json:"status"`
+	} `json:"test_run"`
+}
+
+// testResults reads terraform test's JSON lines as they are written
+type testResults struct {
+	partial  []byte
+	summary  TestSummary
+	failures []string
+}
+
+func (t *testResults) Write(p []byte) (int, error) {
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.read(t.partial[:i])
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (t *testResults) read(line []byte) {
+	var m testMessage
+	if json.Unmarshal(line, &m) != nil {
+		return
+	}
+	switch {
+	case m.Type == "test_run" && m.Run != nil:
+		// terraform before 1.7 has no progress and only reports a run once it is done
+		done := m.Run.Progress == "" || m.Run.Progress == "complete"
+		if done && (m.Run.Status == "fail" || m.Run.Status == "error") {
+			t.failures = append(t.failures, m.Run.Path+"/"+m.Run.Run)
+		}
+	case m.Type == "test_summary" && m.Summary != nil:
+		t.summary = *m.Summary
+	}
+	t.summary.Failures = t.failures
+}
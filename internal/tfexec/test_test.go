@@ -0,0 +1,53 @@
+package tfexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testOutput = `{"@level":"info","@message":"Found 1 file and 2 run blocks","type":"test_abstract"}
+{"@level":"info","@message":"  \"ok\"... pass","test_run":{"path":"tests/main.tftest.hcl","run":"ok","progress":"complete","status":"pass"},"type":"test_run"}
+{"@level":"info","@message":"  \"bad\"... in progress","test_run":{"path":"tests/main.tftest.hcl","run":"bad","progress":"starting"},"type":"test_run"}
+{"@level":"info","@message":"  \"bad\"... fail","test_run":{"path":"tests/main.tftest.hcl","run":"bad","progress":"complete","status":"fail"},"type":"test_run"}
+{"@level":"info","@message":"Failure! 1 passed, 1 failed.","test_summary":{"status":"fail","passed":1,"failed":1,"errored":0,"skipped":0},"type":"test_summary"}
+`
+
+func TestTest(t *testing.T) {
+	dir := t.TempDir()
+	varFile := filepath.Join(dir, "dev.tfvars")
+	os.WriteFile(varFile, nil, 0o644)
+
+	var stdout bytes.Buffer
+	r := &RecordingRunner{Output: testOutput, Result: func([]string) error { return &FakeExitError{Code: 1} }}
+	summary, err := Test(context.Background(), r, TestOptions{Chdir: "infra", VarFile: varFile, Filters: []string{"tests/main.tftest.hcl"}, JSON: true}, RunOptions{Stdout: &stdout})
+	var failed *ErrTerraformFailed
+	if !errors.As(err, &failed) || failed.ExitCode != 1 {
+		t.Fatalf("Test() error = %v, want terraform's exit code", err)
+	}
+	want := TestSummary{Status: "fail", Passed: 1, Failed: 1, Failures: []string{"tests/main.tftest.hcl/bad"}}
+	if summary.Status != want.Status || summary.Passed != 1 || summary.Failed != 1 || !slices.Equal(summary.Failures, want.Failures) {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+	if stdout.String() != testOutput {
+		t.Errorf("the output wasn't streamed as it is:\n%s", stdout.String())
+	}
+	if want := []string{"-chdir=infra", "test", "-var-file", varFile, "-filter=tests/main.tftest.hcl", "-json"}; !slices.Equal(r.Calls[0].Args, want) {
+		t.Errorf("args = %q, want %q", r.Calls[0].Args, want)
+	}
+
+	// without -json nothing is read
+	r = &RecordingRunner{Output: "Success! 2 passed, 0 failed.\n"}
+	stdout.Reset()
+	summary, err = Test(context.Background(), r, TestOptions{VarFile: varFile, NoColor: true}, RunOptions{Stdout: &stdout})
+	if err != nil || summary.Status != "" || stdout.String() == "" {
+		t.Errorf("Test() = %+v, %v", summary, err)
+	}
+	if want := []string{"test", "-var-file", varFile, "-no-color"}; !slices.Equal(r.Calls[0].Args, want) {
+		t.Errorf("args = %q, want %q", r.Calls[0].Args, want)
+	}
+}
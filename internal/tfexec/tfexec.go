@@ -0,0 +1,525 @@
+// Package tfexec runs the terraform commands the tool wraps. Every command
+// builds its argument list and hands it to a TerraformRunner, so what gets
+// run can be tested without terraform installed.
+package tfexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrPlanHasChanges is returned by Plan when terraform reports changes. The
+// plan itself succeeded.
+var ErrPlanHasChanges = errors.New("plan contains changes")
+
+// ErrTerraformFailed is returned when a terraform command did not succeed.
+// ExitCode is -1 when terraform could not be started at all.
+type ErrTerraformFailed struct {
+	Command  string
+	ExitCode int
+	Err      error
+}
+
+func (e *ErrTerraformFailed) Error() string {
+	if e.ExitCode < 0 {
+		return fmt.Sprintf("terraform %s could not be run: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("terraform %s failed with exit code %d", e.Command, e.ExitCode)
+}
+
+func (e *ErrTerraformFailed) Unwrap() error { return e.Err }
+
+// ErrNotInitialized is returned together with ErrTerraformFailed when
+// terraform said the working directory has to be initialized first.
+var ErrNotInitialized = errors.New("the working directory is not initialized, run terraform init first")
+
+// failed wraps an error from the runner in ErrTerraformFailed
+func failed(command string, err error) error {
+	code, ok := ExitCode(err)
+	if !ok {
+		code = -1
+	}
+	return &ErrTerraformFailed{Command: command, ExitCode: code, Err: err}
+}
+
+// ErrPlanVersionMismatch is returned together with ErrTerraformFailed when
+// terraform refused a plan file made by a different terraform version.
+var ErrPlanVersionMismatch = errors.New("the plan was created by a different terraform version")
+
+// knownProblems are the failures terraform explains on stderr that callers
+// want to tell apart
+var knownProblems = []struct {
+	err     error
+	pattern *regexp.Regexp
+}{
+	{ErrNotInitialized, regexp.MustCompile(`(?i)(initialization required|not initialized|module not installed|run "?terraform init)`)},
+	{ErrPlanVersionMismatch, regexp.MustCompile(`(?i)(plan file was created by terraform|plan files cannot be transferred between different terraform versions)`)},
+}
+
+// stderrWatcher sees everything terraform prints to stderr and remembers the first known problem it mentioned
+type stderrWatcher struct {
+	tail []byte
+	seen error
+}
+
+func (w *stderrWatcher) Write(p []byte) (int, error) {
+	// keep a little of the previous write so a message split across writes still matches
+	w.tail = append(w.tail, p...)
+	for _, k := range knownProblems {
+		if w.seen == nil && k.pattern.Match(w.tail) {
+			w.seen = k.err
+		}
+	}
+	if len(w.tail) > 256 {
+		w.tail = w.tail[len(w.tail)-256:]
+	}
+	return len(p), nil
+}
+
+// execute runs a terraform command and turns a failure into ErrTerraformFailed,
+// adding ErrNotInitialized or ErrPlanVersionMismatch when terraform said so
+func execute(ctx context.Context, r TerraformRunner, command string, args []string, run RunOptions) error {
+	watcher := &stderrWatcher{}
+	stderr := run.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if run.Redactor != nil {
+		redacted := run.Redactor.NewWriter(stderr)
+		defer redacted.Flush()
+		stderr = redacted
+		if _, captured := run.Stdout.(*capturedOutput); !captured {
+			stdout := run.Stdout
+			if stdout == nil {
+				stdout = os.Stdout
+			}
+			redacted := run.Redactor.NewWriter(stdout)
+			defer redacted.Flush()
+			run.Stdout = redacted
+		}
+	}
+	run.Stderr = io.MultiWriter(stderr, watcher)
+
+	err := r.Run(ctx, args, run)
+	if err == nil {
+		return nil
+	}
+	if watcher.seen != nil {
+		return fmt.Errorf("%w: %w", watcher.seen, failed(command, err))
+	}
+	return failed(command, err)
+}
+
+// PlanOptions are the inputs to terraform plan.
+type PlanOptions struct {
+	// Chdir is passed as -chdir, before the subcommand.
+	Chdir   string
+	VarFile string
+	Out     string
+	Targets []string
+	// Replace forces these resource addresses to be replaced.
+	Replace     []string
+	Destroy     bool
+	RefreshOnly bool
+	// DetailedExitCode makes terraform exit 2 when there are changes.
+	DetailedExitCode bool
+	NoColor          bool
+	// NoLock passes -lock=false, otherwise LockTimeout is passed as
+	// -lock-timeout when set.
+	NoLock      bool
+	LockTimeout string
+}
+
+// ApplyOptions are the inputs to terraform apply. When PlanFile is set the
+// saved plan is applied and the planning options are left out.
+type ApplyOptions struct {
+	Chdir       string
+	VarFile     string
+	PlanFile    string
+	Targets     []string
+	Replace     []string
+	Destroy     bool
+	RefreshOnly bool
+	AutoApprove bool
+	NoColor     bool
+}
+
+// ShowOptions are the inputs to terraform show for a saved plan.
+type ShowOptions struct {
+	Chdir    string
+	PlanFile string
+	// JSON asks for the machine readable plan instead of the text one.
+	JSON    bool
+	NoColor bool
+}
+
+// InitOptions are the inputs to terraform init.
+type InitOptions struct {
+	Chdir string
+	// BackendConfig are key=value settings passed with -backend-config.
+	BackendConfig []string
+	// MigrateState copies the state to the backend as it is configured now,
+	// without asking since the caller has already.
+	MigrateState bool
+	// Upgrade picks the newest module and provider versions the constraints
+	// allow instead of the locked ones.
+	Upgrade bool
+	// SkipBackend leaves the backend as it was initialized, only modules and
+	// providers are installed.
+	SkipBackend bool
+	NoColor     bool
+}
+
+// ImportOptions are the inputs to terraform import.
+type ImportOptions struct {
+	Chdir   string
+	VarFile string
+	// Address is the resource address in the configuration, ID is the id of
+	// the existing object at the provider.
+	Address string
+	ID      string
+	NoColor bool
+}
+
+// GraphOptions are the inputs to terraform graph.
+type GraphOptions struct {
+	Chdir string
+	// Type is the -type of graph, plan or apply. It is left out with a
+	// PlanFile, which implies apply.
+	Type     string
+	PlanFile string
+}
+
+func globalArgs(chdir string) []string {
+	if chdir == "" {
+		return nil
+	}
+	return []string{"-chdir=" + chdir}
+}
+
+func planningArgs(varFile string, targets, replace []string, destroy, refreshOnly bool) []string {
+	var args []string
+	if varFile != "" {
+		args = append(args, "-var-file", varFile)
+	}
+	for _, t := range targets {
+		args = append(args, "-target="+t)
+	}
+	for _, r := range replace {
+		args = append(args, "-replace="+r)
+	}
+	if destroy {
+		args = append(args, "-destroy")
+	}
+	if refreshOnly {
+		args = append(args, "-refresh-only")
+	}
+	return args
+}
+
+// PlanArgs builds the argument list for terraform plan.
+func PlanArgs(o PlanOptions) []string {
+	args := append(globalArgs(o.Chdir), "plan")
+	args = append(args, planningArgs(o.VarFile, o.Targets, o.Replace, o.Destroy, o.RefreshOnly)...)
+	if o.NoLock {
+		args = append(args, "-lock=false")
+	} else if o.LockTimeout != "" {
+		args = append(args, "-lock-timeout="+o.LockTimeout)
+	}
+	if o.Out != "" {
+		args = append(args, "-out", o.Out)
+	}
+	if o.DetailedExitCode {
+		args = append(args, "-detailed-exitcode")
+	}
+	if o.NoColor {
+		args = append(args, "-no-color")
+	}
+	return args
+}
+
+// ApplyArgs builds the argument list for terraform apply.
+func ApplyArgs(o ApplyOptions) []string {
+	args := append(globalArgs(o.Chdir), "apply")
+	if o.AutoApprove {
+		args = append(args, "-auto-approve")
+	}
+	if o.NoColor {
+		args = append(args, "-no-color")
+	}
+	if o.PlanFile != "" {
+		return append(args, o.PlanFile)
+	}
+	return append(args, planningArgs(o.VarFile, o.Targets, o.Replace, o.Destroy, o.RefreshOnly)...)
+}
+
+// ShowArgs builds the argument list for terraform show.
+func ShowArgs(o ShowOptions) []string {
+	args := append(globalArgs(o.Chdir), "show")
+	if o.JSON {
+		args = append(args, "-json")
+	}
+	if o.NoColor {
+		args = append(args, "-no-color")
+	}
+	return append(args, o.PlanFile)
+}
+
+// ImportArgs builds the argument list for terraform import.
+func ImportArgs(o ImportOptions) []string {
+	args := append(globalArgs(o.Chdir), "import")
+	if o.VarFile != "" {
+		args = append(args, "-var-file", o.VarFile)
+	}
+	if o.NoColor {
+		args = append(args, "-no-color")
+	}
+	return append(args, o.Address, o.ID)
+}
+
+// InitArgs builds the argument list for terraform init.
+func InitArgs(o InitOptions) []string {
+	args := append(globalArgs(o.Chdir), "init", "-input=false")
+	for _, c := range o.BackendConfig {
+		args = append(args, "-backend-config="+c)
+	}
+	if o.MigrateState {
+		args = append(args, "-migrate-state", "-force-copy")
+	}
+	if o.Upgrade {
+		args = append(args, "-upgrade")
+	}
+	if o.SkipBackend {
+		args = append(args, "-backend=false")
+	}
+	if o.NoColor {
+		args = append(args, "-no-color")
+	}
+	return args
+}
+
+// GetArgs builds the argument list for terraform get, update downloads the
+// modules again even when they are installed.
+func GetArgs(chdir string, update bool) []string {
+	args := append(globalArgs(chdir), "get")
+	if update {
+		args = append(args, "-update")
+	}
+	return args
+}
+
+// GraphArgs builds the argument list for terraform graph.
+func GraphArgs(o GraphOptions) []string {
+	args := append(globalArgs(o.Chdir), "graph")
+	if o.PlanFile != "" {
+		return append(args, "-plan="+o.PlanFile)
+	}
+	if o.Type != "" {
+		args = append(args, "-type="+o.Type)
+	}
+	return args
+}
+
+// TaintArgs builds the argument list for terraform taint.
+func TaintArgs(chdir, address string) []string {
+	return append(globalArgs(chdir), "taint", address)
+}
+
+// UntaintArgs builds the argument list for terraform untaint.
+func UntaintArgs(chdir, address string) []string {
+	return append(globalArgs(chdir), "untaint", address)
+}
+
+// ConsoleArgs builds the argument list for terraform console. The console
+// only reads the state, so it never takes the state lock.
+func ConsoleArgs(chdir, varFile string) []string {
+	args := append(globalArgs(chdir), "console", "-lock=false")
+	if varFile != "" {
+		args = append(args, "-var-file", varFile)
+	}
+	return args
+}
+
+// StatePullArgs builds the argument list for terraform state pull.
+func StatePullArgs(chdir string) []string {
+	return append(globalArgs(chdir), "state", "pull")
+}
+
+// StatePushArgs builds the argument list for terraform state push. force
+// pushes a state with another lineage or a lower serial.
+func StatePushArgs(chdir, stateFile string, force bool) []string {
+	args := append(globalArgs(chdir), "state", "push")
+	if force {
+		args = append(args, "-force")
+	}
+	return append(args, stateFile)
+}
+
+// StateListArgs builds the argument list for terraform state list. The
+// addresses limit the list to those resources and modules.
+func StateListArgs(chdir string, addresses ...string) []string {
+	return append(append(globalArgs(chdir), "state", "list"), addresses...)
+}
+
+// StateShowArgs builds the argument list for terraform state show.
+func StateShowArgs(chdir, address string) []string {
+	return append(globalArgs(chdir), "state", "show", address)
+}
+
+// absPath makes the file absolute since -chdir changes what a relative path points at
+func absPath(kind, file string) (string, error) {
+	if file == "" {
+		return "", nil
+	}
+	p, err := filepath.Abs(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path of %s file: %w", kind, err)
+	}
+	return p, nil
+}
+
+// Apply runs terraform apply through the runner.
+func Apply(ctx context.Context, r TerraformRunner, o ApplyOptions, run RunOptions) error {
+	var err error
+	if o.VarFile, err = absPath("tfvars", o.VarFile); err != nil {
+		return err
+	}
+	if o.PlanFile, err = absPath("plan", o.PlanFile); err != nil {
+		return err
+	}
+
+	return execute(ctx, r, "apply", ApplyArgs(o), run)
+}
+
+// Plan runs terraform plan through the runner. With DetailedExitCode set,
+// changes come back as ErrPlanHasChanges.
+func Plan(ctx context.Context, r TerraformRunner, o PlanOptions, run RunOptions) error {
+	var err error
+	if o.VarFile, err = absPath("tfvars", o.VarFile); err != nil {
+		return err
+	}
+	if o.Out, err = absPath("plan", o.Out); err != nil {
+		return err
+	}
+
+	err = execute(ctx, r, "plan", PlanArgs(o), run)
+	var tfErr *ErrTerraformFailed
+	if errors.As(err, &tfErr) && tfErr.ExitCode == 2 && o.DetailedExitCode {
+		return ErrPlanHasChanges
+	}
+	return err
+}
+
+// Import runs terraform import through the runner.
+func Import(ctx context.Context, r TerraformRunner, o ImportOptions, run RunOptions) error {
+	var err error
+	if o.VarFile, err = absPath("tfvars", o.VarFile); err != nil {
+		return err
+	}
+
+	return execute(ctx, r, "import", ImportArgs(o), run)
+}
+
+// Graph runs terraform graph and returns the DOT graph it printed. The
+// output is captured, run.Stdout is ignored.
+func Graph(ctx context.Context, r TerraformRunner, o GraphOptions, run RunOptions) ([]byte, error) {
+	var err error
+	if o.PlanFile, err = absPath("plan", o.PlanFile); err != nil {
+		return nil, err
+	}
+
+	return capture(ctx, r, "graph", GraphArgs(o), run)
+}
+
+// Taint runs terraform taint, streaming its output.
+func Taint(ctx context.Context, r TerraformRunner, chdir, address string, run RunOptions) error {
+	return execute(ctx, r, "taint", TaintArgs(chdir, address), run)
+}
+
+// Untaint runs terraform untaint, streaming its output.
+func Untaint(ctx context.Context, r TerraformRunner, chdir, address string, run RunOptions) error {
+	return execute(ctx, r, "untaint", UntaintArgs(chdir, address), run)
+}
+
+// Console runs terraform console with run's stdin, stdout and stderr handed
+// to it as they are, so the prompt works on a terminal. Nothing is watched or
+// redacted, and a non-zero exit is ErrTerraformFailed with terraform's code.
+func Console(ctx context.Context, r TerraformRunner, chdir, varFile string, run RunOptions) error {
+	varFile, err := absPath("tfvars", varFile)
+	if err != nil {
+		return err
+	}
+	if err := r.Run(ctx, ConsoleArgs(chdir, varFile), run); err != nil {
+		return failed("console", err)
+	}
+	return nil
+}
+
+// Show runs terraform show on a saved plan and returns what it printed. The
+// output is captured, run.Stdout is ignored.
+func Show(ctx context.Context, r TerraformRunner, o ShowOptions, run RunOptions) ([]byte, error) {
+	var err error
+	if o.PlanFile, err = absPath("plan", o.PlanFile); err != nil {
+		return nil, err
+	}
+
+	return capture(ctx, r, "show", ShowArgs(o), run)
+}
+
+// StatePull runs terraform state pull and returns the state it printed. The
+// output is captured, run.Stdout is ignored.
+func StatePull(ctx context.Context, r TerraformRunner, chdir string, run RunOptions) ([]byte, error) {
+	return capture(ctx, r, "state pull", StatePullArgs(chdir), run)
+}
+
+// StatePush runs terraform state push with a local state file, streaming
+// its output.
+func StatePush(ctx context.Context, r TerraformRunner, chdir, stateFile string, force bool, run RunOptions) error {
+	stateFile, err := absPath("state", stateFile)
+	if err != nil {
+		return err
+	}
+	return execute(ctx, r, "state push", StatePushArgs(chdir, stateFile, force), run)
+}
+
+// capturedOutput is the stdout of a command whose output is the result, it
+// is never redacted
+type capturedOutput struct {
+	bytes.Buffer
+}
+
+// capture runs a command whose stdout is the result rather than something to show
+func capture(ctx context.Context, r TerraformRunner, command string, args []string, run RunOptions) ([]byte, error) {
+	var out capturedOutput
+	run.Stdout = &out
+	if err := execute(ctx, r, command, args, run); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Init runs terraform init, streaming its output.
+func Init(ctx context.Context, r TerraformRunner, o InitOptions, run RunOptions) error {
+	return execute(ctx, r, "init", InitArgs(o), run)
+}
+
+// Get runs terraform get, streaming its output.
+func Get(ctx context.Context, r TerraformRunner, chdir string, update bool, run RunOptions) error {
+	return execute(ctx, r, "get", GetArgs(chdir, update), run)
+}
+
+// StateList runs terraform state list, streaming its output.
+func StateList(ctx context.Context, r TerraformRunner, chdir string, addresses []string, run RunOptions) error {
+	return execute(ctx, r, "state list", StateListArgs(chdir, addresses...), run)
+}
+
+// StateShow runs terraform state show for one address, streaming its output.
+func StateShow(ctx context.Context, r TerraformRunner, chdir, address string, run RunOptions) error {
+	return execute(ctx, r, "state show", StateShowArgs(chdir, address), run)
+}
@@ -0,0 +1,282 @@
+package tfexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+)
+
+func TestPlanArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts PlanOptions
+		want []string
+	}{
+		{"bare", PlanOptions{}, []string{"plan"}},
+		{"var file and out", PlanOptions{VarFile: "/w/dev.tfvars", Out: "/w/plan.out"},
+			[]string{"plan", "-var-file", "/w/dev.tfvars", "-out", "/w/plan.out"}},
+		{"targets", PlanOptions{VarFile: "/w/dev.tfvars", Targets: []string{"aws_s3_bucket.a", "module.b"}},
+			[]string{"plan", "-var-file", "/w/dev.tfvars", "-target=aws_s3_bucket.a", "-target=module.b"}},
+		{"replace", PlanOptions{VarFile: "/w/dev.tfvars", Replace: []string{"aws_instance.web"}},
+			[]string{"plan", "-var-file", "/w/dev.tfvars", "-replace=aws_instance.web"}},
+		{"destroy", PlanOptions{VarFile: "/w/dev.tfvars", Destroy: true},
+			[]string{"plan", "-var-file", "/w/dev.tfvars", "-destroy"}},
+		{"refresh only", PlanOptions{VarFile: "/w/dev.tfvars", RefreshOnly: true},
+			[]string{"plan", "-var-file", "/w/dev.tfvars", "-refresh-only"}},
+		{"chdir", PlanOptions{Chdir: "infra", VarFile: "/w/dev.tfvars"},
+			[]string{"-chdir=infra", "plan", "-var-file", "/w/dev.tfvars"}},
+		{"detailed exit code", PlanOptions{Out: "/w/p", DetailedExitCode: true},
+			[]string{"plan", "-out", "/w/p", "-detailed-exitcode"}},
+		{"no lock", PlanOptions{VarFile: "/v", NoLock: true, LockTimeout: "5s"},
+			[]string{"plan", "-var-file", "/v", "-lock=false"}},
+		{"lock timeout", PlanOptions{VarFile: "/v", LockTimeout: "5s"},
+			[]string{"plan", "-var-file", "/v", "-lock-timeout=5s"}},
+		{"no color", PlanOptions{VarFile: "/v", NoColor: true},
+			[]string{"plan", "-var-file", "/v", "-no-color"}},
+		{"everything", PlanOptions{Chdir: "infra", VarFile: "/v", Out: "/o", Targets: []string{"a.b"}, Destroy: true, RefreshOnly: true, DetailedExitCode: true},
+			[]string{"-chdir=infra", "plan", "-var-file", "/v", "-target=a.b", "-destroy", "-refresh-only", "-out", "/o", "-detailed-exitcode"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PlanArgs(tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ApplyOptions
+		want []string
+	}{
+		{"var file", ApplyOptions{VarFile: "/w/dev.tfvars", AutoApprove: true},
+			[]string{"apply", "-auto-approve", "-var-file", "/w/dev.tfvars"}},
+		{"saved plan ignores planning options", ApplyOptions{VarFile: "/w/dev.tfvars", PlanFile: "/w/plan.out", Targets: []string{"a.b"}, AutoApprove: true},
+			[]string{"apply", "-auto-approve", "/w/plan.out"}},
+		{"targets", ApplyOptions{VarFile: "/v", Targets: []string{"a.b"}},
+			[]string{"apply", "-var-file", "/v", "-target=a.b"}},
+		{"destroy", ApplyOptions{VarFile: "/v", Destroy: true, AutoApprove: true},
+			[]string{"apply", "-auto-approve", "-var-file", "/v", "-destroy"}},
+		{"refresh only", ApplyOptions{VarFile: "/v", RefreshOnly: true},
+			[]string{"apply", "-var-file", "/v", "-refresh-only"}},
+		{"no color with saved plan", ApplyOptions{PlanFile: "/p", AutoApprove: true, NoColor: true},
+			[]string{"apply", "-auto-approve", "-no-color", "/p"}},
+		{"chdir", ApplyOptions{Chdir: "infra", PlanFile: "/p"},
+			[]string{"-chdir=infra", "apply", "/p"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ApplyArgs(tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ApplyArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanResolvesPathsAndMapsExitCodes(t *testing.T) {
+	varFile, _ := filepath.Abs("dev.tfvars")
+	out, _ := filepath.Abs("plan.out")
+
+	r := &RecordingRunner{}
+	err := Plan(context.Background(), r, PlanOptions{VarFile: "dev.tfvars", Out: "plan.out", DetailedExitCode: true}, RunOptions{})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	want := []string{"plan", "-var-file", varFile, "-out", out, "-detailed-exitcode"}
+	if got := r.Args(); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Fatalf("calls = %q, want %q", got, want)
+	}
+
+	r.Result = func([]string) error { return &FakeExitError{Code: 2} }
+	err = Plan(context.Background(), r, PlanOptions{VarFile: "dev.tfvars", DetailedExitCode: true}, RunOptions{})
+	if !errors.Is(err, ErrPlanHasChanges) {
+		t.Errorf("exit 2 gave %v, want ErrPlanHasChanges", err)
+	}
+
+	r.Result = func([]string) error { return &FakeExitError{Code: 1} }
+	err = Plan(context.Background(), r, PlanOptions{VarFile: "dev.tfvars", DetailedExitCode: true}, RunOptions{})
+	var tfErr *ErrTerraformFailed
+	if !errors.As(err, &tfErr) || tfErr.ExitCode != 1 || tfErr.Command != "plan" {
+		t.Errorf("exit 1 gave %v, want ErrTerraformFailed with exit code 1", err)
+	}
+
+	r.Result = func([]string) error { return errors.New(`exec: "terraform": executable file not found in $PATH`) }
+	err = Plan(context.Background(), r, PlanOptions{VarFile: "dev.tfvars"}, RunOptions{})
+	if !errors.As(err, &tfErr) || tfErr.ExitCode != -1 {
+		t.Errorf("start failure gave %v, want ErrTerraformFailed with exit code -1", err)
+	}
+}
+
+func TestApplyWithoutDetailedExitCodeTreatsTwoAsFailure(t *testing.T) {
+	r := &RecordingRunner{Result: func([]string) error { return &FakeExitError{Code: 2} }}
+	err := Apply(context.Background(), r, ApplyOptions{VarFile: "dev.tfvars", AutoApprove: true}, RunOptions{})
+	if err == nil {
+		t.Fatal("Apply() succeeded on a non-zero exit")
+	}
+}
+
+func TestShowCapturesStdout(t *testing.T) {
+	plan, _ := filepath.Abs("plan.out")
+	r := &RecordingRunner{Output: `{"format_version":"1.2"}`}
+
+	data, err := Show(context.Background(), r, ShowOptions{Chdir: "infra", PlanFile: "plan.out", JSON: true}, RunOptions{})
+	if err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+	if string(data) != `{"format_version":"1.2"}` {
+		t.Errorf("output = %q", data)
+	}
+	want := []string{"-chdir=infra", "show", "-json", plan}
+	if got := r.Args(); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+	if got := ShowArgs(ShowOptions{PlanFile: "/p", NoColor: true}); !reflect.DeepEqual(got, []string{"show", "-no-color", "/p"}) {
+		t.Errorf("text ShowArgs() = %q", got)
+	}
+}
+
+func TestRedactorMasksStreamedOutput(t *testing.T) {
+	r := &RecordingRunner{
+		Output: "connecting with hunter2\n",
+		Stderr: "Error: password hunter2 rejected",
+		Result: func([]string) error { return &FakeExitError{Code: 1} },
+	}
+	var stdout, stderr bytes.Buffer
+	run := RunOptions{Stdout: &stdout, Stderr: &stderr, Redactor: redact.NewRedactor("hunter2")}
+	StateList(context.Background(), r, "", nil, run)
+	if stdout.String() != "connecting with (redacted)\n" || stderr.String() != "Error: password (redacted) rejected" {
+		t.Errorf("streamed %q and %q, want the value masked", stdout.String(), stderr.String())
+	}
+
+	r.Result = nil
+	if data, err := StatePull(context.Background(), r, "", run); err != nil || string(data) != "connecting with hunter2\n" {
+		t.Errorf("StatePull() = %q, %v, want the captured output untouched", data, err)
+	}
+}
+
+func TestStatePull(t *testing.T) {
+	r := &RecordingRunner{Output: `{"version":4,"serial":7}`}
+	data, err := StatePull(context.Background(), r, "infra", RunOptions{})
+	if err != nil || string(data) != `{"version":4,"serial":7}` {
+		t.Fatalf("StatePull() = %q, %v", data, err)
+	}
+	if got := r.Args(); !reflect.DeepEqual(got[0], []string{"-chdir=infra", "state", "pull"}) {
+		t.Errorf("calls = %q", got)
+	}
+
+	r.Result = func([]string) error { return &FakeExitError{Code: 1} }
+	var tfErr *ErrTerraformFailed
+	if _, err := StatePull(context.Background(), r, "", RunOptions{}); !errors.As(err, &tfErr) || tfErr.Command != "state pull" {
+		t.Errorf("err = %v, want ErrTerraformFailed for state pull", err)
+	}
+}
+
+func TestNotInitialized(t *testing.T) {
+	r := &RecordingRunner{
+		Result: func([]string) error { return &FakeExitError{Code: 1} },
+		Stderr: "Error: Backend initialization required, please run \"terraform init\"\n",
+	}
+	err := StateList(context.Background(), r, "infra", nil, RunOptions{Stderr: io.Discard})
+	var tfErr *ErrTerraformFailed
+	if !errors.Is(err, ErrNotInitialized) || !errors.As(err, &tfErr) || tfErr.Command != "state list" {
+		t.Errorf("err = %v, want ErrNotInitialized and ErrTerraformFailed", err)
+	}
+
+	r.Stderr = "Error: Invalid resource address\n"
+	err = StateShow(context.Background(), r, "", "aws_instance.web", RunOptions{Stderr: io.Discard})
+	if errors.Is(err, ErrNotInitialized) || !errors.As(err, &tfErr) {
+		t.Errorf("err = %v, want a plain ErrTerraformFailed", err)
+	}
+}
+
+func TestStateArgs(t *testing.T) {
+	if got := StateListArgs("infra", "module.network"); !reflect.DeepEqual(got, []string{"-chdir=infra", "state", "list", "module.network"}) {
+		t.Errorf("StateListArgs() = %q", got)
+	}
+	if got := StatePushArgs("infra", "/tmp/state.json", true); !reflect.DeepEqual(got, []string{"-chdir=infra", "state", "push", "-force", "/tmp/state.json"}) {
+		t.Errorf("StatePushArgs() = %q", got)
+	}
+	if got := StateShowArgs("", `aws_instance.web["a"]`); !reflect.DeepEqual(got, []string{"state", "show", `aws_instance.web["a"]`}) {
+		t.Errorf("StateShowArgs() = %q", got)
+	}
+}
+
+func TestImportArgs(t *testing.T) {
+	got := ImportArgs(ImportOptions{Chdir: "infra", VarFile: "/w/prod.tfvars", Address: "aws_s3_bucket.logs", ID: "my-logs", NoColor: true})
+	want := []string{"-chdir=infra", "import", "-var-file", "/w/prod.tfvars", "-no-color", "aws_s3_bucket.logs", "my-logs"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestInitArgs(t *testing.T) {
+	got := InitArgs(InitOptions{Chdir: "infra", BackendConfig: []string{"key=prod/terraform.tfstate"}, MigrateState: true})
+	want := []string{"-chdir=infra", "init", "-input=false", "-backend-config=key=prod/terraform.tfstate", "-migrate-state", "-force-copy"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InitArgs() = %q, want %q", got, want)
+	}
+	got = InitArgs(InitOptions{Upgrade: true, SkipBackend: true, NoColor: true})
+	want = []string{"init", "-input=false", "-upgrade", "-backend=false", "-no-color"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InitArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestTaintArgs(t *testing.T) {
+	if got := TaintArgs("infra", "aws_instance.web"); !reflect.DeepEqual(got, []string{"-chdir=infra", "taint", "aws_instance.web"}) {
+		t.Errorf("TaintArgs() = %q", got)
+	}
+	if got := UntaintArgs("", "aws_instance.web"); !reflect.DeepEqual(got, []string{"untaint", "aws_instance.web"}) {
+		t.Errorf("UntaintArgs() = %q", got)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	if got := ConsoleArgs("infra", "/w/prod.tfvars"); !reflect.DeepEqual(got, []string{"-chdir=infra", "console", "-lock=false", "-var-file", "/w/prod.tfvars"}) {
+		t.Errorf("ConsoleArgs() = %q", got)
+	}
+
+	r := &RecordingRunner{Result: func([]string) error { return &FakeExitError{Code: 3} }}
+	stdin := strings.NewReader("var.region\n")
+	err := Console(context.Background(), r, "", "prod.tfvars", RunOptions{Stdin: stdin, Redactor: redact.NewRedactor()})
+	var tfErr *ErrTerraformFailed
+	if !errors.As(err, &tfErr) || tfErr.ExitCode != 3 || tfErr.Command != "console" {
+		t.Fatalf("Console() = %v, want terraform console failed with exit code 3", err)
+	}
+	call := r.Calls[0]
+	if !filepath.IsAbs(call.Args[len(call.Args)-1]) {
+		t.Errorf("Console() passed the tfvars as %q, want an absolute path", call.Args[len(call.Args)-1])
+	}
+	if call.Opts.Stdin != stdin || call.Opts.Stdout != nil || call.Opts.Stderr != nil {
+		t.Errorf("Console() changed the streams: %+v", call.Opts)
+	}
+}
+
+func TestGraphArgs(t *testing.T) {
+	if got := GraphArgs(GraphOptions{Chdir: "infra", Type: "plan"}); !reflect.DeepEqual(got, []string{"-chdir=infra", "graph", "-type=plan"}) {
+		t.Errorf("GraphArgs() = %q", got)
+	}
+	if got := GraphArgs(GraphOptions{Type: "apply", PlanFile: "/w/p.tfplan"}); !reflect.DeepEqual(got, []string{"graph", "-plan=/w/p.tfplan"}) {
+		t.Errorf("GraphArgs() with a plan = %q", got)
+	}
+}
+
+func TestPlanVersionMismatch(t *testing.T) {
+	r := &RecordingRunner{
+		Result: func([]string) error { return &FakeExitError{Code: 1} },
+		Stderr: "Error: Failed to read the given file as a state or plan file\n\nplan file was created by Terraform 1.5.7, but this is 1.6.2; plan files cannot be transferred between different Terraform versions.\n",
+	}
+	_, err := Show(context.Background(), r, ShowOptions{PlanFile: "/w/p.tfplan"}, RunOptions{Stderr: io.Discard})
+	if !errors.Is(err, ErrPlanVersionMismatch) || errors.Is(err, ErrNotInitialized) {
+		t.Errorf("err = %v, want ErrPlanVersionMismatch", err)
+	}
+}
@@ -0,0 +1,102 @@
+package tfexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Diagnostic is one error or warning of terraform validate, File and Line
+// are empty when it isn't about a place in the configuration.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Detail   string `json:"detail,omitempty"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+// Message is the summary with the detail's first line after it.
+func (d Diagnostic) Message() string {
+	detail, _, _ := strings.Cut(strings.TrimSpace(d.Detail), "\n")
+	if detail == "" {
+		return d.Summary
+	}
+	return d.Summary + ": " + detail
+}
+
+// FmtCheckArgs builds the argument list for terraform fmt -check, which
+// lists the files that aren't formatted.
+func FmtCheckArgs(chdir string) []string {
+	return append(globalArgs(chdir), "fmt", "-check", "-recursive", "-list=true", "-no-color")
+}
+
+// ValidateArgs builds the argument list for terraform validate -json.
+func ValidateArgs(chdir string) []string {
+	return append(globalArgs(chdir), "validate", "-json", "-no-color")
+}
+
+// FmtCheck runs terraform fmt -check and gives back the files that aren't
+// formatted, relative to chdir. Terraform failing for any other reason is
+// an error.
+func FmtCheck(ctx context.Context, r TerraformRunner, chdir string, run RunOptions) ([]string, error) {
+	var out capturedOutput
+	run.Stdout = &out
+	err := execute(ctx, r, "fmt", FmtCheckArgs(chdir), run)
+	var files []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	if err != nil && len(files) == 0 {
+		return nil, err
+	}
+	return files, nil
+}
+
+// validateOutput is what terraform validate -json prints, see
+// https://developer.hashicorp.com/terraform/cli/commands/validate#json
+type validateOutput struct {
+	Valid       bool `json:"valid"`
+	Diagnostics []struct {
+		Severity string `json:"severity"`
+		Summary  string `json:"summary"`
+		Detail   string `json:"detail"`
+		Range    *struct {
+			Filename string `json:"filename"`
+			Start    struct {
+				Line int `json:"line"`
+			} `json:"start"`
+		} `json:"range"`
+	} `json:"diagnostics"`
+}
+
+// Validate runs terraform validate -json and gives back its diagnostics and
+// whether the configuration is valid. Terraform exits 1 for an invalid
+// configuration, which is only an error when it didn't print its JSON.
+func Validate(ctx context.Context, r TerraformRunner, chdir string, run RunOptions) (bool, []Diagnostic, error) {
+	var out capturedOutput
+	run.Stdout = &out
+	err := execute(ctx, r, "validate", ValidateArgs(chdir), run)
+	var result validateOutput
+	if jsonErr := json.Unmarshal(out.Bytes(), &result); jsonErr != nil {
+		if err != nil {
+			return false, nil, err
+		}
+		return false, nil, fmt.Errorf("failed to read terraform validate's output: %w", jsonErr)
+	}
+	if err != nil && result.Valid {
+		return false, nil, err
+	}
+	diags := make([]Diagnostic, 0, len(result.Diagnostics))
+	for _, d := range result.Diagnostics {
+		diag := Diagnostic{Severity: d.Severity, Summary: d.Summary, Detail: d.Detail}
+		if d.Range != nil {
+			diag.File, diag.Line = d.Range.Filename, d.Range.Start.Line
+		}
+		diags = append(diags, diag)
+	}
+	return result.Valid, diags, nil
+}
@@ -0,0 +1,58 @@
+package tfexec
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestFmtCheck(t *testing.T) {
+	r := &RecordingRunner{Output: "main.tf\nmodules/net/vpc.tf\n", Result: func([]string) error { return &FakeExitError{Code: 3} }}
+	files, err := FmtCheck(context.Background(), r, "infra", RunOptions{})
+	if err != nil || !slices.Equal(files, []string{"main.tf", "modules/net/vpc.tf"}) {
+		t.Errorf("FmtCheck() = %q, %v", files, err)
+	}
+	if want := []string{"-chdir=infra", "fmt", "-check", "-recursive", "-list=true", "-no-color"}; !slices.Equal(r.Calls[0].Args, want) {
+		t.Errorf("args = %q, want %q", r.Calls[0].Args, want)
+	}
+
+	r = &RecordingRunner{Result: func([]string) error { return &FakeExitError{Code: 1} }}
+	var failed *ErrTerraformFailed
+	if _, err := FmtCheck(context.Background(), r, "", RunOptions{}); !errors.As(err, &failed) {
+		t.Errorf("FmtCheck() of a failing terraform = %v, want ErrTerraformFailed", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	out := `{"format_version":"1.0","valid":false,"error_count":1,"warning_count":1,"diagnostics":[
+{"severity":"error","summary":"Unsupported argument","detail":"An argument named \"nme\" is not expected here.\nDid you mean \"name\"?","range":{"filename":"main.tf","start":{"line":12,"column":3}}},
+{"severity":"warning","summary":"Deprecated attribute","detail":""}]}`
+	r := &RecordingRunner{Output: out, Result: func([]string) error { return &FakeExitError{Code: 1} }}
+	valid, diags, err := Validate(context.Background(), r, "infra", RunOptions{})
+	if err != nil || valid {
+		t.Fatalf("Validate() = %v, %v", valid, err)
+	}
+	want := []Diagnostic{
+		{Severity: "error", Summary: "Unsupported argument", Detail: "An argument named \"nme\" is not expected here.\nDid you mean \"name\"?", File: "main.tf", Line: 12},
+		{Severity: "warning", Summary: "Deprecated attribute"},
+	}
+	if !slices.Equal(diags, want) {
+		t.Errorf("diagnostics = %+v, want %+v", diags, want)
+	}
+	if got := diags[0].Message(); got != `Unsupported argument: An argument named "nme" is not expected here.` {
+		t.Errorf("Message() = %q", got)
+	}
+	if want := []string{"-chdir=infra", "validate", "-json", "-no-color"}; !slices.Equal(r.Calls[0].Args, want) {
+		t.Errorf("args = %q, want %q", r.Calls[0].Args, want)
+	}
+
+	r = &RecordingRunner{Output: `{"valid":true,"diagnostics":[]}`}
+	if valid, diags, err := Validate(context.Background(), r, "", RunOptions{}); !valid || len(diags) != 0 || err != nil {
+		t.Errorf("Validate() of a valid configuration = %v, %v, %v", valid, diags, err)
+	}
+	r = &RecordingRunner{Stderr: "terraform crashed", Result: func([]string) error { return &FakeExitError{Code: 11} }}
+	if _, _, err := Validate(context.Background(), r, "", RunOptions{}); err == nil {
+		t.Error("Validate() without JSON succeeded")
+	}
+}
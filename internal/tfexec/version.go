@@ -0,0 +1,70 @@
+package tfexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a terraform version, without any pre-release suffix.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast is true when v is the same as or newer than o.
+func (v Version) AtLeast(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major > o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor > o.Minor
+	}
+	return v.Patch >= o.Patch
+}
+
+// ParseVersion reads versions like "1.5.7", "v1.6.0" or "1.7.0-beta1".
+func ParseVersion(s string) (Version, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid terraform version %q", s)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid terraform version %q", s)
+		}
+		nums[i] = n
+	}
+	return Version{nums[0], nums[1], nums[2]}, nil
+}
+
+// VersionArgs builds the argument list for terraform version.
+func VersionArgs() []string {
+	return []string{"version", "-json"}
+}
+
+// TerraformVersion asks terraform for its version.
+func TerraformVersion(ctx context.Context, r TerraformRunner, run RunOptions) (Version, error) {
+	data, err := capture(ctx, r, "version", VersionArgs(), run)
+	if err != nil {
+		return Version{}, err
+	}
+	var out struct {
+		Version string `json:"terraform_version"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Version{}, fmt.Errorf("terraform version did not print JSON: %w", err)
+	}
+	return ParseVersion(out.Version)
+}
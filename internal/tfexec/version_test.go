@@ -0,0 +1,39 @@
+package tfexec
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for in, want := range map[string]Version{"1.5.7": {1, 5, 7}, "v1.6.0": {1, 6, 0}, "1.7.0-beta1": {1, 7, 0}} {
+		if got, err := ParseVersion(in); err != nil || got != want {
+			t.Errorf("ParseVersion(%q) = %v, %v", in, got, err)
+		}
+	}
+	for _, bad := range []string{"", "1.5", "one.two.three"} {
+		if _, err := ParseVersion(bad); err == nil {
+			t.Errorf("ParseVersion(%q) gave no error", bad)
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	v := Version{0, 15, 2}
+	for _, c := range []struct {
+		other Version
+		want  bool
+	}{{Version{0, 15, 2}, true}, {Version{0, 15, 3}, false}, {Version{0, 14, 9}, true}, {Version{1, 0, 0}, false}} {
+		if got := v.AtLeast(c.other); got != c.want {
+			t.Errorf("%v.AtLeast(%v) = %v", v, c.other, got)
+		}
+	}
+}
+
+func TestTerraformVersion(t *testing.T) {
+	r := &RecordingRunner{Output: `{"terraform_version":"1.6.2","platform":"linux_amd64"}`}
+	v, err := TerraformVersion(context.Background(), r, RunOptions{})
+	if err != nil || v != (Version{1, 6, 2}) {
+		t.Errorf("TerraformVersion() = %v, %v", v, err)
+	}
+}
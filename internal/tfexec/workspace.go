@@ -0,0 +1,79 @@
+package tfexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// WorkspaceListArgs builds the argument list for terraform workspace list.
+func WorkspaceListArgs(chdir string) []string {
+	return append(globalArgs(chdir), "workspace", "list")
+}
+
+// WorkspaceNewArgs builds the argument list for terraform workspace new,
+// which also selects the new workspace.
+func WorkspaceNewArgs(chdir, name string) []string {
+	return append(globalArgs(chdir), "workspace", "new", name)
+}
+
+// WorkspaceSelectArgs builds the argument list for terraform workspace select.
+func WorkspaceSelectArgs(chdir, name string) []string {
+	return append(globalArgs(chdir), "workspace", "select", name)
+}
+
+// Workspaces runs terraform workspace list and gives back the workspaces and
+// the selected one. TF_WORKSPACE overrides the selection, so the caller
+// leaves it out of run.Env to see what terraform init last selected.
+func Workspaces(ctx context.Context, r TerraformRunner, chdir string, run RunOptions) ([]string, string, error) {
+	if err := checkInitialized(chdir); err != nil {
+		return nil, "", err
+	}
+	out, err := capture(ctx, r, "workspace list", WorkspaceListArgs(chdir), run)
+	if err != nil {
+		return nil, "", err
+	}
+	var names []string
+	var selected string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(line, "* "); ok {
+			line = strings.TrimSpace(name)
+			selected = line
+		}
+		if line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, selected, nil
+}
+
+// WorkspaceNew runs terraform workspace new, streaming its output.
+func WorkspaceNew(ctx context.Context, r TerraformRunner, chdir, name string, run RunOptions) error {
+	if err := checkInitialized(chdir); err != nil {
+		return err
+	}
+	return execute(ctx, r, "workspace new", WorkspaceNewArgs(chdir, name), run)
+}
+
+// WorkspaceSelect runs terraform workspace select, streaming its output.
+func WorkspaceSelect(ctx context.Context, r TerraformRunner, chdir, name string, run RunOptions) error {
+	if err := checkInitialized(chdir); err != nil {
+		return err
+	}
+	return execute(ctx, r, "workspace select", WorkspaceSelectArgs(chdir, name), run)
+}
+
+// checkInitialized fails with ErrNotInitialized when terraform init hasn't
+// run in chdir. Terraform itself only says so for a remote backend, without
+// init it lists the local default workspace.
+func checkInitialized(chdir string) error {
+	dataDir := DataDir(chdir)
+	if _, err := os.Stat(dataDir); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s doesn't exist", ErrNotInitialized, dataDir)
+	}
+	return nil
+}
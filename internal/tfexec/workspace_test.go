@@ -0,0 +1,37 @@
+package tfexec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWorkspaces(t *testing.T) {
+	dir := t.TempDir()
+	r := &RecordingRunner{Output: "  default\n* production\n  staging\n\n"}
+	if _, _, err := Workspaces(context.Background(), r, dir, RunOptions{}); !errors.Is(err, ErrNotInitialized) || len(r.Calls) != 0 {
+		t.Errorf("Workspaces() before init = %v, %d calls", err, len(r.Calls))
+	}
+
+	os.Mkdir(filepath.Join(dir, ".terraform"), 0o755)
+	names, selected, err := Workspaces(context.Background(), r, dir, RunOptions{})
+	if err != nil || selected != "production" || !slices.Equal(names, []string{"default", "production", "staging"}) {
+		t.Errorf("Workspaces() = %q, %q, %v", names, selected, err)
+	}
+	if want := []string{"-chdir=" + dir, "workspace", "list"}; !slices.Equal(r.Calls[0].Args, want) {
+		t.Errorf("args = %q, want %q", r.Calls[0].Args, want)
+	}
+
+	if err := WorkspaceNew(context.Background(), r, dir, "qa", RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WorkspaceSelect(context.Background(), r, dir, "qa", RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Args()[1:]; !slices.Equal(got[0], []string{"-chdir=" + dir, "workspace", "new", "qa"}) || !slices.Equal(got[1], []string{"-chdir=" + dir, "workspace", "select", "qa"}) {
+		t.Errorf("args = %q", got)
+	}
+}
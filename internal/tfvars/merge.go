@@ -0,0 +1,224 @@
+package tfvars
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Labels name the two sides of a conflict in its markers, the way git names
+// the branches.
+type Labels struct {
+	Local, Remote string
+}
+
+// MergeResult is the outcome of a three-way merge.
+type MergeResult struct {
+	Data []byte
+	// Conflicts are the variables both sides changed, each differently. They
+	// are written between conflict markers with both values.
+	Conflicts []string
+}
+
+// side is what one version of the file says about a variable
+type side struct {
+	Assignment
+	ok bool
+}
+
+func sideOf(assignments []Assignment, name string) side {
+	a, ok := Lookup(assignments, name)
+	return side{a, ok}
+}
+
+func (s side) same(o side) bool {
+	return s.ok == o.ok && (!s.ok || Equal(s.Value, o.Value))
+}
+
+// resolution is what the merge does with a variable of the local file
+type resolution int
+
+const (
+	keepLocal resolution = iota
+	takeRemote
+	conflict
+)
+
+func resolve(base, local, remote side) resolution {
+	switch {
+	case remote.same(base), remote.same(local):
+		return keepLocal
+	case local.same(base):
+		return takeRemote
+	}
+	return conflict
+}
+
+// names is every variable of the three versions, in the order of the first
+// one that sets it.
+func names(versions ...[]Assignment) []string {
+	var out []string
+	for _, assignments := range versions {
+		for _, a := range assignments {
+			if !slices.Contains(out, a.Name) {
+				out = append(out, a.Name)
+			}
+		}
+	}
+	return out
+}
+
+// Merge merges the changes between base and remote into local, variable by
+// variable: a variable only one side changed takes that side's value, and
+// one both sides changed the same way is left alone. Local keeps its
+// comments and layout, a variable taken from remote is copied as remote
+// writes it and new ones go at the end.
+func Merge(base, local, remote []byte, labels Labels) (MergeResult, error) {
+	var parsed [3][]Assignment
+	for i, data := range [][]byte{base, local, remote} {
+		assignments, err := Parse(data)
+		if err != nil {
+			return MergeResult{}, fmt.Errorf("the %s version: %w", [...]string{"base", "local", "remote"}[i], err)
+		}
+		parsed[i] = assignments
+	}
+	localLines, remoteLines := lines(local), lines(remote)
+	text := func(lines []string, s side) []string {
+		if !s.ok {
+			return nil
+		}
+		return lines[s.Line-1 : s.EndLine]
+	}
+
+	var (
+		result   MergeResult
+		replaced = map[int][]string{} // by the local line an assignment starts on
+		dropped  = map[int]bool{}
+		appended [][]string
+	)
+	for _, name := range names(parsed[1], parsed[2], parsed[0]) {
+		b, l, r := sideOf(parsed[0], name), sideOf(parsed[1], name), sideOf(parsed[2], name)
+		var with []string
+		switch resolve(b, l, r) {
+		case keepLocal:
+			continue
+		case takeRemote:
+			with = text(remoteLines, r)
+		case conflict:
+			result.Conflicts = append(result.Conflicts, name)
+			with = slices.Concat([]string{"<<<<<<< " + labels.Local}, text(localLines, l), []string{"======="}, text(remoteLines, r), []string{">>>>>>> " + labels.Remote})
+		}
+		switch {
+		case l.ok:
+			replaced[l.Line] = with
+			for n := l.Line; n <= l.EndLine; n++ {
+				dropped[n] = true
+			}
+		case with != nil:
+			appended = append(appended, with)
+		}
+	}
+
+	var out []string
+	for i, line := range localLines {
+		if with, ok := replaced[i+1]; ok {
+			out = append(out, with...)
+		}
+		if !dropped[i+1] {
+			out = append(out, line)
+		}
+	}
+	for _, with := range appended {
+		out = append(out, with...)
+	}
+	if len(out) > 0 {
+		result.Data = []byte(strings.Join(out, "\n") + "\n")
+	}
+	return result, nil
+}
+
+// lines splits a file into its lines, without the empty one after the last
+// newline
+func lines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// MergeJSON is Merge for .tfvars.json files. The result is indented and
+// sorted by name, a conflict is written between markers like in Merge, which
+// leaves the file invalid JSON until it is resolved.
+func MergeJSON(base, local, remote []byte, labels Labels) (MergeResult, error) {
+	var parsed [3][]Assignment
+	for i, data := range [][]byte{base, local, remote} {
+		assignments, err := ParseJSON(data)
+		if err != nil {
+			return MergeResult{}, fmt.Errorf("the %s version: %w", [...]string{"base", "local", "remote"}[i], err)
+		}
+		parsed[i] = assignments
+	}
+	all := names(parsed[1], parsed[2], parsed[0])
+	slices.Sort(all)
+
+	var result MergeResult
+	var entries [][]string
+	for _, name := range all {
+		b, l, r := sideOf(parsed[0], name), sideOf(parsed[1], name), sideOf(parsed[2], name)
+		switch resolve(b, l, r) {
+		case keepLocal:
+			if l.ok {
+				entries = append(entries, []string{jsonEntry(l)})
+			}
+		case takeRemote:
+			if r.ok {
+				entries = append(entries, []string{jsonEntry(r)})
+			}
+		case conflict:
+			result.Conflicts = append(result.Conflicts, name)
+			entry := []string{"<<<<<<< " + labels.Local}
+			if l.ok {
+				entry = append(entry, jsonEntry(l))
+			}
+			entry = append(entry, "=======")
+			if r.ok {
+				entry = append(entry, jsonEntry(r))
+			}
+			entries = append(entries, append(entry, ">>>>>>> "+labels.Remote))
+		}
+	}
+
+	var buf strings.Builder
+	buf.WriteString("{\n")
+	for i, entry := range entries {
+		for _, line := range entry {
+			if i < len(entries)-1 && !strings.HasPrefix(line, "<<<<<<<") && !strings.HasPrefix(line, "=======") && !strings.HasPrefix(line, ">>>>>>>") {
+				line += ","
+			}
+			buf.WriteString(line + "\n")
+		}
+	}
+	buf.WriteString("}\n")
+	result.Data = []byte(buf.String())
+	return result, nil
+}
+
+func jsonEntry(s side) string {
+	name, _ := json.Marshal(s.Name)
+	var value bytes.Buffer
+	if err := json.Indent(&value, []byte(s.Value), "  ", "  "); err != nil {
+		value.Reset()
+		value.WriteString(s.Value)
+	}
+	return "  " + string(name) + ": " + value.String()
+}
+
+// MergeFile is Merge, or MergeJSON for a file named .json.
+func MergeFile(name string, base, local, remote []byte, labels Labels) (MergeResult, error) {
+	if strings.HasSuffix(name, ".json") {
+		return MergeJSON(base, local, remote, labels)
+	}
+	return Merge(base, local, remote, labels)
+}
@@ -0,0 +1,141 @@
+package tfvars
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+var labels = Labels{Local: "local", Remote: "remote (v5)"}
+
+func TestMerge(t *testing.T) {
+	base := `# web tier
+region        = "us-east-1"
+instance_type = "t3.small"
+replicas      = 2
+old_flag      = true
+tags = {
+  owner = "platform"
+}
+`
+	local := `# web tier
+region        = "us-east-1"
+instance_type = "t3.large" # bigger for the sale
+replicas      = 2
+old_flag      = true
+tags = {
+  owner = "platform"
+}
+local_only = "x"
+`
+	remote := `# web tier
+region = "us-east-1"
+instance_type = "t3.small"
+replicas = 4
+tags = { owner = "platform" }
+zones = [
+  "a",
+  "b",
+]
+`
+	got, err := Merge([]byte(base), []byte(local), []byte(remote), labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# web tier
+region        = "us-east-1"
+instance_type = "t3.large" # bigger for the sale
+replicas = 4
+tags = {
+  owner = "platform"
+}
+local_only = "x"
+zones = [
+  "a",
+  "b",
+]
+`
+	if string(got.Data) != want || len(got.Conflicts) != 0 {
+		t.Errorf("Merge() = %v\n%s\nwant\n%s", got.Conflicts, got.Data, want)
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	base := "replicas = 2\nsize = \"s\"\nzone = \"a\"\n"
+	local := "replicas = 3\nzone = \"b\"\nnew = 1\n"
+	remote := "replicas = 4\nsize = \"m\"\nnew = 2\n"
+	got, err := Merge([]byte(base), []byte(local), []byte(remote), labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<<<<<<< local
+replicas = 3
+=======
+replicas = 4
+>>>>>>> remote (v5)
+<<<<<<< local
+zone = "b"
+=======
+>>>>>>> remote (v5)
+<<<<<<< local
+new = 1
+=======
+new = 2
+>>>>>>> remote (v5)
+<<<<<<< local
+=======
+size = "m"
+>>>>>>> remote (v5)
+`
+	if string(got.Data) != want {
+		t.Errorf("Merge() =\n%s\nwant\n%s", got.Data, want)
+	}
+	if !slices.Equal(got.Conflicts, []string{"replicas", "zone", "new", "size"}) {
+		t.Errorf("conflicts = %v", got.Conflicts)
+	}
+}
+
+func TestMergeSameChange(t *testing.T) {
+	for name, c := range map[string]struct{ base, local, remote, want string }{
+		"both changed the same way":      {"a = 1\n", "a = 2\n", "a  =  2\n", "a = 2\n"},
+		"both removed it":                {"a = 1\nb = 1\n", "b = 1\n", "b = 1\n", "b = 1\n"},
+		"remote removed it":              {"a = 1\nb = 1\n", "a = 1\nb = 2\n", "b = 1\n", "b = 2\n"},
+		"local removed what remote kept": {"a = 1\nb = 1\n", "b = 1\n", "a = 1\nb = 1\n", "b = 1\n"},
+		"everything removed":             {"a = 1\n", "a = 1\n", "", ""},
+	} {
+		got, err := Merge([]byte(c.base), []byte(c.local), []byte(c.remote), labels)
+		if err != nil || string(got.Data) != c.want || len(got.Conflicts) != 0 {
+			t.Errorf("%s: Merge() = %q, %v, %v, want %q", name, got.Data, got.Conflicts, err, c.want)
+		}
+	}
+}
+
+func TestMergeJSON(t *testing.T) {
+	got, err := MergeFile("prod.tfvars.json",
+		[]byte(`{"a": 1, "b": 1, "c": 1}`),
+		[]byte(`{"a": 2, "b": 1, "c": 2}`),
+		[]byte(`{"a": 1, "b": {"x": true}, "c": 3}`), labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "a": 2,
+  "b": {
+    "x": true
+  },
+<<<<<<< local
+  "c": 2
+=======
+  "c": 3
+>>>>>>> remote (v5)
+}
+`
+	if string(got.Data) != want || !slices.Equal(got.Conflicts, []string{"c"}) {
+		t.Errorf("MergeJSON() = %v\n%s\nwant\n%s", got.Conflicts, got.Data, want)
+	}
+
+	clean, err := MergeJSON([]byte(`{"a": 1}`), []byte(`{"a": 1, "b": 2}`), []byte(`{"a": 5}`), labels)
+	if err != nil || !json.Valid(clean.Data) {
+		t.Errorf("MergeJSON() without conflicts = %s, %v, want valid JSON", clean.Data, err)
+	}
+}
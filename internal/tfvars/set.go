@@ -0,0 +1,90 @@
+package tfvars
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Set gives the variables of values new values and keeps the rest of the
+// file as it is. A value written on one line is replaced where it stands, so
+// a comment after it stays, and one spread over several lines is replaced by
+// a single name = value line. Variables the file doesn't set are added at the
+// end, in the order of values.
+func Set(data []byte, values []Assignment) ([]byte, error) {
+	assignments, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	out := lines(data)
+	var appended []string
+	// from the bottom up, so the line numbers of the ones still to do hold
+	values = slices.Clone(values)
+	slices.SortStableFunc(values, func(a, b Assignment) int {
+		x, _ := Lookup(assignments, a.Name)
+		y, _ := Lookup(assignments, b.Name)
+		return y.Line - x.Line
+	})
+	for _, v := range values {
+		old, ok := Lookup(assignments, v.Name)
+		if !ok {
+			appended = append(appended, v.Name+" = "+v.Value)
+			continue
+		}
+		if old.Line == old.EndLine {
+			line := out[old.Line-1]
+			if i := strings.Index(line, "="); i >= 0 {
+				if j := strings.Index(line[i:], old.Value); j >= 0 {
+					out[old.Line-1] = line[:i+j] + v.Value + line[i+j+len(old.Value):]
+					continue
+				}
+			}
+		}
+		indent := out[old.Line-1][:len(out[old.Line-1])-len(strings.TrimLeft(out[old.Line-1], " \t"))]
+		out = slices.Replace(out, old.Line-1, old.EndLine, indent+v.Name+" = "+v.Value)
+	}
+	out = append(out, appended...)
+	return []byte(strings.Join(out, "\n") + "\n"), nil
+}
+
+// SetJSON is Set for .tfvars.json files, the values are JSON. The result is
+// indented and sorted by name like MergeJSON's.
+func SetJSON(data []byte, values []Assignment) ([]byte, error) {
+	assignments, err := ParseJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		if !json.Valid([]byte(v.Value)) {
+			return nil, fmt.Errorf("the value of %s isn't JSON: %s", v.Name, v.Value)
+		}
+		i := slices.IndexFunc(assignments, func(a Assignment) bool { return a.Name == v.Name })
+		if i < 0 {
+			assignments = append(assignments, v)
+			continue
+		}
+		assignments[i].Value = v.Value
+	}
+	slices.SortFunc(assignments, func(a, b Assignment) int { return strings.Compare(a.Name, b.Name) })
+
+	var buf strings.Builder
+	buf.WriteString("{\n")
+	for i, a := range assignments {
+		buf.WriteString(jsonEntry(side{Assignment: a, ok: true}))
+		if i < len(assignments)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+	return []byte(buf.String()), nil
+}
+
+// SetFile is Set, or SetJSON for a file named .json.
+func SetFile(name string, data []byte, values []Assignment) ([]byte, error) {
+	if strings.HasSuffix(name, ".json") {
+		return SetJSON(data, values)
+	}
+	return Set(data, values)
+}
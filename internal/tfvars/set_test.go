@@ -0,0 +1,62 @@
+package tfvars
+
+import "testing"
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		values []Assignment
+		want   string
+	}{
+		{
+			name:   "one line keeps its comment",
+			data:   "# sizes\nsize = \"small\" # for now\nreplicas = 2\n",
+			values: []Assignment{{Name: "size", Value: `"large"`}},
+			want:   "# sizes\nsize = \"large\" # for now\nreplicas = 2\n",
+		},
+		{
+			name:   "several lines become one",
+			data:   "zones = [\n  \"a\",\n  \"b\",\n]\nreplicas = 2\n",
+			values: []Assignment{{Name: "zones", Value: `["c"]`}, {Name: "replicas", Value: "3"}},
+			want:   "zones = [\"c\"]\nreplicas = 3\n",
+		},
+		{
+			name:   "new variables go at the end in order",
+			data:   "replicas = 2\n",
+			values: []Assignment{{Name: "b", Value: "1"}, {Name: "a", Value: "2"}},
+			want:   "replicas = 2\nb = 1\na = 2\n",
+		},
+		{
+			name:   "the name isn't mistaken for the value",
+			data:   "env = \"env\"\n",
+			values: []Assignment{{Name: "env", Value: `"qa"`}},
+			want:   "env = \"qa\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Set([]byte(tt.data), tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Set() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetJSON(t *testing.T) {
+	got, err := SetFile("qa.tfvars.json", []byte(`{"size": "small", "zones": ["a"]}`), []Assignment{{Name: "size", Value: `"large"`}, {Name: "replicas", Value: "3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"replicas\": 3,\n  \"size\": \"large\",\n  \"zones\": [\n    \"a\"\n  ]\n}\n"
+	if string(got) != want {
+		t.Errorf("SetJSON() =\n%s\nwant\n%s", got, want)
+	}
+	if _, err := SetJSON([]byte(`{}`), []Assignment{{Name: "size", Value: "large"}}); err == nil {
+		t.Error("SetJSON() took a value that isn't JSON")
+	}
+}
@@ -0,0 +1,212 @@
+// Package tfvars reads the top-level assignments of a tfvars file without a
+// full HCL parser. It understands what tfvars files hold in practice: one
+// name = value per line, values that carry on over several lines inside
+// brackets or a heredoc, and #, // and /* */ comments. Files named
+// .tfvars.json are read as JSON objects.
+package tfvars
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	assignment   = regexp.MustCompile(`^([A-Za-z_][\w-]*)\s*=\s*(.*)$`)
+	heredocStart = regexp.MustCompile(`^<<-?([A-Za-z_]\w*)$`)
+)
+
+// Assignment is one variable set by the file.
+type Assignment struct {
+	Name string
+	// Value is the expression as it is written, without comments and with
+	// the lines of one spread over several lines trimmed. Heredocs are kept
+	// as they are.
+	Value string
+	// Line and EndLine are the first and last line of the assignment,
+	// counted from 1. They are 0 for JSON.
+	Line, EndLine int
+}
+
+// Parse reads the assignments of a tfvars file in the order they are
+// written. A variable set twice is an error, as it is for terraform.
+func Parse(data []byte) ([]Assignment, error) {
+	lines := strings.Split(string(data), "\n")
+	var (
+		out     []Assignment
+		inBlock bool
+	)
+	for i := 0; i < len(lines); i++ {
+		text, depth := clean(lines[i], &inBlock)
+		if text == "" {
+			continue
+		}
+		m := assignment.FindStringSubmatch(text)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: expected name = value, got %q", i+1, strings.TrimSpace(lines[i]))
+		}
+		if j := slices.IndexFunc(out, func(a Assignment) bool { return a.Name == m[1] }); j >= 0 {
+			return nil, fmt.Errorf("line %d: %s is already set on line %d", i+1, m[1], out[j].Line)
+		}
+		a := Assignment{Name: m[1], Value: m[2], Line: i + 1}
+		if h := heredocStart.FindStringSubmatch(m[2]); h != nil {
+			body := []string{m[2]}
+			for i++; ; i++ {
+				if i == len(lines) {
+					return nil, fmt.Errorf("line %d: the heredoc of %s never ends with %s", a.Line, a.Name, h[1])
+				}
+				body = append(body, lines[i])
+				if strings.TrimSpace(lines[i]) == h[1] {
+					break
+				}
+			}
+			a.Value = strings.Join(body, "\n")
+		} else {
+			value := []string{m[2]}
+			for depth > 0 {
+				if i++; i == len(lines) {
+					return nil, fmt.Errorf("line %d: the value of %s never closes its brackets", a.Line, a.Name)
+				}
+				more, d := clean(lines[i], &inBlock)
+				depth += d
+				if more != "" {
+					value = append(value, more)
+				}
+			}
+			a.Value = strings.Join(value, "\n")
+		}
+		a.EndLine = i + 1
+		out = append(out, a)
+	}
+	if inBlock {
+		return nil, fmt.Errorf("a /* comment never ends")
+	}
+	return out, nil
+}
+
+// clean takes the comments out of a line and trims it, and counts the
+// brackets it opens and doesn't close. inBlock carries a /* comment from one
+// line to the next.
+func clean(line string, inBlock *bool) (string, int) {
+	var out strings.Builder
+	depth, inString := 0, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case *inBlock:
+			if strings.HasPrefix(line[i:], "*/") {
+				*inBlock = false
+				i++
+			}
+			continue
+		case inString:
+			out.WriteByte(c)
+			switch c {
+			case '\\':
+				if i+1 < len(line) {
+					i++
+					out.WriteByte(line[i])
+				}
+			case '"':
+				inString = false
+			}
+			continue
+		case c == '#', strings.HasPrefix(line[i:], "//"):
+			return strings.TrimSpace(out.String()), depth
+		case strings.HasPrefix(line[i:], "/*"):
+			*inBlock = true
+			i++
+			continue
+		case c == '"':
+			inString = true
+		case c == '{', c == '[', c == '(':
+			depth++
+		case c == '}', c == ']', c == ')':
+			depth--
+		}
+		out.WriteByte(c)
+	}
+	return strings.TrimSpace(out.String()), depth
+}
+
+// ParseJSON reads the assignments of a .tfvars.json file, sorted by name.
+// Values are the compacted JSON.
+func ParseJSON(data []byte) ([]Assignment, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("not a JSON object: %w", err)
+	}
+	var out []Assignment
+	for name, raw := range values {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return nil, err
+		}
+		out = append(out, Assignment{Name: name, Value: buf.String()})
+	}
+	slices.SortFunc(out, func(a, b Assignment) int { return strings.Compare(a.Name, b.Name) })
+	return out, nil
+}
+
+// ParseFile is Parse, or ParseJSON for a file named .json.
+func ParseFile(name string, data []byte) ([]Assignment, error) {
+	if strings.HasSuffix(name, ".json") {
+		return ParseJSON(data)
+	}
+	return Parse(data)
+}
+
+// Lookup finds the assignment of a variable.
+func Lookup(assignments []Assignment, name string) (Assignment, bool) {
+	i := slices.IndexFunc(assignments, func(a Assignment) bool { return a.Name == name })
+	if i < 0 {
+		return Assignment{}, false
+	}
+	return assignments[i], true
+}
+
+// Equal is true when two values are the same expression, whatever the
+// spacing outside strings, and whether the items of a list or a map are
+// split by commas or newlines. Heredocs have to match exactly.
+func Equal(a, b string) bool {
+	return canonical(a) == canonical(b)
+}
+
+func canonical(value string) string {
+	if strings.HasPrefix(value, "<<") {
+		return value
+	}
+	var out []byte
+	inString := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(value) {
+				i++
+				out = append(out, value[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		case c == ' ', c == '\t', c == '\r':
+			continue
+		case c == ',', c == '\n':
+			// a separator right after an opening bracket or another one says nothing
+			if len(out) > 0 && !strings.ContainsRune("{[(,", rune(out[len(out)-1])) {
+				out = append(out, ',')
+			}
+			continue
+		case c == '"':
+			inString = true
+		case c == '}', c == ']', c == ')':
+			out = bytes.TrimSuffix(out, []byte(","))
+		}
+		out = append(out, c)
+	}
+	return string(bytes.TrimSuffix(out, []byte(",")))
+}
@@ -0,0 +1,100 @@
+package tfvars
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := `# the web tier
+region        = "us-east-1" // where it runs
+instance_type = "t3.large"
+url           = "https://example.com/#anchor"
+/* replicas = 1
+   was too few */
+replicas = 3
+tags = {
+  owner = "platform" # the team
+  cost  = "web"
+}
+subnets = ["a", "b",
+  "c"]
+user_data = <<-EOT
+  #!/bin/bash
+  echo "hi" # not a comment
+EOT
+`
+	got, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Assignment{
+		{Name: "region", Value: `"us-east-1"`, Line: 2, EndLine: 2},
+		{Name: "instance_type", Value: `"t3.large"`, Line: 3, EndLine: 3},
+		{Name: "url", Value: `"https://example.com/#anchor"`, Line: 4, EndLine: 4},
+		{Name: "replicas", Value: "3", Line: 7, EndLine: 7},
+		{Name: "tags", Value: "{\nowner = \"platform\"\ncost  = \"web\"\n}", Line: 8, EndLine: 11},
+		{Name: "subnets", Value: "[\"a\", \"b\",\n\"c\"]", Line: 12, EndLine: 13},
+		{Name: "user_data", Value: "<<-EOT\n  #!/bin/bash\n  echo \"hi\" # not a comment\nEOT", Line: 14, EndLine: 17},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for data, want := range map[string]string{
+		"a = 1\na = 2\n":         "line 2: a is already set on line 1",
+		"tags = {\n  a = 1\n":    "line 1: the value of tags never closes its brackets",
+		"x = <<EOT\nhello\n":     "line 1: the heredoc of x never ends with EOT",
+		"resource \"a\" \"b\"\n": "line 1: expected name = value",
+		"a = 1 /* open\n":        "a /* comment never ends",
+	} {
+		if _, err := Parse([]byte(data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) = %v, want %q", data, err, want)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	got, err := ParseFile("prod.tfvars.json", []byte(`{"replicas": 3, "tags": {"owner": "platform"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Assignment{{Name: "replicas", Value: "3"}, {Name: "tags", Value: `{"owner":"platform"}`}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseFile() = %+v, want %+v", got, want)
+	}
+	if _, err := ParseJSON([]byte(`[1]`)); err == nil {
+		t.Error("ParseJSON() of an array gave no error")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{`"t3.large"`, `"t3.large"`, true},
+		{`["a","b"]`, "[\n\"a\",\n\"b\",\n]", true},
+		{"{\nowner = \"x\"\ncost = \"y\"\n}", `{ owner = "x", cost = "y" }`, true},
+		{`"a b"`, `"ab"`, false},
+		{`3`, `4`, false},
+		{"<<EOT\na\nEOT", "<<EOT\n a\nEOT", false},
+	} {
+		if got := Equal(c.a, c.b); got != c.want {
+			t.Errorf("Equal(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	assignments := []Assignment{{Name: "a", Value: "1"}}
+	if a, ok := Lookup(assignments, "a"); !ok || a.Value != "1" {
+		t.Errorf("Lookup(a) = %+v, %v", a, ok)
+	}
+	if _, ok := Lookup(assignments, "b"); ok {
+		t.Error("Lookup(b) found a variable that isn't set")
+	}
+}
@@ -0,0 +1,137 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CheckovSeverities are checkov's severities from least to most severe.
+var CheckovSeverities = []string{"LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// CheckovFinding is one failed check.
+type CheckovFinding struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Severity string `json:"severity,omitempty"`
+	Resource string `json:"resource"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	// Guideline links to the docs for the check.
+	Guideline string `json:"guideline,omitempty"`
+}
+
+// CheckovResult is what checkov found in a plan. Checks skipped through
+// .checkov.yaml or inline suppressions are only counted.
+type CheckovResult struct {
+	Failed  []CheckovFinding `json:"failed"`
+	Passed  int              `json:"passed"`
+	Skipped int              `json:"skipped"`
+}
+
+// FailsAt returns the failed checks at or above threshold. Checks without a
+// severity, which is what checkov reports without a platform API key, always
+// count.
+func (r CheckovResult) FailsAt(threshold string) []CheckovFinding {
+	lowest := slices.Index(CheckovSeverities, strings.ToUpper(threshold))
+	var out []CheckovFinding
+	for _, f := range r.Failed {
+		if slices.Index(CheckovSeverities, f.Severity) >= lowest || f.Severity == "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// ValidCheckovSeverity reports whether s is one of CheckovSeverities, in any case.
+func ValidCheckovSeverity(s string) bool {
+	return slices.Contains(CheckovSeverities, strings.ToUpper(s))
+}
+
+// CheckovArgs builds the arguments for scanning a plan exported with
+// terraform show -json. configFile is passed on when set so skips in it are
+// respected.
+func CheckovArgs(planJSON, configFile string) []string {
+	args := []string{"-f", planJSON, "-o", "json", "--framework", "terraform_plan"}
+	if configFile != "" {
+		args = append(args, "--config-file", configFile)
+	}
+	return args
+}
+
+// Checkov scans the plan JSON at planJSON, running in dir. binary is
+// "checkov" when empty. Failed checks are not an error, they are reported in
+// the result.
+func Checkov(ctx context.Context, r Runner, binary, dir, planJSON, configFile string) (CheckovResult, error) {
+	if binary == "" {
+		binary = "checkov"
+	}
+	out, err := r.Output(ctx, Command{Binary: binary, Args: CheckovArgs(planJSON, configFile), Dir: dir})
+	// checkov exits 1 when a check fails
+	var toolErr *Error
+	if err != nil && !(errors.As(err, &toolErr) && toolErr.ExitCode == 1 && len(out) > 0) {
+		return CheckovResult{}, err
+	}
+	return ParseCheckov(out)
+}
+
+type checkovReport struct {
+	Results struct {
+		FailedChecks []struct {
+			CheckID       string `json:"check_id"`
+			CheckName     string `json:"check_name"`
+			Severity      string `json:"severity"`
+			Resource      string `json:"resource"`
+			FilePath      string `json:"file_path"`
+			FileLineRange []int  `json:"file_line_range"`
+			Guideline     string `json:"guideline"`
+		} `json:"failed_checks"`
+	} `json:"results"`
+	Summary struct {
+		Passed  int `json:"passed"`
+		Skipped int `json:"skipped"`
+	} `json:"summary"`
+}
+
+// ParseCheckov reads the output of checkov -o json, which is one report or a
+// list of them when more than one framework ran.
+func ParseCheckov(data []byte) (CheckovResult, error) {
+	var reports []checkovReport
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &reports); err != nil {
+			return CheckovResult{}, fmt.Errorf("failed to parse checkov output: %w", err)
+		}
+	} else {
+		var r checkovReport
+		if err := json.Unmarshal(data, &r); err != nil {
+			return CheckovResult{}, fmt.Errorf("failed to parse checkov output: %w", err)
+		}
+		reports = append(reports, r)
+	}
+
+	var res CheckovResult
+	for _, r := range reports {
+		res.Passed += r.Summary.Passed
+		res.Skipped += r.Summary.Skipped
+		for _, c := range r.Results.FailedChecks {
+			f := CheckovFinding{
+				ID:        c.CheckID,
+				Name:      c.CheckName,
+				Severity:  strings.ToUpper(c.Severity),
+				Resource:  c.Resource,
+				File:      c.FilePath,
+				Guideline: c.Guideline,
+			}
+			if len(c.FileLineRange) > 0 {
+				f.Line = c.FileLineRange[0]
+			}
+			res.Failed = append(res.Failed, f)
+		}
+	}
+	return res, nil
+}
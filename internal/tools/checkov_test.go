@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+const checkovSample = `{
+  "check_type": "terraform_plan",
+  "results": {
+    "failed_checks": [
+      {"check_id": "CKV_AWS_20", "check_name": "S3 Bucket has an ACL defined which allows public READ access.", "severity": "HIGH",
+       "resource": "aws_s3_bucket.logs", "file_path": "/plan.json", "file_line_range": [0, 0], "guideline": "https://docs.example/ckv-aws-20"},
+      {"check_id": "CKV_AWS_18", "check_name": "Ensure the S3 bucket has access logging enabled", "severity": "low",
+       "resource": "aws_s3_bucket.logs", "file_path": "/plan.json", "file_line_range": [0, 0]},
+      {"check_id": "CKV2_AWS_6", "check_name": "Ensure that S3 bucket has a Public Access block", "severity": null,
+       "resource": "aws_s3_bucket.logs", "file_path": "/plan.json", "file_line_range": [0, 0]}
+    ]
+  },
+  "summary": {"passed": 7, "failed": 3, "skipped": 1}
+}`
+
+func TestParseCheckov(t *testing.T) {
+	res, err := ParseCheckov([]byte(checkovSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Failed) != 3 || res.Passed != 7 || res.Skipped != 1 {
+		t.Fatalf("ParseCheckov() = %+v", res)
+	}
+	if res.Failed[1].Severity != "LOW" {
+		t.Errorf("severity = %q, want it upper-cased", res.Failed[1].Severity)
+	}
+
+	var ids []string
+	for _, f := range res.FailsAt("high") {
+		ids = append(ids, f.ID)
+	}
+	if want := []string{"CKV_AWS_20", "CKV2_AWS_6"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("FailsAt(high) = %q, want %q", ids, want)
+	}
+	if got := len(res.FailsAt("LOW")); got != 3 {
+		t.Errorf("FailsAt(LOW) = %d findings, want 3", got)
+	}
+
+	list, err := ParseCheckov([]byte("[" + checkovSample + "," + checkovSample + "]"))
+	if err != nil || len(list.Failed) != 6 || list.Skipped != 2 {
+		t.Errorf("list output gave %+v, %v", list, err)
+	}
+}
+
+func TestCheckovArgs(t *testing.T) {
+	r := &RecordingRunner{Result: func(Command) ([]byte, error) {
+		return []byte(checkovSample), &Error{Tool: "checkov", ExitCode: 1}
+	}}
+	res, err := Checkov(context.Background(), r, "", "infra", "/tmp/plan.json", ".checkov.yaml")
+	if err != nil || len(res.Failed) != 3 {
+		t.Fatalf("Checkov() = %+v, %v", res, err)
+	}
+	want := Command{Binary: "checkov", Dir: "infra", Args: []string{"-f", "/tmp/plan.json", "-o", "json", "--framework", "terraform_plan", "--config-file", ".checkov.yaml"}}
+	if !reflect.DeepEqual(r.Calls[0], want) {
+		t.Errorf("call = %+v, want %+v", r.Calls[0], want)
+	}
+	if !ValidCheckovSeverity("medium") || ValidCheckovSeverity("SEVERE") {
+		t.Error("ValidCheckovSeverity is wrong")
+	}
+}
@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PolicyViolation is one deny or warn result from a rego policy.
+type PolicyViolation struct {
+	Namespace string `json:"namespace"`
+	Message   string `json:"message"`
+}
+
+// PolicyResult is what the policies had to say about a plan.
+type PolicyResult struct {
+	Failures []PolicyViolation `json:"failures"`
+	Warnings []PolicyViolation `json:"warnings"`
+}
+
+// Denied reports whether any policy denied the plan.
+func (r PolicyResult) Denied() bool {
+	return len(r.Failures) > 0
+}
+
+// ConftestArgs builds the arguments for testing a plan exported with
+// terraform show -json against the policies in policyDir.
+func ConftestArgs(policyDir, planJSON string) []string {
+	return []string{"test", "--policy", policyDir, "--all-namespaces", "--output", "json", "--no-color", planJSON}
+}
+
+// Conftest evaluates the policies in policyDir against the plan JSON at
+// planJSON. binary is "conftest" when empty. Denied plans are not an error,
+// they are reported in the result.
+func Conftest(ctx context.Context, r Runner, binary, policyDir, planJSON string) (PolicyResult, error) {
+	if binary == "" {
+		binary = "conftest"
+	}
+	out, err := r.Output(ctx, Command{Binary: binary, Args: ConftestArgs(policyDir, planJSON)})
+	// conftest exits 1 when a policy fails, anything else is conftest itself failing
+	var toolErr *Error
+	if err != nil && !(errors.As(err, &toolErr) && toolErr.ExitCode == 1 && len(out) > 0) {
+		return PolicyResult{}, err
+	}
+	return ParseConftest(out)
+}
+
+// conftestResult is one entry of conftest's JSON output, one per file and namespace
+type conftestResult struct {
+	Filename  string `json:"filename"`
+	Namespace string `json:"namespace"`
+	Warnings  []struct {
+		Msg string `json:"msg"`
+	} `json:"warnings"`
+	Failures []struct {
+		Msg string `json:"msg"`
+	} `json:"failures"`
+}
+
+// ParseConftest reads the output of conftest test --output json.
+func ParseConftest(data []byte) (PolicyResult, error) {
+	var results []conftestResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return PolicyResult{}, fmt.Errorf("failed to parse conftest output: %w", err)
+	}
+	var res PolicyResult
+	for _, r := range results {
+		for _, f := range r.Failures {
+			res.Failures = append(res.Failures, PolicyViolation{Namespace: r.Namespace, Message: f.Msg})
+		}
+		for _, w := range r.Warnings {
+			res.Warnings = append(res.Warnings, PolicyViolation{Namespace: r.Namespace, Message: w.Msg})
+		}
+	}
+	return res, nil
+}
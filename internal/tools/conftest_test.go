@@ -0,0 +1,51 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const conftestOutput = `[
+  {"filename": "/tmp/plan.json", "namespace": "s3", "successes": 2,
+   "failures": [{"msg": "aws_s3_bucket.logs must not be public"}],
+   "warnings": [{"msg": "aws_s3_bucket.logs has no owner tag"}]},
+  {"filename": "/tmp/plan.json", "namespace": "tags", "successes": 1}
+]`
+
+func TestParseConftest(t *testing.T) {
+	res, err := ParseConftest([]byte(conftestOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PolicyResult{
+		Failures: []PolicyViolation{{Namespace: "s3", Message: "aws_s3_bucket.logs must not be public"}},
+		Warnings: []PolicyViolation{{Namespace: "s3", Message: "aws_s3_bucket.logs has no owner tag"}},
+	}
+	if !reflect.DeepEqual(res, want) || !res.Denied() {
+		t.Errorf("ParseConftest() = %+v, want %+v", res, want)
+	}
+}
+
+func TestConftestExitCodes(t *testing.T) {
+	r := &RecordingRunner{Result: func(Command) ([]byte, error) {
+		return []byte(conftestOutput), &Error{Tool: "conftest", ExitCode: 1}
+	}}
+	res, err := Conftest(context.Background(), r, "", "policy", "/tmp/plan.json")
+	if err != nil || !res.Denied() {
+		t.Errorf("exit 1 with output gave %+v, %v, want a denied result", res, err)
+	}
+	want := []string{"test", "--policy", "policy", "--all-namespaces", "--output", "json", "--no-color", "/tmp/plan.json"}
+	if r.Calls[0].Binary != "conftest" || !reflect.DeepEqual(r.Calls[0].Args, want) {
+		t.Errorf("call = %+v", r.Calls[0])
+	}
+
+	r.Result = func(Command) ([]byte, error) {
+		return nil, &Error{Tool: "conftest", ExitCode: 1, Stderr: "load policies: no policies found"}
+	}
+	var toolErr *Error
+	if _, err := Conftest(context.Background(), r, "", "policy", "/tmp/plan.json"); !errors.As(err, &toolErr) {
+		t.Errorf("exit 1 without output gave %v, want the tool error", err)
+	}
+}
@@ -0,0 +1,32 @@
+package tools
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+)
+
+// DotFormat gives the graphviz output format for an image file, and false
+// when the extension is not one dot is used for here.
+func DotFormat(file string) (string, bool) {
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".svg", ".png":
+		return ext[1:], true
+	}
+	return "", false
+}
+
+// DotArgs builds the arguments for rendering the graph in input to output.
+func DotArgs(format, input, output string) []string {
+	return []string{"-T" + format, "-o", output, input}
+}
+
+// Dot renders the DOT file input into output with graphviz. binary is "dot"
+// when empty.
+func Dot(ctx context.Context, r Runner, binary, format, input, output string) error {
+	if binary == "" {
+		binary = "dot"
+	}
+	_, err := r.Output(ctx, Command{Binary: binary, Args: DotArgs(format, input, output)})
+	return err
+}
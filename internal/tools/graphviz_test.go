@@ -0,0 +1,26 @@
+package tools
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDotFormat(t *testing.T) {
+	for file, want := range map[string]string{"graph.svg": "svg", "out/Graph.PNG": "png", "graph.dot": "", "graph": ""} {
+		if got, ok := DotFormat(file); got != want || ok != (want != "") {
+			t.Errorf("DotFormat(%q) = %q, %v", file, got, ok)
+		}
+	}
+}
+
+func TestDot(t *testing.T) {
+	r := &RecordingRunner{}
+	if err := Dot(context.Background(), r, "", "svg", "/tmp/g.dot", "graph.svg"); err != nil {
+		t.Fatal(err)
+	}
+	want := Command{Binary: "dot", Args: []string{"-Tsvg", "-o", "graph.svg", "/tmp/g.dot"}}
+	if !reflect.DeepEqual(r.Calls, []Command{want}) {
+		t.Errorf("calls = %+v, want %+v", r.Calls, want)
+	}
+}
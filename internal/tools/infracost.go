@@ -0,0 +1,106 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// InfracostAPIKeyEnv is where infracost looks for its API key.
+const InfracostAPIKeyEnv = "INFRACOST_API_KEY"
+
+// InfracostAPIKey is the key from the environment, read once. It must never
+// be printed.
+var InfracostAPIKey = sync.OnceValue(func() string {
+	return os.Getenv(InfracostAPIKeyEnv)
+})
+
+// CostEstimate is the monthly cost before and after a plan, in Currency.
+type CostEstimate struct {
+	Currency string  `json:"currency"`
+	Past     float64 `json:"past_monthly"`
+	Total    float64 `json:"total_monthly"`
+	Diff     float64 `json:"diff_monthly"`
+}
+
+func (e CostEstimate) String() string {
+	return fmt.Sprintf("%+.2f %s/month (%.2f -> %.2f)", e.Diff, e.Currency, e.Past, e.Total)
+}
+
+// InfracostArgs builds the arguments for pricing a plan exported with
+// terraform show -json.
+func InfracostArgs(planJSON string) []string {
+	return []string{"breakdown", "--path", planJSON, "--format", "json", "--no-color"}
+}
+
+// Infracost prices the plan JSON at planJSON. binary is "infracost" when empty.
+func Infracost(ctx context.Context, r Runner, binary, planJSON string) (CostEstimate, error) {
+	if binary == "" {
+		binary = "infracost"
+	}
+	out, err := r.Output(ctx, Command{Binary: binary, Args: InfracostArgs(planJSON)})
+	if err != nil {
+		return CostEstimate{}, redactKey(err)
+	}
+	return ParseInfracost(out)
+}
+
+// the key could turn up in an error message from infracost, it goes no further than here
+func redactKey(err error) error {
+	key := InfracostAPIKey()
+	if key == "" || !strings.Contains(err.Error(), key) {
+		return err
+	}
+	return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), key, "****"))
+}
+
+// infracostBreakdown is the part of infracost's JSON output the estimate needs,
+// the totals are strings and can be null
+type infracostBreakdown struct {
+	Currency             string  `json:"currency"`
+	TotalMonthlyCost     *string `json:"totalMonthlyCost"`
+	PastTotalMonthlyCost *string `json:"pastTotalMonthlyCost"`
+	DiffTotalMonthlyCost *string `json:"diffTotalMonthlyCost"`
+}
+
+// ParseInfracost reads the output of infracost breakdown --format json.
+func ParseInfracost(data []byte) (CostEstimate, error) {
+	var b infracostBreakdown
+	if err := json.Unmarshal(data, &b); err != nil {
+		return CostEstimate{}, fmt.Errorf("failed to parse infracost output: %w", err)
+	}
+	e := CostEstimate{Currency: b.Currency}
+	var err error
+	if e.Total, err = parseCost(b.TotalMonthlyCost); err != nil {
+		return CostEstimate{}, err
+	}
+	if e.Past, err = parseCost(b.PastTotalMonthlyCost); err != nil {
+		return CostEstimate{}, err
+	}
+	if b.DiffTotalMonthlyCost != nil {
+		if e.Diff, err = parseCost(b.DiffTotalMonthlyCost); err != nil {
+			return CostEstimate{}, err
+		}
+	} else {
+		e.Diff = e.Total - e.Past
+	}
+	if e.Currency == "" {
+		e.Currency = "USD"
+	}
+	return e, nil
+}
+
+func parseCost(s *string) (float64, error) {
+	if s == nil || *s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(*s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse infracost output: cost %q is not a number", *s)
+	}
+	return v, nil
+}
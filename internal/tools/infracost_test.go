@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInfracost(t *testing.T) {
+	e, err := ParseInfracost([]byte(`{"currency":"USD","totalMonthlyCost":"123.45","pastTotalMonthlyCost":"100","diffTotalMonthlyCost":"23.45","projects":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CostEstimate{Currency: "USD", Past: 100, Total: 123.45, Diff: 23.45}
+	if e != want {
+		t.Errorf("ParseInfracost() = %+v, want %+v", e, want)
+	}
+	if got := e.String(); got != "+23.45 USD/month (100.00 -> 123.45)" {
+		t.Errorf("String() = %q", got)
+	}
+
+	e, err = ParseInfracost([]byte(`{"currency":"EUR","totalMonthlyCost":"40","pastTotalMonthlyCost":null,"diffTotalMonthlyCost":null}`))
+	if err != nil || e.Diff != 40 || e.Past != 0 {
+		t.Errorf("null totals gave %+v, %v", e, err)
+	}
+
+	if _, err := ParseInfracost([]byte(`{"totalMonthlyCost":"lots"}`)); err == nil {
+		t.Error("expected an error for a non-numeric cost")
+	}
+}
+
+func TestInfracostRedactsAPIKey(t *testing.T) {
+	t.Setenv(InfracostAPIKeyEnv, "ico-secret-key")
+	old := InfracostAPIKey
+	InfracostAPIKey = func() string { return "ico-secret-key" }
+	t.Cleanup(func() { InfracostAPIKey = old })
+
+	r := &RecordingRunner{Result: func(Command) ([]byte, error) {
+		return nil, &Error{Tool: "infracost", ExitCode: 1, Stderr: "invalid API key ico-secret-key"}
+	}}
+	_, err := Infracost(context.Background(), r, "", "/tmp/plan.json")
+	if err == nil || strings.Contains(err.Error(), "ico-secret-key") {
+		t.Errorf("err = %v, want the key redacted", err)
+	}
+	want := Command{Binary: "infracost", Args: []string{"breakdown", "--path", "/tmp/plan.json", "--format", "json", "--no-color"}}
+	if len(r.Calls) != 1 || !reflect.DeepEqual(r.Calls[0], want) {
+		t.Errorf("calls = %+v", r.Calls)
+	}
+
+	r.Result = func(Command) ([]byte, error) { return nil, ErrNotInstalled }
+	if _, err := Infracost(context.Background(), r, "", "/tmp/plan.json"); !errors.Is(err, ErrNotInstalled) {
+		t.Errorf("err = %v, want ErrNotInstalled", err)
+	}
+}
@@ -0,0 +1,103 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Lint severities, in the order they are reported.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNotice  = "notice"
+)
+
+// LintIssue is one finding from tflint.
+type LintIssue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+// TFLintArgs builds the arguments for linting the working directory.
+func TFLintArgs() []string {
+	return []string{"--format", "json", "--no-color"}
+}
+
+// TFLint lints the module in dir, the current directory when empty. binary is
+// "tflint" when empty. Issues are not an error, tflint failing to run is.
+func TFLint(ctx context.Context, r Runner, binary, dir string) ([]LintIssue, error) {
+	if binary == "" {
+		binary = "tflint"
+	}
+	// tflint exits non-zero when it finds issues, so the output decides
+	out, err := r.Output(ctx, Command{Binary: binary, Args: TFLintArgs(), Dir: dir})
+	if len(out) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return ParseTFLint(out)
+}
+
+// tflintOutput is the JSON tflint prints with --format json
+type tflintOutput struct {
+	Issues []struct {
+		Rule struct {
+			Name     string `json:"name"`
+			Severity string `json:"severity"`
+		} `json:"rule"`
+		Message string `json:"message"`
+		Range   struct {
+			Filename string `json:"filename"`
+			Start    struct {
+				Line int `json:"line"`
+			} `json:"start"`
+		} `json:"range"`
+	} `json:"issues"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// ParseTFLint reads the output of tflint --format json. Errors tflint
+// reports about itself, such as a broken config, come back as an error.
+func ParseTFLint(data []byte) ([]LintIssue, error) {
+	var o tflintOutput
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("failed to parse tflint output: %w", err)
+	}
+	if len(o.Errors) > 0 {
+		msgs := make([]string, len(o.Errors))
+		for i, e := range o.Errors {
+			msgs[i] = e.Message
+		}
+		return nil, fmt.Errorf("tflint failed: %s", strings.Join(msgs, "; "))
+	}
+	issues := make([]LintIssue, 0, len(o.Issues))
+	for _, i := range o.Issues {
+		issues = append(issues, LintIssue{
+			Rule:     i.Rule.Name,
+			Severity: strings.ToLower(i.Rule.Severity),
+			Message:  i.Message,
+			File:     i.Range.Filename,
+			Line:     i.Range.Start.Line,
+		})
+	}
+	return issues, nil
+}
+
+// GroupBySeverity splits issues into errors, warnings and notices, keeping
+// their order within each group.
+func GroupBySeverity(issues []LintIssue) map[string][]LintIssue {
+	groups := map[string][]LintIssue{}
+	for _, i := range issues {
+		groups[i.Severity] = append(groups[i.Severity], i)
+	}
+	return groups
+}
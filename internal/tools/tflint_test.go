@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const tflintSample = `{
+  "issues": [
+    {"rule": {"name": "terraform_unused_declarations", "severity": "warning"}, "message": "variable \"old\" is declared but not used",
+     "range": {"filename": "variables.tf", "start": {"line": 12, "column": 1}}},
+    {"rule": {"name": "aws_instance_invalid_type", "severity": "error"}, "message": "\"t2.mega\" is an invalid value as instance_type",
+     "range": {"filename": "main.tf", "start": {"line": 3, "column": 19}}}
+  ],
+  "errors": []
+}`
+
+func TestParseTFLint(t *testing.T) {
+	issues, err := ParseTFLint([]byte(tflintSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := GroupBySeverity(issues)
+	want := LintIssue{Rule: "aws_instance_invalid_type", Severity: SeverityError, Message: `"t2.mega" is an invalid value as instance_type`, File: "main.tf", Line: 3}
+	if len(groups[SeverityError]) != 1 || !reflect.DeepEqual(groups[SeverityError][0], want) {
+		t.Errorf("errors = %+v", groups[SeverityError])
+	}
+	if len(groups[SeverityWarning]) != 1 || groups[SeverityWarning][0].Line != 12 {
+		t.Errorf("warnings = %+v", groups[SeverityWarning])
+	}
+
+	if _, err := ParseTFLint([]byte(`{"issues": [], "errors": [{"message": "Failed to load configurations", "severity": "error"}]}`)); err == nil {
+		t.Error("expected tflint's own errors to be returned")
+	}
+}
+
+func TestTFLintUsesOutputOverExitCode(t *testing.T) {
+	r := &RecordingRunner{Result: func(Command) ([]byte, error) {
+		return []byte(tflintSample), &Error{Tool: "tflint", ExitCode: 2}
+	}}
+	issues, err := TFLint(context.Background(), r, "", "infra")
+	if err != nil || len(issues) != 2 {
+		t.Errorf("TFLint() = %+v, %v", issues, err)
+	}
+	if c := r.Calls[0]; c.Binary != "tflint" || c.Dir != "infra" {
+		t.Errorf("call = %+v", c)
+	}
+
+	r.Result = func(Command) ([]byte, error) { return nil, ErrNotInstalled }
+	if _, err := TFLint(context.Background(), r, "/opt/tflint", ""); !errors.Is(err, ErrNotInstalled) {
+		t.Errorf("err = %v, want ErrNotInstalled", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Package tools runs the optional helper programs the tool can hand a plan
+// or a module to, such as infracost. None of them are needed unless the
+// feature using them was switched on, so a missing binary is reported as
+// ErrNotInstalled rather than a generic exec error.
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// ErrNotInstalled is returned when the tool's binary can't be found.
+var ErrNotInstalled = errors.New("not installed")
+
+// Command is one run of a tool.
+type Command struct {
+	// Binary is the executable, looked up in the PATH unless it is a path.
+	Binary string
+	Args   []string
+	// Dir is the working directory, empty means the current one.
+	Dir string
+	// Env is added to the environment of the process.
+	Env []string
+}
+
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Binary}, c.Args...), " ")
+}
+
+// Error is returned when a tool ran but did not exit successfully. Several
+// tools exit non-zero when they have findings, so the output is returned
+// alongside it.
+type Error struct {
+	Tool     string
+	ExitCode int
+	// Stderr is what the tool printed to stderr, trimmed.
+	Stderr string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s exited with code %d", e.Tool, e.ExitCode)
+	}
+	return fmt.Sprintf("%s exited with code %d: %s", e.Tool, e.ExitCode, e.Stderr)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Runner runs a tool and returns what it printed to stdout.
+type Runner interface {
+	Output(ctx context.Context, c Command) ([]byte, error)
+}
+
+// ExecRunner runs tools installed on this machine.
+type ExecRunner struct{}
+
+func (ExecRunner) Output(ctx context.Context, c Command) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, c.Binary, c.Args...)
+	cmd.Dir = c.Dir
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s was not found, install it or point the config at it", ErrNotInstalled, c.Binary)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.Bytes(), &Error{Tool: c.Binary, ExitCode: exitErr.ExitCode(), Stderr: strings.TrimSpace(stderr.String()), Err: err}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s: %w", c.Binary, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// RecordingRunner is a Runner for tests. It records every call and never
+// runs anything.
+type RecordingRunner struct {
+	mu    sync.Mutex
+	Calls []Command
+
+	// Result, when set, decides what each call returns.
+	Result func(c Command) ([]byte, error)
+}
+
+func (r *RecordingRunner) Output(ctx context.Context, c Command) ([]byte, error) {
+	r.mu.Lock()
+	r.Calls = append(r.Calls, c)
+	r.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r.Result != nil {
+		return r.Result(c)
+	}
+	return nil, nil
+}
@@ -0,0 +1,25 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExecRunnerMissingBinary(t *testing.T) {
+	_, err := ExecRunner{}.Output(context.Background(), Command{Binary: "tfmanage-no-such-tool"})
+	if !errors.Is(err, ErrNotInstalled) {
+		t.Errorf("err = %v, want ErrNotInstalled", err)
+	}
+}
+
+func TestExecRunnerExitCode(t *testing.T) {
+	out, err := ExecRunner{}.Output(context.Background(), Command{Binary: "sh", Args: []string{"-c", "echo findings; echo bad >&2; exit 3"}})
+	var toolErr *Error
+	if !errors.As(err, &toolErr) || toolErr.ExitCode != 3 || toolErr.Stderr != "bad" {
+		t.Fatalf("err = %v, want a tool error with exit code 3", err)
+	}
+	if string(out) != "findings\n" {
+		t.Errorf("stdout = %q, want it returned with the error", out)
+	}
+}
@@ -0,0 +1,31 @@
+package tracing
+
+import (
+	"context"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// AWSMiddleware wraps every AWS API call in a client span named after the
+// service and operation, such as S3.PutObject, with the region and request
+// ID as attributes. It goes in the APIOptions of the AWS config and costs a
+// context lookup per call when there is no Tracer.
+func AWSMiddleware(stack *middleware.Stack) error {
+	// after the service metadata is registered, which happens in the initialize step too
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TFManageTracing", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+		ctx, span := StartKind(ctx, service+"."+operation, KindClient,
+			String("rpc.system", "aws-api"),
+			String("rpc.service", service),
+			String("rpc.method", operation),
+			String("cloud.region", awsmiddleware.GetRegion(ctx)),
+		)
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		if id, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+			span.SetAttributes(String("aws.request_id", id))
+		}
+		span.End(err)
+		return out, metadata, err
+	}), middleware.After)
+}
@@ -0,0 +1,331 @@
+// Package tracing records OpenTelemetry spans of a run and sends them to a
+// collector over OTLP/HTTP with JSON bodies, so a run inside a CI pipeline
+// shows up in the pipeline's trace. It is set up from the standard OTEL_*
+// variables and TRACEPARENT. Without an endpoint there is no Tracer at all:
+// Start finds none in the context and hands back a nil *Span, whose methods
+// do nothing.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The variables FromEnv reads.
+const (
+	EndpointEnv       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	TracesEndpointEnv = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	HeadersEnv        = "OTEL_EXPORTER_OTLP_HEADERS"
+	ServiceNameEnv    = "OTEL_SERVICE_NAME"
+	DisabledEnv       = "OTEL_SDK_DISABLED"
+	TraceparentEnv    = "TRACEPARENT"
+)
+
+// Client is what Flush sends with, a variable so tests can swap it.
+var Client = &http.Client{Timeout: 10 * time.Second}
+
+// Tracer collects the spans of a run until Flush sends them.
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	version  string
+	// parent is the span TRACEPARENT named, the root span's parent
+	parent spanContext
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+func (c spanContext) valid() bool {
+	return c.traceID != [16]byte{} && c.spanID != [8]byte{}
+}
+
+// FromEnv returns a Tracer when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, and nil otherwise or when
+// OTEL_SDK_DISABLED is true. version is reported as service.version.
+func FromEnv(version string) *Tracer {
+	if disabled, _ := strconv.ParseBool(os.Getenv(DisabledEnv)); disabled {
+		return nil
+	}
+	// the traces endpoint is used as it is, the general one gets the path added
+	endpoint := os.Getenv(TracesEndpointEnv)
+	if endpoint == "" {
+		if base := os.Getenv(EndpointEnv); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil
+	}
+	t := &Tracer{endpoint: endpoint, headers: parseHeaders(os.Getenv(HeadersEnv)), service: os.Getenv(ServiceNameEnv), version: version}
+	if t.service == "" {
+		t.service = "tfmanage"
+	}
+	t.parent, _ = parseTraceparent(os.Getenv(TraceparentEnv))
+	return t
+}
+
+// parseHeaders reads the key=value,key=value list of OTEL_EXPORTER_OTLP_HEADERS,
+// values are URL encoded
+func parseHeaders(s string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			v = unescaped
+		}
+		headers[strings.TrimSpace(k)] = v
+	}
+	return headers
+}
+
+// parseTraceparent reads a W3C traceparent header, version 00.
+func parseTraceparent(s string) (spanContext, error) {
+	var c spanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return spanContext{}, errors.New("not a traceparent")
+	}
+	if _, err := hex.Decode(c.traceID[:], []byte(parts[1])); err != nil {
+		return spanContext{}, errors.New("not a traceparent")
+	}
+	if _, err := hex.Decode(c.spanID[:], []byte(parts[2])); err != nil {
+		return spanContext{}, errors.New("not a traceparent")
+	}
+	if !c.valid() {
+		return spanContext{}, errors.New("not a traceparent")
+	}
+	return c, nil
+}
+
+type tracerKey struct{}
+type spanKey struct{}
+
+// WithTracer returns a context that Start records spans of t in.
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// Kinds of span.
+const (
+	KindInternal = 1
+	KindClient   = 3
+)
+
+// Attr is one span attribute.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String is a string attribute.
+func String(key, value string) Attr { return Attr{key, value} }
+
+// Int is an integer attribute.
+func Int(key string, value int64) Attr { return Attr{key, value} }
+
+// Bool is a boolean attribute.
+func Bool(key string, value bool) Attr { return Attr{key, value} }
+
+// Span is one timed operation. A nil *Span is valid and does nothing.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	kind     int
+	ctx      spanContext
+	parentID [8]byte
+	start    time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []Attr
+	err   string
+	ended bool
+}
+
+// Start starts a span named name as a child of the span in ctx, or of
+// TRACEPARENT when there is none. It returns ctx and a nil span when ctx has
+// no Tracer.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal, attrs...)
+}
+
+// StartKind is Start for a span of the given kind.
+func StartKind(ctx context.Context, name string, kind int, attrs ...Attr) (context.Context, *Span) {
+	t, _ := ctx.Value(tracerKey{}).(*Tracer)
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: attrs}
+	if parent, _ := ctx.Value(spanKey{}).(*Span); parent != nil {
+		s.ctx.traceID, s.parentID = parent.ctx.traceID, parent.ctx.spanID
+	} else if t.parent.valid() {
+		s.ctx.traceID, s.parentID = t.parent.traceID, t.parent.spanID
+	} else {
+		rand.Read(s.ctx.traceID[:])
+	}
+	rand.Read(s.ctx.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// End ends the span, marking it failed when err isn't nil. Only the first
+// End counts.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+// Traceparent is the W3C traceparent of the span, for handing the trace on
+// to a child process. It is empty for a nil span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(s.ctx.traceID[:]) + "-" + hex.EncodeToString(s.ctx.spanID[:]) + "-01"
+}
+
+// Flush sends the ended spans to the collector and forgets them. A nil
+// Tracer has nothing to send.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.payload(spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode the spans: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", EndpointEnv, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send the spans: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		answer, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector answered %s: %s", resp.Status, strings.TrimSpace(string(answer)))
+	}
+	return nil
+}
+
+// payload is the ExportTraceServiceRequest in the OTLP JSON encoding
+func (t *Tracer) payload(spans []*Span) map[string]any {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.ctx.traceID[:]),
+			"spanId":            hex.EncodeToString(s.ctx.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        encodeAttrs(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != "" {
+			span["status"] = map[string]any{"code": 2, "message": s.err}
+		}
+		s.mu.Unlock()
+		encoded = append(encoded, span)
+	}
+	resource := []Attr{String("service.name", t.service)}
+	if t.version != "" {
+		resource = append(resource, String("service.version", t.version))
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": encodeAttrs(resource)},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "tfmanage"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+func encodeAttrs(attrs []Attr) []any {
+	out := make([]any, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.Value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int64:
+			// int64s are strings in OTLP JSON
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": a.Key, "value": value})
+	}
+	return out
+}
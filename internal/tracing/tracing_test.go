@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const pipelineParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestStartWithoutTracer(t *testing.T) {
+	ctx := context.Background()
+	got, span := Start(ctx, "upload")
+	if got != ctx || span != nil {
+		t.Errorf("Start() = %v, %v, want ctx back and no span", got, span)
+	}
+	// a nil span is fine to use
+	span.SetAttributes(String("k", "v"))
+	span.End(errors.New("x"))
+	if span.Traceparent() != "" {
+		t.Errorf("Traceparent() of a nil span = %q", span.Traceparent())
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(EndpointEnv, "")
+	t.Setenv(TracesEndpointEnv, "")
+	if FromEnv("1.0.0") != nil {
+		t.Error("FromEnv() gave a Tracer without an endpoint")
+	}
+	t.Setenv(EndpointEnv, "http://collector:4318/")
+	if tr := FromEnv("1.0.0"); tr == nil || tr.endpoint != "http://collector:4318/v1/traces" {
+		t.Errorf("FromEnv() = %+v, want the traces path added", tr)
+	}
+	t.Setenv(TracesEndpointEnv, "http://collector:4318/custom")
+	if tr := FromEnv("1.0.0"); tr == nil || tr.endpoint != "http://collector:4318/custom" {
+		t.Errorf("FromEnv() = %+v, want the traces endpoint as it is", tr)
+	}
+	t.Setenv(DisabledEnv, "true")
+	if FromEnv("1.0.0") != nil {
+		t.Error("FromEnv() gave a Tracer with OTEL_SDK_DISABLED=true")
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	if c, err := parseTraceparent(pipelineParent); err != nil || !c.valid() {
+		t.Errorf("parseTraceparent() = %v, %v", c, err)
+	}
+	for _, bad := range []string{"", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-xyz-00f067aa0ba902b7-01"} {
+		if _, err := parseTraceparent(bad); err == nil {
+			t.Errorf("parseTraceparent(%q) gave no error", bad)
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var payload struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Attributes   []struct {
+						Key   string         `json:"key"`
+						Value map[string]any `json:"value"`
+					} `json:"attributes"`
+					Status *struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+	t.Setenv(TracesEndpointEnv, srv.URL)
+	t.Setenv(HeadersEnv, "Authorization=Bearer%20abc, x-team = infra")
+	t.Setenv(TraceparentEnv, pipelineParent)
+	t.Setenv(DisabledEnv, "")
+	tracer := FromEnv("1.0.0")
+
+	ctx, root := Start(WithTracer(context.Background(), tracer), "tfmanage upload")
+	_, child := Start(ctx, "storage.upload", String("storage.key", "team/prod.tfvars"), Int("storage.bytes", 12))
+	child.End(errors.New("access denied"))
+	root.End(nil)
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer abc" {
+		t.Errorf("Authorization = %q, want the header from %s", auth, HeadersEnv)
+	}
+	spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("sent %d spans, want 2", len(spans))
+	}
+	upload, run := spans[0], spans[1]
+	if run.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || run.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("root span %+v, want it under TRACEPARENT", run)
+	}
+	if upload.TraceID != run.TraceID || upload.ParentSpanID != run.SpanID || upload.Status == nil || upload.Status.Code != 2 {
+		t.Errorf("upload span %+v, want a failed child of the root", upload)
+	}
+	if len(upload.Attributes) != 2 || upload.Attributes[1].Value["intValue"] != "12" {
+		t.Errorf("upload attributes = %+v", upload.Attributes)
+	}
+	if !strings.HasPrefix(root.Traceparent(), "00-4bf92f3577b34da6a3ce929d0e0e4736-"+run.SpanID) {
+		t.Errorf("Traceparent() = %q", root.Traceparent())
+	}
+}
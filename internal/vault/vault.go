@@ -0,0 +1,232 @@
+// Package vault reads secrets from HashiCorp Vault's KV secrets engine over
+// its HTTP API. It logs in with a token or with the AWS auth method, which
+// proves the caller's AWS identity with a signed sts:GetCallerIdentity
+// request instead of a shared secret.
+package vault
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+var (
+	// ErrPermissionDenied is returned when Vault refused the token or the
+	// login.
+	ErrPermissionDenied = errors.New("permission denied by vault")
+	// ErrNotFound is returned for a secret, or a version of it, that doesn't
+	// exist.
+	ErrNotFound = errors.New("not found in vault")
+)
+
+// Client calls the Vault HTTP API.
+type Client struct {
+	// Address is the Vault server, such as https://vault.example.com:8200.
+	Address string
+	// Token authenticates every request, LoginAWS sets it.
+	Token string
+	// Namespace is sent as X-Vault-Namespace when set, for Vault Enterprise.
+	Namespace string
+	// HTTPClient is http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// KV is where a value is kept in a KV secrets engine.
+type KV struct {
+	// Mount is the path the engine is mounted at, such as secret.
+	Mount string
+	Path  string
+	// Field is the key of the value within the secret.
+	Field string
+	// Version pins a version of a KV v2 secret, 0 is the latest.
+	Version int
+	// V1 reads a KV version 1 engine, which has no versions.
+	V1 bool
+}
+
+// String is the secret's path the way the vault CLI takes it, which is safe
+// to show since it never has the value in it.
+func (kv KV) String() string {
+	s := strings.Trim(kv.Mount, "/") + "/" + strings.Trim(kv.Path, "/")
+	if kv.Version > 0 {
+		s += "?version=" + strconv.Itoa(kv.Version)
+	}
+	return s + "#" + kv.Field
+}
+
+// ReadKV reads one field of a KV secret. A field that isn't a string comes
+// back as JSON.
+func (c *Client) ReadKV(ctx context.Context, kv KV) (string, error) {
+	mount, path := strings.Trim(kv.Mount, "/"), strings.Trim(kv.Path, "/")
+	var apiPath string
+	if kv.V1 {
+		apiPath = mount + "/" + path
+	} else {
+		apiPath = mount + "/data/" + path
+		if kv.Version > 0 {
+			apiPath += "?version=" + strconv.Itoa(kv.Version)
+		}
+	}
+
+	var out struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := c.call(ctx, http.MethodGet, apiPath, kv.String(), nil, &out); err != nil {
+		return "", err
+	}
+	fields := out.Data
+	if !kv.V1 {
+		// v2 wraps the secret's fields in data with its metadata next to them
+		inner, ok := out.Data["data"].(map[string]any)
+		if !ok {
+			// a deleted or destroyed version has no data
+			return "", fmt.Errorf("%w: %s has no data, the version may have been deleted", ErrNotFound, kv)
+		}
+		fields = inner
+	}
+	value, ok := fields[kv.Field]
+	if !ok {
+		return "", fmt.Errorf("%w: %s has no field %s", ErrNotFound, kv, kv.Field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// stsURL is where the signed GetCallerIdentity request is addressed. Vault
+// sends it on to STS itself, and uses the global endpoint by default.
+const stsURL = "https://sts.amazonaws.com/"
+
+const stsBody = "Action=GetCallerIdentity&Version=2011-06-15"
+
+// LoginAWS logs in with the AWS auth method mounted at mount as role, and
+// keeps the token for the requests that follow. serverID is sent as the
+// X-Vault-AWS-IAM-Server-ID header when the auth method requires one.
+func (c *Client) LoginAWS(ctx context.Context, cfg aws.Config, mount, role, serverID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stsURL, strings.NewReader(stsBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if serverID != "" {
+		req.Header.Set("X-Vault-AWS-IAM-Server-ID", serverID)
+	}
+	if cfg.Credentials == nil {
+		return fmt.Errorf("vault aws login: no AWS credentials")
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("vault aws login: %w", err)
+	}
+	sum := sha256.Sum256([]byte(stsBody))
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "sts", "us-east-1", time.Now()); err != nil {
+		return fmt.Errorf("vault aws login: %w", err)
+	}
+	headers, err := json.Marshal(req.Header)
+	if err != nil {
+		return err
+	}
+
+	login := map[string]string{
+		"role":                    role,
+		"iam_http_request_method": http.MethodPost,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(stsURL)),
+		"iam_request_body":        base64.StdEncoding.EncodeToString([]byte(stsBody)),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+	}
+	var out struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	mount = strings.Trim(cmp.Or(mount, "aws"), "/")
+	if err := c.call(ctx, http.MethodPost, "auth/"+mount+"/login", "auth/"+mount+"/login as "+role, login, &out); err != nil {
+		return err
+	}
+	if out.Auth.ClientToken == "" {
+		return fmt.Errorf("vault aws login as %s gave back no token", role)
+	}
+	c.Token = out.Auth.ClientToken
+	return nil
+}
+
+// vaultError is the body of a failed request
+type vaultError struct {
+	Errors []string `json:"errors"`
+}
+
+// call makes a request to /v1/<apiPath>, what names the secret or login in errors
+func (c *Client) call(ctx context.Context, method, apiPath, what string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	address, err := url.Parse(strings.TrimSuffix(c.Address, "/") + "/v1/" + apiPath)
+	if err != nil || address.Host == "" {
+		return fmt.Errorf("vault address %q is not a URL", c.Address)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, address.String(), body)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Vault-Token", c.Token)
+	}
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault %s: %w", what, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("vault %s: %w", what, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e vaultError
+		json.Unmarshal(data, &e)
+		msg := strings.Join(e.Errors, "; ")
+		switch resp.StatusCode {
+		case http.StatusForbidden, http.StatusUnauthorized:
+			return fmt.Errorf("%w: %s", ErrPermissionDenied, what)
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrNotFound, what)
+		}
+		return fmt.Errorf("vault %s: %s (status %d)", what, msg, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,118 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestReadKV(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+		if r.Header.Get("X-Vault-Token") != "s.token" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/app/db":
+			if r.URL.Query().Get("version") == "1" {
+				w.Write([]byte(`{"data":{"data":null,"metadata":{"version":1,"deletion_time":"2024-01-01T00:00:00Z"}}}`))
+				return
+			}
+			w.Write([]byte(`{"data":{"data":{"password":"hunter22","port":5432},"metadata":{"version":3}}}`))
+		case "/v1/kv1/app/db":
+			w.Write([]byte(`{"data":{"password":"old-hunter"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer srv.Close()
+	c := &Client{Address: srv.URL, Token: "s.token", Namespace: "team"}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		kv      KV
+		want    string
+		wantErr error
+	}{
+		{KV{Mount: "secret", Path: "app/db", Field: "password"}, "hunter22", nil},
+		{KV{Mount: "secret", Path: "app/db", Field: "port", Version: 3}, "5432", nil},
+		{KV{Mount: "kv1/", Path: "/app/db", Field: "password", V1: true}, "old-hunter", nil},
+		{KV{Mount: "secret", Path: "app/db", Field: "user"}, "", ErrNotFound},
+		{KV{Mount: "secret", Path: "app/db", Field: "password", Version: 1}, "", ErrNotFound},
+		{KV{Mount: "secret", Path: "app/cache", Field: "password"}, "", ErrNotFound},
+	} {
+		got, err := c.ReadKV(ctx, tc.kv)
+		if got != tc.want || !errors.Is(err, tc.wantErr) {
+			t.Errorf("ReadKV(%s) = %q, %v, want %q, %v", tc.kv, got, err, tc.want, tc.wantErr)
+		}
+		if err != nil && strings.Contains(err.Error(), "hunter") {
+			t.Errorf("ReadKV(%s) error has the value in it: %v", tc.kv, err)
+		}
+	}
+	if requests[1] != "/v1/secret/data/app/db?version=3" {
+		t.Errorf("pinned version requested %q", requests[1])
+	}
+
+	c.Token = "s.expired"
+	_, err := c.ReadKV(ctx, KV{Mount: "secret", Path: "app/db", Field: "password", Version: 2})
+	if !errors.Is(err, ErrPermissionDenied) || !strings.Contains(err.Error(), "secret/app/db?version=2#password") {
+		t.Errorf("ReadKV() with a bad token = %v, want permission denied naming the path", err)
+	}
+}
+
+func TestLoginAWS(t *testing.T) {
+	var login map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/aws-prod/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&login)
+		if login["role"] != "deployer" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["entry for role nobody not found"]}`))
+			return
+		}
+		w.Write([]byte(`{"auth":{"client_token":"s.fromaws"}}`))
+	}))
+	defer srv.Close()
+	cfg := aws.Config{Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "secret"}, nil
+	})}
+
+	c := &Client{Address: srv.URL}
+	if err := c.LoginAWS(context.Background(), cfg, "/aws-prod/", "deployer", "vault.example.com"); err != nil {
+		t.Fatalf("LoginAWS() = %v", err)
+	}
+	if c.Token != "s.fromaws" {
+		t.Errorf("token = %q", c.Token)
+	}
+	decode := func(field string) string {
+		data, _ := base64.StdEncoding.DecodeString(login[field])
+		return string(data)
+	}
+	if decode("iam_request_url") != "https://sts.amazonaws.com/" || !strings.Contains(decode("iam_request_body"), "Action=GetCallerIdentity") || login["iam_http_request_method"] != "POST" {
+		t.Errorf("login = %q", login)
+	}
+	var headers http.Header
+	json.Unmarshal([]byte(decode("iam_request_headers")), &headers)
+	if !strings.HasPrefix(headers.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIAEXAMPLE/") || !strings.Contains(headers.Get("Authorization"), "x-vault-aws-iam-server-id") || headers.Get("X-Vault-AWS-IAM-Server-ID") != "vault.example.com" {
+		t.Errorf("signed headers = %q", headers)
+	}
+
+	c = &Client{Address: srv.URL}
+	if err := c.LoginAWS(context.Background(), cfg, "aws-prod", "nobody", ""); !errors.Is(err, ErrPermissionDenied) || c.Token != "" {
+		t.Errorf("LoginAWS() with a role it can't use = %v, token %q", err, c.Token)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+// errLintFailed is returned when tflint found errors, or warnings with --lint-strict - the issues have been printed already
+
+var errLintFailed = withCode(exitCheck, errors.New("tflint found problems, fix them before planning"))
+
+// lintSteps is how the lint before a plan is set up
+
+type lintSteps struct {
+	enabled bool
+	strict  bool
+	binary  string
+}
+
+// runLint runs tflint in dir and prints what it found grouped by severity
+
+func runLint(ctx context.Context, a *app, lint lintSteps, dir string) error {
+	a.out.Printf("Linting with tflint...\n")
+	a.out.Verbosef("Running tflint %v\n", tools.TFLintArgs())
+	issues, err := tools.TFLint(ctx, toolRunner, lint.binary, dir)
+	if err != nil {
+		return err
+	}
+	a.out.Event("lint", map[string]any{"issues": issues})
+	if len(issues) == 0 {
+		a.out.Successf("tflint found no issues")
+		return nil
+	}
+
+	groups := tools.GroupBySeverity(issues)
+	var counts []string
+	for _, severity := range []string{tools.SeverityError, tools.SeverityWarning, tools.SeverityNotice} {
+		if n := len(groups[severity]); n > 0 {
+			counts = append(counts, plural(n, severity))
+		}
+	}
+	a.out.Printf("tflint found %s:\n", strings.Join(counts, ", "))
+	for _, severity := range []string{tools.SeverityError, tools.SeverityWarning, tools.SeverityNotice} {
+		for _, i := range groups[severity] {
+			a.out.Finding(severity, i.File, i.Line, fmt.Sprintf("%s (%s)", i.Message, i.Rule))
+		}
+	}
+
+	if len(groups[tools.SeverityError]) > 0 || (lint.strict && len(groups[tools.SeverityWarning]) > 0) {
+		return errLintFailed
+	}
+	return nil
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
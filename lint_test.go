@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+func withLintRunners(t *testing.T, output string) (*tfexec.RecordingRunner, *tools.RecordingRunner) {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{}
+	tflint := &tools.RecordingRunner{Result: func(tools.Command) ([]byte, error) {
+		return []byte(output), &tools.Error{Tool: "tflint", ExitCode: 2}
+	}}
+	useRunner(t, rec)
+	useTools(t, tflint)
+	withTFVars(t, "dev")
+	return rec, tflint
+}
+
+const lintWarning = `{"issues": [{"rule": {"name": "terraform_unused_declarations", "severity": "warning"}, "message": "variable \"old\" is not used", "range": {"filename": "variables.tf", "start": {"line": 4}}}], "errors": []}`
+
+const lintError = `{"issues": [{"rule": {"name": "aws_instance_invalid_type", "severity": "error"}, "message": "invalid instance type", "range": {"filename": "main.tf", "start": {"line": 3}}}], "errors": []}`
+
+func TestLintErrorsStopThePlan(t *testing.T) {
+	rec, tflint := withLintRunners(t, lintError)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"plan", "dev", "plan.out", "--lint", "--chdir", "infra", "--github"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if !errors.Is(err, errLintFailed) || exitCodeFor(err) != exitCheck {
+		t.Fatalf("plan: %v, want the lint failure", err)
+	}
+	if len(rec.Calls) != 0 {
+		t.Errorf("terraform ran after a lint error: %q", rec.Args())
+	}
+	if tflint.Calls[0].Dir != "infra" {
+		t.Errorf("tflint ran in %q, want the --chdir directory", tflint.Calls[0].Dir)
+	}
+	for _, want := range []string{"tflint found 1 error:", "main.tf:3: invalid instance type (aws_instance_invalid_type)", "::error file=main.tf,line=3::invalid instance type"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("output is missing %q:\n%s", want, stdout.String())
+		}
+	}
+}
+
+func TestLintWarnings(t *testing.T) {
+	rec, _ := withLintRunners(t, lintWarning)
+
+	if err := run([]string{"plan", "dev", "plan.out", "--lint"}); err != nil {
+		t.Fatalf("plan with lint warnings: %v", err)
+	}
+	if len(rec.Calls) != 1 {
+		t.Errorf("terraform calls = %q, want the plan", rec.Args())
+	}
+
+	if err := run([]string{"plan", "dev", "plan.out", "--lint-strict"}); !errors.Is(err, errLintFailed) {
+		t.Errorf("plan with --lint-strict: %v, want the lint failure", err)
+	}
+}
+
+func TestLintOnlyWhenAsked(t *testing.T) {
+	_, tflint := withLintRunners(t, lintError)
+	tflint.Result = func(tools.Command) ([]byte, error) { return nil, tools.ErrNotInstalled }
+
+	if err := run([]string{"plan", "dev", "plan.out"}); err != nil {
+		t.Fatalf("plan without --lint: %v", err)
+	}
+	if len(tflint.Calls) != 0 {
+		t.Errorf("tflint ran without --lint")
+	}
+	if err := run([]string{"plan", "dev", "plan.out", "--lint"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("plan --lint without tflint: %v, want a config error", err)
+	}
+}
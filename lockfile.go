@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// the canonical lock file - each environment's .terraform.lock.hcl is one of its files, files/<env>/.terraform.lock.hcl, so every run uses the same provider versions instead of whatever init picked on the runner
+
+// lockfileLocation is where the environment's lock file is kept and its key there
+
+func (a *app) lockfileLocation(ctx context.Context, operation, environment string) (tfvarsLocation, string, error) {
+	loc, prefix, err := a.filesLocation(ctx, operation, environment)
+	if err != nil {
+		return tfvarsLocation{}, "", err
+	}
+	key, err := fileKey(prefix, tfexec.LockFileName)
+	return loc, key, err
+}
+
+// readLockProviders reads a lock file for a diff, a missing or unreadable one has no providers
+
+func readLockProviders(fileName string) []tfexec.LockedProvider {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil
+	}
+	providers, _ := tfexec.ParseLockFile(data)
+	return providers
+}
+
+// printLockChanges summarizes a lock file change as provider versions, the hashes alone say nothing to a reviewer
+
+func (a *app) printLockChanges(changes []tfexec.LockChange) {
+	if len(changes) == 0 {
+		a.out.Printf("No provider changes\n")
+		return
+	}
+	a.out.Printf("Provider changes:\n")
+	for _, c := range changes {
+		a.out.Printf("  %s\n", c)
+	}
+}
+
+func lockChangeStrings(changes []tfexec.LockChange) []string {
+	out := make([]string, 0, len(changes))
+	for _, c := range changes {
+		out = append(out, c.String())
+	}
+	return out
+}
+
+// uploadLockfile makes the lock file in dir the environment's canonical one. A remote lock file changed after the local one was last written is someone else's newer lock, it is only overwritten with force
+
+func uploadLockfile(ctx context.Context, a *app, environment, dir string, force bool) error {
+	loc, key, err := a.lockfileLocation(ctx, "upload-lockfile", environment)
+	if err != nil {
+		return err
+	}
+	fileName := filepath.Join(cmp.Or(dir, "."), tfexec.LockFileName)
+	local, err := os.Stat(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return configError("there is no %s, run terraform init or tfmanage providers lock first", fileName)
+	}
+	if err != nil {
+		return err
+	}
+	sum, err := storage.FileChecksum(fileName)
+	if err != nil {
+		return err
+	}
+
+	var before []tfexec.LockedProvider
+	remote, err := loc.store.Head(ctx, key)
+	switch {
+	case errors.Is(err, storage.ErrObjectNotFound):
+	case err != nil:
+		return err
+	case remote.Metadata[storage.ChecksumMetadataKey] == sum:
+		a.out.Event("lockfile-upload", map[string]any{"environment": environment, "file": fileName, "key": key, "sha256": sum, "skipped": true})
+		a.out.Warnf("%s is unchanged at %s, skipping upload", fileName, loc.url(key))
+		return nil
+	case remote.LastModified.After(local.ModTime()) && !force:
+		return configError("%s was changed at %s, after %s was last written, download it with 'tfmanage download-lockfile %s' or pass --force to overwrite it", loc.url(key), remote.LastModified.Format(time.RFC3339), fileName, environment)
+	default:
+		if data, err := storage.GetBytes(ctx, loc.store, key); err == nil {
+			before, _ = tfexec.ParseLockFile(data)
+		}
+	}
+	changes := tfexec.DiffLockFiles(before, readLockProviders(fileName))
+
+	opts := storage.UploadOptions{Metadata: gitMetadata(gitinfo.File(ctx, fileName)), CacheControl: "no-cache"}
+	if loc.bucket != "" {
+		opts.KMSKeyID = loc.kmsKey
+	}
+	res, err := storage.UploadKey(ctx, loc.store, key, fileName, opts)
+	if err != nil {
+		return err
+	}
+	a.out.Event("lockfile-upload", map[string]any{"environment": environment, "file": fileName, "key": res.Key, "sha256": res.Checksum, "skipped": false, "changes": lockChangeStrings(changes)})
+	a.printLockChanges(changes)
+	a.out.Successf("Uploaded %s to %s", fileName, loc.url(key))
+	return nil
+}
+
+// downloadLockfile writes the environment's canonical lock file into dir. Without one stored it only warns, unless required
+
+func downloadLockfile(ctx context.Context, a *app, environment, dir string, required bool) error {
+	loc, key, err := a.lockfileLocation(ctx, "download-lockfile", environment)
+	if err != nil {
+		return err
+	}
+	fileName := filepath.Join(cmp.Or(dir, "."), tfexec.LockFileName)
+	remote, err := loc.store.Head(ctx, key)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		if required {
+			return configError("there is no lock file for %s at %s, upload one with 'tfmanage upload-lockfile %s'", environment, loc.url(key), environment)
+		}
+		a.out.Warnf("There is no lock file for %s at %s yet, using %s as it is", environment, loc.url(key), fileName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if loc.bucket != "" {
+		a.checkObjectKey(loc, remote)
+	}
+
+	before := readLockProviders(fileName)
+	if _, err := storage.DownloadKey(ctx, loc.store, key, fileName); err != nil {
+		return kmsDecryptError(err, cmp.Or(remote.KMSKeyID, loc.kmsKey))
+	}
+	if sum := remote.Metadata[storage.ChecksumMetadataKey]; sum != "" {
+		if local, err := storage.FileChecksum(fileName); err == nil && local != sum {
+			return withCode(exitTransfer, fmt.Errorf("%s was written to %s but doesn't match the checksum it was uploaded with, it may have been changed in the bucket", loc.url(key), fileName))
+		}
+	}
+	changes := tfexec.DiffLockFiles(before, readLockProviders(fileName))
+	a.out.Event("lockfile-download", map[string]any{"environment": environment, "file": fileName, "key": key, "changes": lockChangeStrings(changes)})
+	a.printLockChanges(changes)
+	a.out.Successf("Downloaded %s to %s", loc.url(key), fileName)
+	return nil
+}
+
+func uploadLockfileCommand() *command {
+	return &command{
+		name:    "upload-lockfile",
+		args:    "<env>",
+		summary: "Make the local .terraform.lock.hcl the environment's canonical lock file, stored next to its tfvars.",
+		examples: []string{
+			"tfmanage upload-lockfile prod",
+			"tfmanage upload-lockfile prod --chdir infra --force",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "upload the lock file in this directory")
+			force := fs.Bool("force", false, "overwrite a stored lock file that was changed after the local one")
+			return func(ctx context.Context, a *app, args []string) error {
+				return uploadLockfile(ctx, a, args[0], a.useEnvironment(args[0], *chdir), *force)
+			}
+		},
+	}
+}
+
+func downloadLockfileCommand() *command {
+	return &command{
+		name:    "download-lockfile",
+		args:    "<env>",
+		summary: "Replace the local .terraform.lock.hcl with the environment's canonical lock file.",
+		examples: []string{
+			"tfmanage download-lockfile prod",
+			"tfmanage download-lockfile prod --chdir infra",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "write the lock file into this directory")
+			return func(ctx context.Context, a *app, args []string) error {
+				return downloadLockfile(ctx, a, args[0], a.useEnvironment(args[0], *chdir), true)
+			}
+		},
+	}
+}
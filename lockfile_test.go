@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func lockFileFor(awsVersion string) string {
+	return "provider \"registry.terraform.io/hashicorp/aws\" {\n  version     = \"" + awsVersion + "\"\n  constraints = \"~> 5.0\"\n  hashes = [\n    \"h1:" + awsVersion + "=\",\n  ]\n}\n"
+}
+
+func TestUploadDownloadLockfile(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	os.WriteFile("prod.tfvars", []byte("a = 1\n"), 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	const key = "team/files/prod/.terraform.lock.hcl"
+
+	if err := run([]string{"download-lockfile", "prod"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("download-lockfile with nothing stored: %v, want a config error", err)
+	}
+	if err := run([]string{"upload-lockfile", "prod"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("upload-lockfile without a lock file: %v, want a config error", err)
+	}
+
+	os.WriteFile(tfexec.LockFileName, []byte(lockFileFor("5.30.0")), 0o644)
+	if err := run([]string{"upload-lockfile", "prod"}); err != nil {
+		t.Fatalf("upload-lockfile: %v", err)
+	}
+	if info, err := store.Head(context.Background(), key); err != nil || info.Metadata["sha256"] == "" {
+		t.Fatalf("stored %+v, %v", info, err)
+	}
+
+	// someone else's newer lock file isn't overwritten by an older local one
+	storage.PutBytes(context.Background(), store, key, []byte(lockFileFor("5.31.0")))
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(tfexec.LockFileName, old, old)
+	if err := run([]string{"upload-lockfile", "prod"}); exitCodeFor(err) != exitConfig || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("upload-lockfile over a newer one: %v, want a config error", err)
+	}
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"download-lockfile", "prod"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("download-lockfile: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "~ hashicorp/aws 5.30.0 -> 5.31.0") || strings.Contains(stdout.String(), "h1:") {
+		t.Errorf("download-lockfile printed %q, want the version change and no hashes", stdout.String())
+	}
+	if data, _ := os.ReadFile(tfexec.LockFileName); string(data) != lockFileFor("5.31.0") {
+		t.Errorf("local lock file = %q", data)
+	}
+
+	os.WriteFile(tfexec.LockFileName, []byte(lockFileFor("5.32.0")), 0o644)
+	os.Chtimes(tfexec.LockFileName, old, old)
+	stdout.Reset()
+	if err := runWithUI([]string{"upload-lockfile", "prod", "--force"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("upload-lockfile --force: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "~ hashicorp/aws 5.31.0 -> 5.32.0") {
+		t.Errorf("upload-lockfile printed %q", stdout.String())
+	}
+}
+
+func TestPlanSyncLockfile(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	// nothing stored yet only warns
+	if err := run([]string{"plan", "dev", "plan.out", "--sync-lockfile"}); err != nil {
+		t.Fatalf("plan --sync-lockfile without a stored lock file: %v", err)
+	}
+	storage.PutBytes(context.Background(), store, "team/files/dev/.terraform.lock.hcl", []byte(lockFileFor("5.31.0")))
+	os.WriteFile(tfexec.LockFileName, []byte(lockFileFor("5.40.0")), 0o644)
+	if err := run([]string{"plan", "dev", "plan.out", "--sync-lockfile"}); err != nil {
+		t.Fatalf("plan --sync-lockfile: %v", err)
+	}
+	if data, _ := os.ReadFile(tfexec.LockFileName); string(data) != lockFileFor("5.31.0") {
+		t.Errorf("the plan ran with lock file %q, want the stored one", data)
+	}
+}
+
+func TestProvidersLockSyncLockfile(t *testing.T) {
+	rec := withProvidersRunner(t, "1.6.2")
+	store := withMemoryStore(t)
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	storage.PutBytes(context.Background(), store, "team/files/dev/.terraform.lock.hcl", []byte(lockFileFor("5.30.0")))
+	// terraform providers lock updates the lock file it was started with
+	rec.Result = func(args []string) error {
+		if len(args) > 1 && args[1] == "lock" {
+			os.WriteFile(tfexec.LockFileName, []byte(lockFileFor("5.31.0")), 0o644)
+		}
+		return nil
+	}
+
+	if err := run([]string{"providers", "lock", "dev", "--sync-lockfile"}); err != nil {
+		t.Fatalf("providers lock --sync-lockfile: %v", err)
+	}
+	if data, _ := storage.GetBytes(context.Background(), store, "team/files/dev/.terraform.lock.hcl"); string(data) != lockFileFor("5.31.0") {
+		t.Errorf("stored lock file = %q, want the new lock", data)
+	}
+	if err := run([]string{"providers", "lock", "--sync-lockfile"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("providers lock --sync-lockfile without an environment: %v, want a usage error", err)
+	}
+}
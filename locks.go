@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/dirs"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/locallock"
+)
+
+// local locks - two runs on the same machine can't change the same environment at once, such as a download swapping the tfvars under an apply. They are OS locks on files in the state directory so a crashed run never leaves one held
+
+func localLockPath(environment string) (string, error) {
+	dir, err := dirs.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "locks", environment+".lock"), nil
+}
+
+// lockEnvironment takes the environment's local lock until the run ends, --no-local-lock skips it for homes on shared filesystems like NFS where the lock can't be trusted
+
+func (a *app) lockEnvironment(environment, operation string) error {
+	if a.global.noLocalLock || a.locks[environment] != nil {
+		return nil
+	}
+	path, err := localLockPath(environment)
+	if err != nil {
+		return err
+	}
+	lock, stale, err := locallock.Acquire(path, locallock.Holder{PID: os.Getpid(), Command: operation + " " + environment, Since: time.Now().UTC()})
+	if errors.Is(err, locallock.ErrLocked) {
+		return withCode(exitCheck, fmt.Errorf("%s %s: the environment is %w, wait for it to finish or pass --no-local-lock", operation, environment, err))
+	}
+	if err != nil {
+		return err
+	}
+	if stale != nil {
+		a.out.Warnf("Reclaimed the local lock of %s from %s, which is no longer running", environment, stale)
+	}
+	a.out.Verbosef("Holding the local lock %s\n", path)
+	if a.locks == nil {
+		a.locks = map[string]*locallock.Lock{}
+	}
+	a.locks[environment] = lock
+	return nil
+}
+
+// releaseLocks lets go of the local locks when the run ends, however it ends
+
+func (a *app) releaseLocks() {
+	for environment, lock := range a.locks {
+		if err := lock.Release(); err != nil {
+			a.out.Warnf("Could not release the local lock of %s: %v", environment, err)
+		}
+	}
+	a.locks = nil
+}
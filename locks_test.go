@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/locallock"
+)
+
+func TestLocalLock(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	os.WriteFile("dev.tfvars", []byte("a = 1\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	path, err := localLockPath("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, _, err := locallock.Acquire(path, locallock.Holder{PID: os.Getpid(), Command: "apply dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"upload", "dev", "--force"}); !errors.Is(err, locallock.ErrLocked) || exitCodeFor(err) != exitCheck {
+		t.Errorf("upload while dev is locked: %v, want ErrLocked with exit %d", err, exitCheck)
+	}
+	os.WriteFile("backend.hcl", []byte("bucket = \"state\"\n"), 0o644)
+	if err := run([]string{"put", "dev", "backend.hcl"}); !errors.Is(err, locallock.ErrLocked) || exitCodeFor(err) != exitCheck {
+		t.Errorf("put while dev is locked: %v, want ErrLocked with exit %d", err, exitCheck)
+	}
+	if err := run([]string{"upload", "dev", "--force", "--no-local-lock"}); err != nil {
+		t.Errorf("upload --no-local-lock: %v", err)
+	}
+	held.Release()
+
+	if err := run([]string{"upload", "dev", "--force"}); err != nil {
+		t.Fatalf("upload after the lock was released: %v", err)
+	}
+	// the run let go of it when it ended
+	again, _, err := locallock.Acquire(path, locallock.Holder{PID: os.Getpid()})
+	if err != nil {
+		t.Fatalf("the upload kept the lock: %v", err)
+	}
+	again.Release()
+}
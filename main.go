@@ -0,0 +1,408 @@
+package main
+
+// main is only the CLI layer - the AWS config, the S3 transfers and the terraform runs live in internal/
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/buildinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/locallock"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/metrics"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/notify"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/plansummary"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tracing"
+)
+
+// settings come from the config file and the local env - the env always wins so the script keeps working with only env variables set
+
+type settings struct {
+	S3Bucket  string
+	S3Path    string
+	TFVars    map[string]string
+	AWSConfig awsconfig.Env
+	S3Client  storage.S3ClientOptions
+	Hooks     config.Hooks
+	Retention config.Retention
+	// Terraform has the per environment chdir and workspace from the config file
+	Terraform map[string]config.Environment
+	// Webhook is where alerts go, TFMANAGE_WEBHOOK_URL or notify.webhook
+	Webhook string
+	// Pushgateway and MetricsJob are where run metrics go, TFMANAGE_PUSHGATEWAY_URL or metrics.pushgateway_url
+	Pushgateway string
+	MetricsJob  string
+	// Branches maps branch names or globs to the environment auto resolves to
+	Branches map[string]string
+	// CacheMaxAge is when --use-cache starts warning, 0 is the default
+	CacheMaxAge time.Duration
+	// PlanDir is where plan without a plan file writes one, plan_dir or plans
+	PlanDir string
+	// ExpectEqual are the variables matrix wants the same in every environment, matrix.expect_equal
+	ExpectEqual []string
+	// GitHubRepo is where applies are recorded as GitHub deployments, --github-repo or github_repo
+	GitHubRepo string
+	// CIVariable is the extra variable that marks a CI system for require_ci, ci_variable
+	CIVariable string
+	// ExpectedBucketAccount is the account the buckets uploads go to have to belong to, expected_bucket_account or the caller's when empty
+	ExpectedBucketAccount string
+	// ReplicaBucket and ReplicaRegion are where downloads read from when S3_BUCKET can't be reached, replica_bucket and replica_region
+	ReplicaBucket string
+	ReplicaRegion string
+	// KMSKeyARN is the key for environments without one of their own, KMS_KEY_ARN or kms_key_arn
+	KMSKeyARN string
+	// TerraformEnv is added to every terraform run's environment, the environments can add their own in Terraform
+	TerraformEnv map[string]string
+	// SecretVars are the terraform variables read from Secrets Manager for every environment, the environments can add their own in Terraform
+	SecretVars map[string]config.SecretVar
+	// Vault and VaultVars are where the Vault variables come from, VAULT_ADDR and VAULT_NAMESPACE win over the config
+	Vault     config.Vault
+	VaultVars map[string]config.VaultVar
+	// ConfigFile is the config file the settings were read from, empty when there is none
+	ConfigFile string
+	// Sources says where each setting came from, keyed by the name config show gives it. Settings that aren't in it weren't set
+	Sources map[string]string
+}
+
+// builtinEnvironments always exist, their tfvars come from <NAME>_TFVARS
+
+var builtinEnvironments = []string{"dev", "staging", "prod", "dr", "management"}
+
+// tfvarsEnvVar is the env variable holding the tfvars path for an environment, e.g. DEV_TFVARS
+
+func tfvarsEnvVar(environment string) string {
+	return strings.ToUpper(strings.ReplaceAll(environment, "-", "_")) + "_TFVARS"
+}
+
+func loadSettings(configPath string) (settings, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			suggestKeys(invalid.Problems)
+		}
+		return settings{}, withCode(exitConfig, err)
+	}
+
+	s := settings{
+		TFVars:                map[string]string{},
+		Hooks:                 cfg.Hooks,
+		Retention:             cfg.Retention,
+		MetricsJob:            cfg.Metrics.JobName,
+		Branches:              cfg.Branches,
+		CacheMaxAge:           cfg.Cache.MaxAge,
+		PlanDir:               cmp.Or(cfg.PlanDir, defaultPlanDir),
+		ExpectEqual:           cfg.Matrix.ExpectEqual,
+		CIVariable:            cfg.CIVariable,
+		GitHubRepo:            cfg.GitHubRepo,
+		TerraformEnv:          cfg.TerraformEnv,
+		ExpectedBucketAccount: cfg.ExpectedBucketAccount,
+		ReplicaBucket:         cfg.ReplicaBucket,
+		ReplicaRegion:         cfg.ReplicaRegion,
+		SecretVars:            cfg.SecretVars,
+		Vault:                 cfg.Vault,
+		VaultVars:             cfg.VaultVars,
+		S3Client: storage.S3ClientOptions{
+			Endpoint:     os.Getenv("S3_ENDPOINT"),
+			UsePathStyle: envBool("S3_FORCE_PATH_STYLE"),
+		},
+		ConfigFile: cfg.Path,
+		Sources:    map[string]string{},
+	}
+	s.S3Bucket = s.envOr("S3_BUCKET", cfg.Bucket, "bucket")
+	s.S3Path = s.envOr("S3_PATH", cfg.Prefix, "prefix")
+	s.Webhook = s.envOr(notify.WebhookURLEnv, cfg.Notify.Webhook, "notify.webhook")
+	s.Pushgateway = s.envOr(metrics.PushgatewayURLEnv, cfg.Metrics.PushgatewayURL, "metrics.pushgateway_url")
+	s.KMSKeyARN = s.envOr("KMS_KEY_ARN", cfg.KMSKeyARN, "kms_key_arn")
+	s.Vault.Address = s.envOr("VAULT_ADDR", s.Vault.Address, "vault.address")
+	s.Vault.Namespace = s.envOr("VAULT_NAMESPACE", s.Vault.Namespace, "vault.namespace")
+	s.envOr("S3_ENDPOINT", "", "")
+	s.envOr("HTTPS_PROXY", "", "")
+	s.envOr("AWS_CA_BUNDLE", "", "")
+	s.sourced(settingMetricsJob, cfg.Metrics.JobName, "metrics.job_name")
+	s.sourced(settingPlanDir, cfg.PlanDir, "plan_dir")
+	s.sourced(settingCIVariable, cfg.CIVariable, "ci_variable")
+	s.sourced(settingGitHubRepo, cfg.GitHubRepo, "github_repo")
+	s.sourced(settingExpectedBucketAccount, cfg.ExpectedBucketAccount, "expected_bucket_account")
+	s.sourced(settingReplicaBucket, cfg.ReplicaBucket, "replica_bucket")
+	s.sourced(settingReplicaRegion, cfg.ReplicaRegion, "replica_region")
+	for _, name := range builtinEnvironments {
+		s.TFVars[name] = ""
+	}
+	s.Terraform = cfg.Environments
+	for name, env := range cfg.Environments {
+		s.TFVars[name] = env.TFVars
+	}
+	for name := range s.TFVars {
+		s.TFVars[name] = s.envOr(tfvarsEnvVar(name), s.TFVars[name], "environments."+name+".tfvars")
+	}
+
+	s.AWSConfig = awsconfig.FromEnv()
+	s.AWSConfig.Region = s.envOr("AWS_REGION", cfg.Region, "region")
+	if s.AWSConfig.AccessKeyID == "" {
+		s.AWSConfig.Profile = s.envOr("AWS_PROFILE", cfg.Profile, "profile")
+	} else {
+		s.AWSConfig.Profile = s.envOr("AWS_PROFILE", "", "")
+	}
+	s.envOr("AWS_ACCESS_KEY_ID", "", "")
+	s.AWSConfig.CredentialsCommand = cfg.CredentialsCommand
+	s.sourced(settingCredentialsCommand, cfg.CredentialsCommand, "credentials_command")
+	s.AWSConfig.RoleARN = cfg.RoleARN
+	s.sourced(settingRoleARN, cfg.RoleARN, "role_arn")
+	return s, nil
+}
+
+// envOr is the env variable when it is set and the config file's value under configKey otherwise, noting which one it was
+
+func (s *settings) envOr(envVar, fallback, configKey string) string {
+	if v := os.Getenv(envVar); v != "" {
+		s.Sources[envVar] = envSource(envVar)
+		return v
+	}
+	s.sourced(envVar, fallback, configKey)
+	return fallback
+}
+
+// sourced notes that a setting came from the config file's configKey, when it is set at all
+
+func (s *settings) sourced(name, value, configKey string) {
+	if value != "" {
+		s.Sources[name] = s.ConfigFile + ": " + configKey
+	}
+}
+
+// envBool is true for anything strconv.ParseBool accepts as true
+
+func envBool(name string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(name))
+	return v
+}
+
+// app is what every command gets - the global flags, the output and the settings once they are loaded
+
+type app struct {
+	global   globalFlags
+	out      *ui
+	settings *settings
+	// workspace is the terraform workspace of the environment being worked on, passed as TF_WORKSPACE
+	workspace string
+	// bucketVerdicts is the outcome of the public access check of each bucket uploaded to
+	bucketVerdicts map[string]error
+	// redactor masks the sensitive values of the environment in terraform's output, redactedFrom is the directory and tfvars it came from
+	redactor     *redact.Redactor
+	redactedFrom string
+	// locks are the local environment locks held until the run ends
+	locks map[string]*locallock.Lock
+	// environment is the one the command works on, planChanges the counts of its plan, both for the metrics
+	environment string
+	planChanges *plansummary.Summary
+	// trace is the context of the run's root span when it is traced
+	trace context.Context
+	// terraformVars are the terraform_env and --tf-env variables of the environment being worked on, as KEY=VALUE
+	terraformVars []string
+	// secretVars are the TF_VAR_ variables read from Secrets Manager for secretVarsFor, secretValues every value read so far so they are always masked
+	secretVars    []string
+	secretVarsFor string
+	secretValues  []string
+	// credentialVars are the credentials terraform runs with for credentialVarsFor, from its assume_roles chain or the credentials command
+	credentialVars    []string
+	credentialVarsFor string
+	// credentialsExpire is when credentialVars expire, zero when they don't
+	credentialsExpire time.Time
+}
+
+func (a *app) loadSettings() (settings, error) {
+	if a.settings == nil {
+		_, span := tracing.Start(a.traceContext(), "config.load")
+		s, err := loadSettings(a.global.config)
+		span.End(err)
+		if err != nil {
+			return settings{}, err
+		}
+		a.global.override(&s)
+		a.useTransport(s)
+		a.warnInsecure(s)
+		if err := checkConfig(s, a.global.allowCredentialEnv); err != nil {
+			return settings{}, err
+		}
+		a.settings = &s
+	}
+	return *a.settings, nil
+}
+
+// tfvarsFor gives back the tfvars file for an environment or an error saying what is wrong
+
+func (a *app) tfvarsFor(environment string) (string, error) {
+	s, err := a.loadSettings()
+	if err != nil {
+		return "", err
+	}
+	fileName, exists := s.TFVars[environment]
+	if !exists {
+		return "", usageError("invalid environment specified: %s (valid environments: %s)", environment, strings.Join(environmentNames(s), ", "))
+	}
+	if fileName == "" {
+		return "", configError("no tfvars file is set for the %s environment, set %s or add it to the config file", environment, tfvarsEnvVar(environment))
+	}
+	return fileName, nil
+}
+
+// useEnvironment picks up the environment's terraform directory and workspace from the config - a --chdir flag wins over the config
+
+func (a *app) useEnvironment(environment, chdir string) string {
+	s, err := a.loadSettings()
+	if err != nil {
+		// the command reports a broken config itself when it needs it
+		return chdir
+	}
+	env := s.Terraform[environment]
+	a.workspace = env.Workspace
+	a.useTerraformVars(environment)
+	if chdir == "" {
+		chdir = env.Chdir
+	}
+	a.redactVariables(chdir, s.TFVars[environment])
+	return chdir
+}
+
+// newStore gives back the store for the bucket - a variable so the tests can use a memory store
+
+var newStore = newS3Store
+
+// newS3Store loads the AWS config and gives back the S3 store for the bucket
+
+func newS3Store(ctx context.Context, s settings) (storage.Backend, error) {
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewS3Store(storage.NewS3Client(cfg, s.S3Client), s.S3Bucket), nil
+}
+
+// runner is what runs terraform - it is a variable so the tests can swap it for a recording runner
+
+var runner tfexec.TerraformRunner = tfexec.ExecRunner{}
+
+// toolRunner runs the optional helpers such as infracost, the tests swap it out too
+
+var toolRunner tools.Runner = tools.ExecRunner{}
+
+// entry point - main only turns the result of run into an exit code so that deferred cleanup in run always happens
+
+func main() {
+	out := newUI()
+	err := runWithUI(os.Args[1:], out)
+	if err != nil {
+		reportError(out, err)
+	}
+	os.Exit(exitCodeFor(err))
+}
+
+// newContext is the one context every operation runs under - it is cancelled on SIGINT/SIGTERM and gets a deadline when TFM_TIMEOUT is set. A SIGINT while tfmanage is in the foreground of its terminal is a Ctrl-C that terraform got as well, so it is the cause and terraform isn't interrupted a second time. A SIGINT sent to tfmanage alone, by kill, timeout or a CI runner aborting the job, is passed on to terraform
+
+func newContext() (context.Context, context.CancelFunc, error) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			if sig == os.Interrupt && inForeground() {
+				cancel(tfexec.ErrInterrupted)
+				return
+			}
+			cancel(nil)
+		case <-ctx.Done():
+		}
+	}()
+	stop := func() {
+		signal.Stop(signals)
+		cancel(nil)
+	}
+
+	timeout := os.Getenv("TFM_TIMEOUT")
+	if timeout == "" {
+		return ctx, stop, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		stop()
+		return nil, nil, configError("TFM_TIMEOUT must be a positive duration like 30m, got %q", timeout)
+	}
+	ctx, cancelTimeout := context.WithTimeout(ctx, d)
+	return ctx, func() { cancelTimeout(); stop() }, nil
+}
+
+func run(args []string) error {
+	return runWithUI(args, newUI())
+}
+
+func runWithUI(args []string, out *ui) error {
+	a := &app{out: out, global: defaultGlobalFlags()}
+
+	root := newFlagSet("tfmanage")
+	a.global.register(root)
+	showVersion := root.Bool("version", false, "print the version and exit")
+	if err := root.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printOverview()
+			return nil
+		}
+		return usageError("%v\n%s", err, overviewHint)
+	}
+	if err := a.global.apply(a.out); err != nil {
+		return err
+	}
+	if *showVersion {
+		fmt.Println(buildinfo.Get())
+		return nil
+	}
+
+	rest := root.Args()
+	if len(rest) == 0 {
+		return usageError("Usage: tfmanage [global flags] <command> [args]\n%s", overviewHint)
+	}
+
+	cmd, err := lookupCommand(rest[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := newContext()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	// a SIGINT or SIGTERM cancels ctx rather than killing the process, so this runs for those too
+	defer a.releaseLocks()
+
+	if !cmd.hidden {
+		a.out.Event("startup", map[string]any{"command": cmd.name, "build": buildinfo.Get()})
+	}
+	finishTrace := func(error) {}
+	if !cmd.hidden {
+		ctx, finishTrace = a.startTrace(ctx, cmd.name)
+	}
+	start := time.Now()
+	err = cmd.execute(ctx, a, rest[1:])
+	a.pushMetrics(ctx, cmd.name, time.Since(start), err)
+	finishTrace(err)
+	if !cmd.hidden {
+		a.out.Result(cmd.name, err)
+	}
+	return err
+}
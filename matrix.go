@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfvars"
+)
+
+// matrix - the tfvars of every environment side by side, a row per variable and a column per environment, to catch the drift between environments before it surprises prod. Variables in matrix.expect_equal should be the same everywhere, a difference in one of them fails the report
+
+const (
+	matrixSame     = "same"
+	matrixDiffers  = "differs"
+	matrixMismatch = "mismatch"
+)
+
+// maxMatrixValue is how much of a value a cell of the table shows, the json events have all of it
+
+const maxMatrixValue = 40
+
+// matrixRow is one variable across the environments. Values has the value of each environment that sets it, or for a sensitive variable which of its distinct values it is
+
+type matrixRow struct {
+	Variable    string            `json:"variable"`
+	Status      string            `json:"status"`
+	ExpectEqual bool              `json:"expect_equal,omitempty"`
+	Sensitive   bool              `json:"sensitive,omitempty"`
+	Values      map[string]string `json:"values"`
+	Missing     []string          `json:"missing,omitempty"`
+}
+
+// failed is a variable that should be the same everywhere and isn't
+
+func (r matrixRow) failed() bool {
+	return r.ExpectEqual && r.Status != matrixSame
+}
+
+func matrixCommand() *command {
+	return &command{
+		name:    "matrix",
+		args:    "[env...]",
+		summary: "Show the tfvars of the environments side by side, variables as rows and environments as columns. Exits 69 when a variable in matrix.expect_equal isn't the same everywhere.",
+		examples: []string{
+			"tfmanage matrix",
+			"tfmanage matrix staging prod --vars instance_type,ami_id",
+			"tfmanage matrix --local --output markdown > tfvars-matrix.md",
+		},
+		markdown: true,
+		minArgs:  0,
+		maxArgs:  -1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			vars := fs.String("vars", "", "only show these variables, comma separated")
+			local := fs.Bool("local", false, "read the local tfvars files instead of downloading them")
+			return func(ctx context.Context, a *app, args []string) error {
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				environments, err := a.matrixEnvironments(s, args, *local)
+				if err != nil {
+					return err
+				}
+				files, environments, err := a.readMatrixFiles(ctx, s, environments, *local)
+				if err != nil {
+					return err
+				}
+				var only []string
+				for name := range strings.SplitSeq(*vars, ",") {
+					if name = strings.TrimSpace(name); name != "" && !slices.Contains(only, name) {
+						only = append(only, name)
+					}
+				}
+				sensitive, err := redact.SensitiveVariables(".")
+				if err != nil {
+					a.out.Verbosef("Could not read the sensitive variables, only the ones named like secrets are masked: %v\n", err)
+				}
+				rows := buildMatrix(environments, files, only, s.ExpectEqual, sensitive)
+				return a.reportMatrix(environments, rows)
+			}
+		},
+	}
+}
+
+// matrixEnvironments are the environments asked for, or every environment with a tfvars file when none are
+
+func (a *app) matrixEnvironments(s settings, args []string, local bool) ([]string, error) {
+	environments := args
+	if len(environments) == 0 {
+		for _, env := range environmentNames(s) {
+			if s.TFVars[env] == "" {
+				a.out.Verbosef("Leaving out %s, it has no tfvars file\n", env)
+				continue
+			}
+			environments = append(environments, env)
+		}
+		if len(environments) == 0 {
+			return nil, configError("no environment has a tfvars file, set <ENV>_TFVARS or add them to the config file")
+		}
+	}
+	for _, env := range environments {
+		if _, err := a.tfvarsFor(env); err != nil {
+			return nil, err
+		}
+		if !local {
+			if err := requirementsError("matrix", checkStoreRequirements("download", env, s)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return slices.Compact(environments), nil
+}
+
+// readMatrixFiles reads the tfvars of every environment at once. An environment whose file doesn't exist is left out with a warning, any other failure stops the report
+
+func (a *app) readMatrixFiles(ctx context.Context, s settings, environments []string, local bool) (map[string][]tfvars.Assignment, []string, error) {
+	type result struct {
+		name        string
+		assignments []tfvars.Assignment
+		err         error
+	}
+	results := make([]result, len(environments))
+	var wg sync.WaitGroup
+	for i, env := range environments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fileName := s.TFVars[env]
+			r := &results[i]
+			var data []byte
+			if local {
+				r.name = fileName
+				data, r.err = os.ReadFile(fileName)
+			} else {
+				var loc tfvarsLocation
+				if loc, r.err = a.locate(ctx, s, env, fileName); r.err != nil {
+					return
+				}
+				r.name = loc.url(loc.key)
+				data, r.err = storage.GetBytes(ctx, loc.store, loc.key)
+			}
+			if r.err == nil {
+				if r.assignments, r.err = tfvars.ParseFile(fileName, data); r.err != nil {
+					r.err = configError("%s can't be read: %v", r.name, r.err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	files := map[string][]tfvars.Assignment{}
+	var read []string
+	for i, r := range results {
+		switch {
+		case errors.Is(r.err, fs.ErrNotExist) || errors.Is(r.err, storage.ErrObjectNotFound):
+			a.out.Warnf("Leaving out %s, %s doesn't exist", environments[i], r.name)
+		case r.err != nil:
+			return nil, nil, r.err
+		default:
+			files[environments[i]] = r.assignments
+			read = append(read, environments[i])
+		}
+	}
+	if len(read) == 0 {
+		return nil, nil, usageError("none of the environments' tfvars files exist")
+	}
+	return files, read, nil
+}
+
+// buildMatrix makes a row for each variable, those in only in that order or else every variable any environment sets sorted by name. A sensitive variable's values are replaced by which of its distinct values each one is, so it still shows where they differ
+
+func buildMatrix(environments []string, files map[string][]tfvars.Assignment, only, expectEqual, sensitive []string) []matrixRow {
+	names := only
+	if len(names) == 0 {
+		for _, env := range environments {
+			for _, as := range files[env] {
+				if !slices.Contains(names, as.Name) {
+					names = append(names, as.Name)
+				}
+			}
+		}
+		slices.Sort(names)
+	}
+
+	rows := make([]matrixRow, 0, len(names))
+	for _, name := range names {
+		row := matrixRow{Variable: name, Values: map[string]string{}, ExpectEqual: slices.Contains(expectEqual, name)}
+		row.Sensitive = redact.SensitiveName(name) || slices.Contains(sensitive, name)
+		var distinct []string
+		group := map[string]int{}
+		for _, env := range environments {
+			as, ok := tfvars.Lookup(files[env], name)
+			if !ok {
+				row.Missing = append(row.Missing, env)
+				continue
+			}
+			row.Values[env] = as.Value
+			row.Sensitive = row.Sensitive || redact.SecretValue(as.Value)
+			i := slices.IndexFunc(distinct, func(v string) bool { return tfvars.Equal(v, as.Value) })
+			if i < 0 {
+				i = len(distinct)
+				distinct = append(distinct, as.Value)
+			}
+			group[env] = i
+		}
+		if row.Sensitive {
+			for env, i := range group {
+				row.Values[env] = fmt.Sprintf("%s #%d", redact.Mask, i+1)
+			}
+		}
+		switch {
+		case len(row.Missing) > 0:
+			row.Status = statusMissing
+		case len(distinct) > 1 && row.ExpectEqual:
+			row.Status = matrixMismatch
+		case len(distinct) > 1:
+			row.Status = matrixDiffers
+		default:
+			row.Status = matrixSame
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func (a *app) reportMatrix(environments []string, rows []matrixRow) error {
+	counts := map[string]int{}
+	var failed []string
+	for _, row := range rows {
+		counts[row.Status]++
+		if row.failed() {
+			failed = append(failed, row.Variable)
+		}
+		a.out.Event("matrix-variable", map[string]any{"variable": row.Variable, "status": row.Status, "expect_equal": row.ExpectEqual, "sensitive": row.Sensitive, "values": row.Values, "missing": row.Missing})
+	}
+	a.out.Event("matrix", map[string]any{"environments": environments, "variables": len(rows), "differ": counts[matrixDiffers], "missing": counts[statusMissing], "mismatched": failed})
+	summary := fmt.Sprintf("%d variable(s) across %d environment(s): %d differ, %d missing somewhere, %d expected to match but don't", len(rows), len(environments), counts[matrixDiffers], counts[statusMissing], len(failed))
+
+	switch {
+	case a.out.markdown:
+		if _, err := io.WriteString(a.out.stdout, matrixMarkdown(environments, rows, summary)); err != nil {
+			return err
+		}
+	case !a.out.json:
+		var table [][]string
+		for _, row := range rows {
+			cells := []string{row.Variable}
+			for _, env := range environments {
+				cells = append(cells, matrixCell(row, env))
+			}
+			table = append(table, append(cells, matrixStatus(row)))
+		}
+		headers := append(append([]string{"VARIABLE"}, environments...), "STATUS")
+		a.out.Table(a.out.humanOut(), headers, table, func(col int, cell string) string {
+			if col != len(headers)-1 {
+				return cell
+			}
+			status, _, _ := strings.Cut(cell, " ")
+			return strings.Replace(cell, status, a.out.statusColor(status), 1)
+		})
+		a.out.Printf("%s\n", summary)
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+	return withCode(exitCheck, fmt.Errorf("%d variable(s) in matrix.expect_equal aren't the same in every environment: %s", len(failed), strings.Join(failed, ", ")))
+}
+
+// matrixCell is the value of a variable in an environment, and - where the environment doesn't set it
+
+func matrixCell(row matrixRow, env string) string {
+	value, ok := row.Values[env]
+	if !ok {
+		return "-"
+	}
+	return shortValue(value)
+}
+
+// shortValue is the first line of a value, cut to fit a table
+
+func shortValue(value string) string {
+	first, _, multiline := strings.Cut(value, "\n")
+	if len(first) > maxMatrixValue {
+		first, multiline = first[:maxMatrixValue-3], true
+	}
+	if multiline {
+		first += "..."
+	}
+	return first
+}
+
+// matrixStatus names the environments a variable is missing from
+
+func matrixStatus(row matrixRow) string {
+	if row.Status == statusMissing {
+		return row.Status + " in " + strings.Join(row.Missing, ",")
+	}
+	return row.Status
+}
+
+// matrixMarkdown renders the report as a table for a wiki page or a pull request, with the statuses that need attention in bold
+
+func matrixMarkdown(environments []string, rows []matrixRow, summary string) string {
+	var b strings.Builder
+	b.WriteString("## tfvars matrix\n\n")
+	b.WriteString("| Variable | " + strings.Join(environments, " | ") + " | Status |\n")
+	b.WriteString("|---" + strings.Repeat("|---", len(environments)) + "|---|\n")
+	for _, row := range rows {
+		fmt.Fprintf(&b, "| `%s` |", row.Variable)
+		for _, env := range environments {
+			if _, ok := row.Values[env]; !ok {
+				b.WriteString(" _missing_ |")
+				continue
+			}
+			fmt.Fprintf(&b, " `%s` |", strings.NewReplacer("|", `\|`, "`", "'").Replace(matrixCell(row, env)))
+		}
+		status := matrixStatus(row)
+		if row.Status == statusMissing || row.failed() {
+			status = "**" + status + "**"
+		}
+		fmt.Fprintf(&b, " %s |\n", status)
+	}
+	fmt.Fprintf(&b, "\n%s.\n", summary)
+	return b.String()
+}
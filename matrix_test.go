@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfvars"
+)
+
+func TestBuildMatrix(t *testing.T) {
+	parse := func(src string) []tfvars.Assignment {
+		as, err := tfvars.Parse([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return as
+	}
+	files := map[string][]tfvars.Assignment{
+		"staging": parse("region = \"us-east-1\"\nsize = \"small\"\ndb_password = \"hunter2\"\ntags = { team = \"infra\" }\n"),
+		"prod":    parse("region = \"us-west-2\"\nsize = \"large\"\ndb_password = \"hunter3\"\ntags = {team=\"infra\"}\nreplicas = 3\n"),
+	}
+	rows := buildMatrix([]string{"staging", "prod"}, files, nil, []string{"region"}, nil)
+	got := map[string]matrixRow{}
+	for _, row := range rows {
+		got[row.Variable] = row
+	}
+	for name, want := range map[string]string{
+		"region":      matrixMismatch,
+		"size":        matrixDiffers,
+		"db_password": matrixDiffers,
+		"tags":        matrixSame,
+		"replicas":    statusMissing,
+	} {
+		if got[name].Status != want {
+			t.Errorf("status of %s = %q, want %q", name, got[name].Status, want)
+		}
+	}
+	if rows[0].Variable != "db_password" || rows[len(rows)-1].Variable != "tags" {
+		t.Errorf("rows aren't sorted by name: %+v", rows)
+	}
+	if !got["region"].failed() || got["size"].failed() {
+		t.Error("only a difference in an expect_equal variable fails the report")
+	}
+	secret := got["db_password"]
+	if !secret.Sensitive || secret.Values["staging"] != "(redacted) #1" || secret.Values["prod"] != "(redacted) #2" {
+		t.Errorf("db_password = %+v, want its values masked", secret)
+	}
+	if m := got["replicas"].Missing; len(m) != 1 || m[0] != "staging" {
+		t.Errorf("replicas missing in %v, want staging", m)
+	}
+
+	rows = buildMatrix([]string{"staging", "prod"}, files, []string{"size", "nope"}, nil, []string{"size"})
+	if len(rows) != 2 || rows[0].Variable != "size" || rows[0].Values["prod"] != "(redacted) #2" || rows[1].Status != statusMissing {
+		t.Errorf("buildMatrix() with --vars = %+v", rows)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	os.WriteFile("tfmanage.yaml", []byte(`environments:
+  staging:
+    tfvars: staging.tfvars
+  prod:
+    tfvars: prod.tfvars
+matrix:
+  expect_equal: [ami_id]
+`), 0o644)
+	os.WriteFile("staging.tfvars", []byte("ami_id = \"ami-1\"\ninstance_type = \"t3.small\"\napi_token = \"abc123\"\n"), 0o644)
+	os.WriteFile("prod.tfvars", []byte("ami_id = \"ami-2\"\ninstance_type = \"m5.large\"\napi_token = \"abc123\"\n"), 0o644)
+	for _, env := range []string{"staging", "prod"} {
+		if err := run([]string{"upload", env}); err != nil {
+			t.Fatalf("upload %s: %v", env, err)
+		}
+	}
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"--output", "json", "matrix"}, &ui{json: true, stdout: &stdout, stderr: io.Discard})
+	if exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "ami_id") {
+		t.Errorf("matrix with a mismatched ami_id: %v, want exit code %d naming it", err, exitCheck)
+	}
+	if strings.Contains(stdout.String(), "abc123") {
+		t.Errorf("the json output reveals a sensitive value:\n%s", stdout.String())
+	}
+	statuses := map[string]string{}
+	for line := range strings.Lines(stdout.String()) {
+		var event map[string]any
+		if json.Unmarshal([]byte(line), &event); event["event"] == "matrix-variable" {
+			statuses[event["variable"].(string)] = event["status"].(string)
+		}
+	}
+	if statuses["ami_id"] != matrixMismatch || statuses["instance_type"] != matrixDiffers || statuses["api_token"] != matrixSame {
+		t.Errorf("statuses = %v", statuses)
+	}
+
+	stdout.Reset()
+	err = runWithUI([]string{"--output", "markdown", "matrix", "--local", "--vars", "instance_type"}, &ui{markdown: true, stdout: &stdout, stderr: io.Discard})
+	if err != nil {
+		t.Errorf("matrix --vars instance_type: %v", err)
+	}
+	for _, want := range []string{"| Variable | prod | staging | Status |", "| `instance_type` | `\"m5.large\"` | `\"t3.small\"` | differs |"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("markdown output doesn't have %q:\n%s", want, stdout.String())
+		}
+	}
+
+	if err := run([]string{"matrix", "staging", "nowhere"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("matrix with an unknown environment: %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfvars"
+)
+
+// merge-remote - when an upload is refused because someone else uploaded in between, merge their changes into the local file variable by variable, from the base recorded by the last download
+
+func mergeRemoteCommand() *command {
+	return &command{
+		name:    "merge-remote",
+		args:    "<env>",
+		summary: "Merge what was uploaded since the last download into the local tfvars, variable by variable. Exits 1 when variables conflict.",
+		examples: []string{
+			"tfmanage merge-remote prod",
+			"tfmanage merge-remote prod --out merged.tfvars",
+			"tfmanage merge-remote prod --upload -m \"Merge the replica change\"",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			outFile := fs.String("out", "", "write the merged file here instead of over the local tfvars")
+			upload := fs.Bool("upload", false, "upload a clean merge without asking")
+			message := fs.String("m", "", "the change message of the upload after a clean merge")
+			return func(ctx context.Context, a *app, args []string) error {
+				return a.mergeRemote(ctx, args[0], *outFile, *upload, *message)
+			}
+		},
+	}
+}
+
+func (a *app) mergeRemote(ctx context.Context, environment, outFile string, upload bool, message string) error {
+	fileName, err := a.tfvarsFor(environment)
+	if err != nil {
+		return err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	if err := requirementsError("merge-remote", checkStoreRequirements("download", environment, s)); err != nil {
+		return err
+	}
+	loc, err := a.locate(ctx, s, environment, fileName)
+	if err != nil {
+		return err
+	}
+	location := loc.url(loc.key)
+	base, ok, err := readSyncState(environment, fileName, location)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return usageError("no base revision of %s is recorded, it was never downloaded from %s here - keep a copy of your edits, download %s and make them again", fileName, location, environment)
+	}
+	if base.VersionID == "" {
+		return configError("%s keeps no versions, so the base revision %s can't be read back to merge from", location, base.ETag)
+	}
+	local, err := os.ReadFile(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", storage.ErrLocalFileMissing, fileName)
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.lockEnvironment(environment, "merge-remote"); err != nil {
+		return err
+	}
+
+	remote, err := loc.store.Head(ctx, loc.key)
+	if err != nil {
+		return err
+	}
+	if remote.ETag == base.ETag {
+		a.out.Event("merge-remote", map[string]any{"environment": environment, "file": fileName, "base_version": base.VersionID, "remote_version": remote.VersionID, "merged": false, "conflicts": []string{}})
+		a.out.Successf("%s hasn't changed since %s was downloaded, there is nothing to merge", location, fileName)
+		return nil
+	}
+	remoteData, err := storage.GetVersionBytes(ctx, loc.store, loc.key, remote.VersionID)
+	if err != nil {
+		return err
+	}
+	baseData, err := storage.GetVersionBytes(ctx, loc.store, loc.key, base.VersionID)
+	if err != nil {
+		return fmt.Errorf("reading the base revision %s of %s: %w", base.VersionID, location, err)
+	}
+	a.out.Verbosef("Merging %s (version %s) into %s from version %s\n", location, remote.VersionID, fileName, base.VersionID)
+	result, err := tfvars.MergeFile(fileName, baseData, local, remoteData, tfvars.Labels{Local: fileName, Remote: location + " (" + cmp.Or(remote.VersionID, remote.ETag) + ")"})
+	if err != nil {
+		return configError("can't merge %s: %v", fileName, err)
+	}
+
+	out := cmp.Or(outFile, fileName)
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(fileName); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(out, result.Data, mode); err != nil {
+		return err
+	}
+	if out == fileName {
+		// the local file has the remote's changes now, so the remote is the base
+		sum := sha256.Sum256(remoteData)
+		a.recordSync(environment, fileName, location, remote.ETag, remote.VersionID, hex.EncodeToString(sum[:]))
+	}
+	conflicts := result.Conflicts
+	if conflicts == nil {
+		conflicts = []string{}
+	}
+	a.out.Event("merge-remote", map[string]any{"environment": environment, "file": out, "base_version": base.VersionID, "remote_version": remote.VersionID, "merged": true, "conflicts": conflicts})
+
+	if len(conflicts) > 0 {
+		for _, name := range conflicts {
+			a.out.Printf("  conflict: %s\n", name)
+		}
+		return withCode(exitGeneric, fmt.Errorf("%d variable(s) changed both in %s and in %s: %s - pick a value between the conflict markers in %s, then upload %s", len(conflicts), fileName, location, strings.Join(conflicts, ", "), out, environment))
+	}
+	a.out.Successf("Merged the changes of %s into %s", location, out)
+	if out != fileName {
+		a.out.Printf("Copy it over %s and upload it with --base-etag %s\n", fileName, remote.ETag)
+		return nil
+	}
+	if !upload {
+		if upload, err = a.askYesNo(fmt.Sprintf("Upload the merged %s now?", fileName)); err != nil {
+			return err
+		}
+	}
+	if !upload {
+		a.out.Printf("Upload it with: tfmanage upload %s\n", environment)
+		return nil
+	}
+	return a.upload(ctx, environment, uploadRequest{message: message, baseETag: remote.ETag})
+}
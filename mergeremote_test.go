@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+func TestMergeRemote(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	ctx := context.Background()
+
+	os.WriteFile("dev.tfvars", []byte("replicas = 2\nsize = \"small\"\n"), 0o644)
+	if err := run([]string{"upload", "dev"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	// someone else changes the replicas while the size is changed here
+	storage.PutBytes(ctx, store, "team/dev.tfvars", []byte("replicas = 4\nsize = \"small\"\n"))
+	os.WriteFile("dev.tfvars", []byte("replicas = 2\nsize = \"large\" # for the sale\n"), 0o644)
+	if err := run([]string{"upload", "dev"}); exitCodeFor(err) != exitCheck {
+		t.Fatalf("upload: %v, want a refusal", err)
+	}
+
+	var stderr strings.Builder
+	if err := runWithUI([]string{"merge-remote", "dev", "--out", "merged.tfvars"}, &ui{stdout: io.Discard, stderr: &stderr}); err != nil {
+		t.Fatalf("merge-remote --out: %v", err)
+	}
+	want := "replicas = 4\nsize = \"large\" # for the sale\n"
+	if data, _ := os.ReadFile("merged.tfvars"); string(data) != want {
+		t.Errorf("merged.tfvars = %q, want %q", data, want)
+	}
+	if data, _ := os.ReadFile("dev.tfvars"); strings.HasPrefix(string(data), "replicas = 4") {
+		t.Error("merge-remote --out changed the local file")
+	}
+
+	// answering yes uploads the merge
+	if err := runWithUI([]string{"merge-remote", "dev"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader("y\n")}); err != nil {
+		t.Fatalf("merge-remote: %v", err)
+	}
+	if data, _ := store.Bytes("team/dev.tfvars"); string(data) != want {
+		t.Errorf("the merge uploaded %q, want %q", data, want)
+	}
+
+	if err := run([]string{"merge-remote", "dev"}); err != nil {
+		t.Errorf("merge-remote with nothing to merge: %v", err)
+	}
+}
+
+func TestMergeRemoteConflicts(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	os.WriteFile("dev.tfvars", []byte("replicas = 2\n"), 0o644)
+	if err := run([]string{"upload", "dev"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	storage.PutBytes(context.Background(), store, "team/dev.tfvars", []byte("replicas = 4\n"))
+	os.WriteFile("dev.tfvars", []byte("replicas = 3\n"), 0o644)
+
+	err := run([]string{"merge-remote", "dev", "--upload"})
+	if exitCodeFor(err) != exitGeneric || !strings.Contains(err.Error(), "replicas") {
+		t.Fatalf("merge-remote: %v, want exit 1 naming the conflict", err)
+	}
+	if data, _ := os.ReadFile("dev.tfvars"); !strings.Contains(string(data), "<<<<<<< dev.tfvars\nreplicas = 3\n=======\nreplicas = 4\n>>>>>>> ") {
+		t.Errorf("dev.tfvars =\n%s\nwant conflict markers", data)
+	}
+	if data, _ := store.Bytes("team/dev.tfvars"); string(data) != "replicas = 4\n" {
+		t.Errorf("a merge with conflicts was uploaded: %q", data)
+	}
+
+	// resolved by hand, the upload goes on top of their version
+	os.WriteFile("dev.tfvars", []byte("replicas = 5\n"), 0o644)
+	if err := run([]string{"upload", "dev"}); err != nil {
+		t.Errorf("upload after resolving: %v", err)
+	}
+}
+
+func TestMergeRemoteWithoutBase(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	os.WriteFile("dev.tfvars", []byte("replicas = 2\n"), 0o644)
+	if err := run([]string{"merge-remote", "dev"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("merge-remote without a download: %v, want a usage error", err)
+	}
+}
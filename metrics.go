@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/metrics"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+)
+
+// metrics - each operation on an environment pushes how long it took, whether it worked and what its plan would change to a Prometheus Pushgateway. Only the environment, the operation, the host and the plan action are ever labels, never anything from the tfvars or the credentials
+
+// pushgatewayURL is --pushgateway-url, TFMANAGE_PUSHGATEWAY_URL or metrics.pushgateway_url, empty when metrics are off
+
+func (a *app) pushgatewayURL() string {
+	if a.global.pushgatewayURL != "" {
+		return a.global.pushgatewayURL
+	}
+	if a.settings != nil {
+		return a.settings.Pushgateway
+	}
+	if s, err := a.loadSettings(); err == nil {
+		return s.Pushgateway
+	}
+	return ""
+}
+
+func (a *app) metricsJob() string {
+	if a.global.metricsJob != "" {
+		return a.global.metricsJob
+	}
+	if a.settings != nil && a.settings.MetricsJob != "" {
+		return a.settings.MetricsJob
+	}
+	return metrics.DefaultJob
+}
+
+// unsafeLabelChars is anything that has no business in an environment or host name
+var unsafeLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// metricsLabel keeps a label value short and plain, and drops it altogether when it looks like a credential
+
+func metricsLabel(value string) string {
+	if redact.SecretValue(value) {
+		return "redacted"
+	}
+	value = unsafeLabelChars.ReplaceAllString(value, "_")
+	if len(value) > 64 {
+		value = value[:64]
+	}
+	return value
+}
+
+// pushMetrics is the last thing a run does, failing to push only warns since the operation itself is done by then
+
+func (a *app) pushMetrics(ctx context.Context, operation string, took time.Duration, err error) {
+	if a.environment == "" {
+		return
+	}
+	url := a.pushgatewayURL()
+	if url == "" {
+		return
+	}
+	instance, _ := os.Hostname()
+	success := 0.0
+	if code := exitCodeFor(err); code == exitOK || code == exitPlanChanges {
+		success = 1
+	}
+	gauges := []metrics.Gauge{
+		{Name: "tfmanage_operation_duration_seconds", Help: "How long the last run of the operation took.", Value: took.Seconds()},
+		{Name: "tfmanage_operation_success", Help: "1 when the last run of the operation worked, 0 when it failed.", Value: success},
+	}
+	if c := a.planChanges; c != nil {
+		for _, change := range []struct {
+			action string
+			count  int
+		}{{"add", c.Add}, {"change", c.Change}, {"destroy", c.Destroy}} {
+			gauges = append(gauges, metrics.Gauge{Name: "tfmanage_plan_resource_changes", Help: "Resources the last plan would add, change or destroy.", Labels: map[string]string{"action": change.action}, Value: float64(change.count)})
+		}
+	}
+	grouping := [][2]string{
+		{"environment", metricsLabel(a.environment)},
+		{"operation", metricsLabel(operation)},
+		{"instance", metricsLabel(instance)},
+	}
+	// the run may have been cancelled by a signal, the metrics of it are still worth having
+	ctx = context.WithoutCancel(ctx)
+	if err := metrics.Push(ctx, url, metricsLabel(a.metricsJob()), grouping, gauges); err != nil {
+		a.out.Warnf("Could not push the metrics: %v", err)
+		return
+	}
+	a.out.Verbosef("Pushed the metrics of %s %s\n", operation, a.environment)
+}
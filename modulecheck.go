@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// the checks of the terraform configuration itself before a plan - terraform fmt -check and terraform validate, so badly formatted or invalid code fails in seconds instead of after a whole plan. Neither needs the backend or AWS, so they run before anything else does
+
+// errNotFormatted and errInvalidConfiguration are returned when the checks failed - what they found has been printed already
+
+var (
+	errNotFormatted         = withCode(exitCheck, errors.New("the terraform files aren't formatted, run terraform fmt -recursive"))
+	errInvalidConfiguration = withCode(exitCheck, errors.New("terraform validate found errors, fix them before planning"))
+)
+
+// moduleChecks are the checks to run, --preflight turns on both
+
+type moduleChecks struct {
+	fmt      bool
+	validate bool
+}
+
+// runModuleChecks runs the checks in dir, each failing one stops the plan
+
+func runModuleChecks(ctx context.Context, a *app, checks moduleChecks, dir string) error {
+	if checks.fmt {
+		if err := checkFormatting(ctx, a, dir); err != nil {
+			return err
+		}
+	}
+	if checks.validate {
+		if err := validateConfiguration(ctx, a, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkFormatting(ctx context.Context, a *app, dir string) error {
+	a.out.Printf("Checking the formatting with terraform fmt...\n")
+	a.out.Verbosef("Running terraform %v\n", tfexec.FmtCheckArgs(dir))
+	files, err := tfexec.FmtCheck(ctx, runner, dir, a.terraformOutput())
+	if err != nil {
+		return err
+	}
+	a.out.Event("fmt-check", map[string]any{"dir": dir, "files": files})
+	if len(files) == 0 {
+		a.out.Successf("The terraform files are formatted")
+		return nil
+	}
+	a.out.Printf("%s not formatted:\n", plural(len(files), "file"))
+	for _, f := range files {
+		a.out.Finding("error", inDir(dir, f), 0, "not formatted, run terraform fmt")
+	}
+	return errNotFormatted
+}
+
+func validateConfiguration(ctx context.Context, a *app, dir string) error {
+	a.out.Printf("Validating with terraform validate...\n")
+	a.out.Verbosef("Running terraform %v\n", tfexec.ValidateArgs(dir))
+	valid, diags, err := tfexec.Validate(ctx, runner, dir, a.terraformOutput())
+	if err != nil {
+		return err
+	}
+	for i := range diags {
+		if diags[i].File != "" {
+			diags[i].File = inDir(dir, diags[i].File)
+		}
+	}
+	a.out.Event("validate", map[string]any{"dir": dir, "valid": valid, "diagnostics": diags})
+	for _, d := range diags {
+		a.out.Finding(d.Severity, d.File, d.Line, d.Message())
+	}
+	if !valid {
+		return errInvalidConfiguration
+	}
+	a.out.Successf("The configuration is valid")
+	return nil
+}
+
+// inDir is a path terraform gave relative to dir, relative to where tfmanage runs instead so annotations land on the right file
+
+func inDir(dir, file string) string {
+	if dir == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(dir, file)
+}
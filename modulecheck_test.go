@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+const invalidConfiguration = `{"valid":false,"diagnostics":[{"severity":"error","summary":"Reference to undeclared input variable","detail":"An input variable with the name \"regoin\" has not been declared.","range":{"filename":"main.tf","start":{"line":7}}}]}`
+
+func withModuleCheckRunner(t *testing.T, fmtOutput, validateOutput string) *tfexec.RecordingRunner {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{
+		OutputFor: func(args []string) string {
+			switch {
+			case slices.Contains(args, "fmt"):
+				return fmtOutput
+			case slices.Contains(args, "validate"):
+				return validateOutput
+			}
+			return ""
+		},
+		Result: func(args []string) error {
+			if slices.Contains(args, "fmt") && fmtOutput != "" || slices.Contains(args, "validate") && strings.Contains(validateOutput, `"valid":false`) {
+				return &tfexec.FakeExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	useRunner(t, rec)
+	withTFVars(t, "dev")
+	return rec
+}
+
+func TestPreflightStopsThePlan(t *testing.T) {
+	rec := withModuleCheckRunner(t, "", invalidConfiguration)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"plan", "dev", "plan.out", "--preflight", "--chdir", "infra", "--github"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if !errors.Is(err, errInvalidConfiguration) || exitCodeFor(err) != exitCheck {
+		t.Fatalf("plan: %v, want the validate failure", err)
+	}
+	want := [][]string{tfexec.FmtCheckArgs("infra"), tfexec.ValidateArgs("infra")}
+	if got := rec.Args(); len(got) != 2 || !slices.Equal(got[0], want[0]) || !slices.Equal(got[1], want[1]) {
+		t.Errorf("terraform ran %q, want only fmt and validate", got)
+	}
+	for _, want := range []string{"The terraform files are formatted", "infra/main.tf:7: Reference to undeclared input variable", "::error file=infra/main.tf,line=7::Reference to undeclared input variable"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("output is missing %q:\n%s", want, stdout.String())
+		}
+	}
+}
+
+func TestFmtCheckOnItsOwn(t *testing.T) {
+	rec := withModuleCheckRunner(t, "main.tf\n", "")
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"plan", "dev", "plan.out", "--fmt-check"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if !errors.Is(err, errNotFormatted) {
+		t.Fatalf("plan: %v, want the fmt failure", err)
+	}
+	if len(rec.Calls) != 1 || !strings.Contains(stdout.String(), "main.tf: not formatted") {
+		t.Errorf("terraform ran %q, output:\n%s", rec.Args(), stdout.String())
+	}
+}
+
+func TestValidateHook(t *testing.T) {
+	rec := withModuleCheckRunner(t, "", `{"valid":true,"diagnostics":[]}`)
+	os.WriteFile("tfmanage.yaml", []byte("hooks:\n  validate: true\n"), 0o644)
+
+	if err := run([]string{"plan", "dev", "plan.out"}); err != nil {
+		t.Fatalf("plan with hooks.validate: %v", err)
+	}
+	if got := rec.Args(); len(got) < 2 || !slices.Contains(got[0], "validate") || !slices.Contains(got[1], "plan") {
+		t.Errorf("terraform ran %q, want validate then plan", got)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// modules update - terraform get -update and init -upgrade the same way every time, with what moved in the lock file and the module installs summed up. The backend is left as init set it up, -backend=false, so nothing here touches the state
+
+// errUpdatesAvailable is modules update --check's answer, exit code 2 like a plan with changes so a scheduled job can tell it from a failure
+
+var errUpdatesAvailable = withCode(exitPlanChanges, errors.New("module or provider updates are available"))
+
+func modulesCommand() *command {
+	return &command{
+		name:    "modules",
+		args:    "update <env>",
+		summary: "Update the modules and providers to the newest versions allowed, and sum up what changed. Exits 2 with --check when there are updates.",
+		examples: []string{
+			"tfmanage modules update dev",
+			"tfmanage modules update prod --sync-lockfile",
+			"tfmanage modules update prod --check",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			check := fs.Bool("check", false, "only say whether there are updates, exit 2 when there are, without changing the lock file or the installed modules")
+			syncLockfile := fs.Bool("sync-lockfile", false, "start from the environment's canonical lock file and upload the updated one as the new one")
+			return func(ctx context.Context, a *app, args []string) error {
+				if args[0] != "update" {
+					return usageError("unknown modules subcommand %q, the only one is update", args[0])
+				}
+				if *check && *syncLockfile {
+					return usageError("--check doesn't change the lock file, it can't be used with --sync-lockfile")
+				}
+				env := args[1]
+				if err := a.checkEnvironment(env); err != nil {
+					return err
+				}
+				if !*check {
+					if err := a.lockEnvironment(env, "modules update"); err != nil {
+						return err
+					}
+				}
+				dir := a.useEnvironment(env, *chdir)
+				if err := a.useTerraformCredentials(ctx, env); err != nil {
+					return err
+				}
+				if *syncLockfile {
+					if err := downloadLockfile(ctx, a, env, dir, false); err != nil {
+						return err
+					}
+				}
+				if *check {
+					return checkModuleUpdates(ctx, a, env, dir)
+				}
+				if err := updateModules(ctx, a, env, dir); err != nil {
+					return err
+				}
+				if *syncLockfile {
+					return uploadLockfile(ctx, a, env, dir, false)
+				}
+				return nil
+			}
+		},
+	}
+}
+
+// moduleUpdates is what an update changed, or would change
+
+type moduleUpdates struct {
+	providers []tfexec.LockChange
+	modules   []tfexec.ModuleChange
+}
+
+// any leaves out lock file changes that are only more package hashes, those come from the platform init ran on rather than from an update
+
+func (u moduleUpdates) any() bool {
+	for _, c := range u.providers {
+		if c.From.Version != c.To.Version {
+			return true
+		}
+	}
+	return len(u.modules) > 0
+}
+
+func (a *app) printModuleUpdates(env string, u moduleUpdates, check bool) {
+	modules := make([]string, 0, len(u.modules))
+	for _, c := range u.modules {
+		modules = append(modules, c.String())
+	}
+	a.out.Event("modules-update", map[string]any{"environment": env, "check": check, "providers": lockChangeStrings(u.providers), "modules": modules, "updates": u.any()})
+	a.printLockChanges(u.providers)
+	if len(modules) == 0 {
+		a.out.Printf("No module changes\n")
+		return
+	}
+	a.out.Printf("Module changes:\n")
+	for _, m := range modules {
+		a.out.Printf("  %s\n", m)
+	}
+}
+
+// updateModules runs terraform get -update and init -upgrade in dir and sums up the changes
+
+func updateModules(ctx context.Context, a *app, env, dir string) error {
+	lockFile := filepath.Join(cmp.Or(dir, "."), tfexec.LockFileName)
+	providersBefore := readLockProviders(lockFile)
+	modulesBefore, err := tfexec.ReadModules(tfexec.DataDir(dir))
+	if err != nil {
+		return err
+	}
+
+	run := a.streamOutput()
+	a.out.Verbosef("Running terraform %v\n", tfexec.GetArgs(dir, true))
+	if err := tfexec.Get(ctx, runner, dir, true, run); err != nil {
+		return err
+	}
+	opts := tfexec.InitOptions{Chdir: dir, Upgrade: true, SkipBackend: true, NoColor: !a.out.color}
+	a.out.Verbosef("Running terraform %v\n", tfexec.InitArgs(opts))
+	if err := tfexec.Init(ctx, runner, opts, run); err != nil {
+		return err
+	}
+
+	modulesAfter, err := tfexec.ReadModules(tfexec.DataDir(dir))
+	if err != nil {
+		return err
+	}
+	updates := moduleUpdates{
+		providers: tfexec.DiffLockFiles(providersBefore, readLockProviders(lockFile)),
+		modules:   tfexec.DiffModules(modulesBefore, modulesAfter),
+	}
+	a.printModuleUpdates(env, updates, false)
+	if updates.any() {
+		a.out.Successf("Updated the modules and providers of %s", env)
+	} else {
+		a.out.Successf("The modules and providers of %s were already up to date", env)
+	}
+	return nil
+}
+
+// checkModuleUpdates runs init -upgrade into a scratch data directory and puts the lock file back afterwards, so what is installed and locked stays as it was
+
+func checkModuleUpdates(ctx context.Context, a *app, env, dir string) (err error) {
+	lockFile := filepath.Join(cmp.Or(dir, "."), tfexec.LockFileName)
+	locked, readErr := os.ReadFile(lockFile)
+	if readErr != nil && !errors.Is(readErr, fs.ErrNotExist) {
+		return readErr
+	}
+	modulesBefore, err := tfexec.ReadModules(tfexec.DataDir(dir))
+	if err != nil {
+		return err
+	}
+	scratch, err := os.MkdirTemp("", "tfmanage-modules-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+	defer func() {
+		restoreErr := os.Remove(lockFile)
+		if readErr == nil {
+			restoreErr = os.WriteFile(lockFile, locked, 0o644)
+		} else if errors.Is(restoreErr, fs.ErrNotExist) {
+			restoreErr = nil
+		}
+		if restoreErr != nil && err == nil {
+			err = fmt.Errorf("failed to put %s back: %w", lockFile, restoreErr)
+		}
+	}()
+
+	run := a.streamOutput()
+	// appended last so it wins over a TF_DATA_DIR of the environment
+	run.Env = append(run.Env, "TF_DATA_DIR="+scratch)
+	opts := tfexec.InitOptions{Chdir: dir, Upgrade: true, SkipBackend: true, NoColor: !a.out.color}
+	a.out.Verbosef("Running terraform %v with TF_DATA_DIR=%s\n", tfexec.InitArgs(opts), scratch)
+	if err := tfexec.Init(ctx, runner, opts, run); err != nil {
+		return err
+	}
+	modulesAfter, err := tfexec.ReadModules(scratch)
+	if err != nil {
+		return err
+	}
+	var providersBefore []tfexec.LockedProvider
+	if readErr == nil {
+		providersBefore, _ = tfexec.ParseLockFile(locked)
+	}
+	updates := moduleUpdates{
+		providers: tfexec.DiffLockFiles(providersBefore, readLockProviders(lockFile)),
+		modules:   tfexec.DiffModules(modulesBefore, modulesAfter),
+	}
+	a.printModuleUpdates(env, updates, true)
+	if !updates.any() {
+		a.out.Successf("The modules and providers of %s are up to date", env)
+		return nil
+	}
+	a.out.Printf("Run tfmanage modules update %s to install them\n", env)
+	return errUpdatesAvailable
+}
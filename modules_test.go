@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func vpcModule(version string) string {
+	return `{"Modules":[{"Key":"","Source":"","Dir":"."},{"Key":"vpc","Source":"registry.terraform.io/terraform-aws-modules/vpc/aws","Version":"` + version + `","Dir":".terraform/modules/vpc"}]}`
+}
+
+// withModuleUpgrade has terraform init -upgrade lock aws 5.31.0 and install vpc 5.2.0, in TF_DATA_DIR when it is set
+
+func withModuleUpgrade(t *testing.T) *tfexec.RecordingRunner {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{}
+	rec.Result = func(args []string) error {
+		if !slices.Contains(args, "init") {
+			return nil
+		}
+		dataDir := ".terraform"
+		for _, e := range rec.Calls[len(rec.Calls)-1].Opts.Env {
+			if dir, ok := strings.CutPrefix(e, "TF_DATA_DIR="); ok {
+				dataDir = dir
+			}
+		}
+		os.MkdirAll(filepath.Join(dataDir, "modules"), 0o755)
+		os.WriteFile(filepath.Join(dataDir, "modules", "modules.json"), []byte(vpcModule("5.2.0")), 0o644)
+		return os.WriteFile(tfexec.LockFileName, []byte(lockFileFor("5.31.0")), 0o644)
+	}
+	useRunner(t, rec)
+	withTFVars(t, "prod")
+	os.WriteFile(tfexec.LockFileName, []byte(lockFileFor("5.30.0")), 0o644)
+	os.MkdirAll(".terraform/modules", 0o755)
+	os.WriteFile(".terraform/modules/modules.json", []byte(vpcModule("5.1.1")), 0o644)
+	return rec
+}
+
+func TestModulesUpdateCheck(t *testing.T) {
+	rec := withModuleUpgrade(t)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"modules", "update", "prod", "--check"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if !errors.Is(err, errUpdatesAvailable) || exitCodeFor(err) != exitPlanChanges {
+		t.Fatalf("modules update --check: %v, want updates", err)
+	}
+	for _, want := range []string{"~ hashicorp/aws 5.30.0 -> 5.31.0", "~ module.vpc terraform-aws-modules/vpc/aws 5.1.1 -> 5.2.0"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("output is missing %q:\n%s", want, stdout.String())
+		}
+	}
+	if got := rec.Args(); len(got) != 1 || !slices.Equal(got[0], []string{"init", "-input=false", "-upgrade", "-backend=false", "-no-color"}) {
+		t.Errorf("terraform ran %q", got)
+	}
+	// nothing changed
+	if data, _ := os.ReadFile(tfexec.LockFileName); string(data) != lockFileFor("5.30.0") {
+		t.Errorf("the lock file wasn't put back: %q", data)
+	}
+	if data, _ := os.ReadFile(".terraform/modules/modules.json"); string(data) != vpcModule("5.1.1") {
+		t.Errorf("the installed modules changed: %s", data)
+	}
+}
+
+func TestModulesUpdate(t *testing.T) {
+	rec := withModuleUpgrade(t)
+	store := withMemoryStore(t)
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"modules", "update", "prod", "--sync-lockfile"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("modules update: %v", err)
+	}
+	want := [][]string{{"get", "-update"}, {"init", "-input=false", "-upgrade", "-backend=false", "-no-color"}}
+	if got := rec.Args(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("terraform ran %q, want %q", got, want)
+	}
+	if !strings.Contains(stdout.String(), "~ module.vpc terraform-aws-modules/vpc/aws 5.1.1 -> 5.2.0") {
+		t.Errorf("output:\n%s", stdout.String())
+	}
+	data, err := storage.GetBytes(context.Background(), store, "team/files/prod/.terraform.lock.hcl")
+	if err != nil || string(data) != lockFileFor("5.31.0") {
+		t.Errorf("stored lock file = %q, %v", data, err)
+	}
+
+	// once it is up to date there is nothing to report
+	if err := run([]string{"modules", "update", "prod", "--check"}); err != nil {
+		t.Errorf("modules update --check when up to date: %v", err)
+	}
+}
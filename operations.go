@@ -0,0 +1,984 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/ghactions"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/plansummary"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+// The four original operations - upload and download move the tfvars to and from S3, plan and apply run terraform with them
+
+func uploadCommand() *command {
+	return &command{
+		name:     "upload",
+		args:     "<env>",
+		summary:  "Upload the environment's tfvars file to S3.",
+		examples: []string{"tfmanage upload dev", "tfmanage upload prod -m \"Scale the web tier to 4 instances\"", "tfmanage upload prod --force", "tfmanage upload dev --skip-unchanged", "tfmanage upload prod --base-etag 9b2cf535f27731c974343645a3985328", "tfmanage upload prod --allow-dirty", "tfmanage upload dev --strict", "tfmanage upload dev --allow-cross-account-bucket"},
+		minArgs:  1,
+		maxArgs:  1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			force := fs.Bool("force", false, "upload even when the remote file has changed since it was downloaded")
+			skipUnchanged := fs.Bool("skip-unchanged", false, "don't upload when the remote file already has the same content")
+			baseETag := fs.String("base-etag", "", "the ETag the remote file has to still have, instead of the one recorded by the last download")
+			allowDirty := fs.Bool("allow-dirty", false, "upload even when the environment requires a clean git checkout and the file has uncommitted changes")
+			allowPublic := fs.Bool("allow-public-bucket", false, "upload even when the bucket allows public access")
+			allowCrossAccount := fs.Bool("allow-cross-account-bucket", false, "upload even when the bucket isn't owned by expected_bucket_account or the account of the AWS credentials")
+			strict := fs.Bool("strict", false, "fail when the bucket's owner or public access settings can't be checked, instead of warning")
+			contentType := fs.String("content-type", "", "the Content-Type the object is stored with (default from the file name, text/plain for .tfvars and application/json for .tfvars.json)")
+			message := fs.String("m", "", "the change message saying why the tfvars changed, kept in the object metadata and the change journal")
+			ci := fs.Bool("ci", false, "running from a pipeline: without -m the message comes from "+changeMessageEnv+" or the commit subject")
+			storageClass := fs.String("storage-class", "", "the S3 storage class of the tfvars: "+strings.Join(storage.StorageClasses, ", ")+" (default STANDARD)")
+			return func(ctx context.Context, a *app, args []string) error {
+				return a.upload(ctx, args[0], uploadRequest{force: *force, skipUnchanged: *skipUnchanged, allowDirty: *allowDirty, allowPublic: *allowPublic, allowCrossAccount: *allowCrossAccount, strict: *strict, contentType: *contentType, message: *message, ci: *ci, baseETag: *baseETag, storageClass: *storageClass})
+			}
+		},
+	}
+}
+
+// uploadRequest is the flags of upload, merge-remote uploads with them too
+
+type uploadRequest struct {
+	force, skipUnchanged, allowDirty, allowPublic, allowCrossAccount, strict, ci bool
+	contentType, message, baseETag                                               string
+	storageClass                                                                 string
+}
+
+func (a *app) upload(ctx context.Context, environment string, req uploadRequest) error {
+	fileName, err := a.prepare("upload", environment)
+	if err != nil {
+		return err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	msg, err := changeMessage(ctx, s, environment, fileName, req.message, req.ci)
+	if err != nil {
+		return err
+	}
+	if err := a.lockEnvironment(environment, "upload"); err != nil {
+		return err
+	}
+	git := gitinfo.File(ctx, fileName)
+	if git.Dirty && !req.allowDirty && a.requireCleanGit(environment) {
+		return usageError("%s has changes that aren't committed, commit them or pass --allow-dirty to upload it anyway", fileName)
+	}
+	if req.contentType != "" {
+		if _, _, err := mime.ParseMediaType(req.contentType); err != nil {
+			return usageError("invalid --content-type %q: %v", req.contentType, err)
+		}
+	}
+	if err := checkStorageClass(req.storageClass); err != nil {
+		return err
+	}
+	return uploadTFVars(ctx, a, environment, fileName, git, msg, req.force, storage.UploadOptions{SkipUnchanged: req.skipUnchanged, ContentType: req.contentType, IfMatch: quoteETag(req.baseETag), StorageClass: req.storageClass}, bucketCheck{allowPublic: req.allowPublic, allowCrossAccount: req.allowCrossAccount, strict: req.strict})
+}
+
+func downloadCommand() *command {
+	return &command{
+		name:     "download",
+		args:     "<env>",
+		summary:  "Download the environment's tfvars file from S3, replacing the local copy.",
+		examples: []string{"tfmanage download staging", "tfmanage download prod --cache", "tfmanage download prod --backup", "tfmanage download prod --ci", "tfmanage download old --restore --restore-tier Expedited --wait"},
+		minArgs:  1,
+		maxArgs:  1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			toCache := fs.Bool("cache", false, "download into the tfmanage cache directory for plan and apply --use-cache, instead of the tfvars path")
+			force := fs.Bool("force", false, "replace the local file even when it has changes that aren't uploaded")
+			backup := fs.Bool("backup", false, "keep a copy of a local file with changes as <file>.<time>.backup before replacing it")
+			ci := fs.Bool("ci", false, "running from a pipeline: never ask, refuse to replace a file tfmanage didn't download")
+			restore := addRestoreFlags(fs)
+			return func(ctx context.Context, a *app, args []string) error {
+				if err := restore.check(); err != nil {
+					return err
+				}
+				if *toCache {
+					fileName, err := a.tfvarsFor(args[0])
+					if err != nil {
+						return err
+					}
+					s, err := a.loadSettings()
+					if err != nil {
+						return err
+					}
+					if err := requirementsError("download", checkStoreRequirements("download", args[0], s)); err != nil {
+						return err
+					}
+					return downloadToCache(ctx, a, args[0], fileName)
+				}
+				fileName, err := a.prepare("download", args[0])
+				if err != nil {
+					return err
+				}
+				if err := a.lockEnvironment(args[0], "download"); err != nil {
+					return err
+				}
+				return downloadTFVars(ctx, a, args[0], fileName, downloadOptions{force: *force, backup: *backup, ci: *ci, restore: *restore})
+			}
+		},
+	}
+}
+
+func planCommand() *command {
+	return &command{
+		name:    "plan",
+		args:    "<env> [plan-file]",
+		summary: "Run terraform plan with the environment's tfvars and save the plan, under plan_dir when no plan file is given. Exits 2 when the plan has changes.",
+		examples: []string{
+			"tfmanage plan dev",
+			"tfmanage plan dev plan.out",
+			"tfmanage plan prod prod.tfplan --target module.network",
+			"tfmanage plan staging destroy.tfplan --destroy",
+			"tfmanage plan prod prod.tfplan --output markdown --out-file plan.md",
+			"tfmanage plan dev plan.out --lint",
+			"tfmanage plan dev --preflight",
+			"tfmanage plan prod prod.tfplan --store-plan",
+		},
+		markdown: true,
+		minArgs:  1,
+		maxArgs:  2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			var targets stringList
+			fs.Var(&targets, "target", "limit the plan to this resource address (repeatable)")
+			destroy := fs.Bool("destroy", false, "plan to destroy everything")
+			refreshOnly := fs.Bool("refresh-only", false, "only plan to update the state to match remote objects")
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			outFile := fs.String("out-file", "", "write the --output markdown report to this file instead of stdout")
+			cost := fs.Bool("cost", false, "price the plan with infracost (hooks.cost in the config does the same)")
+			lint := fs.Bool("lint", false, "run tflint first and stop on errors (hooks.lint in the config does the same)")
+			lintStrict := fs.Bool("lint-strict", false, "with --lint, stop on tflint warnings too")
+			preflight := fs.Bool("preflight", false, "run terraform fmt -check and terraform validate first and stop on what they find, before any backend or AWS call")
+			fmtCheck := fs.Bool("fmt-check", false, "run terraform fmt -check first (hooks.fmt_check in the config does the same)")
+			validate := fs.Bool("validate", false, "run terraform validate first (hooks.validate in the config does the same)")
+			store := fs.Bool("store-plan", false, "upload the plan to plans/<env>/ in the bucket with its metadata, for show and plan-diff")
+			allowPlaintext := fs.Bool("allow-plaintext-plan", false, "with --store-plan, store the plan of a protected environment without a KMS key unencrypted")
+			useCache := fs.Bool("use-cache", false, "use the tfvars cached with download --cache instead of the tfvars path")
+			skipBackendCheck := fs.Bool("skip-backend-check", false, "don't check that the terraform backend keeps the environment's state")
+			syncLockfile := fs.Bool("sync-lockfile", false, "replace .terraform.lock.hcl with the environment's canonical lock file first")
+			yes := fs.Bool("yes", false, "don't ask before planning with AWS credentials that expire within --min-credential-lifetime")
+			return func(ctx context.Context, a *app, args []string) error {
+				var planFile string
+				if len(args) == 2 {
+					planFile = args[1]
+					if err := checkPlanFile(planFile); err != nil {
+						return err
+					}
+				}
+				fileName, err := a.varFile(ctx, "plan", args[0], *useCache)
+				if err != nil {
+					return err
+				}
+				dir := a.useEnvironment(args[0], *chdir)
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				checks := moduleChecks{fmt: *preflight || *fmtCheck || s.Hooks.FmtCheck, validate: *preflight || *validate || s.Hooks.Validate}
+				if err := runModuleChecks(ctx, a, checks, dir); err != nil {
+					return err
+				}
+				if *syncLockfile {
+					if err := downloadLockfile(ctx, a, args[0], dir, false); err != nil {
+						return err
+					}
+				}
+				if planFile == "" {
+					if planFile, err = newPlanFile(s, args[0], time.Now()); err != nil {
+						return err
+					}
+					a.out.Printf("\nWriting the plan to %s\n\n", a.out.green(planFile))
+					a.out.Event("plan-file", map[string]any{"environment": args[0], "file": planFile})
+				}
+				if *store {
+					if err := requirementsError("plan artifacts", checkRequirements("plan artifacts", "", s)); err != nil {
+						return err
+					}
+					if err := a.checkPlanEncryption(s, args[0], *allowPlaintext); err != nil {
+						return err
+					}
+				}
+				steps := planSteps{
+					env:              args[0],
+					outFile:          *outFile,
+					store:            *store,
+					skipBackendCheck: *skipBackendCheck,
+					yes:              *yes,
+					cost:             *cost || s.Hooks.Cost,
+					infracost:        s.Hooks.Infracost,
+					lint: lintSteps{
+						enabled: *lint || *lintStrict || s.Hooks.Lint,
+						strict:  *lintStrict || s.Hooks.LintStrict,
+						binary:  s.Hooks.TFLint,
+					},
+				}
+				return terraformPlan(ctx, a, steps, tfexec.PlanOptions{
+					Chdir:            dir,
+					VarFile:          fileName,
+					Out:              planFile,
+					Targets:          targets,
+					Destroy:          *destroy,
+					RefreshOnly:      *refreshOnly,
+					DetailedExitCode: true,
+				})
+			}
+		},
+	}
+}
+
+func applyCommand() *command {
+	return &command{
+		name:    "apply",
+		args:    "<env>",
+		summary: "Run terraform apply with the environment's tfvars, or apply a saved plan with --plan.",
+		examples: []string{
+			"tfmanage apply dev",
+			"tfmanage apply prod --plan prod.tfplan",
+			"tfmanage apply prod --policy-dir policy",
+			"tfmanage apply prod --checkov-fail-on MEDIUM",
+			"tfmanage apply prod --auto-backup",
+			"tfmanage apply dev --snapshot-state",
+			"tfmanage apply prod --tag-on-apply --push-tags",
+			"tfmanage apply prod --github-repo acme/infra --log-url https://ci.example.com/runs/42",
+			"tfmanage apply prod --plan latest --require-approval",
+			"tfmanage apply staging --plan latest --max-plan-age 2h",
+			"tfmanage apply prod --from-bundle latest",
+			"tfmanage apply prod --break-glass --reason \"INC-1234, the pipeline is down\"",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			var targets stringList
+			fs.Var(&targets, "target", "limit the apply to this resource address (repeatable)")
+			destroy := fs.Bool("destroy", false, "destroy everything")
+			refreshOnly := fs.Bool("refresh-only", false, "only update the state to match remote objects")
+			planFile := fs.String("plan", "", "apply this saved plan file instead of planning again, last for the environment's newest plan on this machine, latest or a key for a stored plan")
+			maxPlanAge := fs.Duration("max-plan-age", defaultMaxPlanAge, "refuse a stored plan made longer ago than this, 0 for no limit")
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			policyDir := fs.String("policy-dir", "", "check the plan against these rego policies first (default hooks.policy_dir from the config)")
+			autoBackup := fs.Bool("auto-backup", false, "back up the state to S3 before applying, like state backup does")
+			snapshotFlag := fs.Bool("snapshot-state", false, "keep a gzipped snapshot of the state in S3 before applying (default true for prod and protected environments)")
+			snapshotBestEffort := fs.Bool("snapshot-best-effort", false, "apply anyway when the state snapshot can't be taken")
+			checkov := fs.Bool("checkov", false, "scan the plan with checkov first (hooks.scan in the config does the same)")
+			checkovFailOnFlag := fs.String("checkov-fail-on", "", "with --checkov, the lowest severity that fails the apply: LOW, MEDIUM, HIGH or CRITICAL (default HIGH)")
+			requireApproval := fs.Bool("require-approval", false, "only apply the stored plan given with --plan, and only if its hash matches approvals from the approve command")
+			noApproval := fs.Bool("no-approval", false, "don't require an approval even when the environment has require_approval set")
+			useCache := fs.Bool("use-cache", false, "use the tfvars cached with download --cache instead of the tfvars path")
+			skipBackendCheck := fs.Bool("skip-backend-check", false, "don't check that the terraform backend keeps the environment's state")
+			fromBundle := fs.String("from-bundle", "", "apply a bundle stored with the bundle command, by key or latest, from a clean temp directory with its own tfvars")
+			keepWorkdir := fs.Bool("keep-workdir", false, "with --from-bundle, don't remove the directory the bundle was unpacked in")
+			syncLockfile := fs.Bool("sync-lockfile", false, "replace .terraform.lock.hcl with the environment's canonical lock file first")
+			yes := fs.Bool("yes", false, "don't ask before applying with AWS credentials that expire within --min-credential-lifetime")
+			breakGlass := fs.Bool("break-glass", false, "apply an environment with require_ci from outside CI, after typing its name, recorded in the audit trail")
+			reason := fs.String("reason", "", "with --break-glass, why the apply can't wait for CI, kept in the audit trail")
+			tagOnApply := fs.Bool("tag-on-apply", false, "tag HEAD as deploy/<env>/<time> once the apply succeeded (tag_on_apply in the config does the same)")
+			pushTags := fs.Bool("push-tags", false, "push the tag made after the apply to origin")
+			githubRepo := fs.String("github-repo", "", "record the apply as a GitHub deployment in this owner/name repository, with the token in GITHUB_TOKEN (default github_repo from the config)")
+			logURL := fs.String("log-url", "", "the link on the GitHub deployment's status (default the GitHub Actions run)")
+			return func(ctx context.Context, a *app, args []string) error {
+				if *requireApproval && *noApproval {
+					return usageError("--require-approval and --no-approval can't be used together")
+				}
+				if *fromBundle != "" && (*planFile != "" || *chdir != "" || *useCache || *syncLockfile) {
+					return usageError("--from-bundle brings its own module, tfvars and lock file, it can't be used with --plan, --chdir, --use-cache or --sync-lockfile")
+				}
+				if *keepWorkdir && *fromBundle == "" {
+					return usageError("--keep-workdir only works with --from-bundle")
+				}
+				if *maxPlanAge < 0 {
+					return usageError("--max-plan-age can't be negative")
+				}
+				action := "apply"
+				if *destroy {
+					action = "destroy"
+				}
+				if err := a.checkCI(ctx, args[0], action, *breakGlass, *reason); err != nil {
+					return err
+				}
+				var fileName string
+				var err error
+				if *fromBundle != "" {
+					err = a.checkEnvironment(args[0])
+				} else {
+					fileName, err = a.varFile(ctx, "apply", args[0], *useCache)
+				}
+				if err != nil {
+					return err
+				}
+				if err := a.lockEnvironment(args[0], "apply"); err != nil {
+					return err
+				}
+				if *autoBackup {
+					if err := a.prepareStateBackup(args[0]); err != nil {
+						return err
+					}
+				}
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				failOn, err := checkovFailOn(*checkovFailOnFlag, s.Hooks.CheckovFailOn)
+				if err != nil {
+					return err
+				}
+				snapshotSet := false
+				fs.Visit(func(f *flag.Flag) { snapshotSet = snapshotSet || f.Name == "snapshot-state" })
+				steps := applySteps{
+					env:    args[0],
+					backup: *autoBackup,
+					snapshot: snapshotSteps{
+						enabled:    a.snapshotEnabled(args[0], *snapshotFlag, snapshotSet),
+						bestEffort: *snapshotBestEffort,
+					},
+					policyDir:        *policyDir,
+					conftest:         s.Hooks.Conftest,
+					skipBackendCheck: *skipBackendCheck,
+					yes:              *yes,
+					tag: tagSteps{
+						enabled: *tagOnApply || s.Terraform[args[0]].TagOnApply,
+						push:    *pushTags,
+					},
+					deploy: deploySteps{
+						repo:   cmp.Or(*githubRepo, s.GitHubRepo),
+						logURL: *logURL,
+					},
+					scan: scanSteps{
+						enabled: *checkov || *checkovFailOnFlag != "" || s.Hooks.Scan,
+						failOn:  failOn,
+						binary:  s.Hooks.Checkov,
+					},
+				}
+				if steps.policyDir == "" {
+					steps.policyDir = s.Hooks.PolicyDir
+				}
+				if err := checkDeploySteps(steps.deploy); err != nil {
+					return err
+				}
+				if steps.tag.push && !steps.tag.enabled {
+					return usageError("--push-tags pushes the tag made after the apply, it needs --tag-on-apply or tag_on_apply for %s", args[0])
+				}
+				dir := a.useEnvironment(args[0], *chdir)
+				if *syncLockfile {
+					if err := downloadLockfile(ctx, a, args[0], dir, false); err != nil {
+						return err
+					}
+				}
+				if *fromBundle != "" {
+					bundleDir, varFile, cleanup, err := bundleWorkdir(ctx, a, args[0], *fromBundle, *keepWorkdir)
+					if err != nil {
+						return err
+					}
+					defer cleanup()
+					dir, fileName = bundleDir, varFile
+				}
+				plan := *planFile
+				required := approvalsRequired(s, args[0], *requireApproval, *noApproval)
+				if plan == lastPlanArg {
+					if required > 0 {
+						return usageError("--plan last is a plan on this machine, applying %s needs an approved stored plan", args[0])
+					}
+					if plan, err = lastPlan(args[0]); err != nil {
+						return err
+					}
+					a.out.Printf("Applying the last plan of %s, %s\n", args[0], plan)
+				}
+				switch {
+				case required > 0:
+					approved, key, cleanup, err := approvedPlan(ctx, a, args[0], plan, fileName, required, *maxPlanAge)
+					if err != nil {
+						return err
+					}
+					defer cleanup()
+					plan, steps.planKey = approved, key
+				case isStoredPlan(s, plan):
+					ps, store, err := a.planStore(ctx)
+					if err != nil {
+						return err
+					}
+					stored, key, cleanup, err := storedApplyPlan(ctx, a, ps, store, args[0], plan, fileName, *maxPlanAge)
+					if err != nil {
+						return err
+					}
+					defer cleanup()
+					plan, steps.planKey = stored.file, key
+				}
+				return terraformApply(ctx, a, steps, tfexec.ApplyOptions{
+					Chdir:       dir,
+					VarFile:     fileName,
+					PlanFile:    plan,
+					Targets:     targets,
+					Destroy:     *destroy,
+					RefreshOnly: *refreshOnly,
+					AutoApprove: true,
+				})
+			}
+		},
+	}
+}
+
+// This is the function for uploading the tfvars, force uploads without checking the remote is still what was last downloaded
+
+func uploadTFVars(ctx context.Context, a *app, environment, fileName string, git gitinfo.FileStatus, message string, force bool, opts storage.UploadOptions, check bucketCheck) error {
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	loc, err := a.locate(ctx, s, environment, fileName)
+	if err != nil {
+		return err
+	}
+	if err := a.checkBucket(ctx, loc, check); err != nil {
+		return err
+	}
+	a.logKMSKey(s, environment)
+	a.out.Printf("Uploading %s to %s...\n", fileName, loc.service)
+
+	metadata := gitMetadata(git)
+	if message != "" {
+		metadata[changeMessageMetadataKey] = encodeMetadataValue(message)
+	}
+	opts.Metadata, opts.CacheControl = metadata, "no-cache"
+	if loc.bucket != "" {
+		opts.KMSKeyID = loc.kmsKey
+	}
+	// the remote has to still be what was last downloaded, so nobody's upload in between is lost
+	location := loc.url(loc.key)
+	if force {
+		opts.IfMatch = ""
+	} else if opts.IfMatch == "" {
+		base, ok, err := readSyncState(environment, fileName, location)
+		switch {
+		case err != nil:
+			a.out.Warnf("Could not read the base revision of %s, uploading without checking the remote is unchanged: %v", fileName, err)
+		case ok:
+			opts.IfMatch = base.ETag
+		default:
+			a.out.Verbosef("%s was never downloaded from %s here, uploading without checking the remote is unchanged\n", fileName, location)
+		}
+	}
+	// the change journal needs what is there now, before the upload replaces it
+	_, _, journal := changeJournal(loc, environment, fileName)
+	var prev previousUpload
+	if journal {
+		prev, journal = a.readPrevious(ctx, loc)
+	}
+	res, err := storage.UploadKey(ctx, loc.store, loc.key, fileName, opts)
+	if errors.Is(err, storage.ErrPreconditionFailed) {
+		return fmt.Errorf("%s: remote has changed since you last downloaded it (base ETag %s): %w", location, opts.IfMatch, err)
+	}
+	if err != nil {
+		return err
+	}
+	a.recordSync(environment, fileName, location, res.ETag, res.VersionID, res.Checksum)
+	a.out.Event("upload", map[string]any{"file": fileName, "bucket": loc.bucket, "key": res.Key, "sha256": res.Checksum, "skipped": res.Skipped, "git_sha": metadata[gitSHAMetadataKey], "message": message})
+	if res.Skipped {
+		a.out.Warnf("%s is unchanged in %s, skipping upload", fileName, loc.name)
+		return nil
+	}
+	if journal {
+		a.recordChange(ctx, s, environment, fileName, message, loc, prev, res)
+	}
+	a.out.Successf("Successfully uploaded %s to %s", fileName, loc.name)
+	return nil
+}
+
+// the object metadata that ties an upload to a commit - files outside a repo only get git-sha: none
+
+const (
+	gitSHAMetadataKey    = "git-sha"
+	gitBranchMetadataKey = "git-branch"
+	gitDirtyMetadataKey  = "git-dirty"
+)
+
+func gitMetadata(git gitinfo.FileStatus) map[string]string {
+	if !git.InRepo {
+		return map[string]string{gitSHAMetadataKey: "none"}
+	}
+	return map[string]string{
+		gitSHAMetadataKey:    git.SHA,
+		gitBranchMetadataKey: git.Branch,
+		gitDirtyMetadataKey:  strconv.FormatBool(git.Dirty),
+	}
+}
+
+// requireCleanGit is true for prod unless the config turns it off, and for anything the config turns it on for
+
+func (a *app) requireCleanGit(environment string) bool {
+	s, err := a.loadSettings()
+	if err == nil {
+		if require := s.Terraform[environment].RequireCleanGit; require != nil {
+			return *require
+		}
+	}
+	return environment == "prod"
+}
+
+// function for donwloading tfvars
+
+func downloadTFVars(ctx context.Context, a *app, environment, fileName string, opts downloadOptions) error {
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	loc, err := a.locate(ctx, s, environment, fileName)
+	if err != nil {
+		return err
+	}
+	a.logKMSKey(s, environment)
+	if err := a.checkOverwrite(ctx, loc, environment, fileName, opts); err != nil {
+		return err
+	}
+	a.out.Printf("Downloading %s from %s...\n", fileName, loc.service)
+
+	// the head is only for the key checks, a missing object is reported by the download
+	var remote storage.ObjectInfo
+	if loc.bucket != "" {
+		if remote, err = loc.store.Head(ctx, loc.key); err == nil {
+			a.checkObjectKey(loc, remote)
+		}
+	}
+	numBytes, err := storage.DownloadKey(ctx, loc.store, loc.key, fileName)
+	if errors.Is(err, storage.ErrArchived) {
+		if err = a.restoreArchived(ctx, loc.store, loc.url(loc.key), loc.key, opts.restore, err); err == nil {
+			numBytes, err = storage.DownloadKey(ctx, loc.store, loc.key, fileName)
+		}
+	}
+	if err != nil {
+		return kmsDecryptError(err, cmp.Or(remote.KMSKeyID, loc.kmsKey))
+	}
+	if replica, ok := loc.store.(*storage.ReplicaStore); ok && replica.UsedReplica() {
+		a.checkReplicaCopy(ctx, replica, loc, environment, fileName)
+	} else {
+		a.recordDownload(ctx, loc, environment, fileName)
+	}
+	a.out.Event("download", map[string]any{"file": fileName, "bucket": loc.bucket, "key": loc.key, "bytes": numBytes})
+	a.out.Successf("Successfully downloaded %s (%d bytes)", fileName, numBytes)
+	return nil
+}
+
+// recordDownload makes the downloaded version the base of the next upload, a remote that changed during the download leaves no base so the upload isn't checked against the wrong one
+
+func (a *app) recordDownload(ctx context.Context, loc tfvarsLocation, environment, fileName string) {
+	sum, err := storage.FileChecksum(fileName)
+	if err != nil {
+		a.out.Warnf("Could not record the base revision of %s: %v", fileName, err)
+		return
+	}
+	remote, err := loc.store.Head(ctx, loc.key)
+	if err == nil && remote.Metadata[storage.ChecksumMetadataKey] != "" && remote.Metadata[storage.ChecksumMetadataKey] != sum {
+		err = errors.New("the remote file changed during the download")
+	}
+	if err != nil {
+		a.out.Warnf("Could not record the base revision of %s, the next upload can't check the remote is unchanged: %v", fileName, err)
+		return
+	}
+	a.recordSync(environment, fileName, loc.url(loc.key), remote.ETag, remote.VersionID, sum)
+}
+
+// checkReplicaCopy compares a download from the replica bucket with the version last synced from the primary, replication lags behind so the copy can be older. The replica's ETags aren't the primary's, so the download doesn't become the base of the next upload
+
+func (a *app) checkReplicaCopy(ctx context.Context, replica *storage.ReplicaStore, loc tfvarsLocation, environment, fileName string) {
+	sum, err := storage.FileChecksum(fileName)
+	if err != nil {
+		a.out.Warnf("Could not check the replica's copy of %s: %v", fileName, err)
+		return
+	}
+	remote, err := replica.Replica.Head(ctx, loc.key)
+	if err != nil {
+		a.out.Warnf("Could not check the replica's copy of %s: %v", fileName, err)
+		return
+	}
+	if replicaSum := remote.Metadata[storage.ChecksumMetadataKey]; replicaSum != "" && replicaSum != sum {
+		a.out.Warnf("%s doesn't match the checksum the replica keeps for it, it changed during the download", fileName)
+	}
+	state, tracked, err := readSyncState(environment, fileName, loc.url(loc.key))
+	switch {
+	case err != nil || !tracked:
+		a.out.Warnf("Downloaded %s from the replica %s, there is no checksum from %s to check it against - replication can be behind", fileName, replica.ReplicaName, replica.PrimaryName)
+	case state.SHA256 != sum:
+		a.out.Warnf("The replica's copy of %s isn't the version last synced from %s at %s, replication may be behind", fileName, replica.PrimaryName, state.At.Format(time.RFC3339))
+	default:
+		a.out.Verbosef("The replica's copy of %s matches the version last synced from %s\n", fileName, replica.PrimaryName)
+	}
+	a.out.Event("replica-download", map[string]any{"file": fileName, "bucket": replica.ReplicaName, "sha256": sum, "last_synced_sha256": state.SHA256, "matches": tracked && state.SHA256 == sum})
+}
+
+// terraform's output goes to stderr in json and markdown mode so stdout stays parseable, and it gets the environment's workspace and terraform_env
+
+func (a *app) terraformOutput() tfexec.RunOptions {
+	var run tfexec.RunOptions
+	if a.out.machineReadable() {
+		run = tfexec.RunOptions{Stdout: a.out.stderr, Stderr: a.out.stderr}
+	}
+	run.Env = a.terraformEnv()
+	switch {
+	case !a.global.rawOutput:
+		run.Redactor = a.redactor
+		if run.Redactor == nil {
+			run.Redactor = redact.NewRedactor(a.secretValues...)
+		}
+	default:
+		// --raw-output is for debugging the tfvars, the values from Secrets Manager are never shown
+		run.Redactor = a.neverShown()
+	}
+	return run
+}
+
+// neverShown masks only the values that are never shown, not even raw, the secrets tfmanage read for terraform and the proxy password - nil when there are none
+
+func (a *app) neverShown() *redact.Redactor {
+	if len(a.secretValues) == 0 {
+		return nil
+	}
+	return redact.NewRedactor(a.secretValues...)
+}
+
+// redactVariables masks the values the tfvars give the sensitive variables of the terraform in chdir, on top of the built-in secret patterns
+
+func (a *app) redactVariables(chdir, varFile string) {
+	if a.global.rawOutput || a.redactedFrom == chdir+"\x00"+varFile {
+		return
+	}
+	r, err := redact.ForTerraform(chdir, varFile)
+	if err != nil {
+		a.out.Warnf("Could not read the sensitive variables, only the built-in secret patterns are masked in terraform's output: %v", err)
+	}
+	r.Add(a.secretValues...)
+	a.redactor, a.redactedFrom = r, chdir+"\x00"+varFile
+	if r.Len() > 0 {
+		a.out.Verbosef("Masking %d sensitive value(s) from %s in terraform's output\n", r.Len(), varFile)
+	}
+}
+
+// applySteps is what has to happen before terraform apply runs
+
+type applySteps struct {
+	env string
+	// policyDir turns on the policy gate, conftest is the binary to use
+	policyDir string
+	conftest  string
+	scan      scanSteps
+	// backup saves the state to S3 right before terraform apply runs
+	backup bool
+	// snapshot keeps a gzipped copy of the state from before the apply
+	snapshot snapshotSteps
+	// skipBackendCheck doesn't compare the backend with the environment
+	skipBackendCheck bool
+	// yes doesn't ask before running with credentials that are about to expire
+	yes bool
+	// planKey is the stored plan being applied, its sidecar gets the versions it was applied with
+	planKey string
+	// tag tags HEAD once the apply succeeded
+	tag tagSteps
+	// deploy records the apply as a GitHub deployment
+	deploy deploySteps
+}
+
+//function for applying
+
+func terraformApply(ctx context.Context, a *app, steps applySteps, opts tfexec.ApplyOptions) error {
+	// the tfvars used can be the cached copy rather than the environment's path
+	if opts.VarFile != "" {
+		a.redactVariables(opts.Chdir, opts.VarFile)
+	}
+	if err := a.prepareTerraform(ctx, steps.env); err != nil {
+		return err
+	}
+	if err := a.checkCredentialLifetime(ctx, steps.env, "apply", steps.yes); err != nil {
+		return err
+	}
+	if err := a.checkBackend(steps.env, opts.Chdir, steps.skipBackendCheck); err != nil {
+		return err
+	}
+	opts.NoColor = !a.out.color
+	if opts.Destroy && opts.PlanFile == "" {
+		a.out.DestroyWarningf("this apply destroys every resource managed by this configuration")
+	}
+	// a saved plan already has the replacements in it
+	replace, err := pendingReplacements(steps.env, opts.Chdir)
+	if err != nil {
+		return err
+	}
+	if opts.PlanFile == "" {
+		opts.Replace = append(opts.Replace, replace...)
+		a.printReplacements(replace)
+	}
+	// the checks need a plan to look at, and what gets applied has to be the plan they passed. The tag needs the plan's counts
+	if (steps.policyDir != "" || steps.scan.enabled || steps.tag.enabled) && opts.PlanFile == "" {
+		planFile, cleanup, err := planForApply(ctx, a, opts)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		opts.PlanFile = planFile
+	}
+	if steps.policyDir != "" || steps.scan.enabled {
+		planJSON, cleanup, err := exportPlan(ctx, a, tfexec.ShowOptions{Chdir: opts.Chdir, PlanFile: opts.PlanFile})
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		if steps.policyDir != "" {
+			if err := checkPolicy(ctx, a, steps.env, steps.policyDir, steps.conftest, planJSON); err != nil {
+				return err
+			}
+		}
+		if steps.scan.enabled {
+			if err := runCheckov(ctx, a, steps.env, steps.scan, opts.Chdir, planJSON); err != nil {
+				return err
+			}
+		}
+	}
+	if steps.backup {
+		if _, err := backupState(ctx, a, steps.env, opts.Chdir, ""); err != nil {
+			return err
+		}
+	}
+	if steps.snapshot.enabled {
+		snapshot, err := snapshotBeforeApply(ctx, a, steps, opts)
+		if err != nil {
+			return err
+		}
+		// printed last whatever happens, a failed apply is when it's needed
+		if snapshot != "" {
+			defer a.out.Printf("\nPre-apply state snapshot: %s\n", snapshot)
+		}
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.ApplyArgs(opts))
+	deploy := a.startDeployment(ctx, steps.env, steps.deploy)
+	err = tfexec.Apply(ctx, runner, opts, a.terraformOutput())
+	deploy.finish(ctx, a, err)
+	if err != nil {
+		return err
+	}
+	recordApply(ctx, a, steps, opts.Chdir)
+	if steps.tag.enabled {
+		tagApply(ctx, a, steps, opts)
+	}
+	if len(replace) > 0 {
+		return writeReplacements(steps.env, opts.Chdir, nil)
+	}
+	return nil
+}
+
+// printReplacements says which recorded taint --use-replace addresses are being passed on
+
+func (a *app) printReplacements(replace []string) {
+	for _, address := range replace {
+		a.out.Printf("Replacing %s (recorded with taint --use-replace)\n", address)
+	}
+}
+
+// planForApply saves a plan with the apply's options to a temp file so it can be checked and then applied as is
+
+func planForApply(ctx context.Context, a *app, opts tfexec.ApplyOptions) (string, func(), error) {
+	f, err := os.CreateTemp("", "tfmanage-*.tfplan")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create a plan file: %w", err)
+	}
+	f.Close()
+	cleanup := func() { os.Remove(f.Name()) }
+
+	plan := tfexec.PlanOptions{
+		Chdir:       opts.Chdir,
+		VarFile:     opts.VarFile,
+		Out:         f.Name(),
+		Targets:     opts.Targets,
+		Replace:     opts.Replace,
+		Destroy:     opts.Destroy,
+		RefreshOnly: opts.RefreshOnly,
+		NoColor:     opts.NoColor,
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.PlanArgs(plan))
+	if err := tfexec.Plan(ctx, runner, plan, a.terraformOutput()); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// planSteps is what happens around the plan itself
+
+type planSteps struct {
+	env     string
+	outFile string
+	// cost prices the plan with infracost, infracost is the binary to use
+	cost      bool
+	infracost string
+	lint      lintSteps
+	// store uploads the saved plan to the bucket once it is made
+	store bool
+	// skipBackendCheck doesn't compare the backend with the environment
+	skipBackendCheck bool
+	// yes doesn't ask before running with credentials that are about to expire
+	yes bool
+}
+
+//function for planning
+
+func terraformPlan(ctx context.Context, a *app, steps planSteps, opts tfexec.PlanOptions) error {
+	if opts.VarFile != "" {
+		a.redactVariables(opts.Chdir, opts.VarFile)
+	}
+	if err := a.prepareTerraform(ctx, steps.env); err != nil {
+		return err
+	}
+	if err := a.checkCredentialLifetime(ctx, steps.env, "plan", steps.yes); err != nil {
+		return err
+	}
+	if err := a.checkBackend(steps.env, opts.Chdir, steps.skipBackendCheck); err != nil {
+		return err
+	}
+	if steps.lint.enabled {
+		if err := runLint(ctx, a, steps.lint, opts.Chdir); err != nil {
+			return err
+		}
+	}
+	replace, err := pendingReplacements(steps.env, opts.Chdir)
+	if err != nil {
+		return err
+	}
+	opts.Replace = append(opts.Replace, replace...)
+	a.printReplacements(replace)
+	opts.NoColor = !a.out.color
+	if opts.Destroy {
+		a.out.DestroyWarningf("this is a destroy plan, applying it removes every resource managed by this configuration")
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.PlanArgs(opts))
+	start := time.Now()
+	err = tfexec.Plan(ctx, runner, opts, a.terraformOutput())
+	if err != nil && !errors.Is(err, tfexec.ErrPlanHasChanges) {
+		return err
+	}
+	if err := rememberPlan(steps.env, opts.Out); err != nil {
+		a.out.Warnf("Could not remember the plan for apply --plan last: %v", err)
+	}
+	if steps.store {
+		if _, err := storePlan(ctx, a, steps.env, opts); err != nil {
+			return err
+		}
+	}
+
+	if a.out.markdown || a.out.github || steps.cost || a.pushgatewayURL() != "" {
+		report, reportErr := a.planReport(ctx, steps, opts, time.Since(start))
+		// the markdown report is what was asked for so not getting it is an error, everywhere else it's only a warning
+		if a.out.markdown {
+			if reportErr != nil {
+				return reportErr
+			}
+			if err := a.writeMarkdown(report.Markdown(), steps.outFile); err != nil {
+				return err
+			}
+		}
+		if reportErr != nil {
+			a.out.Warnf("Could not read the plan back for the summary: %v", reportErr)
+		} else {
+			if report.Cost != "" {
+				a.out.Printf("Monthly cost: %s\n", report.Cost)
+			}
+			if a.out.github {
+				a.reportPlanToGitHub(report)
+			}
+		}
+	}
+	if err != nil {
+		return errPlanHasChanges
+	}
+	return nil
+}
+
+// planReport reads the saved plan back, the JSON for the counts and the cost and in markdown mode the text for the details block
+
+func (a *app) planReport(ctx context.Context, steps planSteps, opts tfexec.PlanOptions, took time.Duration) (plansummary.Report, error) {
+	show := tfexec.ShowOptions{Chdir: opts.Chdir, PlanFile: opts.Out, JSON: true}
+	data, err := tfexec.Show(ctx, runner, show, a.terraformOutput())
+	if err != nil {
+		return plansummary.Report{}, err
+	}
+	summary, err := plansummary.Parse(data)
+	if err != nil {
+		return plansummary.Report{}, err
+	}
+
+	a.planChanges = &summary
+
+	report := plansummary.Report{Environment: steps.env, Commit: gitinfo.Short(gitinfo.Commit(ctx)), Summary: summary, Duration: took}
+	event := map[string]any{"environment": steps.env, "summary": summary}
+	if steps.cost {
+		if estimate, err := a.estimateCost(ctx, steps.infracost, data); err != nil {
+			a.out.Warnf("Could not price the plan, carrying on without it: %v", err)
+		} else {
+			report.Cost = estimate.String()
+			event["cost"] = estimate
+		}
+	}
+	a.out.Event("plan-summary", event)
+
+	if a.out.markdown && summary.HasChanges() {
+		show.JSON, show.NoColor = false, true
+		text, err := tfexec.Show(ctx, runner, show, a.terraformOutput())
+		if err != nil {
+			return plansummary.Report{}, err
+		}
+		report.PlanText = stripANSI(string(text))
+	}
+	return report, nil
+}
+
+func (a *app) estimateCost(ctx context.Context, binary string, data []byte) (tools.CostEstimate, error) {
+	if tools.InfracostAPIKey() == "" {
+		a.out.Warnf("%s is not set, infracost may not be able to price the plan", tools.InfracostAPIKeyEnv)
+	}
+	planJSON, cleanup, err := writeTempJSON(data)
+	if err != nil {
+		return tools.CostEstimate{}, err
+	}
+	defer cleanup()
+	a.out.Verbosef("Running infracost %v\n", tools.InfracostArgs(planJSON))
+	return tools.Infracost(ctx, toolRunner, binary, planJSON)
+}
+
+func (a *app) writeMarkdown(markdown, outFile string) error {
+	if outFile == "" {
+		_, err := io.WriteString(a.out.stdout, markdown)
+		return err
+	}
+	if err := os.WriteFile(outFile, []byte(markdown), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outFile, err)
+	}
+	a.out.Printf("Wrote the plan report to %s\n", outFile)
+	return nil
+}
+
+// the step summary and outputs are extras, if they can't be written the plan still counts
+
+func (a *app) reportPlanToGitHub(report plansummary.Report) {
+	if err := ghactions.AppendSummary(report.Markdown()); err != nil {
+		a.out.Warnf("Could not write the step summary: %v", err)
+	}
+	summary := report.Summary
+	err := ghactions.SetOutputs(map[string]string{
+		"changes": strconv.FormatBool(summary.HasChanges()),
+		"add":     strconv.Itoa(summary.Add),
+		"change":  strconv.Itoa(summary.Change),
+		"destroy": strconv.Itoa(summary.Destroy),
+	})
+	if err != nil {
+		a.out.Warnf("Could not set the step outputs: %v", err)
+	}
+}
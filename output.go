@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/ghactions"
+)
+
+// ui is where everything the commands print goes through - in json mode stdout only gets one JSON event per line and the human messages move to stderr
+
+type ui struct {
+	json bool
+	// markdown is --output markdown, stdout gets the rendered report and nothing else
+	markdown bool
+	verbose  bool
+	// color is only on when the output is a terminal, NO_COLOR is not set and --no-color was not passed
+	color bool
+	// github adds workflow command annotations for failures and destroy warnings
+	github bool
+	stdout io.Writer
+	stderr io.Writer
+	// stdin is only read for confirmations, nil means there is nobody to ask
+	stdin io.Reader
+}
+
+func newUI() *ui {
+	return &ui{stdout: os.Stdout, stderr: os.Stderr, stdin: os.Stdin}
+}
+
+// colorAllowed is the automatic part of the color decision, --no-color is applied on top of it
+
+func colorAllowed(w io.Writer) bool {
+	if _, set := os.LookupEnv("NO_COLOR"); set {
+		return false
+	}
+	return isTerminal(w)
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+	ansiBold   = "\x1b[1m"
+)
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// stripANSI removes color codes, for output that ends up somewhere other than a terminal
+
+func stripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+func (u *ui) paint(code, s string) string {
+	if !u.color || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+func (u *ui) green(s string) string  { return u.paint(ansiGreen, s) }
+func (u *ui) yellow(s string) string { return u.paint(ansiYellow, s) }
+func (u *ui) red(s string) string    { return u.paint(ansiRed, s) }
+
+// Printf is for the normal progress messages
+
+func (u *ui) Printf(format string, a ...any) {
+	fmt.Fprintf(u.humanOut(), format, a...)
+}
+
+func (u *ui) humanOut() io.Writer {
+	if u.machineReadable() {
+		return u.stderr
+	}
+	return u.stdout
+}
+
+// machineReadable is true when stdout belongs to the json events or the markdown report
+
+func (u *ui) machineReadable() bool {
+	return u.json || u.markdown
+}
+
+// Successf, Warnf and Failf print a status line in green, yellow or red
+
+func (u *ui) Successf(format string, a ...any) {
+	fmt.Fprintln(u.humanOut(), u.green(fmt.Sprintf(format, a...)))
+}
+
+func (u *ui) Warnf(format string, a ...any) {
+	fmt.Fprintln(u.humanOut(), u.yellow(fmt.Sprintf(format, a...)))
+}
+
+func (u *ui) Failf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	fmt.Fprintln(u.stderr, u.red(msg))
+	if u.github {
+		ghactions.Annotate(u.humanOut(), "error", msg)
+	}
+}
+
+// DestroyWarningf is for anything that is about to delete infrastructure, it is meant to be hard to miss
+
+func (u *ui) DestroyWarningf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	fmt.Fprintln(u.humanOut(), u.paint(ansiBold+ansiRed, "WARNING: "+msg))
+	if u.github {
+		ghactions.Annotate(u.humanOut(), "warning", msg)
+	}
+}
+
+// Finding prints one lint or scan finding colored by severity - in github mode it also becomes an annotation on the file
+
+func (u *ui) Finding(severity, file string, line int, message string) {
+	where := file
+	if line > 0 {
+		where = fmt.Sprintf("%s:%d", file, line)
+	}
+	text := "  " + message
+	if where != "" {
+		text = fmt.Sprintf("  %s: %s", where, message)
+	}
+	level := "notice"
+	switch severity {
+	case "error":
+		level, text = "error", u.red(text)
+	case "warning":
+		level, text = "warning", u.yellow(text)
+	}
+	fmt.Fprintln(u.humanOut(), text)
+	if u.github {
+		if file != "" {
+			ghactions.AnnotateFile(u.humanOut(), level, file, line, message)
+		} else {
+			ghactions.Annotate(u.humanOut(), level, message)
+		}
+	}
+}
+
+// DiffLine prints one line of a unified diff colored by what kind of line it is
+
+func (u *ui) DiffLine(line string) {
+	switch {
+	case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		line = u.paint(ansiBold, line)
+	case strings.HasPrefix(line, "@@"):
+		line = u.paint(ansiCyan, line)
+	case strings.HasPrefix(line, "+"):
+		line = u.green(line)
+	case strings.HasPrefix(line, "-"):
+		line = u.red(line)
+	}
+	fmt.Fprintln(u.humanOut(), line)
+}
+
+// statusColor picks the color for a status word used in tables
+
+func (u *ui) statusColor(status string) string {
+	switch status {
+	case statusOK, statusClean, statusValid, statusCurrent, statusAllowed, syncInSync, matrixSame:
+		return u.green(status)
+	case statusDrift, statusStale, statusDiffers, statusBehind, statusAhead, syncLocalModified, syncRemoteNewer:
+		return u.yellow(status)
+	case statusMissing, statusFileMissing, statusError, statusDenied, statusUnreachable, syncBothChanged, syncLocalMissing, syncRemoteMissing, syncError, matrixMismatch:
+		return u.red(status)
+	}
+	return status
+}
+
+// Table prints aligned columns - the padding is worked out on the plain text so colored cells still line up
+
+func (u *ui) Table(w io.Writer, headers []string, rows [][]string, style func(col int, cell string) string) {
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = len(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+
+	line := func(cells []string, styled bool) {
+		var b strings.Builder
+		for i, cell := range cells {
+			padded := cell
+			if i < len(cells)-1 {
+				padded += strings.Repeat(" ", widths[i]-len(cell)+2)
+			}
+			if styled && style != nil {
+				padded = strings.Replace(padded, cell, style(i, cell), 1)
+			}
+			b.WriteString(padded)
+		}
+		fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	}
+	line(headers, false)
+	for _, row := range rows {
+		line(row, true)
+	}
+}
+
+// Verbosef only prints with --verbose, always to stderr so it never mixes with output meant for other tools
+
+func (u *ui) Verbosef(format string, a ...any) {
+	if u.verbose {
+		fmt.Fprintf(u.stderr, format, a...)
+	}
+}
+
+// Event writes one JSON event in json mode and nothing otherwise
+
+func (u *ui) Event(event string, fields map[string]any) {
+	if !u.json {
+		return
+	}
+	record := map[string]any{"event": event, "time": time.Now().UTC().Format(time.RFC3339)}
+	for k, v := range fields {
+		record[k] = v
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		fmt.Fprintf(u.stderr, "failed to encode %s event: %v\n", event, err)
+		return
+	}
+	fmt.Fprintln(u.stdout, string(data))
+}
+
+// Result is the last event of every command
+
+func (u *ui) Result(command string, err error) {
+	fields := map[string]any{"command": command, "status": "ok", "exit_code": exitCodeFor(err)}
+	if err != nil {
+		fields["status"] = "error"
+		fields["error"] = err.Error()
+	}
+	u.Event("result", fields)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func printAll(u *ui) {
+	u.Successf("uploaded %s", "dev.tfvars")
+	u.Warnf("unchanged")
+	u.Failf("failed")
+	u.DestroyWarningf("destroying")
+	u.DiffLine("--- a")
+	u.DiffLine("+++ b")
+	u.DiffLine("@@ -1 +1 @@")
+	u.DiffLine("-old")
+	u.DiffLine("+new")
+	u.Table(u.stdout, []string{"NAME", "STATUS"}, [][]string{{"DEV_TFVARS", statusOK}, {"S3_BUCKET", statusMissing}},
+		func(col int, cell string) string {
+			if col == 1 {
+				return u.statusColor(cell)
+			}
+			return cell
+		})
+}
+
+func TestPlainOutputHasNoEscapes(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	printAll(&ui{stdout: &stdout, stderr: &stderr})
+
+	out := stdout.String() + stderr.String()
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("plain output has escape codes: %q", out)
+	}
+	if !strings.Contains(stdout.String(), "uploaded dev.tfvars") || !strings.Contains(stderr.String(), "failed") {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestColorOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	u := &ui{color: true, stdout: &stdout, stderr: &stderr}
+	printAll(u)
+
+	if !strings.Contains(stdout.String(), ansiGreen+"uploaded dev.tfvars"+ansiReset) {
+		t.Errorf("success line not green: %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), ansiRed+"failed"+ansiReset) {
+		t.Errorf("fail line not red: %q", stderr.String())
+	}
+
+	// the columns have to line up the same way with and without color
+	var plain bytes.Buffer
+	(&ui{stdout: &plain, stderr: &bytes.Buffer{}}).Table(&plain, []string{"NAME", "STATUS"},
+		[][]string{{"DEV_TFVARS", statusOK}, {"S3_BUCKET", statusMissing}}, func(_ int, cell string) string { return cell })
+	if !strings.HasSuffix(stripANSI(stdout.String()), plain.String()) {
+		t.Errorf("colored table = %q, want %q", stripANSI(stdout.String()), plain.String())
+	}
+}
+
+func TestNoColorFlag(t *testing.T) {
+	u := &ui{stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+	g := globalFlags{output: "text", noColor: true}
+	if err := g.apply(u); err != nil {
+		t.Fatal(err)
+	}
+	if u.color {
+		t.Error("--no-color left color on")
+	}
+}
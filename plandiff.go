@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/plansummary"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// errPlansDiffer is the answer rather than a failure, like errPlanHasChanges, but it keeps exit code 1 so scripts can tell it from equivalent plans
+
+var errPlansDiffer = withCode(exitGeneric, errors.New("the plans are different"))
+
+func planDiffCommand() *command {
+	return &command{
+		name:    "plan-diff",
+		args:    "<plan-a> <plan-b>",
+		summary: "Compare what two saved plans do, by address and action. Exits 1 when they differ.",
+		examples: []string{
+			"tfmanage plan-diff yesterday.tfplan today.tfplan",
+			"tfmanage plan-diff plans/prod/20240501T130405Z-0123abc.tfplan prod.tfplan --chdir infra",
+			"tfmanage plan-diff --env prod 20240501T130405Z-0123abc.tfplan latest",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform show in this directory")
+			env := fs.String("env", "", "look up latest and bare plan names under this environment's stored plans")
+			return func(ctx context.Context, a *app, args []string) error {
+				if *env != "" {
+					if err := a.checkEnvironment(*env); err != nil {
+						return err
+					}
+				}
+				before, err := showPlanJSON(ctx, a, *chdir, *env, args[0])
+				if err != nil {
+					return err
+				}
+				after, err := showPlanJSON(ctx, a, *chdir, *env, args[1])
+				if err != nil {
+					return err
+				}
+				return a.comparePlans(args[0], args[1], before, after)
+			}
+		},
+	}
+}
+
+// showPlanJSON fetches the plan when it is stored in the bucket and gives back terraform show -json of it
+
+func showPlanJSON(ctx context.Context, a *app, chdir, environment, arg string) ([]byte, error) {
+	planFile, cleanup, err := fetchPlan(ctx, a, environment, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	show := tfexec.ShowOptions{Chdir: chdir, PlanFile: planFile, JSON: true}
+	a.out.Verbosef("Running terraform %v\n", tfexec.ShowArgs(show))
+	return tfexec.Show(ctx, runner, show, a.terraformOutput())
+}
+
+func (a *app) comparePlans(nameA, nameB string, before, after []byte) error {
+	changes, err := plansummary.Diff(before, after)
+	if err != nil {
+		return err
+	}
+	summaryA, err := plansummary.Parse(before)
+	if err != nil {
+		return err
+	}
+	summaryB, err := plansummary.Parse(after)
+	if err != nil {
+		return err
+	}
+	a.out.Event("plan-diff", map[string]any{"a": nameA, "b": nameB, "equivalent": len(changes) == 0, "changes": changes, "summary_a": summaryA, "summary_b": summaryB})
+
+	if len(changes) == 0 {
+		a.out.Successf("The plans are equivalent")
+		return nil
+	}
+	a.out.Printf("Comparing %s with %s:\n", nameA, nameB)
+	for _, c := range changes {
+		switch {
+		case c.Before == "":
+			a.out.DiffLine(fmt.Sprintf("+ %s (%s)", c.Address, c.After))
+		case c.After == "":
+			a.out.DiffLine(fmt.Sprintf("- %s (%s)", c.Address, c.Before))
+		default:
+			a.out.DiffLine(fmt.Sprintf("~ %s: %s -> %s", c.Address, c.Before, c.After))
+		}
+	}
+	a.out.Printf("\n%s: %s\n%s: %s\n", nameA, summaryA, nameB, summaryB)
+	a.out.Printf("Difference: %+d to add, %+d to change, %+d to destroy\n", summaryB.Add-summaryA.Add, summaryB.Change-summaryA.Change, summaryB.Destroy-summaryA.Destroy)
+	return errPlansDiffer
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// withShowRunner has terraform show print the plan JSON stored in the plan file itself
+
+func withShowRunner(t *testing.T) *tfexec.RecordingRunner {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{OutputFor: func(args []string) string {
+		data, _ := os.ReadFile(args[len(args)-1])
+		return string(data)
+	}}
+	useRunner(t, rec)
+	inTempDir(t)
+	return rec
+}
+
+const (
+	planA = `{"format_version":"1.2","resource_changes":[{"address":"aws_instance.web","change":{"actions":["update"]}},{"address":"aws_iam_role.old","change":{"actions":["delete"]}}]}`
+	planB = `{"format_version":"1.2","terraform_version":"1.7.0","resource_changes":[{"address":"aws_instance.web","change":{"actions":["delete","create"]}},{"address":"aws_sqs_queue.jobs","change":{"actions":["create"]}}]}`
+)
+
+func TestPlanDiff(t *testing.T) {
+	withShowRunner(t)
+	os.WriteFile("a.tfplan", []byte(planA), 0o644)
+	os.WriteFile("b.tfplan", []byte(planB), 0o644)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"plan-diff", "a.tfplan", "b.tfplan"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if !errors.Is(err, errPlansDiffer) || exitCodeFor(err) != exitGeneric {
+		t.Fatalf("plan-diff: %v, want errPlansDiffer", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"- aws_iam_role.old (delete)",
+		"~ aws_instance.web: update -> replace",
+		"+ aws_sqs_queue.jobs (create)",
+		"Difference: +2 to add, -1 to change, +0 to destroy",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+
+	stdout.Reset()
+	if err := runWithUI([]string{"plan-diff", "a.tfplan", "a.tfplan"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("plan-diff of the same plan: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "The plans are equivalent") {
+		t.Errorf("output = %q", stdout.String())
+	}
+}
+
+func TestPlanDiffDownloadsStoredPlans(t *testing.T) {
+	withShowRunner(t)
+	store := withMemoryStore(t)
+	store.Put(context.Background(), storage.PutInput{Key: "team/plans/prod/old.tfplan", Body: strings.NewReader(planA)})
+	os.WriteFile("new.tfplan", []byte(planA), 0o644)
+
+	for _, arg := range []string{"plans/prod/old.tfplan", "team/plans/prod/old.tfplan"} {
+		if err := run([]string{"plan-diff", arg, "new.tfplan"}); err != nil {
+			t.Errorf("plan-diff %s: %v", arg, err)
+		}
+	}
+	if err := run([]string{"plan-diff", "plans/prod/missing.tfplan", "new.tfplan"}); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("missing stored plan: %v", err)
+	}
+	if err := run([]string{"plan-diff", "nowhere.tfplan", "new.tfplan"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("missing local plan: %v, want a config error", err)
+	}
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	if err := run([]string{"plan-diff", "--env", "prod", "old.tfplan", "latest"}); err != nil {
+		t.Errorf("plan-diff --env prod old.tfplan latest: %v", err)
+	}
+
+	// a plan moved to another environment's folder is refused
+	store.Put(context.Background(), storage.PutInput{Key: "team/plans/prod/dev.tfplan.json", Body: strings.NewReader(`{"environment":"dev"}`)})
+	if err := run([]string{"plan-diff", "plans/prod/dev.tfplan", "new.tfplan"}); exitCodeFor(err) != exitCheck {
+		t.Errorf("plan-diff of a dev plan stored under prod: %v, want exit %d", err, exitCheck)
+	}
+}
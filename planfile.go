@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/dirs"
+)
+
+// plan files - plan without a plan file names one itself under plan_dir, and every plan is remembered in the state directory so apply --plan last finds the newest one of the environment
+
+const (
+	defaultPlanDir = "plans"
+	lastPlanArg    = "last"
+)
+
+// newPlanFile names a plan <env>-<timestamp>.tfplan in the plan directory, which is made when it doesn't exist yet
+
+func newPlanFile(s settings, environment string, now time.Time) (string, error) {
+	if err := os.MkdirAll(s.PlanDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create the plan directory: %w", err)
+	}
+	return filepath.Join(s.PlanDir, environment+"-"+now.UTC().Format("20060102T150405Z")+".tfplan"), nil
+}
+
+// checkPlanFile refuses a plan file terraform couldn't write, before terraform runs and fails with its own error
+
+func checkPlanFile(file string) error {
+	dir := filepath.Dir(file)
+	info, err := os.Stat(dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return usageError("can't write the plan to %s, the directory %s doesn't exist", file, dir)
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return usageError("can't write the plan to %s, %s is not a directory", file, dir)
+	}
+	return nil
+}
+
+func lastPlanPath(environment string) (string, error) {
+	dir, err := dirs.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plans", environment+".last"), nil
+}
+
+// rememberPlan keeps the absolute path of the environment's newest plan for apply --plan last
+
+func rememberPlan(environment, file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	path, err := lastPlanPath(environment)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(abs+"\n"), 0o644)
+}
+
+// lastPlan is the environment's newest plan made on this machine
+
+func lastPlan(environment string) (string, error) {
+	path, err := lastPlanPath(environment)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", usageError("no plan of %s has been made on this machine, run plan %s first", environment, environment)
+	}
+	if err != nil {
+		return "", err
+	}
+	file := strings.TrimSpace(string(data))
+	if _, err := os.Stat(file); err != nil {
+		return "", usageError("the last plan of %s, %s, is gone, run plan %s again", environment, file, environment)
+	}
+	return file, nil
+}
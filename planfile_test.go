@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestPlanFileName(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	if err := run([]string{"apply", "dev", "--plan", "last"}); exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "no plan of dev") {
+		t.Errorf("apply --plan last before any plan: %v", err)
+	}
+
+	if err := run([]string{"plan", "dev"}); err != nil {
+		t.Fatalf("plan without a plan file: %v", err)
+	}
+	planned := rec.Calls[0].Args[slices.Index(rec.Calls[0].Args, "-out")+1]
+	if dir, name := filepath.Split(planned); !strings.HasSuffix(filepath.Clean(dir), "plans") || !strings.HasPrefix(name, "dev-") || !strings.HasSuffix(name, ".tfplan") {
+		t.Errorf("plan wrote %s, want plans/dev-<timestamp>.tfplan", planned)
+	}
+	os.WriteFile(planned, []byte("plan"), 0o644)
+
+	if err := run([]string{"apply", "dev", "--plan", "last"}); err != nil {
+		t.Fatalf("apply --plan last: %v", err)
+	}
+	abs, _ := filepath.Abs(planned)
+	if !slices.ContainsFunc(rec.Calls, func(c tfexec.RecordedCall) bool {
+		return slices.Contains(c.Args, "apply") && slices.Contains(c.Args, abs)
+	}) {
+		t.Errorf("apply --plan last ran %q, want it to apply %s", rec.Args(), abs)
+	}
+
+	// an explicit plan file is remembered too, and its directory has to exist
+	calls := len(rec.Calls)
+	if err := run([]string{"plan", "dev", "missing/dev.tfplan"}); exitCodeFor(err) != exitUsage || !strings.Contains(err.Error(), "missing doesn't exist") {
+		t.Errorf("plan into a missing directory: %v", err)
+	}
+	if len(rec.Calls) != calls {
+		t.Errorf("terraform ran: %q", rec.Args()[calls:])
+	}
+	if err := run([]string{"plan", "dev", "plan.out"}); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := lastPlan("dev"); err == nil || !strings.Contains(err.Error(), "is gone") {
+		t.Errorf("lastPlan() = %s, %v, want the removed plan.out to be reported gone", last, err)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/plansummary"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// plans - lists an environment's stored plans newest first from their sidecars, so finding the one to approve or apply doesn't mean reading keys
+
+const (
+	defaultPlanLimit = 10
+	unknownField     = "unknown"
+)
+
+// planRecord is one stored plan as plans lists it. Artifact and Summary are nil for plans stored without them
+
+type planRecord struct {
+	Key       string               `json:"key"`
+	CreatedAt time.Time            `json:"created_at"`
+	Artifact  *planArtifact        `json:"artifact"`
+	Summary   *plansummary.Summary `json:"summary"`
+	Approvals []planApproval       `json:"approvals"`
+	// Approval is approved, not required, or how many of the required approvals match the plan's hash
+	Approval string `json:"approval"`
+}
+
+func plansCommand() *command {
+	return &command{
+		name:    "plans",
+		args:    "<env>",
+		summary: "List the environment's stored plans, newest first, with their commit, author, changes, approvals and whether they were applied.",
+		examples: []string{
+			"tfmanage plans prod",
+			"tfmanage plans prod --limit 3 --output json",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			limit := fs.Int("limit", defaultPlanLimit, "list at most this many plans")
+			return func(ctx context.Context, a *app, args []string) error {
+				if *limit < 1 {
+					return usageError("--limit has to be at least 1")
+				}
+				if err := a.checkEnvironment(args[0]); err != nil {
+					return err
+				}
+				s, store, err := a.planStore(ctx)
+				if err != nil {
+					return err
+				}
+				records, err := storedPlans(ctx, s, store, args[0], *limit)
+				if err != nil {
+					return err
+				}
+				return a.printPlans(args[0], records)
+			}
+		},
+	}
+}
+
+// storedPlans reads the newest limit plans of the environment with their sidecars, summaries and approvals
+
+func storedPlans(ctx context.Context, s settings, store storage.Backend, environment string, limit int) ([]planRecord, error) {
+	objects, err := store.List(ctx, storage.Key(s.S3Path, planStorePrefix+"/"+environment+"/"))
+	if err != nil {
+		return nil, err
+	}
+	var records []planRecord
+	for _, o := range objects {
+		if !strings.HasSuffix(o.Key, ".tfplan") {
+			continue
+		}
+		record := planRecord{Key: o.Key, CreatedAt: o.LastModified}
+		if t, ok := planNameTime(o.Key); ok {
+			record.CreatedAt = t
+		}
+		records = append(records, record)
+	}
+	slices.SortFunc(records, func(x, y planRecord) int {
+		if c := y.CreatedAt.Compare(x.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(y.Key, x.Key)
+	})
+	records = records[:min(len(records), limit)]
+
+	required := approvalsRequired(s, environment, false, false)
+	for i := range records {
+		r := &records[i]
+		if r.Artifact, err = readJSON[planArtifact](ctx, store, sidecarKey(r.Key)); err != nil {
+			return nil, err
+		}
+		if r.Summary, err = readJSON[plansummary.Summary](ctx, store, summaryKey(r.Key)); err != nil {
+			return nil, err
+		}
+		if r.Artifact != nil && !r.Artifact.CreatedAt.IsZero() {
+			r.CreatedAt = r.Artifact.CreatedAt
+		}
+		if r.Approvals, err = readApprovals(ctx, store, r.Key); err != nil {
+			return nil, err
+		}
+		r.Approval = approvalStatus(r.Artifact, r.Approvals, required)
+	}
+	return records, nil
+}
+
+// readJSON reads a sidecar, a missing one gives back nil and one that isn't JSON an error
+
+func readJSON[T any](ctx context.Context, store storage.Backend, key string) (*T, error) {
+	data, err := storage.GetBytes(ctx, store, key)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, configError("failed to read %s: %w", key, err)
+	}
+	return &v, nil
+}
+
+// approvalStatus counts the different approvers whose approval matches the hash the sidecar recorded, without a hash there is nothing to match
+
+func approvalStatus(artifact *planArtifact, approvals []planApproval, required int) string {
+	if artifact == nil || artifact.SHA256 == "" {
+		if len(approvals) == 0 && required == 0 {
+			return "not required"
+		}
+		return unknownField
+	}
+	var approvers []string
+	for _, approval := range approvals {
+		if approval.SHA256 == artifact.SHA256 && !slices.Contains(approvers, approval.Approver) {
+			approvers = append(approvers, approval.Approver)
+		}
+	}
+	switch {
+	case len(approvers) > 0 && len(approvers) >= required:
+		return "approved"
+	case required == 0:
+		return "not required"
+	}
+	return fmt.Sprintf("%d of %d", len(approvers), required)
+}
+
+func (a *app) printPlans(environment string, records []planRecord) error {
+	var rows [][]string
+	for _, r := range records {
+		a.out.Event("stored-plan", map[string]any{"environment": environment, "key": r.Key, "created_at": r.CreatedAt, "artifact": r.Artifact, "summary": r.Summary, "approvals": r.Approvals, "approval": r.Approval})
+		commit, author, applied := unknownField, unknownField, unknownField
+		if r.Artifact != nil {
+			commit = cmp.Or(gitinfo.Short(r.Artifact.Commit), unknownField)
+			author = cmp.Or(r.Artifact.Author, unknownField)
+			applied = "no"
+			if !r.Artifact.AppliedAt.IsZero() {
+				applied = r.Artifact.AppliedAt.Format(time.RFC3339)
+			}
+		}
+		add, change, destroy := unknownField, unknownField, unknownField
+		if r.Summary != nil {
+			add, change, destroy = strconv.Itoa(r.Summary.Add), strconv.Itoa(r.Summary.Change), strconv.Itoa(r.Summary.Destroy)
+		}
+		rows = append(rows, []string{r.CreatedAt.Format(time.RFC3339), commit, author, add, change, destroy, r.Approval, applied, path.Base(r.Key)})
+	}
+	if a.out.json {
+		return nil
+	}
+	if len(rows) == 0 {
+		a.out.Printf("No plans are stored for %s, run plan with --store-plan first\n", environment)
+		return nil
+	}
+	a.out.Table(a.out.humanOut(), []string{"CREATED", "COMMIT", "AUTHOR", "ADD", "CHANGE", "DESTROY", "APPROVAL", "APPLIED", "PLAN"}, rows, func(col int, cell string) string {
+		if col == 6 && cell == "approved" {
+			return a.out.green(cell)
+		}
+		return cell
+	})
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+func TestPlans(t *testing.T) {
+	_, store := withPlanStore(t)
+	ctx := context.Background()
+	put := func(key, body string) {
+		store.Put(ctx, storage.PutInput{Key: key, Body: strings.NewReader(body)})
+	}
+	// applied, with a summary and an approval that matches it
+	put("team/plans/prod/20240103T000000Z-ccccccc.tfplan", "plan bytes")
+	put("team/plans/prod/20240103T000000Z-ccccccc.tfplan.json", `{"environment":"prod","commit":"cccccccccc","author":"`+deployerARN+`","sha256":"`+planBytesSHA+`","applied_at":"2024-01-03T01:00:00Z"}`)
+	put("team/plans/prod/20240103T000000Z-ccccccc.tfplan.summary.json", `{"add":1,"change":2,"destroy":3}`)
+	data, _ := json.Marshal(planApproval{SHA256: planBytesSHA, Approver: reviewerARN})
+	put(approvalKey("team/plans/prod/20240103T000000Z-ccccccc.tfplan", reviewerARN), string(data))
+	// no sidecar at all
+	put("team/plans/prod/20240102T000000Z.tfplan", "plan bytes")
+	put("team/plans/prod/20240101T000000Z-aaaaaaa.tfplan", "plan bytes")
+	put("team/plans/dev/20240104T000000Z.tfplan", "plan bytes")
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"plans", "prod", "--limit", "2"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("plans: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("plans --limit 2 printed:\n%s", stdout.String())
+	}
+	if got := strings.Join(strings.Fields(lines[1]), " "); got != "2024-01-03T00:00:00Z ccccccc "+deployerARN+" 1 2 3 approved 2024-01-03T01:00:00Z 20240103T000000Z-ccccccc.tfplan" {
+		t.Errorf("the newest plan's row = %q", got)
+	}
+	if got := strings.Join(strings.Fields(lines[2]), " "); got != "2024-01-02T00:00:00Z unknown unknown unknown unknown unknown not required unknown 20240102T000000Z.tfplan" {
+		t.Errorf("a plan without a sidecar = %q", got)
+	}
+
+	stdout.Reset()
+	if err := runWithUI([]string{"--output", "json", "plans", "prod"}, &ui{json: true, stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("plans --output json: %v", err)
+	}
+	var records []map[string]any
+	for line := range strings.Lines(stdout.String()) {
+		var event map[string]any
+		if json.Unmarshal([]byte(line), &event) == nil && event["event"] == "stored-plan" {
+			records = append(records, event)
+		}
+	}
+	if len(records) != 3 || records[0]["key"] != "team/plans/prod/20240103T000000Z-ccccccc.tfplan" || records[2]["artifact"] != nil {
+		t.Fatalf("plans --output json printed:\n%s", stdout.String())
+	}
+	if artifact, _ := records[0]["artifact"].(map[string]any); artifact["author"] != deployerARN {
+		t.Errorf("the record's artifact = %v", records[0]["artifact"])
+	}
+
+	if err := run([]string{"plans", "prod", "--limit", "0"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("plans --limit 0: %v, want a usage error", err)
+	}
+}
+
+func TestApprovalStatus(t *testing.T) {
+	artifact := &planArtifact{SHA256: "new"}
+	approvals := []planApproval{{SHA256: "new", Approver: "a"}, {SHA256: "new", Approver: "a"}, {SHA256: "old", Approver: "b"}}
+	for _, tc := range []struct {
+		artifact  *planArtifact
+		approvals []planApproval
+		required  int
+		want      string
+	}{
+		{artifact, nil, 0, "not required"},
+		{artifact, approvals, 0, "approved"},
+		{artifact, approvals, 1, "approved"},
+		{artifact, approvals, 2, "1 of 2"},
+		{nil, approvals, 1, "unknown"},
+		{nil, nil, 0, "not required"},
+	} {
+		if got := approvalStatus(tc.artifact, tc.approvals, tc.required); got != tc.want {
+			t.Errorf("approvalStatus(%v, %d approvals, %d) = %q, want %q", tc.artifact, len(tc.approvals), tc.required, got, tc.want)
+		}
+	}
+}
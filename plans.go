@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/plansummary"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// Stored plans - plan --store-plan keeps the plan file in the bucket as plans/<env>/<timestamp>-<short sha>.tfplan with a JSON sidecar and a summary next to it, show and plan-diff read them back
+
+// planStorePrefix is where plans are kept in the bucket under S3_PATH, one folder per environment
+
+const planStorePrefix = "plans"
+
+// planTimeFormat is how the time a plan was made shows up at the start of its name
+
+const planTimeFormat = "20060102T150405Z"
+
+// defaultMaxPlanAge is how old a stored plan apply still takes
+
+const defaultMaxPlanAge = 24 * time.Hour
+
+// planArtifact is the sidecar stored at <plan key>.json
+
+type planArtifact struct {
+	Environment      string `json:"environment"`
+	TerraformVersion string `json:"terraform_version,omitempty"`
+	Commit           string `json:"commit,omitempty"`
+	// Author is the ARN of whoever stored the plan
+	Author    string    `json:"author,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// SHA256 is the checksum of the plan file when it was stored, approvals are checked against it
+	SHA256 string `json:"sha256,omitempty"`
+	// TFVarsSHA256 is the checksum of the tfvars the plan was made with, approvals go stale when it changes
+	TFVarsSHA256 string `json:"tfvars_sha256,omitempty"`
+	// KMSKeyARN is the key the plan and the sidecar are encrypted with in the bucket, empty when they are plaintext
+	KMSKeyARN string `json:"kms_key_arn,omitempty"`
+	// the Applied fields are set once the plan has been applied, with the versions it was applied with
+	AppliedAt               time.Time               `json:"applied_at,omitzero"`
+	AppliedTerraformVersion string                  `json:"applied_terraform_version,omitempty"`
+	AppliedProviders        []tfexec.LockedProvider `json:"applied_providers,omitempty"`
+}
+
+func sidecarKey(planKey string) string {
+	return planKey + ".json"
+}
+
+// summaryKey is where the add/change/destroy counts of a stored plan are kept, so listing plans doesn't need terraform
+
+func summaryKey(planKey string) string {
+	return planKey + ".summary.json"
+}
+
+// storedPlanName names a plan by when it was made and the commit it was made from, plans made outside git only get the time
+
+func storedPlanName(createdAt time.Time, commit string) string {
+	name := createdAt.Format(planTimeFormat)
+	if short := gitinfo.Short(commit); short != "" {
+		name += "-" + short
+	}
+	return name + ".tfplan"
+}
+
+// planNameTime is the time at the start of a stored plan's name, plans stored under other names have none
+
+func planNameTime(key string) (time.Time, bool) {
+	name := path.Base(key)
+	t, err := time.Parse(planTimeFormat, name[:min(len(name), len(planTimeFormat))])
+	return t, err == nil
+}
+
+// planEnvironment is the environment folder a stored plan's key is in
+
+func planEnvironment(s settings, key string) string {
+	rest, _ := strings.CutPrefix(key, storage.Key(s.S3Path, planStorePrefix+"/"))
+	environment, _, _ := strings.Cut(rest, "/")
+	return environment
+}
+
+// checkPlanEnvironment refuses a plan whose sidecar says it was made for another environment than the folder it is stored in
+
+func checkPlanEnvironment(s settings, key string, artifact planArtifact) error {
+	if environment := planEnvironment(s, key); artifact.Environment != "" && artifact.Environment != environment {
+		return withCode(exitCheck, fmt.Errorf("%s is stored with the plans of %s but its sidecar says it was made for %s", key, environment, artifact.Environment))
+	}
+	return nil
+}
+
+// planKey gives back the object key for a plan argument that looks like a stored plan - plans/<env>/<file>, with or without S3_PATH in front
+
+func planKey(s settings, arg string) (string, bool) {
+	if s.S3Path != "" && strings.HasPrefix(arg, s.S3Path+planStorePrefix+"/") {
+		return arg, true
+	}
+	if strings.HasPrefix(arg, planStorePrefix+"/") {
+		return storage.Key(s.S3Path, arg), true
+	}
+	return "", false
+}
+
+// planStore checks the bucket settings and gives back the store the plans are kept in
+
+func (a *app) planStore(ctx context.Context) (settings, storage.Backend, error) {
+	s, err := a.loadSettings()
+	if err != nil {
+		return settings{}, nil, err
+	}
+	if err := requirementsError("plan artifacts", checkRequirements("plan artifacts", "", s)); err != nil {
+		return settings{}, nil, err
+	}
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return settings{}, nil, err
+	}
+	return s, store, nil
+}
+
+// checkPlanEncryption refuses to store a protected environment's plan in plaintext - plans have every attribute of every resource in them, sensitive ones included
+
+func (a *app) checkPlanEncryption(s settings, environment string, allowPlaintext bool) error {
+	if key, _ := kmsKeyFor(s, environment); key != "" || !a.protectedEnvironment(environment) {
+		return nil
+	}
+	if !allowPlaintext {
+		return configError("the plan of %s would be stored unencrypted, set kms_key_arn for the environment or KMS_KEY_ARN, or pass --allow-plaintext-plan", environment)
+	}
+	a.out.Warnf("Storing the plan of %s unencrypted, it can contain the values of sensitive attributes", environment)
+	return nil
+}
+
+// storePlan uploads a saved plan, its sidecar and its summary, encrypted with the environment's KMS key when it has one. It gives back the key of the plan
+
+func storePlan(ctx context.Context, a *app, environment string, opts tfexec.PlanOptions) (string, error) {
+	s, store, err := a.planStore(ctx)
+	if err != nil {
+		return "", err
+	}
+	kmsKey, _ := kmsKeyFor(s, environment)
+	artifact := planArtifact{Environment: environment, Commit: gitinfo.Commit(ctx), CreatedAt: time.Now().UTC(), KMSKeyARN: kmsKey}
+	if opts.VarFile != "" {
+		if artifact.TFVarsSHA256, err = storage.FileChecksum(opts.VarFile); err != nil {
+			return "", err
+		}
+	}
+	if artifact.Author, err = callerIdentity(ctx, s); err != nil {
+		a.out.Warnf("Could not get your AWS identity for the plan's metadata: %v", err)
+	}
+	if version, err := tfexec.TerraformVersion(ctx, runner, a.terraformOutput()); err != nil {
+		a.out.Warnf("Could not get the terraform version for the plan's metadata: %v", err)
+	} else {
+		artifact.TerraformVersion = version.String()
+	}
+
+	key := storage.Key(s.S3Path, path.Join(planStorePrefix, environment, storedPlanName(artifact.CreatedAt, artifact.Commit)))
+	res, err := storage.UploadKey(ctx, store, key, opts.Out, storage.UploadOptions{KMSKeyID: kmsKey})
+	if err != nil {
+		return "", err
+	}
+	artifact.SHA256 = res.Checksum
+	sidecar, err := json.MarshalIndent(artifact, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the plan metadata: %w", err)
+	}
+	if _, err := storage.PutBytesEncrypted(ctx, store, sidecarKey(key), sidecar, kmsKey); err != nil {
+		return "", err
+	}
+	// the plan is stored either way, without a summary plans only lists it with unknown counts
+	if err := storePlanSummary(ctx, a, store, key, kmsKey, opts); err != nil {
+		a.out.Warnf("Could not store the plan's summary: %v", err)
+	}
+	a.out.Event("plan-store", map[string]any{"environment": environment, "bucket": s.S3Bucket, "key": key, "sha256": res.Checksum, "terraform_version": artifact.TerraformVersion, "kms_key_arn": kmsKey})
+	if kmsKey != "" {
+		a.out.Successf("Stored the plan as s3://%s/%s, encrypted with KMS key %s", s.S3Bucket, key, kmsKey)
+	} else {
+		a.out.Successf("Stored the plan as s3://%s/%s", s.S3Bucket, key)
+	}
+	return key, nil
+}
+
+func storePlanSummary(ctx context.Context, a *app, store storage.Backend, key, kmsKey string, opts tfexec.PlanOptions) error {
+	data, err := tfexec.Show(ctx, runner, tfexec.ShowOptions{Chdir: opts.Chdir, PlanFile: opts.Out, JSON: true}, a.terraformOutput())
+	if err != nil {
+		return err
+	}
+	summary, err := plansummary.Parse(data)
+	if err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the plan summary: %w", err)
+	}
+	_, err = storage.PutBytesEncrypted(ctx, store, summaryKey(key), data, kmsKey)
+	return err
+}
+
+// latestPlan is the newest plan stored for the environment
+
+func latestPlan(ctx context.Context, s settings, store storage.Backend, environment string) (string, error) {
+	prefix := storage.Key(s.S3Path, planStorePrefix+"/"+environment+"/")
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return "", err
+	}
+	// the time in the name is when the plan was made, copying it around the bucket changes LastModified but not that
+	var latest string
+	var latestTime time.Time
+	for _, o := range objects {
+		if !strings.HasSuffix(o.Key, ".tfplan") {
+			continue
+		}
+		t, ok := planNameTime(o.Key)
+		if !ok {
+			t = o.LastModified
+		}
+		if latest == "" || t.After(latestTime) || (t.Equal(latestTime) && o.Key > latest) {
+			latest, latestTime = o.Key, t
+		}
+	}
+	if latest == "" {
+		return "", configError("no plans are stored for %s under s3://%s/%s, run plan with --store-plan first", environment, s.S3Bucket, prefix)
+	}
+	return latest, nil
+}
+
+// downloadPlan gets a stored plan into a temp dir that cleanup removes
+
+func downloadPlan(ctx context.Context, a *app, s settings, store storage.Backend, key string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "tfmanage-plan-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create a temp dir for the plan: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	local := filepath.Join(dir, path.Base(key))
+	a.out.Printf("Downloading s3://%s/%s...\n", s.S3Bucket, key)
+	// encrypted and plaintext plans can sit side by side, the object says which one this is and S3 decrypts it
+	var kmsKey string
+	if info, err := store.Head(ctx, key); err == nil && info.KMSKeyID != "" {
+		kmsKey = info.KMSKeyID
+		a.out.Verbosef("s3://%s/%s is encrypted with KMS key %s\n", s.S3Bucket, key, kmsKey)
+	}
+	if _, err := storage.DownloadKey(ctx, store, key, local); err != nil {
+		cleanup()
+		return "", nil, kmsDecryptError(err, kmsKey)
+	}
+	return local, cleanup, nil
+}
+
+// readArtifact reads the sidecar of a stored plan, plans stored without one give an empty artifact
+
+func readArtifact(ctx context.Context, store storage.Backend, key string) planArtifact {
+	var artifact planArtifact
+	if data, err := storage.GetBytes(ctx, store, sidecarKey(key)); err == nil {
+		json.Unmarshal(data, &artifact)
+	}
+	return artifact
+}
+
+// fetchPlan gives back a local path for a plan argument. A local file always wins, stored plans are downloaded to a temp file that cleanup removes. With an environment, latest and bare file names are looked up under its plans too
+
+func fetchPlan(ctx context.Context, a *app, environment, arg string) (string, func(), error) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, func() {}, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", nil, fmt.Errorf("failed to read plan file %s: %w", arg, err)
+	}
+
+	s, err := a.loadSettings()
+	if err != nil {
+		return "", nil, err
+	}
+	key, stored := planKey(s, arg)
+	if !stored && environment == "" {
+		return "", nil, configError("plan file %s does not exist, and it isn't a key under %s/ in the bucket", arg, planStorePrefix)
+	}
+	s, store, err := a.planStore(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if !stored {
+		if key, err = resolvePlanKey(ctx, s, store, environment, arg); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := checkPlanEnvironment(s, key, readArtifact(ctx, store, key)); err != nil {
+		return "", nil, err
+	}
+	return downloadPlan(ctx, a, s, store, key)
+}
+
+func showCommand() *command {
+	return &command{
+		name:    "show",
+		args:    "<env> <plan-key|latest>",
+		summary: "Show a plan stored with plan --store-plan, as text or with --output json as JSON.",
+		examples: []string{
+			"tfmanage show prod latest",
+			"tfmanage show prod 20240501T130405Z-0123abc.tfplan",
+			"tfmanage --output json show prod plans/prod/20240501T130405Z-0123abc.tfplan",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform show in this directory")
+			return func(ctx context.Context, a *app, args []string) error {
+				if err := a.checkEnvironment(args[0]); err != nil {
+					return err
+				}
+				s, store, err := a.planStore(ctx)
+				if err != nil {
+					return err
+				}
+				key, err := resolvePlanKey(ctx, s, store, args[0], args[1])
+				if err != nil {
+					return err
+				}
+				return showStoredPlan(ctx, a, s, store, key, a.useEnvironment(args[0], *chdir))
+			}
+		},
+	}
+}
+
+// resolvePlanKey turns latest, a full key or a bare file name under the environment's plans into a key
+
+func resolvePlanKey(ctx context.Context, s settings, store storage.Backend, environment, arg string) (string, error) {
+	if arg == "latest" {
+		return latestPlan(ctx, s, store, environment)
+	}
+	if key, ok := planKey(s, arg); ok {
+		return key, nil
+	}
+	return storage.Key(s.S3Path, path.Join(planStorePrefix, environment, arg)), nil
+}
+
+func showStoredPlan(ctx context.Context, a *app, s settings, store storage.Backend, key, chdir string) error {
+	artifact := readArtifact(ctx, store, key)
+	if err := checkPlanEnvironment(s, key, artifact); err != nil {
+		return err
+	}
+	planFile, cleanup, err := downloadPlan(ctx, a, s, store, key)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	show := tfexec.ShowOptions{Chdir: chdir, PlanFile: planFile, JSON: a.out.json, NoColor: !a.out.color}
+	a.out.Verbosef("Running terraform %v\n", tfexec.ShowArgs(show))
+	data, err := tfexec.Show(ctx, runner, show, a.terraformOutput())
+	if errors.Is(err, tfexec.ErrPlanVersionMismatch) {
+		version := artifact.TerraformVersion
+		if version == "" {
+			version = "a version that wasn't recorded"
+		}
+		return configError("the plan was made with terraform %s, run show with that version: %w", version, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if a.out.json {
+		a.out.Event("plan-show", map[string]any{"key": key, "plan": json.RawMessage(data)})
+		return nil
+	}
+	_, err = a.out.humanOut().Write(data)
+	return err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/plansummary"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestPlanKey(t *testing.T) {
+	s := settings{S3Path: "team/"}
+	for arg, want := range map[string]string{
+		"plans/prod/a.tfplan":      "team/plans/prod/a.tfplan",
+		"team/plans/prod/a.tfplan": "team/plans/prod/a.tfplan",
+		"prod.tfplan":              "",
+	} {
+		if got, ok := planKey(s, arg); got != want || ok != (want != "") {
+			t.Errorf("planKey(%q) = %q, %v", arg, got, ok)
+		}
+	}
+}
+
+const planKMSKey = "arn:aws:kms:us-east-1:123456789012:key/plans"
+
+// withPlanStore runs terraform show as printing the plan file's own content, and version as 1.6.2, plans are encrypted with planKMSKey and stored by deployerARN
+
+func withPlanStore(t *testing.T) (*tfexec.RecordingRunner, *storage.MemoryStore) {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{OutputFor: func(args []string) string {
+		switch {
+		case args[0] == "version":
+			return `{"terraform_version":"1.6.2"}`
+		case slices.Contains(args, "show"):
+			data, _ := os.ReadFile(args[len(args)-1])
+			return string(data)
+		}
+		return ""
+	}}
+	useRunner(t, rec)
+	withTFVars(t, "prod")
+	store := withMemoryStore(t)
+	t.Setenv("GITHUB_SHA", "0123456789abcdef")
+	t.Setenv("KMS_KEY_ARN", planKMSKey)
+	withCaller(t, deployerARN)
+	return rec, store
+}
+
+func TestPlanStorePlan(t *testing.T) {
+	_, store := withPlanStore(t)
+	os.WriteFile("prod.tfplan", []byte("plan bytes"), 0o644)
+
+	if err := run([]string{"plan", "prod", "prod.tfplan", "--store-plan"}); err != nil {
+		t.Fatalf("plan --store-plan: %v", err)
+	}
+	objects, _ := store.List(context.Background(), "team/plans/prod/")
+	if len(objects) != 2 || !strings.HasSuffix(objects[0].Key, "-0123456.tfplan") || objects[1].Key != objects[0].Key+".json" {
+		t.Fatalf("stored objects = %+v, want the plan named after the commit and its sidecar", objects)
+	}
+	if data, _ := store.Bytes(objects[0].Key); string(data) != "plan bytes" {
+		t.Errorf("stored plan = %q", data)
+	}
+	for _, key := range []string{objects[0].Key, objects[1].Key} {
+		if head, _ := store.Head(context.Background(), key); head.KMSKeyID != planKMSKey {
+			t.Errorf("%s is encrypted with %q, want the environment's key", key, head.KMSKeyID)
+		}
+	}
+	var artifact planArtifact
+	data, _ := store.Bytes(objects[1].Key)
+	if err := json.Unmarshal(data, &artifact); err != nil || artifact.TerraformVersion != "1.6.2" || artifact.Commit != "0123456789abcdef" || artifact.Environment != "prod" || artifact.SHA256 != planBytesSHA || artifact.KMSKeyARN != planKMSKey || artifact.Author != deployerARN {
+		t.Errorf("sidecar = %s (%v)", data, err)
+	}
+}
+
+func TestStorePlanSummary(t *testing.T) {
+	withShowRunner(t)
+	store := withMemoryStore(t)
+	withCaller(t, deployerARN)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	t.Setenv("KMS_KEY_ARN", planKMSKey)
+	os.WriteFile("prod.tfplan", []byte(planB), 0o644)
+
+	if err := run([]string{"plan", "prod", "prod.tfplan", "--store-plan"}); err != nil {
+		t.Fatalf("plan --store-plan: %v", err)
+	}
+	objects, _ := store.List(context.Background(), "team/plans/prod/")
+	if len(objects) != 3 || objects[2].Key != objects[0].Key+".summary.json" {
+		t.Fatalf("stored objects = %+v, want the plan, its sidecar and its summary", objects)
+	}
+	var summary plansummary.Summary
+	data, _ := store.Bytes(objects[2].Key)
+	if err := json.Unmarshal(data, &summary); err != nil || summary.Add != 2 || summary.Destroy != 1 {
+		t.Errorf("summary = %s (%v)", data, err)
+	}
+	if head, _ := store.Head(context.Background(), objects[2].Key); head.KMSKeyID != planKMSKey {
+		t.Errorf("the summary is encrypted with %q, want the environment's key", head.KMSKeyID)
+	}
+}
+
+func TestStoredPlanName(t *testing.T) {
+	at := time.Date(2024, 5, 1, 13, 4, 5, 0, time.UTC)
+	if got := storedPlanName(at, "0123456789abcdef"); got != "20240501T130405Z-0123456.tfplan" {
+		t.Errorf("storedPlanName() = %q", got)
+	}
+	if got := storedPlanName(at, ""); got != "20240501T130405Z.tfplan" {
+		t.Errorf("storedPlanName() outside git = %q", got)
+	}
+}
+
+func TestStorePlanNeedsEncryption(t *testing.T) {
+	rec, store := withPlanStore(t)
+	t.Setenv("KMS_KEY_ARN", "")
+	os.WriteFile("prod.tfplan", []byte("plan bytes"), 0o644)
+
+	if err := run([]string{"plan", "prod", "prod.tfplan", "--store-plan"}); exitCodeFor(err) != exitConfig || !strings.Contains(err.Error(), "--allow-plaintext-plan") {
+		t.Fatalf("plan --store-plan for prod without a key: %v, want a config error", err)
+	}
+	if len(rec.Calls) != 0 || store.Puts() != 0 {
+		t.Fatalf("planned %d times and stored %d objects before refusing", len(rec.Calls), store.Puts())
+	}
+	if err := run([]string{"plan", "prod", "prod.tfplan", "--store-plan", "--allow-plaintext-plan"}); err != nil {
+		t.Fatalf("plan --store-plan --allow-plaintext-plan: %v", err)
+	}
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	os.WriteFile("dev.tfplan", []byte("plan bytes"), 0o644)
+	if err := run([]string{"plan", "dev", "dev.tfplan", "--store-plan"}); err != nil {
+		t.Errorf("plan --store-plan for dev without a key: %v", err)
+	}
+
+	// an encrypted plan next to the plaintext one, reading it without kms:Decrypt names the key
+	ctx := context.Background()
+	store.Put(ctx, storage.PutInput{Key: "team/plans/prod/20990101T000000Z.tfplan", Body: strings.NewReader("encrypted plan\n"), KMSKeyID: planKMSKey})
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"show", "prod", "20990101T000000Z.tfplan"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil || !strings.HasSuffix(stdout.String(), "encrypted plan\n") {
+		t.Errorf("show of an encrypted plan: %q, %v", stdout.String(), err)
+	}
+	store.GetErr = storage.ErrAccessDenied
+	err := run([]string{"show", "prod", "20990101T000000Z.tfplan"})
+	if exitCodeFor(err) != exitCredentials || !strings.Contains(err.Error(), "KMS key "+planKMSKey) {
+		t.Errorf("show without kms:Decrypt: %v, want a credentials error naming the key", err)
+	}
+}
+
+func TestShowLatest(t *testing.T) {
+	rec, store := withPlanStore(t)
+	ctx := context.Background()
+	store.Put(ctx, storage.PutInput{Key: "team/plans/prod/20240101T000000Z.tfplan", Body: strings.NewReader("old plan\n")})
+	time.Sleep(10 * time.Millisecond)
+	store.Put(ctx, storage.PutInput{Key: "team/plans/prod/20240102T000000Z.tfplan", Body: strings.NewReader("new plan\n")})
+	store.Put(ctx, storage.PutInput{Key: "team/plans/prod/20240102T000000Z.tfplan.json", Body: strings.NewReader("{}")})
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"show", "prod", "latest"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("show latest: %v", err)
+	}
+	if !strings.HasSuffix(stdout.String(), "new plan\n") {
+		t.Errorf("show printed %q, want the newest plan", stdout.String())
+	}
+	planFile := rec.Calls[0].Args[len(rec.Calls[0].Args)-1]
+	if _, err := os.Stat(planFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the downloaded plan %s was not cleaned up", planFile)
+	}
+
+	stdout.Reset()
+	if err := runWithUI([]string{"--output", "json", "show", "prod", "20240101T000000Z.tfplan"}, &ui{json: true, stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("show --output json: %v", err)
+	}
+	if args := rec.Calls[1].Args; !slices.Contains(args, "-json") {
+		t.Errorf("show ran %q, want -json", args)
+	}
+
+	if err := run([]string{"show", "dev", "latest"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("show latest without plans: %v, want a config error", err)
+	}
+}
+
+func TestApplyStoredPlan(t *testing.T) {
+	rec, store := withPlanStore(t)
+	ctx := context.Background()
+	newest := "team/plans/prod/20240102T000000Z-bbbbbbb.tfplan"
+	sidecar := func(createdAt time.Time, tfvarsSHA string) {
+		data, _ := json.Marshal(planArtifact{Environment: "prod", CreatedAt: createdAt, SHA256: planBytesSHA, TFVarsSHA256: tfvarsSHA})
+		store.Put(ctx, storage.PutInput{Key: newest + ".json", Body: bytes.NewReader(data)})
+	}
+	// the newest plan by its name was stored first, LastModified would pick the other one
+	store.Put(ctx, storage.PutInput{Key: newest, Body: strings.NewReader("plan bytes")})
+	sidecar(time.Now().Add(-time.Hour), "")
+	time.Sleep(10 * time.Millisecond)
+	store.Put(ctx, storage.PutInput{Key: "team/plans/prod/20240101T000000Z-aaaaaaa.tfplan", Body: strings.NewReader("old plan")})
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"apply", "prod", "--plan", "latest"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("apply --plan latest: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Using the stored plan s3://tfvars-bucket/"+newest) {
+		t.Errorf("the resolved key wasn't printed: %q", stdout.String())
+	}
+	i := slices.IndexFunc(rec.Calls, func(c tfexec.RecordedCall) bool { return c.Args[0] == "apply" })
+	if i < 0 || !strings.HasSuffix(rec.Calls[i].Args[len(rec.Calls[i].Args)-1], "20240102T000000Z-bbbbbbb.tfplan") {
+		t.Fatalf("apply ran %q, want the newest stored plan", rec.Args())
+	}
+
+	calls := len(rec.Calls)
+	sidecar(time.Now().Add(-30*time.Hour), "")
+	err := run([]string{"apply", "prod", "--plan", "latest"})
+	if exitCodeFor(err) != exitCheck || !strings.Contains(fmt.Sprint(err), "run 'tfmanage plan prod <plan-file> --store-plan' again") {
+		t.Errorf("apply of a 30h old plan: %v, want exit %d", err, exitCheck)
+	}
+	if len(rec.Calls) != calls {
+		t.Errorf("terraform ran for a stale plan: %q", rec.Args()[calls:])
+	}
+	if err := run([]string{"apply", "prod", "--plan", "20240102T000000Z-bbbbbbb.tfplan", "--max-plan-age", "48h"}); err != nil {
+		t.Errorf("apply of a 30h old plan with --max-plan-age 48h: %v", err)
+	}
+
+	sidecar(time.Now(), "0000")
+	if err := run([]string{"apply", "prod", "--plan", "latest"}); exitCodeFor(err) != exitCheck || !strings.Contains(fmt.Sprint(err), "prod.tfvars has changed") {
+		t.Errorf("apply of a plan made with other tfvars: %v, want exit %d", err, exitCheck)
+	}
+}
+
+func TestShowVersionMismatch(t *testing.T) {
+	rec, store := withPlanStore(t)
+	rec.Stderr = "plan file was created by Terraform 1.5.7, but this is 1.6.2; plan files cannot be transferred between different Terraform versions.\n"
+	rec.Result = func([]string) error { return &tfexec.FakeExitError{Code: 1} }
+	ctx := context.Background()
+	store.Put(ctx, storage.PutInput{Key: "team/plans/prod/p.tfplan", Body: strings.NewReader("plan")})
+	store.Put(ctx, storage.PutInput{Key: "team/plans/prod/p.tfplan.json", Body: strings.NewReader(`{"terraform_version":"1.5.7"}`)})
+
+	err := runWithUI([]string{"show", "prod", "p.tfplan"}, &ui{stdout: io.Discard, stderr: io.Discard})
+	if !errors.Is(err, tfexec.ErrPlanVersionMismatch) || exitCodeFor(err) != exitConfig || !strings.Contains(err.Error(), "terraform 1.5.7") {
+		t.Errorf("show with another terraform: %v (exit %d)", err, exitCodeFor(err))
+	}
+}
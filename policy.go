@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+// errPolicyDenied is returned when a rego policy denied the plan, each violation has been printed already
+
+var errPolicyDenied = withCode(exitCheck, errors.New("the plan was denied by policy"))
+
+func policyCheckCommand() *command {
+	return &command{
+		name:    "policy-check",
+		args:    "<env> <plan-file>",
+		summary: "Check a saved plan against the rego policies in --policy-dir with conftest.",
+		examples: []string{
+			"tfmanage policy-check prod prod.tfplan --policy-dir policy",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			policyDir := fs.String("policy-dir", "", "directory of rego policies (default hooks.policy_dir from the config)")
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			return func(ctx context.Context, a *app, args []string) error {
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				if _, ok := s.TFVars[args[0]]; !ok {
+					return usageError("invalid environment specified: %s", args[0])
+				}
+				dir := *policyDir
+				if dir == "" {
+					dir = s.Hooks.PolicyDir
+				}
+				if dir == "" {
+					return usageError("no policies to check, pass --policy-dir or set hooks.policy_dir in the config file")
+				}
+				planJSON, cleanup, err := exportPlan(ctx, a, tfexec.ShowOptions{Chdir: a.useEnvironment(args[0], *chdir), PlanFile: args[1]})
+				if err != nil {
+					return err
+				}
+				defer cleanup()
+				return checkPolicy(ctx, a, args[0], dir, s.Hooks.Conftest, planJSON)
+			}
+		},
+	}
+}
+
+// checkPolicy runs conftest on the exported plan - warnings are printed, any failure stops here
+
+func checkPolicy(ctx context.Context, a *app, env, policyDir, conftest, planJSON string) error {
+	if _, err := os.Stat(policyDir); err != nil {
+		return configError("policy directory %s: %v", policyDir, err)
+	}
+	a.out.Printf("Checking the plan against the policies in %s...\n", policyDir)
+	a.out.Verbosef("Running conftest %v\n", tools.ConftestArgs(policyDir, planJSON))
+	res, err := tools.Conftest(ctx, toolRunner, conftest, policyDir, planJSON)
+	if err != nil {
+		return err
+	}
+	a.out.Event("policy-check", map[string]any{"environment": env, "failures": res.Failures, "warnings": res.Warnings})
+	for _, w := range res.Warnings {
+		a.out.Warnf("policy warning (%s): %s", w.Namespace, w.Message)
+	}
+	for _, f := range res.Failures {
+		a.out.Failf("policy denied (%s): %s", f.Namespace, f.Message)
+	}
+	if res.Denied() {
+		return errPolicyDenied
+	}
+	a.out.Successf("The plan passed every policy")
+	return nil
+}
+
+// exportPlan writes the saved plan as JSON to a temp file for the checks to read
+
+func exportPlan(ctx context.Context, a *app, show tfexec.ShowOptions) (string, func(), error) {
+	show.JSON = true
+	data, err := tfexec.Show(ctx, runner, show, a.terraformOutput())
+	if err != nil {
+		return "", nil, err
+	}
+	return writeTempJSON(data)
+}
+
+// the helper tools all want the plan JSON in a file
+
+func writeTempJSON(data []byte) (string, func(), error) {
+	f, err := os.CreateTemp("", "tfmanage-plan-*.json")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to write the plan JSON: %w", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write the plan JSON: %w", err)
+	}
+	return f.Name(), cleanup, nil
+}
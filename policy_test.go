@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tools"
+)
+
+// withPolicyRunners swaps in recording runners, conftest answers with output
+
+func withPolicyRunners(t *testing.T, output string) (*tfexec.RecordingRunner, *tools.RecordingRunner) {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{Output: `{"format_version":"1.2"}`}
+	conftest := &tools.RecordingRunner{Result: func(tools.Command) ([]byte, error) {
+		if strings.Contains(output, `"failures"`) {
+			return []byte(output), &tools.Error{Tool: "conftest", ExitCode: 1}
+		}
+		return []byte(output), nil
+	}}
+	useRunner(t, rec)
+	useTools(t, conftest)
+	withTFVars(t, "dev")
+	os.Mkdir("policy", 0o755)
+	return rec, conftest
+}
+
+func TestApplyPolicyGateDenies(t *testing.T) {
+	rec, conftest := withPolicyRunners(t, `[{"namespace":"s3","failures":[{"msg":"bucket must not be public"}]}]`)
+
+	var stderr bytes.Buffer
+	err := runWithUI([]string{"apply", "dev", "--policy-dir", "policy"}, &ui{stdout: io.Discard, stderr: &stderr})
+	if !errors.Is(err, errPolicyDenied) || exitCodeFor(err) != exitCheck {
+		t.Fatalf("apply: %v, want the policy denial", err)
+	}
+	if !strings.Contains(stderr.String(), "policy denied (s3): bucket must not be public") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+
+	calls := rec.Args()
+	if len(calls) != 2 || calls[0][0] != "plan" || calls[1][0] != "show" {
+		t.Fatalf("terraform calls = %q, want plan and show only", calls)
+	}
+	planFile := calls[1][len(calls[1])-1]
+	if conftest.Calls[0].Args[2] != "policy" {
+		t.Errorf("conftest call = %+v", conftest.Calls[0])
+	}
+	if _, err := os.Stat(planFile); !os.IsNotExist(err) {
+		t.Errorf("temporary plan %s was left behind", planFile)
+	}
+}
+
+func TestApplyPolicyGateWarnsAndApplies(t *testing.T) {
+	rec, _ := withPolicyRunners(t, `[{"namespace":"tags","warnings":[{"msg":"missing owner tag"}]}]`)
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"apply", "dev", "--policy-dir", "policy"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "policy warning (tags): missing owner tag") {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+	calls := rec.Args()
+	if len(calls) != 4 || calls[2][0] != "apply" {
+		t.Fatalf("terraform calls = %q", calls)
+	}
+	// the plan that passed is the one applied
+	planned := calls[0][slices.Index(calls[0], "-out")+1]
+	checked, applied := calls[1][len(calls[1])-1], calls[2][len(calls[2])-1]
+	if planned != checked || checked != applied {
+		t.Errorf("planned %s, checked %s, applied %s", planned, checked, applied)
+	}
+}
+
+func TestPolicyCheckCommand(t *testing.T) {
+	rec, _ := withPolicyRunners(t, `[{"namespace":"main","successes":3}]`)
+
+	if err := run([]string{"policy-check", "dev", "plan.out", "--policy-dir", "policy"}); err != nil {
+		t.Fatalf("policy-check: %v", err)
+	}
+	if calls := rec.Args(); len(calls) != 1 || calls[0][0] != "show" {
+		t.Errorf("terraform calls = %q", calls)
+	}
+
+	err := run([]string{"policy-check", "dev", "plan.out"})
+	if exitCodeFor(err) != exitUsage {
+		t.Errorf("policy-check without a policy dir: %v, want a usage error", err)
+	}
+	err = run([]string{"policy-check", "dev", "plan.out", "--policy-dir", "nowhere"})
+	if exitCodeFor(err) != exitConfig {
+		t.Errorf("policy-check with a missing policy dir: %v, want a config error", err)
+	}
+}
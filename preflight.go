@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/iampolicy"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// preflight - checks the caller is allowed to make the AWS calls an operation makes, before anything has been half done. The IAM policy simulator gives a real answer, without it the reads are probed and the writes can't be checked
+
+const (
+	statusAllowed   = "allowed"
+	statusDenied    = "denied"
+	statusUnchecked = "unchecked"
+)
+
+var preflightOperations = []string{"upload", "download", "plan", "apply", "versions"}
+
+// simulatePolicy runs the IAM policy simulator for the principal - a variable so the tests don't need IAM
+
+var simulatePolicy = iamSimulate
+
+func iamSimulate(ctx context.Context, s settings, principal string, checks []iampolicy.Check) ([]iampolicy.Result, error) {
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return nil, err
+	}
+	return iampolicy.NewClient(cfg).Simulate(ctx, principal, checks)
+}
+
+// arnScope is what the ARNs of regional resources are built from
+
+type arnScope struct {
+	partition string
+	region    string
+	account   string
+}
+
+func (a arnScope) arn(service, resource string) string {
+	return fmt.Sprintf("arn:%s:%s:%s:%s:%s", a.partition, service, a.region, a.account, resource)
+}
+
+// scopeFor takes the partition and account from the caller's ARN
+
+func scopeFor(caller, region string) arnScope {
+	parts := strings.SplitN(caller, ":", 6)
+	scope := arnScope{partition: "aws", region: region, account: iampolicy.AccountID(caller)}
+	if len(parts) == 6 && parts[1] != "" {
+		scope.partition = parts[1]
+	}
+	return scope
+}
+
+// kmsKeyARN accepts the key forms the config does - an ARN, alias/name or a key ID
+
+func kmsKeyARN(key string, scope arnScope) string {
+	switch {
+	case strings.HasPrefix(key, "arn:"):
+		return key
+	case strings.HasPrefix(key, "alias/"):
+		return scope.arn("kms", key)
+	}
+	return scope.arn("kms", "key/"+key)
+}
+
+// tfvarsPermissions are the calls the tool makes for an operation on the environment's tfvars. plan and apply only read them, from the cache with --use-cache
+
+func tfvarsPermissions(s settings, environment, fileName, operation string, scope arnScope) ([]iampolicy.Check, error) {
+	env := s.Terraform[environment]
+	loc, err := parseLocation(env.Location)
+	if err != nil {
+		return nil, err
+	}
+	upload, versions := operation == "upload", operation == "versions"
+	var checks []iampolicy.Check
+	add := func(resource string, actions ...string) {
+		for _, action := range actions {
+			checks = append(checks, iampolicy.Check{Action: action, Resource: resource})
+		}
+	}
+
+	switch loc.scheme {
+	case fileScheme:
+		return nil, nil
+	case ssmScheme:
+		param := scope.arn("ssm", "parameter"+loc.name)
+		// chunks of files over 8KB live under the parameter
+		chunks := param + "/chunks/*"
+		switch {
+		case versions:
+			add(param, "ssm:GetParameterHistory")
+		case upload:
+			add(param, "ssm:PutParameter", "ssm:GetParameter")
+			add(chunks, "ssm:PutParameter", "ssm:GetParameter")
+		default:
+			add(param, "ssm:GetParameter")
+			add(chunks, "ssm:GetParameter")
+		}
+	case secretsManagerScheme:
+		// secret ARNs end in a random suffix
+		secret := scope.arn("secretsmanager", "secret:"+loc.name+"-*")
+		switch {
+		case versions:
+			add(secret, "secretsmanager:ListSecretVersionIds")
+		case upload:
+			add(secret, "secretsmanager:GetSecretValue", "secretsmanager:PutSecretValue", "secretsmanager:CreateSecret")
+		default:
+			add(secret, "secretsmanager:GetSecretValue")
+		}
+	default:
+		bucket, prefix := s.S3Bucket, s.S3Path
+		if loc.scheme == s3Scheme {
+			bucket, prefix = loc.bucket, loc.name
+		}
+		bucketARN := fmt.Sprintf("arn:%s:s3:::%s", scope.partition, bucket)
+		object := bucketARN + "/" + storage.Key(prefix, fileName)
+		switch {
+		case versions:
+			add(bucketARN, "s3:ListBucketVersions")
+		case upload:
+			add(bucketARN, "s3:ListBucket", "s3:GetBucketPublicAccessBlock", "s3:GetBucketPolicyStatus", "s3:GetBucketAcl", "s3:GetBucketOwnershipControls")
+			add(object, "s3:GetObject", "s3:PutObject")
+		default:
+			add(bucketARN, "s3:ListBucket")
+			add(object, "s3:GetObject")
+		}
+	}
+
+	if k, _ := kmsKeyFor(s, environment); k != "" && !versions && loc.scheme != fileScheme {
+		key := kmsKeyARN(k, scope)
+		if upload {
+			add(key, "kms:Encrypt", "kms:GenerateDataKey")
+		}
+		add(key, "kms:Decrypt")
+	}
+	return checks, nil
+}
+
+// permission is one check with its outcome and how it was found out
+
+type permission struct {
+	iampolicy.Check
+	Status string `json:"status"`
+	Source string `json:"source"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// checkPermissions asks the simulator, and probes the store when the simulator can't be used - the string says why it couldn't
+
+func (a *app) checkPermissions(ctx context.Context, s settings, caller string, loc tfvarsLocation, checks []iampolicy.Check) ([]permission, string) {
+	principal, err := iampolicy.PrincipalARN(caller)
+	if err == nil {
+		var results []iampolicy.Result
+		if results, err = simulatePolicy(ctx, s, principal, checks); err == nil {
+			var perms []permission
+			for _, r := range results {
+				p := permission{Check: r.Check, Status: statusAllowed, Source: "simulation"}
+				if !r.Allowed() {
+					p.Status, p.Detail = statusDenied, r.Decision
+				}
+				perms = append(perms, p)
+			}
+			return perms, ""
+		}
+	}
+
+	probed := map[string]permission{}
+	var perms []permission
+	for _, c := range checks {
+		p, ok := probed[c.Action]
+		if !ok {
+			p = probePermission(ctx, loc, c.Action)
+			probed[c.Action] = p
+		}
+		p.Check = c
+		perms = append(perms, p)
+	}
+	return perms, err.Error()
+}
+
+// probePermission makes the cheapest read that needs the action - writes are never tried
+
+func probePermission(ctx context.Context, loc tfvarsLocation, action string) permission {
+	p := permission{Source: "probe", Status: statusUnchecked}
+	var err error
+	switch action {
+	case "s3:GetObject", "ssm:GetParameter", "secretsmanager:GetSecretValue", "kms:Decrypt":
+		_, err = loc.store.Head(ctx, loc.key)
+	case "s3:ListBucket":
+		_, err = loc.store.List(ctx, loc.key)
+	case "s3:ListBucketVersions", "ssm:GetParameterHistory", "secretsmanager:ListSecretVersionIds":
+		_, err = loc.store.Versions(ctx, loc.key)
+	default:
+		p.Detail = "can't be checked without making the change"
+		return p
+	}
+	switch {
+	case err == nil, errors.Is(err, storage.ErrObjectNotFound):
+		p.Status = statusAllowed
+	case errors.Is(err, storage.ErrAccessDenied):
+		p.Status, p.Detail = statusDenied, err.Error()
+	default:
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+// preflight checks and prints the permissions of one operation, failing when any of them is denied
+
+func (a *app) preflight(ctx context.Context, s settings, environment, fileName, operation string) error {
+	if isLocalLocation(s, environment) {
+		a.out.Printf("%s %s: the tfvars are in a local directory, no AWS permissions are needed\n", operation, environment)
+		return nil
+	}
+	caller, err := callerIdentity(ctx, s)
+	if err != nil {
+		return err
+	}
+	checks, err := tfvarsPermissions(s, environment, fileName, operation, scopeFor(caller, s.AWSConfig.Region))
+	if err != nil {
+		return err
+	}
+	loc, err := a.locate(ctx, s, environment, fileName)
+	if err != nil {
+		return err
+	}
+	perms, fallback := a.checkPermissions(ctx, s, caller, loc, checks)
+
+	denied := 0
+	var rows [][]string
+	for _, p := range perms {
+		if p.Status == statusDenied {
+			denied++
+		}
+		a.out.Event("permission", map[string]any{"environment": environment, "operation": operation, "action": p.Action, "resource": p.Resource, "status": p.Status, "source": p.Source, "detail": p.Detail})
+		rows = append(rows, []string{p.Action, p.Resource, p.Status, p.Detail})
+	}
+	if !a.out.json {
+		if fallback == "" {
+			a.out.Printf("%s %s, simulated for %s:\n", operation, environment, caller)
+		} else {
+			a.out.Printf("%s %s, the policy simulator can't be used (%s) so this is best effort from probing %s:\n", operation, environment, fallback, loc.url(loc.key))
+		}
+		a.out.Table(a.out.humanOut(), []string{"ACTION", "RESOURCE", "RESULT", "DETAIL"}, rows, func(col int, cell string) string {
+			if col == 2 {
+				return a.out.statusColor(cell)
+			}
+			return cell
+		})
+	}
+	if denied > 0 {
+		return withCode(exitCheck, fmt.Errorf("%d of the %d permissions %s %s needs are denied", denied, len(perms), operation, environment))
+	}
+	return nil
+}
+
+// storeOperation is the operation whose requirements cover reaching the environment's store
+
+func storeOperation(operation string) string {
+	if operation == "upload" {
+		return "upload"
+	}
+	return "download"
+}
+
+func preflightCommand() *command {
+	return &command{
+		name:    "preflight",
+		args:    "<env> <operation>",
+		summary: "Check the AWS credentials are allowed to make the calls an operation needs, with the IAM policy simulator when permitted.",
+		examples: []string{
+			"tfmanage preflight prod upload",
+			"tfmanage preflight dev download --output json",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(ctx context.Context, a *app, args []string) error {
+				environment, operation := args[0], args[1]
+				if !slices.Contains(preflightOperations, operation) {
+					return usageError("unknown operation %q, use one of %s", operation, strings.Join(preflightOperations, ", "))
+				}
+				fileName, err := a.tfvarsFor(environment)
+				if err != nil {
+					return err
+				}
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				if err := requirementsError("preflight", checkStoreRequirements(storeOperation(operation), environment, s)); err != nil {
+					return err
+				}
+				return a.preflight(ctx, s, environment, fileName, operation)
+			}
+		},
+	}
+}
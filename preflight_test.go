@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/iampolicy"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// withSimulator makes the policy simulator deny the given actions, or fail with err when it is set
+
+func withSimulator(t *testing.T, err error, denied ...string) *string {
+	t.Helper()
+	var principal string
+	swap(t, &simulatePolicy, func(_ context.Context, _ settings, p string, checks []iampolicy.Check) ([]iampolicy.Result, error) {
+		principal = p
+		if err != nil {
+			return nil, err
+		}
+		var results []iampolicy.Result
+		for _, c := range checks {
+			decision := iampolicy.Allowed
+			if strings.Contains(strings.Join(denied, ","), c.Action) {
+				decision = iampolicy.ImplicitDeny
+			}
+			results = append(results, iampolicy.Result{Check: c, Decision: decision})
+		}
+		return results, nil
+	})
+	return &principal
+}
+
+// permissionEvents runs args in json mode and gives back the status of every permission event by action
+
+func permissionEvents(t *testing.T, args ...string) (map[string]string, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	err := runWithUI(append([]string{"--output", "json"}, args...), &ui{json: true, stdout: &stdout, stderr: io.Discard})
+	statuses := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var event struct {
+			Event    string `json:"event"`
+			Action   string `json:"action"`
+			Resource string `json:"resource"`
+			Status   string `json:"status"`
+		}
+		if json.Unmarshal([]byte(line), &event); event.Event == "permission" {
+			statuses[event.Action+" "+event.Resource] = event.Status
+		}
+	}
+	return statuses, err
+}
+
+func TestPreflightSimulation(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	withCaller(t, deployerARN)
+	principal := withSimulator(t, nil, "s3:PutObject")
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	statuses, err := permissionEvents(t, "preflight", "dev", "download")
+	if err != nil {
+		t.Fatalf("preflight download: %v", err)
+	}
+	if *principal != "arn:aws:iam::123456789012:role/deploy" {
+		t.Errorf("simulated for %q, want the role of the session", *principal)
+	}
+	if statuses["s3:GetObject arn:aws:s3:::tfvars-bucket/team/dev.tfvars"] != statusAllowed || statuses["s3:ListBucket arn:aws:s3:::tfvars-bucket"] != statusAllowed {
+		t.Errorf("permissions = %v, want GetObject on the key and ListBucket on the bucket", statuses)
+	}
+
+	statuses, err = permissionEvents(t, "preflight", "dev", "upload")
+	if exitCodeFor(err) != exitCheck || statuses["s3:PutObject arn:aws:s3:::tfvars-bucket/team/dev.tfvars"] != statusDenied {
+		t.Errorf("preflight upload without PutObject: %v, %v, want a check failure", statuses, err)
+	}
+
+	if err := run([]string{"preflight", "dev", "destroy"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("preflight of an unknown operation: %v, want a usage error", err)
+	}
+}
+
+func TestPreflightProbes(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	withCaller(t, deployerARN)
+	withSimulator(t, iampolicy.ErrSimulationDenied)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	statuses, err := permissionEvents(t, "preflight", "dev", "upload")
+	if err != nil {
+		t.Fatalf("preflight upload: %v", err)
+	}
+	if statuses["s3:GetObject arn:aws:s3:::tfvars-bucket/team/dev.tfvars"] != statusAllowed || statuses["s3:PutObject arn:aws:s3:::tfvars-bucket/team/dev.tfvars"] != statusUnchecked {
+		t.Errorf("permissions = %v, want the read probed and the write unchecked", statuses)
+	}
+
+	store.HeadErr = storage.ErrAccessDenied
+	statuses, err = permissionEvents(t, "preflight", "dev", "download")
+	if exitCodeFor(err) != exitCheck || statuses["s3:GetObject arn:aws:s3:::tfvars-bucket/team/dev.tfvars"] != statusDenied {
+		t.Errorf("preflight download with HeadObject denied: %v, %v", statuses, err)
+	}
+
+	// env check --deep runs the same checks
+	if err := run([]string{"env", "check", "download", "dev", "--deep"}); exitCodeFor(err) != exitCheck {
+		t.Errorf("env check --deep: %v, want the denied permission to fail it", err)
+	}
+	if err := run([]string{"env", "check", "--deep"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("env check --deep without an environment: %v, want a usage error", err)
+	}
+}
+
+func TestTFVarsPermissions(t *testing.T) {
+	inTempDir(t)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  small:\n    tfvars: small.tfvars\n    location: ssm:///tfvars/small\n    kms_key: alias/tfvars\n"), 0o644)
+	s, err := loadSettings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope := scopeFor(deployerARN, "eu-west-1")
+	checks, err := tfvarsPermissions(s, "small", "small.tfvars", "upload", scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range checks {
+		got = append(got, c.Action+" "+c.Resource)
+	}
+	for _, want := range []string{
+		"ssm:PutParameter arn:aws:ssm:eu-west-1:123456789012:parameter/tfvars/small",
+		"ssm:GetParameter arn:aws:ssm:eu-west-1:123456789012:parameter/tfvars/small/chunks/*",
+		"kms:GenerateDataKey arn:aws:kms:eu-west-1:123456789012:alias/tfvars",
+		"kms:Decrypt arn:aws:kms:eu-west-1:123456789012:alias/tfvars",
+	} {
+		if !strings.Contains(strings.Join(got, "\n"), want) {
+			t.Errorf("permissions %v are missing %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func providersCommand() *command {
+	return &command{
+		name:    "providers",
+		args:    "mirror <dir> | lock [env]",
+		summary: "Mirror the providers to a directory for airgapped use, or regenerate .terraform.lock.hcl.",
+		examples: []string{
+			"tfmanage providers mirror ./mirror --platform linux_amd64 --platform darwin_arm64",
+			"tfmanage providers mirror ./mirror --platform linux_amd64 --sync-prefix provider-mirror/",
+			"tfmanage providers lock --platform linux_amd64 --platform darwin_arm64",
+			"tfmanage providers lock prod --platform linux_amd64 --sync-lockfile",
+		},
+		minArgs: 1,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			var platforms stringList
+			fs.Var(&platforms, "platform", "a platform to get the providers for, like linux_amd64 (repeatable, default this machine's)")
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			syncPrefix := fs.String("sync-prefix", "", "mirror: upload the mirror to this prefix under S3_PATH in the bucket")
+			noIgnore := fs.Bool("no-ignore", false, "mirror: also sync the files .tfmanageignore and the built-in ignore rules leave out")
+			syncLockfile := fs.Bool("sync-lockfile", false, "lock: start from the environment's canonical lock file and upload the result as the new one")
+			return func(ctx context.Context, a *app, args []string) error {
+				switch args[0] {
+				case "mirror":
+					if len(args) != 2 {
+						return usageError("providers mirror needs the directory to mirror into")
+					}
+					if *syncPrefix != "" {
+						s, err := a.loadSettings()
+						if err != nil {
+							return err
+						}
+						if err := requirementsError("providers sync", checkRequirements("providers sync", "", s)); err != nil {
+							return err
+						}
+					}
+					return mirrorProviders(ctx, a, *chdir, args[1], platforms, *syncPrefix, *noIgnore)
+				case "lock":
+					if *syncPrefix != "" {
+						return usageError("--sync-prefix only works with providers mirror")
+					}
+					if len(args) == 1 {
+						if *syncLockfile {
+							return usageError("providers lock --sync-lockfile needs the environment whose lock file to update")
+						}
+						return lockProviders(ctx, a, *chdir, platforms)
+					}
+					environment := args[1]
+					if err := a.checkEnvironment(environment); err != nil {
+						return err
+					}
+					dir := a.useEnvironment(environment, *chdir)
+					if *syncLockfile {
+						if err := downloadLockfile(ctx, a, environment, dir, false); err != nil {
+							return err
+						}
+					}
+					if err := lockProviders(ctx, a, dir, platforms); err != nil {
+						return err
+					}
+					if *syncLockfile {
+						return uploadLockfile(ctx, a, environment, dir, false)
+					}
+					return nil
+				}
+				return usageError("unknown providers subcommand %q, use mirror or lock", args[0])
+			}
+		},
+	}
+}
+
+// requireTerraform fails with a config error when the installed terraform is older than the command needs
+
+func requireTerraform(ctx context.Context, a *app, command string, since tfexec.Version) error {
+	version, err := tfexec.TerraformVersion(ctx, runner, a.terraformOutput())
+	if err != nil {
+		return err
+	}
+	if !version.AtLeast(since) {
+		return configError("terraform %s does not have %s, it needs %s or newer", version, command, since)
+	}
+	return nil
+}
+
+func mirrorProviders(ctx context.Context, a *app, chdir, dir string, platforms []string, syncPrefix string, noIgnore bool) error {
+	if err := requireTerraform(ctx, a, "providers mirror", tfexec.ProvidersMirrorSince); err != nil {
+		return err
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.ProvidersMirrorArgs(chdir, dir, platforms))
+	count, err := tfexec.ProvidersMirror(ctx, runner, chdir, dir, platforms, a.streamOutput())
+	if err != nil {
+		return err
+	}
+	a.out.Event("providers-mirror", map[string]any{"dir": dir, "platforms": platforms, "providers": count})
+	a.out.Successf("Mirrored %d provider(s) to %s", count, dir)
+
+	if syncPrefix == "" {
+		return nil
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return err
+	}
+	skip, err := a.ignoreRules(dir, noIgnore)
+	if err != nil {
+		return err
+	}
+	prefix := storage.Key(s.S3Path, strings.TrimSuffix(syncPrefix, "/")+"/")
+	a.out.Printf("Syncing %s to s3://%s/%s...\n", dir, s.S3Bucket, prefix)
+	results, err := storage.UploadDir(ctx, store, prefix, dir, storage.UploadOptions{SkipUnchanged: true, Skip: skip})
+	if err != nil {
+		return err
+	}
+	uploaded := 0
+	for _, r := range results {
+		if !r.Skipped {
+			uploaded++
+		}
+	}
+	a.out.Event("providers-sync", map[string]any{"bucket": s.S3Bucket, "prefix": prefix, "files": len(results), "uploaded": uploaded})
+	a.out.Successf("Synced the mirror to s3://%s/%s, %d of %d file(s) uploaded", s.S3Bucket, prefix, uploaded, len(results))
+	return nil
+}
+
+func lockProviders(ctx context.Context, a *app, chdir string, platforms []string) error {
+	if err := requireTerraform(ctx, a, "providers lock", tfexec.ProvidersLockSince); err != nil {
+		return err
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.ProvidersLockArgs(chdir, platforms))
+	count, err := tfexec.ProvidersLock(ctx, runner, chdir, platforms, a.streamOutput())
+	if err != nil {
+		return err
+	}
+	a.out.Event("providers-lock", map[string]any{"platforms": platforms, "providers": count})
+	a.out.Successf("Locked %d provider(s) in .terraform.lock.hcl", count)
+	return nil
+}
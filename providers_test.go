@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func withProvidersRunner(t *testing.T, version string) *tfexec.RecordingRunner {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{OutputFor: func(args []string) string {
+		switch {
+		case args[0] == "version":
+			return `{"terraform_version":"` + version + `"}`
+		case slices.Contains(args, "mirror"):
+			// terraform writes the mirror, the test only needs a file in it
+			dir := args[len(args)-1]
+			os.MkdirAll(filepath.Join(dir, "registry.terraform.io", "hashicorp", "aws"), 0o755)
+			os.WriteFile(filepath.Join(dir, "registry.terraform.io", "hashicorp", "aws", "index.json"), []byte("{}"), 0o644)
+			return "- Mirroring hashicorp/aws...\n  - Downloading package for linux_amd64...\n"
+		}
+		return "- Fetching hashicorp/aws 5.31.0 for linux_amd64...\n- Fetching hashicorp/random 3.6.0 for linux_amd64...\n"
+	}}
+	useRunner(t, rec)
+	inTempDir(t)
+	return rec
+}
+
+func TestProvidersMirrorAndSync(t *testing.T) {
+	rec := withProvidersRunner(t, "1.6.2")
+	store := withMemoryStore(t)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"providers", "mirror", "mirror", "--platform", "linux_amd64", "--platform", "darwin_arm64", "--sync-prefix", "provider-mirror"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if err != nil {
+		t.Fatalf("providers mirror: %v", err)
+	}
+	calls := rec.Args()
+	if len(calls) != 2 || !slices.Equal(calls[1][:4], []string{"providers", "mirror", "-platform=linux_amd64", "-platform=darwin_arm64"}) {
+		t.Fatalf("terraform calls = %q", calls)
+	}
+	if !strings.Contains(stdout.String(), "Mirrored 1 provider(s)") {
+		t.Errorf("output = %q, want the provider count", stdout.String())
+	}
+	if data, _ := store.Bytes("team/provider-mirror/registry.terraform.io/hashicorp/aws/index.json"); string(data) != "{}" {
+		t.Errorf("mirror was not synced, got %q", data)
+	}
+	objects, _ := store.List(context.Background(), "team/provider-mirror/")
+	if len(objects) != 1 {
+		t.Errorf("synced objects = %+v", objects)
+	}
+}
+
+func TestProvidersLock(t *testing.T) {
+	rec := withProvidersRunner(t, "1.6.2")
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"providers", "lock", "--platform", "linux_amd64", "--chdir", "infra"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("providers lock: %v", err)
+	}
+	if want := []string{"-chdir=infra", "providers", "lock", "-platform=linux_amd64"}; !slices.Equal(rec.Args()[1], want) {
+		t.Errorf("lock ran %q, want %q", rec.Args()[1], want)
+	}
+	if !strings.Contains(stdout.String(), "Locked 2 provider(s)") {
+		t.Errorf("output = %q, want the provider count", stdout.String())
+	}
+
+	for _, args := range [][]string{{"providers", "lock", "extra"}, {"providers", "mirror"}, {"providers", "list"}, {"providers", "lock", "--sync-prefix", "x"}} {
+		if err := run(args); exitCodeFor(err) != exitUsage {
+			t.Errorf("%q gave %v, want a usage error", args, err)
+		}
+	}
+}
+
+func TestProvidersNeedNewEnoughTerraform(t *testing.T) {
+	rec := withProvidersRunner(t, "0.13.7")
+
+	if err := run([]string{"providers", "lock"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("lock on 0.13: %v, want a config error", err)
+	}
+	if err := run([]string{"providers", "mirror", "mirror"}); err != nil {
+		t.Errorf("mirror on 0.13: %v", err)
+	}
+	if calls := rec.Args(); len(calls) != 3 {
+		t.Errorf("terraform calls = %q, want lock to stop after the version", calls)
+	}
+}
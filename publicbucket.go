@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// Uploads refuse a bucket anyone could read, tfvars are full of secrets, and one of another account - the verdict is kept per bucket so a run that uploads several files only asks once
+
+var errPublicBucket = errors.New("the bucket allows public access")
+
+// bucketCheck is what the upload flags say about the bucket checks
+
+type bucketCheck struct {
+	allowPublic       bool
+	allowCrossAccount bool
+	// strict fails the upload when the settings can't be read, instead of warning
+	strict bool
+}
+
+func (a *app) checkBucket(ctx context.Context, loc tfvarsLocation, check bucketCheck) error {
+	if loc.bucket == "" {
+		return nil
+	}
+	if err, done := a.bucketVerdicts[loc.bucket]; done {
+		return err
+	}
+	var err error
+	if checker, ok := loc.store.(storage.OwnershipChecker); ok {
+		err = a.bucketOwnerVerdict(ctx, checker, loc.bucket, check)
+	}
+	if checker, ok := loc.store.(storage.PublicAccessChecker); ok && err == nil {
+		err = a.publicAccessVerdict(ctx, checker, loc.bucket, check)
+	}
+	if a.bucketVerdicts == nil {
+		a.bucketVerdicts = map[string]error{}
+	}
+	a.bucketVerdicts[loc.bucket] = err
+	return err
+}
+
+func (a *app) publicAccessVerdict(ctx context.Context, checker storage.PublicAccessChecker, bucket string, check bucketCheck) error {
+	access, err := checker.PublicAccess(ctx)
+	if err != nil {
+		if check.strict {
+			return withCode(exitCheck, fmt.Errorf("couldn't check whether s3://%s allows public access, and --strict needs it checked: %w", bucket, err))
+		}
+		a.out.Warnf("Couldn't check whether s3://%s allows public access, uploading anyway: %v", bucket, err)
+		return nil
+	}
+	problems := access.Problems()
+	a.out.Event("bucket-check", map[string]any{"bucket": bucket, "public": len(problems) > 0, "problems": problems, "allowed": check.allowPublic})
+	if len(problems) == 0 {
+		return nil
+	}
+	if check.allowPublic {
+		a.out.Warnf("s3://%s allows public access, uploading anyway because of --allow-public-bucket", bucket)
+		return nil
+	}
+	return withCode(exitCheck, fmt.Errorf("%w, refusing to upload tfvars to s3://%s:\n  - %s\nturn on all four Block Public Access settings and remove any public bucket policy, or pass --allow-public-bucket if it has to stay public",
+		errPublicBucket, bucket, strings.Join(problems, "\n  - ")))
+}
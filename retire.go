@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/statediff"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// retire - the end of an environment. Everything the tool keeps for it, the tfvars and their journal and files, the plans, bundles, state backups and snapshots, apply records and the audit trail, moves under archive/<env>/<date>/ in the bucket and the environment leaves the config file. The state must have no resources left in it, an environment that still has infrastructure isn't retired by accident
+
+const archivePrefix = "archive"
+
+// retiredPrefixes are the bucket folders that have a folder per environment under S3_PATH
+
+var retiredPrefixes = []string{planStorePrefix, bundleStorePrefix, stateBackupPrefix, stateSnapshotPrefix, applyRecordPrefix, auditPrefix}
+
+// archiveStorageClass is what --glacier moves the archive to, --storage-class picks any other
+
+const archiveStorageClass = "GLACIER"
+
+// archivedObject is one object retire moves, from wherever it is to target in the archive bucket
+
+type archivedObject struct {
+	store  storage.Backend
+	key    string
+	url    string
+	target string
+	// sameBucket objects are copied by S3 itself, the others are read and written again
+	sameBucket bool
+}
+
+type retireRequest struct {
+	chdir, storageClass         string
+	dryRun, glacier, yes        bool
+	ignoreLiveState, keepConfig bool
+}
+
+func retireCommand() *command {
+	return &command{
+		name:    "retire",
+		args:    "<env>",
+		summary: "Retire an environment: move its tfvars, plans, bundles, state backups and snapshots, apply records and audit trail under archive/<env>/<date>/ in the bucket and take it out of the config file.",
+		examples: []string{
+			"tfmanage retire qa --dry-run",
+			"tfmanage retire qa --glacier",
+			"tfmanage retire qa --storage-class DEEP_ARCHIVE",
+			"tfmanage retire sandbox --ignore-live-state --yes",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			dryRun := fs.Bool("dry-run", false, "list the objects that would move and change nothing")
+			glacier := fs.Bool("glacier", false, "store the archive in the "+archiveStorageClass+" storage class, the same as --storage-class "+archiveStorageClass)
+			storageClass := fs.String("storage-class", "", "the S3 storage class of the archive: "+strings.Join(storage.StorageClasses, ", ")+" (default STANDARD)")
+			ignoreLive := fs.Bool("ignore-live-state", false, "retire the environment even though its state still has resources, or can't be read")
+			keep := fs.Bool("keep-config", false, "leave the environment in the config file")
+			yes := fs.Bool("yes", false, "don't ask for the environment name first")
+			chdir := fs.String("chdir", "", "run terraform in this directory for the state check")
+			return func(ctx context.Context, a *app, args []string) error {
+				if err := checkStorageClass(*storageClass); err != nil {
+					return err
+				}
+				if *glacier && *storageClass != "" && *storageClass != archiveStorageClass {
+					return usageError("--glacier is --storage-class %s, it can't be used with --storage-class %s", archiveStorageClass, *storageClass)
+				}
+				return a.retireEnvironment(ctx, args[0], retireRequest{chdir: *chdir, storageClass: *storageClass, dryRun: *dryRun, glacier: *glacier, yes: *yes, ignoreLiveState: *ignoreLive, keepConfig: *keep})
+			}
+		},
+	}
+}
+
+func (a *app) retireEnvironment(ctx context.Context, environment string, req retireRequest) error {
+	fileName, err := a.tfvarsFor(environment)
+	if err != nil {
+		return err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	if err := requirementsError("retire", checkStoreRequirements("download", environment, s)); err != nil {
+		return err
+	}
+	if err := requirementsError("retire", checkRequirements("plan artifacts", "", s)); err != nil {
+		return err
+	}
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return err
+	}
+	archive := storage.Key(s.S3Path, path.Join(archivePrefix, environment, time.Now().UTC().Format("2006-01-02"))+"/")
+	objects, err := a.retiredObjects(ctx, s, store, environment, fileName, archive)
+	if err != nil {
+		return err
+	}
+	storageClass := req.storageClass
+	if req.glacier {
+		storageClass = archiveStorageClass
+	}
+
+	liveErr := a.checkNoLiveState(ctx, environment, req)
+	if req.dryRun {
+		a.reportRetirement(environment, objects, true)
+		a.out.Event("retire", map[string]any{"environment": environment, "archive": "s3://" + s.S3Bucket + "/" + archive, "objects": len(objects), "storage_class": storageClass, "dry_run": true})
+		a.out.Printf("Dry run: %d object(s) would move to s3://%s/%s, nothing was changed\n", len(objects), s.S3Bucket, archive)
+		return liveErr
+	}
+	if liveErr != nil {
+		return liveErr
+	}
+	if !req.yes {
+		warning := fmt.Sprintf("retire moves %d object(s) of %s to s3://%s/%s and deletes them from where they are.", len(objects), environment, s.S3Bucket, archive)
+		if err := a.askToConfirm(environment, "retire", warning); err != nil {
+			return err
+		}
+	}
+
+	// every copy is made before anything is deleted, a failure half way leaves the originals where they were
+	kmsKey, _ := kmsKeyFor(s, environment)
+	for _, o := range objects {
+		if err := archiveObject(ctx, store, o, storageClass, kmsKey); err != nil {
+			return fmt.Errorf("failed to archive %s, nothing was deleted: %w", o.url, err)
+		}
+	}
+	for _, o := range objects {
+		if err := o.store.Delete(ctx, o.key); err != nil {
+			return fmt.Errorf("failed to delete %s, it is archived already: %w", o.url, err)
+		}
+	}
+	a.reportRetirement(environment, objects, false)
+
+	configFile, removed := "", false
+	if !req.keepConfig {
+		configFile = cmp.Or(s.ConfigFile, config.DefaultFile)
+		if removed, err = config.RemoveEnvironment(configFile, environment); err != nil {
+			return configError("the objects of %s are archived, but it couldn't be taken out of the config file: %v", environment, err)
+		}
+		a.settings = nil
+	}
+	a.out.Event("retire", map[string]any{"environment": environment, "archive": "s3://" + s.S3Bucket + "/" + archive, "objects": len(objects), "storage_class": storageClass, "config_file": configFile, "removed_from_config": removed})
+	a.out.Successf("Retired %s: %d object(s) archived in s3://%s/%s", environment, len(objects), s.S3Bucket, archive)
+	switch {
+	case removed:
+		a.out.Printf("Removed %s from %s\n", environment, configFile)
+	case slices.Contains(builtinEnvironments, environment):
+		a.out.Warnf("%s is a built-in environment, unset %s so nothing uses it again", environment, tfvarsEnvVar(environment))
+	case !req.keepConfig:
+		a.out.Warnf("%s isn't in %s, take it out of wherever it is configured", environment, configFile)
+	}
+	a.out.Printf("The local %s is left as it is, delete it once nothing needs it\n", fileName)
+	return nil
+}
+
+// retiredObjects lists everything of the environment that retire moves, with the key each one gets under archive
+
+func (a *app) retiredObjects(ctx context.Context, s settings, store storage.Backend, environment, fileName, archive string) ([]archivedObject, error) {
+	var objects []archivedObject
+	loc, err := a.locate(ctx, s, environment, fileName)
+	if err != nil {
+		return nil, err
+	}
+	sameBucket := loc.scheme == "" && loc.bucket == s.S3Bucket
+	if _, err := loc.store.Head(ctx, loc.key); err == nil {
+		objects = append(objects, archivedObject{store: loc.store, key: loc.key, url: loc.url(loc.key), target: archive + "tfvars/" + path.Base(fileName), sameBucket: sameBucket})
+	} else if !errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, err
+	}
+
+	// the journal and the files sit next to the tfvars, SSM and Secrets Manager have neither
+	if loc.scheme != ssmScheme && loc.scheme != secretsManagerScheme {
+		base := strings.TrimSuffix(loc.key, fileName)
+		var prefixes []string
+		if _, journal, ok := changeJournal(loc, environment, fileName); ok {
+			prefixes = append(prefixes, journal)
+		}
+		prefixes = append(prefixes, base+filesPrefix+"/"+environment+"/")
+		for _, prefix := range prefixes {
+			listed, err := loc.store.List(ctx, prefix)
+			if err != nil && !errors.Is(err, storage.ErrUnsupported) {
+				return nil, err
+			}
+			for _, o := range listed {
+				objects = append(objects, archivedObject{store: loc.store, key: o.Key, url: loc.url(o.Key), target: archive + strings.TrimPrefix(o.Key, base), sameBucket: sameBucket})
+			}
+		}
+	}
+
+	for _, prefix := range retiredPrefixes {
+		listed, err := store.List(ctx, storage.Key(s.S3Path, prefix+"/"+environment+"/"))
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range listed {
+			objects = append(objects, archivedObject{store: store, key: o.Key, url: "s3://" + s.S3Bucket + "/" + o.Key, target: archive + strings.TrimPrefix(o.Key, s.S3Path), sameBucket: true})
+		}
+	}
+	return objects, nil
+}
+
+// archiveObject copies one object into the archive, one from another store is read and written again
+
+func archiveObject(ctx context.Context, store storage.Backend, o archivedObject, storageClass, kmsKey string) error {
+	if o.sameBucket {
+		_, err := storage.Copy(ctx, store, storage.CopyInput{Source: o.key, Key: o.target, StorageClass: storageClass, KMSKeyID: kmsKey})
+		return err
+	}
+	data, err := storage.GetBytes(ctx, o.store, o.key)
+	if err != nil {
+		return err
+	}
+	_, err = storage.PutBytesWith(ctx, store, o.target, data, storage.UploadOptions{KMSKeyID: kmsKey, StorageClass: storageClass})
+	return err
+}
+
+// checkNoLiveState pulls the state and refuses an environment that still has managed resources in it, or whose state can't be read, unless --ignore-live-state says to go ahead
+
+func (a *app) checkNoLiveState(ctx context.Context, environment string, req retireRequest) error {
+	if req.ignoreLiveState {
+		a.out.Warnf("Not checking whether %s still has infrastructure, --ignore-live-state", environment)
+		return nil
+	}
+	hint := "destroy it first or pass --ignore-live-state"
+	if err := a.useTerraformCredentials(ctx, environment); err != nil {
+		return fmt.Errorf("can't tell whether %s still has infrastructure, %s: %w", environment, hint, err)
+	}
+	chdir := a.useEnvironment(environment, req.chdir)
+	a.out.Verbosef("Running terraform %v\n", tfexec.StatePullArgs(chdir))
+	data, err := tfexec.StatePull(ctx, runner, chdir, a.terraformOutput())
+	if err != nil {
+		return fmt.Errorf("can't tell whether %s still has infrastructure, %s: %w", environment, hint, err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	state, err := statediff.Read(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("can't tell whether %s still has infrastructure, %s: terraform state pull did not print a valid state: %w", environment, hint, err)
+	}
+	live := managedResources(state)
+	if len(live) == 0 {
+		return nil
+	}
+	a.out.Event("retire-live-state", map[string]any{"environment": environment, "resources": live})
+	return withCode(exitCheck, fmt.Errorf("the state of %s still has %d resource(s), such as %s - %s", environment, len(live), live[0], hint))
+}
+
+// managedResources are the addresses of a state that are real infrastructure, data sources left out
+
+func managedResources(state statediff.State) []string {
+	var live []string
+	for address := range state {
+		rest := address
+		for strings.HasPrefix(rest, "module.") {
+			_, after, _ := strings.Cut(rest[len("module."):], ".")
+			rest = after
+		}
+		if !strings.HasPrefix(rest, "data.") {
+			live = append(live, address)
+		}
+	}
+	slices.Sort(live)
+	return live
+}
+
+// reportRetirement lists every object and where it goes
+
+func (a *app) reportRetirement(environment string, objects []archivedObject, dryRun bool) {
+	var rows [][]string
+	for _, o := range objects {
+		a.out.Event("retire-object", map[string]any{"environment": environment, "source": o.url, "target": o.target, "dry_run": dryRun})
+		rows = append(rows, []string{o.url, o.target})
+	}
+	if !a.out.json && len(rows) > 0 {
+		a.out.Table(a.out.humanOut(), []string{"OBJECT", "ARCHIVED AS"}, rows, nil)
+	}
+}
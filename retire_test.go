@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/statediff"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestRetire(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	rec := &tfexec.RecordingRunner{Output: `{"version":4,"serial":3,"lineage":"x","resources":[{"mode":"data","type":"aws_caller_identity","name":"me","instances":[{"attributes":{}}]}]}`}
+	useRunner(t, rec)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  qa:\n    tfvars: qa.tfvars # retire me\n  sandbox:\n    tfvars: sandbox.tfvars\n"), 0o644)
+	os.WriteFile("qa.tfvars", []byte("name = \"qa\"\n"), 0o644)
+	if err := run([]string{"upload", "qa"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"team/plans/qa/a.tfplan", "team/state-snapshots/qa/a.json.gz", "team/audit/qa/a.json", "team/plans/qa2/keep.tfplan", "team/files/qa/backend.hcl"} {
+		storage.PutBytes(ctx, store, key, []byte(key))
+	}
+	archive := "team/archive/qa/" + time.Now().UTC().Format("2006-01-02") + "/"
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"retire", "qa", "--dry-run"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("retire --dry-run: %v", err)
+	}
+	for _, want := range []string{"s3://tfvars-bucket/team/qa.tfvars", archive + "tfvars/qa.tfvars", archive + "plans/qa/a.tfplan", archive + "files/qa/backend.hcl", archive + "changes/qa/"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("the dry run doesn't show %q:\n%s", want, stdout.String())
+		}
+	}
+	if strings.Contains(stdout.String(), "qa2") {
+		t.Errorf("the dry run moves another environment's plan:\n%s", stdout.String())
+	}
+	if _, ok := store.Bytes("team/plans/qa/a.tfplan"); !ok {
+		t.Fatal("the dry run moved the plan")
+	}
+
+	if err := runWithUI([]string{"retire", "qa"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader("qa\n")}); err != nil {
+		t.Fatalf("retire: %v", err)
+	}
+	for _, key := range []string{"team/qa.tfvars", "team/plans/qa/a.tfplan", "team/state-snapshots/qa/a.json.gz", "team/audit/qa/a.json", "team/files/qa/backend.hcl"} {
+		if _, ok := store.Bytes(key); ok {
+			t.Errorf("%s is still there", key)
+		}
+	}
+	if data, ok := store.Bytes(archive + "tfvars/qa.tfvars"); !ok || string(data) != "name = \"qa\"\n" {
+		t.Errorf("archived tfvars = %q, %v", data, ok)
+	}
+	if data, ok := store.Bytes(archive + "audit/qa/a.json"); !ok || string(data) != "team/audit/qa/a.json" {
+		t.Errorf("archived audit record = %q, %v", data, ok)
+	}
+	if _, ok := store.Bytes("team/plans/qa2/keep.tfplan"); !ok {
+		t.Error("retire moved another environment's plan")
+	}
+	if data, _ := os.ReadFile("tfmanage.yaml"); strings.Contains(string(data), "qa:") || !strings.Contains(string(data), "sandbox:") {
+		t.Errorf("tfmanage.yaml after retire =\n%s", data)
+	}
+	if err := run([]string{"retire", "qa"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("retire of a retired environment: %v, want a usage error", err)
+	}
+}
+
+func TestRetireRefusesLiveState(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	rec := &tfexec.RecordingRunner{Output: `{"version":4,"serial":3,"lineage":"x","resources":[{"module":"module.net","mode":"managed","type":"aws_vpc","name":"main","instances":[{"attributes":{"id":"vpc-1"}}]}]}`}
+	useRunner(t, rec)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  qa:\n    tfvars: qa.tfvars\n"), 0o644)
+	os.WriteFile("qa.tfvars", []byte("name = \"qa\"\n"), 0o644)
+	if err := run([]string{"upload", "qa"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	err := run([]string{"retire", "qa", "--yes"})
+	if exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "module.net.aws_vpc.main") {
+		t.Fatalf("retire with a live resource: %v, want exit code %d naming it", err, exitCheck)
+	}
+	if _, ok := store.Bytes("team/qa.tfvars"); !ok {
+		t.Fatal("the refused retire moved the tfvars")
+	}
+	if err := run([]string{"retire", "qa"}); exitCodeFor(err) != exitCheck {
+		t.Errorf("retire without --yes and a live resource: %v, want the state check first", err)
+	}
+
+	rec.Output = ""
+	if err := runWithUI([]string{"retire", "qa"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader("prod\n")}); !errors.Is(err, errNotConfirmed) {
+		t.Errorf("retire with the wrong name typed: %v, want it cancelled", err)
+	}
+
+	rec.Output = "not a state"
+	if err := run([]string{"retire", "qa", "--ignore-live-state", "--glacier", "--yes"}); err != nil {
+		t.Fatalf("retire --ignore-live-state: %v", err)
+	}
+	archived, _ := store.List(context.Background(), "team/archive/qa/")
+	if len(archived) != 2 {
+		t.Fatalf("archive = %+v, want the tfvars and their change journal entry", archived)
+	}
+	for _, o := range archived {
+		if o.StorageClass != archiveStorageClass {
+			t.Errorf("%s is in storage class %q, want %s", o.Key, o.StorageClass, archiveStorageClass)
+		}
+	}
+}
+
+func TestManagedResources(t *testing.T) {
+	state := statediff.State{
+		"aws_vpc.main":                     {},
+		"data.aws_region.current":          {},
+		"module.net.data.aws_ami.base":     {},
+		"module.data.aws_subnet.a":         {},
+		`module.app["x"].aws_instance.web`: {},
+	}
+	want := []string{`module.app["x"].aws_instance.web`, "aws_vpc.main", "module.data.aws_subnet.a"}
+	slices.Sort(want)
+	if got := managedResources(state); !slices.Equal(got, want) {
+		t.Errorf("managedResources() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// assume_roles - terraform runs as the last of a chain of roles, each assumed with the credentials of the one before it. The tool itself keeps using its own credentials for the bucket, only terraform gets the chain's
+
+var (
+	roleARN         = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+	sessionNameChar = regexp.MustCompile(`[^\w+=,.@-]`)
+)
+
+// checkAssumeRolesConfig checks role_arn and every environment's assume_roles are role ARNs
+
+func checkAssumeRolesConfig(s settings) error {
+	if s.AWSConfig.RoleARN != "" && !roleARN.MatchString(s.AWSConfig.RoleARN) {
+		return configError("role_arn %q is not an IAM role ARN", s.AWSConfig.RoleARN)
+	}
+	for _, env := range slices.Sorted(maps.Keys(s.Terraform)) {
+		for _, role := range s.Terraform[env].AssumeRoles {
+			if !roleARN.MatchString(role) {
+				return configError("assume_roles of %s: %q is not an IAM role ARN", env, role)
+			}
+		}
+	}
+	return nil
+}
+
+// roleSessionName shows up in CloudTrail for everything terraform does, so it names the environment
+
+func roleSessionName(environment string) string {
+	name := sessionNameChar.ReplaceAllString("tfmanage-"+environment, "-")
+	return name[:min(len(name), 64)]
+}
+
+// assumeRoles assumes the chain and asks STS who the last role's credentials belong to - it is a variable so the tests don't need STS
+
+var assumeRoles = stsAssumeRoles
+
+func stsAssumeRoles(ctx context.Context, s settings, roles []string, sessionName string, duration time.Duration) (aws.Credentials, string, error) {
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return aws.Credentials{}, "", err
+	}
+	creds, err := awsconfig.AssumeRoleChain(ctx, cfg, roles, sessionName, duration)
+	if err != nil {
+		return aws.Credentials{}, "", err
+	}
+	arn, err := awsconfig.CallerARN(ctx, awsconfig.WithCredentials(cfg, creds))
+	return creds, arn, err
+}
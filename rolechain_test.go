@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const chainConfig = `environments:
+  prod:
+    assume_roles:
+      - arn:aws:iam::111111111111:role/hop
+      - arn:aws:iam::222222222222:role/deploy
+`
+
+func TestAssumeRoles(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("tfmanage.yaml", []byte(chainConfig), 0o644)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+
+	var gotRoles []string
+	var gotSession string
+	var gotDuration time.Duration
+	swap(t, &assumeRoles, func(_ context.Context, _ settings, roles []string, session string, d time.Duration) (aws.Credentials, string, error) {
+		gotRoles, gotSession, gotDuration = roles, session, d
+		return aws.Credentials{AccessKeyID: "ASIACHAIN", SecretAccessKey: "chain-secret", SessionToken: "chain-token"}, "arn:aws:sts::222222222222:assumed-role/deploy/tfmanage-prod", nil
+	})
+
+	var stderr bytes.Buffer
+	if err := runWithUI([]string{"--session-duration", "2h", "plan", "prod", "plan.out"}, &ui{stdout: io.Discard, stderr: &stderr}); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if fmt.Sprint(gotRoles) != "[arn:aws:iam::111111111111:role/hop arn:aws:iam::222222222222:role/deploy]" || gotSession != "tfmanage-prod" || gotDuration != 2*time.Hour {
+		t.Errorf("assumed %q as %q for %s", gotRoles, gotSession, gotDuration)
+	}
+	if !strings.Contains(stderr.String(), "Terraform runs in prod as arn:aws:sts::222222222222:assumed-role/deploy/tfmanage-prod") {
+		t.Errorf("the identity wasn't printed: %q", stderr.String())
+	}
+	env := rec.Calls[0].Opts.Env
+	for _, want := range []string{"AWS_ACCESS_KEY_ID=ASIACHAIN", "AWS_SECRET_ACCESS_KEY=chain-secret", "AWS_SESSION_TOKEN=chain-token", "AWS_PROFILE="} {
+		if !slices.Contains(env, want) {
+			t.Errorf("terraform env = %q, want %s", env, want)
+		}
+	}
+
+	// a role that can't be assumed stops the run before terraform
+	calls := len(rec.Calls)
+	assumeRoles = func(context.Context, settings, []string, string, time.Duration) (aws.Credentials, string, error) {
+		return aws.Credentials{}, "", fmt.Errorf("%w arn:aws:iam::222222222222:role/deploy (step 2 of 2): AccessDenied", awsconfig.ErrAssumeRoleFailed)
+	}
+	err := run([]string{"apply", "prod"})
+	if exitCodeFor(err) != exitCredentials || !strings.Contains(fmt.Sprint(err), "role/deploy (step 2 of 2)") {
+		t.Errorf("apply with a role it can't assume: %v", err)
+	}
+	if len(rec.Calls) != calls {
+		t.Errorf("terraform ran without the chain's credentials: %q", rec.Args()[calls:])
+	}
+}
+
+func TestAssumeRolesConfig(t *testing.T) {
+	inTempDir(t)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    assume_roles: [deploy]\n"), 0o644)
+	if err := run([]string{"plan", "prod", "plan.out"}); exitCodeFor(err) != exitConfig || !strings.Contains(fmt.Sprint(err), `"deploy" is not an IAM role ARN`) {
+		t.Errorf("plan with a role that isn't an ARN: %v", err)
+	}
+}
+
+func TestSessionDuration(t *testing.T) {
+	for _, d := range []string{"10m", "13h"} {
+		if err := run([]string{"--session-duration", d, "plan", "prod", "plan.out"}); exitCodeFor(err) != exitUsage {
+			t.Errorf("--session-duration %s: %v, want a usage error", d, err)
+		}
+	}
+}
+
+func TestRoleSessionName(t *testing.T) {
+	if got := roleSessionName("team a/prod"); got != "tfmanage-team-a-prod" {
+		t.Errorf("roleSessionName() = %q", got)
+	}
+	if got := roleSessionName(strings.Repeat("x", 100)); len(got) != 64 {
+		t.Errorf("roleSessionName() is %d long, STS takes at most 64", len(got))
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// secret_vars - variables whose values only ever live in Secrets Manager. They are read right before terraform runs and passed as TF_VAR_<name>, never written anywhere, and masked in terraform's output
+
+var terraformVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// checkSecretVarsConfig checks the secret_vars of the config and of every environment
+
+func checkSecretVarsConfig(s settings) error {
+	check := func(where string, vars map[string]config.SecretVar) error {
+		for _, name := range slices.Sorted(maps.Keys(vars)) {
+			if !terraformVarName.MatchString(name) {
+				return configError("%s: %q is not a valid terraform variable name", where, name)
+			}
+			if vars[name].Secret == "" {
+				return configError("%s: %s has no secret", where, name)
+			}
+		}
+		return nil
+	}
+	if err := check("secret_vars", s.SecretVars); err != nil {
+		return err
+	}
+	for _, env := range slices.Sorted(maps.Keys(s.Terraform)) {
+		if err := check("secret_vars of "+env, s.Terraform[env].SecretVars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secretVarsFor gives the environment's secret variables, its own on top of the global ones
+
+func secretVarsFor(s settings, environment string) map[string]config.SecretVar {
+	vars := map[string]config.SecretVar{}
+	maps.Copy(vars, s.SecretVars)
+	maps.Copy(vars, s.Terraform[environment].SecretVars)
+	return vars
+}
+
+// secretValue picks the value out of a secret, following the dotted key into a JSON secret when there is one
+
+func secretValue(secret []byte, key string) (string, error) {
+	if key == "" {
+		return string(secret), nil
+	}
+	var value any
+	if err := json.Unmarshal(secret, &value); err != nil {
+		return "", fmt.Errorf("the secret isn't JSON, so it has no key %s", key)
+	}
+	for _, part := range strings.Split(key, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("the secret has no key %s", key)
+		}
+		if value, ok = object[part]; !ok {
+			return "", fmt.Errorf("the secret has no key %s", key)
+		}
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// readSecretsManagerVars reads the values of the secret_vars
+
+func readSecretsManagerVars(ctx context.Context, s settings, vars map[string]config.SecretVar) (map[string]string, error) {
+	store, err := newSecretsStore(ctx, s, "")
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		data, err := storage.GetBytes(ctx, store, vars[name].Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the secret of the %s variable: %w", name, err)
+		}
+		if values[name], err = secretValue(data, vars[name].Key); err != nil {
+			return nil, configError("secret variable %s: %v", name, err)
+		}
+	}
+	return values, nil
+}
+
+// useSecretVars reads the environment's secret_vars and vault_vars for the terraform runs that follow. Any value that can't be read stops the run before terraform starts, and only the variable is named, never a value
+
+func (a *app) useSecretVars(ctx context.Context, environment string) error {
+	if a.secretVarsFor == environment {
+		return nil
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	a.secretVars = nil
+	values := map[string]string{}
+	var sources []string
+	if vars := secretVarsFor(s, environment); len(vars) > 0 {
+		read, err := readSecretsManagerVars(ctx, s, vars)
+		if err != nil {
+			return err
+		}
+		maps.Copy(values, read)
+		sources = append(sources, "Secrets Manager")
+	}
+	if vars := vaultVarsFor(s, environment); len(vars) > 0 {
+		read, err := readVaultVars(ctx, s, vars)
+		if err != nil {
+			return err
+		}
+		maps.Copy(values, read)
+		sources = append(sources, "Vault")
+	}
+	a.secretVarsFor = environment
+	if len(values) == 0 {
+		return nil
+	}
+
+	names := slices.Sorted(maps.Keys(values))
+	for _, name := range names {
+		a.secretVars = append(a.secretVars, "TF_VAR_"+name+"="+values[name])
+		a.secretValues = append(a.secretValues, values[name])
+	}
+	if a.redactor != nil {
+		a.redactor.Add(slices.Collect(maps.Values(values))...)
+	}
+	a.out.Verbosef("Passing %s to terraform from %s\n", strings.Join(names, ", "), strings.Join(sources, " and "))
+	return nil
+}
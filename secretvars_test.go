@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func withSecrets(t *testing.T) *storage.MemoryStore {
+	t.Helper()
+	secrets := storage.NewMemoryStore()
+	swap(t, &newSecretsStore, func(context.Context, settings, string) (storage.Backend, error) { return secrets, nil })
+	return secrets
+}
+
+func TestSecretValue(t *testing.T) {
+	secret := []byte(`{"db": {"password": "s3cret!", "port": 5432}}`)
+	for _, tc := range []struct {
+		data    []byte
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{[]byte("plain value"), "", "plain value", false},
+		{secret, "db.password", "s3cret!", false},
+		{secret, "db.port", "5432", false},
+		{secret, "db", `{"password":"s3cret!","port":5432}`, false},
+		{secret, "db.user", "", true},
+		{secret, "db.password.x", "", true},
+		{[]byte("plain value"), "db", "", true},
+	} {
+		got, err := secretValue(tc.data, tc.key)
+		if got != tc.want || (err != nil) != tc.wantErr {
+			t.Errorf("secretValue(%s, %q) = %q, %v", tc.data, tc.key, got, err)
+		}
+		if err != nil && strings.Contains(err.Error(), "s3cret") {
+			t.Errorf("secretValue(%q) error has the value in it: %v", tc.key, err)
+		}
+	}
+}
+
+func TestSecretVars(t *testing.T) {
+	const password = "correct-horse-battery"
+	rec := &tfexec.RecordingRunner{Output: "Error: connecting with " + password + " failed\n"}
+	useRunner(t, rec)
+	withTFVars(t, "prod")
+	secrets := withSecrets(t)
+	storage.PutBytes(context.Background(), secrets, "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf", []byte(`{"password":"`+password+`"}`))
+	storage.PutBytes(context.Background(), secrets, "shared/api", []byte("api-key-value"))
+	os.WriteFile("tfmanage.yaml", []byte(`secret_vars:
+  api_key: shared/api
+environments:
+  prod:
+    secret_vars:
+      db_password:
+        secret: arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf
+        key: password
+`), 0o644)
+
+	var out bytes.Buffer
+	if err := runWithUI([]string{"--output", "json", "--verbose", "--raw-output", "plan", "prod", "plan.out"}, &ui{json: true, verbose: true, stdout: io.Discard, stderr: &out}); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	env := rec.Calls[0].Opts.Env
+	if !slices.Contains(env, "TF_VAR_db_password="+password) || !slices.Contains(env, "TF_VAR_api_key=api-key-value") {
+		t.Errorf("terraform env = %q, want the secrets as TF_VAR_ variables", env)
+	}
+	if strings.Contains(out.String(), password) || !strings.Contains(out.String(), "Error: connecting with (redacted) failed") {
+		t.Errorf("the secret leaked into the output, even --raw-output has to mask it: %q", out.String())
+	}
+	entries, _ := os.ReadDir(".")
+	for _, e := range entries {
+		if data, _ := os.ReadFile(e.Name()); bytes.Contains(data, []byte(password)) {
+			t.Errorf("%s has the secret in it", e.Name())
+		}
+	}
+
+	// a secret that can't be read stops the run before terraform, naming the variable
+	calls := len(rec.Calls)
+	secrets.GetErr = fmt.Errorf("%w: secretsmanager://shared/api: not authorized", storage.ErrAccessDenied)
+	err := run([]string{"apply", "prod"})
+	if exitCodeFor(err) != exitCredentials || !strings.Contains(fmt.Sprint(err), "api_key") {
+		t.Errorf("apply with a secret it can't read: %v", err)
+	}
+	if len(rec.Calls) != calls {
+		t.Errorf("terraform ran without its secrets: %q", rec.Args()[calls:])
+	}
+
+	secrets.GetErr = nil
+	os.WriteFile("tfmanage.yaml", []byte("secret_vars:\n  db_password:\n    secret: shared/api\n    key: password\n"), 0o644)
+	if err := run([]string{"plan", "prod", "plan.out"}); exitCodeFor(err) != exitConfig || !strings.Contains(fmt.Sprint(err), "db_password") || strings.Contains(fmt.Sprint(err), "api-key-value") {
+		t.Errorf("plan with a key the secret doesn't have: %v", err)
+	}
+	os.WriteFile("tfmanage.yaml", []byte("secret_vars:\n  db password: shared/api\n"), 0o644)
+	if err := run([]string{"plan", "prod", "plan.out"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("plan with an invalid variable name: %v", err)
+	}
+	if len(rec.Calls) != calls {
+		t.Errorf("terraform ran: %q", rec.Args()[calls:])
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/buildinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/deployments"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/selfupdate"
+)
+
+// self-update - replace the binary with the latest GitHub release, checked against the release's checksums first. It only ever runs when asked to, the tool never updates itself or checks for updates on its own
+
+// errUpdateAvailable is self-update --check's answer rather than a failure, like errPlansDiffer it keeps exit code 1 for shell prompts and CI
+
+var errUpdateAvailable = withCode(exitGeneric, errors.New("a newer version is available"))
+
+// updateSource and executable are where the releases come from and what gets replaced, variables so the tests can swap them
+
+var (
+	updateSource = selfupdate.Source{}
+	executable   = os.Executable
+)
+
+func selfUpdateCommand() *command {
+	return &command{
+		name:    "self-update",
+		summary: "Replace tfmanage with the latest release after checking its checksum. Exits 1 with --check when there is a newer one.",
+		examples: []string{
+			"tfmanage self-update",
+			"tfmanage self-update --check",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			check := fs.Bool("check", false, "only say whether there is a newer release, exit 1 when there is")
+			force := fs.Bool("force", false, "install the latest release even when it isn't newer, or over a development build")
+			return func(ctx context.Context, a *app, args []string) error {
+				return a.selfUpdate(ctx, *check, *force)
+			}
+		},
+	}
+}
+
+func (a *app) selfUpdate(ctx context.Context, check, force bool) error {
+	source, err := a.releaseSource()
+	if err != nil {
+		return err
+	}
+	current := buildinfo.Get().Version
+	release, err := source.Latest(ctx)
+	if err != nil {
+		return err
+	}
+	latest := release.Version()
+	newer, err := selfupdate.Newer(latest, current)
+	comparable := err == nil
+	event := map[string]any{"current": current, "latest": latest, "release": release.URL, "outdated": newer}
+	if !comparable {
+		event["outdated"] = nil
+		if check || !force {
+			a.out.Event("self-update", event)
+			return usageError("tfmanage %s can't be compared with the %s release, pass --force to replace it anyway", current, latest)
+		}
+	}
+
+	if check {
+		a.out.Event("self-update", event)
+		if !newer {
+			a.out.Successf("tfmanage %s is the latest release", current)
+			return nil
+		}
+		a.out.Printf("tfmanage %s is out, this is %s: %s\nRun tfmanage self-update to install it\n", latest, current, release.URL)
+		return errUpdateAvailable
+	}
+	if comparable && !newer && !force {
+		a.out.Event("self-update", event)
+		a.out.Successf("tfmanage %s is the latest release, nothing to update", current)
+		return nil
+	}
+
+	exe, err := executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	selfupdate.CleanUp(exe)
+	asset, err := release.AssetFor(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	data, err := a.downloadRelease(ctx, source, release, asset)
+	if err != nil {
+		return err
+	}
+	if err := selfupdate.Replace(exe, data, runtime.GOOS == "windows"); err != nil {
+		return fmt.Errorf("can't replace %s: %w", exe, err)
+	}
+	event["updated"] = true
+	event["path"] = exe
+	a.out.Event("self-update", event)
+	a.out.Successf("Updated %s from %s to %s", exe, current, latest)
+	return nil
+}
+
+// downloadRelease downloads the platform's binary with the release's checksums, and their signature once releases are signed, and checks it
+
+func (a *app) downloadRelease(ctx context.Context, source selfupdate.Source, release selfupdate.Release, asset selfupdate.Asset) ([]byte, error) {
+	sums, ok := release.Asset(selfupdate.ChecksumsAsset)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no %s", selfupdate.ErrChecksum, release.Tag, selfupdate.ChecksumsAsset)
+	}
+	checksums, err := source.Download(ctx, sums)
+	if err != nil {
+		return nil, err
+	}
+	var signature []byte
+	if selfupdate.PublicKey != "" {
+		sig, ok := release.Asset(selfupdate.SignatureAsset)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s has no %s", selfupdate.ErrSignature, release.Tag, selfupdate.SignatureAsset)
+		}
+		if signature, err = source.Download(ctx, sig); err != nil {
+			return nil, err
+		}
+	}
+	a.out.Printf("Downloading %s...\n", asset.URL)
+	data, err := source.Download(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	if err := selfupdate.Verify(data, asset.Name, checksums, signature); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// releaseSource is the release source with a client that goes through --proxy-url, or the proxy the environment gives
+
+func (a *app) releaseSource() (selfupdate.Source, error) {
+	source := updateSource
+	if source.Client == nil {
+		proxy, err := awsconfig.ProxyFunc(a.global.proxyURL)
+		if err != nil {
+			return source, err
+		}
+		source.Client = &http.Client{Timeout: 5 * time.Minute, Transport: &http.Transport{Proxy: proxy}}
+	}
+	if source.Token == "" {
+		source.Token = os.Getenv(deployments.TokenEnv)
+	}
+	return source, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/buildinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/selfupdate"
+)
+
+func TestSelfUpdate(t *testing.T) {
+	binary := []byte("tfmanage 1.5.0")
+	asset := fmt.Sprintf("tfmanage_1.5.0_%s_%s", runtime.GOOS, runtime.GOARCH)
+	checksums := fmt.Sprintf("%x  %s\n", sha256.Sum256(binary), asset)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/" + selfupdate.DefaultRepo + "/releases/latest":
+			fmt.Fprintf(w, `{"tag_name":"v1.5.0","html_url":"https://github.com/releases/v1.5.0","assets":[{"name":%q,"browser_download_url":"%s/download/bin"},{"name":"checksums.txt","browser_download_url":"%s/download/sums"}]}`, asset, srv.URL, srv.URL)
+		case "/download/bin":
+			w.Write(binary)
+		case "/download/sums":
+			io.WriteString(w, checksums)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	swap(t, &updateSource, selfupdate.Source{APIURL: srv.URL, Client: srv.Client()})
+	exe := filepath.Join(t.TempDir(), "tfmanage")
+	os.WriteFile(exe, []byte("tfmanage 1.4.0"), 0o755)
+	swap(t, &executable, func() (string, error) { return exe, nil })
+	swap(t, &buildinfo.Version, "1.4.0")
+
+	err := run([]string{"self-update", "--check"})
+	if !errors.Is(err, errUpdateAvailable) || exitCodeFor(err) != exitGeneric {
+		t.Fatalf("self-update --check of an old version: %v, want exit code 1", err)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "tfmanage 1.4.0" {
+		t.Fatal("self-update --check replaced the binary")
+	}
+
+	checksums = fmt.Sprintf("%x  %s\n", sha256.Sum256([]byte("something else")), asset)
+	if err := run([]string{"self-update"}); exitCodeFor(err) != exitCheck {
+		t.Errorf("self-update with the wrong checksum: %v, want exit code %d", err, exitCheck)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "tfmanage 1.4.0" {
+		t.Fatal("a binary that doesn't match its checksum was installed")
+	}
+
+	checksums = fmt.Sprintf("%x  %s\n", sha256.Sum256(binary), asset)
+	if err := run([]string{"self-update"}); err != nil {
+		t.Fatalf("self-update: %v", err)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "tfmanage 1.5.0" {
+		t.Errorf("the binary after self-update is %q", data)
+	}
+
+	buildinfo.Version = "1.5.0"
+	if err := run([]string{"self-update", "--check"}); err != nil {
+		t.Errorf("self-update --check of the latest version: %v", err)
+	}
+	buildinfo.Version = "devel"
+	if err := run([]string{"self-update"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("self-update of a development build: %v, want a usage error", err)
+	}
+}
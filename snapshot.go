@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// state snapshots - apply keeps a gzipped copy of the state from right before it ran, tied to the plan and whoever applied it, so getting back to it during an incident is one copy-paste. state restore pushes one back
+
+// stateSnapshotPrefix is where snapshots go under S3_PATH, one folder per environment. Unlike state backups they are never pruned
+
+const stateSnapshotPrefix = "state-snapshots"
+
+// the object metadata a snapshot carries
+
+const (
+	snapshotSerialMetadataKey  = "serial"
+	snapshotLineageMetadataKey = "lineage"
+	snapshotPlanMetadataKey    = "plan-sha256"
+	snapshotCallerMetadataKey  = "caller-arn"
+)
+
+func stateSnapshotKey(s settings, environment string, now time.Time, reason string) string {
+	name := fmt.Sprintf("%s-%s.json.gz", now.UTC().Format("20060102T150405Z"), reason)
+	return storage.Key(s.S3Path, path.Join(stateSnapshotPrefix, environment, name))
+}
+
+// snapshotSteps is how apply snapshots the state before it runs terraform
+
+type snapshotSteps struct {
+	enabled bool
+	// bestEffort carries on with a warning when the snapshot can't be taken
+	bestEffort bool
+}
+
+// snapshotEnabled is --snapshot-state when it was given, otherwise it's on for protected environments
+
+func (a *app) snapshotEnabled(environment string, flagValue, flagSet bool) bool {
+	if flagSet {
+		return flagValue
+	}
+	return a.protectedEnvironment(environment)
+}
+
+// snapshotState pulls the state, gzips it and uploads it with the plan's hash and the caller in the metadata. planFile can be empty when the apply plans as it goes. It gives back the key, empty when there is no state yet
+
+func snapshotState(ctx context.Context, a *app, environment, chdir, reason, planFile string) (string, error) {
+	s, err := a.loadSettings()
+	if err != nil {
+		return "", err
+	}
+	if err := requirementsError("state snapshot", checkRequirements("state backup", environment, s)); err != nil {
+		return "", err
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.StatePullArgs(chdir))
+	data, err := tfexec.StatePull(ctx, runner, chdir, a.terraformOutput())
+	if err != nil {
+		return "", err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		a.out.Printf("There is no %s state to snapshot yet\n", environment)
+		return "", nil
+	}
+	state, err := readState(data)
+	if err != nil {
+		return "", err
+	}
+
+	metadata := map[string]string{
+		snapshotSerialMetadataKey:  strconv.FormatInt(state.Serial, 10),
+		snapshotLineageMetadataKey: state.Lineage,
+	}
+	if planFile != "" {
+		if metadata[snapshotPlanMetadataKey], err = storage.FileChecksum(planFile); err != nil {
+			return "", err
+		}
+	}
+	// the caller is only a label, not knowing it doesn't stop the snapshot
+	if caller, err := callerIdentity(ctx, s); err != nil {
+		a.out.Warnf("Could not get the caller for the snapshot's metadata: %v", err)
+	} else {
+		metadata[snapshotCallerMetadataKey] = caller
+	}
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(data); err != nil {
+		return "", fmt.Errorf("failed to compress the state: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress the state: %w", err)
+	}
+
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	// the state has every sensitive attribute in it, so it gets the environment's key like the plans do
+	kmsKey, _ := kmsKeyFor(s, environment)
+	key := stateSnapshotKey(s, environment, time.Now(), reason)
+	storageClass, err := retentionStorageClass(s)
+	if err != nil {
+		return "", err
+	}
+	res, err := storage.PutBytesWith(ctx, store, key, gz.Bytes(), storage.UploadOptions{Metadata: metadata, KMSKeyID: kmsKey, StorageClass: storageClass})
+	if err != nil {
+		return "", err
+	}
+	a.out.Event("state-snapshot", map[string]any{"environment": environment, "bucket": s.S3Bucket, "key": key, "reason": reason, "serial": state.Serial, "lineage": state.Lineage, "sha256": res.Checksum, "plan_sha256": metadata[snapshotPlanMetadataKey], "caller": metadata[snapshotCallerMetadataKey]})
+	a.out.Successf("Snapshotted state serial %d to s3://%s/%s", state.Serial, s.S3Bucket, key)
+	return key, nil
+}
+
+// snapshotBeforeApply takes the pre-apply snapshot, a failure stops the apply unless the steps say it's best effort
+
+func snapshotBeforeApply(ctx context.Context, a *app, steps applySteps, opts tfexec.ApplyOptions) (string, error) {
+	key, err := snapshotState(ctx, a, steps.env, opts.Chdir, "pre-apply", opts.PlanFile)
+	if err != nil {
+		if !steps.snapshot.bestEffort {
+			return "", fmt.Errorf("could not snapshot the state before applying, pass --snapshot-best-effort to apply anyway: %w", err)
+		}
+		a.out.Warnf("Applying without a state snapshot: %v", err)
+	}
+	return key, nil
+}
+
+// latestSnapshot is the newest snapshot of the environment, the keys start with the time they were taken
+
+func latestSnapshot(ctx context.Context, s settings, store storage.Backend, environment string) (string, error) {
+	prefix := storage.Key(s.S3Path, stateSnapshotPrefix+"/"+environment+"/")
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	for _, o := range objects {
+		if strings.HasSuffix(o.Key, ".json.gz") && o.Key > latest {
+			latest = o.Key
+		}
+	}
+	if latest == "" {
+		return "", configError("there are no state snapshots of %s under s3://%s/%s", environment, s.S3Bucket, prefix)
+	}
+	return latest, nil
+}
+
+// resolveSnapshotKey turns latest, a full key or a bare file name under the environment's snapshots into a key, snapshots of other environments are refused
+
+func resolveSnapshotKey(ctx context.Context, s settings, store storage.Backend, environment, arg string) (string, error) {
+	if arg == "latest" {
+		return latestSnapshot(ctx, s, store, environment)
+	}
+	key := arg
+	switch {
+	case s.S3Path != "" && strings.HasPrefix(arg, s.S3Path+stateSnapshotPrefix+"/"):
+	case strings.HasPrefix(arg, stateSnapshotPrefix+"/"):
+		key = storage.Key(s.S3Path, arg)
+	default:
+		key = storage.Key(s.S3Path, path.Join(stateSnapshotPrefix, environment, arg))
+	}
+	if !strings.HasPrefix(key, storage.Key(s.S3Path, stateSnapshotPrefix+"/"+environment+"/")) {
+		return "", usageError("%s is not a state snapshot of %s", key, environment)
+	}
+	return key, nil
+}
+
+// snapshotStore checks the bucket settings for reading the environment's snapshots
+
+func (a *app) snapshotStore(ctx context.Context, operation, environment string) (settings, storage.Backend, error) {
+	s, err := a.loadSettings()
+	if err != nil {
+		return settings{}, nil, err
+	}
+	if err := requirementsError(operation, checkRequirements("state backup", environment, s)); err != nil {
+		return settings{}, nil, err
+	}
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return settings{}, nil, err
+	}
+	return s, store, nil
+}
+
+// fetchSnapshot downloads a snapshot as it is stored, gzipped
+
+func fetchSnapshot(ctx context.Context, s settings, store storage.Backend, environment, arg string) (string, []byte, error) {
+	key, err := resolveSnapshotKey(ctx, s, store, environment, arg)
+	if err != nil {
+		return "", nil, err
+	}
+	var kmsKey string
+	if remote, err := store.Head(ctx, key); err == nil {
+		kmsKey = remote.KMSKeyID
+	}
+	data, err := storage.GetBytes(ctx, store, key)
+	if err != nil {
+		return "", nil, kmsDecryptError(err, kmsKey)
+	}
+	return key, data, nil
+}
+
+// gunzipState unpacks a snapshot
+
+func gunzipState(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// withSerial sets the serial of a state, terraform state push refuses a state older than the one it replaces
+
+func withSerial(data []byte, serial int64) ([]byte, error) {
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	state["serial"] = json.RawMessage(strconv.FormatInt(serial, 10))
+	return json.MarshalIndent(state, "", "  ")
+}
+
+// restoreState pushes a snapshot back as the environment's state. The lineage has to match the current state unless force is set, and the current state is snapshotted first so the restore can be undone too
+
+func restoreState(ctx context.Context, a *app, environment, chdir, arg string, force, yes bool) error {
+	s, store, err := a.snapshotStore(ctx, "state restore", environment)
+	if err != nil {
+		return err
+	}
+	key, data, err := fetchSnapshot(ctx, s, store, environment, arg)
+	if err != nil {
+		return err
+	}
+	if data, err = gunzipState(data); err != nil {
+		return configError("%s is not a gzipped state snapshot: %w", key, err)
+	}
+	snapshot, err := readState(data)
+	if err != nil {
+		return configError("%s is not a terraform state: %w", key, err)
+	}
+
+	a.out.Verbosef("Running terraform %v\n", tfexec.StatePullArgs(chdir))
+	pulled, err := tfexec.StatePull(ctx, runner, chdir, a.terraformOutput())
+	if err != nil {
+		return err
+	}
+	var current stateInfo
+	hasState := len(bytes.TrimSpace(pulled)) > 0
+	if hasState {
+		if current, err = readState(pulled); err != nil {
+			return err
+		}
+	}
+
+	a.out.Printf("Snapshot: serial %d, lineage %s (%s)\n", snapshot.Serial, snapshot.Lineage, key)
+	if hasState {
+		a.out.Printf("Current:  serial %d, lineage %s\n", current.Serial, current.Lineage)
+	} else {
+		a.out.Printf("Current:  there is no %s state\n", environment)
+	}
+	mismatch := hasState && current.Lineage != snapshot.Lineage
+	if mismatch && !force {
+		return withCode(exitCheck, fmt.Errorf("the snapshot's lineage %s doesn't match the current state's %s, it is the state of something else - pass --force to push it anyway", snapshot.Lineage, current.Lineage))
+	}
+	if mismatch {
+		a.out.Warnf("--force pushes a state with another lineage, whatever the current state tracks is forgotten")
+	}
+
+	warning := fmt.Sprintf("state restore replaces the %s state with the snapshot from %s.", environment, path.Base(key))
+	if !yes {
+		if err := a.askToConfirm(environment, "state restore", warning); err != nil {
+			return err
+		}
+	}
+
+	// the current state is kept before it is replaced, and not replaced if it can't be
+	if hasState {
+		if _, err := snapshotState(ctx, a, environment, chdir, "pre-restore", ""); err != nil {
+			return fmt.Errorf("could not snapshot the current state, nothing was restored: %w", err)
+		}
+	}
+	if hasState && snapshot.Serial <= current.Serial {
+		if data, err = withSerial(data, current.Serial+1); err != nil {
+			return fmt.Errorf("failed to update the snapshot's serial: %w", err)
+		}
+		a.out.Printf("Pushing the snapshot as serial %d, terraform refuses a serial older than the current one\n", current.Serial+1)
+	}
+	f, err := os.CreateTemp("", "tfmanage-restore-*.tfstate")
+	if err != nil {
+		return fmt.Errorf("failed to write the snapshot for terraform: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the snapshot for terraform: %w", err)
+	}
+
+	a.out.Verbosef("Running terraform %v\n", tfexec.StatePushArgs(chdir, f.Name(), force))
+	if err := tfexec.StatePush(ctx, runner, chdir, f.Name(), force, a.terraformOutput()); err != nil {
+		return err
+	}
+	a.out.Event("state-restore", map[string]any{"environment": environment, "bucket": s.S3Bucket, "key": key, "serial": snapshot.Serial, "lineage": snapshot.Lineage, "previous_serial": current.Serial, "previous_lineage": current.Lineage, "force": force})
+	a.out.Successf("Restored the %s state from s3://%s/%s", environment, s.S3Bucket, key)
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestStateSnapshotKey(t *testing.T) {
+	now := time.Date(2024, 5, 1, 13, 4, 5, 0, time.UTC)
+	if got := stateSnapshotKey(settings{S3Path: "team/"}, "prod", now, "pre-apply"); got != "team/state-snapshots/prod/20240501T130405Z-pre-apply.json.gz" {
+		t.Errorf("stateSnapshotKey() = %q", got)
+	}
+}
+
+// gunzipObject reads a snapshot back out of the store
+
+func gunzipObject(t *testing.T, store *storage.MemoryStore, key string) string {
+	t.Helper()
+	data, _ := store.Bytes(key)
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s is not gzipped: %v", key, err)
+	}
+	plain, _ := io.ReadAll(r)
+	return string(plain)
+}
+
+func TestApplySnapshotsState(t *testing.T) {
+	const state = `{"version":4,"serial":8,"lineage":"abc-123"}`
+	rec := &tfexec.RecordingRunner{Output: state}
+	useRunner(t, rec)
+	inTempDir(t)
+	store := withMemoryStore(t)
+	withCaller(t, deployerARN)
+	t.Setenv("KMS_KEY_ARN", planKMSKey)
+	for _, env := range []string{"dev", "prod"} {
+		os.WriteFile(env+".tfvars", nil, 0o644)
+		t.Setenv(strings.ToUpper(env)+"_TFVARS", env+".tfvars")
+	}
+	os.WriteFile("prod.tfplan", []byte("plan bytes"), 0o644)
+	snapshots := func() []storage.ObjectInfo {
+		objects, _ := store.List(context.Background(), "team/state-snapshots/")
+		return objects
+	}
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"apply", "prod", "--plan", "prod.tfplan"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("apply prod: %v", err)
+	}
+	objects := snapshots()
+	if len(objects) != 1 || !strings.HasPrefix(objects[0].Key, "team/state-snapshots/prod/") || !strings.HasSuffix(objects[0].Key, "-pre-apply.json.gz") {
+		t.Fatalf("snapshots = %+v, want one pre-apply snapshot of prod", objects)
+	}
+	key := objects[0].Key
+	if got := gunzipObject(t, store, key); got != state {
+		t.Errorf("snapshot content = %q", got)
+	}
+	info, _ := store.Head(context.Background(), key)
+	if info.Metadata["plan-sha256"] != planBytesSHA || info.Metadata["caller-arn"] != deployerARN || info.Metadata["serial"] != "8" || info.Metadata["lineage"] != "abc-123" || info.KMSKeyID != planKMSKey {
+		t.Errorf("snapshot = %+v, want the plan hash, caller, serial, lineage and the KMS key", info)
+	}
+	if !strings.HasSuffix(stdout.String(), "Pre-apply state snapshot: "+key+"\n") {
+		t.Errorf("apply output = %q, want the snapshot key at the end", stdout.String())
+	}
+
+	// on by default only for prod
+	if err := run([]string{"apply", "dev"}); err != nil {
+		t.Fatalf("apply dev: %v", err)
+	}
+	if err := run([]string{"apply", "prod", "--snapshot-state=false"}); err != nil {
+		t.Fatalf("apply prod --snapshot-state=false: %v", err)
+	}
+	if len(snapshots()) != 1 {
+		t.Errorf("snapshots = %+v, want none for dev or with --snapshot-state=false", snapshots())
+	}
+	if err := run([]string{"apply", "dev", "--snapshot-state"}); err != nil {
+		t.Fatalf("apply dev --snapshot-state: %v", err)
+	}
+	if objects := snapshots(); len(objects) != 2 || !strings.HasPrefix(objects[0].Key, "team/state-snapshots/dev/") {
+		t.Errorf("snapshots = %+v, want one of dev", objects)
+	}
+}
+
+func TestApplySnapshotFailure(t *testing.T) {
+	rec := &tfexec.RecordingRunner{Output: `{"version":4,"serial":8}`}
+	useRunner(t, rec)
+	inTempDir(t)
+	store := withMemoryStore(t)
+	withCaller(t, deployerARN)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	store.PutErr = fmt.Errorf("%w: no PutObject", storage.ErrAccessDenied)
+
+	if err := run([]string{"apply", "prod"}); !errors.Is(err, storage.ErrAccessDenied) || !strings.Contains(err.Error(), "--snapshot-best-effort") {
+		t.Errorf("apply with a failing snapshot: %v, want it stopped", err)
+	}
+	if calls := rec.Args(); len(calls) != 1 || calls[0][0] != "state" {
+		t.Errorf("terraform calls = %q, want only the state pull", calls)
+	}
+
+	rec.Calls = nil
+	if err := run([]string{"apply", "prod", "--snapshot-best-effort"}); err != nil {
+		t.Fatalf("apply --snapshot-best-effort: %v", err)
+	}
+	if calls := rec.Args(); len(calls) != 3 || calls[1][0] != "apply" {
+		t.Errorf("terraform calls = %q, want the apply to run", calls)
+	}
+}
+
+// storeSnapshot gzips a state into the store the way apply does
+
+func storeSnapshot(t *testing.T, store *storage.MemoryStore, key, state string) {
+	t.Helper()
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(state))
+	w.Close()
+	store.Put(context.Background(), storage.PutInput{Key: key, Body: &gz})
+}
+
+func TestStateRestore(t *testing.T) {
+	current := `{"version":4,"serial":8,"lineage":"abc-123"}`
+	var pushed string
+	rec := &tfexec.RecordingRunner{
+		OutputFor: func(args []string) string {
+			if slices.Contains(args, "pull") {
+				return current
+			}
+			return ""
+		},
+		Result: func(args []string) error {
+			if slices.Contains(args, "push") {
+				data, _ := os.ReadFile(args[len(args)-1])
+				pushed = string(data)
+			}
+			return nil
+		},
+	}
+	useRunner(t, rec)
+	inTempDir(t)
+	store := withMemoryStore(t)
+	withCaller(t, deployerARN)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	storeSnapshot(t, store, "team/state-snapshots/prod/20240101T000000Z-pre-apply.json.gz", `{"version":4,"serial":3,"lineage":"abc-123","resources":[]}`)
+	storeSnapshot(t, store, "team/state-snapshots/prod/20240102T000000Z-pre-apply.json.gz", `{"version":4,"serial":5,"lineage":"abc-123","resources":[]}`)
+	restore := func(input string, args ...string) error {
+		return runWithUI(append([]string{"state", "restore", "prod"}, args...), &ui{stdin: strings.NewReader(input), stdout: io.Discard, stderr: io.Discard})
+	}
+
+	if err := restore("dev\n", "latest"); !errors.Is(err, errNotConfirmed) || pushed != "" {
+		t.Fatalf("restore with the wrong name typed: %v, pushed %q", err, pushed)
+	}
+	if err := restore("prod\n", "latest"); err != nil {
+		t.Fatalf("state restore: %v", err)
+	}
+	var state struct {
+		Serial  int64  `json:"serial"`
+		Lineage string `json:"lineage"`
+	}
+	if err := json.Unmarshal([]byte(pushed), &state); err != nil || state.Serial != 9 || state.Lineage != "abc-123" || !strings.Contains(pushed, `"resources"`) {
+		t.Errorf("pushed %q, want the latest snapshot as serial 9", pushed)
+	}
+	if args := rec.Calls[len(rec.Calls)-1].Args; slices.Contains(args, "-force") {
+		t.Errorf("state push ran with %q, want no -force", args)
+	}
+	objects, _ := store.List(context.Background(), "team/state-snapshots/prod/")
+	if len(objects) != 3 || !strings.HasSuffix(objects[2].Key, "-pre-restore.json.gz") || gunzipObject(t, store, objects[2].Key) != current {
+		t.Errorf("snapshots = %+v, want the current state kept before the restore", objects)
+	}
+
+	// a snapshot of another state
+	current = `{"version":4,"serial":8,"lineage":"other"}`
+	pushed = ""
+	if err := restore("prod\n", "20240101T000000Z-pre-apply.json.gz"); exitCodeFor(err) != exitCheck || pushed != "" {
+		t.Errorf("restore with another lineage: %v, want exit %d and nothing pushed", err, exitCheck)
+	}
+	if err := restore("", "20240101T000000Z-pre-apply.json.gz", "--force", "--yes"); err != nil {
+		t.Fatalf("state restore --force: %v", err)
+	}
+	if args := rec.Calls[len(rec.Calls)-1].Args; !slices.Contains(args, "-force") || pushed == "" {
+		t.Errorf("state push ran with %q, want -force", args)
+	}
+
+	if err := restore("prod\n", "state-snapshots/dev/20240101T000000Z-pre-apply.json.gz"); exitCodeFor(err) != exitUsage {
+		t.Errorf("restoring a dev snapshot into prod: %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// --runner ssm - terraform runs on an instance through SSM Run Command with the instance's role, so the people running plan and apply never need the environment's credentials. The files go to the instance through the bucket
+
+// ssmStagePrefix is where the files for the instance are staged under S3_PATH, each run's are removed once it is over
+
+const ssmStagePrefix = "ssm/"
+
+// ssmCommands are the commands that can run terraform on an instance, the others read what terraform leaves in .terraform on this machine
+
+var ssmCommands = []string{"init", "plan", "apply"}
+
+// newSSMCommandClient gives back the client that sends the commands - a variable like newStore so the tests can fake the instance
+
+var newSSMCommandClient = func(ctx context.Context, s settings) (tfexec.SSMCommandAPI, error) {
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return nil, err
+	}
+	return ssm.NewFromConfig(cfg), nil
+}
+
+// useSSMRunner swaps the runner for one that runs terraform on --instance-id until the returned func puts it back
+
+func (a *app) useSSMRunner(ctx context.Context) (func(), error) {
+	s, err := a.loadSettings()
+	if err != nil {
+		return nil, err
+	}
+	if s.S3Bucket == "" {
+		return nil, configError("--runner ssm hands the files to the instance through the bucket, set S3_BUCKET")
+	}
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newSSMCommandClient(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	kmsKey, _ := kmsKeyFor(s, a.environment)
+	prefix := storage.Key(s.S3Path, ssmStagePrefix)
+	a.out.Verbosef("Running terraform on %s through SSM, with the files staged under s3://%s/%s\n", a.global.instanceID, s.S3Bucket, prefix)
+	old := runner
+	runner = tfexec.SSMRunner{
+		Client:     client,
+		InstanceID: a.global.instanceID,
+		Bucket:     s.S3Bucket,
+		Prefix:     prefix,
+		Staging:    bucketStaging{store: store, kmsKey: kmsKey},
+		Timeout:    a.global.ssmTimeout,
+	}
+	return func() { runner = old }, nil
+}
+
+// bucketStaging is the bucket as the SSM runner's staging, encrypted with the environment's KMS key since the staged environment has the secret variables
+
+type bucketStaging struct {
+	store  storage.Backend
+	kmsKey string
+}
+
+func (b bucketStaging) Put(ctx context.Context, key string, data []byte) error {
+	_, err := b.store.Put(ctx, storage.PutInput{Key: key, Body: bytes.NewReader(data), KMSKeyID: b.kmsKey})
+	return err
+}
+
+func (b bucketStaging) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := storage.GetBytes(ctx, b.store, key)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	return data, err
+}
+
+func (b bucketStaging) Delete(ctx context.Context, key string) error {
+	// a plan that failed never wrote its -out file
+	if err := b.store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// fakeInstance answers every command with response
+type fakeInstance struct {
+	scripts  []string
+	response *ssm.GetCommandInvocationOutput
+}
+
+func (f *fakeInstance) SendCommand(ctx context.Context, in *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	f.scripts = append(f.scripts, strings.Join(in.Parameters["commands"], "\n"))
+	return &ssm.SendCommandOutput{Command: &types.Command{CommandId: aws.String("cmd-1")}}, nil
+}
+
+func (f *fakeInstance) GetCommandInvocation(ctx context.Context, in *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	return f.response, nil
+}
+
+func (f *fakeInstance) CancelCommand(ctx context.Context, in *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+	return &ssm.CancelCommandOutput{}, nil
+}
+
+func TestSSMRunnerFlag(t *testing.T) {
+	local := &tfexec.RecordingRunner{}
+	instance := &fakeInstance{response: &ssm.GetCommandInvocationOutput{Status: types.CommandInvocationStatusSuccess, StandardOutputContent: aws.String("Terraform has been successfully initialized!\n")}}
+	useRunner(t, local)
+	swap(t, &newSSMCommandClient, func(context.Context, settings) (tfexec.SSMCommandAPI, error) { return instance, nil })
+	inTempDir(t)
+	store := withMemoryStore(t)
+	os.WriteFile("main.tf", nil, 0o644)
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	// the roles are the instance's business, assuming them here would fail without credentials
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dev:\n    assume_roles: [arn:aws:iam::111111111111:role/deploy]\n"), 0o644)
+
+	if err := run([]string{"--runner", "ssm", "--instance-id", "i-0abc", "init", "dev"}); err != nil {
+		t.Fatalf("init through SSM: %v", err)
+	}
+	if len(local.Calls) != 0 || len(instance.scripts) != 1 || !strings.Contains(instance.scripts[0], "'init' '-input=false'") {
+		t.Fatalf("local terraform ran %q, the instance %q", local.Args(), instance.scripts)
+	}
+	if !strings.Contains(instance.scripts[0], "'s3://tfvars-bucket/team/ssm/tfmanage-") {
+		t.Errorf("the files weren't staged under S3_PATH: %s", instance.scripts[0])
+	}
+	if objects, _ := store.List(context.Background(), "team/ssm/"); len(objects) != 0 {
+		t.Errorf("staged files left in the bucket: %v", objects)
+	}
+	if runner != local {
+		t.Error("the SSM runner wasn't put back after the command")
+	}
+
+	instance.response = &ssm.GetCommandInvocationOutput{Status: types.CommandInvocationStatusFailed, ResponseCode: 1}
+	if err := run([]string{"--runner", "ssm", "--instance-id", "i-0abc", "init", "dev"}); exitCodeFor(err) != exitTerraform {
+		t.Errorf("terraform failing on the instance: %v (exit code %d)", err, exitCodeFor(err))
+	}
+	instance.response = &ssm.GetCommandInvocationOutput{Status: types.CommandInvocationStatusTimedOut, ResponseCode: -1, StatusDetails: aws.String("ExecutionTimedOut")}
+	if err := run([]string{"--runner", "ssm", "--instance-id", "i-0abc", "init", "dev"}); exitCodeFor(err) != exitTerraform || !strings.Contains(hintFor(err), "Systems Manager") {
+		t.Errorf("command timing out: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"--runner", "ssm", "init", "dev"},
+		{"--instance-id", "i-0abc", "init", "dev"},
+		{"--runner", "ssm", "--instance-id", "i-0abc", "--ssm-timeout", "-1s", "init", "dev"},
+		{"--runner", "ssm", "--instance-id", "i-0abc", "workspace", "list", "dev"},
+	} {
+		if err := run(args); exitCodeFor(err) != exitUsage {
+			t.Errorf("%q: %v, want a usage error", args, err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/redact"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// stateBackupPrefix is where state backups go under S3_PATH, one folder per environment
+
+const stateBackupPrefix = "state-backups"
+
+func stateCommand() *command {
+	return &command{
+		name:    "state",
+		args:    "backup|list|show|restore|diff <env> [pattern|address|snapshot] [snapshot|current]",
+		summary: "Back up, list, show, restore or diff the terraform state of an environment.",
+		examples: []string{
+			"tfmanage state backup prod",
+			"tfmanage state backup dev --chdir infra",
+			"tfmanage state list prod module.network",
+			"tfmanage state show prod aws_db_instance.main",
+			"tfmanage state restore prod latest",
+			"tfmanage state diff prod latest current",
+		},
+		minArgs: 2,
+		maxArgs: 4,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			raw := fs.Bool("raw", false, "state show: print the resource without hiding sensitive attributes")
+			force := fs.Bool("force", false, "state restore: push the snapshot even when its lineage doesn't match the current state, with terraform state push -force")
+			yes := fs.Bool("yes", false, "state restore: don't ask for the environment name first")
+			namesOnly := fs.Bool("names-only", false, "state diff: only list the addresses that changed")
+			storageClass := fs.String("storage-class", "", "state backup: the S3 storage class of the backup (default retention.storage_class from the config, or STANDARD)")
+			return func(ctx context.Context, a *app, args []string) error {
+				switch args[0] {
+				case "backup":
+					if len(args) != 2 {
+						return usageError("state backup takes just the environment")
+					}
+					if err := checkStorageClass(*storageClass); err != nil {
+						return err
+					}
+					if storage.NeedsRestore(*storageClass) {
+						return usageError("a state backup can't go to %s, restoring it would take hours, use GLACIER_IR for instant retrieval at archive prices", *storageClass)
+					}
+					if err := a.prepareStateBackup(args[1]); err != nil {
+						return err
+					}
+					if err := a.useTerraformCredentials(ctx, args[1]); err != nil {
+						return err
+					}
+					_, err := backupState(ctx, a, args[1], a.useEnvironment(args[1], *chdir), *storageClass)
+					return err
+				case "list":
+					if err := a.checkEnvironment(args[1]); err != nil {
+						return err
+					}
+					if err := a.useTerraformCredentials(ctx, args[1]); err != nil {
+						return err
+					}
+					var addresses []string
+					if len(args) == 3 {
+						addresses = args[2:]
+					}
+					return stateList(ctx, a, a.useEnvironment(args[1], *chdir), addresses)
+				case "show":
+					if len(args) != 3 {
+						return usageError("state show needs the environment and a resource address")
+					}
+					if err := a.checkEnvironment(args[1]); err != nil {
+						return err
+					}
+					if err := a.useTerraformCredentials(ctx, args[1]); err != nil {
+						return err
+					}
+					return stateShow(ctx, a, a.useEnvironment(args[1], *chdir), args[2], *raw)
+				case "restore":
+					if len(args) != 3 {
+						return usageError("state restore needs the environment and a snapshot key or latest")
+					}
+					if err := a.checkEnvironment(args[1]); err != nil {
+						return err
+					}
+					if err := a.lockEnvironment(args[1], "state restore"); err != nil {
+						return err
+					}
+					if err := a.useTerraformCredentials(ctx, args[1]); err != nil {
+						return err
+					}
+					return restoreState(ctx, a, args[1], a.useEnvironment(args[1], *chdir), args[2], *force, *yes)
+				case "diff":
+					if len(args) != 4 {
+						return usageError("state diff needs the environment and two snapshots, or a snapshot and current")
+					}
+					if err := a.checkEnvironment(args[1]); err != nil {
+						return err
+					}
+					if err := a.useTerraformCredentials(ctx, args[1]); err != nil {
+						return err
+					}
+					return diffStates(ctx, a, args[1], a.useEnvironment(args[1], *chdir), args[2], args[3], *namesOnly)
+				}
+				return usageError("unknown state subcommand %q, use backup, list, show, restore or diff", args[0])
+			}
+		},
+	}
+}
+
+// checkEnvironment only checks the environment exists, for the state commands that don't need its tfvars file
+
+func (a *app) checkEnvironment(environment string) error {
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	if _, ok := s.TFVars[environment]; !ok {
+		_, err := a.tfvarsFor(environment)
+		return err
+	}
+	return nil
+}
+
+// stateList streams terraform state list, a pattern narrows it down like terraform's own addresses do
+
+func stateList(ctx context.Context, a *app, chdir string, addresses []string) error {
+	a.out.Verbosef("Running terraform %v\n", tfexec.StateListArgs(chdir, addresses...))
+	return tfexec.StateList(ctx, runner, chdir, addresses, a.streamOutput())
+}
+
+// stateShow streams terraform state show with the attributes named like secrets masked too, unless raw is set. Raw still masks the values that are never shown
+
+func stateShow(ctx context.Context, a *app, chdir, address string, raw bool) error {
+	a.out.Verbosef("Running terraform %v\n", tfexec.StateShowArgs(chdir, address))
+	run := a.streamOutput()
+	if raw {
+		run.Redactor = a.neverShown()
+	} else {
+		run.Redactor = cmp.Or(run.Redactor, redact.NewRedactor()).WithAttributes()
+	}
+	return tfexec.StateShow(ctx, runner, chdir, address, run)
+}
+
+// prepareStateBackup checks the environment is known and the bucket is set up, the state itself comes from terraform
+
+func (a *app) prepareStateBackup(environment string) error {
+	if err := a.checkEnvironment(environment); err != nil {
+		return err
+	}
+	s, err := a.loadSettings()
+	if err != nil {
+		return err
+	}
+	return requirementsError("state backup", checkRequirements("state backup", environment, s))
+}
+
+// stateInfo is what the tool reads out of a state file
+
+type stateInfo struct {
+	Serial  int64
+	Lineage string
+}
+
+// readState checks the pulled state is a state and gives back its serial and lineage
+
+func readState(data []byte) (stateInfo, error) {
+	if len(data) == 0 {
+		return stateInfo{}, configError("there is no state to back up, has this configuration been applied yet?")
+	}
+	var state struct {
+		Serial  *int64 `json:"serial"`
+		Lineage string `json:"lineage"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return stateInfo{}, fmt.Errorf("terraform state pull did not print a valid state: %w", err)
+	}
+	if state.Serial == nil {
+		return stateInfo{}, fmt.Errorf("terraform state pull did not print a valid state: it has no serial")
+	}
+	return stateInfo{Serial: *state.Serial, Lineage: state.Lineage}, nil
+}
+
+func stateSerial(data []byte) (int64, error) {
+	info, err := readState(data)
+	return info.Serial, err
+}
+
+func stateBackupKey(s settings, environment string, now time.Time, serial int64) string {
+	name := fmt.Sprintf("%s-serial%d.json", now.UTC().Format("20060102T150405Z"), serial)
+	return storage.Key(s.S3Path, path.Join(stateBackupPrefix, environment, name))
+}
+
+// backupState pulls the state, uploads it in storageClass, or retention.storage_class when that's empty, and prunes the old backups - it returns the key of the new backup
+
+func backupState(ctx context.Context, a *app, environment, chdir, storageClass string) (string, error) {
+	s, err := a.loadSettings()
+	if err != nil {
+		return "", err
+	}
+	if storageClass == "" {
+		if storageClass, err = retentionStorageClass(s); err != nil {
+			return "", err
+		}
+	}
+	a.out.Printf("Backing up the %s state...\n", environment)
+	a.out.Verbosef("Running terraform %v\n", tfexec.StatePullArgs(chdir))
+	run := a.terraformOutput()
+	data, err := tfexec.StatePull(ctx, runner, chdir, run)
+	if err != nil {
+		return "", err
+	}
+	serial, err := stateSerial(data)
+	if err != nil {
+		return "", err
+	}
+
+	store, err := newStore(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	key := stateBackupKey(s, environment, time.Now(), serial)
+	res, err := storage.PutBytesWith(ctx, store, key, data, storage.UploadOptions{StorageClass: storageClass})
+	if err != nil {
+		return "", err
+	}
+	a.out.Event("state-backup", map[string]any{"environment": environment, "bucket": s.S3Bucket, "key": key, "serial": serial, "sha256": res.Checksum, "storage_class": storageClass})
+	a.out.Successf("Backed up state serial %d to s3://%s/%s", serial, s.S3Bucket, key)
+
+	// a failed prune doesn't undo the backup, it just leaves more behind
+	prefix := storage.Key(s.S3Path, stateBackupPrefix+"/"+environment+"/")
+	deleted, err := storage.Prune(ctx, store, prefix, s.Retention.Keep)
+	if err != nil {
+		a.out.Warnf("Could not prune old state backups: %v", err)
+	}
+	if len(deleted) > 0 {
+		a.out.Printf("Pruned %d old state backup(s), keeping the newest %d\n", len(deleted), s.Retention.Keep)
+	}
+	return key, nil
+}
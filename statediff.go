@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/statediff"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// state diff - which resources a snapshot and another snapshot, or the current state, disagree on. The changes are printed as they are found so a state with thousands of resources never becomes one big string
+
+// loadStateForDiff reads a snapshot, or the current state for current
+
+func loadStateForDiff(ctx context.Context, a *app, environment, chdir, arg string) (statediff.State, error) {
+	if arg == "current" {
+		a.out.Verbosef("Running terraform %v\n", tfexec.StatePullArgs(chdir))
+		data, err := tfexec.StatePull(ctx, runner, chdir, a.terraformOutput())
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			return statediff.State{}, nil
+		}
+		state, err := statediff.Read(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("terraform state pull did not print a valid state: %w", err)
+		}
+		return state, nil
+	}
+	s, store, err := a.snapshotStore(ctx, "state diff", environment)
+	if err != nil {
+		return nil, err
+	}
+	key, data, err := fetchSnapshot(ctx, s, store, environment, arg)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, configError("%s is not a gzipped state snapshot: %w", key, err)
+	}
+	defer r.Close()
+	state, err := statediff.Read(r)
+	if err != nil {
+		return nil, configError("%s: %w", key, err)
+	}
+	return state, nil
+}
+
+// diffStates prints the resources added, removed and modified between two states, namesOnly leaves out the attributes
+
+func diffStates(ctx context.Context, a *app, environment, chdir, argA, argB string, namesOnly bool) error {
+	before, err := loadStateForDiff(ctx, a, environment, chdir, argA)
+	if err != nil {
+		return err
+	}
+	after, err := loadStateForDiff(ctx, a, environment, chdir, argB)
+	if err != nil {
+		return err
+	}
+
+	// names only is for piping into other commands, nothing but the addresses
+	if !namesOnly {
+		a.out.Printf("Comparing %s with %s:\n", argA, argB)
+	}
+	marks := map[string]string{statediff.Added: "+", statediff.Removed: "-", statediff.Modified: "~"}
+	summary, err := statediff.Diff(before, after, func(c statediff.Change) error {
+		if namesOnly {
+			c.Attributes = nil
+		}
+		a.out.Event("state-diff-resource", map[string]any{"environment": environment, "address": c.Address, "action": c.Action, "attributes": c.Attributes})
+		if a.out.json {
+			return nil
+		}
+		if namesOnly {
+			fmt.Fprintln(a.out.humanOut(), c.Address)
+			return nil
+		}
+		a.out.DiffLine(marks[c.Action] + " " + c.Address)
+		for _, attr := range c.Attributes {
+			before, after := attr.Before, attr.After
+			if before == "" {
+				before = "(none)"
+			}
+			if after == "" {
+				after = "(none)"
+			}
+			a.out.Printf("    %s: %s -> %s\n", attr.Name, before, after)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	a.out.Event("state-diff", map[string]any{"environment": environment, "a": argA, "b": argB, "summary": summary})
+	if !namesOnly {
+		a.out.Printf("\n%d added, %d removed, %d modified\n", summary.Added, summary.Removed, summary.Modified)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestStateDiff(t *testing.T) {
+	rec := &tfexec.RecordingRunner{Output: `{"version":4,"serial":9,"lineage":"abc","resources":[
+		{"mode":"managed","type":"aws_db_instance","name":"main","instances":[{"attributes":{"id":"db-1","password":"new-one","instance_class":"db.t3.large"}}]},
+		{"mode":"managed","type":"aws_s3_bucket","name":"logs","instances":[{"attributes":{"id":"logs"}}]}]}`}
+	useRunner(t, rec)
+	inTempDir(t)
+	store := withMemoryStore(t)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+	storeSnapshot(t, store, "team/state-snapshots/prod/20240101T000000Z-pre-apply.json.gz", `{"version":4,"serial":8,"lineage":"abc","resources":[
+		{"mode":"managed","type":"aws_db_instance","name":"main","instances":[{"attributes":{"id":"db-1","password":"old-one","instance_class":"db.t3.medium"}}]},
+		{"mode":"managed","type":"aws_iam_role","name":"app","instances":[{"attributes":{"id":"app"}}]}]}`)
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"state", "diff", "prod", "latest", "current"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("state diff: %v", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"~ aws_db_instance.main\n",
+		`    instance_class: "db.t3.medium" -> "db.t3.large"`,
+		"    password: (redacted) -> (redacted)\n",
+		"- aws_iam_role.app\n",
+		"+ aws_s3_bucket.logs\n",
+		"1 added, 1 removed, 1 modified\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("state diff output = %q, want %q in it", out, want)
+		}
+	}
+	if strings.Contains(out, "old-one") || strings.Contains(out, "new-one") {
+		t.Errorf("state diff printed the password: %q", out)
+	}
+
+	stdout.Reset()
+	if err := runWithUI([]string{"state", "diff", "prod", "latest", "current", "--names-only"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("state diff --names-only: %v", err)
+	}
+	if got := stdout.String(); got != "aws_db_instance.main\naws_iam_role.app\naws_s3_bucket.logs\n" {
+		t.Errorf("state diff --names-only = %q", got)
+	}
+
+	stdout.Reset()
+	if err := runWithUI([]string{"--output", "json", "state", "diff", "prod", "20240101T000000Z-pre-apply.json.gz", "current"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("state diff --output json: %v", err)
+	}
+	var resources int
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("not JSON: %q", line)
+		}
+		if event["event"] == "state-diff-resource" {
+			resources++
+		}
+		if strings.Contains(line, "old-one") {
+			t.Errorf("the JSON has the password in it: %s", line)
+		}
+	}
+	if resources != 3 {
+		t.Errorf("%d state-diff-resource events, want 3:\n%s", resources, stdout.String())
+	}
+
+	if err := run([]string{"state", "diff", "prod", "latest"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("state diff with one state: %v, want a usage error", err)
+	}
+}
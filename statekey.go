@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// init and the managed state key - an environment with state_key_template or manage_state_key gets its backend key from the template, not from whoever ran terraform init last
+
+const defaultStateKeyTemplate = "env:/<workspace>/<prefix>/<env>/terraform.tfstate"
+
+var unknownPlaceholder = regexp.MustCompile(`<[^<>/]+>`)
+
+// stateKey is the key the environment's state is kept under, when the config manages it
+
+func stateKey(s settings, environment string) (string, bool, error) {
+	env := s.Terraform[environment]
+	template := env.StateKeyTemplate
+	if template == "" {
+		if !env.ManageStateKey {
+			return "", false, nil
+		}
+		template = defaultStateKeyTemplate
+	}
+	workspace := env.Workspace
+	if workspace == "" {
+		workspace = "default"
+	}
+	key := strings.NewReplacer("<env>", environment, "<workspace>", workspace, "<prefix>", strings.Trim(s.S3Path, "/")).Replace(template)
+	if p := unknownPlaceholder.FindString(key); p != "" {
+		return "", false, configError("state_key_template %q of %s has %s in it, only <env>, <workspace> and <prefix> can be used", template, environment, p)
+	}
+	// an empty prefix leaves a double slash behind
+	for strings.Contains(key, "//") {
+		key = strings.ReplaceAll(key, "//", "/")
+	}
+	return key, true, nil
+}
+
+// initializedBackend is the backend terraform init last set the directory up with, false before init has run
+
+func initializedBackend(dir string) (tfexec.Backend, bool) {
+	b, err := tfexec.ReadBackend(dir)
+	return b, err == nil && strings.HasSuffix(b.Source, filepath.Join(".terraform", "terraform.tfstate"))
+}
+
+func initCommand() *command {
+	return &command{
+		name:    "init",
+		args:    "<env>",
+		summary: "Run terraform init for the environment, with the backend key from its state_key_template when it has one.",
+		examples: []string{
+			"tfmanage init dev",
+			"tfmanage init prod --migrate-state",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			migrateState := fs.Bool("migrate-state", false, "copy the state to the new key when the backend key changed, with terraform init -migrate-state")
+			yes := fs.Bool("yes", false, "don't ask before migrating the state")
+			syncLockfile := fs.Bool("sync-lockfile", false, "replace .terraform.lock.hcl with the environment's canonical lock file first")
+			return func(ctx context.Context, a *app, args []string) error {
+				env := args[0]
+				if err := a.checkEnvironment(env); err != nil {
+					return err
+				}
+				if err := a.lockEnvironment(env, "init"); err != nil {
+					return err
+				}
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				dir := a.useEnvironment(env, *chdir)
+				if err := a.useTerraformCredentials(ctx, env); err != nil {
+					return err
+				}
+				if *syncLockfile {
+					if err := downloadLockfile(ctx, a, env, dir, false); err != nil {
+						return err
+					}
+				}
+				key, managed, err := stateKey(s, env)
+				if err != nil {
+					return err
+				}
+
+				opts := tfexec.InitOptions{Chdir: dir, NoColor: !a.out.color}
+				migration := "init --migrate-state copies the state of " + env + " to the backend as it is configured now."
+				if managed {
+					a.out.Printf("\nState key for %s: %s\n\n", env, a.out.green(key))
+					a.out.Event("state-key", map[string]any{"environment": env, "key": key})
+					opts.BackendConfig = []string{"key=" + key}
+					current, initialized := initializedBackend(dir)
+					switch {
+					case initialized && current.Key != key && !*migrateState:
+						return configError("the backend is initialized with the state key %s, not %s. Changing the key leaves the state behind, run init again with --migrate-state to copy it", current.Key, key)
+					case initialized && current.Key != key:
+						migration = fmt.Sprintf("init --migrate-state copies the state of %s from %s to %s.", env, current.Key, key)
+					case *migrateState:
+						a.out.Warnf("The state key of %s hasn't changed, there is no state to migrate", env)
+						*migrateState = false
+					}
+				}
+				if *migrateState {
+					if !*yes {
+						if err := a.askToConfirm(env, "init --migrate-state", migration); err != nil {
+							return err
+						}
+					}
+					opts.MigrateState = true
+				}
+
+				a.out.Verbosef("Running terraform %v\n", tfexec.InitArgs(opts))
+				if err := tfexec.Init(ctx, runner, opts, a.streamOutput()); err != nil {
+					return err
+				}
+				a.out.Event("init", map[string]any{"environment": env, "state_key": key, "migrated": opts.MigrateState})
+				return nil
+			}
+		},
+	}
+}
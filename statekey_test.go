@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestStateKey(t *testing.T) {
+	s := settings{S3Path: "team/app/", Terraform: map[string]config.Environment{
+		"dev":     {ManageStateKey: true},
+		"prod":    {Workspace: "blue", StateKeyTemplate: "<prefix>/<workspace>/<env>.tfstate"},
+		"staging": {StateKeyTemplate: "<env>/<region>.tfstate"},
+	}}
+	for env, want := range map[string]string{"dev": "env:/default/team/app/dev/terraform.tfstate", "prod": "team/app/blue/prod.tfstate", "qa": ""} {
+		if key, managed, err := stateKey(s, env); err != nil || key != want || managed != (want != "") {
+			t.Errorf("stateKey(%s) = %q, %v, %v, want %q", env, key, managed, err, want)
+		}
+	}
+	if _, _, err := stateKey(s, "staging"); exitCodeFor(err) != exitConfig {
+		t.Errorf("stateKey() with <region> = %v, want a config error", err)
+	}
+	s.S3Path = ""
+	if key, _, _ := stateKey(s, "dev"); key != "env:/default/dev/terraform.tfstate" {
+		t.Errorf("stateKey() without a prefix = %q", key)
+	}
+}
+
+func TestInitStateKey(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	t.Setenv("S3_PATH", "team/")
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dev:\n    manage_state_key: true\n"), 0o644)
+	const key = "env:/default/team/dev/terraform.tfstate"
+
+	if err := run([]string{"init", "dev"}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if args := rec.Calls[0].Args; !slices.Contains(args, "-backend-config=key="+key) || slices.Contains(args, "-migrate-state") {
+		t.Errorf("init ran %q, want the key from the template", args)
+	}
+	// what terraform init would have left behind
+	os.Mkdir(".terraform", 0o755)
+	initialized := func(key string) {
+		os.WriteFile(filepath.Join(".terraform", "terraform.tfstate"), []byte(`{"backend":{"type":"s3","config":{"key":"`+key+`"}}}`), 0o644)
+	}
+	initialized(key)
+	if err := run([]string{"plan", "dev", "plan.out"}); err != nil {
+		t.Fatalf("plan with the managed key: %v", err)
+	}
+
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dev:\n    state_key_template: <prefix>/<env>.tfstate\n"), 0o644)
+	if err := run([]string{"plan", "dev", "plan.out"}); !errors.Is(err, errBackendMismatch) {
+		t.Errorf("plan after the template changed: %v, want a backend mismatch", err)
+	}
+	calls := len(rec.Calls)
+	if err := run([]string{"init", "dev"}); exitCodeFor(err) != exitConfig || len(rec.Calls) != calls {
+		t.Fatalf("init after the template changed: %v, want it refused without --migrate-state", err)
+	}
+	// depending on how the tests are run stdin is empty or not a terminal at all
+	if err := run([]string{"init", "dev", "--migrate-state"}); !errors.Is(err, errNotConfirmed) && exitCodeFor(err) != exitUsage {
+		t.Errorf("init --migrate-state without confirming: %v, want it refused", err)
+	}
+	if err := run([]string{"init", "dev", "--migrate-state", "--yes"}); err != nil {
+		t.Fatalf("init --migrate-state --yes: %v", err)
+	}
+	if args := rec.Calls[len(rec.Calls)-1].Args; !slices.Contains(args, "-backend-config=key=team/dev.tfstate") || !slices.Contains(args, "-migrate-state") {
+		t.Errorf("init --migrate-state ran %q", args)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/cache"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// status - like git status for the tfvars, whether each environment's local file matches the remote one, worked out from the checksums, the sync state of the last download or upload and a Head of every remote file at once
+
+const (
+	syncInSync        = "in-sync"
+	syncLocalModified = "local-modified"
+	syncRemoteNewer   = "remote-newer"
+	syncBothChanged   = "both-changed"
+	syncLocalMissing  = "local-missing"
+	syncRemoteMissing = "remote-missing"
+	syncNotConfigured = "not-configured"
+	syncError         = "error"
+)
+
+// syncStatus is one environment's row
+
+type syncStatus struct {
+	Environment     string `json:"environment"`
+	State           string `json:"state"`
+	File            string `json:"file,omitempty"`
+	Location        string `json:"location,omitempty"`
+	LocalSHA256     string `json:"local_sha256,omitempty"`
+	RemoteETag      string `json:"remote_etag,omitempty"`
+	RemoteVersionID string `json:"remote_version_id,omitempty"`
+	BaseETag        string `json:"base_etag,omitempty"`
+	Cache           string `json:"cache"`
+	Detail          string `json:"detail,omitempty"`
+	// when the cached copy was downloaded and what is wrong with it, for the cache-status event
+	cacheDownloaded, cacheDetail string
+}
+
+func statusCommand() *command {
+	return &command{
+		name:    "status",
+		args:    "[env]",
+		summary: "Show whether each environment's local tfvars match the remote ones, and its cached copy. Exits 69 unless they all do.",
+		examples: []string{
+			"tfmanage status",
+			"tfmanage status prod --output json",
+			"tfmanage status --exit-zero",
+		},
+		minArgs: 0,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			exitZero := fs.Bool("exit-zero", false, "exit 0 even when environments are out of sync")
+			return func(ctx context.Context, a *app, args []string) error {
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				environments := environmentNames(s)
+				if len(args) == 1 {
+					if err := a.checkEnvironment(args[0]); err != nil {
+						return err
+					}
+					environments = args
+				}
+				c, err := tfvarsCache()
+				if err != nil {
+					return err
+				}
+
+				statuses := make([]syncStatus, len(environments))
+				var wg sync.WaitGroup
+				for i, env := range environments {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						statuses[i] = environmentStatus(ctx, s, c, env)
+					}()
+				}
+				wg.Wait()
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				var rows [][]string
+				var outOfSync []string
+				for _, st := range statuses {
+					a.out.Event("cache-status", map[string]any{"environment": st.Environment, "status": st.Cache, "downloaded_at": st.cacheDownloaded, "detail": st.cacheDetail})
+					a.out.Event("sync-status", map[string]any{"environment": st.Environment, "state": st.State, "file": st.File, "location": st.Location, "local_sha256": st.LocalSHA256, "remote_etag": st.RemoteETag, "remote_version_id": st.RemoteVersionID, "base_etag": st.BaseETag, "cache": st.Cache, "detail": st.Detail})
+					rows = append(rows, []string{st.Environment, st.State, st.Cache, st.Detail})
+					if st.State != syncInSync && st.State != syncNotConfigured {
+						outOfSync = append(outOfSync, st.Environment+" ("+st.State+")")
+					}
+				}
+				if !a.out.json {
+					a.out.Table(a.out.humanOut(), []string{"ENVIRONMENT", "STATE", "CACHE", "DETAIL"}, rows, func(col int, cell string) string {
+						if col == 1 || col == 2 {
+							return a.out.statusColor(cell)
+						}
+						return cell
+					})
+				}
+				if len(outOfSync) == 0 || *exitZero {
+					return nil
+				}
+				return withCode(exitCheck, fmt.Errorf("%d environment(s) are not in sync: %s", len(outOfSync), strings.Join(outOfSync, ", ")))
+			}
+		},
+	}
+}
+
+// environmentStatus works out one environment's row. It runs next to the others, so it only reads the settings and the state and never prints
+
+func environmentStatus(ctx context.Context, s settings, c cache.Cache, environment string) syncStatus {
+	st := syncStatus{Environment: environment, State: syncNotConfigured, Cache: statusUnknown, cacheDetail: "not cached"}
+	if entry, err := c.Read(tfvarsCacheName(s, environment), environment); err == nil {
+		st.Cache, st.cacheDetail = cacheStatus(ctx, s, environment, entry)
+		st.cacheDownloaded = entry.DownloadedAt.Format(time.RFC3339)
+	} else if !errors.Is(err, cache.ErrNotCached) {
+		st.cacheDetail = err.Error()
+	}
+
+	fileName := s.TFVars[environment]
+	if fileName == "" {
+		st.Detail = "no tfvars file is set, set " + tfvarsEnvVar(environment)
+		return st
+	}
+	st.File = fileName
+	if requirementsError("status", checkStoreRequirements("download", environment, s)) != nil {
+		st.Detail = "the bucket settings are not set"
+		return st
+	}
+	loc, err := tfvarsStore(ctx, s, environment, fileName)
+	if err != nil {
+		st.State, st.Detail = syncError, err.Error()
+		return st
+	}
+	st.Location = loc.url(loc.key)
+
+	localInfo, localErr := os.Stat(fileName)
+	if localErr == nil {
+		if st.LocalSHA256, localErr = storage.FileChecksum(fileName); localErr != nil {
+			st.State, st.Detail = syncError, localErr.Error()
+			return st
+		}
+	} else if !errors.Is(localErr, os.ErrNotExist) {
+		st.State, st.Detail = syncError, localErr.Error()
+		return st
+	}
+	remote, remoteErr := loc.store.Head(ctx, loc.key)
+	if remoteErr != nil && !errors.Is(remoteErr, storage.ErrObjectNotFound) {
+		st.State, st.Detail = syncError, remoteErr.Error()
+		return st
+	}
+	st.RemoteETag, st.RemoteVersionID = remote.ETag, remote.VersionID
+
+	switch {
+	case localErr != nil && remoteErr != nil:
+		st.State, st.Detail = syncRemoteMissing, "neither the local nor the remote file exists"
+		return st
+	case localErr != nil:
+		st.State, st.Detail = syncLocalMissing, fileName+" doesn't exist, download it"
+		return st
+	case remoteErr != nil:
+		st.State, st.Detail = syncRemoteMissing, st.Location+" doesn't exist, upload it"
+		return st
+	}
+
+	base, tracked, _ := readSyncState(environment, fileName, st.Location)
+	st.BaseETag = base.ETag
+	if remote.Metadata[storage.ChecksumMetadataKey] == st.LocalSHA256 {
+		st.State = syncInSync
+		return st
+	}
+	if !tracked {
+		// never synced from here, the newer of the two is the one that changed
+		st.State, st.Detail = syncRemoteNewer, "never downloaded here, the remote file is newer"
+		if localInfo.ModTime().After(remote.LastModified) {
+			st.State, st.Detail = syncLocalModified, "never downloaded here, the local file is newer"
+		}
+		return st
+	}
+	localChanged, remoteChanged := st.LocalSHA256 != base.SHA256, remote.ETag != base.ETag
+	switch {
+	case localChanged && remoteChanged:
+		st.State, st.Detail = syncBothChanged, "changed here and uploaded since "+base.At.Format(time.RFC3339)+", run merge-remote"
+	case localChanged:
+		st.State, st.Detail = syncLocalModified, "changed since "+base.At.Format(time.RFC3339)+", upload it"
+	case remoteChanged:
+		st.State, st.Detail = syncRemoteNewer, "uploaded at "+remote.LastModified.Format(time.RFC3339)+", download it"
+	default:
+		st.State = syncInSync
+	}
+	return st
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+func TestStatus(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	ctx := context.Background()
+	os.WriteFile("tfmanage.yaml", []byte(`environments:
+  synced:
+    tfvars: synced.tfvars
+  edited:
+    tfvars: edited.tfvars
+  behind:
+    tfvars: behind.tfvars
+  both:
+    tfvars: both.tfvars
+  gone:
+    tfvars: gone.tfvars
+  new:
+    tfvars: new.tfvars
+`), 0o644)
+	for _, env := range []string{"synced", "edited", "behind", "both", "gone"} {
+		os.WriteFile(env+".tfvars", []byte("replicas = 1\n"), 0o644)
+		if err := run([]string{"upload", env}); err != nil {
+			t.Fatalf("upload %s: %v", env, err)
+		}
+	}
+	os.WriteFile("edited.tfvars", []byte("replicas = 2\n"), 0o644)
+	storage.PutBytes(ctx, store, "team/behind.tfvars", []byte("replicas = 3\n"))
+	os.WriteFile("both.tfvars", []byte("replicas = 2\n"), 0o644)
+	storage.PutBytes(ctx, store, "team/both.tfvars", []byte("replicas = 3\n"))
+	os.Remove("gone.tfvars")
+	os.WriteFile("new.tfvars", []byte("replicas = 1\n"), 0o644)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"--output", "json", "status"}, &ui{json: true, stdout: &stdout, stderr: io.Discard})
+	if exitCodeFor(err) != exitCheck {
+		t.Errorf("status with environments out of sync: %v, want exit code %d", err, exitCheck)
+	}
+	got := map[string]string{}
+	for line := range strings.Lines(stdout.String()) {
+		var event map[string]any
+		if json.Unmarshal([]byte(line), &event); event["event"] == "sync-status" {
+			got[event["environment"].(string)] = event["state"].(string)
+		}
+	}
+	want := map[string]string{
+		"synced": syncInSync,
+		"edited": syncLocalModified,
+		"behind": syncRemoteNewer,
+		"both":   syncBothChanged,
+		"gone":   syncLocalMissing,
+		"new":    syncRemoteMissing,
+		"dev":    syncNotConfigured,
+	}
+	for env, state := range want {
+		if got[env] != state {
+			t.Errorf("status of %s = %q, want %q", env, got[env], state)
+		}
+	}
+
+	if err := run([]string{"status", "--exit-zero"}); err != nil {
+		t.Errorf("status --exit-zero: %v", err)
+	}
+	if err := run([]string{"status", "synced"}); err != nil {
+		t.Errorf("status of an environment in sync: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// Storage classes - uploads can go to a cheaper S3 storage class, and a download of an object archived in GLACIER or DEEP_ARCHIVE offers to restore it first
+
+// restorePollInterval is how often --wait checks on a restore, restores take minutes at best
+
+var restorePollInterval = 30 * time.Second
+
+const (
+	defaultRestoreTier = "Standard"
+	defaultRestoreDays = 1
+)
+
+// checkStorageClass refuses a --storage-class S3 doesn't have
+
+func checkStorageClass(class string) error {
+	if class == "" {
+		return nil
+	}
+	if err := storage.ValidStorageClass(class); err != nil {
+		return usageError("--storage-class: %v", err)
+	}
+	return nil
+}
+
+// retentionStorageClass is the class of state backups and snapshots. An archive class is refused, a state is needed at once when it is needed at all
+
+func retentionStorageClass(s settings) (string, error) {
+	class := s.Retention.StorageClass
+	if class == "" {
+		return "", nil
+	}
+	if err := storage.ValidStorageClass(class); err != nil {
+		return "", configError("retention.storage_class: %v", err)
+	}
+	if storage.NeedsRestore(class) {
+		return "", configError("retention.storage_class can't be %s, a state in it takes hours to restore, use GLACIER_IR for instant retrieval at archive prices", class)
+	}
+	return class, nil
+}
+
+// restoreOptions are the flags of the downloads that can restore an archived object
+
+type restoreOptions struct {
+	restore, wait bool
+	tier          string
+	days          int
+}
+
+func addRestoreFlags(fs *flag.FlagSet) *restoreOptions {
+	opts := &restoreOptions{}
+	fs.BoolVar(&opts.restore, "restore", false, "restore an object archived in GLACIER or DEEP_ARCHIVE without asking")
+	fs.BoolVar(&opts.wait, "wait", false, "wait for the restore to finish and download then, instead of exiting")
+	fs.StringVar(&opts.tier, "restore-tier", defaultRestoreTier, "how fast the restore is: "+strings.Join(storage.RestoreTiers, ", ")+", faster costs more")
+	fs.IntVar(&opts.days, "restore-days", defaultRestoreDays, "how many days the restored copy can be read for")
+	return opts
+}
+
+func (o restoreOptions) check() error {
+	if !slices.Contains(storage.RestoreTiers, o.tier) {
+		return usageError("--restore-tier %q isn't one of %s", o.tier, strings.Join(storage.RestoreTiers, ", "))
+	}
+	if o.days < 1 {
+		return usageError("--restore-days has to be at least 1")
+	}
+	return nil
+}
+
+// restoreTime is how long S3 says a restore takes
+
+func restoreTime(class, tier string) string {
+	if class == "DEEP_ARCHIVE" {
+		if tier == "Bulk" {
+			return "up to 48 hours"
+		}
+		return "up to 12 hours"
+	}
+	switch tier {
+	case "Expedited":
+		return "1-5 minutes"
+	case "Bulk":
+		return "5-12 hours"
+	}
+	return "3-5 hours"
+}
+
+// restoreArchived deals with a download that found its object archived. It restores the object when asked to, or when the answer to the question is yes, and with --wait polls until the restored copy can be read. nil means the download can be tried again, anything else says when to come back
+
+func (a *app) restoreArchived(ctx context.Context, store storage.Backend, url, key string, opts restoreOptions, archived error) error {
+	restorer, ok := store.(storage.Restorer)
+	if !ok {
+		return archived
+	}
+	info, err := store.Head(ctx, key)
+	if err != nil {
+		return err
+	}
+	class := info.StorageClass
+	if info.Restore.Restored() {
+		// the restore finished between the download and now
+		return nil
+	}
+	if !info.Restore.InProgress {
+		restore := opts.restore
+		if !restore {
+			question := fmt.Sprintf("%s is archived in %s. Restore it for %d day(s) with the %s tier, which takes %s?", url, class, opts.days, opts.tier, restoreTime(class, opts.tier))
+			if restore, err = a.askYesNo(question); err != nil {
+				return err
+			}
+		}
+		if !restore {
+			return withCode(exitTransfer, fmt.Errorf("%s is archived in %s, pass --restore to restore it, then download it again", url, class))
+		}
+		err := restorer.Restore(ctx, storage.RestoreInput{Key: key, Days: opts.days, Tier: opts.tier})
+		if err != nil && !errors.Is(err, storage.ErrRestoreInProgress) {
+			return err
+		}
+		a.out.Event("restore", map[string]any{"url": url, "key": key, "storage_class": class, "tier": opts.tier, "days": opts.days})
+		a.out.Printf("Restoring %s from %s with the %s tier, which takes %s\n", url, class, opts.tier, restoreTime(class, opts.tier))
+	} else {
+		a.out.Printf("%s is being restored already\n", url)
+	}
+	if !opts.wait {
+		return withCode(exitTransfer, fmt.Errorf("%s is being restored from %s, download it again once tfmanage list shows it restored, or pass --wait", url, class))
+	}
+
+	started := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(restorePollInterval):
+		}
+		info, err := store.Head(ctx, key)
+		if err != nil {
+			return err
+		}
+		if info.Restore.Restored() {
+			a.out.Successf("Restored %s, it can be read until %s", url, info.Restore.Expiry.Format(time.RFC3339))
+			return nil
+		}
+		a.out.Verbosef("Still restoring %s after %s\n", url, time.Since(started).Round(time.Second))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+)
+
+func TestUploadStorageClass(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	os.WriteFile("dev.tfvars", []byte("a = 1\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	if err := run([]string{"upload", "dev", "--storage-class", "REDUCED"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("upload with an unknown storage class: %v, want a usage error", err)
+	}
+	if err := run([]string{"upload", "dev", "--storage-class", "STANDARD_IA"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if info, err := store.Head(context.Background(), "team/dev.tfvars"); err != nil || info.StorageClass != "STANDARD_IA" {
+		t.Errorf("uploaded tfvars = %+v, %v, want STANDARD_IA", info, err)
+	}
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"list", "dev"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "STORAGE CLASS") || !strings.Contains(stdout.String(), "STANDARD_IA") {
+		t.Errorf("list doesn't show the storage class:\n%s", stdout.String())
+	}
+}
+
+func TestDownloadRestoresArchivedTFVars(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	store.RestorePolls = 4
+	swap(t, &restorePollInterval, time.Millisecond)
+	os.WriteFile("dev.tfvars", []byte("a = 1\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	if err := run([]string{"upload", "dev", "--storage-class", "DEEP_ARCHIVE"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	os.Remove("dev.tfvars")
+
+	err := run([]string{"download", "dev"})
+	if exitCodeFor(err) != exitTransfer || !strings.Contains(err.Error(), "--restore") {
+		t.Fatalf("download of an archived file: %v, want exit code %d saying how to restore it", err, exitTransfer)
+	}
+	if len(store.Restores) != 0 {
+		t.Fatal("download restored without being asked to")
+	}
+	if err := run([]string{"download", "dev", "--restore-tier", "Fast"}); exitCodeFor(err) != exitUsage {
+		t.Errorf("download with an unknown tier: %v, want a usage error", err)
+	}
+
+	err = run([]string{"download", "dev", "--restore", "--restore-tier", "Bulk", "--restore-days", "3"})
+	if exitCodeFor(err) != exitTransfer || !strings.Contains(err.Error(), "--wait") {
+		t.Fatalf("download --restore: %v, want exit code %d saying to come back", err, exitTransfer)
+	}
+	if len(store.Restores) != 1 || store.Restores[0].Tier != "Bulk" || store.Restores[0].Days != 3 {
+		t.Errorf("restores = %+v, want one Bulk restore for 3 days", store.Restores)
+	}
+
+	if err := run([]string{"download", "dev", "--wait"}); err != nil {
+		t.Fatalf("download --wait: %v", err)
+	}
+	if data, _ := os.ReadFile("dev.tfvars"); string(data) != "a = 1\n" {
+		t.Errorf("dev.tfvars = %q", data)
+	}
+	if len(store.Restores) != 1 {
+		t.Errorf("download --wait started another restore: %+v", store.Restores)
+	}
+}
+
+func TestRetentionStorageClass(t *testing.T) {
+	for class, ok := range map[string]bool{"": true, "GLACIER_IR": true, "STANDARD_IA": true, "DEEP_ARCHIVE": false, "GLACIER": false, "COLD": false} {
+		_, err := retentionStorageClass(settings{Retention: config.Retention{StorageClass: class}})
+		if (err == nil) != ok {
+			t.Errorf("retentionStorageClass(%q) = %v", class, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/dirs"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/linediff"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// sync state - what the local tfvars were last downloaded from or uploaded to, kept in the state directory for each environment and local file. upload uses it as the base the remote object must still be at
+
+type syncState struct {
+	Environment string `json:"environment"`
+	File        string `json:"file"`
+	// Location is where the base came from, a base from another location is no base
+	Location  string    `json:"location"`
+	ETag      string    `json:"etag"`
+	VersionID string    `json:"version_id,omitempty"`
+	SHA256    string    `json:"sha256"`
+	At        time.Time `json:"at"`
+}
+
+// syncStatePath is named after the absolute path of the local file so two checkouts of the same environment have a base each
+
+func syncStatePath(environment, fileName string) (string, string, error) {
+	abs, err := filepath.Abs(fileName)
+	if err != nil {
+		return "", "", err
+	}
+	dir, err := dirs.StateDir()
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, "sync", environment, hex.EncodeToString(sum[:8])+".json"), abs, nil
+}
+
+// readSyncState is the base of the local file, ok is false when it was never downloaded or uploaded from here, or only from another location
+
+func readSyncState(environment, fileName, location string) (syncState, bool, error) {
+	path, _, err := syncStatePath(environment, fileName)
+	if err != nil {
+		return syncState{}, false, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return syncState{}, false, nil
+	}
+	if err != nil {
+		return syncState{}, false, err
+	}
+	var state syncState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return syncState{}, false, err
+	}
+	return state, state.Location == location && state.ETag != "", nil
+}
+
+func writeSyncState(state syncState) error {
+	path, abs, err := syncStatePath(state.Environment, state.File)
+	if err != nil {
+		return err
+	}
+	state.File = abs
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// recordSync makes what was just transferred the local file's base, a failure only costs the check on the next upload
+
+func (a *app) recordSync(environment, fileName, location, etag, versionID, checksum string) {
+	if etag == "" {
+		return
+	}
+	state := syncState{Environment: environment, File: fileName, Location: location, ETag: etag, VersionID: versionID, SHA256: checksum, At: time.Now().UTC()}
+	if err := writeSyncState(state); err != nil {
+		a.out.Warnf("Could not record the base revision of %s, the next upload can't check the remote is unchanged: %v", fileName, err)
+		return
+	}
+	a.out.Verbosef("Recorded %s (ETag %s) as the base of %s\n", location, etag, fileName)
+}
+
+// quoteETag takes an ETag as S3 prints it, with or without its quotes
+
+func quoteETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// downloadOptions are the flags of download that decide whether it may replace a local file with edits in it
+
+type downloadOptions struct {
+	force, backup, ci bool
+	restore           restoreOptions
+}
+
+// maxOverwriteDiff is how many lines of the diff a refused download shows
+
+const maxOverwriteDiff = 20
+
+// checkOverwrite refuses to download over local edits. A file downloaded or uploaded by tfmanage is edited when its checksum isn't the recorded one. For a file it never synced the timestamp decides: one older than the remote is an old copy, a newer one may hold edits and is only replaced when someone at a terminal says so. --force skips the check for one run, there is no way to turn it off for good
+
+func (a *app) checkOverwrite(ctx context.Context, loc tfvarsLocation, environment, fileName string, opts downloadOptions) error {
+	if opts.force {
+		return nil
+	}
+	local, err := os.ReadFile(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(local)
+	checksum := hex.EncodeToString(sum[:])
+	location := loc.url(loc.key)
+	state, tracked, err := readSyncState(environment, fileName, location)
+	if err != nil {
+		a.out.Warnf("Could not read the base revision of %s, it is treated as a file tfmanage never downloaded: %v", fileName, err)
+	}
+	if tracked && state.SHA256 == checksum {
+		return nil
+	}
+	// a missing remote is left for the download to report
+	remote, err := loc.store.Head(ctx, loc.key)
+	if err != nil || remote.Metadata[storage.ChecksumMetadataKey] == checksum {
+		return nil
+	}
+	if !tracked {
+		info, err := os.Stat(fileName)
+		if err == nil && !remote.LastModified.IsZero() && !info.ModTime().After(remote.LastModified) {
+			a.out.Verbosef("%s was never downloaded by tfmanage and is older than %s, replacing it\n", fileName, location)
+			return nil
+		}
+	}
+
+	if opts.backup {
+		backup := fmt.Sprintf("%s.%s.backup", fileName, time.Now().UTC().Format("20060102T150405Z"))
+		if err := os.WriteFile(backup, local, 0o600); err != nil {
+			return fmt.Errorf("backing up %s: %w", fileName, err)
+		}
+		a.out.Event("download-backup", map[string]any{"environment": environment, "file": fileName, "backup": backup})
+		a.out.Printf("Kept the local %s as %s\n", fileName, backup)
+		return nil
+	}
+
+	if remoteData, err := storage.GetBytes(ctx, loc.store, loc.key); err == nil {
+		diff := strings.Split(strings.TrimSuffix(redactDiff(linediff.Unified(fileName, location, local, remoteData, 1), local, remoteData), "\n"), "\n")
+		for i, line := range diff {
+			if i == maxOverwriteDiff {
+				a.out.Printf("... %d more lines\n", len(diff)-i)
+				break
+			}
+			a.out.DiffLine(line)
+		}
+	}
+	what := "has local changes since it was last downloaded"
+	if !tracked {
+		what = "was not downloaded by tfmanage and is newer than the remote file"
+		if !opts.ci {
+			overwrite, err := a.askYesNo(fmt.Sprintf("%s %s. Replace it with %s?", fileName, what, location))
+			if err != nil || overwrite {
+				return err
+			}
+		}
+	}
+	a.out.Event("download-refused", map[string]any{"environment": environment, "file": fileName, "tracked": tracked})
+	return withCode(exitCheck, fmt.Errorf("%s %s, not replacing it - pass --force to replace it, --backup to keep a copy first, or run merge-remote %s to merge the remote changes into it", fileName, what, environment))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+func TestUploadChecksBase(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	ctx := context.Background()
+
+	os.WriteFile("dev.tfvars", []byte("replicas = 1\n"), 0o644)
+	if err := run([]string{"upload", "dev"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	state, ok, err := readSyncState("dev", "dev.tfvars", "s3://tfvars-bucket/team/dev.tfvars")
+	if err != nil || !ok || state.VersionID != "v1" {
+		t.Fatalf("sync state = %+v, %v, %v, want the upload as the base", state, ok, err)
+	}
+
+	// someone else uploads in between
+	if _, err := storage.PutBytes(ctx, store, "team/dev.tfvars", []byte("replicas = 5\n")); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile("dev.tfvars", []byte("replicas = 2\n"), 0o644)
+	err = run([]string{"upload", "dev"})
+	if exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "remote has changed since you last downloaded it") || !strings.Contains(hintFor(err), "download") {
+		t.Fatalf("upload over someone else's upload: %v, want a refusal", err)
+	}
+	if data, _ := store.Bytes("team/dev.tfvars"); string(data) != "replicas = 5\n" {
+		t.Fatalf("the refused upload replaced the remote file with %q", data)
+	}
+	if err := run([]string{"upload", "dev", "--base-etag", "0123456789abcdef"}); exitCodeFor(err) != exitCheck {
+		t.Errorf("upload --base-etag of another version: %v, want a refusal", err)
+	}
+
+	// after a download their upload is the base, --force drops the edit the upload was refused for
+	if err := run([]string{"download", "dev", "--force"}); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	os.WriteFile("dev.tfvars", []byte("replicas = 6\n"), 0o644)
+	if err := run([]string{"upload", "dev"}); err != nil {
+		t.Fatalf("upload after download: %v", err)
+	}
+
+	storage.PutBytes(ctx, store, "team/dev.tfvars", []byte("replicas = 7\n"))
+	os.WriteFile("dev.tfvars", []byte("replicas = 8\n"), 0o644)
+	if err := run([]string{"upload", "dev", "--force"}); err != nil {
+		t.Fatalf("upload --force: %v", err)
+	}
+	if data, _ := store.Bytes("team/dev.tfvars"); string(data) != "replicas = 8\n" {
+		t.Errorf("upload --force left %q", data)
+	}
+}
+
+func TestQuoteETag(t *testing.T) {
+	for etag, want := range map[string]string{
+		"":        "",
+		"abc":     `"abc"`,
+		`"abc"`:   `"abc"`,
+		`W/"abc"`: `W/"abc"`,
+		"abc-2":   `"abc-2"`,
+		`"abc-2"`: `"abc-2"`,
+	} {
+		if got := quoteETag(etag); got != want {
+			t.Errorf("quoteETag(%q) = %q, want %q", etag, got, want)
+		}
+	}
+}
+
+func TestDownloadKeepsLocalChanges(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	storage.PutBytes(context.Background(), store, "team/dev.tfvars", []byte("replicas = 2\n"))
+
+	if err := run([]string{"download", "dev"}); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	os.WriteFile("dev.tfvars", []byte("replicas = 3\n"), 0o644)
+
+	var stdout bytes.Buffer
+	err := runWithUI([]string{"download", "dev"}, &ui{stdout: &stdout, stderr: io.Discard})
+	if exitCodeFor(err) != exitCheck || !strings.Contains(err.Error(), "--backup") {
+		t.Fatalf("download over local changes: %v, want a refusal", err)
+	}
+	if !strings.Contains(stdout.String(), "-replicas = 3\n+replicas = 2\n") {
+		t.Errorf("the refusal printed:\n%s\nwant a diff", stdout.String())
+	}
+	if data, _ := os.ReadFile("dev.tfvars"); string(data) != "replicas = 3\n" {
+		t.Fatalf("the refused download replaced the local file with %q", data)
+	}
+
+	if err := run([]string{"download", "dev", "--backup"}); err != nil {
+		t.Fatalf("download --backup: %v", err)
+	}
+	backups, _ := filepath.Glob("dev.tfvars.*.backup")
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want one", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "replicas = 3\n" {
+		t.Errorf("backup = %q", data)
+	}
+	if data, _ := os.ReadFile("dev.tfvars"); string(data) != "replicas = 2\n" {
+		t.Errorf("dev.tfvars = %q after download --backup", data)
+	}
+	// downloaded and unchanged, so it can be replaced
+	if err := run([]string{"download", "dev"}); err != nil {
+		t.Errorf("download of an unchanged file: %v", err)
+	}
+}
+
+func TestDownloadUntrackedFile(t *testing.T) {
+	inTempDir(t)
+	stateDir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateDir)
+	store := withMemoryStore(t)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	storage.PutBytes(context.Background(), store, "team/dev.tfvars", []byte("replicas = 2\n"))
+
+	// older than the remote, an old copy
+	os.WriteFile("dev.tfvars", []byte("replicas = 1\n"), 0o644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes("dev.tfvars", old, old)
+	if err := run([]string{"download", "dev"}); err != nil {
+		t.Fatalf("download over an old copy: %v", err)
+	}
+
+	newer := func() {
+		os.WriteFile("dev.tfvars", []byte("replicas = 5\n"), 0o644)
+		os.Chtimes("dev.tfvars", time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+		os.RemoveAll(filepath.Join(stateDir, "tfmanage", "sync"))
+	}
+	newer()
+	if err := runWithUI([]string{"download", "dev", "--ci"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader("y\n")}); exitCodeFor(err) != exitCheck {
+		t.Errorf("download --ci over a newer file: %v, want a refusal without asking", err)
+	}
+	if err := runWithUI([]string{"download", "dev"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader("n\n")}); exitCodeFor(err) != exitCheck {
+		t.Errorf("download answered no: %v, want a refusal", err)
+	}
+	if err := runWithUI([]string{"download", "dev"}, &ui{stdout: io.Discard, stderr: io.Discard, stdin: strings.NewReader("y\n")}); err != nil {
+		t.Errorf("download answered yes: %v", err)
+	}
+	if data, _ := os.ReadFile("dev.tfvars"); string(data) != "replicas = 2\n" {
+		t.Errorf("dev.tfvars = %q", data)
+	}
+}
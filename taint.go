@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// taint and untaint - with --use-replace nothing touches the state, the address is written down and the next plan or apply passes it as -replace
+
+// replaceSince is the first terraform with -replace, taint has been deprecated since
+
+var replaceSince = tfexec.Version{Major: 0, Minor: 15, Patch: 2}
+
+func taintCommand() *command {
+	return &command{
+		name:    "taint",
+		args:    "<env> <address>",
+		summary: "Mark a resource to be replaced on the next apply.",
+		examples: []string{
+			"tfmanage taint dev aws_instance.web",
+			"tfmanage taint prod aws_instance.web --use-replace",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			useReplace := fs.Bool("use-replace", false, "don't taint, pass -replace for the address on the next plan and apply instead")
+			yes := fs.Bool("yes", false, "don't ask for the environment name on protected environments")
+			return func(ctx context.Context, a *app, args []string) error {
+				env, address := args[0], strings.TrimSpace(args[1])
+				if err := a.checkEnvironment(env); err != nil {
+					return err
+				}
+				if address == "" {
+					return usageError("taint needs a resource address")
+				}
+				if err := a.lockEnvironment(env, "taint"); err != nil {
+					return err
+				}
+				dir := a.useEnvironment(env, *chdir)
+				if *useReplace {
+					return recordReplacement(ctx, a, env, dir, address)
+				}
+				if err := a.useTerraformCredentials(ctx, env); err != nil {
+					return err
+				}
+				if err := a.confirm(env, "taint", *yes); err != nil {
+					return err
+				}
+				a.out.Verbosef("Running terraform %v\n", tfexec.TaintArgs(dir, address))
+				if err := tfexec.Taint(ctx, runner, dir, address, a.streamOutput()); err != nil {
+					return err
+				}
+				a.out.Event("taint", map[string]any{"environment": env, "address": address})
+				return nil
+			}
+		},
+	}
+}
+
+func untaintCommand() *command {
+	return &command{
+		name:    "untaint",
+		args:    "<env> <address>",
+		summary: "Remove the taint from a resource, or forget a --use-replace address.",
+		examples: []string{
+			"tfmanage untaint dev aws_instance.web",
+			"tfmanage untaint prod aws_instance.web --use-replace",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			useReplace := fs.Bool("use-replace", false, "forget an address recorded with taint --use-replace instead of running terraform untaint")
+			return func(ctx context.Context, a *app, args []string) error {
+				env, address := args[0], strings.TrimSpace(args[1])
+				if err := a.checkEnvironment(env); err != nil {
+					return err
+				}
+				if address == "" {
+					return usageError("untaint needs a resource address")
+				}
+				if err := a.lockEnvironment(env, "untaint"); err != nil {
+					return err
+				}
+				dir := a.useEnvironment(env, *chdir)
+				if *useReplace {
+					return forgetReplacement(a, env, dir, address)
+				}
+				if err := a.useTerraformCredentials(ctx, env); err != nil {
+					return err
+				}
+				a.out.Verbosef("Running terraform %v\n", tfexec.UntaintArgs(dir, address))
+				if err := tfexec.Untaint(ctx, runner, dir, address, a.streamOutput()); err != nil {
+					return err
+				}
+				a.out.Event("untaint", map[string]any{"environment": env, "address": address})
+				return nil
+			}
+		},
+	}
+}
+
+// streamOutput is terraformOutput with stdout going where the human messages go, for commands whose output is the answer
+
+func (a *app) streamOutput() tfexec.RunOptions {
+	run := a.terraformOutput()
+	if run.Stdout == nil {
+		run.Stdout = a.out.humanOut()
+	}
+	return run
+}
+
+// replacementsFile is kept under .terraform so it goes away with the rest of the local terraform data and is never committed
+
+func replacementsFile(environment, chdir string) string {
+	return filepath.Join(chdir, ".terraform", "tfmanage-replace", environment)
+}
+
+// pendingReplacements gives back the addresses recorded for the environment, one per line in its file
+
+func pendingReplacements(environment, chdir string) ([]string, error) {
+	f, err := os.Open(replacementsFile(environment, chdir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the recorded replacements: %w", err)
+	}
+	defer f.Close()
+
+	var addresses []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			addresses = append(addresses, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the recorded replacements: %w", err)
+	}
+	return addresses, nil
+}
+
+func writeReplacements(environment, chdir string, addresses []string) error {
+	file := replacementsFile(environment, chdir)
+	if len(addresses) == 0 {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to clear the recorded replacements: %w", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("failed to record the replacement: %w", err)
+	}
+	if err := os.WriteFile(file, []byte(strings.Join(addresses, "\n")+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to record the replacement: %w", err)
+	}
+	return nil
+}
+
+// recordReplacement checks terraform has -replace and writes the address down for the next plan and apply
+
+func recordReplacement(ctx context.Context, a *app, environment, chdir, address string) error {
+	if err := requireTerraform(ctx, a, "-replace", replaceSince); err != nil {
+		return err
+	}
+	addresses, err := pendingReplacements(environment, chdir)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(addresses, address) {
+		if err := writeReplacements(environment, chdir, append(addresses, address)); err != nil {
+			return err
+		}
+	}
+	a.out.Event("taint", map[string]any{"environment": environment, "address": address, "replace": true})
+	a.out.Successf("%s will be replaced on the next %s plan and apply", address, environment)
+	return nil
+}
+
+func forgetReplacement(a *app, environment, chdir, address string) error {
+	addresses, err := pendingReplacements(environment, chdir)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(addresses, address)
+	if i < 0 {
+		return usageError("%s is not recorded for replacement in %s", address, environment)
+	}
+	if err := writeReplacements(environment, chdir, slices.Delete(addresses, i, i+1)); err != nil {
+		return err
+	}
+	a.out.Event("untaint", map[string]any{"environment": environment, "address": address, "replace": true})
+	a.out.Successf("%s will no longer be replaced", address)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestTaint(t *testing.T) {
+	rec := &tfexec.RecordingRunner{Output: "Resource instance aws_instance.web has been marked as tainted.\n"}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    chdir: infra\n    workspace: production\n"), 0o644)
+
+	var stdout bytes.Buffer
+	out := &ui{stdout: &stdout, stderr: io.Discard, stdin: strings.NewReader("prod\n")}
+	if err := runWithUI([]string{"taint", "prod", "aws_instance.web"}, out); err != nil {
+		t.Fatalf("taint: %v", err)
+	}
+	call := rec.Calls[0]
+	if want := []string{"-chdir=infra", "taint", "aws_instance.web"}; !slices.Equal(call.Args, want) || !slices.Contains(call.Opts.Env, "TF_WORKSPACE=production") {
+		t.Errorf("taint ran %q with %q", call.Args, call.Opts.Env)
+	}
+	if !strings.Contains(stdout.String(), "marked as tainted") {
+		t.Errorf("terraform's output was not shown: %q", stdout.String())
+	}
+
+	// untaint has no gate, and an empty address never gets to terraform
+	if err := runWithUI([]string{"untaint", "prod", "aws_instance.web"}, &ui{stdout: io.Discard, stderr: io.Discard}); err != nil {
+		t.Fatalf("untaint: %v", err)
+	}
+	if err := run([]string{"taint", "dev", " "}); exitCodeFor(err) != exitUsage {
+		t.Errorf("empty address: %v", err)
+	}
+	if calls := rec.Args(); len(calls) != 2 || calls[1][1] != "untaint" {
+		t.Errorf("terraform calls = %q", calls)
+	}
+}
+
+func TestTaintUseReplace(t *testing.T) {
+	rec := &tfexec.RecordingRunner{OutputFor: func(args []string) string {
+		if args[0] == "version" {
+			return `{"terraform_version":"1.6.2"}`
+		}
+		return ""
+	}}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("dev.tfvars", nil, 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	for _, address := range []string{"aws_instance.web", "aws_instance.db", "aws_instance.web"} {
+		if err := run([]string{"taint", "dev", address, "--use-replace"}); err != nil {
+			t.Fatalf("taint --use-replace: %v", err)
+		}
+	}
+	if got, _ := pendingReplacements("dev", ""); !slices.Equal(got, []string{"aws_instance.web", "aws_instance.db"}) {
+		t.Fatalf("recorded = %q", got)
+	}
+	if err := run([]string{"untaint", "dev", "aws_instance.db", "--use-replace"}); err != nil {
+		t.Fatalf("untaint --use-replace: %v", err)
+	}
+
+	rec.Calls = nil
+	if err := run([]string{"plan", "dev", "plan.out"}); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if err := run([]string{"apply", "dev"}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	for _, call := range rec.Args() {
+		if call[0] == "version" {
+			continue
+		}
+		if !slices.Contains(call, "-replace=aws_instance.web") || slices.Contains(call, "-replace=aws_instance.db") {
+			t.Errorf("%s ran with %q", call[0], call)
+		}
+	}
+	if got, _ := pendingReplacements("dev", ""); len(got) != 0 {
+		t.Errorf("replacements left after apply: %q", got)
+	}
+
+	rec.OutputFor = func([]string) string { return `{"terraform_version":"0.14.11"}` }
+	if err := run([]string{"taint", "dev", "aws_instance.web", "--use-replace"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("old terraform: %v, want a config error", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+)
+
+// terraform_env - variables for the terraform process, such as TF_LOG or a proxy, come from the config and --tf-env instead of leaking in from whatever shell started the tool
+
+var (
+	envVarName   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	envReference = regexp.MustCompile(`\$\{([^}]*)\}`)
+)
+
+// checkTerraformVar says what is wrong with a variable name, credentials can only be set when they are allowed so the identity terraform runs as is never changed by accident
+
+func checkTerraformVar(name string, allowCredentials bool) error {
+	if !envVarName.MatchString(name) {
+		return fmt.Errorf("%q is not a valid environment variable name", name)
+	}
+	if !allowCredentials && slices.Contains(awsconfig.CredentialVars, strings.ToUpper(name)) {
+		return fmt.Errorf("%s sets the AWS credentials terraform runs with, pass --allow-credential-env to set it anyway", name)
+	}
+	return nil
+}
+
+// parseTFEnv checks the --tf-env flags
+
+func parseTFEnv(flags []string, allowCredentials bool) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, f := range flags {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, usageError("invalid --tf-env %q, use KEY=VALUE", f)
+		}
+		if err := checkTerraformVar(name, allowCredentials); err != nil {
+			return nil, usageError("invalid --tf-env: %v", err)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// checkTerraformEnvConfig checks the terraform_env of the config and of every environment
+
+func checkTerraformEnvConfig(s settings, allowCredentials bool) error {
+	for _, name := range slices.Sorted(maps.Keys(s.TerraformEnv)) {
+		if err := checkTerraformVar(name, allowCredentials); err != nil {
+			return configError("terraform_env: %v", err)
+		}
+	}
+	for _, env := range slices.Sorted(maps.Keys(s.Terraform)) {
+		for _, name := range slices.Sorted(maps.Keys(s.Terraform[env].TerraformEnv)) {
+			if err := checkTerraformVar(name, allowCredentials); err != nil {
+				return configError("terraform_env of %s: %v", env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnv replaces ${NAME} with the variable from the tool's environment, a $ on its own is left alone
+
+func expandEnv(value string) string {
+	return envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+// useTerraformVars works out the variables terraform gets for the environment: the global terraform_env, then the environment's, then --proxy-url's proxy variables and --ca-bundle, then --tf-env. Only the names are logged, the values can be secrets
+
+func (a *app) useTerraformVars(environment string) {
+	vars := map[string]string{}
+	if s, err := a.loadSettings(); err == nil {
+		maps.Copy(vars, s.TerraformEnv)
+		maps.Copy(vars, s.Terraform[environment].TerraformEnv)
+	}
+	if a.global.proxyURL != "" {
+		maps.Copy(vars, awsconfig.ProxyEnv(a.global.proxyURL))
+	}
+	if a.global.caBundle != "" {
+		// terraform runs in the environment's chdir
+		bundle, _ := filepath.Abs(a.global.caBundle)
+		vars["AWS_CA_BUNDLE"] = bundle
+	}
+	maps.Copy(vars, a.global.tfEnvVars)
+
+	names := slices.Sorted(maps.Keys(vars))
+	a.terraformVars = make([]string, 0, len(names))
+	for _, name := range names {
+		a.terraformVars = append(a.terraformVars, name+"="+expandEnv(vars[name]))
+	}
+	if len(names) > 0 {
+		a.out.Verbosef("Adding %s to terraform's environment\n", strings.Join(names, ", "))
+	}
+}
+
+// terraformEnv is what every terraform run gets on top of the tool's own environment
+
+func (a *app) terraformEnv() []string {
+	if a.terraformVars == nil {
+		a.useTerraformVars("")
+	}
+	var env []string
+	if a.workspace != "" {
+		env = append(env, "TF_WORKSPACE="+a.workspace)
+	}
+	env = append(env, a.terraformVars...)
+	env = append(env, a.credentialVars...)
+	return append(env, a.secretVars...)
+}
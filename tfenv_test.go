@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestTerraformEnv(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	t.Setenv("CORP_PROXY", "http://proxy.internal:3128")
+	os.WriteFile("tfmanage.yaml", []byte(`terraform_env:
+  TF_LOG: info
+  HTTPS_PROXY: ${CORP_PROXY}
+  TF_PLUGIN_CACHE_DIR: /cache/$HOME
+environments:
+  prod:
+    workspace: production
+    terraform_env:
+      TF_LOG: warn
+`), 0o644)
+
+	var stderr bytes.Buffer
+	err := runWithUI([]string{"--verbose", "untaint", "prod", "aws_instance.web", "--tf-env", "TF_LOG=debug", "--tf-env", "NO_PROXY=.internal"}, &ui{stdout: io.Discard, stderr: &stderr})
+	if err != nil {
+		t.Fatalf("untaint: %v", err)
+	}
+	want := []string{"TF_WORKSPACE=production", "HTTPS_PROXY=http://proxy.internal:3128", "NO_PROXY=.internal", "TF_LOG=debug", "TF_PLUGIN_CACHE_DIR=/cache/$HOME"}
+	if got := rec.Calls[0].Opts.Env; !slices.Equal(got, want) {
+		t.Errorf("terraform env = %q, want %q", got, want)
+	}
+	if !strings.Contains(stderr.String(), "Adding HTTPS_PROXY, NO_PROXY, TF_LOG, TF_PLUGIN_CACHE_DIR to terraform's environment") || strings.Contains(stderr.String(), "proxy.internal:3128") {
+		t.Errorf("verbose output should name the variables without their values: %q", stderr.String())
+	}
+
+	// the other environments only get the global ones
+	if err := runWithUI([]string{"untaint", "dev", "aws_instance.web"}, &ui{stdout: io.Discard, stderr: io.Discard}); err != nil {
+		t.Fatalf("untaint dev: %v", err)
+	}
+	if got := rec.Calls[1].Opts.Env; !slices.Contains(got, "TF_LOG=info") || slices.Contains(got, "TF_WORKSPACE=production") {
+		t.Errorf("dev terraform env = %q", got)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"untaint", "dev", "aws_instance.web", "--tf-env", "TF_LOG"}, exitUsage},
+		{[]string{"untaint", "dev", "aws_instance.web", "--tf-env", "1BAD=x"}, exitUsage},
+		{[]string{"untaint", "dev", "aws_instance.web", "--tf-env", "AWS_PROFILE=admin"}, exitUsage},
+	} {
+		if err := runWithUI(tc.args, &ui{stdout: io.Discard, stderr: io.Discard}); exitCodeFor(err) != tc.code {
+			t.Errorf("%q: exit code %d (%v), want %d", tc.args, exitCodeFor(err), err, tc.code)
+		}
+	}
+	if err := runWithUI([]string{"--allow-credential-env", "untaint", "dev", "aws_instance.web", "--tf-env", "AWS_PROFILE=admin"}, &ui{stdout: io.Discard, stderr: io.Discard}); err != nil {
+		t.Errorf("--allow-credential-env: %v", err)
+	}
+	if got := rec.Calls[len(rec.Calls)-1].Opts.Env; !slices.Contains(got, "AWS_PROFILE=admin") {
+		t.Errorf("terraform env with --allow-credential-env = %q", got)
+	}
+
+	// credentials in the config are refused the same way
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    terraform_env:\n      AWS_SECRET_ACCESS_KEY: x\n"), 0o644)
+	calls := len(rec.Calls)
+	if err := runWithUI([]string{"untaint", "prod", "aws_instance.web"}, &ui{stdout: io.Discard, stderr: io.Discard}); exitCodeFor(err) != exitConfig || !strings.Contains(err.Error(), "terraform_env of prod") {
+		t.Errorf("credentials in the config: %v", err)
+	}
+	if len(rec.Calls) != calls {
+		t.Errorf("terraform ran with credentials from the config: %q", rec.Args())
+	}
+}
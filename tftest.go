@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// test - terraform test with the environment's tfvars, workspace and secret variables. Test runs make their own throwaway state, so like console nothing is locked and prod isn't confirmed
+
+func testCommand() *command {
+	return &command{
+		name:    "test",
+		args:    "<env>",
+		summary: "Run terraform test with the environment's tfvars and variables, exiting the way terraform did.",
+		examples: []string{
+			"tfmanage test dev",
+			"tfmanage test dev --filter tests/vpc.tftest.hcl",
+			"tfmanage test prod --chdir infra --json > results.jsonl",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			var filters stringList
+			fs.Var(&filters, "filter", "only run this test file (repeatable)")
+			jsonOutput := fs.Bool("json", false, "have terraform print its test results as JSON lines, and sum them up at the end")
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			return func(ctx context.Context, a *app, args []string) error {
+				dir := a.useEnvironment(args[0], *chdir)
+				fileName, err := a.prepare("test", args[0])
+				if err != nil {
+					return err
+				}
+				if err := a.prepareTerraform(ctx, args[0]); err != nil {
+					return err
+				}
+				return terraformTest(ctx, a, args[0], tfexec.TestOptions{
+					Chdir:   dir,
+					VarFile: fileName,
+					Filters: filters,
+					JSON:    *jsonOutput,
+					NoColor: !a.out.color,
+				})
+			}
+		},
+	}
+}
+
+// terraformTest streams terraform test and exits the way it did, after the summary when there is one
+
+func terraformTest(ctx context.Context, a *app, env string, opts tfexec.TestOptions) error {
+	a.out.Verbosef("Running terraform %v\n", tfexec.TestArgs(opts))
+	summary, err := tfexec.Test(ctx, runner, opts, a.terraformOutput())
+	if opts.JSON && summary.Status != "" {
+		a.printTestSummary(env, summary)
+	}
+	var tfErr *tfexec.ErrTerraformFailed
+	if errors.As(err, &tfErr) && tfErr.ExitCode > 0 && !errors.Is(err, tfexec.ErrDockerUnavailable) {
+		return withCode(tfErr.ExitCode, err)
+	}
+	return err
+}
+
+// printTestSummary goes to stderr, so piping --json only gets terraform's lines
+
+func (a *app) printTestSummary(env string, summary tfexec.TestSummary) {
+	a.out.Event("test", map[string]any{
+		"environment": env,
+		"status":      summary.Status,
+		"passed":      summary.Passed,
+		"failed":      summary.Failed,
+		"errored":     summary.Errored,
+		"skipped":     summary.Skipped,
+		"failures":    summary.Failures,
+	})
+	counts := fmt.Sprintf("%d passed, %d failed, %d errored, %d skipped", summary.Passed, summary.Failed, summary.Errored, summary.Skipped)
+	if summary.Failed+summary.Errored == 0 {
+		fmt.Fprintf(a.out.stderr, "\nTests in %s: %s\n", env, a.out.green(counts))
+		return
+	}
+	fmt.Fprintf(a.out.stderr, "\nTests in %s: %s\n", env, a.out.red(counts))
+	if len(summary.Failures) > 0 {
+		fmt.Fprintf(a.out.stderr, "  %s\n", strings.Join(summary.Failures, "\n  "))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+const failedTests = `{"test_run":{"path":"tests/vpc.tftest.hcl","run":"cidr","progress":"complete","status":"fail"},"type":"test_run"}
+{"test_summary":{"status":"fail","passed":3,"failed":1,"errored":0,"skipped":1},"type":"test_summary"}
+`
+
+func TestTerraformTest(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    chdir: infra\n    workspace: production\n"), 0o644)
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+
+	// prod isn't confirmed, there is no terminal to ask at
+	if err := runWithUI([]string{"test", "prod", "--filter", "tests/vpc.tftest.hcl"}, &ui{stdout: io.Discard, stderr: io.Discard}); err != nil {
+		t.Fatalf("test: %v", err)
+	}
+	varFile, _ := filepath.Abs("prod.tfvars")
+	call := rec.Calls[0]
+	if want := []string{"-chdir=infra", "test", "-var-file", varFile, "-filter=tests/vpc.tftest.hcl", "-no-color"}; !slices.Equal(call.Args, want) || !slices.Contains(call.Opts.Env, "TF_WORKSPACE=production") {
+		t.Errorf("test ran %q with %q", call.Args, call.Opts.Env)
+	}
+
+	// --json sums them up on stderr and exits the way terraform did
+	rec.Output = failedTests
+	rec.Result = func([]string) error { return &tfexec.FakeExitError{Code: 1} }
+	var stdout, stderr bytes.Buffer
+	err := runWithUI([]string{"--output", "json", "test", "prod", "--json"}, &ui{stdout: &stdout, stderr: &stderr})
+	if exitCodeFor(err) != 1 {
+		t.Errorf("test exit code = %d (%v), want 1", exitCodeFor(err), err)
+	}
+	if !strings.Contains(stdout.String(), `"failures":["tests/vpc.tftest.hcl/cidr"]`) {
+		t.Errorf("no test event:\n%s", stdout.String())
+	}
+	for _, want := range []string{"Tests in prod: 3 passed, 1 failed, 0 errored, 1 skipped", "tests/vpc.tftest.hcl/cidr"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr is missing %q:\n%s", want, stderr.String())
+		}
+	}
+	if !slices.Contains(rec.Calls[1].Args, "-json") {
+		t.Errorf("test ran %q", rec.Calls[1].Args)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Where an environment's tfvars live - the bucket unless the environment's location in the config points at another bucket, SSM Parameter Store, Secrets Manager or a local directory, plan and apply never notice since they only see the downloaded file
+
+const (
+	s3Scheme             = "s3"
+	ssmScheme            = "ssm"
+	secretsManagerScheme = "secretsmanager"
+	fileScheme           = "file"
+)
+
+// tfvarsLocation is the store and key of one environment's tfvars
+
+type tfvarsLocation struct {
+	store storage.Backend
+	key   string
+	// scheme is empty for a bucket
+	scheme string
+	bucket string
+	// service and name are how the messages refer to it, S3 and the bucket or SSM and the parameter
+	service string
+	name    string
+	// kmsKey is the key the tfvars are encrypted with, empty for the service's default
+	kmsKey string
+}
+
+// url is how a key in the location is shown, s3://bucket/key, ssm:///parameter, secretsmanager://secret or file://dir/key
+
+func (l tfvarsLocation) url(key string) string {
+	switch l.scheme {
+	case "":
+		return "s3://" + l.bucket + "/" + key
+	case fileScheme:
+		return "file://" + path.Join(l.name, key)
+	}
+	return l.scheme + "://" + key
+}
+
+// tfvarsCacheName is the cache folder the environment's copies go in, the bucket or the scheme
+
+func tfvarsCacheName(s settings, environment string) string {
+	loc, err := parseLocation(s.Terraform[environment].Location)
+	switch {
+	case err != nil, loc.scheme == "":
+		return s.S3Bucket
+	case loc.scheme == s3Scheme:
+		return loc.bucket
+	}
+	return loc.scheme
+}
+
+// kmsKeyFor is the environment's KMS key - its own kms_key_arn (or the older kms_key) wins over the global one. overridden is true when a global key was set but not used
+
+func kmsKeyFor(s settings, environment string) (key string, overridden bool) {
+	key, own := kmsKeyFrom(s, environment)
+	return key, own != "" && s.KMSKeyARN != "" && s.KMSKeyARN != key
+}
+
+// kmsKeyFrom also gives the config key of the environment's own KMS key, empty when the global one is used
+
+func kmsKeyFrom(s settings, environment string) (key, configKey string) {
+	env := s.Terraform[environment]
+	if env.KMSKeyARN != "" {
+		return env.KMSKeyARN, "environments." + environment + ".kms_key_arn"
+	}
+	if env.KMSKey != "" {
+		return env.KMSKey, "environments." + environment + ".kms_key"
+	}
+	return s.KMSKeyARN, ""
+}
+
+// newSSMStore and newSecretsStore give back the stores with the environment's KMS key - variables like newStore
+
+var newSSMStore = func(ctx context.Context, s settings, kmsKey string) (storage.Backend, error) {
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewSSMStore(ssm.NewFromConfig(cfg), kmsKey), nil
+}
+
+var newSecretsStore = func(ctx context.Context, s settings, kmsKey string) (storage.Backend, error) {
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewSecretsManagerStore(storage.NewSecretsManagerClient(cfg), kmsKey), nil
+}
+
+// location is an environment's location from the config, the zero value means the bucket from the settings
+
+type location struct {
+	scheme string
+	// bucket is only set for s3
+	bucket string
+	// name is the key prefix for s3, the directory for file, and the parameter or secret name for the others
+	name string
+}
+
+func parseLocation(raw string) (location, error) {
+	if raw == "" {
+		return location{}, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return location{}, configError("location %q is not a valid URL: %v", raw, err)
+	}
+	switch u.Scheme {
+	case s3Scheme:
+		if u.Host == "" {
+			return location{}, configError("location %q must look like s3://bucket/prefix/", raw)
+		}
+		return location{scheme: s3Scheme, bucket: u.Host, name: strings.TrimPrefix(u.Path, "/")}, nil
+	case ssmScheme:
+		if u.Host != "" || len(u.Path) < 2 || strings.HasSuffix(u.Path, "/") {
+			return location{}, configError("location %q must look like ssm:///path/to/parameter", raw)
+		}
+		return location{scheme: ssmScheme, name: u.Path}, nil
+	case secretsManagerScheme:
+		name := u.Host + u.Path
+		if u.Host == "" || strings.HasSuffix(name, "/") {
+			return location{}, configError("location %q must look like secretsmanager://secret/name", raw)
+		}
+		return location{scheme: secretsManagerScheme, name: name}, nil
+	case fileScheme:
+		// file://tfvars is the relative directory tfvars, file:///srv/tfvars an absolute one
+		dir := u.Host + u.Path
+		if dir == "" {
+			return location{}, configError("location %q must look like file:///path/to/dir or file://relative/dir", raw)
+		}
+		return location{scheme: fileScheme, name: dir}, nil
+	}
+	return location{}, configError("location %q is not supported, use s3://bucket/prefix/, ssm:///path/to/parameter, secretsmanager://secret/name or file:///path/to/dir, or leave it out to use the bucket", raw)
+}
+
+// hasLocation is true when the environment's location says where its tfvars are, so S3_BUCKET isn't needed for them
+
+func hasLocation(s settings, environment string) bool {
+	loc, err := parseLocation(s.Terraform[environment].Location)
+	return err == nil && loc.scheme != ""
+}
+
+// isLocalLocation is true when the environment's tfvars are in a local directory, so AWS isn't needed for them
+
+func isLocalLocation(s settings, environment string) bool {
+	loc, err := parseLocation(s.Terraform[environment].Location)
+	return err == nil && loc.scheme == fileScheme
+}
+
+// tfvarsStore picks the backend from the scheme of the environment's location
+
+func tfvarsStore(ctx context.Context, s settings, environment, fileName string) (tfvarsLocation, error) {
+	loc, err := parseLocation(s.Terraform[environment].Location)
+	if err != nil {
+		return tfvarsLocation{}, err
+	}
+	if replica := s.Terraform[environment].ReplicaBucket; replica != "" && loc.scheme != "" && loc.scheme != s3Scheme {
+		return tfvarsLocation{}, configError("the replica_bucket of %s only works with a bucket, its location is %s", environment, s.Terraform[environment].Location)
+	}
+	kmsKey, _ := kmsKeyFor(s, environment)
+	var store storage.Backend
+	switch loc.scheme {
+	case ssmScheme:
+		if store, err = newSSMStore(ctx, s, kmsKey); err != nil {
+			return tfvarsLocation{}, err
+		}
+		return tfvarsLocation{store: store, key: loc.name, scheme: loc.scheme, service: "SSM", name: loc.name, kmsKey: kmsKey}, nil
+	case secretsManagerScheme:
+		if store, err = newSecretsStore(ctx, s, kmsKey); err != nil {
+			return tfvarsLocation{}, err
+		}
+		return tfvarsLocation{store: store, key: loc.name, scheme: loc.scheme, service: "Secrets Manager", name: loc.name, kmsKey: kmsKey}, nil
+	case fileScheme:
+		key := strings.TrimPrefix(filepath.ToSlash(fileName), "/")
+		return tfvarsLocation{store: storage.NewFileStore(loc.name), key: key, scheme: loc.scheme, service: "local directory", name: loc.name}, nil
+	case s3Scheme:
+		s.S3Bucket, s.S3Path = loc.bucket, loc.name
+	}
+	if store, err = newStore(ctx, s); err != nil {
+		return tfvarsLocation{}, err
+	}
+	if store, err = withReplica(ctx, s, environment, store); err != nil {
+		return tfvarsLocation{}, err
+	}
+	return tfvarsLocation{store: store, key: storage.Key(s.S3Path, fileName), bucket: s.S3Bucket, service: "S3", name: s.S3Bucket, kmsKey: kmsKey}, nil
+}
+
+// withReplica puts the environment's replica bucket, or the global one when the environment keeps its tfvars in S3_BUCKET, behind the bucket's store for reads when the bucket can't be reached. The replica has the same prefix
+
+func withReplica(ctx context.Context, s settings, environment string, primary storage.Backend) (storage.Backend, error) {
+	env := s.Terraform[environment]
+	bucket, region := env.ReplicaBucket, env.ReplicaRegion
+	if bucket == "" && env.Location == "" {
+		bucket, region = s.ReplicaBucket, s.ReplicaRegion
+	}
+	if bucket == "" {
+		return primary, nil
+	}
+	if bucket == s.S3Bucket {
+		return nil, configError("the replica_bucket of %s is its own bucket s3://%s, it has to be a copy of it", environment, bucket)
+	}
+	primaryName := "s3://" + s.S3Bucket
+	s.S3Bucket = bucket
+	if region != "" {
+		s.AWSConfig.Region = region
+	}
+	replica, err := newStore(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.ReplicaStore{Primary: primary, Replica: replica, PrimaryName: primaryName, ReplicaName: "s3://" + bucket}, nil
+}
+
+// locate is tfvarsStore with a warning the first time a read falls back to the replica bucket
+
+func (a *app) locate(ctx context.Context, s settings, environment, fileName string) (tfvarsLocation, error) {
+	loc, err := tfvarsStore(ctx, s, environment, fileName)
+	if err != nil {
+		return loc, err
+	}
+	if replica, ok := loc.store.(*storage.ReplicaStore); ok {
+		var once sync.Once
+		replica.OnFallback = func(op, key string, err error) {
+			once.Do(func() {
+				a.out.Warnf("%s can't be reached (%v), reading from the replica %s instead", replica.PrimaryName, err, replica.ReplicaName)
+			})
+			a.out.Event("replica-read", map[string]any{"environment": environment, "operation": op, "key": key, "bucket": replica.ReplicaName, "error": err.Error()})
+		}
+	}
+	return loc, nil
+}
+
+// logKMSKey says in verbose mode when the environment's own KMS key is used instead of the global one
+
+func (a *app) logKMSKey(s settings, environment string) {
+	if key, overridden := kmsKeyFor(s, environment); overridden {
+		a.out.Verbosef("Using the %s environment's KMS key %s instead of the global %s\n", environment, key, s.KMSKeyARN)
+	}
+}
+
+// kmsKeyMatches compares the configured key with the key ARN S3 reports, which is always the full key ARN
+
+func kmsKeyMatches(configured, actual string) bool {
+	return configured == actual || strings.HasSuffix(actual, ":key/"+configured)
+}
+
+// isKMSAlias is true for alias/name and alias ARNs - those can't be compared with the key an object reports without asking KMS
+
+func isKMSAlias(key string) bool {
+	return strings.HasPrefix(key, "alias/") || strings.Contains(key, ":alias/")
+}
+
+// checkObjectKey warns when an object in the bucket isn't encrypted with the environment's key
+
+func (a *app) checkObjectKey(loc tfvarsLocation, remote storage.ObjectInfo) {
+	if loc.bucket == "" || loc.kmsKey == "" || isKMSAlias(loc.kmsKey) {
+		return
+	}
+	switch {
+	case remote.KMSKeyID == "":
+		a.out.Warnf("%s isn't encrypted with KMS, the environment's key is %s - upload it again with --force to encrypt it", loc.url(remote.Key), loc.kmsKey)
+	case !kmsKeyMatches(loc.kmsKey, remote.KMSKeyID):
+		a.out.Warnf("%s is encrypted with KMS key %s, not the environment's key %s", loc.url(remote.Key), remote.KMSKeyID, loc.kmsKey)
+	}
+}
+
+// kmsDecryptError names the key when reading something encrypted was denied, a missing cross-account grant otherwise only shows up as access denied
+
+func kmsDecryptError(err error, key string) error {
+	if key == "" || !errors.Is(err, storage.ErrAccessDenied) {
+		return err
+	}
+	return fmt.Errorf("%w (it is encrypted with KMS key %s, the credentials need kms:Decrypt on it, through the key policy or a grant when the key is in another account)", err, key)
+}
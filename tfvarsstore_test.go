@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// withSSMStore puts the parameter store in memory too and records the KMS key it was asked for
+
+func withSSMStore(t *testing.T) (*storage.MemoryStore, *string) {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	var kmsKey string
+	swap(t, &newSSMStore, func(_ context.Context, _ settings, key string) (storage.Backend, error) {
+		kmsKey = key
+		return store, nil
+	})
+	return store, &kmsKey
+}
+
+func TestParseLocation(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		want location
+		bad  bool
+	}{
+		{"ssm:///tfvars/prod", location{scheme: "ssm", name: "/tfvars/prod"}, false},
+		{"ssm:///tfvars", location{scheme: "ssm", name: "/tfvars"}, false},
+		{"ssm://tfvars/prod", location{}, true},
+		{"ssm:///", location{}, true},
+		{"ssm:///tfvars/", location{}, true},
+		{"secretsmanager://tfvars/prod", location{scheme: "secretsmanager", name: "tfvars/prod"}, false},
+		{"secretsmanager://tfvars", location{scheme: "secretsmanager", name: "tfvars"}, false},
+		{"secretsmanager:///tfvars", location{}, true},
+		{"s3://other-bucket/team/", location{scheme: "s3", bucket: "other-bucket", name: "team/"}, false},
+		{"s3://other-bucket", location{scheme: "s3", bucket: "other-bucket"}, false},
+		{"s3:///team/", location{}, true},
+		{"file:///srv/tfvars", location{scheme: "file", name: "/srv/tfvars"}, false},
+		{"file://tfvars", location{scheme: "file", name: "tfvars"}, false},
+		{"file://", location{}, true},
+		{"gcs://bucket/key", location{}, true},
+		{"", location{}, false},
+	} {
+		got, err := parseLocation(tc.raw)
+		if got != tc.want || (err != nil) != tc.bad {
+			t.Errorf("parseLocation(%q) = %+v, %v", tc.raw, got, err)
+		}
+	}
+}
+
+func TestSSMLocation(t *testing.T) {
+	inTempDir(t)
+	bucket := withMemoryStore(t)
+	ssm, kmsKey := withSSMStore(t)
+	t.Setenv("S3_BUCKET", "")
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  small:\n    tfvars: small.tfvars\n    location: ssm:///tfvars/small\n    kms_key: alias/tfvars\n"), 0o644)
+	os.WriteFile("small.tfvars", []byte("name = \"small\"\n"), 0o644)
+
+	if err := run([]string{"upload", "small", "--allow-dirty"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if got, ok := ssm.Bytes("/tfvars/small"); !ok || string(got) != "name = \"small\"\n" {
+		t.Errorf("parameter = %q, %v", got, ok)
+	}
+	if *kmsKey != "alias/tfvars" {
+		t.Errorf("KMS key = %q, want the environment's kms_key", *kmsKey)
+	}
+	if bucket.Puts() != 0 {
+		t.Errorf("%d puts to the bucket, want none", bucket.Puts())
+	}
+
+	os.Remove("small.tfvars")
+	if err := run([]string{"download", "small"}); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if got, _ := os.ReadFile("small.tfvars"); string(got) != "name = \"small\"\n" {
+		t.Errorf("downloaded %q", got)
+	}
+
+	// dev still uses the bucket, which isn't set
+	os.WriteFile("dev.tfvars", []byte("x = 1\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	if err := run([]string{"upload", "dev"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("upload dev without a bucket: %v, want a config error", err)
+	}
+}
+
+func TestSecretsManagerLocation(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	secrets := storage.NewMemoryStore()
+	swap(t, &newSecretsStore, func(context.Context, settings, string) (storage.Backend, error) { return secrets, nil })
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    location: secretsmanager://tfvars/prod\n"), 0o644)
+	os.WriteFile("prod.tfvars", []byte("db_password = \"x\"\n"), 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+
+	if err := run([]string{"upload", "prod", "--allow-dirty"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if _, ok := secrets.Bytes("tfvars/prod"); !ok {
+		t.Error("upload did not write the secret")
+	}
+
+	secrets.PutErr = fmt.Errorf("%w: secret tfvars/prod would be 70000 bytes", storage.ErrTooLarge)
+	if err := run([]string{"upload", "prod", "--allow-dirty", "--force"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("upload of a file that is too large: %v, want a config error", err)
+	}
+}
+
+func TestInvalidLocation(t *testing.T) {
+	inTempDir(t)
+	withMemoryStore(t)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  small:\n    tfvars: small.tfvars\n    location: gcs://bucket/small\n"), 0o644)
+	os.WriteFile("small.tfvars", []byte("x = 1\n"), 0o644)
+	if err := run([]string{"upload", "small", "--allow-dirty"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("upload with an unsupported location: %v, want a config error", err)
+	}
+}
+
+func TestS3Location(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	t.Setenv("S3_BUCKET", "")
+	var bucket, prefix string
+	swap(t, &newStore, func(_ context.Context, s settings) (storage.Backend, error) {
+		bucket, prefix = s.S3Bucket, s.S3Path
+		return store, nil
+	})
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dev:\n    location: s3://other-bucket/dev/\n"), 0o644)
+	os.WriteFile("dev.tfvars", []byte("x = 1\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	if err := run([]string{"upload", "dev", "--allow-dirty"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if bucket != "other-bucket" || prefix != "dev/" {
+		t.Errorf("store for %s/%s, want other-bucket/dev/", bucket, prefix)
+	}
+	if _, ok := store.Bytes("dev/dev.tfvars"); !ok {
+		t.Error("upload did not use the location's prefix")
+	}
+}
+
+func TestReplicaBucket(t *testing.T) {
+	inTempDir(t)
+	primary := withMemoryStore(t)
+	replica := storage.NewMemoryStore()
+	var replicaRegion string
+	swap(t, &newStore, func(_ context.Context, s settings) (storage.Backend, error) {
+		if s.S3Bucket == "tfvars-dr" {
+			replicaRegion = s.AWSConfig.Region
+			return replica, nil
+		}
+		return primary, nil
+	})
+	os.WriteFile("tfmanage.yaml", []byte("replica_bucket: tfvars-dr\nreplica_region: us-west-2\n"), 0o644)
+	os.WriteFile("dev.tfvars", []byte("x = 1\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	if err := run([]string{"upload", "dev", "--allow-dirty"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	// replication copies it with its checksum
+	if _, err := storage.UploadKey(context.Background(), replica, "team/dev.tfvars", "dev.tfvars", storage.UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	outage := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	primary.GetErr, primary.HeadErr, primary.PutErr = outage, outage, outage
+	os.WriteFile("dev.tfvars", []byte("x = 2\n"), 0o644)
+	var out bytes.Buffer
+	if err := runWithUI([]string{"download", "dev", "--force"}, &ui{stdout: &out, stderr: io.Discard}); err != nil {
+		t.Fatalf("download in an outage: %v", err)
+	}
+	if got, _ := os.ReadFile("dev.tfvars"); string(got) != "x = 1\n" {
+		t.Errorf("downloaded %q, want the replica's copy", got)
+	}
+	if replicaRegion != "us-west-2" {
+		t.Errorf("replica store in %q, want replica_region", replicaRegion)
+	}
+	if warnings := out.String(); strings.Count(warnings, "reading from the replica s3://tfvars-dr") != 1 || strings.Contains(warnings, "behind") {
+		t.Errorf("download warned:\n%s", warnings)
+	}
+
+	// the replica is behind the last upload
+	os.WriteFile("dev.tfvars", []byte("x = 3\n"), 0o644)
+	primary.GetErr, primary.HeadErr, primary.PutErr = nil, nil, nil
+	if err := run([]string{"upload", "dev", "--allow-dirty"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	primary.GetErr, primary.HeadErr, primary.PutErr = outage, outage, outage
+	out.Reset()
+	if err := runWithUI([]string{"download", "dev", "--force"}, &ui{stdout: &out, stderr: io.Discard}); err != nil || !strings.Contains(out.String(), "replication may be behind") {
+		t.Errorf("download of a stale replica = %v, warned:\n%s", err, out.String())
+	}
+
+	puts := replica.Puts()
+	err := run([]string{"upload", "dev", "--allow-dirty", "--force"})
+	if !errors.Is(err, storage.ErrPrimaryOnly) || exitCodeFor(err) != exitTransfer || replica.Puts() != puts {
+		t.Errorf("upload in an outage = %v, want ErrPrimaryOnly and nothing written to the replica", err)
+	}
+}
+
+func TestReplicaNeedsBucket(t *testing.T) {
+	inTempDir(t)
+	withSSMStore(t)
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dev:\n    tfvars: dev.tfvars\n    location: ssm:///team/dev\n    replica_bucket: tfvars-dr\n"), 0o644)
+	if err := run([]string{"download", "dev"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("download with a replica of a parameter = %v, want a config error", err)
+	}
+}
+
+func TestFileLocation(t *testing.T) {
+	inTempDir(t)
+	bucket := withMemoryStore(t)
+	// no bucket, region or credentials, a local directory needs none of them
+	for _, name := range []string{"S3_BUCKET", "AWS_REGION", "AWS_PROFILE"} {
+		t.Setenv(name, "")
+	}
+	dir := t.TempDir()
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  dev:\n    tfvars: envs/dev.tfvars\n    location: file://"+dir+"\n"), 0o644)
+	os.MkdirAll("envs", 0o755)
+	for i := range 2 {
+		os.WriteFile("envs/dev.tfvars", []byte(fmt.Sprintf("revision = %d\n", i)), 0o644)
+		if err := run([]string{"upload", "dev", "--allow-dirty"}); err != nil {
+			t.Fatalf("upload #%d: %v", i, err)
+		}
+	}
+	if got, err := os.ReadFile(dir + "/envs/dev.tfvars"); err != nil || string(got) != "revision = 1\n" {
+		t.Errorf("stored file = %q, %v", got, err)
+	}
+	if bucket.Puts() != 0 {
+		t.Errorf("%d puts to the bucket, want none", bucket.Puts())
+	}
+
+	os.Remove("envs/dev.tfvars")
+	if err := run([]string{"download", "dev"}); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if got, _ := os.ReadFile("envs/dev.tfvars"); string(got) != "revision = 1\n" {
+		t.Errorf("downloaded %q", got)
+	}
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"--output", "json", "versions", "dev"}, &ui{json: true, stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("versions: %v", err)
+	}
+	if n := strings.Count(stdout.String(), `"event":"version"`); n != 2 {
+		t.Errorf("versions listed %d versions, want 2:\n%s", n, stdout.String())
+	}
+}
+
+func TestKMSKeyPerEnvironment(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	const global, prodKey = "arn:aws:kms:us-east-1:123456789012:key/global", "arn:aws:kms:us-east-1:123456789012:key/prod"
+	config := "kms_key_arn: %s\nenvironments:\n  prod:\n    tfvars: prod.tfvars\n    kms_key_arn: %s\n  qa:\n    tfvars: qa.tfvars\n"
+	os.WriteFile("tfmanage.yaml", []byte(fmt.Sprintf(config, global, prodKey)), 0o644)
+	os.WriteFile("prod.tfvars", []byte("a = 1\n"), 0o644)
+	os.WriteFile("qa.tfvars", []byte("a = 2\n"), 0o644)
+
+	for env, want := range map[string]string{"prod": prodKey, "qa": global} {
+		if err := run([]string{"upload", env}); err != nil {
+			t.Fatalf("upload %s: %v", env, err)
+		}
+		if head, err := store.Head(context.Background(), "team/"+env+".tfvars"); err != nil || head.KMSKeyID != want {
+			t.Errorf("%s was encrypted with %q (%v), want %q", env, head.KMSKeyID, err, want)
+		}
+	}
+
+	// qa moves to its own key, its tfvars are still under the global one
+	os.WriteFile("tfmanage.yaml", []byte(fmt.Sprintf(config+"    kms_key_arn: %s\n", global, prodKey, "qa-key")), 0o644)
+	os.Remove("qa.tfvars")
+	var out bytes.Buffer
+	if err := runWithUI([]string{"download", "qa"}, &ui{stdout: &out, stderr: &out}); err != nil {
+		t.Fatalf("download qa: %v", err)
+	}
+	if !strings.Contains(out.String(), "encrypted with KMS key "+global+", not the environment's key qa-key") {
+		t.Errorf("download with another key printed %q", out.String())
+	}
+
+	store.GetErr = fmt.Errorf("get: %w", storage.ErrAccessDenied)
+	os.Remove("prod.tfvars")
+	err := run([]string{"download", "prod"})
+	if exitCodeFor(err) != exitCredentials || !strings.Contains(err.Error(), "KMS key "+prodKey) {
+		t.Errorf("download without kms:Decrypt: %v, want a credentials error naming the key", err)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	inTempDir(t)
+	store := withMemoryStore(t)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+	for i := range 3 {
+		os.WriteFile("dev.tfvars", []byte(fmt.Sprintf("revision = %d\n", i)), 0o644)
+		if err := run([]string{"upload", "dev"}); err != nil {
+			t.Fatalf("upload #%d: %v", i, err)
+		}
+	}
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"--output", "json", "versions", "dev"}, &ui{json: true, stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("versions: %v", err)
+	}
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var event struct {
+			Event     string `json:"event"`
+			VersionID string `json:"version_id"`
+		}
+		if json.Unmarshal([]byte(line), &event); event.Event == "version" {
+			ids = append(ids, event.VersionID)
+		}
+	}
+	// the memory store numbers every put, the change journal's entries come in between
+	if strings.Join(ids, ",") != "v5,v3,v1" {
+		t.Errorf("versions = %v, want v5,v3,v1", ids)
+	}
+
+	store.VersionsErr = storage.ErrUnsupported
+	if err := run([]string{"versions", "dev"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("versions on a backend without them: %v, want a config error", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/buildinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tracing"
+)
+
+// tracing - with OTEL_EXPORTER_OTLP_ENDPOINT set the whole run is a root span, under the TRACEPARENT of the pipeline when there is one, with the config load, the transfers, the AWS calls and terraform as child spans
+
+// startTrace starts the root span of the command, finish ends it and sends the spans. Without an endpoint ctx comes back as it is and finish does nothing
+
+func (a *app) startTrace(ctx context.Context, command string) (context.Context, func(error)) {
+	tracer := tracing.FromEnv(buildinfo.Get().Version)
+	if tracer == nil {
+		return ctx, func(error) {}
+	}
+	ctx, span := tracing.Start(tracing.WithTracer(ctx, tracer), "tfmanage "+command, tracing.String("tfmanage.command", command))
+	a.trace = ctx
+	return ctx, func(err error) {
+		if a.environment != "" {
+			span.SetAttributes(tracing.String("tfmanage.environment", a.environment))
+		}
+		span.SetAttributes(tracing.Int("tfmanage.exit_code", int64(exitCodeFor(err))))
+		span.End(err)
+
+		// a cancelled run is still worth seeing in the trace
+		flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if err := tracer.Flush(flushCtx); err != nil {
+			a.out.Warnf("Could not send the trace: %v", err)
+		}
+	}
+}
+
+// traceContext is the context of the root span, for work like loading the config that isn't handed one
+
+func (a *app) traceContext() context.Context {
+	if a.trace == nil {
+		return context.Background()
+	}
+	return a.trace
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestTraceRun(t *testing.T) {
+	var names []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		for _, s := range payload.ResourceSpans[0].ScopeSpans[0].Spans {
+			names = append(names, s.Name)
+		}
+	}))
+	defer srv.Close()
+	inTempDir(t)
+	withMemoryStore(t)
+	os.WriteFile("dev.tfvars", []byte("a = 1\n"), 0o644)
+	t.Setenv("DEV_TFVARS", "dev.tfvars")
+
+	// nothing is sent without an endpoint
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if err := run([]string{"upload", "dev"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("sent spans %q without an endpoint", names)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL)
+	if err := run([]string{"upload", "dev", "--force"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	for _, want := range []string{"tfmanage upload", "config.load", "storage.upload"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("sent spans %q, want %s", names, want)
+		}
+	}
+}
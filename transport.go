@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/ghactions"
+)
+
+// how the AWS requests get out - through --proxy-url or the proxy HTTPS_PROXY, HTTP_PROXY and NO_PROXY give, trusting --ca-bundle or AWS_CA_BUNDLE on top of the system's CAs, within the --http-timeout, --connect-timeout and --tls-handshake-timeout bounds. Terraform gets the same variables so its providers go the same way. A proxy's credentials are never shown
+
+// useTransport says which proxy and timeouts the AWS requests use and makes sure the passwords of the proxies are masked in terraform's output
+
+func (a *app) useTransport(s settings) {
+	proxies := []string{s.AWSConfig.ProxyURL}
+	for _, name := range awsconfig.ProxyVars {
+		proxies = append(proxies, os.Getenv(name))
+	}
+	for _, proxy := range proxies {
+		if password := awsconfig.ProxyPassword(proxy); password != "" {
+			a.secretValues = append(a.secretValues, password)
+		}
+	}
+	a.out.Verbosef("AWS request timeouts: %s, up to %d idle connections per endpoint\n", s.AWSConfig.Timeouts.WithDefaults(), awsconfig.MaxIdleConnsPerHost)
+	if s.AWSConfig.ProxyURL != "" {
+		a.out.Verbosef("Sending the AWS requests and terraform's through %s\n", awsconfig.RedactProxyURL(s.AWSConfig.ProxyURL))
+	}
+}
+
+// warnInsecure warns on every run that --insecure-skip-verify is on, on stderr and as an annotation so it can't be left in a pipeline unnoticed
+
+func (a *app) warnInsecure(s settings) {
+	if !s.AWSConfig.InsecureSkipVerify {
+		return
+	}
+	msg := "--insecure-skip-verify is on: the TLS certificates of the AWS requests aren't checked, so anyone between here and the endpoint can read and change them, credentials included. Use --ca-bundle for an internal CA instead"
+	fmt.Fprintln(a.out.stderr, a.out.paint(ansiBold+ansiRed, "WARNING: "+msg))
+	if a.out.github {
+		ghactions.Annotate(a.out.humanOut(), "warning", msg)
+	}
+	a.out.Event("insecure-skip-verify", map[string]any{})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/awsconfig"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/config"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/vault"
+)
+
+// vault_vars - the same as secret_vars for secrets kept in Vault's KV engine. Nothing talks to Vault unless an environment has some
+
+// checkVaultVarsConfig checks the vault_vars of the config and of every environment, a name can't come from both Secrets Manager and Vault
+
+func checkVaultVarsConfig(s settings) error {
+	check := func(where string, vars map[string]config.VaultVar, secrets map[string]config.SecretVar) error {
+		for _, name := range slices.Sorted(maps.Keys(vars)) {
+			v := vars[name]
+			switch {
+			case !terraformVarName.MatchString(name):
+				return configError("%s: %q is not a valid terraform variable name", where, name)
+			case v.Path == "" || v.Field == "":
+				return configError("%s: %s needs a path and a field", where, name)
+			case v.KVVersion != 0 && v.KVVersion != 1 && v.KVVersion != 2:
+				return configError("%s: kv_version of %s must be 1 or 2", where, name)
+			case v.Version < 0 || (v.Version > 0 && v.KVVersion == 1):
+				return configError("%s: version of %s can only pin a version of a KV version 2 secret", where, name)
+			}
+			if _, ok := secrets[name]; ok {
+				return configError("%s: %s is in secret_vars too, it can only come from one of them", where, name)
+			}
+		}
+		return nil
+	}
+	if err := check("vault_vars", s.VaultVars, s.SecretVars); err != nil {
+		return err
+	}
+	for _, env := range slices.Sorted(maps.Keys(s.Terraform)) {
+		if err := check("vault_vars of "+env, vaultVarsFor(s, env), secretVarsFor(s, env)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vaultVarsFor gives the environment's Vault variables, its own on top of the global ones
+
+func vaultVarsFor(s settings, environment string) map[string]config.VaultVar {
+	vars := map[string]config.VaultVar{}
+	maps.Copy(vars, s.VaultVars)
+	maps.Copy(vars, s.Terraform[environment].VaultVars)
+	return vars
+}
+
+// newVaultClient logs in to Vault with VAULT_TOKEN, or with the AWS auth method and the tool's AWS credentials
+
+func newVaultClient(ctx context.Context, s settings) (*vault.Client, error) {
+	if s.Vault.Address == "" {
+		return nil, configError("vault_vars are set but there is no Vault to read them from, set VAULT_ADDR or vault.address")
+	}
+	c := &vault.Client{Address: s.Vault.Address, Namespace: s.Vault.Namespace, Token: os.Getenv("VAULT_TOKEN")}
+	if c.Token != "" {
+		return c, nil
+	}
+	if s.Vault.AWSRole == "" {
+		return nil, configError("vault_vars are set but there is no way to log in to Vault, set VAULT_TOKEN or vault.aws_role")
+	}
+	cfg, err := awsconfig.Load(ctx, s.AWSConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.LoginAWS(ctx, cfg, s.Vault.AWSMount, s.Vault.AWSRole, s.Vault.AWSServerID); err != nil {
+		return nil, fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+	return c, nil
+}
+
+// readVaultVars reads the values of the vault_vars
+
+func readVaultVars(ctx context.Context, s settings, vars map[string]config.VaultVar) (map[string]string, error) {
+	c, err := newVaultClient(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		v := vars[name]
+		kv := vault.KV{Mount: cmp.Or(v.Mount, "secret"), Path: v.Path, Field: v.Field, Version: v.Version, V1: v.KVVersion == 1}
+		if values[name], err = c.ReadKV(ctx, kv); err != nil {
+			return nil, fmt.Errorf("failed to read the %s variable from Vault: %w", name, err)
+		}
+	}
+	return values, nil
+}
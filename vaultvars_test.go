@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestVaultVars(t *testing.T) {
+	rec := &tfexec.RecordingRunner{}
+	useRunner(t, rec)
+	inTempDir(t)
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+		if r.Header.Get("X-Vault-Token") != "s.valid" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"password":"vault-hunter22"}}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "s.valid")
+	os.WriteFile("prod.tfvars", nil, 0o644)
+	t.Setenv("PROD_TFVARS", "prod.tfvars")
+
+	// without any vault_vars Vault is never called, whatever the env says
+	if err := run([]string{"plan", "prod", "plan.out"}); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(requests) != 0 || slices.ContainsFunc(rec.Calls[0].Opts.Env, func(v string) bool { return strings.HasPrefix(v, "TF_VAR_") }) {
+		t.Errorf("plan without vault_vars called Vault %q with env %q", requests, rec.Calls[0].Opts.Env)
+	}
+
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    vault_vars:\n      db_password:\n        mount: kv\n        path: app/prod/db\n        field: password\n        version: 7\n"), 0o644)
+	if err := run([]string{"plan", "prod", "plan.out"}); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if !slices.Equal(requests, []string{"/v1/kv/data/app/prod/db?version=7"}) {
+		t.Errorf("vault requests = %q", requests)
+	}
+	if env := rec.Calls[1].Opts.Env; !slices.Contains(env, "TF_VAR_db_password=vault-hunter22") {
+		t.Errorf("terraform env = %q", env)
+	}
+
+	t.Setenv("VAULT_TOKEN", "s.revoked")
+	err := runWithUI([]string{"apply", "prod"}, &ui{stdout: io.Discard, stderr: io.Discard})
+	msg := fmt.Sprint(err)
+	if exitCodeFor(err) != exitCredentials || !strings.Contains(msg, "kv/app/prod/db?version=7#password") || !strings.Contains(msg, "db_password") || strings.Contains(msg, "hunter") {
+		t.Errorf("apply with a token that can't read the secret: %v", err)
+	}
+	if len(rec.Calls) != 2 {
+		t.Errorf("terraform ran without its secrets: %q", rec.Args())
+	}
+
+	os.WriteFile("tfmanage.yaml", []byte("secret_vars:\n  db_password: prod/db\nvault_vars:\n  db_password:\n    path: app/prod/db\n    field: password\n"), 0o644)
+	if err := run([]string{"plan", "prod", "plan.out"}); exitCodeFor(err) != exitConfig {
+		t.Errorf("a variable from both Secrets Manager and Vault: %v", err)
+	}
+	t.Setenv("VAULT_TOKEN", "")
+	os.WriteFile("tfmanage.yaml", []byte("vault_vars:\n  db_password:\n    path: app/prod/db\n    field: password\n"), 0o644)
+	if err := run([]string{"plan", "prod", "plan.out"}); exitCodeFor(err) != exitConfig || !strings.Contains(fmt.Sprint(err), "VAULT_TOKEN") {
+		t.Errorf("vault_vars without a token or aws_role: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+)
+
+// versions - the stored versions of an environment's tfvars, from whichever backend its location picks
+
+func versionsCommand() *command {
+	return &command{
+		name:    "versions",
+		args:    "<env>",
+		summary: "List the stored versions of the environment's tfvars, newest first.",
+		examples: []string{
+			"tfmanage versions prod",
+			"tfmanage versions staging --output json",
+		},
+		minArgs: 1,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(ctx context.Context, a *app, args []string) error {
+				fileName, err := a.tfvarsFor(args[0])
+				if err != nil {
+					return err
+				}
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				if err := requirementsError("versions", checkStoreRequirements("download", args[0], s)); err != nil {
+					return err
+				}
+				loc, err := a.locate(ctx, s, args[0], fileName)
+				if err != nil {
+					return err
+				}
+				versions, err := loc.store.Versions(ctx, loc.key)
+				if err != nil {
+					return err
+				}
+
+				var rows [][]string
+				for i, v := range versions {
+					a.out.Event("version", map[string]any{"environment": args[0], "key": v.Key, "version_id": v.VersionID, "last_modified": v.LastModified, "size": v.Size, "latest": i == 0, "metadata": v.Metadata, "message": decodeMetadataValue(v.Metadata[changeMessageMetadataKey])})
+					rows = append(rows, []string{v.VersionID, v.LastModified.Format(time.RFC3339), sizeOrBlank(v.Size), describeMetadata(v.Metadata)})
+				}
+				if a.out.json {
+					return nil
+				}
+				a.out.Printf("%s\n", loc.url(loc.key))
+				a.out.Table(a.out.humanOut(), []string{"VERSION", "MODIFIED", "SIZE", "DETAIL"}, rows, nil)
+				return nil
+			}
+		},
+	}
+}
+
+// sizeOrBlank leaves the size out for backends that don't report it without reading every version
+
+func sizeOrBlank(size int64) string {
+	if size == 0 {
+		return ""
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+// describeMetadata is the metadata as sorted key=value pairs, without the checksum which nobody reads. The change message is quoted since it has spaces in it
+
+func describeMetadata(metadata map[string]string) string {
+	var pairs []string
+	for k, v := range metadata {
+		switch k {
+		case storage.ChecksumMetadataKey:
+		case changeMessageMetadataKey:
+			pairs = append(pairs, k+"="+strconv.Quote(decodeMetadataValue(v)))
+		default:
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
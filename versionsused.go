@@ -0,0 +1,206 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/gitinfo"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// apply records - every successful apply writes down the terraform and provider versions it ran with, so "what was that applied with?" has an answer after an incident. versions-used reads them back
+
+// applyRecordPrefix is where the records go under S3_PATH, one folder per environment
+
+const applyRecordPrefix = "applies"
+
+// applyRecord is stored as applies/<env>/<timestamp>.json
+
+type applyRecord struct {
+	Environment      string                  `json:"environment"`
+	AppliedAt        time.Time               `json:"applied_at"`
+	Commit           string                  `json:"commit,omitempty"`
+	TerraformVersion string                  `json:"terraform_version,omitempty"`
+	Providers        []tfexec.LockedProvider `json:"providers,omitempty"`
+	// PlanKey is the stored plan that was applied, empty when the apply planned as it went
+	PlanKey string `json:"plan_key,omitempty"`
+}
+
+func applyRecordKey(s settings, environment string, appliedAt time.Time) string {
+	return storage.Key(s.S3Path, path.Join(applyRecordPrefix, environment, appliedAt.UTC().Format("20060102T150405Z")+".json"))
+}
+
+// providerList is the providers the way people say them, hashicorp/aws 5.31.0. One only pinned by a constraint shows the constraint
+
+func providerList(providers []tfexec.LockedProvider) string {
+	var parts []string
+	for _, p := range providers {
+		if p.Version != "" {
+			parts = append(parts, p.ShortSource()+" "+p.Version)
+		} else {
+			parts = append(parts, p.ShortSource()+" ("+p.Constraints+")")
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// recordApply notes the terraform and provider versions a successful apply ran with, in the apply record in the bucket and in the sidecar of the stored plan it applied. The apply has already happened, so nothing here fails it
+
+func recordApply(ctx context.Context, a *app, steps applySteps, chdir string) {
+	record := applyRecord{Environment: steps.env, AppliedAt: time.Now().UTC(), Commit: gitinfo.Commit(ctx), PlanKey: steps.planKey}
+	if version, err := tfexec.TerraformVersion(ctx, runner, a.terraformOutput()); err != nil {
+		a.out.Warnf("Could not get the terraform version the apply ran with: %v", err)
+	} else {
+		record.TerraformVersion = version.String()
+	}
+	providers, err := tfexec.ReadLockFile(cmp.Or(chdir, "."))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		a.out.Verbosef("There is no %s, the provider versions aren't recorded\n", tfexec.LockFileName)
+	case err != nil:
+		a.out.Warnf("Could not read the provider versions: %v", err)
+	default:
+		record.Providers = providers
+	}
+	a.out.Event("apply-versions", map[string]any{"environment": record.Environment, "commit": record.Commit, "terraform_version": record.TerraformVersion, "providers": record.Providers, "plan_key": record.PlanKey})
+	if record.TerraformVersion != "" {
+		a.out.Printf("Applied with terraform %s\n", record.TerraformVersion)
+	}
+	if len(record.Providers) > 0 {
+		a.out.Printf("Providers: %s\n", providerList(record.Providers))
+	}
+
+	s, err := a.loadSettings()
+	if err != nil || s.S3Bucket == "" {
+		a.out.Verbosef("S3_BUCKET isn't set, the apply record isn't stored\n")
+		return
+	}
+	store, err := newStore(ctx, s)
+	if err != nil {
+		a.out.Warnf("Could not store the apply record: %v", err)
+		return
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		a.out.Warnf("Could not store the apply record: %v", err)
+		return
+	}
+	kmsKey, _ := kmsKeyFor(s, steps.env)
+	key := applyRecordKey(s, steps.env, record.AppliedAt)
+	if _, err := storage.PutBytesEncrypted(ctx, store, key, data, kmsKey); err != nil {
+		a.out.Warnf("Could not store the apply record: %v", err)
+		return
+	}
+	a.out.Verbosef("Stored the apply record as s3://%s/%s\n", s.S3Bucket, key)
+
+	if steps.planKey == "" {
+		return
+	}
+	artifact := readArtifact(ctx, store, steps.planKey)
+	artifact.AppliedAt, artifact.AppliedTerraformVersion, artifact.AppliedProviders = record.AppliedAt, record.TerraformVersion, record.Providers
+	sidecar, err := json.MarshalIndent(artifact, "", "  ")
+	if err == nil {
+		_, err = storage.PutBytesEncrypted(ctx, store, sidecarKey(steps.planKey), sidecar, artifact.KMSKeyARN)
+	}
+	if err != nil {
+		a.out.Warnf("Could not record the versions in the plan's metadata: %v", err)
+	}
+}
+
+// latestApplyRecord is the newest record of the environment, false when it has none
+
+func latestApplyRecord(ctx context.Context, s settings, store storage.Backend, environment string) (applyRecord, bool, error) {
+	objects, err := store.List(ctx, storage.Key(s.S3Path, applyRecordPrefix+"/"+environment+"/"))
+	if err != nil {
+		return applyRecord{}, false, err
+	}
+	latest := ""
+	for _, o := range objects {
+		if strings.HasSuffix(o.Key, ".json") && o.Key > latest {
+			latest = o.Key
+		}
+	}
+	if latest == "" {
+		return applyRecord{}, false, nil
+	}
+	var kmsKey string
+	if info, err := store.Head(ctx, latest); err == nil {
+		kmsKey = info.KMSKeyID
+	}
+	data, err := storage.GetBytes(ctx, store, latest)
+	if err != nil {
+		return applyRecord{}, false, kmsDecryptError(err, kmsKey)
+	}
+	var record applyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return applyRecord{}, false, configError("the apply record s3://%s/%s is broken: %v", s.S3Bucket, latest, err)
+	}
+	return record, true, nil
+}
+
+func versionsUsedCommand() *command {
+	return &command{
+		name:    "versions-used",
+		args:    "[env]",
+		summary: "Show the terraform and provider versions of the last apply of each environment.",
+		examples: []string{
+			"tfmanage versions-used",
+			"tfmanage versions-used prod --output json",
+		},
+		minArgs: 0,
+		maxArgs: 1,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(ctx context.Context, a *app, args []string) error {
+				s, err := a.loadSettings()
+				if err != nil {
+					return err
+				}
+				environments := environmentNames(s)
+				if len(args) == 1 {
+					if err := a.checkEnvironment(args[0]); err != nil {
+						return err
+					}
+					environments = args
+				}
+				if err := requirementsError("apply records", checkRequirements("apply records", "", s)); err != nil {
+					return err
+				}
+				store, err := newStore(ctx, s)
+				if err != nil {
+					return err
+				}
+
+				var rows [][]string
+				for _, environment := range environments {
+					record, ok, err := latestApplyRecord(ctx, s, store, environment)
+					if err != nil {
+						return err
+					}
+					if !ok {
+						rows = append(rows, []string{environment, "never", "", "", ""})
+						continue
+					}
+					a.out.Event("versions-used", map[string]any{"environment": environment, "applied_at": record.AppliedAt, "commit": record.Commit, "terraform_version": record.TerraformVersion, "providers": record.Providers, "plan_key": record.PlanKey})
+					rows = append(rows, []string{environment, record.AppliedAt.Format(time.RFC3339), gitinfo.Short(record.Commit), cmp.Or(record.TerraformVersion, "unknown"), providerList(record.Providers)})
+				}
+				if a.out.json {
+					return nil
+				}
+				if len(rows) == 0 {
+					a.out.Printf("No environments are configured\n")
+					return nil
+				}
+				a.out.Table(a.out.humanOut(), []string{"ENVIRONMENT", "APPLIED", "COMMIT", "TERRAFORM", "PROVIDERS"}, rows, nil)
+				return nil
+			}
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/storage"
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func TestProviderList(t *testing.T) {
+	got := providerList([]tfexec.LockedProvider{
+		{Source: "registry.terraform.io/hashicorp/aws", Version: "5.31.0"},
+		{Source: "example.com/acme/widgets", Constraints: "~> 1.2"},
+	})
+	if want := "hashicorp/aws 5.31.0, example.com/acme/widgets (~> 1.2)"; got != want {
+		t.Errorf("providerList() = %q, want %q", got, want)
+	}
+}
+
+func TestApplyRecordsVersions(t *testing.T) {
+	_, store := withPlanStore(t)
+	caller := withCaller(t, reviewerARN)
+	storeTestPlan(t, store, "team/plans/prod/p.tfplan", "plan bytes")
+	os.WriteFile(".terraform.lock.hcl", []byte("provider \"registry.terraform.io/hashicorp/aws\" {\n  version     = \"5.31.0\"\n  constraints = \"~> 5.0\"\n  hashes = [\n    \"h1:a=\",\n    \"zh:b\",\n  ]\n}\n"), 0o644)
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"versions-used", "prod"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil || !strings.Contains(stdout.String(), "never") {
+		t.Fatalf("versions-used before any apply: %v, %q", err, stdout.String())
+	}
+
+	if err := run([]string{"approve", "prod", "latest"}); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	*caller = deployerARN
+	if err := run([]string{"apply", "prod", "--plan", "latest", "--require-approval", "--snapshot-state=false"}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	records, _ := store.List(context.Background(), "team/applies/prod/")
+	if len(records) != 1 {
+		t.Fatalf("apply records = %v, want one", records)
+	}
+	if info, _ := store.Head(context.Background(), records[0].Key); info.KMSKeyID != planKMSKey {
+		t.Errorf("the apply record is encrypted with %q, want the environment's key", info.KMSKeyID)
+	}
+	data, _ := storage.GetBytes(context.Background(), store, records[0].Key)
+	var record applyRecord
+	json.Unmarshal(data, &record)
+	if record.TerraformVersion != "1.6.2" || record.PlanKey != "team/plans/prod/p.tfplan" || len(record.Providers) != 1 || record.Providers[0].Version != "5.31.0" || record.Providers[0].Hashes != 2 {
+		t.Errorf("apply record = %+v", record)
+	}
+
+	artifact := readArtifact(context.Background(), store, "team/plans/prod/p.tfplan")
+	if artifact.AppliedAt.IsZero() || artifact.AppliedTerraformVersion != "1.6.2" || len(artifact.AppliedProviders) != 1 || artifact.SHA256 == "" {
+		t.Errorf("sidecar = %+v, want the applied versions next to what was there", artifact)
+	}
+
+	stdout.Reset()
+	if err := runWithUI([]string{"versions-used"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("versions-used: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "1.6.2") || !strings.Contains(out, "hashicorp/aws 5.31.0") || !strings.Contains(out, "0123456") {
+		t.Errorf("versions-used printed %q", out)
+	}
+	if err := run([]string{"versions-used", "nope"}); err == nil {
+		t.Error("versions-used of an unknown environment gave no error")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"slices"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+// workspace - see and create the terraform workspaces of an environment's directory. plan and apply pick the environment's workspace with TF_WORKSPACE, these show what terraform run by hand would use next to it
+
+func workspaceCommand() *command {
+	return &command{
+		name:    "workspace",
+		args:    "list|new <env>",
+		summary: "List the terraform workspaces with the environment's marked, or create and select the environment's workspace.",
+		examples: []string{
+			"tfmanage workspace list prod",
+			"tfmanage workspace new staging --chdir infra",
+		},
+		minArgs: 2,
+		maxArgs: 2,
+		setup: func(fs *flag.FlagSet) runFunc {
+			chdir := fs.String("chdir", "", "run terraform in this directory")
+			return func(ctx context.Context, a *app, args []string) error {
+				if args[0] != "list" && args[0] != "new" {
+					return usageError("unknown workspace subcommand %q, use list or new", args[0])
+				}
+				if err := a.checkEnvironment(args[1]); err != nil {
+					return err
+				}
+				if err := a.useTerraformCredentials(ctx, args[1]); err != nil {
+					return err
+				}
+				dir := a.useEnvironment(args[1], *chdir)
+				if args[0] == "new" {
+					return newWorkspace(ctx, a, args[1], dir)
+				}
+				return listWorkspaces(ctx, a, args[1], dir)
+			}
+		},
+	}
+}
+
+// expectedWorkspace is the workspace the environment runs in, terraform's default when the config doesn't name one
+
+func (a *app) expectedWorkspace() string {
+	return cmp.Or(a.workspace, "default")
+}
+
+// workspaceOutput is terraform's environment without the workspace override, the workspace commands are about the selection TF_WORKSPACE would hide. An empty TF_WORKSPACE counts as unset and wins over one exported in the shell
+
+func (a *app) workspaceOutput() tfexec.RunOptions {
+	run := a.streamOutput()
+	run.Env = append(run.Env, "TF_WORKSPACE=")
+	return run
+}
+
+func listWorkspaces(ctx context.Context, a *app, env, dir string) error {
+	expected := a.expectedWorkspace()
+	a.out.Verbosef("Running terraform %v\n", tfexec.WorkspaceListArgs(dir))
+	names, selected, err := tfexec.Workspaces(ctx, runner, dir, a.workspaceOutput())
+	if err != nil {
+		return err
+	}
+	exists := slices.Contains(names, expected)
+	a.out.Event("workspaces", map[string]any{"environment": env, "workspaces": names, "selected": selected, "expected": expected, "exists": exists})
+
+	for _, name := range names {
+		marker := "  "
+		if name == selected {
+			marker = "* "
+		}
+		line := marker + name
+		if name == expected {
+			line = marker + a.out.green(name) + " (" + env + ")"
+		}
+		a.out.Printf("%s\n", line)
+	}
+	switch {
+	case !exists:
+		a.out.Warnf("%s runs in the %s workspace, which doesn't exist yet, create it with tfmanage workspace new %s", env, expected, env)
+	case selected != expected:
+		a.out.Warnf("terraform run by hand uses %s, tfmanage runs %s in %s", selected, env, expected)
+	}
+	return nil
+}
+
+// newWorkspace creates the environment's workspace and selects it, a workspace that already exists is only selected
+
+func newWorkspace(ctx context.Context, a *app, env, dir string) error {
+	expected := a.expectedWorkspace()
+	run := a.workspaceOutput()
+	a.out.Verbosef("Running terraform %v\n", tfexec.WorkspaceListArgs(dir))
+	names, selected, err := tfexec.Workspaces(ctx, runner, dir, run)
+	if err != nil {
+		return err
+	}
+	event := map[string]any{"environment": env, "workspace": expected, "created": false}
+	switch {
+	case selected == expected:
+		a.out.Event("workspace", event)
+		a.out.Successf("The %s workspace of %s exists and is selected", expected, env)
+		return nil
+	case slices.Contains(names, expected):
+		a.out.Verbosef("Running terraform %v\n", tfexec.WorkspaceSelectArgs(dir, expected))
+		if err := tfexec.WorkspaceSelect(ctx, runner, dir, expected, run); err != nil {
+			return err
+		}
+		a.out.Event("workspace", event)
+		a.out.Successf("The %s workspace of %s already existed, selected it", expected, env)
+		return nil
+	}
+	a.out.Verbosef("Running terraform %v\n", tfexec.WorkspaceNewArgs(dir, expected))
+	if err := tfexec.WorkspaceNew(ctx, runner, dir, expected, run); err != nil {
+		return err
+	}
+	event["created"] = true
+	a.out.Event("workspace", event)
+	a.out.Successf("Created and selected the %s workspace of %s", expected, env)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DrewDrabek/terraform-manage-script-AWS/internal/tfexec"
+)
+
+func withWorkspaces(t *testing.T, list string) *tfexec.RecordingRunner {
+	t.Helper()
+	rec := &tfexec.RecordingRunner{Output: list}
+	useRunner(t, rec)
+	withTFVars(t, "prod")
+	os.WriteFile("tfmanage.yaml", []byte("environments:\n  prod:\n    chdir: infra\n    workspace: production\n"), 0o644)
+	os.MkdirAll("infra/.terraform", 0o755)
+	return rec
+}
+
+func TestWorkspaceList(t *testing.T) {
+	rec := withWorkspaces(t, "* default\n  production\n")
+
+	var stdout bytes.Buffer
+	if err := runWithUI([]string{"workspace", "list", "prod"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil {
+		t.Fatalf("workspace list: %v", err)
+	}
+	for _, want := range []string{"* default\n", "  production (prod)\n", "terraform run by hand uses default, tfmanage runs prod in production"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("output is missing %q:\n%s", want, stdout.String())
+		}
+	}
+	call := rec.Calls[0]
+	if !slices.Equal(call.Args, []string{"-chdir=infra", "workspace", "list"}) || call.Opts.Env[len(call.Opts.Env)-1] != "TF_WORKSPACE=" {
+		t.Errorf("workspace list ran %q with %q", call.Args, call.Opts.Env)
+	}
+
+	// a workspace that isn't there yet says how to create it
+	rec.Output = "* default\n"
+	stdout.Reset()
+	if err := runWithUI([]string{"workspace", "list", "prod"}, &ui{stdout: &stdout, stderr: io.Discard}); err != nil || !strings.Contains(stdout.String(), "tfmanage workspace new prod") {
+		t.Errorf("workspace list = %v:\n%s", err, stdout.String())
+	}
+}
+
+func TestWorkspaceNew(t *testing.T) {
+	rec := withWorkspaces(t, "* default\n")
+	if err := run([]string{"workspace", "new", "prod"}); err != nil {
+		t.Fatalf("workspace new: %v", err)
+	}
+	if got := rec.Args(); len(got) != 2 || !slices.Equal(got[1], []string{"-chdir=infra", "workspace", "new", "production"}) {
+		t.Errorf("terraform ran %q", got)
+	}
+
+	// an existing workspace is only selected, and nothing happens once it is
+	rec.Calls, rec.Output = nil, "* default\n  production\n"
+	if err := run([]string{"workspace", "new", "prod"}); err != nil {
+		t.Fatalf("workspace new: %v", err)
+	}
+	rec.Output = "  default\n* production\n"
+	if err := run([]string{"workspace", "new", "prod"}); err != nil {
+		t.Fatalf("workspace new: %v", err)
+	}
+	if got := rec.Args(); len(got) != 3 || !slices.Equal(got[1], []string{"-chdir=infra", "workspace", "select", "production"}) {
+		t.Errorf("terraform ran %q", got)
+	}
+
+	// before terraform init there is nothing to list
+	os.RemoveAll("infra/.terraform")
+	if err := run([]string{"workspace", "new", "prod"}); !errors.Is(err, tfexec.ErrNotInitialized) || !strings.Contains(hintFor(err), "terraform init") {
+		t.Errorf("workspace new before init: %v", err)
+	}
+}